@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/audit"
+)
+
+const auditUsage = `Usage:
+  ddosctl audit export [-o file]
+  ddosctl audit verify [-key base64 | -key-file file | -fetch-key] [-allow-legacy] file
+
+export downloads every audit entry with its hash chain and signed
+checkpoints. verify checks an export offline: each entry must match its hash
+and link to the one before it, and every checkpoint must be signed by the
+given key, of which there must be at least one. Without a key the key
+embedded in the export is used, which only proves the file is
+self-consistent. Entries without a hash are refused unless -allow-legacy is
+given for a log recorded before hash chaining, since stripping the chain
+leaves an export that looks the same.
+
+Options:
+`
+
+func runAudit(c *client, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, auditUsage)
+		return fmt.Errorf("missing audit command")
+	}
+
+	switch args[0] {
+	case "export":
+		return runAuditExport(c, args[1:])
+	case "verify":
+		return runAuditVerify(c, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, auditUsage)
+		return fmt.Errorf("unknown audit command %q", args[0])
+	}
+}
+
+func runAuditExport(c *client, args []string) error {
+	flags := flag.NewFlagSet("audit export", flag.ExitOnError)
+	output := flags.String("o", "", "write the export to file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, auditUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// Large logs take longer than the usual request timeout to stream
+	c.http.Timeout = 0
+	body, err := c.open("/api/v1/audit/export", nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return fmt.Errorf("export interrupted: %v", err)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s\n", n, *output)
+	}
+	return nil
+}
+
+func runAuditVerify(c *client, args []string) error {
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	keyText := flags.String("key", "", "base64 public key checkpoints must be signed with")
+	keyFile := flags.String("key-file", "", "file holding the base64 public key")
+	fetchKey := flags.Bool("fetch-key", false, "use the public key reported by the server")
+	allowLegacy := flags.Bool("allow-legacy", false, "accept entries recorded before hash chaining")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, auditUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one export file")
+	}
+
+	var pub ed25519.PublicKey
+	switch {
+	case *keyText != "":
+		key, err := audit.DecodePublicKey(*keyText)
+		if err != nil {
+			return err
+		}
+		pub = key
+	case *keyFile != "":
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		if pub, err = audit.DecodePublicKey(string(data)); err != nil {
+			return err
+		}
+	case *fetchKey:
+		var resp struct {
+			PublicKey string `json:"public_key"`
+		}
+		if err := c.get("/api/v1/audit/public-key", nil, &resp); err != nil {
+			return err
+		}
+		key, err := audit.DecodePublicKey(resp.PublicKey)
+		if err != nil {
+			return err
+		}
+		pub = key
+	default:
+		fmt.Fprintln(os.Stderr, "warning: no public key given, using the key embedded in the export")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := audit.Verify(file, pub, audit.VerifyOptions{AllowLegacy: *allowLegacy})
+	printAuditReport(report)
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+func printAuditReport(r *audit.Report) {
+	if r.Entries == 0 {
+		fmt.Println("Entries:       0")
+		return
+	}
+	fmt.Printf("Entries:       %d (IDs %d-%d)\n", r.Entries, r.FirstID, r.LastID)
+	fmt.Printf("Checkpoints:   %d\n", r.Checkpoints)
+	if r.Checkpoints > 0 {
+		fmt.Printf("Signed through entry %d at %s\n", r.SignedThrough, r.SignedAt.Format(time.RFC3339))
+	}
+
+	var notes []string
+	if r.Unsigned > 0 {
+		notes = append(notes, fmt.Sprintf("%d entries after the last checkpoint are chained but not yet signed", r.Unsigned))
+	}
+	if r.Unhashed > 0 {
+		notes = append(notes, fmt.Sprintf("%d entries predate hash chaining and cannot be verified", r.Unhashed))
+	}
+	if !r.Anchored && r.Entries > r.Unhashed {
+		notes = append(notes, "the export does not start at the beginning of the chain")
+	}
+	if len(notes) > 0 {
+		fmt.Println("Notes:\n  " + strings.Join(notes, "\n  "))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ddos-protection/internal/events"
+)
+
+const eventsUsage = `Usage: ddosctl events query [options] [query]
+
+Query terms (combine with spaces; repeat a field to match any value):
+  ip:203.0.113.7  ip:10.0.0.0/8     client IP or CIDR
+  path:/admin  path:/api/*          exact path or prefix
+  code:BLOCKED_IP  reason:"risk"    block code, reason substring
+  tenant:acme  stage:filter  decision:deny  method:POST
+  score>=50  score<80               risk score range
+  since:1h  until:2024-05-01T12:00:00Z
+
+Options:
+`
+
+func runEvents(c *client, args []string) error {
+	if len(args) == 0 || args[0] != "query" {
+		fmt.Fprint(os.Stderr, eventsUsage)
+		return fmt.Errorf("unknown events command")
+	}
+
+	flags := flag.NewFlagSet("events query", flag.ExitOnError)
+	limit := flags.Int("limit", events.DefaultLimit, "events per page")
+	cursor := flags.String("cursor", "", "cursor returned by a previous query")
+	all := flags.Bool("all", false, "follow cursors until every matching event is printed")
+	asJSON := flags.Bool("json", false, "print events as JSON lines")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, eventsUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	query := url.Values{}
+	if q := strings.Join(flags.Args(), " "); q != "" {
+		query.Set("q", q)
+	}
+	query.Set("limit", strconv.Itoa(*limit))
+
+	var w *tabwriter.Writer
+	if !*asJSON {
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tIP\tMETHOD\tPATH\tTENANT\tSTAGE\tCODE\tSCORE\tREASON")
+		defer w.Flush()
+	}
+	enc := json.NewEncoder(os.Stdout)
+
+	next := *cursor
+	for {
+		if next != "" {
+			query.Set("cursor", next)
+		}
+
+		var page events.Page
+		if err := c.get("/api/v1/events", query, &page); err != nil {
+			return err
+		}
+
+		for _, e := range page.Events {
+			if *asJSON {
+				enc.Encode(e)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				e.Time.Format(time.RFC3339), e.IP, e.Method, e.Path, e.Tenant, e.Stage, e.Code, e.Score, e.Reason)
+		}
+
+		next = page.NextCursor
+		if next == "" {
+			return nil
+		}
+		if !*all {
+			if w != nil {
+				w.Flush()
+			}
+			fmt.Fprintf(os.Stderr, "more results: --cursor %s\n", next)
+			return nil
+		}
+	}
+}
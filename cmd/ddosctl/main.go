@@ -0,0 +1,115 @@
+// Command ddosctl is a command-line client for the DDoS protection admin API.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: ddosctl [-server URL] <command> [arguments]
+
+Commands:
+  events query   Search stored security events
+  audit export   Download the audit log in the verifiable export format
+  audit verify   Check an exported audit log has not been altered
+  bundle keygen  Create a key for signing rule bundles
+  bundle sign    Sign a rule bundle for the update channel
+  model train    Learn an anomaly baseline from access logs
+  model eval     Score access logs against an anomaly baseline
+
+Run "ddosctl <command> -h" for command options.
+`
+
+// client calls the admin API
+type client struct {
+	server string
+	http   *http.Client
+}
+
+func main() {
+	server := os.Getenv("DDOSCTL_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+
+	flags := flag.NewFlagSet("ddosctl", flag.ExitOnError)
+	flags.StringVar(&server, "server", server, "admin API base URL (env DDOSCTL_SERVER)")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(server, "/"),
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	var err error
+	switch args[0] {
+	case "events":
+		err = runEvents(c, args[1:])
+	case "audit":
+		err = runAudit(c, args[1:])
+	case "bundle":
+		err = runBundle(args[1:])
+	case "model":
+		err = runModel(args[1:])
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddosctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// get fetches path from the API and decodes the JSON response into out
+func (c *client) get(path string, query url.Values, out interface{}) error {
+	body, err := c.open(path, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(out)
+}
+
+// open fetches path from the API and returns the response body, turning
+// error responses into errors
+func (c *client) open(path string, query url.Values) (io.ReadCloser, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	resp, err := c.http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
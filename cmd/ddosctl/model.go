@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ddos-protection/internal/anomaly"
+)
+
+const modelUsage = `Usage:
+  ddosctl model train -o model.json [-window d] [-min-requests n] [access.log ...]
+  ddosctl model eval -model model.json [-window d] [-min-requests n] [-top n] [access.log ...]
+
+train learns a baseline of normal client traffic from access logs in
+Common or Combined Log Format (stdin without files), recorded while the
+site was not under attack, for protection.anomaly.model_file. An existing
+model given with -o is trained further. eval scores the client windows of
+a log against a model and lists the most anomalous. Use the same window
+and minimum requests as the server.
+
+Options:
+`
+
+// modelFlags are the window settings shared by train and eval
+type modelFlags struct {
+	window      *time.Duration
+	minRequests *int
+}
+
+func newModelFlags(name string) (*flag.FlagSet, modelFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, modelUsage)
+		flags.PrintDefaults()
+	}
+	return flags, modelFlags{
+		window:      flags.Duration("window", time.Minute, "client window length"),
+		minRequests: flags.Int("min-requests", 10, "requests a window needs to count"),
+	}
+}
+
+func runModel(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, modelUsage)
+		return fmt.Errorf("missing model command")
+	}
+
+	switch args[0] {
+	case "train":
+		return runModelTrain(args[1:])
+	case "eval":
+		return runModelEval(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, modelUsage)
+		return fmt.Errorf("unknown model command %q", args[0])
+	}
+}
+
+func runModelTrain(args []string) error {
+	flags, mf := newModelFlags("model train")
+	output := flags.String("o", "", "model file to write")
+	flags.Parse(args)
+
+	if *output == "" {
+		flags.Usage()
+		return fmt.Errorf("missing -o")
+	}
+
+	model, err := anomaly.LoadModel(*output)
+	if os.IsNotExist(err) {
+		model, err = &anomaly.Model{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	before := model.Samples
+
+	replayer := anomaly.NewReplayer(*mf.window, *mf.minRequests, func(ip string, f anomaly.Features) {
+		model.Train(f)
+	})
+	requests, err := replayLogs(flags.Args(), replayer)
+	if err != nil {
+		return err
+	}
+	if model.Samples == before {
+		return fmt.Errorf("no client windows with %d or more requests in %d requests", *mf.minRequests, requests)
+	}
+	if err := model.Save(*output); err != nil {
+		return err
+	}
+
+	fmt.Printf("Trained on %d client windows from %d requests (%d in total)\n", model.Samples-before, requests, model.Samples)
+	return nil
+}
+
+func runModelEval(args []string) error {
+	flags, mf := newModelFlags("model eval")
+	modelFile := flags.String("model", "", "model file to evaluate")
+	top := flags.Int("top", 20, "anomalous windows to list")
+	flags.Parse(args)
+
+	if *modelFile == "" {
+		flags.Usage()
+		return fmt.Errorf("missing -model")
+	}
+	model, err := anomaly.LoadModel(*modelFile)
+	if err != nil {
+		return err
+	}
+
+	type scored struct {
+		ip string
+		anomaly.Deviation
+	}
+	var windows, flagged int
+	var worst []scored
+	replayer := anomaly.NewReplayer(*mf.window, *mf.minRequests, func(ip string, f anomaly.Features) {
+		windows++
+		d := model.Score(f)
+		if d.Score > 0 {
+			flagged++
+			worst = append(worst, scored{ip, d})
+		}
+	})
+	requests, err := replayLogs(flags.Args(), replayer)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(worst, func(i, j int) bool { return worst[i].Score > worst[j].Score })
+	if len(worst) > *top {
+		worst = worst[:*top]
+	}
+
+	fmt.Printf("%d requests, %d client windows, %d anomalous", requests, windows, flagged)
+	if windows > 0 {
+		fmt.Printf(" (%.1f%%)", float64(flagged)/float64(windows)*100)
+	}
+	fmt.Println()
+	if len(worst) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "IP\tSCORE\tFEATURE\tVALUE\tZ")
+	for _, s := range worst {
+		fmt.Fprintf(w, "%s\t%.2f\t%s\t%.2f\t%.1f\n", s.ip, s.Score, s.Feature, s.Value, s.Z)
+	}
+	return nil
+}
+
+// replayLogs feeds access logs, or stdin without files, through r and
+// returns how many requests they held. Unparseable lines are skipped.
+func replayLogs(files []string, r *anomaly.Replayer) (int, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	requests := 0
+	for _, name := range files {
+		var in io.Reader = os.Stdin
+		if name != "-" {
+			file, err := os.Open(name)
+			if err != nil {
+				return requests, err
+			}
+			defer file.Close()
+			in = file
+		}
+
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			ip, t, path, err := anomaly.ParseLogLine(scanner.Text())
+			if err != nil {
+				continue
+			}
+			r.Observe(ip, t, path)
+			requests++
+		}
+		if err := scanner.Err(); err != nil {
+			return requests, err
+		}
+	}
+	r.Flush()
+	return requests, nil
+}
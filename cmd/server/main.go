@@ -2,14 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"ddos-protection/internal/apikey"
+	"ddos-protection/internal/audit"
+	"ddos-protection/internal/authcorr"
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/botpolicy"
 	"ddos-protection/internal/config"
+	"ddos-protection/internal/crawler"
 	"ddos-protection/internal/ddos"
+	"ddos-protection/internal/headerfp"
+	"ddos-protection/internal/signals"
+	"ddos-protection/internal/tlsfp"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -30,6 +44,13 @@ func main() {
 	// Set Gin mode
 	gin.SetMode(cfg.Server.Mode)
 
+	// Read replicas only serve stats and events published by enforcing
+	// instances
+	if cfg.Server.Role == "replica" {
+		runReplica(cfg)
+		return
+	}
+
 	// Create DDoS protection service
 	protectionService, err := ddos.NewProtectionService(cfg)
 	if err != nil {
@@ -44,13 +65,14 @@ func main() {
 	router.Use(protectionService.ProtectionMiddleware())
 
 	// Setup routes
-	setupRoutes(router, protectionService)
+	setupRoutes(router, cfg, protectionService)
 
 	// Create HTTP server
 	server := &http.Server{
 		Addr:    cfg.Server.Port,
 		Handler: router,
 	}
+	protectionService.InstrumentServer(server)
 
 	// Start protection service
 	ctx, cancel := context.WithCancel(context.Background())
@@ -60,14 +82,18 @@ func main() {
 		logrus.Fatalf("Failed to start protection service: %v", err)
 	}
 
-	// Start HTTP server
+	// Start HTTP server. Each connection's ClientHello, or over plain HTTP
+	// its header order, is fingerprinted for botnet detection.
 	go func() {
 		logrus.Infof("Starting server on %s", cfg.Server.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := serve(server, cfg.Server.TLS); err != nil && err != http.ErrServerClosed {
 			logrus.Fatalf("Server error: %v", err)
 		}
 	}()
 
+	// Reload the config file on SIGHUP
+	go reloadOnHangup(ctx, cfgPath, protectionService)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -79,20 +105,69 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
 
+	// Stop accepting connections and let in-flight requests finish before
+	// the protection service tears down the backends they use
+	drainTimeout := cfg.Server.Shutdown.DrainTimeout.Duration()
+	if drainTimeout <= 0 {
+		drainTimeout = 15 * time.Second
+	}
+	drainCtx, drainCancel := context.WithTimeout(shutdownCtx, drainTimeout)
+	defer drainCancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		logrus.Errorf("Server forced to shutdown: %v", err)
+	}
+
 	// Stop protection service
 	if err := protectionService.Stop(shutdownCtx); err != nil {
 		logrus.Errorf("Error stopping protection service: %v", err)
 	}
 
-	// Stop HTTP server
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		logrus.Errorf("Server forced to shutdown: %v", err)
+	logrus.Info("Server exited")
+}
+
+// reloadOnHangup applies the hot-reloadable parts of the config file each
+// time the process receives SIGHUP
+func reloadOnHangup(ctx context.Context, cfgPath string, protectionService *ddos.ProtectionService) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+			cfg, err := config.LoadConfig(cfgPath)
+			if err == nil {
+				err = protectionService.ReloadConfig(ctx, cfg)
+			}
+			if err != nil {
+				logrus.Errorf("Config reload failed, keeping the running config: %v", err)
+				continue
+			}
+			logrus.Infof("Reloaded %s", cfgPath)
+		case <-ctx.Done():
+			return
+		}
 	}
+}
 
-	logrus.Info("Server exited")
+// serve serves plain HTTP with header fingerprinting, or HTTPS with TLS
+// fingerprinting when a certificate is configured
+func serve(server *http.Server, tlsConfig config.TLSConfig) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig.CertFile == "" {
+		server.ConnContext = headerfp.ConnContext
+		return server.Serve(headerfp.NewListener(listener))
+	}
+
+	server.ConnContext = tlsfp.ConnContext
+	return server.ServeTLS(tlsfp.NewListener(listener), tlsConfig.CertFile, tlsConfig.KeyFile)
 }
 
-func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService) {
+func setupRoutes(router *gin.Engine, cfg *config.Config, protectionService *ddos.ProtectionService) {
 	// Health check endpoints
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -115,8 +190,25 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 		c.JSON(httpStatus, status)
 	})
 
+	// Public status page; unauthenticated and sanitized for customers
+	if cfg.StatusPage.Enabled {
+		router.GET("/status-page", func(c *gin.Context) {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Header("Cache-Control", "public, max-age=10")
+			if err := ddos.RenderStatusPage(c.Writer, protectionService.GetPublicStatus(c.Request.Context())); err != nil {
+				logrus.Errorf("Failed to render status page: %v", err)
+			}
+		})
+
+		router.GET("/status-page.json", func(c *gin.Context) {
+			c.Header("Cache-Control", "public, max-age=10")
+			c.JSON(http.StatusOK, protectionService.GetPublicStatus(c.Request.Context()))
+		})
+	}
+
 	// API endpoints
 	api := router.Group("/api/v1")
+	api.Use(auditActor)
 	{
 		// Protected endpoints (these go through DDoS protection)
 		api.GET("/status", func(c *gin.Context) {
@@ -132,13 +224,49 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 			c.JSON(http.StatusOK, stats)
 		})
 
+		api.GET("/forecast", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"tenants": protectionService.GetForecasts()})
+		})
+
+		api.GET("/events", func(c *gin.Context) {
+			limit, _ := strconv.Atoi(c.Query("limit"))
+			page, err := protectionService.SearchEvents(c.Query("q"), c.Query("cursor"), limit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, page)
+		})
+
+		api.GET("/pipeline", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"stages":    protectionService.Pipeline().Stages(),
+				"deadlines": protectionService.Pipeline().Deadlines(),
+			})
+		})
+
+		api.GET("/config/effective", func(c *gin.Context) {
+			path := c.Query("path")
+			if !strings.HasPrefix(path, "/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "path must start with /"})
+				return
+			}
+
+			c.JSON(http.StatusOK, protectionService.EffectiveConfig(c.Query("tenant"), path))
+		})
+
 		// IP management endpoints
 		ip := api.Group("/ip")
+		ip.Use(listTenant(protectionService))
 		{
 			ip.POST("/blacklist", func(c *gin.Context) {
 				var req struct {
 					IP       string        `json:"ip" binding:"required"`
 					Duration time.Duration `json:"duration"`
+					Reason   string        `json:"reason"`
+					Source   string        `json:"source"`
+					Feed     string        `json:"feed"`
 				}
 				
 				if err := c.ShouldBindJSON(&req); err != nil {
@@ -146,12 +274,32 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 					return
 				}
 
+				if !blacklist.IsValidIPOrCIDR(req.IP) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IP address or CIDR"})
+					return
+				}
+
 				duration := req.Duration
 				if duration == 0 {
 					duration = time.Hour // Default duration
 				}
 
-				if err := protectionService.BlacklistIP(c.Request.Context(), req.IP, duration); err != nil {
+				// Entries are manual unless pushed by a feed importer
+				origin := blacklist.Origin{Source: blacklist.SourceManual, Reason: req.Reason}
+				switch blacklist.Source(req.Source) {
+				case "", blacklist.SourceManual:
+				case blacklist.SourceFeed:
+					if req.Feed == "" {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "feed name is required for feed entries"})
+						return
+					}
+					origin.Source, origin.Feed = blacklist.SourceFeed, req.Feed
+				default:
+					c.JSON(http.StatusBadRequest, gin.H{"error": "source must be manual or feed"})
+					return
+				}
+
+				if err := protectionService.BlacklistIP(c.Request.Context(), req.IP, duration, origin); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return
 				}
@@ -159,8 +307,9 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 				c.JSON(http.StatusOK, gin.H{"message": "IP blacklisted successfully"})
 			})
 
-			ip.DELETE("/blacklist/:ip", func(c *gin.Context) {
-				ip := c.Param("ip")
+			ip.DELETE("/blacklist/*ip", func(c *gin.Context) {
+				// Wildcard so CIDR ranges like 2001:db8::/64 can be removed
+				ip := strings.TrimPrefix(c.Param("ip"), "/")
 				
 				if err := protectionService.RemoveFromBlacklist(c.Request.Context(), ip); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -172,7 +321,8 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 
 			ip.POST("/whitelist", func(c *gin.Context) {
 				var req struct {
-					IP string `json:"ip" binding:"required"`
+					IP       string        `json:"ip" binding:"required"`
+					Duration time.Duration `json:"duration"` // zero for a permanent entry
 				}
 				
 				if err := c.ShouldBindJSON(&req); err != nil {
@@ -180,7 +330,17 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 					return
 				}
 
-				if err := protectionService.WhitelistIP(c.Request.Context(), req.IP); err != nil {
+				if !blacklist.IsValidIP(req.IP) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IP address"})
+					return
+				}
+
+				if req.Duration < 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "duration must not be negative"})
+					return
+				}
+
+				if err := protectionService.WhitelistIP(c.Request.Context(), req.IP, req.Duration); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return
 				}
@@ -200,13 +360,82 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 			})
 
 			ip.GET("/blacklist", func(c *gin.Context) {
-				blacklisted := protectionService.GetBlacklistedIPs()
-				c.JSON(http.StatusOK, gin.H{"blacklisted": blacklisted})
+				q, err := blacklist.ParseListQuery(c.Request.URL.Query(), time.Now())
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, protectionService.ListBlacklistAnnotated(q))
 			})
 
 			ip.GET("/whitelist", func(c *gin.Context) {
-				whitelisted := protectionService.GetWhitelistedIPs()
-				c.JSON(http.StatusOK, gin.H{"whitelisted": whitelisted})
+				q, err := blacklist.ParseListQuery(c.Request.URL.Query(), time.Now())
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, protectionService.ListWhitelist(q))
+			})
+
+			ip.GET("/:ip/reputation", func(c *gin.Context) {
+				addr := c.Param("ip")
+				if !blacklist.IsValidIP(addr) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+					return
+				}
+
+				report, err := protectionService.GetReputation(addr)
+				if err != nil {
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, report)
+			})
+
+			ip.GET("/greylist", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"greylisted": protectionService.GetGreylist()})
+			})
+
+			ip.POST("/greylist", func(c *gin.Context) {
+				var req struct {
+					IP     string `json:"ip" binding:"required"`
+					Reason string `json:"reason"`
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if !blacklist.IsValidIP(req.IP) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IP address"})
+					return
+				}
+
+				if req.Reason == "" {
+					req.Reason = "manual"
+				}
+
+				if err := protectionService.GreylistIP(c.Request.Context(), req.IP, req.Reason); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "IP greylisted successfully"})
+			})
+
+			ip.DELETE("/greylist/*ip", func(c *gin.Context) {
+				ip := strings.TrimPrefix(c.Param("ip"), "/")
+
+				if err := protectionService.RemoveFromGreylist(c.Request.Context(), ip); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "IP removed from greylist"})
 			})
 		}
 
@@ -229,15 +458,708 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 					return
 				}
 
-				if err := protectionService.UpdateRateLimitConfig(req.RequestsPerMinute, req.BurstSize); err != nil {
+				if err := protectionService.UpdateRateLimitConfig(c.Request.Context(), req.RequestsPerMinute, req.BurstSize); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return
 				}
 
 				c.JSON(http.StatusOK, gin.H{"message": "Rate limit configuration updated"})
 			})
+
+			config.GET("/bot-policy", func(c *gin.Context) {
+				spec, err := protectionService.GetBotPolicy()
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, spec)
+			})
+
+			config.PUT("/bot-policy", func(c *gin.Context) {
+				var spec botpolicy.Spec
+				if err := c.ShouldBindJSON(&spec); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.UpdateBotPolicy(c.Request.Context(), spec); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Bot policy updated"})
+			})
+
+			config.GET("/botnet-scoring", func(c *gin.Context) {
+				c.JSON(http.StatusOK, protectionService.GetBotnetScoring())
+			})
+
+			config.PUT("/botnet-scoring", func(c *gin.Context) {
+				// Decoded onto the scoring in force, so what the body
+				// leaves out is kept
+				scoring := protectionService.GetBotnetScoring()
+				if err := c.ShouldBindJSON(&scoring); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.UpdateBotnetScoring(c.Request.Context(), scoring); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, protectionService.GetBotnetScoring())
+			})
+		}
+
+		// SLA endpoints
+		api.GET("/sla", func(c *gin.Context) {
+			c.JSON(http.StatusOK, protectionService.GetSLAStatus())
+		})
+
+		// Attack cost endpoints
+		api.GET("/attack-cost", func(c *gin.Context) {
+			report, err := protectionService.GetAttackCost()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, report)
+		})
+
+		// Attack endpoints
+		api.GET("/attacks", func(c *gin.Context) {
+			list, err := protectionService.GetAttacks()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"attacks": list})
+		})
+
+		api.GET("/attacks/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attack ID"})
+				return
+			}
+			attack, err := protectionService.GetAttack(id)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, attack)
+		})
+
+		// Authentication event correlation endpoints
+		authGroup := api.Group("/auth")
+		{
+			// Accepts one event or {"events": [...]}
+			authGroup.POST("/events", func(c *gin.Context) {
+				var req struct {
+					authcorr.Event
+					Events []authcorr.Event `json:"events"`
+				}
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				evs := req.Events
+				if len(evs) == 0 {
+					evs = []authcorr.Event{req.Event}
+				}
+
+				if err := protectionService.IngestAuthEvents(c.Request.Context(), evs); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusAccepted, gin.H{"accepted": len(evs)})
+			})
+
+			authGroup.GET("/correlation", func(c *gin.Context) {
+				limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+				summary, err := protectionService.GetAuthCorrelation(limit)
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, summary)
+			})
+
+			authGroup.GET("/correlation/:ip", func(c *gin.Context) {
+				client, err := protectionService.GetAuthClient(c.Param("ip"))
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, client)
+			})
+		}
+
+		// Preset endpoints
+		presets := api.Group("/presets")
+		{
+			presets.GET("/", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{
+					"active":  protectionService.GetPreset(),
+					"presets": protectionService.ListPresets(),
+				})
+			})
+
+			presets.POST("/:name/apply", func(c *gin.Context) {
+				if err := protectionService.ApplyPreset(c.Request.Context(), c.Param("name")); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Preset applied", "preset": c.Param("name")})
+			})
 		}
 
+		// Rate limit inspection endpoints
+		rl := api.Group("/rate-limit")
+		{
+			rl.GET("/keys", func(c *gin.Context) {
+				top, _ := strconv.Atoi(c.DefaultQuery("top", "20"))
+				window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{
+					"window": window.String(),
+					"keys":   protectionService.GetTopThrottledKeys(top, time.Now().Add(-window)),
+				})
+			})
+
+			rl.GET("/keys/:key", func(c *gin.Context) {
+				state, rejections, err := protectionService.InspectRateLimitKey(c.Request.Context(), c.Param("key"))
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{
+					"state":      state,
+					"rejections": rejections,
+				})
+			})
+		}
+
+		// Rule management endpoints
+		rules := api.Group("/rules")
+		{
+			rules.GET("/probation", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"rules": protectionService.GetProbationRules()})
+			})
+
+			rules.POST("/", func(c *gin.Context) {
+				var req struct {
+					ID      string `json:"id" binding:"required"`
+					Pattern string `json:"pattern" binding:"required"`
+					Rollout *int   `json:"rollout"`
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.AddFilterRule(c.Request.Context(), req.ID, req.Pattern); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if req.Rollout != nil {
+					if err := protectionService.SetRuleRollout(c.Request.Context(), req.ID, *req.Rollout); err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Rule added"})
+			})
+
+			rules.PUT("/:id/rollout", func(c *gin.Context) {
+				var req struct {
+					Percent *int `json:"percent" binding:"required"`
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.SetRuleRollout(c.Request.Context(), c.Param("id"), *req.Percent); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Rule rollout updated"})
+			})
+
+			rules.POST("/:id/promote", func(c *gin.Context) {
+				if err := protectionService.PromoteRule(c.Request.Context(), c.Param("id")); err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Rule promoted to enforcement"})
+			})
+
+			rules.GET("/custom", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"rules": protectionService.GetFilterRules()})
+			})
+
+			rules.POST("/custom/reload", func(c *gin.Context) {
+				reloaded, err := protectionService.ReloadFilterRules(c.Request.Context())
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"reloaded": reloaded, "rules": len(protectionService.GetFilterRules())})
+			})
+
+			rules.GET("/schemas", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"schemas": protectionService.GetSchemas()})
+			})
+
+			rules.GET("/response", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"rules": protectionService.GetResponseRules()})
+			})
+
+			rules.GET("/categories", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"categories": protectionService.GetCategoryPolicies()})
+			})
+		}
+
+		// Rule update channel endpoints
+		api.GET("/rule-bundle", func(c *gin.Context) {
+			status, err := protectionService.GetRuleBundle()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, status)
+		})
+
+		api.POST("/rule-bundle/check", func(c *gin.Context) {
+			if _, err := protectionService.GetRuleBundle(); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			if err := protectionService.UpdateRules(c.Request.Context()); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+				return
+			}
+			status, _ := protectionService.GetRuleBundle()
+			c.JSON(http.StatusOK, status)
+		})
+
+		// Bad bot signature endpoints
+		api.GET("/bad-bots", func(c *gin.Context) {
+			status, err := protectionService.GetBadBots()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, status)
+		})
+
+		api.POST("/bad-bots/refresh", func(c *gin.Context) {
+			if status, err := protectionService.GetBadBots(); err != nil || status.Source == "" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "no bad bot signature source configured"})
+				return
+			}
+			if err := protectionService.RefreshBadBots(c.Request.Context()); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+				return
+			}
+			status, _ := protectionService.GetBadBots()
+			c.JSON(http.StatusOK, status)
+		})
+
+		// Composite signal endpoints
+		composites := api.Group("/signals/composite")
+		{
+			composites.GET("", func(c *gin.Context) {
+				list, err := protectionService.GetCompositeSignals()
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"signals": list})
+			})
+
+			composites.PUT("/:name", func(c *gin.Context) {
+				var composite signals.Composite
+				if err := c.ShouldBindJSON(&composite); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				composite.Name = c.Param("name")
+
+				if err := protectionService.SetCompositeSignal(c.Request.Context(), composite); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, composite)
+			})
+
+			composites.DELETE("/:name", func(c *gin.Context) {
+				if err := protectionService.RemoveCompositeSignal(c.Request.Context(), c.Param("name")); err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"message": "Composite signal removed"})
+			})
+		}
+
+		// Anomaly model endpoints
+		api.GET("/anomaly", func(c *gin.Context) {
+			status, err := protectionService.GetAnomalyStatus()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, status)
+		})
+
+		// DNSBL endpoints
+		api.GET("/dnsbl/:ip", func(c *gin.Context) {
+			ip := c.Param("ip")
+			if !blacklist.IsValidIP(ip) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+				return
+			}
+
+			result, err := protectionService.CheckDNSBL(c.Request.Context(), ip)
+			if err != nil && !result.Listed {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"ip": ip, "result": result})
+		})
+
+		// Botnet endpoints
+		api.GET("/botnet/:ip", func(c *gin.Context) {
+			ip := c.Param("ip")
+			if !blacklist.IsValidIP(ip) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+				return
+			}
+			explanation := protectionService.ExplainBotnet(ip)
+			if explanation.Behavior == nil && explanation.Network == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "IP not tracked by the botnet detector"})
+				return
+			}
+			c.JSON(http.StatusOK, explanation)
+		})
+
+		api.GET("/campaigns", func(c *gin.Context) {
+			campaigns, err := protectionService.GetCampaigns()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+		})
+
+		// Crawler endpoints
+		api.GET("/crawlers/:ip", func(c *gin.Context) {
+			ip := c.Param("ip")
+			if !blacklist.IsValidIP(ip) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+				return
+			}
+
+			userAgent := c.Query("user_agent")
+			if userAgent == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "user_agent is required"})
+				return
+			}
+
+			result, err := protectionService.VerifyCrawler(c.Request.Context(), ip, userAgent)
+			if err == crawler.ErrNotClaimed {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"ip": ip, "result": result})
+		})
+
+		// ASN endpoints
+		asn := api.Group("/asn")
+		{
+			asn.GET("/rules", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"rules": protectionService.GetASNRules()})
+			})
+
+			asn.POST("/rules", func(c *gin.Context) {
+				var rule blacklist.ASNRule
+				if err := c.ShouldBindJSON(&rule); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.SetASNRule(c.Request.Context(), rule); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "ASN rule saved"})
+			})
+
+			asn.DELETE("/rules/:asn", func(c *gin.Context) {
+				number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(c.Param("asn")), "AS"), 10, 32)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid AS number"})
+					return
+				}
+
+				if err := protectionService.RemoveASNRule(c.Request.Context(), uint32(number)); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "ASN rule removed"})
+			})
+
+			asn.GET("/lookup/:ip", func(c *gin.Context) {
+				info, found := protectionService.LookupASN(c.Param("ip"))
+				if !found {
+					c.JSON(http.StatusNotFound, gin.H{"error": "ASN not found"})
+					return
+				}
+
+				c.JSON(http.StatusOK, info)
+			})
+		}
+
+		// GeoIP endpoints
+		geoGroup := api.Group("/geo")
+		{
+			geoGroup.GET("/lookup/:ip", func(c *gin.Context) {
+				country, err := protectionService.LookupCountry(c.Param("ip"))
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"ip": c.Param("ip"), "country": country})
+			})
+
+			geoGroup.POST("/reload", func(c *gin.Context) {
+				if err := protectionService.ReloadGeoDatabase(); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "GeoIP database reloaded"})
+			})
+
+			geoGroup.GET("/fences", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{
+					"incident": protectionService.IncidentActive(),
+					"fences":   protectionService.GetGeoFences(),
+				})
+			})
+
+			geoGroup.POST("/incident", func(c *gin.Context) {
+				var req struct {
+					Active bool `json:"active"`
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				protectionService.SetIncidentMode(c.Request.Context(), req.Active)
+				c.JSON(http.StatusOK, gin.H{"message": "Incident mode updated", "incident": req.Active})
+			})
+		}
+
+		// Kill switch endpoints
+		ks := api.Group("/kill-switches")
+		{
+			ks.GET("/", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"kill_switches": protectionService.GetKillSwitches()})
+			})
+
+			ks.POST("/", func(c *gin.Context) {
+				var req struct {
+					Kind   string `json:"kind" binding:"required"`
+					Name   string `json:"name" binding:"required"`
+					Reason string `json:"reason"`
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := protectionService.EngageKillSwitch(c.Request.Context(), req.Kind, req.Name, req.Reason); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Kill switch engaged"})
+			})
+
+			ks.DELETE("/:kind/:name", func(c *gin.Context) {
+				if err := protectionService.ReleaseKillSwitch(c.Request.Context(), c.Param("kind"), c.Param("name")); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "Kill switch released"})
+			})
+		}
+
+		// API key endpoints; tokens are only shown on creation and rotation
+		keys := api.Group("/keys")
+		{
+			keys.GET("/", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"keys": protectionService.ListAPIKeys()})
+			})
+
+			keys.GET("/:id", func(c *gin.Context) {
+				key, ok := protectionService.GetAPIKey(c.Param("id"))
+				if !ok {
+					c.JSON(http.StatusNotFound, gin.H{"error": apikey.ErrNotFound.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, key)
+			})
+
+			keys.POST("/", func(c *gin.Context) {
+				var req struct {
+					Name              string        `json:"name" binding:"required"`
+					Paths             []string      `json:"paths"`
+					Methods           []string      `json:"methods"`
+					RequestsPerMinute int           `json:"requests_per_minute"`
+					BurstSize         int           `json:"burst_size"`
+					Quota             int64         `json:"quota"`
+					QuotaPeriod       string        `json:"quota_period"`
+					TTL               time.Duration `json:"ttl"` // zero for a key that never expires
+				}
+
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				key, token, err := protectionService.CreateAPIKey(c.Request.Context(), apikey.Spec{
+					Name:              req.Name,
+					Paths:             req.Paths,
+					Methods:           req.Methods,
+					RequestsPerMinute: req.RequestsPerMinute,
+					BurstSize:         req.BurstSize,
+					Quota:             req.Quota,
+					QuotaPeriod:       req.QuotaPeriod,
+					TTL:               req.TTL,
+				})
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusCreated, gin.H{"key": key, "token": token})
+			})
+
+			keys.POST("/:id/rotate", func(c *gin.Context) {
+				key, token, err := protectionService.RotateAPIKey(c.Request.Context(), c.Param("id"))
+				if err != nil {
+					status := http.StatusBadRequest
+					if err == apikey.ErrNotFound {
+						status = http.StatusNotFound
+					}
+					c.JSON(status, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"key": key, "token": token})
+			})
+
+			keys.DELETE("/:id", func(c *gin.Context) {
+				key, err := protectionService.RevokeAPIKey(c.Request.Context(), c.Param("id"))
+				if err != nil {
+					status := http.StatusBadRequest
+					if err == apikey.ErrNotFound {
+						status = http.StatusNotFound
+					}
+					c.JSON(status, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "key": key})
+			})
+		}
+
+		// Audit log endpoints
+		api.GET("/audit", func(c *gin.Context) {
+			q := audit.Query{
+				Actor:  c.Query("actor"),
+				Action: c.Query("action"),
+				Target: c.Query("target"),
+			}
+			q.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+			for param, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+				if value := c.Query(param); value != "" {
+					t, err := time.Parse(time.RFC3339, value)
+					if err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, expected RFC 3339"})
+						return
+					}
+					*dst = t
+				}
+			}
+
+			entries, err := protectionService.GetAuditLog(q)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"entries": entries})
+		})
+
+		api.GET("/audit/export", func(c *gin.Context) {
+			if !protectionService.AuditEnabled() {
+				c.JSON(http.StatusNotFound, gin.H{"error": "audit log is disabled"})
+				return
+			}
+
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", "attachment; filename=audit-"+time.Now().UTC().Format("20060102T150405Z")+".jsonl")
+			c.Status(http.StatusOK)
+			if err := protectionService.ExportAuditLog(c.Writer); err != nil {
+				logrus.Errorf("Failed to export audit log: %v", err)
+			}
+		})
+
+		api.GET("/audit/public-key", func(c *gin.Context) {
+			pub, err := protectionService.AuditPublicKey()
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"public_key": audit.EncodePublicKey(pub),
+				"key_id":     audit.KeyID(pub),
+			})
+		})
+
 		// Circuit breaker endpoints
 		cb := api.Group("/circuit-breakers")
 		{
@@ -295,3 +1217,41 @@ func setupRoutes(router *gin.Engine, protectionService *ddos.ProtectionService)
 		})
 	})
 }
+
+// auditActor records who is making an API request so changes can be
+// attributed in the audit log. API keys are identified by a fingerprint so
+// the key itself never reaches the log.
+func auditActor(c *gin.Context) {
+	actor := c.GetString("actor")
+	if actor == "" {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			actor = "key:" + hex.EncodeToString(sum[:])[:12]
+		} else {
+			actor = "ip:" + blacklist.GetClientIP(c.Request)
+		}
+	}
+
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+	c.Next()
+}
+
+// listTenant scopes IP list requests carrying a tenant query parameter to
+// that tenant's isolated lists
+func listTenant(protectionService *ddos.ProtectionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.Query("tenant")
+		if tenant == "" {
+			c.Next()
+			return
+		}
+
+		if !protectionService.IsolatesTenant(tenant) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "tenant " + tenant + " has no isolated lists"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(ddos.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
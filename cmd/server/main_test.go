@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/config"
+	"ddos-protection/internal/ddos"
+	"ddos-protection/internal/ratelimit"
+	"ddos-protection/pkg/testserver"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The protection service registers its metrics globally, so the tests
+// share one, behind the API router and behind edge, which runs its
+// middleware in front of a backend answering 200 OK
+var (
+	router  *gin.Engine
+	edge    *gin.Engine
+	service *ddos.ProtectionService
+)
+
+func TestMain(m *testing.M) {
+	cfg := testserver.DefaultConfig()
+	cfg.Protection.Methods = config.MethodsConfig{
+		Limits:        map[string]config.RateLimitConfig{"head": {RequestsPerMinute: 60, BurstSize: 3}},
+		AnswerOptions: true,
+		AllowMethods:  []string{"GET", "HEAD", "OPTIONS"},
+		AllowHeaders:  []string{"Content-Type"},
+		AllowOrigin:   "*",
+		MaxAge:        config.Duration(10 * time.Minute),
+	}
+	cfg.Mesh = config.MeshConfig{Enabled: true, Listen: "127.0.0.1:0", PathPrefix: "/authz/"}
+	cfg.StatusPage = config.StatusPageConfig{Enabled: true, Title: "Shop <Status>", CacheSeconds: config.Duration(time.Hour)}
+	dir, err := os.MkdirTemp("", "ddos-server-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg.Protection.ASN = config.ASNConfig{Enabled: true, Database: filepath.Join(dir, "ip2asn.tsv")}
+	if err := os.WriteFile(cfg.Protection.ASN.Database, []byte("198.18.0.0\t198.18.255.255\t64500\tNL\tEXAMPLE-NET\n"), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg.Protection.Reputation = config.ReputationConfig{Enabled: true}
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	cfg.Protection.Access.Rules = []config.AccessRuleConfig{{Name: "admin-hq", Paths: []string{"/admin"}, Networks: []string{"203.0.113.0/24"}}}
+	gin.SetMode(cfg.Server.Mode)
+	service, err = ddos.NewProtectionService(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	router = gin.New()
+	setupRoutes(router, cfg, service)
+	edge = gin.New()
+	edge.Use(service.ProtectionMiddleware())
+	edge.NoRoute(func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	code := m.Run()
+	service.Stop(context.Background())
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestManualIPv6BanIsExact(t *testing.T) {
+	call := func(method, path, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body)
+		}
+	}
+	banned := func(ip string) bool {
+		addr := netip.MustParseAddr(ip)
+		return service.ListBlacklist(blacklist.ListQuery{Net: netip.PrefixFrom(addr, addr.BitLen())}).Total > 0
+	}
+
+	call("POST", "/api/v1/ip/blacklist", `{"ip": "2001:db8:1:2::10", "reason": "abuse"}`)
+	if !banned("2001:db8:1:2::10") {
+		t.Fatal("banned address not blacklisted")
+	}
+	if banned("2001:db8:1:2::11") {
+		t.Error("manual ban widened to the address's /64")
+	}
+
+	call("DELETE", "/api/v1/ip/blacklist/2001:db8:1:2::10", "")
+	if banned("2001:db8:1:2::10") {
+		t.Error("address still blacklisted after unbanning it")
+	}
+}
+
+func TestAccessRuleIgnoresForgedAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		peer   string
+		header http.Header
+		status int
+	}{
+		{"Allowed network", "203.0.113.5", nil, http.StatusOK},
+		{"Forged X-Forwarded-For", "198.51.100.70", http.Header{"X-Forwarded-For": {"203.0.113.5"}}, http.StatusForbidden},
+		{"Forged X-Real-IP", "198.51.100.71", http.Header{"X-Real-Ip": {"203.0.113.5"}}, http.StatusForbidden},
+		{"Forged hop behind a trusted proxy", "10.0.0.9", http.Header{"X-Forwarded-For": {"203.0.113.5, 198.51.100.72"}}, http.StatusForbidden},
+		{"Allowed network behind a trusted proxy", "10.0.0.9", http.Header{"X-Forwarded-For": {"203.0.113.6"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := fromClient(tt.peer, "GET", "/admin/users", tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("GET /admin/users: %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status == http.StatusForbidden && !strings.Contains(w.Body.String(), "ACCESS_DENIED") {
+				t.Errorf("denial %s is not ACCESS_DENIED", w.Body)
+			}
+		})
+	}
+}
+
+// inspectKey returns what the rate limit inspection API reports for a key
+func inspectKey(t *testing.T, key string) (state ratelimit.KeyState, rejections ratelimit.RejectionStats) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/rate-limit/keys/"+key, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("inspecting %s: %d %s", key, w.Code, w.Body)
+	}
+	var body struct {
+		State      ratelimit.KeyState       `json:"state"`
+		Rejections ratelimit.RejectionStats `json:"rejections"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.State, body.Rejections
+}
+
+// exhaust sends GETs from ip until the rate limit rejects one, returning how
+// many were allowed
+func exhaust(t *testing.T, ip string) int {
+	t.Helper()
+	for allowed := 0; allowed < 100; allowed++ {
+		if w := fromClient(ip, "GET", "/", nil); w.Code == http.StatusTooManyRequests {
+			return allowed
+		}
+	}
+	t.Fatalf("%s never rate limited", ip)
+	return 0
+}
+
+func TestRejectionsKeptAcrossLimitChanges(t *testing.T) {
+	const ip = "192.0.2.80"
+	exhaust(t, ip)
+	if _, rejections := inspectKey(t, ip); rejections.Total != 1 {
+		t.Fatalf("%d rejections recorded, want 1", rejections.Total)
+	}
+
+	limits := service.GetRateLimitConfig()
+	putRateLimits(t, limits["requests_per_minute"], limits["burst_size"])
+	if _, rejections := inspectKey(t, ip); rejections.Total != 1 {
+		t.Errorf("%d rejections reported after a limit change, want 1", rejections.Total)
+	}
+}
+
+// putRateLimits sets the global rate limit through the API
+func putRateLimits(t *testing.T, requestsPerMinute, burstSize interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"requests_per_minute": %v, "burst_size": %v}`, requestsPerMinute, burstSize)
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/config/rate-limits", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("updating rate limits: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLimitChangeKeepsBuckets(t *testing.T) {
+	const ip = "192.0.2.81"
+	limits := service.GetRateLimitConfig()
+	burst := exhaust(t, ip)
+	t.Cleanup(func() { putRateLimits(t, limits["requests_per_minute"], limits["burst_size"]) })
+
+	// Neither a tighter nor a looser limit hands the client a new burst
+	putRateLimits(t, limits["requests_per_minute"], burst/2)
+	if w := fromClient(ip, "GET", "/", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spent client allowed after lowering the limits: %d", w.Code)
+	}
+	putRateLimits(t, limits["requests_per_minute"], burst*2)
+	if w := fromClient(ip, "GET", "/", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spent client allowed after raising the limits: %d", w.Code)
+	}
+	if state, _ := inspectKey(t, ip); state.Burst != burst*2 {
+		t.Errorf("inspected burst %d, want %d", state.Burst, burst*2)
+	}
+}
+
+func TestBlacklistAnnotations(t *testing.T) {
+	// A request through the edge starts tracking the client's reputation
+	fromClient("198.18.0.7", "GET", "/", nil)
+	for _, target := range []string{"198.18.0.7", "198.18.4.0/24", "192.0.2.60"} {
+		if err := service.BlacklistIP(context.Background(), target, time.Hour, blacklist.Origin{Source: blacklist.SourceManual}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		cidr       string
+		country    string
+		asn        uint32
+		reputation bool
+	}{
+		{"198.18.0.7/32", "NL", 64500, true},
+		// A range is looked up by its first address and was never tracked
+		{"198.18.4.0/24", "NL", 64500, false},
+		{"192.0.2.60/32", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ip/blacklist?cidr="+tt.cidr, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("listing: %d %s", w.Code, w.Body)
+			}
+			var page struct {
+				Blacklisted []map[string]json.RawMessage `json:"blacklisted"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Blacklisted) != 1 {
+				t.Fatalf("%d entries listed, want 1: %s", len(page.Blacklisted), w.Body)
+			}
+			entry := page.Blacklisted[0]
+
+			var country string
+			if raw, ok := entry["country"]; ok {
+				json.Unmarshal(raw, &country)
+			}
+			if country != tt.country {
+				t.Errorf("country = %q, want %q", country, tt.country)
+			}
+			var asn blacklist.ASNInfo
+			if raw, ok := entry["asn"]; ok {
+				json.Unmarshal(raw, &asn)
+			}
+			if asn.Number != tt.asn {
+				t.Errorf("asn = %d, want %d", asn.Number, tt.asn)
+			}
+			if _, ok := entry["reputation"]; ok != tt.reputation {
+				t.Errorf("reputation listed = %v, want %v: %s", ok, tt.reputation, w.Body)
+			}
+		})
+	}
+}
+
+func TestPartialBotnetScoringUpdate(t *testing.T) {
+	w := httptest.NewRecorder()
+	body := `{"rules": {"no_css": {"weight": 50}}}`
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/config/botnet-scoring", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+
+	scoring := service.GetBotnetScoring()
+	if rule := scoring.Rules["no_css"]; !rule.Enabled || rule.Weight != 50 || rule.Threshold != 20 {
+		t.Errorf("no_css = %+v, want only its weight changed", rule)
+	}
+	for id, rule := range scoring.Rules {
+		if !rule.Enabled {
+			t.Errorf("indicator %s disabled by an update that left it out", id)
+		}
+	}
+	if scoring.RiskDivisor != 200 {
+		t.Errorf("risk_divisor %v after an update that left it out", scoring.RiskDivisor)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/config/botnet-scoring", strings.NewReader(`{"risk_divisor": -1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid update: %d, want 400", w.Code)
+	}
+}
+
+// fromClient sends a request through the protection middleware from ip
+func fromClient(ip, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "en")
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	w := httptest.NewRecorder()
+	edge.ServeHTTP(w, req)
+	return w
+}
+
+func TestOptionsAnsweredAtEdge(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		cors   bool
+	}{
+		{"Plain OPTIONS", nil, false},
+		{"CORS preflight", http.Header{"Origin": {"https://shop.example"}}, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := fromClient(fmt.Sprintf("192.0.2.%d", 10+i), "OPTIONS", "/api/cart", tt.header)
+			if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+				t.Fatalf("OPTIONS: %d %q, want an empty 204 from the edge", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+				t.Errorf("Allow = %q", got)
+			}
+			cors := map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS",
+				"Access-Control-Allow-Headers": "Content-Type",
+				"Access-Control-Max-Age":       "600",
+			}
+			for name, want := range cors {
+				if !tt.cors {
+					want = ""
+				}
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodLimit(t *testing.T) {
+	const ip = "192.0.2.20"
+	before := service.GetTrafficStats().Methods["HEAD"]["denied"]
+
+	// The limit is configured for "head" and applies to HEAD
+	for i := 0; i < 3; i++ {
+		if w := fromClient(ip, "HEAD", "/", nil); w.Code != http.StatusOK {
+			t.Fatalf("HEAD %d within the burst: %d", i, w.Code)
+		}
+	}
+	w := fromClient(ip, "HEAD", "/", nil)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "RATE_LIMITED_METHOD") {
+		t.Fatalf("HEAD past the burst: %d %s", w.Code, w.Body)
+	}
+	if got := service.GetTrafficStats().Methods["HEAD"]["denied"]; got != before+1 {
+		t.Errorf("denied HEAD requests counted %d, want %d", got, before+1)
+	}
+
+	// Other methods and clients keep their own allowance
+	if w := fromClient(ip, "GET", "/", nil); w.Code != http.StatusOK {
+		t.Errorf("GET from a client out of HEAD allowance: %d", w.Code)
+	}
+	if w := fromClient("192.0.2.21", "HEAD", "/", nil); w.Code != http.StatusOK {
+		t.Errorf("HEAD from another client: %d", w.Code)
+	}
+}
+
+func TestUnknownMethodsCountedAsOther(t *testing.T) {
+	before := service.GetTrafficStats().Methods["OTHER"]
+	fromClient("192.0.2.30", "PURGE", "/", nil)
+	fromClient("192.0.2.30", "X-CUSTOM", "/", nil)
+
+	after := service.GetTrafficStats().Methods["OTHER"]
+	var added int64
+	for outcome, count := range after {
+		added += count - before[outcome]
+	}
+	if added != 2 {
+		t.Errorf("%d unknown-method requests counted as OTHER, want 2", added)
+	}
+	if _, exists := service.GetTrafficStats().Methods["PURGE"]; exists {
+		t.Error("unknown method got its own label")
+	}
+}
+
+func TestPublicStatusPage(t *testing.T) {
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=10" {
+			t.Errorf("GET %s: Cache-Control %q", path, got)
+		}
+		return w
+	}
+
+	// Not under attack, the status follows health, which a fresh start
+	// leaves degraded until the uptime check passes
+	want, description := "operational", "All systems operational"
+	if service.GetHealthStatus(context.Background()).Status != "healthy" {
+		want, description = "degraded", "Some services are experiencing degraded performance."
+	}
+
+	var status map[string]interface{}
+	if err := json.Unmarshal(get("/status-page.json").Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status["status"] != want || status["description"] != description || status["mitigation_level"] != "none" || status["title"] != "Shop <Status>" {
+		t.Errorf("idle service reported as %v, want %s", status, want)
+	}
+	// Nothing but the sanitized fields is published
+	for field := range status {
+		switch field {
+		case "title", "status", "description", "mitigation_level", "since", "uptime_seconds", "updated_at":
+		default:
+			t.Errorf("status page publishes %q", field)
+		}
+	}
+
+	// Within the cache interval the same assessment is served
+	var again map[string]interface{}
+	json.Unmarshal(get("/status-page.json").Body.Bytes(), &again)
+	if again["updated_at"] != status["updated_at"] {
+		t.Errorf("status recomputed within the cache interval: %v then %v", status["updated_at"], again["updated_at"])
+	}
+
+	w := get("/status-page")
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("status page served as %q", got)
+	}
+	page := w.Body.String()
+	if !strings.Contains(page, "<title>Shop &lt;Status&gt;</title>") || !strings.Contains(page, `class="banner `+want+`">`+description) {
+		t.Errorf("status page does not show the escaped title and status:\n%s", page)
+	}
+}
+
+func TestMeshCheck(t *testing.T) {
+	mesh := service.MeshHandler()
+	if mesh == nil {
+		t.Fatal("mesh enabled without a check handler")
+	}
+	origin := blacklist.Origin{Source: blacklist.SourceManual, Reason: "test"}
+	if err := service.BlacklistIP(context.Background(), "198.51.100.60", time.Hour, origin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		header    http.Header
+		status    int
+		code      string
+	}{
+		{"Allowed", "", http.Header{"X-Envoy-External-Address": {"192.0.2.50"}}, http.StatusOK, ""},
+		{"Banned client", "", http.Header{"X-Envoy-External-Address": {"198.51.100.60"}}, http.StatusForbidden, "BLOCKED_IP"},
+		{"Banned client claiming another address", "", http.Header{
+			"X-Envoy-External-Address": {"198.51.100.60"},
+			"X-Forwarded-For":          {"192.0.2.51"},
+			"X-Real-Ip":                {"192.0.2.51"},
+		}, http.StatusForbidden, "BLOCKED_IP"},
+		{"Client claiming a banned address", "", http.Header{
+			"X-Envoy-External-Address": {"192.0.2.52"},
+			"X-Forwarded-For":          {"198.51.100.60"},
+		}, http.StatusOK, ""},
+		{"Client headers ignored without the mesh address", "", http.Header{
+			"X-Forwarded-For": {"198.51.100.60"},
+			"X-Real-Ip":       {"198.51.100.60"},
+		}, http.StatusOK, ""},
+		{"Request denied by the filter", "curl/8.0", http.Header{"X-Envoy-External-Address": {"192.0.2.53"}}, http.StatusBadRequest, "FILTERED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/authz/products/42", nil)
+			req.RemoteAddr = "10.0.0.2:15000"
+			req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Language", "en")
+			if tt.userAgent != "" {
+				req.Header.Set("User-Agent", tt.userAgent)
+			}
+			for k, values := range tt.header {
+				req.Header[k] = values
+			}
+			w := httptest.NewRecorder()
+			mesh.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("check: %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("denial %s does not carry %s", w.Body, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ddos-protection/internal/apikey"
+	"ddos-protection/internal/config"
+	"ddos-protection/internal/events"
+	"ddos-protection/internal/replica"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// runReplica serves dashboards, stats and event queries from what enforcing
+// instances publish to Redis. A replica never handles protected traffic,
+// so heavy queries cannot slow enforcement down.
+func runReplica(cfg *config.Config) {
+	if cfg.Redis.Host == "" {
+		logrus.Fatal("A read replica needs Redis")
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.GetRedisAddr(),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := replica.NewReader(client)
+	store := events.NewStore(cfg.Events.Capacity)
+	go func() {
+		for {
+			if err := reader.Tail(ctx, store); err != nil {
+				logrus.Errorf("Reading replicated events failed: %v", err)
+			}
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if cfg.APIKeys.Enabled {
+		syncInterval := cfg.APIKeys.SyncInterval.Duration()
+		if syncInterval <= 0 {
+			syncInterval = 5 * time.Second
+		}
+		keys := apikey.NewStore(client, syncInterval)
+		if err := keys.Sync(ctx); err != nil {
+			logrus.Warnf("Failed to load API keys: %v", err)
+		}
+		go keys.Run(ctx)
+		router.Use(replicaAPIKeys(cfg.APIKeys, keys))
+	}
+	setupReplicaRoutes(router, reader, store)
+
+	server := &http.Server{
+		Addr:    cfg.Server.Port,
+		Handler: router,
+	}
+	go func() {
+		logrus.Infof("Starting read replica on %s", cfg.Server.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	logrus.Info("Shutting down read replica...")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Server forced to shutdown: %v", err)
+	}
+	logrus.Info("Server exited")
+}
+
+// replicaAPIKeys requires an API key on protected paths, applying the key's
+// scopes and limits as enforcing instances do
+func replicaAPIKeys(cfg config.APIKeysConfig, keys *apikey.Store) gin.HandlerFunc {
+	header := cfg.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	return func(c *gin.Context) {
+		protected := false
+		for _, prefix := range cfg.ProtectedPaths {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				protected = true
+				break
+			}
+		}
+		if !protected {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(header)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key required", "code": "API_KEY_REQUIRED"})
+			return
+		}
+		if _, err := keys.Authorize(c.Request.Context(), token, c.Request.Method, c.Request.URL.Path); err != nil {
+			switch err {
+			case apikey.ErrScope:
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key not valid for this request", "code": "API_KEY_SCOPE"})
+			case apikey.ErrRateLimited, apikey.ErrQuotaExceeded:
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": "API_KEY_RATE_LIMITED"})
+			default:
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key", "code": "API_KEY_INVALID"})
+			}
+			return
+		}
+		c.Next()
+	}
+}
+
+func setupReplicaRoutes(router *gin.Engine, reader *replica.Reader, store *events.Store) {
+	router.GET("/health", func(c *gin.Context) {
+		if err := reader.Ping(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "critical", "role": "replica", "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "ok",
+			"role":      "replica",
+			"timestamp": time.Now(),
+		})
+	})
+
+	api := router.Group("/api/v1")
+	{
+		api.GET("/stats", func(c *gin.Context) {
+			snapshots, err := reader.Snapshots(c.Request.Context())
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, replica.Merge(snapshots))
+		})
+
+		api.GET("/stats/nodes", func(c *gin.Context) {
+			snapshots, err := reader.Snapshots(c.Request.Context())
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"nodes": snapshots})
+		})
+
+		api.GET("/events", func(c *gin.Context) {
+			q, err := events.ParseQuery(c.Query("q"), time.Now())
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			limit, _ := strconv.Atoi(c.Query("limit"))
+			page, err := store.Search(q, c.Query("cursor"), limit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, page)
+		})
+	}
+}
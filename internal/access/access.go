@@ -0,0 +1,191 @@
+// Package access enforces positive security on sensitive paths. A rule
+// names the paths it guards and who may reach them: networks, countries,
+// autonomous systems or authenticated identities. Clients matching none of
+// them are challenged or blocked, however clean they otherwise look.
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Action is what happens to a client a rule does not allow
+type Action string
+
+const (
+	ActionAllow     Action = "allow"
+	ActionChallenge Action = "challenge"
+	ActionBlock     Action = "block"
+)
+
+// Client is what a rule can identify a request by. Country and ASN are
+// empty and zero when unknown.
+type Client struct {
+	Addr     netip.Addr
+	Country  string
+	ASN      uint32
+	Identity string
+}
+
+// Rule restricts a group of paths to the clients it allows
+type Rule struct {
+	Name       string
+	Paths      []string
+	Networks   []netip.Prefix
+	Countries  map[string]bool
+	ASNs       map[uint32]bool
+	Identities map[string]bool
+	Action     Action
+}
+
+// NewRule builds a rule guarding path prefixes. Networks are CIDRs or single
+// addresses; action is challenge or block, defaulting to block.
+func NewRule(name string, paths, networks, countries []string, asns []uint32, identities []string, action string) (*Rule, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("access rule %s guards no paths", name)
+	}
+	if len(networks)+len(countries)+len(asns)+len(identities) == 0 {
+		return nil, fmt.Errorf("access rule %s allows nobody", name)
+	}
+
+	r := &Rule{
+		Name:       name,
+		Paths:      paths,
+		Countries:  make(map[string]bool, len(countries)),
+		ASNs:       make(map[uint32]bool, len(asns)),
+		Identities: make(map[string]bool, len(identities)),
+	}
+
+	switch Action(action) {
+	case "", ActionBlock:
+		r.Action = ActionBlock
+	case ActionChallenge:
+		r.Action = ActionChallenge
+	default:
+		return nil, fmt.Errorf("access rule %s: invalid action %q", name, action)
+	}
+
+	for _, n := range networks {
+		prefix, err := parsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("access rule %s: %v", name, err)
+		}
+		r.Networks = append(r.Networks, prefix)
+	}
+	for _, c := range countries {
+		r.Countries[strings.ToUpper(strings.TrimSpace(c))] = true
+	}
+	for _, a := range asns {
+		r.ASNs[a] = true
+	}
+	for _, id := range identities {
+		r.Identities[id] = true
+	}
+	return r, nil
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Matches reports whether the rule guards path
+func (r *Rule) Matches(path string) bool {
+	for _, prefix := range r.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the client matches any of the rule's allowances
+func (r *Rule) Allows(c Client) bool {
+	if c.Addr.IsValid() {
+		for _, prefix := range r.Networks {
+			if prefix.Contains(c.Addr) {
+				return true
+			}
+		}
+	}
+	if c.Country != "" && r.Countries[c.Country] {
+		return true
+	}
+	if c.ASN != 0 && r.ASNs[c.ASN] {
+		return true
+	}
+	return c.Identity != "" && r.Identities[c.Identity]
+}
+
+// NeedsCountry reports whether the rule allows by country
+func (r *Rule) NeedsCountry() bool {
+	return len(r.Countries) > 0
+}
+
+// NeedsASN reports whether the rule allows by AS
+func (r *Rule) NeedsASN() bool {
+	return len(r.ASNs) > 0
+}
+
+// Policy is an ordered set of access rules
+type Policy []*Rule
+
+// Covering returns the rules guarding path
+func (p Policy) Covering(path string) []*Rule {
+	var rules []*Rule
+	for _, r := range p {
+		if r.Matches(path) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Decide returns the strictest action of the rules that do not allow the
+// client, and the name of the rule that produced it. A client must be
+// allowed by every rule guarding the path.
+func Decide(rules []*Rule, c Client) (Action, string) {
+	result, name := ActionAllow, ""
+	for _, r := range rules {
+		if r.Allows(c) {
+			continue
+		}
+		if r.Action == ActionBlock {
+			return ActionBlock, r.Name
+		}
+		if result == ActionAllow {
+			result, name = r.Action, r.Name
+		}
+	}
+	return result, name
+}
+
+// Identity returns the authenticated identity of a request: the subject
+// common name of a verified TLS client certificate, otherwise the value of
+// header when one is configured. The header must be set by a trusted proxy
+// that strips it from client requests.
+func Identity(req *http.Request, header string) string {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		if cn := req.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	if header != "" {
+		return strings.TrimSpace(req.Header.Get(header))
+	}
+	return ""
+}
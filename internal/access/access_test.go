@@ -0,0 +1,67 @@
+package access
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	admin, err := NewRule("admin-hq", []string{"/admin"}, []string{"203.0.113.0/24"}, nil, nil, []string{"alice"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	billing, err := NewRule("billing-domestic", []string{"/admin/billing", "/billing"}, nil, []string{"de"}, []uint32{64500}, nil, "challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Policy{admin, billing}
+
+	hq := Client{Addr: netip.MustParseAddr("203.0.113.7"), Country: "DE"}
+	remote := Client{Addr: netip.MustParseAddr("198.51.100.1"), Country: "FR"}
+
+	tests := []struct {
+		name   string
+		path   string
+		client Client
+		action Action
+		rule   string
+	}{
+		{"unguarded path", "/products", remote, ActionAllow, ""},
+		{"allowed network", "/admin/users", hq, ActionAllow, ""},
+		{"outsider blocked", "/admin/users", remote, ActionBlock, "admin-hq"},
+		{"allowed identity", "/admin/users", Client{Addr: remote.Addr, Identity: "alice"}, ActionAllow, ""},
+		{"every covering rule must allow", "/admin/billing", Client{Addr: hq.Addr, Country: "FR"}, ActionChallenge, "billing-domestic"},
+		{"allowed ASN", "/billing", Client{Addr: remote.Addr, ASN: 64500}, ActionAllow, ""},
+		{"challenged outsider", "/billing", remote, ActionChallenge, "billing-domestic"},
+	}
+	for _, tt := range tests {
+		action, rule := Decide(policy.Covering(tt.path), tt.client)
+		if action != tt.action || rule != tt.rule {
+			t.Errorf("%s: Decide = %s, %q; want %s, %q", tt.name, action, rule, tt.action, tt.rule)
+		}
+	}
+}
+
+func TestNewRuleValidation(t *testing.T) {
+	if _, err := NewRule("empty", []string{"/admin"}, nil, nil, nil, nil, ""); err == nil {
+		t.Error("rule allowing nobody should be rejected")
+	}
+	if _, err := NewRule("bad", []string{"/admin"}, []string{"not-a-cidr"}, nil, nil, nil, ""); err == nil {
+		t.Error("invalid network should be rejected")
+	}
+	if _, err := NewRule("bad", []string{"/admin"}, []string{"10.0.0.0/8"}, nil, nil, nil, "allow"); err == nil {
+		t.Error("allow is not a valid action for outsiders")
+	}
+}
+
+func TestIdentityHeader(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+	req.Header.Set("X-Authenticated-User", "alice")
+	if got := Identity(req, "X-Authenticated-User"); got != "alice" {
+		t.Errorf("Identity = %q, want alice", got)
+	}
+	if got := Identity(req, ""); got != "" {
+		t.Errorf("Identity without a trusted header = %q", got)
+	}
+}
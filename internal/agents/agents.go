@@ -0,0 +1,133 @@
+package agents
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var agentCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_monitor_agent_requests_total",
+	Help: "Requests from verified monitoring agents by profile",
+}, []string{"profile"})
+
+// Profile describes a monitoring agent by its published source ranges and
+// user agent. A request must match both to be trusted, since either alone
+// is trivially spoofed or shared.
+type Profile struct {
+	Name       string
+	Networks   []netip.Prefix
+	UserAgents []*regexp.Regexp
+}
+
+// NewProfile builds a profile from CIDRs (or single addresses) and
+// case-insensitive user agent patterns
+func NewProfile(name string, networks, userAgents []string) (*Profile, error) {
+	if len(networks) == 0 || len(userAgents) == 0 {
+		return nil, fmt.Errorf("profile %s needs both networks and user agents", name)
+	}
+
+	p := &Profile{Name: name}
+	for _, n := range networks {
+		prefix, err := parsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+		p.Networks = append(p.Networks, prefix)
+	}
+	for _, ua := range userAgents {
+		re, err := regexp.Compile("(?i)" + ua)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: invalid user agent pattern %q: %v", name, ua, err)
+		}
+		p.UserAgents = append(p.UserAgents, re)
+	}
+	return p, nil
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Matches reports whether the request comes from this agent
+func (p *Profile) Matches(addr netip.Addr, userAgent string) bool {
+	inRange := false
+	for _, prefix := range p.Networks {
+		if prefix.Contains(addr) {
+			inRange = true
+			break
+		}
+	}
+	if !inRange {
+		return false
+	}
+
+	for _, re := range p.UserAgents {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry holds the verified monitoring agent profiles
+type Registry struct {
+	profiles []*Profile
+}
+
+// NewRegistry creates a registry of profiles
+func NewRegistry(profiles []*Profile) *Registry {
+	return &Registry{profiles: profiles}
+}
+
+// Match returns the name of the profile matching ip and userAgent, counting
+// the request against it
+func (r *Registry) Match(ip, userAgent string) (string, bool) {
+	if r == nil || len(r.profiles) == 0 {
+		return "", false
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range r.profiles {
+		if p.Matches(addr, userAgent) {
+			agentCounter.WithLabelValues(p.Name).Inc()
+			return p.Name, true
+		}
+	}
+	return "", false
+}
+
+// Names returns the profile names
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+
+	names := make([]string, len(r.profiles))
+	for i, p := range r.profiles {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -0,0 +1,198 @@
+// Package anomaly learns what normal traffic looks like per client
+// (request rate, path entropy and the spread of inter-arrival times) during
+// calm periods, and scores how far a client's current window deviates from
+// that baseline.
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ddos-protection/internal/intern"
+)
+
+// maxPaths bounds the distinct paths counted per client window
+const maxPaths = 100
+
+// Feature indexes
+const (
+	FeatureRate = iota
+	FeaturePathEntropy
+	FeatureInterArrival
+	NumFeatures
+)
+
+// FeatureNames names the features in indicator messages and the CLI
+var FeatureNames = [NumFeatures]string{"request_rate", "path_entropy", "inter_arrival_cv"}
+
+// Features describe a client's traffic over a window: requests per
+// minute, Shannon entropy of the paths in bits, and the coefficient of
+// variation of the gaps between requests (near 0 for a script on a timer)
+type Features [NumFeatures]float64
+
+// Window accumulates one client's requests
+type Window struct {
+	Start    time.Time
+	Last     time.Time
+	Requests int
+	paths    map[string]int
+	gaps     stat
+}
+
+// NewWindow starts a window at start
+func NewWindow(start time.Time) *Window {
+	return &Window{Start: start, paths: make(map[string]int)}
+}
+
+// Observe adds a request
+func (w *Window) Observe(now time.Time, path string) {
+	if w.Requests > 0 {
+		w.gaps.add(now.Sub(w.Last).Seconds())
+	}
+	w.Requests++
+	w.Last = now
+	w.paths[intern.Key(w.paths, path, maxPaths)]++
+}
+
+// Features computes the window's features, the rate over length
+func (w *Window) Features(length time.Duration) Features {
+	var f Features
+	f[FeatureRate] = float64(w.Requests) / length.Minutes()
+
+	for _, count := range w.paths {
+		p := float64(count) / float64(w.Requests)
+		f[FeaturePathEntropy] -= p * math.Log2(p)
+	}
+
+	if w.gaps.mean > 0 {
+		f[FeatureInterArrival] = w.gaps.stddev() / w.gaps.mean
+	}
+	return f
+}
+
+// stat is a running mean and variance (Welford)
+type stat struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+func (s *stat) add(x float64) {
+	s.n++
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+}
+
+func (s *stat) stddev() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.n-1))
+}
+
+// Model is the learned baseline: the mean and variance of each feature
+// over the client windows seen in calm periods. The request rate is
+// modelled on a log scale, as it is heavily skewed.
+type Model struct {
+	Samples int64                `json:"samples"`
+	Mean    [NumFeatures]float64 `json:"mean"`
+	M2      [NumFeatures]float64 `json:"m2"`
+	Updated time.Time            `json:"updated"`
+}
+
+// Deviation is how far a window lies from the baseline
+type Deviation struct {
+	Feature string  `json:"feature"`
+	Value   float64 `json:"value"`
+	Z       float64 `json:"z"`
+	Score   float64 `json:"score"`
+}
+
+// Scores between these z-scores rise linearly from 0 to 1
+const (
+	zNormal    = 3
+	zAnomalous = 8
+)
+
+func transform(f Features) Features {
+	f[FeatureRate] = math.Log1p(f[FeatureRate])
+	return f
+}
+
+// Train adds a calm window to the baseline
+func (m *Model) Train(f Features) {
+	f = transform(f)
+	m.Samples++
+	for i, x := range f {
+		delta := x - m.Mean[i]
+		m.Mean[i] += delta / float64(m.Samples)
+		m.M2[i] += delta * (x - m.Mean[i])
+	}
+	m.Updated = time.Now()
+}
+
+// StdDev returns the spread of feature i, floored so that a feature that
+// barely varied while training does not make every change look extreme
+func (m *Model) StdDev(i int) float64 {
+	std := 0.0
+	if m.Samples > 1 {
+		std = math.Sqrt(m.M2[i] / float64(m.Samples-1))
+	}
+	return math.Max(std, 0.1*math.Abs(m.Mean[i])+0.05)
+}
+
+// Score returns the feature deviating most from the baseline. A low request
+// rate is never anomalous.
+func (m *Model) Score(f Features) Deviation {
+	var worst Deviation
+	for i, x := range transform(f) {
+		z := (x - m.Mean[i]) / m.StdDev(i)
+		if i == FeatureRate && z < 0 {
+			z = 0
+		}
+		if math.Abs(z) > math.Abs(worst.Z) || worst.Feature == "" {
+			worst = Deviation{Feature: FeatureNames[i], Value: f[i], Z: z}
+		}
+	}
+	worst.Score = math.Min(math.Max((math.Abs(worst.Z)-zNormal)/(zAnomalous-zNormal), 0), 1)
+	return worst
+}
+
+// LoadModel reads a model saved with Save
+func LoadModel(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid anomaly model %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// Save writes the model to path, replacing it atomically
+func (m *Model) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
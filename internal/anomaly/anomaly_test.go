@@ -0,0 +1,84 @@
+package anomaly
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// browse records a human-like visit: a dozen or so requests to a few
+// pages at irregular intervals
+func browse(rng *rand.Rand, start time.Time) *Window {
+	w := NewWindow(start)
+	now := start
+	for i := 0; i < 10+rng.Intn(10); i++ {
+		w.Observe(now, fmt.Sprintf("/page/%d", rng.Intn(6)))
+		now = now.Add(time.Duration(rng.ExpFloat64() * float64(3*time.Second)))
+	}
+	return w
+}
+
+func trainedModel(t *testing.T) *Model {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	model := &Model{}
+	start := time.Now()
+	for i := 0; i < 2000; i++ {
+		model.Train(browse(rng, start).Features(time.Minute))
+	}
+	return model
+}
+
+func TestModelScoresDeviations(t *testing.T) {
+	model := trainedModel(t)
+	rng := rand.New(rand.NewSource(2))
+
+	normal := 0
+	for i := 0; i < 100; i++ {
+		if model.Score(browse(rng, time.Now()).Features(time.Minute)).Score > 0 {
+			normal++
+		}
+	}
+	if normal > 5 {
+		t.Errorf("%d of 100 normal visits scored as anomalous", normal)
+	}
+
+	// A script hammering one endpoint on a timer
+	script := NewWindow(time.Now())
+	for i := 0; i < 600; i++ {
+		script.Observe(script.Start.Add(time.Duration(i)*100*time.Millisecond), "/login")
+	}
+	if d := model.Score(script.Features(time.Minute)); d.Score < 1 {
+		t.Errorf("script scored %+v", d)
+	}
+}
+
+func TestModelSaveLoad(t *testing.T) {
+	model := trainedModel(t)
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := model.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Samples != model.Samples || loaded.Mean != model.Mean || loaded.M2 != model.M2 {
+		t.Errorf("loaded %+v, saved %+v", loaded, model)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	ip, ts, path, err := ParseLogLine(`203.0.113.9 - - [10/Oct/2023:13:55:36 -0700] "GET /search?q=x HTTP/1.1" 200 2326 "-" "Mozilla/5.0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "203.0.113.9" || path != "/search" || ts.Unix() != 1696971336 {
+		t.Errorf("parsed %s %v %s", ip, ts, path)
+	}
+	if _, _, _, err := ParseLogLine("garbage"); err == nil {
+		t.Error("parsed a line that is not an access log entry")
+	}
+}
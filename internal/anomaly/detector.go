@@ -0,0 +1,182 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/botnet"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DetectorName is the anomaly detector's name in the botnet ensemble
+const DetectorName = "anomaly"
+
+var samplesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "ddos_protection_anomaly_model_samples",
+	Help: "Client windows the anomaly baseline has been trained on",
+})
+
+// Config tunes the detector. Clients are judged once their window holds
+// MinRequests, and only once the model has seen MinSamples windows.
+type Config struct {
+	Window      time.Duration
+	MinRequests int
+	MinSamples  int64
+	MaxClients  int
+	Learn       bool
+}
+
+// Status describes the model for the API. Baseline and Spread are in model
+// space, with the request rate as log(1 + requests per minute).
+type Status struct {
+	Samples  int64              `json:"samples"`
+	Ready    bool               `json:"ready"`
+	Learning bool               `json:"learning"`
+	Updated  time.Time          `json:"updated,omitempty"`
+	Clients  int                `json:"clients"`
+	Baseline map[string]float64 `json:"baseline"`
+	Spread   map[string]float64 `json:"spread"`
+}
+
+// Detector scores requests by how far their client's current window
+// deviates from the model, and trains the model on windows that finish
+// while traffic is calm. It implements botnet.Detector.
+type Detector struct {
+	config  Config
+	model   *Model
+	clients map[string]*Window
+	calm    func() bool
+	mu      sync.Mutex
+}
+
+// NewDetector creates a detector around model, which may be untrained
+func NewDetector(model *Model, config Config) *Detector {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 10
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = 1000
+	}
+	if config.MaxClients <= 0 {
+		config.MaxClients = 100000
+	}
+	samplesGauge.Set(float64(model.Samples))
+	return &Detector{
+		config:  config,
+		model:   model,
+		clients: make(map[string]*Window),
+		calm:    func() bool { return true },
+	}
+}
+
+// SetCalm sets how the detector tells calm periods, the only ones it
+// learns from
+func (d *Detector) SetCalm(calm func() bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calm = calm
+}
+
+// Name returns DetectorName
+func (d *Detector) Name() string {
+	return DetectorName
+}
+
+// AnalyzeRequest adds the request to its client's window and scores the
+// window against the model
+func (d *Detector) AnalyzeRequest(ctx context.Context, req botnet.Request) *botnet.BotnetAnalysis {
+	now := time.Now()
+	analysis := &botnet.BotnetAnalysis{IP: req.IP, Timestamp: now, Indicators: []string{}}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, exists := d.clients[req.IP]
+	if exists && now.Sub(w.Start) >= d.config.Window {
+		d.finish(w)
+		exists = false
+	}
+	if !exists {
+		if len(d.clients) >= d.config.MaxClients {
+			return analysis
+		}
+		w = NewWindow(now)
+		d.clients[req.IP] = w
+	}
+	w.Observe(now, req.Path)
+
+	if w.Requests < d.config.MinRequests || d.model.Samples < d.config.MinSamples {
+		return analysis
+	}
+	deviation := d.model.Score(w.Features(d.config.Window))
+	if deviation.Score > 0 {
+		analysis.Confidence = deviation.Score
+		analysis.RiskScore = int(deviation.Score * 100)
+		analysis.Indicators = append(analysis.Indicators,
+			fmt.Sprintf("Traffic deviates from learned baseline: %s %.2f (z=%.1f)", deviation.Feature, deviation.Value, deviation.Z))
+		analysis.Signals = []string{DetectorName}
+	}
+	return analysis
+}
+
+// finish trains the model on a completed window if traffic is calm; d.mu
+// must be held
+func (d *Detector) finish(w *Window) {
+	if d.config.Learn && w.Requests >= d.config.MinRequests && d.calm() {
+		d.model.Train(w.Features(d.config.Window))
+		samplesGauge.Set(float64(d.model.Samples))
+	}
+}
+
+// Cleanup finishes the windows of clients idle for a whole window and
+// returns how many were dropped
+func (d *Detector) Cleanup(now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := 0
+	for ip, w := range d.clients {
+		if now.Sub(w.Start) >= d.config.Window {
+			d.finish(w)
+			delete(d.clients, ip)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Save writes a copy of the model to path
+func (d *Detector) Save(path string) error {
+	d.mu.Lock()
+	model := *d.model
+	d.mu.Unlock()
+	return model.Save(path)
+}
+
+// Status describes the model
+func (d *Detector) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := Status{
+		Samples:  d.model.Samples,
+		Ready:    d.model.Samples >= d.config.MinSamples,
+		Learning: d.config.Learn,
+		Updated:  d.model.Updated,
+		Clients:  len(d.clients),
+		Baseline: make(map[string]float64, NumFeatures),
+		Spread:   make(map[string]float64, NumFeatures),
+	}
+	for i, name := range FeatureNames {
+		status.Baseline[name] = d.model.Mean[i]
+		status.Spread[name] = d.model.StdDev(i)
+	}
+	return status
+}
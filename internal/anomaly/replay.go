@@ -0,0 +1,90 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// logTimeLayout is the timestamp format of Common and Combined Log Format
+const logTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// ParseLogLine reads the client IP, time and path of a request from a
+// Common or Combined Log Format line
+func ParseLogLine(line string) (ip string, t time.Time, path string, err error) {
+	fields := strings.SplitN(line, " ", 2)
+	open := strings.IndexByte(line, '[')
+	end := strings.IndexByte(line, ']')
+	quote := strings.IndexByte(line, '"')
+	if len(fields) < 2 || open < 0 || end < open || quote < end {
+		return "", time.Time{}, "", fmt.Errorf("not an access log line: %q", line)
+	}
+
+	t, err = time.Parse(logTimeLayout, line[open+1:end])
+	if err != nil {
+		return "", time.Time{}, "", fmt.Errorf("invalid time in access log line: %q", line)
+	}
+	request := strings.Fields(line[quote+1:])
+	if len(request) < 2 {
+		return "", time.Time{}, "", fmt.Errorf("no request in access log line: %q", line)
+	}
+	path = strings.SplitN(request[1], "?", 2)[0]
+	return fields[0], t, path, nil
+}
+
+// Replayer splits recorded requests into client windows, as the detector
+// does live, and hands each finished window with at least minRequests to
+// emit. Requests must come in time order.
+type Replayer struct {
+	window      time.Duration
+	minRequests int
+	clients     map[string]*Window
+	emit        func(ip string, f Features)
+}
+
+// NewReplayer creates a replayer with the detector's window settings
+func NewReplayer(window time.Duration, minRequests int, emit func(ip string, f Features)) *Replayer {
+	return &Replayer{
+		window:      window,
+		minRequests: minRequests,
+		clients:     make(map[string]*Window),
+		emit:        emit,
+	}
+}
+
+// Observe adds a request, finishing windows that have ended by then
+func (r *Replayer) Observe(ip string, t time.Time, path string) {
+	w, exists := r.clients[ip]
+	if exists && t.Sub(w.Start) >= r.window {
+		r.finish(ip, w)
+		exists = false
+	}
+	if !exists {
+		w = NewWindow(t)
+		r.clients[ip] = w
+	}
+	w.Observe(t, path)
+
+	// Keep memory bounded on long logs
+	if len(r.clients) > 100000 {
+		for ip, w := range r.clients {
+			if t.Sub(w.Start) >= r.window {
+				r.finish(ip, w)
+			}
+		}
+	}
+}
+
+// Flush finishes all open windows
+func (r *Replayer) Flush() {
+	for ip, w := range r.clients {
+		r.finish(ip, w)
+	}
+}
+
+func (r *Replayer) finish(ip string, w *Window) {
+	delete(r.clients, ip)
+	if w.Requests >= r.minRequests {
+		r.emit(ip, w.Features(r.window))
+	}
+}
@@ -0,0 +1,567 @@
+// Package apikey issues API keys scoped to paths and methods, each with its
+// own rate limit and request quota, so protected backends can be exposed to
+// named consumers. Only a SHA-256 hash of each key's secret is stored; the
+// key itself is shown once, when it is created or rotated.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/time/rate"
+)
+
+const (
+	redisHashKey     = "apikeys"
+	redisChannel     = "apikeys:updates"
+	redisQuotaPrefix = "apikey:quota:"
+
+	// tokenPrefix starts every key, so leaked keys are easy to scan for
+	tokenPrefix = "dk_"
+)
+
+// Quota periods
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Errors returned when a key is refused
+var (
+	ErrNotFound      = errors.New("API key not found")
+	ErrInvalid       = errors.New("invalid API key")
+	ErrRevoked       = errors.New("API key revoked")
+	ErrExpired       = errors.New("API key expired")
+	ErrScope         = errors.New("API key not valid for this request")
+	ErrRateLimited   = errors.New("API key rate limit exceeded")
+	ErrQuotaExceeded = errors.New("API key quota exceeded")
+)
+
+// Key is an issued API key. Paths are path prefixes and Methods HTTP
+// methods the key may be used for; empty lists allow any. A zero rate limit
+// leaves the client to the per-IP limit, and a zero quota is unlimited.
+type Key struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Paths             []string   `json:"paths,omitempty"`
+	Methods           []string   `json:"methods,omitempty"`
+	RequestsPerMinute int        `json:"requests_per_minute,omitempty"`
+	BurstSize         int        `json:"burst_size,omitempty"`
+	Quota             int64      `json:"quota,omitempty"`
+	QuotaPeriod       string     `json:"quota_period,omitempty"`
+	Created           time.Time  `json:"created"`
+	Expires           *time.Time `json:"expires,omitempty"`
+	Rotated           *time.Time `json:"rotated,omitempty"`
+	Revoked           *time.Time `json:"revoked,omitempty"`
+}
+
+// Spec describes a key to create. TTL is zero for keys that never expire.
+type Spec struct {
+	Name              string
+	Paths             []string
+	Methods           []string
+	RequestsPerMinute int
+	BurstSize         int
+	Quota             int64
+	QuotaPeriod       string
+	TTL               time.Duration
+}
+
+// Usage counts a key's requests on this node. QuotaUsed is the count for
+// the current quota period, shared across nodes when Redis is available.
+type Usage struct {
+	Requests    int64      `json:"requests"`
+	Denied      int64      `json:"denied"`
+	QuotaUsed   int64      `json:"quota_used"`
+	QuotaResets *time.Time `json:"quota_resets,omitempty"`
+	LastUsed    *time.Time `json:"last_used,omitempty"`
+}
+
+// KeyInfo is a key with its usage
+type KeyInfo struct {
+	Key
+	Usage Usage `json:"usage"`
+}
+
+// record is a key as persisted. During a rotation grace period the
+// previous secret keeps working until PreviousUntil.
+type record struct {
+	Key
+	Hash          string    `json:"hash"`
+	PreviousHash  string    `json:"previous_hash,omitempty"`
+	PreviousUntil time.Time `json:"previous_until,omitempty"`
+}
+
+// state is a key with its limiter and usage on this node
+type state struct {
+	record
+	limiter     *rate.Limiter
+	usage       Usage
+	quotaPeriod time.Time
+}
+
+// Store holds issued keys and keeps them in step across instances through
+// Redis. It is safe for concurrent use.
+type Store struct {
+	client       *redis.Client
+	syncInterval time.Duration
+	keys         map[string]*state
+	mu           sync.Mutex
+}
+
+// NewStore creates a key store. With a nil client keys and quotas only
+// apply to this instance and are lost on restart.
+func NewStore(client *redis.Client, syncInterval time.Duration) *Store {
+	return &Store{
+		client:       client,
+		syncInterval: syncInterval,
+		keys:         make(map[string]*state),
+	}
+}
+
+// Create issues a key and returns it with the token clients present
+func (s *Store) Create(ctx context.Context, spec Spec) (Key, string, error) {
+	if err := spec.validate(); err != nil {
+		return Key{}, "", err
+	}
+
+	now := time.Now()
+	rec := record{Key: Key{
+		ID:                randomHex(8),
+		Name:              spec.Name,
+		Paths:             spec.Paths,
+		Methods:           normalizeMethods(spec.Methods),
+		RequestsPerMinute: spec.RequestsPerMinute,
+		BurstSize:         spec.BurstSize,
+		Quota:             spec.Quota,
+		QuotaPeriod:       spec.QuotaPeriod,
+		Created:           now,
+	}}
+	if rec.Quota > 0 && rec.QuotaPeriod == "" {
+		rec.QuotaPeriod = PeriodDay
+	}
+	if spec.TTL > 0 {
+		expires := now.Add(spec.TTL)
+		rec.Expires = &expires
+	}
+	secret := newSecret()
+	rec.Hash = hashSecret(secret)
+
+	if err := s.save(ctx, rec); err != nil {
+		return Key{}, "", err
+	}
+	return rec.Key, tokenPrefix + rec.ID + "_" + secret, nil
+}
+
+func (spec *Spec) validate() error {
+	if strings.TrimSpace(spec.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, path := range spec.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q must start with /", path)
+		}
+	}
+	if spec.RequestsPerMinute < 0 || spec.BurstSize < 0 || spec.Quota < 0 || spec.TTL < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	switch spec.QuotaPeriod {
+	case "", PeriodDay, PeriodMonth:
+	default:
+		return fmt.Errorf("quota period must be %s or %s", PeriodDay, PeriodMonth)
+	}
+	return nil
+}
+
+// Rotate issues a new secret for a key and returns the new token. The old
+// token keeps working for grace, so clients can switch over without
+// downtime.
+func (s *Store) Rotate(ctx context.Context, id string, grace time.Duration) (Key, string, error) {
+	s.mu.Lock()
+	st, exists := s.keys[id]
+	if !exists {
+		s.mu.Unlock()
+		return Key{}, "", ErrNotFound
+	}
+	rec := st.record
+	s.mu.Unlock()
+
+	if rec.Revoked != nil {
+		return Key{}, "", ErrRevoked
+	}
+
+	now := time.Now()
+	rec.PreviousHash, rec.PreviousUntil = "", time.Time{}
+	if grace > 0 {
+		rec.PreviousHash, rec.PreviousUntil = rec.Hash, now.Add(grace)
+	}
+	secret := newSecret()
+	rec.Hash = hashSecret(secret)
+	rec.Rotated = &now
+
+	if err := s.save(ctx, rec); err != nil {
+		return Key{}, "", err
+	}
+	return rec.Key, tokenPrefix + rec.ID + "_" + secret, nil
+}
+
+// Revoke disables a key immediately. Revoked keys are kept so their usage
+// can still be inspected.
+func (s *Store) Revoke(ctx context.Context, id string) (Key, error) {
+	s.mu.Lock()
+	st, exists := s.keys[id]
+	if !exists {
+		s.mu.Unlock()
+		return Key{}, ErrNotFound
+	}
+	rec := st.record
+	s.mu.Unlock()
+
+	if rec.Revoked == nil {
+		now := time.Now()
+		rec.Revoked = &now
+		rec.PreviousHash, rec.PreviousUntil = "", time.Time{}
+	}
+	if err := s.save(ctx, rec); err != nil {
+		return Key{}, err
+	}
+	return rec.Key, nil
+}
+
+// Get returns a key and its usage
+func (s *Store) Get(id string) (KeyInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, exists := s.keys[id]
+	if !exists {
+		return KeyInfo{}, false
+	}
+	return st.info(), true
+}
+
+// List returns every key with its usage, oldest first
+func (s *Store) List() []KeyInfo {
+	s.mu.Lock()
+	result := make([]KeyInfo, 0, len(s.keys))
+	for _, st := range s.keys {
+		result = append(result, st.info())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result
+}
+
+func (st *state) info() KeyInfo {
+	info := KeyInfo{Key: st.Key, Usage: st.usage}
+	if st.Quota > 0 && !st.quotaPeriod.IsZero() {
+		resets := periodEnd(st.QuotaPeriod, st.quotaPeriod)
+		info.Usage.QuotaResets = &resets
+	}
+	return info
+}
+
+// Authorize checks that token is a valid key for a request and counts the
+// request against the key's rate limit and quota. Quotas fail open when
+// Redis cannot be reached.
+func (s *Store) Authorize(ctx context.Context, token, method, path string) (Key, error) {
+	id, secret, ok := parseToken(token)
+	if !ok {
+		return Key{}, ErrInvalid
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	st, exists := s.keys[id]
+	if !exists {
+		s.mu.Unlock()
+		return Key{}, ErrInvalid
+	}
+	key, err := st.check(secret, method, path, now)
+	if err == ErrInvalid {
+		s.mu.Unlock()
+		return Key{}, err
+	}
+	st.usage.LastUsed = &now
+	if err == nil && st.limiter != nil && !st.limiter.AllowN(now, 1) {
+		err = ErrRateLimited
+	}
+	shared := err == nil && key.Quota > 0 && s.client != nil
+	if err == nil && key.Quota > 0 && s.client == nil {
+		start := periodStart(key.QuotaPeriod, now)
+		st.setQuota(start, st.quotaUsed(start)+1)
+		if st.usage.QuotaUsed > key.Quota {
+			err = ErrQuotaExceeded
+		}
+	}
+	if !shared {
+		st.count(err)
+	}
+	s.mu.Unlock()
+	if !shared {
+		return key, err
+	}
+
+	start := periodStart(key.QuotaPeriod, now)
+	used, qerr := s.incrQuota(ctx, id, key.QuotaPeriod, start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if qerr == nil {
+		st.setQuota(start, used)
+		if used > key.Quota {
+			err = ErrQuotaExceeded
+		}
+	}
+	st.count(err)
+	return key, err
+}
+
+// count records the outcome of a request; s.mu must be held
+func (st *state) count(err error) {
+	if err != nil {
+		st.usage.Denied++
+	} else {
+		st.usage.Requests++
+	}
+}
+
+// quotaUsed returns the quota count of the period starting at start
+func (st *state) quotaUsed(start time.Time) int64 {
+	if !st.quotaPeriod.Equal(start) {
+		return 0
+	}
+	return st.usage.QuotaUsed
+}
+
+func (st *state) setQuota(start time.Time, used int64) {
+	st.quotaPeriod = start
+	st.usage.QuotaUsed = used
+}
+
+// check verifies a secret and the key's validity and scope; s.mu must be
+// held
+func (st *state) check(secret, method, path string, now time.Time) (Key, error) {
+	hash := hashSecret(secret)
+	valid := subtle.ConstantTimeCompare([]byte(hash), []byte(st.Hash)) == 1
+	if !valid && st.PreviousHash != "" && now.Before(st.PreviousUntil) {
+		valid = subtle.ConstantTimeCompare([]byte(hash), []byte(st.PreviousHash)) == 1
+	}
+	if !valid {
+		return Key{}, ErrInvalid
+	}
+
+	key := st.Key
+	switch {
+	case key.Revoked != nil:
+		return key, ErrRevoked
+	case key.Expires != nil && !now.Before(*key.Expires):
+		return key, ErrExpired
+	case !key.Allows(method, path):
+		return key, ErrScope
+	}
+	return key, nil
+}
+
+// Allows reports whether the key's scopes cover a request
+func (k *Key) Allows(method, path string) bool {
+	if len(k.Methods) > 0 {
+		allowed := false
+		for _, m := range k.Methods {
+			if strings.EqualFold(m, method) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if len(k.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range k.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// incrQuota counts a request against a key's quota in Redis, shared across
+// nodes, and returns the count of the period starting at start
+func (s *Store) incrQuota(ctx context.Context, id, period string, start time.Time) (int64, error) {
+	redisKey := redisQuotaPrefix + id + ":" + strconv.FormatInt(start.Unix(), 10)
+	used, err := s.client.Incr(ctx, redisKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if used == 1 {
+		s.client.ExpireAt(ctx, redisKey, periodEnd(period, start))
+	}
+	return used, nil
+}
+
+// periodStart returns the start of the quota period containing t, in UTC
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == PeriodMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// periodEnd returns the end of the quota period starting at start
+func periodEnd(period string, start time.Time) time.Time {
+	if period == PeriodMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// save stores a key locally and in Redis, and tells other instances
+func (s *Store) save(ctx context.Context, rec record) error {
+	s.apply(rec)
+
+	if s.client == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, redisHashKey, rec.ID, data).Err(); err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, redisChannel, rec.ID).Err()
+}
+
+// apply stores a key locally, keeping its limiter and usage when it already
+// exists and its limits are unchanged
+func (s *Store) apply(rec record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, exists := s.keys[rec.ID]
+	if !exists {
+		st = &state{}
+		s.keys[rec.ID] = st
+	}
+	if !exists || st.RequestsPerMinute != rec.RequestsPerMinute || st.BurstSize != rec.BurstSize {
+		st.limiter = newLimiter(rec.RequestsPerMinute, rec.BurstSize)
+	}
+	st.record = rec
+}
+
+func newLimiter(requestsPerMinute, burst int) *rate.Limiter {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	return rate.NewLimiter(rate.Limit(requestsPerMinute)/60.0, burst)
+}
+
+// Sync loads the cluster-wide keys from Redis
+func (s *Store) Sync(ctx context.Context) error {
+	if s.client == nil {
+		return nil
+	}
+
+	entries, err := s.client.HGetAll(ctx, redisHashKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, data := range entries {
+		var rec record
+		if err := json.Unmarshal([]byte(data), &rec); err != nil || rec.ID == "" {
+			continue
+		}
+		s.apply(rec)
+	}
+	return nil
+}
+
+// Run keeps the local keys in sync until ctx is cancelled. Updates are
+// pushed over pub/sub; the periodic sync covers missed messages.
+func (s *Store) Run(ctx context.Context) {
+	if s.client == nil {
+		return
+	}
+
+	pubsub := s.client.Subscribe(ctx, redisChannel)
+	defer pubsub.Close()
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	s.Sync(ctx)
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-messages:
+			s.Sync(ctx)
+		case <-ticker.C:
+			s.Sync(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseToken splits a token into its key ID and secret
+func parseToken(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, tokenPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func normalizeMethods(methods []string) []string {
+	result := make([]string, 0, len(methods))
+	for _, m := range methods {
+		result = append(result, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	return result
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package apikey
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeScopesAndLimits(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(nil, time.Minute)
+
+	key, token, err := s.Create(ctx, Spec{
+		Name:              "partner",
+		Paths:             []string{"/api/orders"},
+		Methods:           []string{"get"},
+		RequestsPerMinute: 60,
+		BurstSize:         3,
+		Quota:             100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.QuotaPeriod != PeriodDay {
+		t.Errorf("quota period = %q, want day by default", key.QuotaPeriod)
+	}
+
+	if _, err := s.Authorize(ctx, token, "GET", "/api/orders/7"); err != nil {
+		t.Fatalf("valid request refused: %v", err)
+	}
+	if _, err := s.Authorize(ctx, token, "POST", "/api/orders"); err != ErrScope {
+		t.Errorf("method outside scope: err = %v, want ErrScope", err)
+	}
+	if _, err := s.Authorize(ctx, token, "GET", "/admin"); err != ErrScope {
+		t.Errorf("path outside scope: err = %v, want ErrScope", err)
+	}
+	if _, err := s.Authorize(ctx, token+"x", "GET", "/api/orders"); err != ErrInvalid {
+		t.Errorf("wrong secret: err = %v, want ErrInvalid", err)
+	}
+	if _, err := s.Authorize(ctx, "dk_missing_secret", "GET", "/api/orders"); err != ErrInvalid {
+		t.Errorf("unknown key: err = %v, want ErrInvalid", err)
+	}
+
+	// One token was spent above; the burst allows two more
+	s.Authorize(ctx, token, "GET", "/api/orders")
+	s.Authorize(ctx, token, "GET", "/api/orders")
+	if _, err := s.Authorize(ctx, token, "GET", "/api/orders"); err != ErrRateLimited {
+		t.Errorf("burst exhausted: err = %v, want ErrRateLimited", err)
+	}
+
+	info, _ := s.Get(key.ID)
+	if info.Usage.Requests != 3 || info.Usage.Denied != 3 || info.Usage.QuotaUsed != 3 {
+		t.Errorf("usage = %+v, want 3 requests, 3 denied, 3 counted against the quota", info.Usage)
+	}
+	if info.Usage.QuotaResets == nil || !info.Usage.QuotaResets.After(time.Now()) {
+		t.Errorf("quota reset time = %v, want the end of today", info.Usage.QuotaResets)
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(nil, time.Minute)
+
+	_, token, _ := s.Create(ctx, Spec{Name: "trial", Quota: 2, QuotaPeriod: PeriodMonth})
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authorize(ctx, token, "GET", "/"); err != nil {
+			t.Fatalf("request %d refused: %v", i, err)
+		}
+	}
+	if _, err := s.Authorize(ctx, token, "GET", "/"); err != ErrQuotaExceeded {
+		t.Errorf("err = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestRotateAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(nil, time.Minute)
+
+	key, oldToken, _ := s.Create(ctx, Spec{Name: "ci"})
+	_, newToken, err := s.Rotate(ctx, key.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := s.Authorize(ctx, token, "GET", "/"); err != nil {
+			t.Errorf("token should work during the grace period: %v", err)
+		}
+	}
+
+	if _, _, err := s.Rotate(ctx, key.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authorize(ctx, newToken, "GET", "/"); err != ErrInvalid {
+		t.Errorf("rotation without grace should retire the old token at once, err = %v", err)
+	}
+
+	_, token, _ := s.Rotate(ctx, key.ID, 0)
+	if _, err := s.Revoke(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authorize(ctx, token, "GET", "/"); err != ErrRevoked {
+		t.Errorf("err = %v, want ErrRevoked", err)
+	}
+	if _, _, err := s.Rotate(ctx, key.ID, 0); err != ErrRevoked {
+		t.Errorf("rotating a revoked key: err = %v, want ErrRevoked", err)
+	}
+}
+
+func TestCreateValidatesSpec(t *testing.T) {
+	s := NewStore(nil, time.Minute)
+	for _, spec := range []Spec{
+		{},
+		{Name: "x", Paths: []string{"api"}},
+		{Name: "x", Quota: -1},
+		{Name: "x", Quota: 10, QuotaPeriod: "week"},
+	} {
+		if _, _, err := s.Create(context.Background(), spec); err == nil {
+			t.Errorf("spec %+v should be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package appeal lets banned clients lift their own ban. A blocked client is
+// given a signed, expiring token bound to its IP; presenting it together
+// with a solved CAPTCHA earns an unban. Appeals are counted per IP so a
+// client cannot appeal its way out of every ban.
+package appeal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisCountPrefix prefixes the per-IP appeal counters
+const redisCountPrefix = "appeal:count:"
+
+// Token errors
+var (
+	ErrInvalidToken = errors.New("invalid appeal token")
+	ErrExpiredToken = errors.New("appeal token expired")
+	ErrWrongClient  = errors.New("appeal token was issued to another client")
+)
+
+// Signer issues and verifies appeal tokens
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSigner returns a signer whose tokens are valid for ttl
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+// Issue returns a token for ip
+func (s *Signer) Issue(ip string, now time.Time) string {
+	payload := ip + "|" + strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.sign(payload)
+}
+
+// Verify checks that token is genuine, unexpired and was issued to ip
+func (s *Signer) Verify(token, ip string, now time.Time) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return ErrInvalidToken
+	}
+
+	tokenIP, expiry, ok := strings.Cut(payload, "|")
+	if !ok {
+		return ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if now.Unix() >= expires {
+		return ErrExpiredToken
+	}
+	if tokenIP != ip {
+		return ErrWrongClient
+	}
+	return nil
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Limiter caps appeals per IP within a window. Counts are kept in Redis so
+// every instance enforces the same cap, or locally without it.
+type Limiter struct {
+	client *redis.Client
+	max    int
+	window time.Duration
+
+	mu     sync.Mutex
+	counts map[string]*count
+}
+
+type count struct {
+	n     int
+	until time.Time
+}
+
+// NewLimiter returns a limiter allowing max appeals per IP within window
+func NewLimiter(client *redis.Client, max int, window time.Duration) *Limiter {
+	return &Limiter{
+		client: client,
+		max:    max,
+		window: window,
+		counts: make(map[string]*count),
+	}
+}
+
+// Allow counts an appeal from ip and reports whether it is within the cap
+func (l *Limiter) Allow(ctx context.Context, ip string) bool {
+	if l.client != nil {
+		key := redisCountPrefix + ip
+		n, err := l.client.Incr(ctx, key).Result()
+		if err == nil {
+			if n == 1 {
+				l.client.Expire(ctx, key, l.window)
+			}
+			return n <= int64(l.max)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c, exists := l.counts[ip]
+	if !exists || now.After(c.until) {
+		c = &count{until: now.Add(l.window)}
+		l.counts[ip] = c
+	}
+	c.n++
+	return c.n <= l.max
+}
+
+// Cleanup drops local counts whose window has passed
+func (l *Limiter) Cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, c := range l.counts {
+		if now.After(c.until) {
+			delete(l.counts, ip)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package appeal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerVerify(t *testing.T) {
+	now := time.Now()
+	s := NewSigner([]byte("secret"), time.Hour)
+	token := s.Issue("192.0.2.1", now)
+
+	if err := s.Verify(token, "192.0.2.1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if err := s.Verify(token, "192.0.2.2", now); err != ErrWrongClient {
+		t.Errorf("token used by another client: got %v, want ErrWrongClient", err)
+	}
+	if err := s.Verify(token, "192.0.2.1", now.Add(2*time.Hour)); err != ErrExpiredToken {
+		t.Errorf("expired token: got %v, want ErrExpiredToken", err)
+	}
+	if err := NewSigner([]byte("other"), time.Hour).Verify(token, "192.0.2.1", now); err != ErrInvalidToken {
+		t.Errorf("token signed with another secret: got %v, want ErrInvalidToken", err)
+	}
+
+	// A longer lived payload cannot borrow the signature of a valid token
+	later, _, _ := strings.Cut(s.Issue("192.0.2.1", now.Add(24*time.Hour)), ".")
+	_, signature, _ := strings.Cut(token, ".")
+	if err := s.Verify(later+"."+signature, "192.0.2.1", now); err != ErrInvalidToken {
+		t.Errorf("payload with another token's signature: got %v, want ErrInvalidToken", err)
+	}
+	for _, bad := range []string{"", "no-dot", "!!!.sig"} {
+		if err := s.Verify(bad, "192.0.2.1", now); err != ErrInvalidToken {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidToken", bad, err)
+		}
+	}
+}
+
+func TestLimiterLocal(t *testing.T) {
+	l := NewLimiter(nil, 2, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if !l.Allow(ctx, "192.0.2.1") {
+			t.Fatalf("appeal %d refused", i+1)
+		}
+	}
+	if l.Allow(ctx, "192.0.2.1") {
+		t.Error("third appeal allowed")
+	}
+	if !l.Allow(ctx, "192.0.2.2") {
+		t.Error("other client refused")
+	}
+
+	l.Cleanup(time.Now().Add(2 * time.Hour))
+	if !l.Allow(ctx, "192.0.2.1") {
+		t.Error("appeal refused after the window passed")
+	}
+}
@@ -0,0 +1,246 @@
+// Package attackcost estimates what blocking traffic saves the upstream: the
+// requests it never saw, the CPU time they would have taken and the bytes
+// they would have sent back. The cost of a request is learned from the
+// requests that are served, and savings are accumulated per incident so
+// reports can show what each attack would have cost without protection.
+package attackcost
+
+import (
+	"sync"
+	"time"
+
+	"ddos-protection/internal/authcorr"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// smoothing is the weight of each served request in the learned averages
+const smoothing = 0.01
+
+var (
+	upstreamPreventedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_attack_upstream_requests_prevented_total",
+		Help: "Requests blocked before reaching the upstream",
+	})
+	cpuSavedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_attack_cpu_seconds_saved_total",
+		Help: "Estimated upstream CPU seconds saved by blocking requests",
+	})
+	egressSavedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_attack_egress_bytes_saved_total",
+		Help: "Estimated response bytes not sent because requests were blocked",
+	})
+	absorbedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_attack_cpu_seconds_absorbed_total",
+		Help: "Seconds the protection spent rejecting blocked requests",
+	})
+)
+
+// Totals are the costs accumulated over a period
+type Totals struct {
+	RequestsServed     int64   `json:"requests_served"`
+	RequestsPrevented  int64   `json:"upstream_requests_prevented"`
+	CPUSecondsSaved    float64 `json:"cpu_seconds_saved"`
+	EgressBytesSaved   int64   `json:"egress_bytes_saved"`
+	CPUSecondsAbsorbed float64 `json:"cpu_seconds_absorbed"`
+}
+
+// LoadFactor is how many times its actual load the upstream would have
+// handled without protection, or 0 if nothing was served
+func (t Totals) LoadFactor() float64 {
+	if t.RequestsServed == 0 {
+		return 0
+	}
+	return float64(t.RequestsServed+t.RequestsPrevented) / float64(t.RequestsServed)
+}
+
+// Incident is the cost absorbed during one attack. End is nil while it is
+// in progress. Accounts is the impact on user accounts, when authentication
+// events are correlated.
+type Incident struct {
+	ID         int              `json:"id"`
+	Reason     string           `json:"reason"`
+	Start      time.Time        `json:"start"`
+	End        *time.Time       `json:"end,omitempty"`
+	Totals     Totals           `json:"totals"`
+	LoadFactor float64          `json:"load_factor"`
+	Accounts   *authcorr.Impact `json:"accounts,omitempty"`
+}
+
+// Model is the estimated cost of serving one request
+type Model struct {
+	CPUSecondsPerRequest float64 `json:"cpu_seconds_per_request"`
+	BytesPerResponse     float64 `json:"bytes_per_response"`
+	Learned              bool    `json:"learned"`
+}
+
+// Report is the cost model, the totals since startup, the incident in
+// progress if any, and past incidents, most recent first
+type Report struct {
+	Model     Model      `json:"model"`
+	Totals    Totals     `json:"totals"`
+	Current   *Incident  `json:"current,omitempty"`
+	Incidents []Incident `json:"incidents"`
+}
+
+// Estimator learns the cost of serving a request and accumulates the cost
+// of the requests that were blocked
+type Estimator struct {
+	cpuPerRequest    float64
+	bytesPerResponse float64
+	avgSeconds       float64
+	avgBytes         float64
+	learned          bool
+	totals           Totals
+	current          *Incident
+	history          []Incident
+	maxHistory       int
+	nextID           int
+	mu               sync.Mutex
+}
+
+// NewEstimator creates an estimator keeping the last maxHistory incidents.
+// A positive cpuPerRequest or bytesPerResponse fixes that part of the cost
+// instead of learning it from served requests.
+func NewEstimator(cpuPerRequest time.Duration, bytesPerResponse int64, maxHistory int) *Estimator {
+	return &Estimator{
+		cpuPerRequest:    cpuPerRequest.Seconds(),
+		bytesPerResponse: float64(bytesPerResponse),
+		maxHistory:       maxHistory,
+		nextID:           1,
+	}
+}
+
+// Served records a request the upstream handled in elapsed, answering with
+// bytes of body
+func (e *Estimator) Served(elapsed time.Duration, bytes int) {
+	if bytes < 0 {
+		bytes = 0
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.learned {
+		e.avgSeconds = elapsed.Seconds()
+		e.avgBytes = float64(bytes)
+		e.learned = true
+	} else {
+		e.avgSeconds += smoothing * (elapsed.Seconds() - e.avgSeconds)
+		e.avgBytes += smoothing * (float64(bytes) - e.avgBytes)
+	}
+
+	e.totals.RequestsServed++
+	if e.current != nil {
+		e.current.Totals.RequestsServed++
+	}
+}
+
+// Blocked records a request rejected before the upstream, after the
+// protection spent elapsed on it and sent bytes of body
+func (e *Estimator) Blocked(elapsed time.Duration, bytes int) {
+	if bytes < 0 {
+		bytes = 0
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	model := e.modelLocked()
+	egress := int64(model.BytesPerResponse) - int64(bytes)
+	if egress < 0 {
+		egress = 0
+	}
+	add := Totals{
+		RequestsPrevented:  1,
+		CPUSecondsSaved:    model.CPUSecondsPerRequest,
+		EgressBytesSaved:   egress,
+		CPUSecondsAbsorbed: elapsed.Seconds(),
+	}
+	e.totals.add(add)
+	if e.current != nil {
+		e.current.Totals.add(add)
+	}
+
+	upstreamPreventedCounter.Inc()
+	cpuSavedCounter.Add(add.CPUSecondsSaved)
+	egressSavedCounter.Add(float64(add.EgressBytesSaved))
+	absorbedCounter.Add(add.CPUSecondsAbsorbed)
+}
+
+func (t *Totals) add(o Totals) {
+	t.RequestsServed += o.RequestsServed
+	t.RequestsPrevented += o.RequestsPrevented
+	t.CPUSecondsSaved += o.CPUSecondsSaved
+	t.EgressBytesSaved += o.EgressBytesSaved
+	t.CPUSecondsAbsorbed += o.CPUSecondsAbsorbed
+}
+
+// StartIncident begins accumulating a new incident unless one is already
+// in progress
+func (e *Estimator) StartIncident(reason string, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil {
+		return
+	}
+	e.current = &Incident{ID: e.nextID, Reason: reason, Start: now}
+	e.nextID++
+}
+
+// EndIncident closes the incident in progress, if any, and moves it to the
+// history
+func (e *Estimator) EndIncident(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current == nil {
+		return
+	}
+	incident := *e.current
+	incident.End = &now
+	incident.LoadFactor = incident.Totals.LoadFactor()
+	e.current = nil
+
+	e.history = append([]Incident{incident}, e.history...)
+	if e.maxHistory > 0 && len(e.history) > e.maxHistory {
+		e.history = e.history[:e.maxHistory]
+	}
+}
+
+// Report returns the current cost model, totals and incidents
+func (e *Estimator) Report() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := Report{
+		Model:     e.modelLocked(),
+		Totals:    e.totals,
+		Incidents: append([]Incident{}, e.history...),
+	}
+	if e.current != nil {
+		current := *e.current
+		current.LoadFactor = current.Totals.LoadFactor()
+		report.Current = &current
+	}
+	return report
+}
+
+// modelLocked returns the configured costs, falling back to the learned
+// averages
+func (e *Estimator) modelLocked() Model {
+	model := Model{
+		CPUSecondsPerRequest: e.avgSeconds,
+		BytesPerResponse:     e.avgBytes,
+		Learned:              e.learned,
+	}
+	if e.cpuPerRequest > 0 {
+		model.CPUSecondsPerRequest = e.cpuPerRequest
+	}
+	if e.bytesPerResponse > 0 {
+		model.BytesPerResponse = e.bytesPerResponse
+	}
+	return model
+}
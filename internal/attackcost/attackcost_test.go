@@ -0,0 +1,67 @@
+package attackcost
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBlockedRequestsCostTheLearnedAverage(t *testing.T) {
+	e := NewEstimator(0, 0, 10)
+	e.Served(200*time.Millisecond, 10000)
+	e.Served(200*time.Millisecond, 10000)
+
+	start := time.Now()
+	e.StartIncident("incident_mode", start)
+	e.Served(200*time.Millisecond, 10000)
+	for i := 0; i < 3; i++ {
+		e.Blocked(time.Millisecond, 100)
+	}
+	e.EndIncident(start.Add(time.Minute))
+	e.Blocked(time.Millisecond, 100)
+
+	report := e.Report()
+	if report.Current != nil || len(report.Incidents) != 1 {
+		t.Fatalf("expected one finished incident, got %+v", report)
+	}
+	incident := report.Incidents[0]
+	if incident.Totals.RequestsPrevented != 3 || incident.Totals.RequestsServed != 1 {
+		t.Errorf("incident totals = %+v", incident.Totals)
+	}
+	if math.Abs(incident.Totals.CPUSecondsSaved-0.6) > 1e-9 {
+		t.Errorf("CPUSecondsSaved = %v, want 0.6", incident.Totals.CPUSecondsSaved)
+	}
+	if incident.Totals.EgressBytesSaved != 3*9900 {
+		t.Errorf("EgressBytesSaved = %d, want %d", incident.Totals.EgressBytesSaved, 3*9900)
+	}
+	if incident.LoadFactor != 4 {
+		t.Errorf("LoadFactor = %v, want 4", incident.LoadFactor)
+	}
+	if report.Totals.RequestsPrevented != 4 || report.Totals.RequestsServed != 3 {
+		t.Errorf("lifetime totals = %+v", report.Totals)
+	}
+}
+
+func TestConfiguredCostOverridesLearned(t *testing.T) {
+	e := NewEstimator(50*time.Millisecond, 0, 10)
+	e.Served(time.Second, 2000)
+
+	model := e.Report().Model
+	if model.CPUSecondsPerRequest != 0.05 || model.BytesPerResponse != 2000 {
+		t.Errorf("model = %+v", model)
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	e := NewEstimator(0, 0, 2)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		e.StartIncident("capacity_mitigation", now)
+		e.EndIncident(now)
+	}
+
+	incidents := e.Report().Incidents
+	if len(incidents) != 2 || incidents[0].ID != 3 || incidents[1].ID != 2 {
+		t.Errorf("incidents = %+v", incidents)
+	}
+}
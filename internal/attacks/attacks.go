@@ -0,0 +1,267 @@
+// Package attacks groups detections, the requests the protection blocked,
+// challenged or slowed, into attacks for post-incident review. An attack
+// starts with a detection and lasts until detections stop for a quiet
+// period; bursts too small to count as an attack are dropped when they end.
+// Finished attacks are kept for a retention period.
+package attacks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/intern"
+)
+
+const (
+	// DefaultMinDetections is the detections an attack needs unless
+	// configured otherwise
+	DefaultMinDetections = 50
+	// DefaultQuietPeriod is how long detections must stop for an attack to
+	// end unless configured otherwise
+	DefaultQuietPeriod = 2 * time.Minute
+	// DefaultRetention is how long finished attacks are kept unless
+	// configured otherwise
+	DefaultRetention = 7 * 24 * time.Hour
+	// DefaultMaxAttacks bounds the finished attacks kept unless configured
+	// otherwise
+	DefaultMaxAttacks = 500
+
+	// maxTracked, maxPaths and maxCodes bound the distinct IPs, paths and
+	// codes counted per attack; beyond them, new IPs are not counted and
+	// paths and codes are counted as intern.Other
+	maxTracked = 100000
+	maxPaths   = 1000
+	maxCodes   = 100
+	// topN is the targets, sources and codes listed for an attack
+	topN = 10
+)
+
+// Detection is a request the protection acted on
+type Detection struct {
+	Time time.Time
+	IP   string
+	Path string
+	Code string
+}
+
+// Count is how often a value was seen in an attack
+type Count struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Attack is a run of detections without a quiet period between them. End
+// is nil while it is in progress.
+type Attack struct {
+	ID         int        `json:"id"`
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"`
+	LastSeen   time.Time  `json:"last_seen"`
+	Detections int64      `json:"detections"`
+	PeakRPS    int64      `json:"peak_rps"` // most detections in one second
+	IPCount    int        `json:"ip_count"`
+	TopTargets []Count    `json:"top_targets"`
+	TopIPs     []Count    `json:"top_ips"`
+	Codes      []Count    `json:"codes"`
+}
+
+// Options configure a Tracker; zero values take the defaults
+type Options struct {
+	MinDetections int
+	QuietPeriod   time.Duration
+	Retention     time.Duration
+	MaxAttacks    int
+}
+
+// run accumulates the detections of an attack in progress
+type run struct {
+	start      time.Time
+	lastSeen   time.Time
+	detections int64
+	second     int64 // Unix second being counted
+	inSecond   int64
+	peak       int64
+	ips        map[string]int64
+	paths      map[string]int64
+	codes      map[string]int64
+}
+
+// Tracker groups detections into attacks
+type Tracker struct {
+	opts    Options
+	current *run
+	history []Attack // most recent first
+	nextID  int
+	mu      sync.Mutex
+}
+
+// NewTracker creates a tracker
+func NewTracker(opts Options) *Tracker {
+	if opts.MinDetections <= 0 {
+		opts.MinDetections = DefaultMinDetections
+	}
+	if opts.QuietPeriod <= 0 {
+		opts.QuietPeriod = DefaultQuietPeriod
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.MaxAttacks <= 0 {
+		opts.MaxAttacks = DefaultMaxAttacks
+	}
+	return &Tracker{opts: opts, nextID: 1}
+}
+
+// Observe counts a detection, ending the attack in progress first if
+// detections had stopped for the quiet period
+func (t *Tracker) Observe(d Detection) {
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endLocked(d.Time)
+	r := t.current
+	if r == nil {
+		r = &run{
+			start: d.Time,
+			ips:   make(map[string]int64),
+			paths: make(map[string]int64),
+			codes: make(map[string]int64),
+		}
+		t.current = r
+	}
+
+	r.detections++
+	if d.Time.After(r.lastSeen) {
+		r.lastSeen = d.Time
+	}
+	if second := d.Time.Unix(); second != r.second {
+		r.second, r.inSecond = second, 0
+	}
+	r.inSecond++
+	if r.inSecond > r.peak {
+		r.peak = r.inSecond
+	}
+	if _, seen := r.ips[d.IP]; seen || len(r.ips) < maxTracked {
+		r.ips[d.IP]++
+	}
+	r.paths[intern.Key(r.paths, d.Path, maxPaths)]++
+	r.codes[intern.Key(r.codes, d.Code, maxCodes)]++
+}
+
+// Sweep ends the attack in progress if detections have stopped for the
+// quiet period, and drops attacks past their retention
+func (t *Tracker) Sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endLocked(now)
+	cutoff := now.Add(-t.opts.Retention)
+	for i, attack := range t.history {
+		if attack.End.Before(cutoff) {
+			t.history = t.history[:i]
+			break
+		}
+	}
+}
+
+// endLocked moves the run in progress to the history once detections have
+// stopped for the quiet period, if it was large enough to be an attack;
+// t.mu must be held
+func (t *Tracker) endLocked(now time.Time) {
+	r := t.current
+	if r == nil || now.Sub(r.lastSeen) < t.opts.QuietPeriod {
+		return
+	}
+	t.current = nil
+	if r.detections < int64(t.opts.MinDetections) {
+		return
+	}
+
+	attack := t.attackLocked(r)
+	end := r.lastSeen
+	attack.End = &end
+	t.nextID++
+
+	t.history = append([]Attack{attack}, t.history...)
+	if len(t.history) > t.opts.MaxAttacks {
+		t.history = t.history[:t.opts.MaxAttacks]
+	}
+}
+
+// attackLocked describes a run, numbered with the next ID; t.mu must be
+// held
+func (t *Tracker) attackLocked(r *run) Attack {
+	return Attack{
+		ID:         t.nextID,
+		Start:      r.start,
+		LastSeen:   r.lastSeen,
+		Detections: r.detections,
+		PeakRPS:    r.peak,
+		IPCount:    len(r.ips),
+		TopTargets: top(r.paths),
+		TopIPs:     top(r.ips),
+		Codes:      top(r.codes),
+	}
+}
+
+// currentLocked returns the attack in progress, if its detections already
+// make it one; t.mu must be held
+func (t *Tracker) currentLocked() *Attack {
+	if t.current == nil || t.current.detections < int64(t.opts.MinDetections) {
+		return nil
+	}
+	attack := t.attackLocked(t.current)
+	return &attack
+}
+
+// List returns the attack in progress, if any, and the finished attacks,
+// most recent first
+func (t *Tracker) List() []Attack {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attacks := make([]Attack, 0, len(t.history)+1)
+	if current := t.currentLocked(); current != nil {
+		attacks = append(attacks, *current)
+	}
+	return append(attacks, t.history...)
+}
+
+// Get returns the attack with id
+func (t *Tracker) Get(id int) (Attack, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current := t.currentLocked(); current != nil && current.ID == id {
+		return *current, true
+	}
+	for _, attack := range t.history {
+		if attack.ID == id {
+			return attack, true
+		}
+	}
+	return Attack{}, false
+}
+
+// top returns the topN most counted values, most counted first
+func top(counts map[string]int64) []Count {
+	list := make([]Count, 0, len(counts))
+	for value, count := range counts {
+		list = append(list, Count{Value: value, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Value < list[j].Value
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	return list
+}
@@ -0,0 +1,58 @@
+package attacks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTrackerGroupsDetections(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(Options{MinDetections: 10, QuietPeriod: time.Minute, Retention: time.Hour})
+
+	// 40 detections over four seconds from eight addresses, mostly at /login
+	for i := 0; i < 40; i++ {
+		path := "/login"
+		if i%4 == 0 {
+			path = "/search"
+		}
+		tracker.Observe(Detection{
+			Time: start.Add(time.Duration(i) * 100 * time.Millisecond),
+			IP:   fmt.Sprintf("203.0.113.%d", i%8),
+			Path: path,
+			Code: "RATE_LIMITED",
+		})
+	}
+
+	list := tracker.List()
+	if len(list) != 1 || list[0].End != nil {
+		t.Fatalf("List() = %+v, want one attack in progress", list)
+	}
+	attack := list[0]
+	if attack.Detections != 40 || attack.PeakRPS != 10 || attack.IPCount != 8 {
+		t.Errorf("attack = %+v", attack)
+	}
+	if attack.TopTargets[0] != (Count{Value: "/login", Count: 30}) {
+		t.Errorf("top targets = %v", attack.TopTargets)
+	}
+
+	// A quiet minute ends it; a lone detection later is not an attack
+	tracker.Observe(Detection{Time: start.Add(2 * time.Minute), IP: "198.51.100.1", Path: "/", Code: "BLOCKED_IP"})
+	tracker.Sweep(start.Add(4 * time.Minute))
+	list = tracker.List()
+	if len(list) != 1 || list[0].End == nil || !list[0].End.Equal(start.Add(3900*time.Millisecond)) {
+		t.Fatalf("List() = %+v, want the finished attack only", list)
+	}
+	if got, ok := tracker.Get(attack.ID); !ok || got.Detections != 40 {
+		t.Errorf("Get(%d) = %+v, %v", attack.ID, got, ok)
+	}
+	if _, ok := tracker.Get(attack.ID + 1); ok {
+		t.Error("Get found an attack that never was")
+	}
+
+	// Past its retention the attack is dropped
+	tracker.Sweep(start.Add(2 * time.Hour))
+	if list := tracker.List(); len(list) != 0 {
+		t.Errorf("List() after retention = %+v", list)
+	}
+}
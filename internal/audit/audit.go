@@ -0,0 +1,321 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DefaultCapacity is how many recent entries are kept for queries
+	DefaultCapacity = 10000
+	// DefaultLimit is the number of entries returned when none is requested
+	DefaultLimit = 100
+	// MaxLimit caps the number of entries returned by a query
+	MaxLimit = 1000
+)
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the actor making a change
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor carried by ctx, or "system" for changes made
+// by the service itself
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "system"
+}
+
+// Entry is a recorded change. Before and After hold the JSON form of the
+// changed value; either is empty when the value did not exist. Each entry
+// is chained to the one before it by PrevHash, so altering, dropping or
+// reordering entries breaks the chain.
+type Entry struct {
+	ID       uint64          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"`
+	Action   string          `json:"action"`
+	Target   string          `json:"target"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	PrevHash string          `json:"prev_hash,omitempty"`
+	Hash     string          `json:"hash,omitempty"`
+}
+
+// Query selects entries. Empty fields match everything; Action matches
+// exactly or, with a trailing "*", by prefix.
+type Query struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (q *Query) match(e *Entry) bool {
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Target != "" && e.Target != q.Target {
+		return false
+	}
+	if strings.HasSuffix(q.Action, "*") {
+		if !strings.HasPrefix(e.Action, strings.TrimSuffix(q.Action, "*")) {
+			return false
+		}
+	} else if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Log is an append-only audit log. Entries are appended to a JSON lines
+// file and/or a Redis stream, and the most recent ones are kept in memory
+// for queries.
+type Log struct {
+	file         *os.File
+	path         string
+	client       *redis.Client
+	stream       string
+	maxLen       int64
+	entries      []Entry
+	next         int
+	full         bool
+	lastID       uint64
+	lastHash     string
+	signer       ed25519.PrivateKey
+	checkpointed uint64
+	mu           sync.Mutex
+}
+
+// NewLog opens an audit log. An empty path disables the file, and a nil
+// client or empty stream disables the Redis stream. Existing entries are
+// loaded so queries cover changes made before a restart.
+func NewLog(ctx context.Context, path string, client *redis.Client, stream string, maxLen int64, capacity int) (*Log, error) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	l := &Log{
+		stream:  stream,
+		maxLen:  maxLen,
+		entries: make([]Entry, capacity),
+	}
+	if stream != "" {
+		l.client = client
+	}
+
+	if path != "" {
+		if err := l.loadFile(path); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create audit log directory: %v", err)
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %v", err)
+		}
+		l.file = file
+		l.path = path
+	} else if l.client != nil {
+		if err := l.loadStream(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (l *Log) loadFile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		l.remember(e)
+	}
+	return scanner.Err()
+}
+
+func (l *Log) loadStream(ctx context.Context) error {
+	messages, err := l.client.XRevRangeN(ctx, l.stream, "+", "-", int64(len(l.entries))).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read audit stream: %v", err)
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		data, ok := messages[i].Values["entry"].(string)
+		if !ok {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			continue
+		}
+		l.remember(e)
+	}
+	return nil
+}
+
+// remember adds an entry to the in-memory ring. The caller must hold l.mu
+// or be the only user of l.
+func (l *Log) remember(e Entry) {
+	if e.ID > l.lastID {
+		l.lastID = e.ID
+		l.lastHash = e.Hash
+	}
+	if e.Action == ActionCheckpoint && e.ID > l.checkpointed {
+		l.checkpointed = e.ID
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Record appends a change made by the actor in ctx. before and after are
+// the old and new values; pass nil when a value did not exist.
+func (l *Log) Record(ctx context.Context, action, target string, before, after interface{}) (Entry, error) {
+	e := Entry{
+		Time:   time.Now().UTC(),
+		Actor:  ActorFrom(ctx),
+		Action: action,
+		Target: target,
+	}
+
+	var err error
+	if e.Before, err = marshalValue(before); err != nil {
+		return e, err
+	}
+	if e.After, err = marshalValue(after); err != nil {
+		return e, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.appendLocked(ctx, e)
+}
+
+// appendLocked numbers e, chains it to the last entry and writes it out.
+// l.mu must be held.
+func (l *Log) appendLocked(ctx context.Context, e Entry) (Entry, error) {
+	e.ID = l.lastID + 1
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+
+	l.remember(e)
+
+	if l.file != nil {
+		if _, err := l.file.Write(append(data, '\n')); err != nil {
+			return e, fmt.Errorf("failed to write audit log: %v", err)
+		}
+	}
+	if l.client != nil {
+		args := &redis.XAddArgs{
+			Stream: l.stream,
+			Values: map[string]interface{}{"entry": string(data)},
+		}
+		if l.maxLen > 0 {
+			args.MaxLen = l.maxLen
+			args.Approx = true
+		}
+		if err := l.client.XAdd(ctx, args).Err(); err != nil {
+			return e, fmt.Errorf("failed to write audit stream: %v", err)
+		}
+	}
+
+	return e, nil
+}
+
+func marshalValue(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Search returns matching entries, newest first
+func (l *Log) Search(q Query) []Entry {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+
+	result := []Entry{}
+	for i := 0; i < n && len(result) < limit; i++ {
+		idx := (l.next - 1 - i + len(l.entries)) % len(l.entries)
+		if q.match(&l.entries[idx]) {
+			result = append(result, l.entries[idx])
+		}
+	}
+	return result
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
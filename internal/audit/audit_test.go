@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReload(t *testing.T) {
+	ctx := WithActor(context.Background(), "key:1a2b3c4d")
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log, err := NewLog(ctx, path, nil, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Record(ctx, "blacklist.add", "198.51.100.7", nil, map[string]string{"source": "manual"})
+	log.Record(ctx, "whitelist.add", "192.0.2.10", false, true)
+	log.Record(context.Background(), "blacklist.remove", "198.51.100.7", map[string]string{"source": "manual"}, nil)
+	log.Close()
+
+	reopened, err := NewLog(ctx, path, nil, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	entries := reopened.Search(Query{})
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries after reload, got %d", len(entries))
+	}
+	if entries[0].Action != "blacklist.remove" || entries[0].Actor != "system" || entries[0].After != nil {
+		t.Errorf("unexpected newest entry: %+v", entries[0])
+	}
+
+	// IDs continue after a reload
+	e, _ := reopened.Record(ctx, "config.rate_limits", "", nil, nil)
+	if e.ID != 4 {
+		t.Errorf("expected ID 4, got %d", e.ID)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	ctx := context.Background()
+	log, _ := NewLog(ctx, "", nil, "", 0, 2)
+
+	log.Record(WithActor(ctx, "alice"), "blacklist.add", "198.51.100.1", nil, true)
+	log.Record(WithActor(ctx, "bob"), "blacklist.remove", "198.51.100.1", true, nil)
+	log.Record(WithActor(ctx, "alice"), "whitelist.add", "192.0.2.1", false, true)
+
+	if entries := log.Search(Query{}); len(entries) != 2 {
+		t.Errorf("expected capacity to bound entries to 2, got %d", len(entries))
+	}
+	if entries := log.Search(Query{Action: "blacklist.*"}); len(entries) != 1 || entries[0].Actor != "bob" {
+		t.Errorf("prefix action search = %+v", entries)
+	}
+	if entries := log.Search(Query{Actor: "alice"}); len(entries) != 1 || entries[0].Action != "whitelist.add" {
+		t.Errorf("actor search = %+v", entries)
+	}
+}
@@ -0,0 +1,375 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ActionCheckpoint marks the entries that sign the head of the chain
+const ActionCheckpoint = "audit.checkpoint"
+
+// ExportFormat and ExportVersion identify the layout written by Export
+const (
+	ExportFormat  = "ddos-audit-export"
+	ExportVersion = 1
+)
+
+// Checkpoint is the body of a checkpoint entry: a signature over the hash of
+// the entry it follows
+type Checkpoint struct {
+	EntryID   uint64 `json:"entry_id"`
+	Hash      string `json:"hash"`
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// ExportHeader is the first line of an export. PublicKey is informational;
+// verifiers should check against a key obtained out of band.
+type ExportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	PublicKey  string    `json:"public_key,omitempty"`
+}
+
+// Report summarises a verified export
+type Report struct {
+	Entries       int       `json:"entries"`
+	Unhashed      int       `json:"unhashed"`
+	Checkpoints   int       `json:"checkpoints"`
+	FirstID       uint64    `json:"first_id,omitempty"`
+	LastID        uint64    `json:"last_id,omitempty"`
+	SignedThrough uint64    `json:"signed_through,omitempty"`
+	SignedAt      time.Time `json:"signed_at,omitempty"`
+	Unsigned      int       `json:"unsigned"`
+	Anchored      bool      `json:"anchored"`
+}
+
+// computeHash hashes e, including the hash of the entry before it
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkpointMessage is what a checkpoint signs
+func checkpointMessage(entryID uint64, hash string) []byte {
+	return []byte(fmt.Sprintf("%s|%d|%s", ExportFormat, entryID, hash))
+}
+
+// KeyID is a short fingerprint of a public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// EncodePublicKey returns pub in the base64 form used in exports
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// DecodePublicKey parses a key written by EncodePublicKey
+func DecodePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// LoadSigningKey reads the checkpoint signing key from path, generating and
+// saving one if the file does not exist
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid signing key in %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create signing key directory: %v", err)
+		}
+	}
+	encoded := base64.StdEncoding.EncodeToString(key.Seed()) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %v", err)
+	}
+	return key, nil
+}
+
+// SetSigningKey enables signed checkpoints
+func (l *Log) SetSigningKey(key ed25519.PrivateKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.signer = key
+}
+
+// PublicKey returns the key checkpoints are signed with, or nil
+func (l *Log) PublicKey() ed25519.PublicKey {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.signer == nil {
+		return nil
+	}
+	return l.signer.Public().(ed25519.PublicKey)
+}
+
+// Checkpoint signs the head of the chain. It does nothing and returns false
+// when there is no signing key or nothing was recorded since the last one.
+func (l *Log) Checkpoint(ctx context.Context) (Entry, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.signer == nil || l.lastID == 0 || l.lastID == l.checkpointed {
+		return Entry{}, false, nil
+	}
+
+	cp := Checkpoint{
+		EntryID: l.lastID,
+		Hash:    l.lastHash,
+		KeyID:   KeyID(l.signer.Public().(ed25519.PublicKey)),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.signer, checkpointMessage(cp.EntryID, cp.Hash)))
+
+	after, err := marshalValue(cp)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e, err := l.appendLocked(ctx, Entry{
+		Time:   time.Now().UTC(),
+		Actor:  "system",
+		Action: ActionCheckpoint,
+		Target: fmt.Sprintf("entry:%d", cp.EntryID),
+		After:  after,
+	})
+	return e, err == nil, err
+}
+
+// RunCheckpoints signs the head of the chain every interval until ctx is
+// done, reporting failures to onError
+func (l *Log) RunCheckpoints(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := l.Checkpoint(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Export writes a header line followed by every entry as a JSON line, oldest
+// first. With a file the whole log is exported; otherwise only the entries
+// still held in memory.
+func (l *Log) Export(w io.Writer) error {
+	header := ExportHeader{
+		Format:     ExportFormat,
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC(),
+	}
+	if pub := l.PublicKey(); pub != nil {
+		header.PublicKey = EncodePublicKey(pub)
+	}
+	if err := json.NewEncoder(w).Encode(header); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	if l.path == "" {
+		entries := l.snapshot()
+		l.mu.Unlock()
+
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Only export what was fully written when the export started, so an
+	// entry being appended cannot be cut in half
+	info, err := l.file.Stat()
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(w, file, info.Size())
+	return err
+}
+
+// snapshot returns the in-memory entries, oldest first. l.mu must be held.
+func (l *Log) snapshot() []Entry {
+	if !l.full {
+		return append([]Entry(nil), l.entries[:l.next]...)
+	}
+	entries := append([]Entry(nil), l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// VerifyOptions relax what Verify accepts
+type VerifyOptions struct {
+	// AllowLegacy accepts entries recorded before the log was hash chained.
+	// They cannot be verified, and an export stripped of its chain looks
+	// the same, so they are refused unless the log is known to have them.
+	AllowLegacy bool
+}
+
+// Verify checks an export written by Export: that every entry matches its
+// hash, that each links to the one before it, and that every checkpoint is
+// signed by pub. Given pub, the export must hold at least one checkpoint;
+// if pub is nil the key in the header is used, which only proves the export
+// is self-consistent. The report covers the entries read before the first
+// problem.
+func Verify(r io.Reader, pub ed25519.PublicKey, opts VerifyOptions) (*Report, error) {
+	report := &Report{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return report, err
+		}
+		return report, errors.New("empty export")
+	}
+	var header ExportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != ExportFormat {
+		return report, errors.New("not an audit log export")
+	}
+	if header.Version != ExportVersion {
+		return report, fmt.Errorf("unsupported export version %d", header.Version)
+	}
+	keyGiven := pub != nil
+	if pub == nil && header.PublicKey != "" {
+		key, err := DecodePublicKey(header.PublicKey)
+		if err != nil {
+			return report, err
+		}
+		pub = key
+	}
+
+	var prev Entry
+	chained := false
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return report, fmt.Errorf("line %d: invalid entry: %v", line, err)
+		}
+
+		if e.Hash == "" {
+			// Entries recorded before the log was chained
+			if chained {
+				return report, fmt.Errorf("entry %d: missing hash", e.ID)
+			}
+			report.Unhashed++
+		} else {
+			if e.computeHash() != e.Hash {
+				return report, fmt.Errorf("entry %d: contents do not match its hash", e.ID)
+			}
+			if chained || report.Entries > 0 {
+				if e.PrevHash != prev.Hash || e.ID != prev.ID+1 {
+					return report, fmt.Errorf("entry %d: chain broken after entry %d", e.ID, prev.ID)
+				}
+			}
+			if !chained {
+				// The chain only provably starts here if nothing was
+				// recorded before this entry
+				report.Anchored = e.PrevHash == ""
+			}
+			chained = true
+		}
+
+		if e.Action == ActionCheckpoint {
+			if err := verifyCheckpoint(e, pub); err != nil {
+				return report, err
+			}
+			report.Checkpoints++
+			report.SignedThrough = e.ID - 1
+			report.SignedAt = e.Time
+			report.Unsigned = 0
+		} else if e.Hash != "" {
+			report.Unsigned++
+		}
+
+		if report.Entries == 0 {
+			report.FirstID = e.ID
+		}
+		report.Entries++
+		report.LastID = e.ID
+		prev = e
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+
+	if report.Unhashed > 0 && !opts.AllowLegacy {
+		return report, fmt.Errorf("%d entries are not hash chained", report.Unhashed)
+	}
+	if keyGiven && report.Checkpoints == 0 {
+		return report, errors.New("no checkpoint signed by the key")
+	}
+	return report, nil
+}
+
+// verifyCheckpoint checks that a checkpoint entry signs the entry it is
+// chained to
+func verifyCheckpoint(e Entry, pub ed25519.PublicKey) error {
+	var cp Checkpoint
+	if err := json.Unmarshal(e.After, &cp); err != nil {
+		return fmt.Errorf("entry %d: invalid checkpoint: %v", e.ID, err)
+	}
+	if e.Hash == "" || cp.EntryID+1 != e.ID || cp.Hash != e.PrevHash {
+		return fmt.Errorf("entry %d: checkpoint does not match the entry before it", e.ID)
+	}
+	if pub == nil {
+		return fmt.Errorf("entry %d: no public key to verify the checkpoint", e.ID)
+	}
+	if cp.KeyID != KeyID(pub) {
+		return fmt.Errorf("entry %d: checkpoint signed with another key (%s)", e.ID, cp.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(cp.Signature)
+	if err != nil || !ed25519.Verify(pub, checkpointMessage(cp.EntryID, cp.Hash), signature) {
+		return fmt.Errorf("entry %d: invalid checkpoint signature", e.ID)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key, err := LoadSigningKey(filepath.Join(dir, "audit.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadSigningKey(filepath.Join(dir, "audit.key"))
+	if err != nil || !reloaded.Equal(key) {
+		t.Fatalf("signing key not reloaded: %v", err)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+
+	log, err := NewLog(ctx, filepath.Join(dir, "audit.log"), nil, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	log.SetSigningKey(key)
+
+	log.Record(ctx, "blacklist.add", "198.51.100.7", nil, true)
+	log.Record(ctx, "whitelist.add", "192.0.2.10", false, true)
+	if _, ok, err := log.Checkpoint(ctx); !ok || err != nil {
+		t.Fatalf("checkpoint not written: %v", err)
+	}
+	if _, ok, _ := log.Checkpoint(ctx); ok {
+		t.Error("checkpoint written with nothing new to sign")
+	}
+	log.Record(ctx, "blacklist.remove", "198.51.100.7", true, nil)
+
+	var buf bytes.Buffer
+	if err := log.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	export := buf.String()
+
+	report, err := Verify(strings.NewReader(export), pub, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("valid export rejected: %v", err)
+	}
+	if report.Entries != 4 || report.Checkpoints != 1 || report.SignedThrough != 2 || report.Unsigned != 1 || !report.Anchored {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// Altering an entry breaks its hash
+	tampered := strings.Replace(export, "198.51.100.7", "198.51.100.8", 1)
+	if _, err := Verify(strings.NewReader(tampered), pub, VerifyOptions{}); err == nil {
+		t.Error("altered entry accepted")
+	}
+
+	// Dropping an entry breaks the chain, and dropping the first leaves it
+	// unanchored
+	lines := strings.Split(export, "\n")
+	dropped := strings.Join(append(lines[:2:2], lines[3:]...), "\n")
+	if _, err := Verify(strings.NewReader(dropped), pub, VerifyOptions{}); err == nil {
+		t.Error("export missing an entry accepted")
+	}
+	truncated := strings.Join(append(lines[:1:1], lines[2:]...), "\n")
+	if report, err := Verify(strings.NewReader(truncated), pub, VerifyOptions{}); err != nil || report.Anchored {
+		t.Errorf("export missing its first entry: report %+v, err %v", report, err)
+	}
+
+	// An export stripped of its chain and checkpoints looks like one
+	// recorded before chaining, so it only passes when that is allowed and
+	// never against a key
+	stripped := lines[:1:1]
+	for _, line := range lines[1:] {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil || e.Action == ActionCheckpoint {
+			continue
+		}
+		e.Hash, e.PrevHash = "", ""
+		data, _ := json.Marshal(e)
+		stripped = append(stripped, string(data))
+	}
+	strippedExport := strings.Join(stripped, "\n")
+	if _, err := Verify(strings.NewReader(strippedExport), nil, VerifyOptions{}); err == nil {
+		t.Error("export stripped of its chain accepted")
+	}
+	if _, err := Verify(strings.NewReader(strippedExport), pub, VerifyOptions{AllowLegacy: true}); err == nil {
+		t.Error("export without checkpoints accepted against a key")
+	}
+	if report, err := Verify(strings.NewReader(strippedExport), nil, VerifyOptions{AllowLegacy: true}); err != nil || report.Unhashed != 3 {
+		t.Errorf("legacy export: report %+v, err %v", report, err)
+	}
+
+	// Checkpoints must be signed by the expected key
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := Verify(strings.NewReader(export), other, VerifyOptions{}); err == nil {
+		t.Error("checkpoint accepted with another key")
+	}
+}
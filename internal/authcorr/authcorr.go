@@ -0,0 +1,410 @@
+// Package authcorr correlates authentication events reported by the
+// upstream (logins and lockouts) with the requests the protection blocked,
+// per client. Many accounts failing from one client, or across the clients
+// being blocked, marks credential stuffing; blocked traffic without that
+// pattern is an ordinary traffic spike. Incident reports use the same
+// events to count the accounts an attack touched.
+package authcorr
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Authentication event types
+const (
+	LoginSuccess = "login_success"
+	LoginFailure = "login_failure"
+	Lockout      = "lockout"
+)
+
+// Classifications
+const (
+	CredentialStuffing = "credential_stuffing"
+	TrafficSpike       = "traffic_spike"
+	Benign             = "benign"
+	None               = "none"
+)
+
+// maxClientEvents bounds the events kept per client
+const maxClientEvents = 256
+
+var eventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_auth_events_total",
+	Help: "Authentication events reported by the upstream, by type",
+}, []string{"type"})
+
+// Event is an authentication outcome reported by the upstream
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	IP      string    `json:"ip"`
+	Session string    `json:"session,omitempty"`
+	Account string    `json:"account,omitempty"`
+}
+
+// Validate checks an event is complete
+func (e Event) Validate() error {
+	switch e.Type {
+	case LoginSuccess, LoginFailure, Lockout:
+	default:
+		return fmt.Errorf("unknown auth event type %q", e.Type)
+	}
+	if e.IP == "" {
+		return fmt.Errorf("auth event without an ip")
+	}
+	return nil
+}
+
+// Config tunes the correlation. A client is credential stuffing once it
+// fails logins on MinAccounts accounts with at least MinFailureRatio of its
+// logins failing.
+type Config struct {
+	Window          time.Duration
+	MinAccounts     int
+	MinFailureRatio float64
+	MaxEvents       int
+	MaxClients      int
+}
+
+// Impact is what authentication events show of an attack's effect on
+// accounts. Accounts at risk logged in successfully from a client that was
+// credential stuffing and may be compromised.
+type Impact struct {
+	LoginFailures     int    `json:"login_failures"`
+	LoginSuccesses    int    `json:"login_successes"`
+	Lockouts          int    `json:"lockouts"`
+	AccountsTargeted  int    `json:"accounts_targeted"`
+	AccountsLockedOut int    `json:"accounts_locked_out"`
+	AccountsAtRisk    int    `json:"accounts_at_risk"`
+	Classification    string `json:"classification"`
+}
+
+// Client is the correlated activity of one client within the window
+type Client struct {
+	IP             string    `json:"ip"`
+	Blocked        int64     `json:"blocked_requests"`
+	LoginFailures  int       `json:"login_failures"`
+	LoginSuccesses int       `json:"login_successes"`
+	Lockouts       int       `json:"lockouts"`
+	Accounts       int       `json:"accounts"`
+	Sessions       int       `json:"sessions"`
+	LastSeen       time.Time `json:"last_seen"`
+	Classification string    `json:"classification"`
+}
+
+// Summary is the correlation over the window: the overall classification,
+// the account impact and the clients involved, most active first
+type Summary struct {
+	Window  string   `json:"window"`
+	Impact  Impact   `json:"impact"`
+	Clients []Client `json:"clients"`
+}
+
+type client struct {
+	events      []Event
+	blocked     int64
+	lastBlocked time.Time
+}
+
+// Correlator keeps recent authentication events and blocked request counts
+type Correlator struct {
+	cfg     Config
+	clients map[string]*client
+	events  []Event
+	next    int
+	full    bool
+	mu      sync.Mutex
+}
+
+// NewCorrelator creates a correlator, filling in defaults for unset values
+func NewCorrelator(cfg Config) *Correlator {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Minute
+	}
+	if cfg.MinAccounts <= 0 {
+		cfg.MinAccounts = 5
+	}
+	if cfg.MinFailureRatio <= 0 {
+		cfg.MinFailureRatio = 0.8
+	}
+	if cfg.MaxEvents <= 0 {
+		cfg.MaxEvents = 100000
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 100000
+	}
+	return &Correlator{
+		cfg:     cfg,
+		clients: make(map[string]*client),
+		events:  make([]Event, cfg.MaxEvents),
+	}
+}
+
+// Ingest records an authentication event and returns the classification of
+// its client afterwards
+func (c *Correlator) Ingest(e Event) (string, error) {
+	if err := e.Validate(); err != nil {
+		return "", err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	eventsCounter.WithLabelValues(e.Type).Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events[c.next] = e
+	c.next = (c.next + 1) % len(c.events)
+	if c.next == 0 {
+		c.full = true
+	}
+
+	cl := c.clientLocked(e.IP)
+	if cl == nil {
+		return Benign, nil
+	}
+	cl.events = append(cl.events, e)
+	if len(cl.events) > maxClientEvents {
+		cl.events = cl.events[len(cl.events)-maxClientEvents:]
+	}
+	return c.summarize(e.IP, cl, e.Time.Add(-c.cfg.Window)).Classification, nil
+}
+
+// Blocked records a request from ip the protection denied or challenged
+func (c *Correlator) Blocked(ip string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cl := c.clientLocked(ip)
+	if cl == nil {
+		return
+	}
+	if at.Sub(cl.lastBlocked) > c.cfg.Window {
+		cl.blocked = 0
+	}
+	cl.blocked++
+	cl.lastBlocked = at
+}
+
+// clientLocked returns the state of ip, creating it unless the client limit
+// is reached
+func (c *Correlator) clientLocked(ip string) *client {
+	cl, exists := c.clients[ip]
+	if !exists {
+		if len(c.clients) >= c.cfg.MaxClients {
+			return nil
+		}
+		cl = &client{}
+		c.clients[ip] = cl
+	}
+	return cl
+}
+
+// Client returns the correlated activity of ip within the window
+func (c *Correlator) Client(ip string, now time.Time) (Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cl, exists := c.clients[ip]
+	if !exists {
+		return Client{}, false
+	}
+	return c.summarize(ip, cl, now.Add(-c.cfg.Window)), true
+}
+
+// Summary correlates the activity within the window, listing at most limit
+// clients
+func (c *Correlator) Summary(now time.Time, limit int) Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	since := now.Add(-c.cfg.Window)
+	var clients []Client
+	blocked := false
+	for ip, cl := range c.clients {
+		s := c.summarize(ip, cl, since)
+		if s.Blocked == 0 && s.LoginFailures+s.LoginSuccesses+s.Lockouts == 0 {
+			continue
+		}
+		blocked = blocked || s.Blocked > 0
+		clients = append(clients, s)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := clients[i], clients[j]
+		if a.Blocked+int64(a.LoginFailures) != b.Blocked+int64(b.LoginFailures) {
+			return a.Blocked+int64(a.LoginFailures) > b.Blocked+int64(b.LoginFailures)
+		}
+		return a.IP < b.IP
+	})
+	if limit > 0 && len(clients) > limit {
+		clients = clients[:limit]
+	}
+	if clients == nil {
+		clients = []Client{}
+	}
+
+	impact := c.impactLocked(since, now)
+	if impact.Classification != CredentialStuffing {
+		impact.Classification = None
+		if blocked {
+			impact.Classification = TrafficSpike
+		}
+	}
+	return Summary{Window: c.cfg.Window.String(), Impact: impact, Clients: clients}
+}
+
+// Impact counts the account impact of events between from and to. The
+// classification is credential stuffing or, failing that, a traffic spike,
+// as impact is asked of incidents. Only the most recent MaxEvents events
+// are kept, so old incidents may be undercounted.
+func (c *Correlator) Impact(from, to time.Time) Impact {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.impactLocked(from, to)
+}
+
+func (c *Correlator) impactLocked(from, to time.Time) Impact {
+	type activity struct {
+		failures, successes int
+		failed              map[string]bool
+		succeeded           map[string]bool
+	}
+	perClient := make(map[string]*activity)
+	targeted := make(map[string]bool)
+	locked := make(map[string]bool)
+	var impact Impact
+
+	count := c.next
+	if c.full {
+		count = len(c.events)
+	}
+	for i := 0; i < count; i++ {
+		e := c.events[i]
+		if e.Time.Before(from) || e.Time.After(to) {
+			continue
+		}
+		a := perClient[e.IP]
+		if a == nil {
+			a = &activity{failed: make(map[string]bool), succeeded: make(map[string]bool)}
+			perClient[e.IP] = a
+		}
+		switch e.Type {
+		case LoginFailure:
+			impact.LoginFailures++
+			a.failures++
+			if e.Account != "" {
+				a.failed[e.Account] = true
+				targeted[e.Account] = true
+			}
+		case LoginSuccess:
+			impact.LoginSuccesses++
+			a.successes++
+			if e.Account != "" {
+				a.succeeded[e.Account] = true
+			}
+		case Lockout:
+			impact.Lockouts++
+			if e.Account != "" {
+				locked[e.Account] = true
+				targeted[e.Account] = true
+			}
+		}
+	}
+
+	atRisk := make(map[string]bool)
+	stuffing := false
+	for _, a := range perClient {
+		if c.stuffing(len(a.failed), a.failures, a.successes) {
+			stuffing = true
+			for account := range a.succeeded {
+				atRisk[account] = true
+			}
+		}
+	}
+	impact.AccountsTargeted = len(targeted)
+	impact.AccountsLockedOut = len(locked)
+	impact.AccountsAtRisk = len(atRisk)
+
+	// Stuffing spread thinly over many clients only shows in the totals
+	impact.Classification = TrafficSpike
+	if stuffing || c.stuffing(len(targeted), impact.LoginFailures, impact.LoginSuccesses) {
+		impact.Classification = CredentialStuffing
+	}
+	return impact
+}
+
+// summarize correlates a client's activity since a time
+func (c *Correlator) summarize(ip string, cl *client, since time.Time) Client {
+	s := Client{IP: ip, Classification: Benign}
+	if cl.lastBlocked.After(since) {
+		s.Blocked = cl.blocked
+		s.LastSeen = cl.lastBlocked
+	}
+
+	accounts := make(map[string]bool)
+	sessions := make(map[string]bool)
+	for _, e := range cl.events {
+		if e.Time.Before(since) {
+			continue
+		}
+		switch e.Type {
+		case LoginFailure:
+			s.LoginFailures++
+		case LoginSuccess:
+			s.LoginSuccesses++
+		case Lockout:
+			s.Lockouts++
+		}
+		if e.Account != "" && e.Type != LoginSuccess {
+			accounts[e.Account] = true
+		}
+		if e.Session != "" {
+			sessions[e.Session] = true
+		}
+		if e.Time.After(s.LastSeen) {
+			s.LastSeen = e.Time
+		}
+	}
+	s.Accounts = len(accounts)
+	s.Sessions = len(sessions)
+	if c.stuffing(s.Accounts, s.LoginFailures, s.LoginSuccesses) {
+		s.Classification = CredentialStuffing
+	}
+	return s
+}
+
+// stuffing reports whether failures spread over accounts look like
+// credential stuffing
+func (c *Correlator) stuffing(accounts, failures, successes int) bool {
+	if accounts < c.cfg.MinAccounts || failures == 0 {
+		return false
+	}
+	return float64(failures)/float64(failures+successes) >= c.cfg.MinFailureRatio
+}
+
+// Cleanup forgets clients with no activity within the window
+func (c *Correlator) Cleanup(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	since := now.Add(-c.cfg.Window)
+	for ip, cl := range c.clients {
+		kept := cl.events[:0]
+		for _, e := range cl.events {
+			if !e.Time.Before(since) {
+				kept = append(kept, e)
+			}
+		}
+		cl.events = kept
+		if len(cl.events) == 0 && !cl.lastBlocked.After(since) {
+			delete(c.clients, ip)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package authcorr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCorrelatorClassifiesCredentialStuffing(t *testing.T) {
+	c := NewCorrelator(Config{Window: time.Minute, MinAccounts: 5, MinFailureRatio: 0.8})
+	now := time.Now()
+
+	// A user mistyping their password is not stuffing
+	for i := 0; i < 3; i++ {
+		class, err := c.Ingest(Event{Time: now, Type: LoginFailure, IP: "10.0.0.1", Account: "alice"})
+		if err != nil || class != Benign {
+			t.Fatalf("ingest = %q, %v, want benign", class, err)
+		}
+	}
+	c.Ingest(Event{Time: now, Type: LoginSuccess, IP: "10.0.0.1", Account: "alice"})
+
+	// One client failing across many accounts is
+	var class string
+	for i := 0; i < 10; i++ {
+		class, _ = c.Ingest(Event{Time: now, Type: LoginFailure, IP: "10.0.0.2", Account: fmt.Sprintf("user%d", i)})
+	}
+	c.Ingest(Event{Time: now, Type: LoginSuccess, IP: "10.0.0.2", Account: "user3"})
+	c.Ingest(Event{Time: now, Type: Lockout, IP: "10.0.0.2", Account: "user4"})
+	if class != CredentialStuffing {
+		t.Errorf("stuffing client classified %q", class)
+	}
+	c.Blocked("10.0.0.2", now)
+
+	client, ok := c.Client("10.0.0.2", now)
+	if !ok || client.Blocked != 1 || client.Accounts != 10 || client.LoginFailures != 10 || client.Classification != CredentialStuffing {
+		t.Errorf("client %+v", client)
+	}
+
+	impact := c.Impact(now.Add(-time.Second), now.Add(time.Second))
+	want := Impact{
+		LoginFailures:     13,
+		LoginSuccesses:    2,
+		Lockouts:          1,
+		AccountsTargeted:  11,
+		AccountsLockedOut: 1,
+		AccountsAtRisk:    1,
+		Classification:    CredentialStuffing,
+	}
+	if impact != want {
+		t.Errorf("impact %+v, want %+v", impact, want)
+	}
+
+	summary := c.Summary(now, 0)
+	if summary.Impact.Classification != CredentialStuffing || len(summary.Clients) != 2 || summary.Clients[0].IP != "10.0.0.2" {
+		t.Errorf("summary %+v", summary)
+	}
+
+	// Outside the window only blocked traffic without logins is left
+	later := now.Add(2 * time.Minute)
+	c.Blocked("10.0.0.3", later)
+	c.Cleanup(later)
+	if summary := c.Summary(later, 0); summary.Impact.Classification != TrafficSpike || len(summary.Clients) != 1 {
+		t.Errorf("summary after window %+v", summary)
+	}
+}
+
+func TestEventValidate(t *testing.T) {
+	if err := (Event{Type: "logout", IP: "10.0.0.1"}).Validate(); err == nil {
+		t.Error("unknown type accepted")
+	}
+	if err := (Event{Type: LoginFailure}).Validate(); err == nil {
+		t.Error("event without ip accepted")
+	}
+}
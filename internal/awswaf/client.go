@@ -0,0 +1,169 @@
+package awswaf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes of a WAFv2 IPSet. CloudFront IPSets live in us-east-1.
+const (
+	ScopeRegional   = "REGIONAL"
+	ScopeCloudFront = "CLOUDFRONT"
+)
+
+// targetPrefix is the X-Amz-Target prefix of the WAFv2 JSON API
+const targetPrefix = "AWSWAF_20190729."
+
+// IPSetRef identifies an IPSet
+type IPSetRef struct {
+	Name string `json:"Name"`
+	ID   string `json:"Id"`
+}
+
+// APIError is an error returned by the WAFv2 API
+type APIError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("aws waf: %s: %s", e.Code, e.Message)
+}
+
+// isLockError reports whether err is a stale lock token
+func isLockError(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == "WAFOptimisticLockException"
+}
+
+// Client calls the WAFv2 API
+type Client struct {
+	endpoint string
+	region   string
+	scope    string
+	creds    Credentials
+	http     *http.Client
+}
+
+// NewClient creates a client for IPSets of scope in region. CloudFront
+// IPSets are always managed in us-east-1. An empty endpoint uses the
+// regional WAFv2 endpoint.
+func NewClient(endpoint, region, scope string, creds Credentials, timeout time.Duration) (*Client, error) {
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("aws waf: credentials are required")
+	}
+	switch strings.ToUpper(scope) {
+	case "", ScopeRegional:
+		scope = ScopeRegional
+		if region == "" {
+			return nil, fmt.Errorf("aws waf: region is required for REGIONAL IPSets")
+		}
+	case ScopeCloudFront:
+		scope = ScopeCloudFront
+		region = "us-east-1"
+	default:
+		return nil, fmt.Errorf("aws waf: unknown scope %q", scope)
+	}
+	if endpoint == "" {
+		endpoint = "https://wafv2." + region + ".amazonaws.com"
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		region:   region,
+		scope:    scope,
+		creds:    creds,
+		http:     &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// GetIPSet returns the addresses in an IPSet and the lock token needed to
+// update it
+func (c *Client) GetIPSet(ctx context.Context, ref IPSetRef) ([]string, string, error) {
+	var out struct {
+		IPSet struct {
+			Addresses []string `json:"Addresses"`
+		} `json:"IPSet"`
+		LockToken string `json:"LockToken"`
+	}
+	err := c.call(ctx, "GetIPSet", map[string]string{
+		"Name":  ref.Name,
+		"Id":    ref.ID,
+		"Scope": c.scope,
+	}, &out)
+	if err != nil {
+		return nil, "", err
+	}
+	return out.IPSet.Addresses, out.LockToken, nil
+}
+
+// UpdateIPSet replaces the addresses in an IPSet
+func (c *Client) UpdateIPSet(ctx context.Context, ref IPSetRef, addresses []string, lockToken string) error {
+	if addresses == nil {
+		addresses = []string{}
+	}
+	return c.call(ctx, "UpdateIPSet", map[string]interface{}{
+		"Name":      ref.Name,
+		"Id":        ref.ID,
+		"Scope":     c.scope,
+		"Addresses": addresses,
+		"LockToken": lockToken,
+	}, nil)
+}
+
+func (c *Client) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", targetPrefix+action)
+	sign(req, body, c.creds, c.region, "wafv2", time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws waf: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return fmt.Errorf("aws waf: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		code := apiErr.Type
+		if i := strings.LastIndex(code, "#"); i >= 0 {
+			code = code[i+1:]
+		}
+		if code == "" {
+			code = resp.Status
+		}
+		return &APIError{Code: code, Message: apiErr.Message, Status: resp.StatusCode}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("aws waf: invalid %s response: %v", action, err)
+	}
+	return nil
+}
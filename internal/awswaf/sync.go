@@ -0,0 +1,283 @@
+// Package awswaf keeps AWS WAFv2 IPSets in step with the blacklist, for
+// deployments behind an ALB or CloudFront where bans should be enforced
+// before traffic reaches the instances. An IPSet holds one address family,
+// so IPv4 and IPv6 bans go to separate sets.
+package awswaf
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MaxAddresses is the most addresses WAFv2 allows in an IPSet
+const MaxAddresses = 10000
+
+// maxAttempts bounds retries when another writer changes the IPSet between
+// reading and updating it
+const maxAttempts = 3
+
+var (
+	addressesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_aws_waf_addresses",
+		Help: "Addresses in the synchronized AWS WAF IPSets, by family",
+	}, []string{"family"})
+
+	droppedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_aws_waf_dropped",
+		Help: "Bans left out of the AWS WAF IPSets because they are full, by family",
+	}, []string{"family"})
+
+	updatesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_aws_waf_updates_total",
+		Help: "UpdateIPSet calls, by result",
+	}, []string{"result"})
+)
+
+// Source returns the ranges that should be banned, with their expiry times
+type Source func() map[netip.Prefix]time.Time
+
+// ipSet is a synchronized IPSet and what was last written to it
+type ipSet struct {
+	ref     IPSetRef
+	family  string
+	applied []string
+}
+
+// Syncer keeps IPSets in step with a source. Changes are batched into one
+// UpdateIPSet call per set, and the sets are re-read and reconciled on
+// start and periodically, undoing edits made elsewhere.
+type Syncer struct {
+	client   *Client
+	sets     []*ipSet
+	source   Source
+	maxSize  int
+	interval time.Duration
+	delay    time.Duration
+	notify   chan struct{}
+	mu       sync.Mutex
+}
+
+// NewSyncer creates a syncer writing IPv4 bans to ipv4 and IPv6 bans to
+// ipv6; a set with no ID is skipped. Each set holds at most maxSize
+// addresses, preferring the bans that last longest. Changes are batched for
+// delay and the sets are reconciled every interval.
+func NewSyncer(client *Client, ipv4, ipv6 IPSetRef, source Source, maxSize int, interval, delay time.Duration) *Syncer {
+	if maxSize <= 0 || maxSize > MaxAddresses {
+		maxSize = MaxAddresses
+	}
+	s := &Syncer{
+		client:   client,
+		source:   source,
+		maxSize:  maxSize,
+		interval: interval,
+		delay:    delay,
+		notify:   make(chan struct{}, 1),
+	}
+	if ipv4.ID != "" {
+		s.sets = append(s.sets, &ipSet{ref: ipv4, family: "ipv4"})
+	}
+	if ipv6.ID != "" {
+		s.sets = append(s.sets, &ipSet{ref: ipv6, family: "ipv6"})
+	}
+	return s
+}
+
+// Notify schedules a sync after a list change. It never blocks.
+func (s *Syncer) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Sync updates the sets whose desired addresses changed since they were
+// last written
+func (s *Syncer) Sync(ctx context.Context) error {
+	return s.sync(ctx, false)
+}
+
+// Reconcile reads every set and updates those that differ from the source
+func (s *Syncer) Reconcile(ctx context.Context) error {
+	return s.sync(ctx, true)
+}
+
+func (s *Syncer) sync(ctx context.Context, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v4, v6 := Addresses(s.source(), time.Now(), s.maxSize)
+	var firstErr error
+	for _, set := range s.sets {
+		desired := v4
+		if set.family == "ipv6" {
+			desired = v6
+		}
+		droppedGauge.WithLabelValues(set.family).Set(float64(desired.Dropped))
+		if !force && set.applied != nil && equal(set.applied, desired.Addresses) {
+			continue
+		}
+		if err := s.update(ctx, set, desired.Addresses); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// update writes addresses to set unless it already holds them, retrying
+// when the lock token went stale
+func (s *Syncer) update(ctx context.Context, set *ipSet, addresses []string) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var current []string
+		var lockToken string
+		current, lockToken, err = s.client.GetIPSet(ctx, set.ref)
+		if err != nil {
+			return err
+		}
+		sort.Strings(current)
+		if equal(current, addresses) {
+			break
+		}
+
+		err = s.client.UpdateIPSet(ctx, set.ref, addresses, lockToken)
+		if isLockError(err) {
+			updatesCounter.WithLabelValues("conflict").Inc()
+			continue
+		}
+		if err != nil {
+			updatesCounter.WithLabelValues("error").Inc()
+			return err
+		}
+		updatesCounter.WithLabelValues("ok").Inc()
+		break
+	}
+	if err != nil {
+		return err
+	}
+
+	set.applied = addresses
+	addressesGauge.WithLabelValues(set.family).Set(float64(len(addresses)))
+	return nil
+}
+
+// Run reconciles the sets and keeps them in sync until ctx is cancelled.
+// Expired bans are removed at the next sync or reconcile.
+func (s *Syncer) Run(ctx context.Context, onError func(error)) {
+	if err := s.Reconcile(ctx); err != nil && ctx.Err() == nil {
+		onError(err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.notify:
+			select {
+			case <-time.After(s.delay):
+			case <-ctx.Done():
+				return
+			}
+			if err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ticker.C:
+			if err := s.Reconcile(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Family is the sorted CIDR list for one IPSet and how many bans did not fit
+type Family struct {
+	Addresses []string
+	Dropped   int
+}
+
+// Addresses splits unexpired bans into IPv4 and IPv6 CIDR lists. Ranges
+// inside a wider ban are left out, and each list keeps at most maxSize
+// ranges, preferring permanent bans and then those expiring last.
+func Addresses(bans map[netip.Prefix]time.Time, now time.Time, maxSize int) (v4, v6 Family) {
+	type ban struct {
+		prefix  netip.Prefix
+		expires time.Time
+	}
+	var live []ban
+	for prefix, expires := range bans {
+		if !expires.IsZero() && !now.Before(expires) {
+			continue
+		}
+		live = append(live, ban{prefix.Masked(), expires})
+	}
+
+	// Widest first, so covered ranges can be skipped
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].prefix.Bits() < live[j].prefix.Bits()
+	})
+	var kept4, kept6 []ban
+	var wide []netip.Prefix
+	for _, b := range live {
+		if covered(wide, b.prefix) {
+			continue
+		}
+		wide = append(wide, b.prefix)
+		if b.prefix.Addr().Is4() {
+			kept4 = append(kept4, b)
+		} else {
+			kept6 = append(kept6, b)
+		}
+	}
+
+	build := func(kept []ban) Family {
+		var f Family
+		if len(kept) > maxSize {
+			sort.Slice(kept, func(i, j int) bool {
+				a, b := kept[i].expires, kept[j].expires
+				if a.IsZero() || b.IsZero() {
+					return a.IsZero() && !b.IsZero()
+				}
+				return a.After(b)
+			})
+			f.Dropped = len(kept) - maxSize
+			kept = kept[:maxSize]
+		}
+		f.Addresses = make([]string, 0, len(kept))
+		for _, b := range kept {
+			f.Addresses = append(f.Addresses, b.prefix.String())
+		}
+		sort.Strings(f.Addresses)
+		return f
+	}
+	return build(kept4), build(kept6)
+}
+
+// covered reports whether prefix lies inside one of wider
+func covered(wider []netip.Prefix, prefix netip.Prefix) bool {
+	for _, w := range wider {
+		if w.Bits() <= prefix.Bits() && w.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package awswaf
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignMatchesAWSTestSuite(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	sign(req, nil, Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	now := time.Now()
+	bans := map[netip.Prefix]time.Time{
+		netip.MustParsePrefix("10.0.0.0/8"):          now.Add(time.Hour),
+		netip.MustParsePrefix("10.1.2.3/32"):         now.Add(time.Hour),
+		netip.MustParsePrefix("192.0.2.1/32"):        now.Add(time.Minute),
+		netip.MustParsePrefix("192.0.2.2/32"):        {},
+		netip.MustParsePrefix("192.0.2.3/32"):        now.Add(-time.Minute),
+		netip.MustParsePrefix("2001:db8:1:2::/64"):   now.Add(time.Hour),
+		netip.MustParsePrefix("2001:db8:1:2::7/128"): now.Add(time.Hour),
+	}
+
+	v4, v6 := Addresses(bans, now, 2)
+	if strings.Join(v4.Addresses, ",") != "10.0.0.0/8,192.0.2.2/32" || v4.Dropped != 1 {
+		t.Errorf("v4 = %+v, want the covering range and the permanent ban", v4)
+	}
+	if strings.Join(v6.Addresses, ",") != "2001:db8:1:2::/64" || v6.Dropped != 0 {
+		t.Errorf("v6 = %+v", v6)
+	}
+}
+
+func TestSyncerUpdatesIPSet(t *testing.T) {
+	addresses := []string{"203.0.113.9/32"}
+	token, conflicts, updates := 1, 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var in struct {
+			Id        string
+			Scope     string
+			Addresses []string
+			LockToken string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in.Id != "set4" || in.Scope != ScopeRegional {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AWSWAF_20190729.GetIPSet":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"IPSet":     map[string]interface{}{"Addresses": addresses},
+				"LockToken": strings.Repeat("t", token),
+			})
+		case "AWSWAF_20190729.UpdateIPSet":
+			// Simulate another writer changing the set once
+			if conflicts > 0 {
+				conflicts--
+				token++
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"com.amazonaws.wafv2#WAFOptimisticLockException","message":"stale"}`))
+				return
+			}
+			if in.LockToken != strings.Repeat("t", token) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			updates++
+			addresses = in.Addresses
+			token++
+			w.Write([]byte(`{"NextLockToken":"x"}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "eu-west-1", "regional", Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bans := map[netip.Prefix]time.Time{netip.MustParsePrefix("198.51.100.7/32"): time.Now().Add(time.Hour)}
+	s := NewSyncer(client, IPSetRef{Name: "bans4", ID: "set4"}, IPSetRef{}, func() map[netip.Prefix]time.Time { return bans }, 0, time.Hour, 0)
+
+	ctx := context.Background()
+	if err := s.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(addresses, ",") != "198.51.100.7/32" || updates != 1 {
+		t.Errorf("addresses = %v after %d updates", addresses, updates)
+	}
+
+	// Unchanged bans make no calls
+	if err := s.Sync(ctx); err != nil || updates != 1 {
+		t.Errorf("idle sync: %d updates, err %v", updates, err)
+	}
+}
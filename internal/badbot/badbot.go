@@ -0,0 +1,351 @@
+// Package badbot matches requests against signatures of known scraping and
+// attack tools: the user agents they announce, quirks in the headers they
+// send and the paths they probe. A signature names a tool outright, so a
+// match needs no behavior to build up first, unlike the botnet detector's
+// heuristics.
+//
+// A Database holds the built-in signatures and a set loaded from a file or
+// URL and refreshed from it, and counts the hits of each signature.
+package badbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var hitsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_bad_bot_hits_total",
+	Help: "Requests matching a known bad bot signature, by signature",
+}, []string{"signature"})
+
+// maxSetSize bounds what is read from a signature source
+const maxSetSize = 4 << 20
+
+// HeaderMatch is a condition on one request header. With Absent, the
+// header must not be sent; otherwise it must be sent and, if Contains is
+// set, contain it, ignoring case.
+type HeaderMatch struct {
+	Name     string `json:"name"`
+	Contains string `json:"contains,omitempty"`
+	Absent   bool   `json:"absent,omitempty"`
+}
+
+// Signature identifies a tool. It matches a request when each kind of
+// condition it sets matches: one of its user agent substrings, all of its
+// header conditions and one of its path prefixes.
+type Signature struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Category   string        `json:"category"`
+	UserAgents []string      `json:"user_agents,omitempty"`
+	Headers    []HeaderMatch `json:"headers,omitempty"`
+	Paths      []string      `json:"paths,omitempty"`
+}
+
+// Set is a release of signatures
+type Set struct {
+	Version    string      `json:"version"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// Validate checks every signature has an ID and at least one condition,
+// and that IDs are unique
+func (s Set) Validate() error {
+	seen := make(map[string]bool, len(s.Signatures))
+	for _, sig := range s.Signatures {
+		if sig.ID == "" {
+			return fmt.Errorf("signature without an id")
+		}
+		if seen[sig.ID] {
+			return fmt.Errorf("duplicate signature %s", sig.ID)
+		}
+		seen[sig.ID] = true
+		if len(sig.UserAgents) == 0 && len(sig.Headers) == 0 && len(sig.Paths) == 0 {
+			return fmt.Errorf("signature %s matches every request", sig.ID)
+		}
+		for _, h := range sig.Headers {
+			if h.Name == "" {
+				return fmt.Errorf("signature %s: header condition without a name", sig.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// matcher is a signature prepared for matching
+type matcher struct {
+	sig        Signature
+	userAgents []string // lowercased
+	hits       *int64
+}
+
+func (m *matcher) matches(userAgent, path string, header http.Header) bool {
+	if len(m.userAgents) > 0 {
+		found := false
+		for _, ua := range m.userAgents {
+			if strings.Contains(userAgent, ua) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, h := range m.sig.Headers {
+		values, sent := header[http.CanonicalHeaderKey(h.Name)]
+		if h.Absent {
+			if sent {
+				return false
+			}
+			continue
+		}
+		if !sent || (h.Contains != "" && !containsFold(values, h.Contains)) {
+			return false
+		}
+	}
+	if len(m.sig.Paths) > 0 {
+		for _, prefix := range m.sig.Paths {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hit is how often a signature matched
+type Hit struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Hits     int64  `json:"hits"`
+}
+
+// Status describes the signatures in force
+type Status struct {
+	Builtin   int       `json:"builtin"`
+	Loaded    int       `json:"loaded"`
+	Version   string    `json:"version,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	Hits      []Hit     `json:"hits"`
+}
+
+// Database matches requests against the built-in and loaded signatures.
+// Loaded signatures come first, so one can replace a built-in of the same
+// ID.
+type Database struct {
+	mu        sync.RWMutex
+	builtin   int
+	matchers  []*matcher
+	counters  map[string]*int64
+	version   string
+	updatedAt time.Time
+	loaded    Set
+	defaults  []Signature
+}
+
+// NewDatabase creates a database holding the built-in signatures, or none
+// if builtin is false
+func NewDatabase(builtin bool) *Database {
+	db := &Database{counters: make(map[string]*int64)}
+	if builtin {
+		db.defaults = DefaultSignatures()
+	}
+	db.rebuildLocked()
+	return db
+}
+
+// Update replaces the loaded signatures with set
+func (db *Database) Update(set Set) error {
+	if err := set.Validate(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.loaded = set
+	db.version = set.Version
+	db.updatedAt = time.Now()
+	db.rebuildLocked()
+	return nil
+}
+
+// rebuildLocked prepares the matchers, keeping the hit counts of
+// signatures that stay; db.mu must be held
+func (db *Database) rebuildLocked() {
+	replaced := make(map[string]bool, len(db.loaded.Signatures))
+	sigs := make([]Signature, 0, len(db.loaded.Signatures)+len(db.defaults))
+	for _, sig := range db.loaded.Signatures {
+		replaced[sig.ID] = true
+		sigs = append(sigs, sig)
+	}
+	db.builtin = 0
+	for _, sig := range db.defaults {
+		if !replaced[sig.ID] {
+			sigs = append(sigs, sig)
+			db.builtin++
+		}
+	}
+
+	matchers := make([]*matcher, 0, len(sigs))
+	counters := make(map[string]*int64, len(sigs))
+	for _, sig := range sigs {
+		hits := db.counters[sig.ID]
+		if hits == nil {
+			hits = new(int64)
+		}
+		counters[sig.ID] = hits
+		m := &matcher{sig: sig, hits: hits}
+		for _, ua := range sig.UserAgents {
+			m.userAgents = append(m.userAgents, strings.ToLower(ua))
+		}
+		matchers = append(matchers, m)
+	}
+	db.matchers = matchers
+	db.counters = counters
+}
+
+// Match returns the first signature matching a request, counting the hit,
+// or nil. A nil database matches nothing.
+func (db *Database) Match(path string, header http.Header) *Signature {
+	if db == nil {
+		return nil
+	}
+	userAgent := strings.ToLower(header.Get("User-Agent"))
+
+	db.mu.RLock()
+	matchers := db.matchers
+	db.mu.RUnlock()
+
+	for _, m := range matchers {
+		if m.matches(userAgent, path, header) {
+			atomic.AddInt64(m.hits, 1)
+			hitsCounter.WithLabelValues(m.sig.ID).Inc()
+			sig := m.sig
+			return &sig
+		}
+	}
+	return nil
+}
+
+// Status returns the signatures in force and their hits, most hit first
+func (db *Database) Status() Status {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	status := Status{
+		Builtin:   db.builtin,
+		Loaded:    len(db.loaded.Signatures),
+		Version:   db.version,
+		UpdatedAt: db.updatedAt,
+		Hits:      make([]Hit, 0, len(db.matchers)),
+	}
+	for _, m := range db.matchers {
+		status.Hits = append(status.Hits, Hit{
+			ID:       m.sig.ID,
+			Name:     m.sig.Name,
+			Category: m.sig.Category,
+			Hits:     atomic.LoadInt64(m.hits),
+		})
+	}
+	sort.SliceStable(status.Hits, func(i, j int) bool {
+		return status.Hits[i].Hits > status.Hits[j].Hits
+	})
+	return status
+}
+
+// Fetcher downloads signature sets from an http(s) URL or a file:// path
+type Fetcher struct {
+	url    string
+	client *http.Client
+	etag   string
+	mu     sync.Mutex
+}
+
+// NewFetcher creates a fetcher for url
+func NewFetcher(url string, timeout time.Duration) *Fetcher {
+	return &Fetcher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Fetch downloads and parses the set at the URL. It returns nil when the
+// server reports it unchanged.
+func (f *Fetcher) Fetch(ctx context.Context) (*Set, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, etag, err := f.download(ctx)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var set Set
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid signature set: %v", err)
+	}
+	if err := set.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid signature set: %v", err)
+	}
+	f.etag = etag
+	return &set, nil
+}
+
+// download returns the source's content and ETag, or nil content if it is
+// unchanged
+func (f *Fetcher) download(ctx context.Context) ([]byte, string, error) {
+	if path := strings.TrimPrefix(f.url, "file://"); path != f.url {
+		data, err := os.ReadFile(path)
+		return data, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("signature source returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSetSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxSetSize {
+		return nil, "", fmt.Errorf("signature set larger than %d bytes", maxSetSize)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
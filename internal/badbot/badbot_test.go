@@ -0,0 +1,76 @@
+package badbot
+
+import (
+	"net/http"
+	"testing"
+)
+
+func header(pairs ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestDefaultSignatures(t *testing.T) {
+	if err := (Set{Signatures: DefaultSignatures()}).Validate(); err != nil {
+		t.Fatalf("built-in signatures: %v", err)
+	}
+
+	db := NewDatabase(true)
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   string
+	}{
+		{"scanner", "/", header("User-Agent", "sqlmap/1.7.2#stable (https://sqlmap.org)"), "sqlmap"},
+		{"browser", "/", header("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", "Accept-Language", "en"), ""},
+		{"flood headers", "/", header("User-Agent", "Mozilla/5.0", "Accept-Charset", "ISO-8859-1,utf-8;q=0.7,*;q=0.7", "Keep-Alive", "115"), "hulk"},
+		{"one quirk only", "/", header("User-Agent", "Mozilla/5.0", "Keep-Alive", "115"), ""},
+		{"probe", "/.git/config", header("User-Agent", "Mozilla/5.0"), "probe-vcs"},
+		{"similar path", "/.github-stars", header("User-Agent", "Mozilla/5.0"), ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if sig := db.Match(tt.path, tt.header); sig != nil {
+			got = sig.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: matched %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateKeepsHitsAndOverridesBuiltins(t *testing.T) {
+	db := NewDatabase(true)
+	sqlmap := header("User-Agent", "sqlmap/1.7")
+	db.Match("/", sqlmap)
+
+	err := db.Update(Set{Version: "2", Signatures: []Signature{
+		{ID: "sqlmap", Name: "sqlmap (any path)", UserAgents: []string{"sqlmap"}},
+		{ID: "acme-scraper", Name: "Acme scraper", UserAgents: []string{"acmebot"}},
+	}})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if sig := db.Match("/", sqlmap); sig == nil || sig.Name != "sqlmap (any path)" {
+		t.Fatalf("loaded signature did not replace the built-in: %+v", sig)
+	}
+	if sig := db.Match("/", header("User-Agent", "AcmeBot/2.0")); sig == nil || sig.ID != "acme-scraper" {
+		t.Errorf("loaded signature not matched: %+v", sig)
+	}
+
+	status := db.Status()
+	if status.Version != "2" || status.Loaded != 2 || status.Builtin != len(DefaultSignatures())-1 {
+		t.Errorf("status = %+v", status)
+	}
+	if top := status.Hits[0]; top.ID != "sqlmap" || top.Hits != 2 {
+		t.Errorf("most hit = %+v, want sqlmap with 2 hits", top)
+	}
+
+	if err := db.Update(Set{Signatures: []Signature{{ID: "everything"}}}); err == nil {
+		t.Error("signature matching every request accepted")
+	}
+}
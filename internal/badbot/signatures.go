@@ -0,0 +1,52 @@
+package badbot
+
+// Signature categories
+const (
+	CategoryScanner = "scanner"
+	CategoryScraper = "scraper"
+	CategoryFlood   = "flood"
+	CategoryProbe   = "probe"
+)
+
+// DefaultSignatures returns the built-in signatures: tools that announce
+// themselves, flood tools by their header quirks, and probes for files no
+// site means to serve
+func DefaultSignatures() []Signature {
+	return []Signature{
+		// Vulnerability scanners
+		{ID: "sqlmap", Name: "sqlmap", Category: CategoryScanner, UserAgents: []string{"sqlmap"}},
+		{ID: "nikto", Name: "Nikto", Category: CategoryScanner, UserAgents: []string{"nikto"}},
+		{ID: "nmap", Name: "Nmap scripting engine", Category: CategoryScanner, UserAgents: []string{"nmap scripting engine", "nmap nse"}},
+		{ID: "masscan", Name: "masscan", Category: CategoryScanner, UserAgents: []string{"masscan"}},
+		{ID: "zgrab", Name: "ZGrab", Category: CategoryScanner, UserAgents: []string{"zgrab"}},
+		{ID: "nuclei", Name: "Nuclei", Category: CategoryScanner, UserAgents: []string{"nuclei"}},
+		{ID: "wpscan", Name: "WPScan", Category: CategoryScanner, UserAgents: []string{"wpscan"}},
+		{ID: "acunetix", Name: "Acunetix", Category: CategoryScanner, UserAgents: []string{"acunetix"}},
+		{ID: "dirbuster", Name: "DirBuster", Category: CategoryScanner, UserAgents: []string{"dirbuster"}},
+		{ID: "gobuster", Name: "Gobuster", Category: CategoryScanner, UserAgents: []string{"gobuster"}},
+		{ID: "wfuzz", Name: "Wfuzz", Category: CategoryScanner, UserAgents: []string{"wfuzz"}},
+		{ID: "ffuf", Name: "ffuf", Category: CategoryScanner, UserAgents: []string{"fuzz faster u fool"}},
+
+		// Scrapers and site copiers
+		{ID: "httrack", Name: "HTTrack", Category: CategoryScraper, UserAgents: []string{"httrack"}},
+		{ID: "scrapy", Name: "Scrapy", Category: CategoryScraper, UserAgents: []string{"scrapy"}},
+		{ID: "webcopier", Name: "WebCopier", Category: CategoryScraper, UserAgents: []string{"webcopier"}},
+		{ID: "webzip", Name: "WebZIP", Category: CategoryScraper, UserAgents: []string{"webzip"}},
+		{ID: "sitesucker", Name: "SiteSucker", Category: CategoryScraper, UserAgents: []string{"sitesucker"}},
+
+		// Flood tools, by the headers they add to every request behind a
+		// random browser user agent. Browsers stopped sending
+		// Accept-Charset years ago.
+		{ID: "hulk", Name: "HULK", Category: CategoryFlood, Headers: []HeaderMatch{
+			{Name: "Accept-Charset", Contains: "ISO-8859-1,utf-8;q=0.7,*;q=0.7"},
+			{Name: "Keep-Alive"},
+		}},
+
+		// Probes for secrets and admin tools
+		{ID: "probe-env", Name: "Environment file probe", Category: CategoryProbe, Paths: []string{"/.env", "/.aws/credentials"}},
+		{ID: "probe-vcs", Name: "Version control probe", Category: CategoryProbe, Paths: []string{"/.git/", "/.svn/", "/.hg/"}},
+		{ID: "probe-phpunit", Name: "PHPUnit RCE probe", Category: CategoryProbe, Paths: []string{"/vendor/phpunit/"}},
+		{ID: "probe-actuator", Name: "Spring actuator probe", Category: CategoryProbe, Paths: []string{"/actuator/env", "/actuator/heapdump"}},
+		{ID: "probe-phpmyadmin", Name: "phpMyAdmin probe", Category: CategoryProbe, Paths: []string{"/phpmyadmin", "/pma/", "/myadmin/"}},
+	}
+}
@@ -0,0 +1,287 @@
+package blacklist
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ASN rule actions
+const (
+	ASNActionBlock     = "block"
+	ASNActionRateLimit = "rate_limit"
+)
+
+// redisASNKey is the hash holding ASN rules keyed by AS number
+const redisASNKey = "blacklist:asn"
+
+// ASNInfo describes the autonomous system announcing an address
+type ASNInfo struct {
+	Number      uint32 `json:"asn"`
+	Country     string `json:"country,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ASNRule blocks or rate limits every address announced by an AS
+type ASNRule struct {
+	ASN               uint32 `json:"asn"`
+	Action            string `json:"action"`
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+// Validate checks that the rule is well formed
+func (r ASNRule) Validate() error {
+	if r.ASN == 0 {
+		return fmt.Errorf("asn is required")
+	}
+
+	switch r.Action {
+	case ASNActionBlock:
+		return nil
+	case ASNActionRateLimit:
+		if r.RequestsPerMinute <= 0 {
+			return fmt.Errorf("requests_per_minute must be positive for rate_limit rules")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown ASN action: %s", r.Action)
+	}
+}
+
+type asnRange struct {
+	start netip.Addr
+	end   netip.Addr
+	info  ASNInfo
+}
+
+// ASNDatabase maps addresses to autonomous systems
+type ASNDatabase struct {
+	ranges []asnRange
+}
+
+// LoadASNDatabase loads an ip2asn TSV file (range_start, range_end, AS number,
+// country code, description), optionally gzip compressed
+func LoadASNDatabase(path string) (*ASNDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip ASN database: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	return parseASNDatabase(reader)
+}
+
+// parseASNDatabase parses ip2asn TSV records
+func parseASNDatabase(r io.Reader) (*ASNDatabase, error) {
+	db := &ASNDatabase{}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range start: %v", line, err)
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range end: %v", line, err)
+		}
+		number, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number: %v", line, err)
+		}
+
+		// ip2asn uses AS0 for unannounced space
+		if number == 0 {
+			continue
+		}
+
+		info := ASNInfo{Number: uint32(number)}
+		if len(fields) > 3 {
+			info.Country = fields[3]
+		}
+		if len(fields) > 4 {
+			info.Description = fields[4]
+		}
+
+		db.ranges = append(db.ranges, asnRange{start: start.Unmap(), end: end.Unmap(), info: info})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+
+	return db, nil
+}
+
+// Lookup returns the AS announcing ip
+func (db *ASNDatabase) Lookup(ip string) (ASNInfo, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ASNInfo{}, false
+	}
+	addr = addr.Unmap()
+
+	// Find the last range starting at or before addr
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return ASNInfo{}, false
+	}
+
+	r := db.ranges[i]
+	if r.start.BitLen() != addr.BitLen() || r.end.Less(addr) {
+		return ASNInfo{}, false
+	}
+	return r.info, true
+}
+
+// Len returns the number of ranges in the database
+func (db *ASNDatabase) Len() int {
+	return len(db.ranges)
+}
+
+// SetASNDatabase sets the database used to resolve client ASNs
+func (im *IPManager) SetASNDatabase(db *ASNDatabase) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.asnDB = db
+}
+
+// LookupASN returns the AS announcing ip, if an ASN database is loaded
+func (im *IPManager) LookupASN(ip string) (ASNInfo, bool) {
+	im.mu.RLock()
+	db := im.asnDB
+	im.mu.RUnlock()
+
+	if db == nil {
+		return ASNInfo{}, false
+	}
+	return db.Lookup(ip)
+}
+
+// SetASNRule adds or replaces the rule for an AS
+func (im *IPManager) SetASNRule(ctx context.Context, rule ASNRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.asnRules[rule.ASN] = rule
+
+	if im.client != nil {
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		return im.client.HSet(ctx, im.key(redisASNKey), strconv.FormatUint(uint64(rule.ASN), 10), data).Err()
+	}
+
+	return nil
+}
+
+// RemoveASNRule removes the rule for an AS
+func (im *IPManager) RemoveASNRule(ctx context.Context, asn uint32) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	delete(im.asnRules, asn)
+
+	if im.client != nil {
+		return im.client.HDel(ctx, im.key(redisASNKey), strconv.FormatUint(uint64(asn), 10)).Err()
+	}
+
+	return nil
+}
+
+// LoadASNRules merges ASN rules stored in Redis into the local set
+func (im *IPManager) LoadASNRules(ctx context.Context) error {
+	if im.client == nil {
+		return nil
+	}
+
+	entries, err := im.client.HGetAll(ctx, im.key(redisASNKey)).Result()
+	if err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, data := range entries {
+		var rule ASNRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			continue
+		}
+		im.asnRules[rule.ASN] = rule
+	}
+
+	return nil
+}
+
+// GetASNRules returns the configured ASN rules ordered by AS number
+func (im *IPManager) GetASNRules() []ASNRule {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	result := make([]ASNRule, 0, len(im.asnRules))
+	for _, rule := range im.asnRules {
+		result = append(result, rule)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ASN < result[j].ASN
+	})
+
+	return result
+}
+
+// MatchASNRule returns the rule applying to ip's AS, if any. Whitelisted IPs
+// never match.
+func (im *IPManager) MatchASNRule(ctx context.Context, ip string) (ASNRule, ASNInfo, bool) {
+	info, found := im.LookupASN(ip)
+	if !found {
+		return ASNRule{}, info, false
+	}
+
+	im.mu.RLock()
+	rule, exists := im.asnRules[info.Number]
+	im.mu.RUnlock()
+
+	if !exists || im.IsWhitelisted(ctx, ip) {
+		return ASNRule{}, info, false
+	}
+	return rule, info, true
+}
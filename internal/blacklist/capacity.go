@@ -0,0 +1,127 @@
+package blacklist
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var blacklistSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ddos_protection_blacklist_entries",
+	Help: "Blacklist entries held in memory on this node, by tenant (empty for the shared lists)",
+}, []string{"tenant"})
+
+// EvictionPolicy chooses which bans make room when the blacklist is full
+type EvictionPolicy string
+
+const (
+	// EvictSoonestExpiry drops the bans closest to running out
+	EvictSoonestExpiry EvictionPolicy = "soonest_expiry"
+	// EvictLRU drops the bans that went longest without a hit
+	EvictLRU EvictionPolicy = "lru"
+)
+
+// ParseEvictionPolicy parses an eviction policy name, defaulting to
+// soonest expiry
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch policy := EvictionPolicy(name); policy {
+	case "":
+		return EvictSoonestExpiry, nil
+	case EvictSoonestExpiry, EvictLRU:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q", name)
+}
+
+// capacity bounds the entries held in memory
+type capacity struct {
+	max    int
+	policy EvictionPolicy
+}
+
+// SetCapacity caps the blacklist entries held in memory at max; 0 removes
+// the cap. Once full, each new ban evicts others by policy, a percent of
+// the cap at a time so a flood of bans does not sort the list for each.
+// Manual bans are never evicted. With Redis, evicted bans stay there and
+// are cached again on the next request they match.
+func (im *IPManager) SetCapacity(max int, policy EvictionPolicy) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.capacity = capacity{max: max, policy: policy}
+	im.enforceCapacity(nil)
+}
+
+// Len returns the number of blacklist entries held in memory
+func (im *IPManager) Len() int {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	return len(im.blacklistedIPs) + len(im.blacklistedNets)
+}
+
+// enforceCapacity evicts entries other than keep while the lists are over
+// capacity, and updates the size gauge; im.mu must be held
+func (im *IPManager) enforceCapacity(keep *Entry) {
+	defer im.updateSize()
+
+	max := im.capacity.max
+	size := len(im.blacklistedIPs) + len(im.blacklistedNets)
+	if max <= 0 || size <= max {
+		return
+	}
+
+	candidates := make([]*Entry, 0, size)
+	for _, entry := range im.blacklistedIPs {
+		if entry != keep && entry.Source != SourceManual {
+			candidates = append(candidates, entry)
+		}
+	}
+	for _, entry := range im.blacklistedNets {
+		if entry != keep && entry.Source != SourceManual {
+			candidates = append(candidates, entry)
+		}
+	}
+
+	now := time.Now()
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		// Expired entries waiting for cleanup go first
+		if aLive, bLive := now.Before(a.Expires), now.Before(b.Expires); aLive != bLive {
+			return !aLive
+		}
+		if im.capacity.policy == EvictLRU {
+			return a.lastUsed().Before(b.lastUsed())
+		}
+		return a.Expires.Before(b.Expires)
+	})
+
+	evict := size - max + max/100
+	if evict > len(candidates) {
+		evict = len(candidates)
+	}
+	for _, entry := range candidates[:evict] {
+		im.evictLocked(entry)
+	}
+}
+
+// evictLocked drops a ban from memory to make room and reports it; im.mu
+// must be held
+func (im *IPManager) evictLocked(entry *Entry) {
+	if prefix, err := netip.ParsePrefix(entry.Target); err == nil {
+		delete(im.blacklistedNets, prefix)
+	} else {
+		delete(im.blacklistedIPs, entry.Target)
+	}
+	im.changed(entry.Target)
+	im.emitBan(BanEvicted, entry, entry.local)
+}
+
+// updateSize sets the size gauge; im.mu must be held
+func (im *IPManager) updateSize() {
+	blacklistSize.WithLabelValues(im.tenant).Set(float64(len(im.blacklistedIPs) + len(im.blacklistedNets)))
+}
@@ -0,0 +1,140 @@
+package blacklist
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Source identifies what added a blacklist entry
+type Source string
+
+const (
+	SourceManual       Source = "manual"
+	SourceRateLimit    Source = "rate_limit"
+	SourceBotnet       Source = "botnet"
+	SourceGreylist     Source = "greylist"
+	SourceTrafficAlert Source = "traffic_alert"
+	SourceFeed         Source = "feed"
+	SourceSubnet       Source = "subnet"
+	SourceOpenProxy    Source = "open_proxy"
+	SourceCredentials  Source = "credential_stuffing"
+	SourceSlowRequests Source = "slow_requests"
+	// SourceUnknown marks entries created before sources were recorded
+	SourceUnknown Source = "unknown"
+)
+
+// Origin records who or what is adding a blacklist entry and why. Feed names
+// the feed for SourceFeed entries, so the feed can be disabled with a kill
+// switch. Offense numbers repeated automatic bans of the same target.
+type Origin struct {
+	Source  Source
+	Feed    string
+	Reason  string
+	Offense int
+}
+
+// Entry is a blacklisted IP or CIDR range and where it came from. Hits
+// counts requests rejected by the entry on this node. Offense is set for
+// escalated automatic bans.
+type Entry struct {
+	Hits    int64     `json:"hits"`
+	used    int64     // unix nanoseconds of the last hit
+	Target  string    `json:"target"`
+	Source  Source    `json:"source"`
+	Feed    string    `json:"feed,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Offense int       `json:"offense,omitempty"`
+	Added   time.Time `json:"added"`
+	Expires time.Time `json:"expires"`
+
+	local bool // banned on this node
+}
+
+// WhitelistEntry is a whitelisted IP. Expires is nil for permanent entries.
+type WhitelistEntry struct {
+	IP      string     `json:"ip"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func newWhitelistEntry(ip string, expires time.Time) WhitelistEntry {
+	entry := WhitelistEntry{IP: ip}
+	if !expires.IsZero() {
+		entry.Expires = &expires
+	}
+	return entry
+}
+
+func newEntry(target string, duration time.Duration, origin Origin) *Entry {
+	now := time.Now()
+	source := origin.Source
+	if source == "" {
+		source = SourceUnknown
+	}
+	return &Entry{
+		Target:  target,
+		Source:  source,
+		Feed:    origin.Feed,
+		Reason:  origin.Reason,
+		Offense: origin.Offense,
+		Added:   now,
+		Expires: now.Add(duration),
+	}
+}
+
+// snapshot returns a copy of e that is safe to read while hits are recorded
+func (e *Entry) snapshot() Entry {
+	c := *e
+	c.Hits = atomic.LoadInt64(&e.Hits)
+	return c
+}
+
+// copy returns a detached copy of e
+func (e *Entry) copy() *Entry {
+	c := e.snapshot()
+	return &c
+}
+
+func (e *Entry) hit() {
+	atomic.AddInt64(&e.Hits, 1)
+	atomic.StoreInt64(&e.used, time.Now().UnixNano())
+}
+
+// lastUsed returns when e last matched a request, or when it was added if
+// it has not matched one here
+func (e *Entry) lastUsed() time.Time {
+	if used := atomic.LoadInt64(&e.used); used != 0 {
+		return time.Unix(0, used)
+	}
+	return e.Added
+}
+
+func (e *Entry) marshal() string {
+	c := e.snapshot()
+	data, _ := json.Marshal(&c)
+	return string(data)
+}
+
+// parseEntry decodes an entry stored in Redis. Values written before entries
+// carried metadata are plain markers and decode as unknown-source entries.
+func parseEntry(target, value string, expires time.Time) *Entry {
+	var entry Entry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil || entry.Target == "" {
+		return &Entry{Target: target, Source: SourceUnknown, Expires: expires}
+	}
+	if entry.Expires.IsZero() {
+		entry.Expires = expires
+	}
+	return &entry
+}
+
+// sortEntries orders entries newest first
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Added.Equal(entries[j].Added) {
+			return entries[i].Added.After(entries[j].Added)
+		}
+		return entries[i].Target < entries[j].Target
+	})
+}
@@ -0,0 +1,135 @@
+package blacklist
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// redisStreakPrefix prefixes the keys counting auto-bans of a target
+const redisStreakPrefix = "blacklist:streak:"
+
+// Escalation lengthens auto-blacklist bans for repeat offenders. The nth ban
+// in a streak lasts the base duration times Multiplier^(n-1), up to
+// MaxDuration. A streak is forgotten ResetAfter after its last ban expires.
+type Escalation struct {
+	Multiplier  float64
+	MaxDuration time.Duration
+	ResetAfter  time.Duration
+}
+
+// Duration returns the ban duration for the given offense in a streak,
+// counting from 1
+func (e Escalation) Duration(base time.Duration, offense int) time.Duration {
+	if e.Multiplier <= 1 || offense <= 1 {
+		return e.capped(base)
+	}
+	scaled := float64(base) * math.Pow(e.Multiplier, float64(offense-1))
+	if scaled >= math.MaxInt64 {
+		return e.capped(math.MaxInt64)
+	}
+	return e.capped(time.Duration(scaled))
+}
+
+func (e Escalation) capped(d time.Duration) time.Duration {
+	if e.MaxDuration > 0 && d > e.MaxDuration {
+		return e.MaxDuration
+	}
+	return d
+}
+
+// streak counts the auto-bans of a target while Redis is unavailable
+type streak struct {
+	offenses int
+	until    time.Time
+}
+
+// SetEscalation enables escalating auto-blacklist durations
+func (im *IPManager) SetEscalation(escalation Escalation) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.escalation = &escalation
+}
+
+// escalate returns the duration of an automatic ban of target and its offense
+// number. Manual and feed bans are not counted.
+func (im *IPManager) escalate(ctx context.Context, target string, base time.Duration, origin Origin) (time.Duration, int) {
+	im.mu.RLock()
+	escalation := im.escalation
+	im.mu.RUnlock()
+
+	if escalation == nil || origin.Source == SourceManual || origin.Source == SourceFeed {
+		return base, 0
+	}
+
+	offense := im.nextOffense(ctx, target)
+	duration := escalation.Duration(base, offense)
+	im.extendStreak(ctx, target, offense, duration+escalation.ResetAfter)
+	return duration, offense
+}
+
+// nextOffense counts another ban of target, in Redis so every instance sees
+// the same streak, or locally when Redis is unavailable
+func (im *IPManager) nextOffense(ctx context.Context, target string) int {
+	if im.client != nil {
+		offense, err := im.client.Incr(ctx, im.key(redisStreakPrefix+target)).Result()
+		if err == nil {
+			return int(offense)
+		}
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	s, exists := im.streaks[target]
+	if !exists || time.Now().After(s.until) {
+		s = &streak{}
+		im.streaks[target] = s
+	}
+	s.offenses++
+	return s.offenses
+}
+
+// extendStreak keeps the streak of target for ttl
+func (im *IPManager) extendStreak(ctx context.Context, target string, offense int, ttl time.Duration) {
+	if im.client != nil {
+		if err := im.client.Expire(ctx, im.key(redisStreakPrefix+target), ttl).Err(); err == nil {
+			return
+		}
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if s, exists := im.streaks[target]; exists && s.offenses == offense {
+		s.until = time.Now().Add(ttl)
+	}
+}
+
+// Offenses returns the number of auto-bans in the current streak of target
+func (im *IPManager) Offenses(ctx context.Context, target string) int {
+	if im.client != nil {
+		offenses, err := im.client.Get(ctx, im.key(redisStreakPrefix+target)).Int()
+		if err == nil {
+			return offenses
+		}
+	}
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	if s, exists := im.streaks[target]; exists && time.Now().Before(s.until) {
+		return s.offenses
+	}
+	return 0
+}
+
+// cleanupStreaks drops forgotten streaks; im.mu must be held
+func (im *IPManager) cleanupStreaks(now time.Time) {
+	for target, s := range im.streaks {
+		if now.After(s.until) {
+			delete(im.streaks, target)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package blacklist
+
+import (
+	"container/heap"
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	banEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_ban_events_total",
+		Help: "Bans added, removed, expired and evicted on this node",
+	}, []string{"kind"})
+
+	banEventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_ban_events_dropped_total",
+		Help: "Ban events dropped because the queue or a subscriber channel was full",
+	})
+)
+
+// banQueueSize bounds the ban events waiting to be delivered
+const banQueueSize = 4096
+
+// BanEventKind tells what happened to a ban
+type BanEventKind string
+
+const (
+	// BanAdded is a new ban, or one renewed with a new expiry
+	BanAdded BanEventKind = "added"
+	// BanRemoved is a ban lifted before it expired
+	BanRemoved BanEventKind = "removed"
+	// BanExpired is a ban that ran its course
+	BanExpired BanEventKind = "expired"
+	// BanEvicted is a ban dropped from memory because the blacklist was full
+	BanEvicted BanEventKind = "evicted"
+)
+
+// BanEvent reports a change to a blacklist entry. Local is set for bans
+// made on this node, so across a cluster a consumer can act on each ban
+// once; bans learned from other nodes, Redis or a snapshot are not local.
+type BanEvent struct {
+	Kind  BanEventKind
+	Entry Entry
+	Local bool
+}
+
+// banEventQueue delivers ban events to handlers and subscribers, and expires
+// bans on time rather than at the next cleanup
+type banEventQueue struct {
+	queue    chan BanEvent
+	wake     chan struct{}
+	expiries expiryHeap
+	mu       sync.Mutex
+	handlers []func(BanEvent)
+	subs     map[chan BanEvent]struct{}
+}
+
+func newBanEventQueue() *banEventQueue {
+	return &banEventQueue{
+		queue: make(chan BanEvent, banQueueSize),
+		wake:  make(chan struct{}, 1),
+		subs:  make(map[chan BanEvent]struct{}),
+	}
+}
+
+// OnBan registers fn to be called with every ban added, removed or expired.
+// Calls come from RunBanEvents one at a time, outside the manager's lock.
+func (im *IPManager) OnBan(fn func(BanEvent)) {
+	im.bans.mu.Lock()
+	defer im.bans.mu.Unlock()
+
+	im.bans.handlers = append(im.bans.handlers, fn)
+}
+
+// SubscribeBans returns a channel receiving ban events and a function that
+// ends the subscription and closes the channel. Events are dropped while
+// the channel is full.
+func (im *IPManager) SubscribeBans(size int) (<-chan BanEvent, func()) {
+	ch := make(chan BanEvent, size)
+
+	im.bans.mu.Lock()
+	im.bans.subs[ch] = struct{}{}
+	im.bans.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			im.bans.mu.Lock()
+			delete(im.bans.subs, ch)
+			im.bans.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// RunBanEvents delivers ban events and expires bans as they run out, until
+// ctx is cancelled
+func (im *IPManager) RunBanEvents(ctx context.Context) {
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		wait := im.expireDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case event := <-im.bans.queue:
+			im.deliverBan(event)
+		case <-im.bans.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (im *IPManager) deliverBan(event BanEvent) {
+	banEvents.WithLabelValues(string(event.Kind)).Inc()
+
+	im.bans.mu.Lock()
+	handlers := im.bans.handlers
+	im.bans.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(event)
+	}
+
+	// Held while sending so a subscription cannot close its channel meanwhile
+	im.bans.mu.Lock()
+	defer im.bans.mu.Unlock()
+
+	for ch := range im.bans.subs {
+		select {
+		case ch <- event:
+		default:
+			banEventsDropped.Inc()
+		}
+	}
+}
+
+// emitBan queues a ban event without blocking
+func (im *IPManager) emitBan(kind BanEventKind, entry *Entry, local bool) {
+	select {
+	case im.bans.queue <- BanEvent{Kind: kind, Entry: entry.snapshot(), Local: local}:
+	default:
+		banEventsDropped.Inc()
+	}
+}
+
+// banAdded records a ban stored in the local lists, replacing old if set;
+// im.mu must be held. A ban learned again with the same expiry, give or
+// take the second Redis keeps, is not reported twice.
+func (im *IPManager) banAdded(entry, old *Entry, local bool) {
+	im.filterAdd(entry.Target)
+	if !local && old != nil && sameExpiry(old.Expires, entry.Expires) {
+		entry.Expires, entry.local = old.Expires, old.local
+		return
+	}
+	entry.local = local
+
+	heap.Push(&im.bans.expiries, expiry{target: entry.Target, expires: entry.Expires})
+	select {
+	case im.bans.wake <- struct{}{}:
+	default:
+	}
+	im.emitBan(BanAdded, entry, local)
+	im.enforceCapacity(entry)
+}
+
+// banRemoved records a ban lifted from the local lists; im.mu must be held
+func (im *IPManager) banRemoved(old *Entry, local bool) {
+	if old != nil {
+		im.emitBan(BanRemoved, old, local)
+	}
+}
+
+// expireLocked drops an expired ban from the local lists and reports it;
+// im.mu must be held
+func (im *IPManager) expireLocked(entry *Entry) {
+	if prefix, err := netip.ParsePrefix(entry.Target); err == nil {
+		if im.blacklistedNets[prefix] != entry {
+			return
+		}
+		delete(im.blacklistedNets, prefix)
+	} else {
+		if im.blacklistedIPs[entry.Target] != entry {
+			return
+		}
+		delete(im.blacklistedIPs, entry.Target)
+	}
+	im.changed(entry.Target)
+	im.emitBan(BanExpired, entry, entry.local)
+}
+
+// expireDue expires the bans due by now and returns the time until the
+// next one is due
+func (im *IPManager) expireDue(now time.Time) time.Duration {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for im.bans.expiries.Len() > 0 {
+		next := im.bans.expiries[0]
+		if next.expires.After(now) {
+			return next.expires.Sub(now)
+		}
+		heap.Pop(&im.bans.expiries)
+
+		// The ban may have been lifted or renewed since
+		if entry := im.lookupLocked(next.target); entry != nil && entry.Expires.Equal(next.expires) {
+			im.expireLocked(entry)
+		}
+	}
+	return time.Minute
+}
+
+func sameExpiry(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Second && d < time.Second
+}
+
+// lookupLocked returns the entry stored for an IP or CIDR range; im.mu must
+// be held
+func (im *IPManager) lookupLocked(target string) *Entry {
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		return im.blacklistedNets[prefix]
+	}
+	return im.blacklistedIPs[target]
+}
+
+// expiry is a ban due to run out
+type expiry struct {
+	target  string
+	expires time.Time
+}
+
+// expiryHeap orders expiries soonest first
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiry)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,202 @@
+package blacklist
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ddos-protection/internal/bloom"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	filterLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_blacklist_filter_lookups_total",
+		Help: "Blacklist checks answered by the ban filter (miss) or passed on to the lists (maybe)",
+	}, []string{"result"})
+
+	filterMisses = filterLookups.WithLabelValues("miss")
+	filterMaybes = filterLookups.WithLabelValues("maybe")
+)
+
+// banFilter is a Bloom filter of blacklisted IPs and ranges, so the common
+// case of a client that is not banned is answered without locks or Redis.
+// Every entry is added as a prefix, single IPs as /32 or /128, and the
+// prefix lengths in use are recorded so lookups probe only those.
+type banFilter struct {
+	filter *bloom.Filter
+	v4     uint64    // bit n set once an IPv4 /n was added
+	v6     [3]uint64 // bit n set once an IPv6 /n was added
+}
+
+func newBanFilter(n int, p float64) *banFilter {
+	return &banFilter{filter: bloom.New(n, p)}
+}
+
+// add inserts an IP or CIDR range
+func (bf *banFilter) add(target string) {
+	if strings.Contains(target, "/") {
+		if prefix, err := netip.ParsePrefix(target); err == nil {
+			bf.addPrefix(prefix.Masked())
+		}
+		return
+	}
+	if addr, err := netip.ParseAddr(canonicalIP(target)); err == nil {
+		bf.addPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+	}
+}
+
+func (bf *banFilter) addPrefix(prefix netip.Prefix) {
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		setBit(&bf.v4, bits)
+	} else {
+		setBit(&bf.v6[bits/64], bits%64)
+	}
+	bf.filter.Add(prefixKey(prefix))
+}
+
+// mayContain reports whether addr may be banned. False means it is not.
+func (bf *banFilter) mayContain(addr netip.Addr) bool {
+	if addr.Is4() {
+		lengths := atomic.LoadUint64(&bf.v4)
+		for bits := 0; bits <= 32; bits++ {
+			if lengths&(1<<bits) != 0 && bf.test(addr, bits) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for bits := 0; bits <= 128; bits++ {
+		if atomic.LoadUint64(&bf.v6[bits/64])&(1<<(bits%64)) != 0 && bf.test(addr, bits) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bf *banFilter) test(addr netip.Addr, bits int) bool {
+	prefix, err := addr.Prefix(bits)
+	return err == nil && bf.filter.Test(prefixKey(prefix))
+}
+
+// prefixKey encodes a masked prefix as its 16-byte address and length
+func prefixKey(prefix netip.Prefix) string {
+	var key [18]byte
+	a := prefix.Addr().As16()
+	copy(key[:], a[:])
+	key[16] = byte(prefix.Bits())
+	if prefix.Addr().Is4() {
+		key[17] = 4
+	}
+	return string(key[:])
+}
+
+func setBit(word *uint64, bit int) {
+	mask := uint64(1) << bit
+	for {
+		old := atomic.LoadUint64(word)
+		if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+			return
+		}
+	}
+}
+
+// EnableFilter answers blacklist checks for clients that are not banned
+// from a Bloom filter sized for n entries at false positive rate p. The
+// filter only grows; RebuildFilter drops removed and expired entries and
+// picks up bans added on other nodes that were not broadcast.
+func (im *IPManager) EnableFilter(ctx context.Context, n int, p float64) error {
+	im.mu.Lock()
+	im.filterSize, im.filterRate = n, p
+	im.mu.Unlock()
+
+	return im.RebuildFilter(ctx)
+}
+
+// RebuildFilter rebuilds the ban filter from the local lists and the IPs
+// banned in Redis
+func (im *IPManager) RebuildFilter(ctx context.Context) error {
+	if err := im.LoadBlacklistedNets(ctx); err != nil {
+		return err
+	}
+
+	var remote []string
+	if im.client != nil {
+		iter := im.client.Scan(ctx, 0, im.redisPrefix+"*", 1000).Iterator()
+		for iter.Next(ctx) {
+			ip := strings.TrimPrefix(iter.Val(), im.redisPrefix)
+			if _, err := netip.ParseAddr(ip); err == nil {
+				remote = append(remote, ip)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Locked so no local ban lands in the old filter after it was copied
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	n := im.filterSize
+	if total := 2 * (len(remote) + len(im.blacklistedIPs) + len(im.blacklistedNets)); total > n {
+		n = total
+	}
+	bf := newBanFilter(n, im.filterRate)
+
+	now := time.Now()
+	for _, ip := range remote {
+		bf.add(ip)
+	}
+	for ip, entry := range im.blacklistedIPs {
+		if now.Before(entry.Expires) {
+			bf.add(ip)
+		}
+	}
+	for prefix, entry := range im.blacklistedNets {
+		if now.Before(entry.Expires) {
+			bf.addPrefix(prefix)
+		}
+	}
+
+	im.filter.Store(bf)
+	return nil
+}
+
+// banFilter returns the ban filter, or nil if it is disabled
+func (im *IPManager) banFilter() *banFilter {
+	bf, _ := im.filter.Load().(*banFilter)
+	return bf
+}
+
+// filterAdd records a new ban in the filter; im.mu must be held so the ban
+// cannot be lost to a concurrent rebuild
+func (im *IPManager) filterAdd(target string) {
+	if bf := im.banFilter(); bf != nil {
+		bf.add(target)
+	}
+}
+
+// filterMiss reports whether the filter proves an IP is not banned
+func (im *IPManager) filterMiss(ip string) bool {
+	bf := im.banFilter()
+	if bf == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	if bf.mayContain(addr.Unmap()) {
+		filterMaybes.Inc()
+		return false
+	}
+	filterMisses.Inc()
+	return true
+}
@@ -0,0 +1,209 @@
+package blacklist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// redisGreylistKey is the hash holding greylist entries as JSON by IP
+const redisGreylistKey = "greylist"
+
+// strikeInterval is the minimum time between counted strikes, so a single
+// burst of rejected requests counts once rather than promoting immediately
+const strikeInterval = time.Minute
+
+// GreylistEntry is a suspicious IP that is treated more strictly than normal
+// traffic but not blocked outright
+type GreylistEntry struct {
+	IP         string    `json:"ip"`
+	Reason     string    `json:"reason"`
+	Strikes    int       `json:"strikes"`
+	Added      time.Time `json:"added"`
+	LastStrike time.Time `json:"last_strike"`
+}
+
+// SetGreylistPolicy sets how many strikes promote a greylisted IP to the
+// blacklist, and how long an IP must stay quiet to be demoted
+func (im *IPManager) SetGreylistPolicy(promoteAfter int, quietPeriod time.Duration) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.greyPromoteAfter = promoteAfter
+	im.greyQuietPeriod = quietPeriod
+}
+
+// IsGreylisted checks if an IP is greylisted and has not yet been quiet long
+// enough to be demoted
+func (im *IPManager) IsGreylisted(ip string) bool {
+	ip = canonicalIP(ip)
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	entry, exists := im.greylist[ip]
+	return exists && !im.isQuiet(entry, time.Now())
+}
+
+func (im *IPManager) isQuiet(entry *GreylistEntry, now time.Time) bool {
+	return im.greyQuietPeriod > 0 && now.Sub(entry.LastStrike) >= im.greyQuietPeriod
+}
+
+// Strike records abuse by an IP. The first strike greylists it; once it
+// reaches the promotion threshold the IP is moved to the blacklist for
+// banDuration. Strikes less than strikeInterval apart count once. It reports
+// whether the IP was promoted.
+func (im *IPManager) Strike(ctx context.Context, ip, reason string, banDuration time.Duration) (bool, error) {
+	ip = canonicalIP(ip)
+	if im.IsWhitelisted(ctx, ip) {
+		return false, nil
+	}
+
+	now := time.Now()
+	im.mu.Lock()
+	entry, exists := im.greylist[ip]
+	if !exists || im.isQuiet(entry, now) {
+		entry = &GreylistEntry{IP: ip, Added: now}
+		im.greylist[ip] = entry
+	} else if now.Sub(entry.LastStrike) < strikeInterval {
+		im.mu.Unlock()
+		return false, nil
+	}
+	entry.Strikes++
+	entry.LastStrike = now
+	entry.Reason = reason
+
+	promote := im.greyPromoteAfter > 0 && entry.Strikes >= im.greyPromoteAfter
+	if promote {
+		delete(im.greylist, ip)
+	}
+	saved := *entry
+	im.mu.Unlock()
+
+	if promote {
+		im.deleteGreylistEntry(ctx, ip)
+		return true, im.AutoBlacklistIP(ctx, ip, banDuration, Origin{
+			Source: SourceGreylist,
+			Reason: fmt.Sprintf("promoted after %d strikes: %s", saved.Strikes, reason),
+		})
+	}
+	return false, im.saveGreylistEntry(ctx, &saved)
+}
+
+// RemoveFromGreylist removes an IP from the greylist
+func (im *IPManager) RemoveFromGreylist(ctx context.Context, ip string) error {
+	ip = canonicalIP(ip)
+
+	im.mu.Lock()
+	delete(im.greylist, ip)
+	im.mu.Unlock()
+
+	return im.deleteGreylistEntry(ctx, ip)
+}
+
+// LookupGreylist returns the active greylist entry for an IP
+func (im *IPManager) LookupGreylist(ip string) (GreylistEntry, bool) {
+	ip = canonicalIP(ip)
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	entry, exists := im.greylist[ip]
+	if !exists || im.isQuiet(entry, time.Now()) {
+		return GreylistEntry{}, false
+	}
+	return *entry, true
+}
+
+// GetGreylist returns the active greylist entries, most strikes first
+func (im *IPManager) GetGreylist() []GreylistEntry {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	now := time.Now()
+	result := make([]GreylistEntry, 0, len(im.greylist))
+	for _, entry := range im.greylist {
+		if !im.isQuiet(entry, now) {
+			result = append(result, *entry)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Strikes != result[j].Strikes {
+			return result[i].Strikes > result[j].Strikes
+		}
+		return result[i].IP < result[j].IP
+	})
+	return result
+}
+
+// DemoteQuietEntries drops greylisted IPs that have been quiet for the quiet
+// period and returns them
+func (im *IPManager) DemoteQuietEntries(ctx context.Context) []string {
+	now := time.Now()
+
+	im.mu.Lock()
+	var demoted []string
+	for ip, entry := range im.greylist {
+		if im.isQuiet(entry, now) {
+			delete(im.greylist, ip)
+			demoted = append(demoted, ip)
+		}
+	}
+	im.mu.Unlock()
+
+	for _, ip := range demoted {
+		im.deleteGreylistEntry(ctx, ip)
+	}
+	return demoted
+}
+
+// LoadGreylist refreshes the local greylist from Redis so IPs greylisted on
+// other nodes are treated strictly here too
+func (im *IPManager) LoadGreylist(ctx context.Context) error {
+	if im.client == nil {
+		return nil
+	}
+
+	values, err := im.client.HGetAll(ctx, im.key(redisGreylistKey)).Result()
+	if err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for ip, value := range values {
+		var entry GreylistEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			continue
+		}
+		if local, exists := im.greylist[ip]; exists && local.LastStrike.After(entry.LastStrike) {
+			continue
+		}
+		im.greylist[ip] = &entry
+	}
+
+	return nil
+}
+
+func (im *IPManager) saveGreylistEntry(ctx context.Context, entry *GreylistEntry) error {
+	if im.client == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return im.client.HSet(ctx, im.key(redisGreylistKey), entry.IP, data).Err()
+}
+
+func (im *IPManager) deleteGreylistEntry(ctx context.Context, ip string) error {
+	if im.client == nil {
+		return nil
+	}
+	return im.client.HDel(ctx, im.key(redisGreylistKey), ip).Err()
+}
@@ -0,0 +1,320 @@
+package blacklist
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit is the page size used when none is requested
+	DefaultListLimit = 100
+	// MaxListLimit caps the page size of list queries
+	MaxListLimit = 1000
+)
+
+// Sort keys for blacklist listings
+const (
+	SortAdded   = "added"
+	SortExpires = "expires"
+	SortHits    = "hits"
+	SortTarget  = "target"
+)
+
+// ListQuery selects and orders a page of list entries. Zero fields match
+// everything.
+type ListQuery struct {
+	// Net selects entries overlapping a range, so both IPs inside it and
+	// ranges containing it match
+	Net           netip.Prefix
+	Source        Source
+	Feed          string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	Sort          string
+	Ascending     bool
+	Offset        int
+	Limit         int
+	// Tenant picks whose isolated lists are listed, the shared ones if
+	// empty. It is for the caller to route the query to that tenant's
+	// manager.
+	Tenant string
+}
+
+// ListPage is a page of blacklist entries. Total counts every matching
+// entry, not just those on the page.
+type ListPage struct {
+	Entries []Entry `json:"blacklisted"`
+	Total   int     `json:"total"`
+	Offset  int     `json:"offset"`
+	Limit   int     `json:"limit"`
+}
+
+// WhitelistPage is a page of whitelisted IPs in address order
+type WhitelistPage struct {
+	Entries []WhitelistEntry `json:"whitelisted"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+}
+
+// ParseListQuery reads a list query from URL parameters:
+//
+//	cidr=10.0.0.0/8              entries overlapping an IP or range
+//	source=feed feed=spamhaus    entry origin
+//	expires_after=1h expires_before=2024-05-01T12:00:00Z
+//	                             relative duration or RFC 3339 time
+//	sort=added|expires|hits|target order=asc|desc
+//	offset=0 limit=100
+//
+// Relative times are resolved against now. Listings default to newest first.
+func ParseListQuery(values url.Values, now time.Time) (ListQuery, error) {
+	q := ListQuery{
+		Tenant: strings.ToLower(values.Get("tenant")),
+		Source: Source(values.Get("source")),
+		Feed:   values.Get("feed"),
+		Sort:   values.Get("sort"),
+	}
+
+	if cidr := values.Get("cidr"); cidr != "" {
+		prefix, err := parseNet(cidr)
+		if err != nil {
+			return q, err
+		}
+		q.Net = prefix
+	}
+
+	var err error
+	if q.ExpiresAfter, err = parseListTime(values.Get("expires_after"), now); err != nil {
+		return q, fmt.Errorf("invalid expires_after: %v", err)
+	}
+	if q.ExpiresBefore, err = parseListTime(values.Get("expires_before"), now); err != nil {
+		return q, fmt.Errorf("invalid expires_before: %v", err)
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = SortAdded
+	case SortAdded, SortExpires, SortHits, SortTarget:
+	default:
+		return q, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+
+	if q.Offset, err = parseListInt(values.Get("offset")); err != nil {
+		return q, fmt.Errorf("invalid offset: %v", err)
+	}
+	if q.Limit, err = parseListInt(values.Get("limit")); err != nil {
+		return q, fmt.Errorf("invalid limit: %v", err)
+	}
+
+	return q, nil
+}
+
+// parseNet parses an IP or CIDR range
+func parseNet(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %q", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseListTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseListInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative integer", value)
+	}
+	return n, nil
+}
+
+// window clamps the page bounds of q to n matching entries
+func (q *ListQuery) window(n int) (start, end, limit int) {
+	limit = q.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	start = q.Offset
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	if end > n {
+		end = n
+	}
+	return start, end, limit
+}
+
+func (q *ListQuery) match(entry *Entry, now time.Time) bool {
+	if !now.Before(entry.Expires) {
+		return false
+	}
+	if q.Source != "" && entry.Source != q.Source {
+		return false
+	}
+	if q.Feed != "" && entry.Feed != q.Feed {
+		return false
+	}
+	if !q.ExpiresAfter.IsZero() && !entry.Expires.After(q.ExpiresAfter) {
+		return false
+	}
+	if !q.ExpiresBefore.IsZero() && !entry.Expires.Before(q.ExpiresBefore) {
+		return false
+	}
+	if q.Net.IsValid() {
+		prefix, err := parseNet(entry.Target)
+		if err != nil || !prefix.Overlaps(q.Net) {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders entries by the query's sort key, breaking ties by target
+func (q *ListQuery) less(a, b *Entry) bool {
+	var cmp int
+	switch q.Sort {
+	case SortExpires:
+		cmp = compareTimes(a.Expires, b.Expires)
+	case SortHits:
+		cmp = compareInts(a.Hits, b.Hits)
+	case SortTarget:
+		cmp = strings.Compare(a.Target, b.Target)
+	default:
+		cmp = compareTimes(a.Added, b.Added)
+	}
+	if cmp == 0 {
+		return a.Target < b.Target
+	}
+	if q.Ascending {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ListBlacklist returns a page of blacklisted IPs and ranges matching q
+func (im *IPManager) ListBlacklist(q ListQuery) ListPage {
+	now := time.Now()
+
+	im.mu.RLock()
+	matched := make([]Entry, 0)
+	for _, entry := range im.blacklistedIPs {
+		if q.match(entry, now) {
+			matched = append(matched, entry.snapshot())
+		}
+	}
+	for _, entry := range im.blacklistedNets {
+		if q.match(entry, now) {
+			matched = append(matched, entry.snapshot())
+		}
+	}
+	im.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return q.less(&matched[i], &matched[j])
+	})
+
+	start, end, limit := q.window(len(matched))
+	return ListPage{
+		Entries: matched[start:end],
+		Total:   len(matched),
+		Offset:  start,
+		Limit:   limit,
+	}
+}
+
+// ListWhitelist returns a page of unexpired whitelisted IPs in q.Net, in
+// address order. Only the range and page bounds of q apply.
+func (im *IPManager) ListWhitelist(q ListQuery) WhitelistPage {
+	type whitelisted struct {
+		addr    netip.Addr
+		expires time.Time
+	}
+
+	now := time.Now()
+	im.mu.RLock()
+	matched := make([]whitelisted, 0, len(im.whitelistedIPs))
+	for ip, expires := range im.whitelistedIPs {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !im.whitelistedLocked(ip, now) {
+			continue
+		}
+		if !q.Net.IsValid() || q.Net.Contains(addr) {
+			matched = append(matched, whitelisted{addr, expires})
+		}
+	}
+	im.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].addr.Less(matched[j].addr)
+	})
+
+	start, end, limit := q.window(len(matched))
+	entries := make([]WhitelistEntry, 0, end-start)
+	for _, w := range matched[start:end] {
+		entries = append(entries, newWhitelistEntry(w.addr.String(), w.expires))
+	}
+	return WhitelistPage{
+		Entries: entries,
+		Total:   len(matched),
+		Offset:  start,
+		Limit:   limit,
+	}
+}
@@ -4,85 +4,299 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ddos-protection/internal/killswitch"
+
 	"github.com/go-redis/redis/v8"
 )
 
 // IPManager manages IP blacklisting and whitelisting
 type IPManager struct {
 	client           *redis.Client
-	blacklistedIPs   map[string]time.Time
-	whitelistedIPs   map[string]bool
+	blacklistedIPs   map[string]*Entry
+	blacklistedNets  map[netip.Prefix]*Entry
+	whitelistedIPs   map[string]time.Time
+	greylist         map[string]*GreylistEntry
+	greyPromoteAfter int
+	greyQuietPeriod  time.Duration
 	mu               sync.RWMutex
 	autoBlacklist    bool
 	threshold        int
 	blacklistDur     time.Duration
+	v6AutoPrefix     int
+	asnDB            *ASNDatabase
+	asnRules         map[uint32]ASNRule
+	killSwitches     *killswitch.Registry
 	redisPrefix      string
+	tenant           string
+	namespace        string
+	nodeID           string
+	onChange         []func(target string)
+	onEvent          []func(ctx context.Context, event ListEvent)
+	escalation       *Escalation
+	streaks          map[string]*streak
+	filter           atomic.Value
+	filterSize       int
+	filterRate       float64
+	bans             *banEventQueue
+	subnetEscalation *SubnetEscalation
+	subnetBans       map[netip.Prefix]map[string]time.Time
+	capacity         capacity
 }
 
+const (
+	// redisNetsKey is the sorted set holding blacklisted CIDRs scored by expiry
+	redisNetsKey = "blacklist:nets"
+	// redisNetsMetaKey is the hash holding blacklisted CIDR entries as JSON
+	redisNetsMetaKey = "blacklist:nets:meta"
+)
+
+// DefaultIPv6AutoPrefix is the prefix length used when auto-blacklisting
+// IPv6 clients, since a single subscriber usually owns a whole /64
+const DefaultIPv6AutoPrefix = 64
+
 // NewIPManager creates a new IP manager
 func NewIPManager(client *redis.Client, autoBlacklist bool, threshold int, blacklistDur time.Duration) *IPManager {
 	return &IPManager{
-		client:           client,
-		blacklistedIPs:   make(map[string]time.Time),
-		whitelistedIPs:   make(map[string]bool),
-		autoBlacklist:    autoBlacklist,
-		threshold:        threshold,
-		blacklistDur:     blacklistDur,
-		redisPrefix:      "blacklist:",
+		client:          client,
+		blacklistedIPs:  make(map[string]*Entry),
+		blacklistedNets: make(map[netip.Prefix]*Entry),
+		whitelistedIPs:  make(map[string]time.Time),
+		greylist:        make(map[string]*GreylistEntry),
+		autoBlacklist:   autoBlacklist,
+		threshold:       threshold,
+		blacklistDur:    blacklistDur,
+		v6AutoPrefix:    DefaultIPv6AutoPrefix,
+		asnRules:        make(map[uint32]ASNRule),
+		redisPrefix:     "blacklist:",
+		nodeID:          newNodeID(),
+		streaks:         make(map[string]*streak),
+		bans:            newBanEventQueue(),
+		subnetBans:      make(map[netip.Prefix]map[string]time.Time),
+	}
+}
+
+// NewTenantIPManager creates an IP manager holding a tenant's own lists.
+// They are kept apart from other managers' in memory and in Redis, where
+// every key is prefixed with the tenant ID.
+func NewTenantIPManager(client *redis.Client, tenant string, autoBlacklist bool, threshold int, blacklistDur time.Duration) *IPManager {
+	im := NewIPManager(client, autoBlacklist, threshold, blacklistDur)
+	im.tenant = tenant
+	im.namespace = "tenant:" + tenant + ":"
+	im.redisPrefix = im.key("blacklist:")
+	return im
+}
+
+// Tenant returns the tenant whose lists the manager holds, or "" for the
+// shared lists
+func (im *IPManager) Tenant() string {
+	return im.tenant
+}
+
+// key returns the Redis key for name in the manager's namespace
+func (im *IPManager) key(name string) string {
+	return im.namespace + name
+}
+
+// SetIPv6AutoPrefix sets the prefix length used when auto-blacklisting IPv6 clients
+func (im *IPManager) SetIPv6AutoPrefix(bits int) {
+	if bits <= 0 || bits > 128 {
+		return
 	}
+	im.v6AutoPrefix = bits
 }
 
-// IsBlacklisted checks if an IP is blacklisted
+// SetKillSwitches lets feed kill switches disable the entries a feed added
+func (im *IPManager) SetKillSwitches(registry *killswitch.Registry) {
+	im.killSwitches = registry
+}
+
+// Hit counts a request rejected by the blacklist entry for an IP or CIDR
+// range without matching it again
+func (im *IPManager) Hit(target string) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	if entry, exists := im.blacklistedIPs[target]; exists {
+		entry.hit()
+		return
+	}
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		if entry, exists := im.blacklistedNets[prefix]; exists {
+			entry.hit()
+		}
+	}
+}
+
+// IsBlacklisted checks if an IP is blacklisted, either directly or through a blacklisted CIDR
 func (im *IPManager) IsBlacklisted(ctx context.Context, ip string) bool {
+	_, blacklisted := im.Match(ctx, ip)
+	return blacklisted
+}
+
+// Match returns the blacklist entry an IP is banned by, counting the hit
+func (im *IPManager) Match(ctx context.Context, ip string) (Entry, bool) {
+	entry, _, banned := im.Check(ctx, ip)
+	return entry, banned
+}
+
+// Check looks an IP up in both lists: whether it is whitelisted, and if not
+// the blacklist entry it is banned by, counting the hit. Most clients are
+// not banned, and when the ban filter proves it only the local whitelist is
+// consulted, sparing the Redis round trips.
+func (im *IPManager) Check(ctx context.Context, ip string) (entry Entry, whitelisted, banned bool) {
+	ip = canonicalIP(ip)
+	if im.filterMiss(ip) {
+		im.mu.RLock()
+		defer im.mu.RUnlock()
+		return Entry{}, im.whitelistedLocked(ip, time.Now()), false
+	}
+
 	// Check whitelist first (whitelist overrides blacklist)
 	if im.IsWhitelisted(ctx, ip) {
-		return false
+		return Entry{}, true, false
+	}
+	entry, banned = im.matchBanned(ctx, ip)
+	return entry, false, banned
+}
+
+// matchBanned returns the blacklist entry a canonical IP that is not
+// whitelisted is banned by, counting the hit
+func (im *IPManager) matchBanned(ctx context.Context, ip string) (Entry, bool) {
+	if entry := im.matchNet(ip); entry != nil {
+		entry.hit()
+		return entry.snapshot(), true
 	}
 
 	// Check local cache first
+	now := time.Now()
 	im.mu.RLock()
-	if expiry, exists := im.blacklistedIPs[ip]; exists {
-		if time.Now().Before(expiry) {
-			im.mu.RUnlock()
-			return true
-		} else {
-			// Expired, remove from cache
-			im.mu.RUnlock()
-			im.mu.Lock()
-			delete(im.blacklistedIPs, ip)
-			im.mu.Unlock()
+	entry, exists := im.blacklistedIPs[ip]
+	im.mu.RUnlock()
+	if exists {
+		if now.Before(entry.Expires) {
+			if !im.enforced(entry) {
+				return Entry{}, false
+			}
+			entry.hit()
+			return entry.snapshot(), true
 		}
-	} else {
-		im.mu.RUnlock()
+
+		// Expired, remove from cache
+		im.mu.Lock()
+		im.expireLocked(entry)
+		im.mu.Unlock()
 	}
 
-	// Check Redis
+	// Check Redis, caching entries added on other nodes
 	if im.client != nil {
 		redisKey := im.redisPrefix + ip
-		exists, err := im.client.Exists(ctx, redisKey).Result()
-		if err == nil && exists > 0 {
-			return true
+		value, err := im.client.Get(ctx, redisKey).Result()
+		if err != nil {
+			return Entry{}, false
+		}
+		ttl, err := im.client.TTL(ctx, redisKey).Result()
+		if err != nil || ttl <= 0 {
+			ttl = im.blacklistDur
+		}
+
+		entry := parseEntry(ip, value, now.Add(ttl))
+		if !im.enforced(entry) {
+			return Entry{}, false
 		}
+		entry.hit()
+
+		im.mu.Lock()
+		old := im.blacklistedIPs[ip]
+		im.blacklistedIPs[ip] = entry
+		im.banAdded(entry, old, false)
+		im.mu.Unlock()
+		return entry.snapshot(), true
 	}
 
-	return false
+	return Entry{}, false
+}
+
+// enforced reports whether an entry applies; entries from a feed whose kill
+// switch is engaged are ignored
+func (im *IPManager) enforced(entry *Entry) bool {
+	return entry.Source != SourceFeed || !im.killSwitches.IsEngaged(killswitch.KindFeed, entry.Feed)
+}
+
+// matchNet returns the enforced blacklisted CIDR containing an IP, if any
+func (im *IPManager) matchNet(ip string) *Entry {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	now := time.Now()
+	for prefix, entry := range im.blacklistedNets {
+		if now.Before(entry.Expires) && prefix.Contains(addr) && im.enforced(entry) {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the active entry for an IP or CIDR range without counting a
+// hit. An IP with no entry of its own returns the range containing it.
+func (im *IPManager) Lookup(target string) (Entry, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	now := time.Now()
+	if strings.Contains(target, "/") {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return Entry{}, false
+		}
+		if entry, exists := im.blacklistedNets[prefix.Masked()]; exists && now.Before(entry.Expires) {
+			return entry.snapshot(), true
+		}
+		return Entry{}, false
+	}
+
+	ip := canonicalIP(target)
+	if entry, exists := im.blacklistedIPs[ip]; exists && now.Before(entry.Expires) {
+		return entry.snapshot(), true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Entry{}, false
+	}
+	for prefix, entry := range im.blacklistedNets {
+		if now.Before(entry.Expires) && prefix.Contains(addr) {
+			return entry.snapshot(), true
+		}
+	}
+	return Entry{}, false
 }
 
 // IsWhitelisted checks if an IP is whitelisted
 func (im *IPManager) IsWhitelisted(ctx context.Context, ip string) bool {
+	ip = canonicalIP(ip)
+
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 
-	if im.whitelistedIPs[ip] {
+	if im.whitelistedLocked(ip, time.Now()) {
 		return true
 	}
 
 	// Check Redis for whitelist
 	if im.client != nil {
-		redisKey := "whitelist:" + ip
+		redisKey := im.key("whitelist:" + ip)
 		exists, err := im.client.Exists(ctx, redisKey).Result()
 		return err == nil && exists > 0
 	}
@@ -90,126 +304,402 @@ func (im *IPManager) IsWhitelisted(ctx context.Context, ip string) bool {
 	return false
 }
 
-// BlacklistIP adds an IP to the blacklist
-func (im *IPManager) BlacklistIP(ctx context.Context, ip string, duration time.Duration) error {
+// BlacklistIP adds an IP or CIDR range to the blacklist, recording where the
+// entry came from. Re-blacklisting an entry keeps its hit count.
+func (im *IPManager) BlacklistIP(ctx context.Context, ip string, duration time.Duration, origin Origin) error {
+	if strings.Contains(ip, "/") {
+		return im.blacklistNet(ctx, ip, duration, origin)
+	}
+	ip = canonicalIP(ip)
+
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
 	// Don't blacklist whitelisted IPs
-	if im.whitelistedIPs[ip] {
+	if im.whitelistedLocked(ip, time.Now()) {
 		return fmt.Errorf("cannot blacklist whitelisted IP: %s", ip)
 	}
 
-	expiry := time.Now().Add(duration)
-	im.blacklistedIPs[ip] = expiry
+	entry := newEntry(ip, duration, origin)
+	old, exists := im.blacklistedIPs[ip]
+	if exists {
+		entry.Hits = old.snapshot().Hits
+	}
+	im.blacklistedIPs[ip] = entry
+	im.banAdded(entry, old, true)
+	im.changed(ip)
 
 	// Also store in Redis if available
 	if im.client != nil {
 		redisKey := im.redisPrefix + ip
-		return im.client.Set(ctx, redisKey, "1", duration).Err()
+		if err := im.client.Set(ctx, redisKey, entry.marshal(), duration).Err(); err != nil {
+			return err
+		}
 	}
+	im.publish(ctx, ListEvent{Op: OpBlacklistAdd, Target: ip, Entry: entry.copy()})
 
 	return nil
 }
 
-// WhitelistIP adds an IP to the whitelist
-func (im *IPManager) WhitelistIP(ctx context.Context, ip string) error {
+// blacklistNet adds a CIDR range to the blacklist
+func (im *IPManager) blacklistNet(ctx context.Context, cidr string, duration time.Duration, origin Origin) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %s: %v", cidr, err)
+	}
+	prefix = prefix.Masked()
+
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
-	im.whitelistedIPs[ip] = true
+	entry := newEntry(prefix.String(), duration, origin)
+	old, exists := im.blacklistedNets[prefix]
+	if exists {
+		entry.Hits = old.snapshot().Hits
+	}
+	im.blacklistedNets[prefix] = entry
+	im.banAdded(entry, old, true)
+	im.changed(prefix.String())
 
-	// Also store in Redis if available
 	if im.client != nil {
-		redisKey := "whitelist:" + ip
-		return im.client.Set(ctx, redisKey, "1", 0).Err() // No expiry for whitelist
+		if err := im.client.HSet(ctx, im.key(redisNetsMetaKey), prefix.String(), entry.marshal()).Err(); err != nil {
+			return err
+		}
+		if err := im.client.ZAdd(ctx, im.key(redisNetsKey), &redis.Z{
+			Score:  float64(entry.Expires.Unix()),
+			Member: prefix.String(),
+		}).Err(); err != nil {
+			return err
+		}
+	}
+	im.publish(ctx, ListEvent{Op: OpBlacklistAdd, Target: prefix.String(), Entry: entry.copy()})
+
+	return nil
+}
+
+// AutoBlacklistIP blacklists a misbehaving client. IPv4 clients are banned by
+// address; IPv6 clients are banned by their enclosing prefix (a /64 by default)
+// because rotating addresses within it is free. With escalation enabled,
+// repeat offenders are banned for longer each time, and with subnet
+// escalation enabled a subnet with many banned clients is banned whole.
+func (im *IPManager) AutoBlacklistIP(ctx context.Context, ip string, duration time.Duration, origin Origin) error {
+	target := canonicalIP(ip)
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return im.BlacklistIP(ctx, ip, duration, origin)
+	}
+
+	if im.IsWhitelisted(ctx, addr.String()) {
+		return fmt.Errorf("cannot blacklist whitelisted IP: %s", ip)
+	}
+
+	if !addr.Is4() {
+		prefix, err := addr.Prefix(im.v6AutoPrefix)
+		if err != nil {
+			return err
+		}
+		target = prefix.String()
+	}
+
+	duration, origin.Offense = im.escalate(ctx, target, duration, origin)
+	if err := im.BlacklistIP(ctx, target, duration, origin); err != nil {
+		return err
+	}
+
+	_, err = im.escalateSubnet(ctx, target, origin)
+	return err
+}
+
+// LoadBlacklistedNets refreshes the local CIDR cache from Redis so ranges
+// banned on other nodes are enforced here too
+func (im *IPManager) LoadBlacklistedNets(ctx context.Context) error {
+	if im.client == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := im.client.ZRemRangeByScore(ctx, im.key(redisNetsKey), "0", fmt.Sprintf("%d", now.Unix())).Err(); err != nil {
+		return err
+	}
+
+	members, err := im.client.ZRangeWithScores(ctx, im.key(redisNetsKey), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	meta, err := im.client.HGetAll(ctx, im.key(redisNetsMetaKey)).Result()
+	if err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, member := range members {
+		cidr, ok := member.Member.(string)
+		if !ok {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+
+		entry := parseEntry(cidr, meta[cidr], time.Unix(int64(member.Score), 0))
+		old, exists := im.blacklistedNets[prefix]
+		if exists {
+			entry.Hits = old.snapshot().Hits
+		}
+		im.blacklistedNets[prefix] = entry
+		im.banAdded(entry, old, false)
+		delete(meta, cidr)
+	}
+
+	// Whatever is left describes ranges that expired or were removed
+	if len(meta) > 0 {
+		stale := make([]string, 0, len(meta))
+		for cidr := range meta {
+			stale = append(stale, cidr)
+		}
+		im.client.HDel(ctx, im.key(redisNetsMetaKey), stale...)
+	}
+
+	return nil
+}
+
+// WhitelistIP adds an IP to the whitelist for duration, or permanently if
+// duration is zero
+func (im *IPManager) WhitelistIP(ctx context.Context, ip string, duration time.Duration) error {
+	ip = canonicalIP(ip)
+
+	var expires time.Time
+	if duration > 0 {
+		expires = time.Now().Add(duration)
+	} else {
+		duration = 0
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.whitelistedIPs[ip] = expires
+	im.changed(ip)
+
+	// Also store in Redis if available; Redis expires temporary entries
+	if im.client != nil {
+		redisKey := im.key("whitelist:" + ip)
+		if err := im.client.Set(ctx, redisKey, "1", duration).Err(); err != nil {
+			return err
+		}
 	}
+	im.publish(ctx, ListEvent{Op: OpWhitelistAdd, Target: ip, Expires: expires})
 
 	return nil
 }
 
-// RemoveFromBlacklist removes an IP from the blacklist
+// RemoveFromBlacklist removes an IP or CIDR range from the blacklist
 func (im *IPManager) RemoveFromBlacklist(ctx context.Context, ip string) error {
+	if strings.Contains(ip, "/") {
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", ip, err)
+		}
+		prefix = prefix.Masked()
+
+		im.mu.Lock()
+		defer im.mu.Unlock()
+
+		im.banRemoved(im.blacklistedNets[prefix], true)
+		delete(im.blacklistedNets, prefix)
+		im.changed(prefix.String())
+		if im.client != nil {
+			if err := im.client.HDel(ctx, im.key(redisNetsMetaKey), prefix.String()).Err(); err != nil {
+				return err
+			}
+			if err := im.client.ZRem(ctx, im.key(redisNetsKey), prefix.String()).Err(); err != nil {
+				return err
+			}
+		}
+		im.publish(ctx, ListEvent{Op: OpBlacklistRemove, Target: prefix.String()})
+		return nil
+	}
+	ip = canonicalIP(ip)
+
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
+	im.banRemoved(im.blacklistedIPs[ip], true)
 	delete(im.blacklistedIPs, ip)
+	im.changed(ip)
 
 	// Also remove from Redis
 	if im.client != nil {
 		redisKey := im.redisPrefix + ip
-		return im.client.Del(ctx, redisKey).Err()
+		if err := im.client.Del(ctx, redisKey).Err(); err != nil {
+			return err
+		}
 	}
+	im.publish(ctx, ListEvent{Op: OpBlacklistRemove, Target: ip})
 
 	return nil
 }
 
 // RemoveFromWhitelist removes an IP from the whitelist
 func (im *IPManager) RemoveFromWhitelist(ctx context.Context, ip string) error {
+	ip = canonicalIP(ip)
+
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
 	delete(im.whitelistedIPs, ip)
+	im.changed(ip)
 
 	// Also remove from Redis
 	if im.client != nil {
-		redisKey := "whitelist:" + ip
-		return im.client.Del(ctx, redisKey).Err()
+		redisKey := im.key("whitelist:" + ip)
+		if err := im.client.Del(ctx, redisKey).Err(); err != nil {
+			return err
+		}
 	}
+	im.publish(ctx, ListEvent{Op: OpWhitelistRemove, Target: ip})
 
 	return nil
 }
 
-// GetClientIP extracts the real client IP from request headers
-func GetClientIP(req interface{}) string {
-	// This is a generic interface - in practice, you'd implement this
-	// for your specific HTTP framework (Gin, Echo, etc.)
-	// For now, return a placeholder
-	return "127.0.0.1"
+// trustedProxies holds the []netip.Prefix of proxies whose forwarding
+// headers GetClientIP believes
+var trustedProxies atomic.Value
+
+// SetTrustedProxies sets the addresses or CIDR ranges of the proxies in
+// front of this service. Only they are believed about where a request came
+// from; with none, the connection's remote address is the client.
+func SetTrustedProxies(proxies []string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		var prefix netip.Prefix
+		if strings.Contains(proxy, "/") {
+			p, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+			}
+			prefix = p.Masked()
+		} else {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	trustedProxies.Store(prefixes)
+	return nil
 }
 
-// IsValidIP checks if the IP address is valid
-func IsValidIP(ip string) bool {
-	return net.ParseIP(ip) != nil
+// isTrustedProxy reports whether addr belongs to a trusted proxy
+func isTrustedProxy(addr netip.Addr) bool {
+	prefixes, _ := trustedProxies.Load().([]netip.Prefix)
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
-// IsPrivateIP checks if the IP is in private ranges
-func IsPrivateIP(ip string) bool {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return false
+// GetClientIP returns the address of the client behind a request. Starting
+// from the connection's remote address it walks X-Forwarded-For from the
+// right, the hops proxies appended, as long as the address so far is a
+// trusted proxy; the first untrusted hop is the client, since everything
+// left of it could have been sent by the client itself. X-Real-IP is only
+// read when a trusted proxy sent no X-Forwarded-For. The result is in
+// canonical form with IPv4-mapped IPv6 addresses unmapped.
+func GetClientIP(req *http.Request) string {
+	// RemoteAddr is host:port with IPv6 hosts in brackets
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
 	}
-
-	privateRanges := []string{
-		"10.0.0.0/8",
-		"172.16.0.0/12",
-		"192.168.0.0/16",
-		"127.0.0.0/8",
+	client, err := netip.ParseAddr(host)
+	if err != nil {
+		return canonicalIP(host)
+	}
+	client = client.Unmap()
+	if !isTrustedProxy(client) {
+		return client.String()
 	}
 
-	for _, cidr := range privateRanges {
-		_, network, _ := net.ParseCIDR(cidr)
-		if network.Contains(parsedIP) {
-			return true
+	hops := req.Header.Values("X-Forwarded-For")
+	if len(hops) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
 		}
+		return client.String()
 	}
+	hops = strings.Split(strings.Join(hops, ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(client); i-- {
+		// A hop a trusted proxy could not name ends the walk at that proxy
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+	}
+	return client.String()
+}
 
-	return false
+// canonicalIP returns the canonical form of an IP address, or the input
+// unchanged if it does not parse
+func canonicalIP(ip string) string {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().String()
+}
+
+// IsValidIP checks if the IP address is valid
+func IsValidIP(ip string) bool {
+	_, err := netip.ParseAddr(ip)
+	return err == nil
+}
+
+// IsValidIPOrCIDR checks if the value is a valid IP address or CIDR range
+func IsValidIPOrCIDR(value string) bool {
+	if strings.Contains(value, "/") {
+		_, err := netip.ParsePrefix(value)
+		return err == nil
+	}
+	return IsValidIP(value)
 }
 
-// GetCIDRRange returns the CIDR range for a given IP
+// IsPrivateIP checks if the IP is in private or loopback ranges (RFC 1918,
+// RFC 4193 unique local addresses, and loopback for both families)
+func IsPrivateIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	return addr.IsPrivate() || addr.IsLoopback()
+}
+
+// GetCIDRRange returns the CIDR range of the given prefix length containing ip.
+// The prefix length is interpreted against the address family of ip.
 func GetCIDRRange(ip string, prefixLen int) string {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return ""
 	}
 
-	ipNet := &net.IPNet{
-		IP:   parsedIP,
-		Mask: net.CIDRMask(prefixLen, 32),
+	prefix, err := addr.Unmap().Prefix(prefixLen)
+	if err != nil {
+		return ""
 	}
 
-	return ipNet.String()
+	return prefix.String()
 }
 
 // ShouldAutoBlacklist determines if an IP should be auto-blacklisted based on request count
@@ -231,36 +721,100 @@ func (im *IPManager) CleanupExpiredEntries() {
 	defer im.mu.Unlock()
 
 	now := time.Now()
-	for ip, expiry := range im.blacklistedIPs {
-		if now.After(expiry) {
-			delete(im.blacklistedIPs, ip)
+	for _, entry := range im.blacklistedIPs {
+		if now.After(entry.Expires) {
+			im.expireLocked(entry)
+		}
+	}
+	for _, entry := range im.blacklistedNets {
+		if now.After(entry.Expires) {
+			im.expireLocked(entry)
+		}
+	}
+	for ip, expires := range im.whitelistedIPs {
+		if !expires.IsZero() && now.After(expires) {
+			delete(im.whitelistedIPs, ip)
 		}
 	}
+	im.cleanupStreaks(now)
+	im.cleanupSubnetBans(now)
 }
 
-// GetBlacklistedIPs returns a copy of currently blacklisted IPs
-func (im *IPManager) GetBlacklistedIPs() map[string]time.Time {
+// GetBlacklist returns the currently blacklisted IPs and CIDR ranges with
+// their metadata, newest first
+func (im *IPManager) GetBlacklist() []Entry {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 
-	result := make(map[string]time.Time)
-	for ip, expiry := range im.blacklistedIPs {
-		if time.Now().Before(expiry) {
-			result[ip] = expiry
+	now := time.Now()
+	result := make([]Entry, 0, len(im.blacklistedIPs)+len(im.blacklistedNets))
+	for _, entry := range im.blacklistedIPs {
+		if now.Before(entry.Expires) {
+			result = append(result, entry.snapshot())
+		}
+	}
+	for _, entry := range im.blacklistedNets {
+		if now.Before(entry.Expires) {
+			result = append(result, entry.snapshot())
 		}
 	}
 
+	sortEntries(result)
 	return result
 }
 
-// GetWhitelistedIPs returns a copy of whitelisted IPs
-func (im *IPManager) GetWhitelistedIPs() []string {
+// EnforcedBlacklist returns the unexpired blacklist entries that apply,
+// leaving out those of feeds disabled by a kill switch
+func (im *IPManager) EnforcedBlacklist() []Entry {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 
-	var result []string
-	for ip := range im.whitelistedIPs {
-		result = append(result, ip)
+	now := time.Now()
+	result := make([]Entry, 0, len(im.blacklistedIPs)+len(im.blacklistedNets))
+	for _, entry := range im.blacklistedIPs {
+		if now.Before(entry.Expires) && im.enforced(entry) {
+			result = append(result, entry.snapshot())
+		}
+	}
+	for _, entry := range im.blacklistedNets {
+		if now.Before(entry.Expires) && im.enforced(entry) {
+			result = append(result, entry.snapshot())
+		}
+	}
+	return result
+}
+
+// whitelistedLocked reports whether an IP has an unexpired local whitelist
+// entry. The caller must hold im.mu.
+func (im *IPManager) whitelistedLocked(ip string, now time.Time) bool {
+	expires, exists := im.whitelistedIPs[ip]
+	return exists && (expires.IsZero() || now.Before(expires))
+}
+
+// LookupWhitelist returns the local whitelist entry for an IP
+func (im *IPManager) LookupWhitelist(ip string) (WhitelistEntry, bool) {
+	ip = canonicalIP(ip)
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	if !im.whitelistedLocked(ip, time.Now()) {
+		return WhitelistEntry{}, false
+	}
+	return newWhitelistEntry(ip, im.whitelistedIPs[ip]), true
+}
+
+// GetWhitelist returns the unexpired whitelist entries
+func (im *IPManager) GetWhitelist() []WhitelistEntry {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	now := time.Now()
+	result := make([]WhitelistEntry, 0, len(im.whitelistedIPs))
+	for ip, expires := range im.whitelistedIPs {
+		if im.whitelistedLocked(ip, now) {
+			result = append(result, newWhitelistEntry(ip, expires))
+		}
 	}
 
 	return result
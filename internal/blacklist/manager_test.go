@@ -0,0 +1,701 @@
+package blacklist
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ddos-protection/internal/killswitch"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestGetCIDRRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		ip        string
+		prefixLen int
+		expected  string
+	}{
+		{"IPv4 /24", "192.168.1.77", 24, "192.168.1.0/24"},
+		{"IPv6 /64", "2001:db8:abcd:12:1:2:3:4", 64, "2001:db8:abcd:12::/64"},
+		{"IPv4-mapped IPv6", "::ffff:10.1.2.3", 16, "10.1.0.0/16"},
+		{"Invalid IP", "not-an-ip", 24, ""},
+		{"Prefix too long for IPv4", "10.0.0.1", 64, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetCIDRRange(tt.ip, tt.prefixLen); got != tt.expected {
+				t.Errorf("GetCIDRRange(%q, %d) = %q, want %q", tt.ip, tt.prefixLen, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetClientIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "2001:db8:ffff::1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xri        string
+		expected   string
+	}{
+		{"IPv4 remote address", "203.0.113.9:51234", "", "", "203.0.113.9"},
+		{"IPv6 remote address", "[2001:db8::1]:443", "", "", "2001:db8::1"},
+		{"IPv6 in X-Forwarded-For", "10.0.0.1:80", "2001:db8::abcd, 10.0.0.2", "", "2001:db8::abcd"},
+		{"Skips invalid X-Forwarded-For entries", "10.0.0.1:80", "unknown, 198.51.100.4", "", "198.51.100.4"},
+		{"Untrusted peer's X-Forwarded-For ignored", "203.0.113.9:51234", "10.0.0.5", "", "203.0.113.9"},
+		{"Untrusted peer's X-Real-IP ignored", "203.0.113.9:51234", "", "198.51.100.4", "203.0.113.9"},
+		{"Forged hops left of the client ignored", "10.0.0.1:80", "192.0.2.1, 198.51.100.4", "", "198.51.100.4"},
+		{"Trusted IPv6 proxy", "[2001:db8:ffff::1]:443", "198.51.100.4", "", "198.51.100.4"},
+		{"X-Real-IP from a trusted proxy", "10.0.0.1:80", "", "198.51.100.4", "198.51.100.4"},
+		{"Chain of trusted proxies", "10.0.0.1:80", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"Unnamed hop ends the walk", "10.0.0.1:80", "198.51.100.4, unknown", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xri != "" {
+				req.Header.Set("X-Real-IP", tt.xri)
+			}
+
+			if got := GetClientIP(req); got != tt.expected {
+				t.Errorf("GetClientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.300"}); err == nil {
+		t.Error("invalid trusted proxy accepted")
+	}
+}
+
+func TestAutoBlacklistIPv6Prefix(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+
+	if err := im.AutoBlacklistIP(ctx, "2001:db8:1:2::10", time.Hour, Origin{Source: SourceRateLimit}); err != nil {
+		t.Fatalf("AutoBlacklistIP failed: %v", err)
+	}
+
+	if !im.IsBlacklisted(ctx, "2001:db8:1:2:ffff::1") {
+		t.Error("Address in the same /64 should be blacklisted")
+	}
+	if im.IsBlacklisted(ctx, "2001:db8:1:3::10") {
+		t.Error("Address in a different /64 should not be blacklisted")
+	}
+
+	if err := im.RemoveFromBlacklist(ctx, "2001:db8:1:2::/64"); err != nil {
+		t.Fatalf("RemoveFromBlacklist failed: %v", err)
+	}
+	if im.IsBlacklisted(ctx, "2001:db8:1:2::10") {
+		t.Error("Address should not be blacklisted after removing its prefix")
+	}
+}
+
+func TestASNDatabaseLookup(t *testing.T) {
+	data := "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+		"1.0.1.0\t1.0.3.255\t0\tNone\tNot routed\n" +
+		"2001:db8::\t2001:db8:ffff:ffff:ffff:ffff:ffff:ffff\t64496\tZZ\tEXAMPLE-V6\n"
+
+	db, err := parseASNDatabase(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseASNDatabase failed: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		expected uint32
+		found    bool
+	}{
+		{"1.0.0.1", 13335, true},
+		{"1.0.2.1", 0, false},
+		{"2001:db8::42", 64496, true},
+		{"2001:db9::1", 0, false},
+	}
+
+	for _, tt := range tests {
+		info, found := db.Lookup(tt.ip)
+		if found != tt.found || info.Number != tt.expected {
+			t.Errorf("Lookup(%q) = AS%d, %v; want AS%d, %v", tt.ip, info.Number, found, tt.expected, tt.found)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lists.json")
+
+	im := NewIPManager(nil, true, 100, time.Hour)
+	im.BlacklistIP(ctx, "198.51.100.7", time.Hour, Origin{Source: SourceManual, Reason: "abuse report"})
+	im.BlacklistIP(ctx, "2001:db8:5::/48", time.Hour, Origin{Source: SourceBotnet})
+	im.BlacklistIP(ctx, "198.51.100.8", -time.Minute, Origin{Source: SourceManual})
+	im.WhitelistIP(ctx, "192.0.2.10", 0)
+	im.WhitelistIP(ctx, "192.0.2.11", time.Hour)
+
+	if err := im.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	restored := NewIPManager(nil, true, 100, time.Hour)
+	n, err := restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Restored %d bans, want 2", n)
+	}
+	if !restored.IsBlacklisted(ctx, "198.51.100.7") || !restored.IsBlacklisted(ctx, "2001:db8:5:1::1") {
+		t.Error("Restored bans should be enforced")
+	}
+	if !restored.IsWhitelisted(ctx, "192.0.2.10") {
+		t.Error("Whitelist should be restored")
+	}
+	if entry, ok := restored.LookupWhitelist("192.0.2.11"); !ok || entry.Expires == nil {
+		t.Errorf("Temporary whitelist entry should be restored with its expiry: %+v", entry)
+	}
+	if entry, _ := restored.Match(ctx, "198.51.100.7"); entry.Source != SourceManual || entry.Reason != "abuse report" {
+		t.Errorf("Restored entry lost its metadata: %+v", entry)
+	}
+
+	if n, err := NewIPManager(nil, true, 100, time.Hour).LoadSnapshot(path + ".missing"); n != 0 || err != nil {
+		t.Errorf("Missing snapshot: got %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestGreylistPromotionAndDemotion(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	im.SetGreylistPolicy(2, time.Hour)
+
+	if promoted, _ := im.Strike(ctx, "203.0.113.5", "rate limit", time.Hour); promoted {
+		t.Fatal("First strike should only greylist")
+	}
+	if !im.IsGreylisted("203.0.113.5") || im.IsBlacklisted(ctx, "203.0.113.5") {
+		t.Fatal("IP should be greylisted but not blacklisted")
+	}
+
+	// A second strike within the strike interval counts once
+	if promoted, _ := im.Strike(ctx, "203.0.113.5", "rate limit", time.Hour); promoted {
+		t.Fatal("Strikes in quick succession should not promote")
+	}
+
+	im.mu.Lock()
+	im.greylist["203.0.113.5"].LastStrike = time.Now().Add(-2 * strikeInterval)
+	im.mu.Unlock()
+
+	if promoted, _ := im.Strike(ctx, "203.0.113.5", "rate limit", time.Hour); !promoted {
+		t.Fatal("Continued abuse should promote to the blacklist")
+	}
+	if im.IsGreylisted("203.0.113.5") || !im.IsBlacklisted(ctx, "203.0.113.5") {
+		t.Error("Promoted IP should move from greylist to blacklist")
+	}
+
+	im.Strike(ctx, "203.0.113.6", "filter", time.Hour)
+	im.mu.Lock()
+	im.greylist["203.0.113.6"].LastStrike = time.Now().Add(-2 * time.Hour)
+	im.mu.Unlock()
+
+	if demoted := im.DemoteQuietEntries(ctx); len(demoted) != 1 || demoted[0] != "203.0.113.6" {
+		t.Errorf("DemoteQuietEntries() = %v, want [203.0.113.6]", demoted)
+	}
+}
+
+func TestBlacklistMetadataAndFeedKillSwitch(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	switches := killswitch.NewRegistry(nil, time.Minute)
+	im.SetKillSwitches(switches)
+
+	im.BlacklistIP(ctx, "198.51.100.20", time.Hour, Origin{Source: SourceFeed, Feed: "spamhaus-drop", Reason: "listed"})
+	im.BlacklistIP(ctx, "198.51.100.21", time.Hour, Origin{Source: SourceRateLimit})
+
+	for i := 0; i < 3; i++ {
+		im.IsBlacklisted(ctx, "198.51.100.20")
+	}
+
+	entries := im.GetBlacklist()
+	if len(entries) != 2 {
+		t.Fatalf("GetBlacklist() returned %d entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Target == "198.51.100.20" && (entry.Hits != 3 || entry.Feed != "spamhaus-drop" || entry.Reason != "listed") {
+			t.Errorf("Feed entry metadata = %+v", entry)
+		}
+	}
+
+	switches.Engage(ctx, killswitch.KindFeed, "spamhaus-drop", "false positives")
+	if im.IsBlacklisted(ctx, "198.51.100.20") {
+		t.Error("Entries from a killed feed should not be enforced")
+	}
+	if !im.IsBlacklisted(ctx, "198.51.100.21") {
+		t.Error("Entries from other sources should still be enforced")
+	}
+}
+
+func TestListBlacklist(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	im.BlacklistIP(ctx, "10.0.0.1", time.Hour, Origin{Source: SourceManual})
+	im.BlacklistIP(ctx, "10.0.0.2", 2*time.Hour, Origin{Source: SourceRateLimit})
+	im.BlacklistIP(ctx, "10.1.0.0/16", 3*time.Hour, Origin{Source: SourceFeed, Feed: "drop"})
+	im.BlacklistIP(ctx, "192.0.2.1", time.Hour, Origin{Source: SourceManual})
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+		total    int
+	}{
+		{"Default order is newest first", "", []string{"192.0.2.1", "10.1.0.0/16", "10.0.0.2", "10.0.0.1"}, 4},
+		{"CIDR includes contained ranges", "cidr=10.0.0.0/8&sort=target&order=asc", []string{"10.0.0.1", "10.0.0.2", "10.1.0.0/16"}, 3},
+		{"Single IP matches its enclosing range", "cidr=10.1.2.3", []string{"10.1.0.0/16"}, 1},
+		{"Source filter", "source=manual&sort=target&order=asc", []string{"10.0.0.1", "192.0.2.1"}, 2},
+		{"Expiry filter", "expires_after=90m&sort=expires", []string{"10.1.0.0/16", "10.0.0.2"}, 2},
+		{"Pagination", "sort=target&order=asc&offset=1&limit=2", []string{"10.0.0.2", "10.1.0.0/16"}, 4},
+		{"Offset past the end", "offset=10", []string{}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			q, err := ParseListQuery(values, time.Now())
+			if err != nil {
+				t.Fatalf("ParseListQuery(%q) failed: %v", tt.query, err)
+			}
+
+			page := im.ListBlacklist(q)
+			targets := []string{}
+			for _, entry := range page.Entries {
+				targets = append(targets, entry.Target)
+			}
+			if strings.Join(targets, ",") != strings.Join(tt.expected, ",") || page.Total != tt.total {
+				t.Errorf("ListBlacklist(%q) = %v (total %d), want %v (total %d)", tt.query, targets, page.Total, tt.expected, tt.total)
+			}
+		})
+	}
+
+	for _, query := range []string{"sort=ip", "order=up", "limit=-1", "cidr=10.0.0.0/33"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseListQuery(values, time.Now()); err == nil {
+			t.Errorf("ParseListQuery(%q) should fail", query)
+		}
+	}
+}
+
+func TestTemporaryWhitelist(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+
+	im.WhitelistIP(ctx, "192.0.2.20", time.Hour)
+	im.WhitelistIP(ctx, "192.0.2.21", 0)
+	if err := im.BlacklistIP(ctx, "192.0.2.20", time.Hour, Origin{Source: SourceManual}); err == nil {
+		t.Error("Temporarily whitelisted IP should not be blacklisted")
+	}
+
+	// Expire the temporary entry
+	im.mu.Lock()
+	im.whitelistedIPs["192.0.2.20"] = time.Now().Add(-time.Second)
+	im.mu.Unlock()
+
+	if im.IsWhitelisted(ctx, "192.0.2.20") {
+		t.Error("Expired whitelist entry should not apply")
+	}
+	if page := im.ListWhitelist(ListQuery{}); page.Total != 1 || page.Entries[0].IP != "192.0.2.21" || page.Entries[0].Expires != nil {
+		t.Errorf("ListWhitelist() = %+v, want only the permanent entry", page)
+	}
+
+	im.CleanupExpiredEntries()
+	im.mu.RLock()
+	_, exists := im.whitelistedIPs["192.0.2.20"]
+	im.mu.RUnlock()
+	if exists {
+		t.Error("Cleanup should remove expired whitelist entries")
+	}
+}
+
+func TestApplyListEvents(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, false, 0, time.Hour)
+	remote := newEntry("192.0.2.1", time.Hour, Origin{Source: SourceBotnet})
+	remoteNet := newEntry("198.51.100.0/24", time.Hour, Origin{Source: SourceManual})
+
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistAdd, Target: "192.0.2.1", Entry: remote})
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistAdd, Target: "198.51.100.0/24", Entry: remoteNet})
+	if entry, ok := im.Match(ctx, "192.0.2.1"); !ok || entry.Source != SourceBotnet {
+		t.Fatalf("ban from another node not applied: %+v %v", entry, ok)
+	}
+	if !im.IsBlacklisted(ctx, "198.51.100.9") {
+		t.Fatal("range ban from another node not applied")
+	}
+
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistRemove, Target: "192.0.2.1"})
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistRemove, Target: "198.51.100.0/24"})
+	if im.IsBlacklisted(ctx, "192.0.2.1") || im.IsBlacklisted(ctx, "198.51.100.9") {
+		t.Error("removal on another node should clear the local cache")
+	}
+
+	im.applyEvent(ListEvent{Node: "other", Op: OpWhitelistAdd, Target: "203.0.113.5"})
+	if !im.IsWhitelisted(ctx, "203.0.113.5") {
+		t.Error("whitelist entry from another node not applied")
+	}
+
+	// A node ignores its own events, which it has already applied
+	im.applyEvent(ListEvent{Node: im.nodeID, Op: OpWhitelistRemove, Target: "203.0.113.5"})
+	if !im.IsWhitelisted(ctx, "203.0.113.5") {
+		t.Error("own event should be ignored")
+	}
+	im.applyEvent(ListEvent{Node: "other", Op: OpWhitelistRemove, Target: "203.0.113.5"})
+	if im.IsWhitelisted(ctx, "203.0.113.5") {
+		t.Error("whitelist removal from another node not applied")
+	}
+}
+
+func TestEscalationDuration(t *testing.T) {
+	esc := Escalation{Multiplier: 2, MaxDuration: 10 * time.Hour}
+	tests := []struct {
+		offense  int
+		expected time.Duration
+	}{
+		{0, time.Hour},
+		{1, time.Hour},
+		{2, 2 * time.Hour},
+		{3, 4 * time.Hour},
+		{4, 8 * time.Hour},
+		{5, 10 * time.Hour},
+		{200, 10 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := esc.Duration(time.Hour, tt.offense); got != tt.expected {
+			t.Errorf("Duration(1h, %d) = %v, want %v", tt.offense, got, tt.expected)
+		}
+	}
+}
+
+func TestAutoBlacklistEscalates(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	im.SetEscalation(Escalation{Multiplier: 3, MaxDuration: 24 * time.Hour, ResetAfter: time.Hour})
+
+	origin := Origin{Source: SourceRateLimit}
+	for i, want := range []time.Duration{time.Hour, 3 * time.Hour, 9 * time.Hour, 24 * time.Hour} {
+		if err := im.AutoBlacklistIP(ctx, "203.0.113.7", time.Hour, origin); err != nil {
+			t.Fatalf("AutoBlacklistIP: %v", err)
+		}
+		entry, ok := im.Lookup("203.0.113.7")
+		if !ok {
+			t.Fatal("expected entry")
+		}
+		if entry.Offense != i+1 {
+			t.Errorf("offense = %d, want %d", entry.Offense, i+1)
+		}
+		if got := entry.Expires.Sub(entry.Added); got != want {
+			t.Errorf("ban %d lasted %v, want %v", i+1, got, want)
+		}
+	}
+
+	// IPv6 streaks are kept per prefix
+	im.AutoBlacklistIP(ctx, "2001:db8::1", time.Hour, origin)
+	im.AutoBlacklistIP(ctx, "2001:db8::2", time.Hour, origin)
+	if got := im.Offenses(ctx, "2001:db8::/64"); got != 2 {
+		t.Errorf("offenses for /64 = %d, want 2", got)
+	}
+
+	// Manual bans neither escalate nor count
+	if err := im.AutoBlacklistIP(ctx, "198.51.100.1", time.Hour, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	if got := im.Offenses(ctx, "198.51.100.1"); got != 0 {
+		t.Errorf("manual ban counted as offense %d", got)
+	}
+}
+
+func TestBanFilter(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	if err := im.BlacklistIP(ctx, "203.0.113.7", time.Hour, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("BlacklistIP: %v", err)
+	}
+	if err := im.EnableFilter(ctx, 1000, 0.001); err != nil {
+		t.Fatalf("EnableFilter: %v", err)
+	}
+
+	// Bans from before and after the filter was built are both found
+	if err := im.BlacklistIP(ctx, "198.51.100.0/24", time.Hour, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("BlacklistIP: %v", err)
+	}
+	if err := im.AutoBlacklistIP(ctx, "2001:db8:1::5", time.Hour, Origin{Source: SourceRateLimit}); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	for _, ip := range []string{"203.0.113.7", "198.51.100.42", "2001:db8:1::9", "::ffff:198.51.100.1"} {
+		if im.filterMiss(ip) {
+			t.Errorf("filter misses banned %s", ip)
+		}
+		if !im.IsBlacklisted(ctx, ip) {
+			t.Errorf("IsBlacklisted(%s) = false", ip)
+		}
+	}
+
+	for _, ip := range []string{"203.0.113.8", "192.0.2.1", "2001:db8:2::1"} {
+		if !im.filterMiss(ip) {
+			t.Errorf("filter does not rule out %s", ip)
+		}
+	}
+
+	// A rebuild forgets lifted bans
+	if err := im.RemoveFromBlacklist(ctx, "203.0.113.7"); err != nil {
+		t.Fatalf("RemoveFromBlacklist: %v", err)
+	}
+	if err := im.RebuildFilter(ctx); err != nil {
+		t.Fatalf("RebuildFilter: %v", err)
+	}
+	if !im.filterMiss("203.0.113.7") {
+		t.Error("filter still holds lifted ban after rebuild")
+	}
+}
+
+// redisCounter counts the commands a Redis client sends, failing them all
+type redisCounter struct{ calls int }
+
+func (c *redisCounter) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	c.calls++
+	return ctx, redis.Nil
+}
+
+func (c *redisCounter) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (c *redisCounter) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	c.calls += len(cmds)
+	return ctx, redis.Nil
+}
+
+func (c *redisCounter) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestBanFilterSparesRedis(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 100, time.Hour)
+	if err := im.BlacklistIP(ctx, "203.0.113.7", time.Hour, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("BlacklistIP: %v", err)
+	}
+	if err := im.WhitelistIP(ctx, "192.0.2.9", 0); err != nil {
+		t.Fatalf("WhitelistIP: %v", err)
+	}
+	if err := im.EnableFilter(ctx, 1000, 0.001); err != nil {
+		t.Fatalf("EnableFilter: %v", err)
+	}
+	counter := &redisCounter{}
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	client.AddHook(counter)
+	defer client.Close()
+	im.client = client
+
+	if _, whitelisted, banned := im.Check(ctx, "192.0.2.1"); whitelisted || banned {
+		t.Errorf("clean IP: whitelisted %v, banned %v", whitelisted, banned)
+	}
+	if _, whitelisted, _ := im.Check(ctx, "192.0.2.9"); !whitelisted {
+		t.Error("locally whitelisted IP not whitelisted on a filter miss")
+	}
+	if counter.calls != 0 {
+		t.Errorf("filter misses made %d Redis calls", counter.calls)
+	}
+
+	if entry, _, banned := im.Check(ctx, "203.0.113.7"); !banned || entry.Target != "203.0.113.7" {
+		t.Errorf("banned IP: banned %v, entry %+v", banned, entry)
+	}
+	if counter.calls == 0 {
+		t.Error("filter hit did not check the whitelist in Redis")
+	}
+}
+
+func TestBanEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	im := NewIPManager(nil, false, 0, time.Hour)
+	events, unsubscribe := im.SubscribeBans(16)
+	defer unsubscribe()
+	go im.RunBanEvents(ctx)
+
+	next := func() BanEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("no ban event")
+			return BanEvent{}
+		}
+	}
+
+	if err := im.BlacklistIP(ctx, "192.0.2.1", 50*time.Millisecond, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("BlacklistIP: %v", err)
+	}
+	if ev := next(); ev.Kind != BanAdded || ev.Entry.Target != "192.0.2.1" || !ev.Local {
+		t.Fatalf("expected local add, got %+v", ev)
+	}
+	if ev := next(); ev.Kind != BanExpired || ev.Entry.Target != "192.0.2.1" || !ev.Local {
+		t.Fatalf("expected local expiry, got %+v", ev)
+	}
+	if im.IsBlacklisted(ctx, "192.0.2.1") {
+		t.Error("expired ban still enforced")
+	}
+
+	// A lifted ban is reported once and does not expire later
+	if err := im.BlacklistIP(ctx, "198.51.100.0/24", 50*time.Millisecond, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("BlacklistIP: %v", err)
+	}
+	next()
+	if err := im.RemoveFromBlacklist(ctx, "198.51.100.0/24"); err != nil {
+		t.Fatalf("RemoveFromBlacklist: %v", err)
+	}
+	if ev := next(); ev.Kind != BanRemoved || ev.Entry.Target != "198.51.100.0/24" {
+		t.Fatalf("expected removal, got %+v", ev)
+	}
+
+	// Bans from other nodes are reported, but not as local, and only once
+	remote := newEntry("203.0.113.9", time.Hour, Origin{Source: SourceBotnet})
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistAdd, Target: "203.0.113.9", Entry: remote})
+	im.applyEvent(ListEvent{Node: "other", Op: OpBlacklistAdd, Target: "203.0.113.9", Entry: remote})
+	if ev := next(); ev.Kind != BanAdded || ev.Local {
+		t.Fatalf("expected remote add, got %+v", ev)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubnetEscalation(t *testing.T) {
+	ctx := context.Background()
+	im := NewIPManager(nil, true, 0, time.Hour)
+	im.SetSubnetEscalation(SubnetEscalation{IPv4Prefix: 24, IPv6Prefix: 48, Threshold: 3, Window: time.Minute, Duration: 2 * time.Hour})
+	origin := Origin{Source: SourceRateLimit}
+
+	// Manual bans and repeat bans of one client do not count
+	if err := im.AutoBlacklistIP(ctx, "192.0.2.1", time.Hour, Origin{Source: SourceManual}); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	for _, ip := range []string{"192.0.2.2", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		if err := im.AutoBlacklistIP(ctx, ip, time.Hour, origin); err != nil {
+			t.Fatalf("AutoBlacklistIP: %v", err)
+		}
+	}
+	if im.IsBlacklisted(ctx, "192.0.2.200") {
+		t.Fatal("subnet banned at the threshold")
+	}
+
+	if err := im.AutoBlacklistIP(ctx, "192.0.2.5", time.Hour, origin); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	entry, ok := im.Match(ctx, "192.0.2.200")
+	if !ok || entry.Target != "192.0.2.0/24" || entry.Source != SourceSubnet {
+		t.Fatalf("subnet not banned: %+v %v", entry, ok)
+	}
+	if d := time.Until(entry.Expires); d < time.Hour+59*time.Minute {
+		t.Errorf("subnet ban lasts %s, want 2h", d)
+	}
+	if im.IsBlacklisted(ctx, "192.0.3.1") {
+		t.Error("neighbouring subnet banned")
+	}
+
+	// IPv6 clients count by their /64
+	for _, ip := range []string{"2001:db8:0:1::1", "2001:db8:0:1::2", "2001:db8:0:2::1", "2001:db8:0:3::1"} {
+		if err := im.AutoBlacklistIP(ctx, ip, time.Hour, origin); err != nil {
+			t.Fatalf("AutoBlacklistIP: %v", err)
+		}
+	}
+	if im.IsBlacklisted(ctx, "2001:db8:0:9::1") {
+		t.Fatal("IPv6 subnet banned after three /64s")
+	}
+	if err := im.AutoBlacklistIP(ctx, "2001:db8:0:4::1", time.Hour, origin); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	if entry, ok := im.Match(ctx, "2001:db8:0:9::1"); !ok || entry.Target != "2001:db8::/48" {
+		t.Errorf("IPv6 subnet not banned: %+v %v", entry, ok)
+	}
+}
+
+func TestTenantIPManagerIsolation(t *testing.T) {
+	ctx := context.Background()
+	shared := NewIPManager(nil, true, 100, time.Hour)
+	acme := NewTenantIPManager(nil, "acme", true, 100, time.Hour)
+
+	if err := acme.AutoBlacklistIP(ctx, "192.0.2.1", time.Hour, Origin{Source: SourceRateLimit}); err != nil {
+		t.Fatalf("AutoBlacklistIP: %v", err)
+	}
+	if err := acme.WhitelistIP(ctx, "192.0.2.2", time.Hour); err != nil {
+		t.Fatalf("WhitelistIP: %v", err)
+	}
+
+	if !acme.IsBlacklisted(ctx, "192.0.2.1") || !acme.IsWhitelisted(ctx, "192.0.2.2") {
+		t.Fatal("tenant lists lost their entries")
+	}
+	if shared.IsBlacklisted(ctx, "192.0.2.1") || shared.IsWhitelisted(ctx, "192.0.2.2") {
+		t.Error("tenant entries leaked into the shared lists")
+	}
+
+	if got := acme.key(redisNetsKey); got != "tenant:acme:blacklist:nets" {
+		t.Errorf("tenant key = %q", got)
+	}
+	if got := shared.key(redisNetsKey); got != redisNetsKey {
+		t.Errorf("shared key = %q, want %q", got, redisNetsKey)
+	}
+	if acme.Tenant() != "acme" || shared.Tenant() != "" {
+		t.Errorf("Tenant() = %q and %q", acme.Tenant(), shared.Tenant())
+	}
+}
+
+func TestCapacityEvictsByPolicy(t *testing.T) {
+	ctx := context.Background()
+	origin := Origin{Source: SourceRateLimit}
+
+	im := NewIPManager(nil, true, 100, time.Hour)
+	im.SetCapacity(3, EvictSoonestExpiry)
+	im.BlacklistIP(ctx, "192.0.2.1", time.Hour, Origin{Source: SourceManual})
+	im.BlacklistIP(ctx, "192.0.2.2", time.Minute, origin)
+	im.BlacklistIP(ctx, "192.0.2.3", 2*time.Hour, origin)
+	im.BlacklistIP(ctx, "198.51.100.0/24", 3*time.Hour, origin)
+
+	if im.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", im.Len())
+	}
+	if im.IsBlacklisted(ctx, "192.0.2.2") {
+		t.Error("ban soonest to expire was kept")
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.3", "198.51.100.7"} {
+		if !im.IsBlacklisted(ctx, ip) {
+			t.Errorf("%s was evicted", ip)
+		}
+	}
+
+	im = NewIPManager(nil, true, 100, time.Hour)
+	im.SetCapacity(2, EvictLRU)
+	im.BlacklistIP(ctx, "192.0.2.1", time.Minute, origin)
+	im.BlacklistIP(ctx, "192.0.2.2", time.Hour, origin)
+	im.IsBlacklisted(ctx, "192.0.2.1")
+	im.BlacklistIP(ctx, "192.0.2.3", time.Hour, origin)
+
+	if im.IsBlacklisted(ctx, "192.0.2.2") || !im.IsBlacklisted(ctx, "192.0.2.1") {
+		t.Error("expected the ban unhit the longest to be evicted")
+	}
+}
@@ -0,0 +1,156 @@
+package blacklist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot format changes. Version 1
+// stored only expiry times; version 2 stores full entries.
+const snapshotVersion = 2
+
+// Snapshot is the on-disk form of the blacklist and whitelist, used to keep
+// ban state across restarts when there is no Redis. WhitelistExpiry holds the
+// expiry of temporary whitelist entries.
+type Snapshot struct {
+	Version         int                  `json:"version"`
+	SavedAt         time.Time            `json:"saved_at"`
+	Entries         []Entry              `json:"entries,omitempty"`
+	Blacklist       map[string]time.Time `json:"blacklist,omitempty"`
+	Whitelist       []string             `json:"whitelist"`
+	WhitelistExpiry map[string]time.Time `json:"whitelist_expiry,omitempty"`
+}
+
+// Snapshot captures the current, unexpired blacklist and whitelist
+func (im *IPManager) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:   snapshotVersion,
+		SavedAt:   time.Now(),
+		Entries:   im.GetBlacklist(),
+		Whitelist: []string{},
+	}
+	for _, entry := range im.GetWhitelist() {
+		s.Whitelist = append(s.Whitelist, entry.IP)
+		if entry.Expires != nil {
+			if s.WhitelistExpiry == nil {
+				s.WhitelistExpiry = make(map[string]time.Time)
+			}
+			s.WhitelistExpiry[entry.IP] = *entry.Expires
+		}
+	}
+	return s
+}
+
+// Restore merges a snapshot into the local lists, skipping expired bans. It
+// returns the number of bans restored.
+func (im *IPManager) Restore(s *Snapshot) (int, error) {
+	entries := s.Entries
+	switch s.Version {
+	case snapshotVersion:
+	case 1:
+		for target, expiry := range s.Blacklist {
+			entries = append(entries, Entry{Target: target, Source: SourceUnknown, Expires: expiry})
+		}
+	default:
+		return 0, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	now := time.Now()
+	for _, ip := range s.Whitelist {
+		expires := s.WhitelistExpiry[ip]
+		if expires.IsZero() || now.Before(expires) {
+			im.whitelistedIPs[canonicalIP(ip)] = expires
+		}
+	}
+
+	restored := 0
+	for i := range entries {
+		entry := entries[i]
+		if !entry.Expires.After(now) {
+			continue
+		}
+
+		if strings.Contains(entry.Target, "/") {
+			prefix, err := netip.ParsePrefix(entry.Target)
+			if err != nil {
+				continue
+			}
+			entry.Target = prefix.Masked().String()
+			old := im.blacklistedNets[prefix.Masked()]
+			im.blacklistedNets[prefix.Masked()] = &entry
+			im.banAdded(&entry, old, false)
+		} else {
+			ip := canonicalIP(entry.Target)
+			if im.whitelistedLocked(ip, now) {
+				continue
+			}
+			entry.Target = ip
+			old := im.blacklistedIPs[ip]
+			im.blacklistedIPs[ip] = &entry
+			im.banAdded(&entry, old, false)
+		}
+		restored++
+	}
+
+	return restored, nil
+}
+
+// SaveSnapshot writes a snapshot to path atomically, so a crash mid-write
+// leaves the previous snapshot intact
+func (im *IPManager) SaveSnapshot(path string) error {
+	data, err := json.Marshal(im.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the lists from a snapshot file. A missing file is not
+// an error. It returns the number of bans restored.
+func (im *IPManager) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	return im.Restore(&s)
+}
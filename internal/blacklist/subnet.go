@@ -0,0 +1,143 @@
+package blacklist
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisSubnetPrefix prefixes the sorted sets of auto-banned targets in a
+// subnet, scored by when they were banned
+const redisSubnetPrefix = "blacklist:subnet:"
+
+// SubnetEscalation bans a whole subnet once more than Threshold distinct IPs
+// in it were auto-banned within Window. IPv6 clients are counted by their
+// auto-ban prefix, so Threshold counts distinct /64s in a /48 by default.
+type SubnetEscalation struct {
+	IPv4Prefix int
+	IPv6Prefix int
+	Threshold  int
+	Window     time.Duration
+	Duration   time.Duration
+}
+
+// SetSubnetEscalation enables subnet bans after clustered auto-bans
+func (im *IPManager) SetSubnetEscalation(escalation SubnetEscalation) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.subnetEscalation = &escalation
+}
+
+// escalateSubnet records an automatic ban of target, an IP or IPv6 auto-ban
+// prefix, and bans its subnet once enough of its neighbours were banned.
+// It returns the subnet banned, if any.
+func (im *IPManager) escalateSubnet(ctx context.Context, target string, origin Origin) (netip.Prefix, error) {
+	im.mu.RLock()
+	escalation := im.subnetEscalation
+	im.mu.RUnlock()
+
+	switch origin.Source {
+	case SourceManual, SourceFeed, SourceSubnet:
+		return netip.Prefix{}, nil
+	}
+	if escalation == nil {
+		return netip.Prefix{}, nil
+	}
+
+	subnet, ok := escalation.subnet(target)
+	if !ok {
+		return netip.Prefix{}, nil
+	}
+
+	now := time.Now()
+	banned := im.countSubnetBan(ctx, subnet, target, now, escalation.Window)
+	if banned <= escalation.Threshold || im.netBanned(subnet, now) {
+		return netip.Prefix{}, nil
+	}
+
+	reason := fmt.Sprintf("%d addresses auto-banned within %s", banned, escalation.Window)
+	err := im.blacklistNet(ctx, subnet.String(), escalation.Duration, Origin{Source: SourceSubnet, Reason: reason})
+	return subnet, err
+}
+
+// subnet returns the subnet a ban of target counts towards
+func (e SubnetEscalation) subnet(target string) (netip.Prefix, bool) {
+	var addr netip.Addr
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		addr = prefix.Addr()
+	} else if addr, err = netip.ParseAddr(target); err != nil {
+		return netip.Prefix{}, false
+	}
+
+	bits := e.IPv4Prefix
+	if !addr.Is4() {
+		bits = e.IPv6Prefix
+	}
+	subnet, err := addr.Prefix(bits)
+	return subnet, err == nil
+}
+
+// countSubnetBan adds target to the recent bans in subnet and returns how
+// many distinct targets were banned there within window. Redis keeps the
+// count across instances; without it the count is local.
+func (im *IPManager) countSubnetBan(ctx context.Context, subnet netip.Prefix, target string, now time.Time, window time.Duration) int {
+	if im.client != nil {
+		key := im.key(redisSubnetPrefix + subnet.String())
+		pipe := im.client.TxPipeline()
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: target})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		count := pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, window)
+		if _, err := pipe.Exec(ctx); err == nil {
+			return int(count.Val())
+		}
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	bans, exists := im.subnetBans[subnet]
+	if !exists {
+		bans = make(map[string]time.Time)
+		im.subnetBans[subnet] = bans
+	}
+	bans[target] = now
+	for t, banned := range bans {
+		if now.Sub(banned) > window {
+			delete(bans, t)
+		}
+	}
+	return len(bans)
+}
+
+// netBanned reports whether subnet is already banned
+func (im *IPManager) netBanned(subnet netip.Prefix, now time.Time) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	entry, exists := im.blacklistedNets[subnet]
+	return exists && now.Before(entry.Expires)
+}
+
+// cleanupSubnetBans drops bans that fell out of the escalation window;
+// im.mu must be held
+func (im *IPManager) cleanupSubnetBans(now time.Time) {
+	if im.subnetEscalation == nil {
+		return
+	}
+	for subnet, bans := range im.subnetBans {
+		for target, banned := range bans {
+			if now.Sub(banned) > im.subnetEscalation.Window {
+				delete(bans, target)
+			}
+		}
+		if len(bans) == 0 {
+			delete(im.subnetBans, subnet)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package blacklist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/netip"
+	"time"
+)
+
+// redisEventsChannel carries list changes between nodes
+const redisEventsChannel = "blacklist:events"
+
+// List change operations broadcast between nodes
+const (
+	OpBlacklistAdd    = "blacklist.add"
+	OpBlacklistRemove = "blacklist.remove"
+	OpWhitelistAdd    = "whitelist.add"
+	OpWhitelistRemove = "whitelist.remove"
+)
+
+// ListEvent is a blacklist or whitelist change made on one node. Entry is
+// set for blacklist additions; Expires for whitelist additions, zero for
+// permanent entries.
+type ListEvent struct {
+	Node    string    `json:"node"`
+	Op      string    `json:"op"`
+	Target  string    `json:"target"`
+	Entry   *Entry    `json:"entry,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// newNodeID returns a random ID telling this node's events apart from others'
+func newNodeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// OnChange registers fn to be called with the IP or CIDR range of every
+// blacklist and whitelist change, whether made here or on another node. fn
+// is called with the manager locked and must not call back into it.
+func (im *IPManager) OnChange(fn func(target string)) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.onChange = append(im.onChange, fn)
+}
+
+// changed reports a list change to the change handlers; im.mu must be held
+func (im *IPManager) changed(target string) {
+	im.updateSize()
+	for _, fn := range im.onChange {
+		fn(target)
+	}
+}
+
+// OnEvent registers fn to be called with every blacklist and whitelist
+// change made on this node, once it is stored. Changes applied from other
+// nodes are not reported, so across a cluster each change is seen once. fn
+// is called with the manager locked and must not block.
+func (im *IPManager) OnEvent(fn func(ctx context.Context, event ListEvent)) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.onEvent = append(im.onEvent, fn)
+}
+
+// publish reports a change made on this node to the event handlers and
+// broadcasts it so other nodes update their caches without waiting for a
+// cache miss or the periodic refresh. Delivery is best effort; im.mu must be
+// held.
+func (im *IPManager) publish(ctx context.Context, event ListEvent) {
+	event.Node = im.nodeID
+	for _, fn := range im.onEvent {
+		fn(ctx, event)
+	}
+	if im.client == nil {
+		return
+	}
+
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return
+	}
+	im.client.Publish(ctx, im.key(redisEventsChannel), data)
+}
+
+// RunSync applies list changes broadcast by other nodes until ctx is
+// cancelled
+func (im *IPManager) RunSync(ctx context.Context) {
+	if im.client == nil {
+		return
+	}
+
+	pubsub := im.client.Subscribe(ctx, im.key(redisEventsChannel))
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event ListEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			im.applyEvent(event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyEvent updates the local caches from another node's change. Local hit
+// counts are kept, since hits are counted per node.
+func (im *IPManager) applyEvent(event ListEvent) {
+	if event.Node == im.nodeID {
+		return
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	defer im.changed(event.Target)
+
+	switch event.Op {
+	case OpBlacklistAdd:
+		if event.Entry == nil {
+			return
+		}
+		entry := *event.Entry
+		entry.Hits = 0
+		if prefix, err := netip.ParsePrefix(event.Target); err == nil {
+			old, exists := im.blacklistedNets[prefix]
+			if exists {
+				entry.Hits = old.snapshot().Hits
+			}
+			im.blacklistedNets[prefix] = &entry
+			im.banAdded(&entry, old, false)
+			return
+		}
+		ip := canonicalIP(event.Target)
+		old, exists := im.blacklistedIPs[ip]
+		if exists {
+			entry.Hits = old.snapshot().Hits
+		}
+		im.blacklistedIPs[ip] = &entry
+		im.banAdded(&entry, old, false)
+	case OpBlacklistRemove:
+		if prefix, err := netip.ParsePrefix(event.Target); err == nil {
+			im.banRemoved(im.blacklistedNets[prefix], false)
+			delete(im.blacklistedNets, prefix)
+			return
+		}
+		ip := canonicalIP(event.Target)
+		im.banRemoved(im.blacklistedIPs[ip], false)
+		delete(im.blacklistedIPs, ip)
+	case OpWhitelistAdd:
+		im.whitelistedIPs[canonicalIP(event.Target)] = event.Expires
+	case OpWhitelistRemove:
+		delete(im.whitelistedIPs, canonicalIP(event.Target))
+	}
+}
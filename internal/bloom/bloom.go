@@ -0,0 +1,95 @@
+// Package bloom implements a Bloom filter safe for concurrent use without
+// locks. Tests may return false positives but never false negatives, so a
+// miss proves a key was never added.
+package bloom
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Filter is a Bloom filter over string keys
+type Filter struct {
+	words  []uint64
+	bits   uint64
+	hashes int
+	count  int64
+}
+
+// New sizes a filter for n keys at false positive rate p
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.001
+	}
+
+	bits := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) / 64 * 64
+	hashes := int(math.Round(float64(bits) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &Filter{
+		words:  make([]uint64, bits/64),
+		bits:   bits,
+		hashes: hashes,
+	}
+}
+
+// Add inserts key
+func (f *Filter) Add(key string) {
+	h1, h2 := hash(key)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.bits
+		word, mask := &f.words[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	atomic.AddInt64(&f.count, 1)
+}
+
+// Test reports whether key may have been added. False means it never was.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hash(key)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.bits
+		if atomic.LoadUint64(&f.words[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of keys added, counting repeats
+func (f *Filter) Count() int {
+	return int(atomic.LoadInt64(&f.count))
+}
+
+// Size returns the filter size in bits
+func (f *Filter) Size() int {
+	return int(f.bits)
+}
+
+// hash derives the two hashes combined into each probe (Kirsch-Mitzenmacher)
+func hash(key string) (uint64, uint64) {
+	// FNV-1a, inline so probing does not allocate
+	h1 := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h1 ^= uint64(key[i])
+		h1 *= 1099511628211
+	}
+
+	// The second hash is h1 through the splitmix64 finalizer
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ h2>>30) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ h2>>27) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2 | 1
+}
@@ -0,0 +1,38 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilterHasNoFalseNegatives(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add(fmt.Sprintf("10.%d.%d.1", i/256, i%256))
+	}
+	for i := 0; i < 10000; i++ {
+		if key := fmt.Sprintf("10.%d.%d.1", i/256, i%256); !f.Test(key) {
+			t.Fatalf("Test(%q) = false after Add", key)
+		}
+	}
+	if f.Count() != 10000 {
+		t.Errorf("Count() = %d, want 10000", f.Count())
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add(fmt.Sprintf("192.0.%d.%d", i/256, i%256))
+	}
+
+	positives := 0
+	for i := 0; i < 100000; i++ {
+		if f.Test(fmt.Sprintf("198.%d.%d.%d", i/65536, i/256%256, i%256)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 100000; rate > 0.02 {
+		t.Errorf("false positive rate = %.4f, want about 0.01", rate)
+	}
+}
@@ -2,66 +2,153 @@ package botnet
 
 import (
 	"context"
-	"fmt"
+	"net/netip"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"ddos-protection/internal/badbot"
+	"ddos-protection/internal/intern"
+	"ddos-protection/internal/killswitch"
 )
 
-// BotnetDetector detects botnet attacks using advanced techniques
+// BotnetDetector detects botnet attacks using advanced techniques. Client
+// and network state is split across lock-striped shards, and settings are
+// swapped in whole, so concurrent requests from different clients do not
+// wait on each other.
 type BotnetDetector struct {
-	// Behavioral analysis
-	requestPatterns    map[string]*IPBehavior
-	globalPatterns     *GlobalPatterns
-	mu                 sync.RWMutex
-	
-	// Network analysis
-	networkRanges      map[string]*NetworkStats
-	geographicData     map[string]*GeoData
+	// Global patterns, updated atomically; 64-bit fields first so they are
+	// aligned on 32-bit platforms
+	totalRequests      int64
+	clients            int64 // client behaviors tracked
+	networks           int64 // IPv6 network behaviors tracked
+	cookies            int64 // tracking cookie behaviors tracked
+	identities         int64 // authenticated identity behaviors tracked
+	ranges             int64 // network ranges tracked
+	countryCount       int64 // countries seen
+	fingerprintCount   int64 // TLS and header fingerprints tracked
+	countries          sync.Map // country -> *int64 requests
+	fingerprints       sync.Map // TLS or header fingerprint -> *fingerprintUse
+	
+	// Behavioral and network analysis, by client, network and range
+	shards             [shardCount]*shard
 	
 	// Timing analysis
-	requestIntervals   map[string][]time.Duration
-	burstPatterns      map[string]*BurstPattern
+	bursts             *[burstSlots]burstSlot
 	
-	// Configuration
-	detectionThreshold float64
+	// Campaigns found by the latest clustering
+	campaigns          atomic.Value // []Campaign
+	
+	// Configuration; mu serializes changes to settings
 	analysisWindow     time.Duration
+	settings           atomic.Value // *settings
+	mu                 sync.Mutex
+	started            time.Time
+}
+
+// settings are the detector's configuration, replaced as a whole on change
+type settings struct {
+	detectionThreshold float64
+	killSwitches       *killswitch.Registry
+	ipv6Prefix         int
+	countryLookup      func(ip string) string
+	stateTTL           time.Duration
+	scoring            Scoring
+	cluster            *Cluster
+	signatures         *badbot.Database
+	decayHalfLife      time.Duration
+
+	// Cardinality bounds
+	limits             Limits
+	userAgents         *intern.Table
+	paths              *intern.Table
+	tlsFingerprints    *intern.Table
+	headerFingerprints *intern.Table
+}
+
+// Limits bounds the distinct user agents and paths the detector keeps.
+// Values beyond a limit are counted as intern.Other.
+type Limits struct {
+	UserAgents   int // distinct user agents across all clients
+	Paths        int // distinct paths across all clients
+	PerIP        int // distinct user agents and paths per client
+	MaxLength    int // longest value kept, in bytes
+	Fingerprints int // distinct TLS fingerprints, and header fingerprints, across all clients
+	Clients      int // clients, and IPv6 networks, whose behavior is tracked; approximate, as each shard holds its share
+	Networks     int // network ranges tracked, approximate like Clients
+}
+
+// DefaultLimits are used until SetLimits is called
+var DefaultLimits = Limits{
+	UserAgents:   10000,
+	Paths:        10000,
+	PerIP:        50,
+	MaxLength:    512,
+	Fingerprints: 10000,
+	Clients:      100000,
+	Networks:     50000,
 }
 
 // IPBehavior tracks individual IP behavior patterns
 type IPBehavior struct {
-	IP                string
-	RequestCount      int64
-	FirstSeen         time.Time
-	LastSeen          time.Time
-	UserAgents        map[string]int
-	RequestPaths      map[string]int
-	ResponseTimes     []time.Duration
-	RequestIntervals  []time.Duration
-	SuspiciousScore   float64
+	IP                string               `json:"ip"`
+	RequestCount      int64                `json:"request_count"`
+	FirstSeen         time.Time            `json:"first_seen"`
+	LastSeen          time.Time            `json:"last_seen"`
+	UserAgents        map[string]int       `json:"user_agents"`
+	RequestPaths      map[string]int       `json:"request_paths"`
+	ResponseTimes     []time.Duration      `json:"response_times_ns"`
+	RequestIntervals  []time.Duration      `json:"request_intervals_ns"`
+	SuspiciousScore   float64              `json:"suspicious_score"`
+	LastBurst         time.Time            `json:"last_burst"`
+	LastSlowRequests  time.Time            `json:"last_slow_requests"`
+	
+	// Signs of automation reported by the challenge page
+	AutomationFlags   []string             `json:"automation_flags,omitempty"`
+	LastTelemetry     time.Time            `json:"last_telemetry"`
+	
+	// Requests sent without the tracking cookie issued to the client
+	CookielessRequests int64               `json:"cookieless_requests"`
+	
+	// Requests, and requests without the tracking cookie, decayed
+	// exponentially so old behavior ages out; indicators are judged on
+	// these rather than on the cumulative counts
+	RecentRequests    float64              `json:"recent_requests"`
+	RecentCookieless  float64              `json:"recent_cookieless"`
+	decayedAt         time.Time
+	
+	// TLS and header fingerprints presented, each counted once in its use
+	fingerprints      map[string]*fingerprintUse
 	
 	// Behavioral indicators
-	HasJavascript     bool
-	HasCSS            bool
-	HasImages         bool
-	HasFavicon        bool
-	HasRobotsTxt      bool
-	HasSitemap        bool
-}
+	HasJavascript     bool                 `json:"has_javascript"`
+	HasCSS            bool                 `json:"has_css"`
+	HasImages         bool                 `json:"has_images"`
+	HasFavicon        bool                 `json:"has_favicon"`
+	HasRobotsTxt      bool                 `json:"has_robots_txt"`
+	HasSitemap        bool                 `json:"has_sitemap"`
 
-// GlobalPatterns tracks patterns across all requests
-type GlobalPatterns struct {
-	TotalRequests     int64
-	UniqueIPs         int
-	CommonUserAgents  map[string]int
-	CommonPaths       map[string]int
-	GeographicSpread  map[string]int
-	NetworkSpread     map[string]int
+	// Addresses seen, when tracking an IPv6 network
+	Addresses         map[string]int       `json:"addresses,omitempty"`
+	
+	// Requests not yet added to the fleet's count, when state is shared
+	pending           int64
+	
+	// Randomization of the latest URLs
+	urls              *urlStats
+	
+	// How plausibly the client's pages follow one another, from 0 to 1
+	NavigationScore   float64              `json:"navigation_score"`
+	navigation        *navStats
+	
+	// Distinct paths not found and client errors in the analysis window
+	NotFoundPaths     int                  `json:"not_found_paths"`
+	ClientErrors      int                  `json:"client_errors"`
+	scan              *scanStats
 	
-	// Anomaly detection
-	NormalRequestRate float64
-	NormalResponseTime time.Duration
-	NormalGeographicDistribution map[string]float64
+	// The latest analysis of the client's requests, for Explain
+	lastAnalysis      *BotnetAnalysis
 }
 
 // NetworkStats tracks behavior by network ranges
@@ -72,65 +159,114 @@ type NetworkStats struct {
 	AvgResponseTime time.Duration
 	SuspiciousScore float64
 	FirstSeen     time.Time
-}
-
-// GeoData tracks geographic information
-type GeoData struct {
-	Country     string
-	Region      string
-	City        string
-	ISP         string
-	ASN         string
-	IsVPN       bool
-	IsProxy     bool
-	IsTor       bool
-}
-
-// BurstPattern detects coordinated attack patterns
-type BurstPattern struct {
-	StartTime    time.Time
-	EndTime      time.Time
-	IPCount      int
-	RequestCount int64
-	Intensity    float64
-	Coordination float64
+	LastSeen      time.Time
 }
 
 // NewBotnetDetector creates a new botnet detector
 func NewBotnetDetector(threshold float64, window time.Duration) *BotnetDetector {
-	return &BotnetDetector{
-		requestPatterns:    make(map[string]*IPBehavior),
-		globalPatterns:     &GlobalPatterns{
-			CommonUserAgents: make(map[string]int),
-			CommonPaths:      make(map[string]int),
-			GeographicSpread: make(map[string]int),
-			NetworkSpread:    make(map[string]int),
-			NormalGeographicDistribution: make(map[string]float64),
-		},
-		networkRanges:      make(map[string]*NetworkStats),
-		geographicData:     make(map[string]*GeoData),
-		requestIntervals:   make(map[string][]time.Duration),
-		burstPatterns:      make(map[string]*BurstPattern),
-		detectionThreshold: threshold,
-		analysisWindow:     window,
+	bd := &BotnetDetector{
+		bursts:         new([burstSlots]burstSlot),
+		analysisWindow: window,
+		started:        time.Now(),
 	}
+	for i := range bd.shards {
+		bd.shards[i] = newShard()
+	}
+	bd.settings.Store(&settings{
+		detectionThreshold: threshold,
+		stateTTL:           DefaultStateTTL * window,
+		decayHalfLife:      window / defaultDecayFraction,
+		scoring:            DefaultScoring(),
+		limits:             DefaultLimits,
+		userAgents:         intern.NewTable("botnet_user_agents", DefaultLimits.UserAgents, DefaultLimits.MaxLength),
+		paths:              intern.NewTable("botnet_paths", DefaultLimits.Paths, DefaultLimits.MaxLength),
+		tlsFingerprints:    intern.NewTable("botnet_tls_fingerprints", DefaultLimits.Fingerprints, DefaultLimits.MaxLength),
+		headerFingerprints: intern.NewTable("botnet_header_fingerprints", DefaultLimits.Fingerprints, DefaultLimits.MaxLength),
+	})
+	return bd
 }
 
-// AnalyzeRequest analyzes a request for botnet indicators
-func (bd *BotnetDetector) AnalyzeRequest(ctx context.Context, ip, userAgent, path string, responseTime time.Duration) *BotnetAnalysis {
+// config returns the settings in force
+func (bd *BotnetDetector) config() *settings {
+	return bd.settings.Load().(*settings)
+}
+
+// configure applies a change to a copy of the settings and puts it in force
+func (bd *BotnetDetector) configure(change func(cfg *settings)) {
 	bd.mu.Lock()
 	defer bd.mu.Unlock()
-	
-	// Get or create IP behavior
-	behavior := bd.getOrCreateIPBehavior(ip)
-	bd.updateIPBehavior(behavior, userAgent, path, responseTime)
-	
-	// Update global patterns
-	bd.updateGlobalPatterns(ip, userAgent, path)
+
+	cfg := *bd.config()
+	change(&cfg)
+	bd.settings.Store(&cfg)
+}
+
+// SetThreshold sets the confidence at which a client is reported as a bot
+func (bd *BotnetDetector) SetThreshold(threshold float64) {
+	bd.configure(func(cfg *settings) {
+		cfg.detectionThreshold = threshold
+	})
+}
+
+// SetKillSwitches attaches the kill switch registry used to disable indicators
+func (bd *BotnetDetector) SetKillSwitches(registry *killswitch.Registry) {
+	bd.configure(func(cfg *settings) {
+		cfg.killSwitches = registry
+	})
+}
+
+// SetSignatures sets the known bad bot signatures requests are matched
+// against; nil matches none
+func (bd *BotnetDetector) SetSignatures(db *badbot.Database) {
+	bd.configure(func(cfg *settings) {
+		cfg.signatures = db
+	})
+}
+
+// SetLimits replaces the cardinality limits. Values already tracked are kept.
+func (bd *BotnetDetector) SetLimits(limits Limits) {
+	bd.configure(func(cfg *settings) {
+		cfg.limits = limits
+		cfg.userAgents = intern.NewTable("botnet_user_agents", limits.UserAgents, limits.MaxLength)
+		cfg.paths = intern.NewTable("botnet_paths", limits.Paths, limits.MaxLength)
+		cfg.tlsFingerprints = intern.NewTable("botnet_tls_fingerprints", limits.Fingerprints, limits.MaxLength)
+		cfg.headerFingerprints = intern.NewTable("botnet_header_fingerprints", limits.Fingerprints, limits.MaxLength)
+	})
+}
+
+// SetIPv6Prefix also tracks IPv6 clients by the network of the given prefix
+// length and judges their behavior there, so rotating privacy addresses
+// does not reset it. Per-address behavior is still kept. Zero disables.
+func (bd *BotnetDetector) SetIPv6Prefix(bits int) {
+	bd.configure(func(cfg *settings) {
+		cfg.ipv6Prefix = bits
+	})
+}
+
+// SetCountryLookup sets how client countries are found for the geographic
+// spread analysis, normally a GeoIP database. Without one, geography is not
+// tracked.
+func (bd *BotnetDetector) SetCountryLookup(lookup func(ip string) string) {
+	bd.configure(func(cfg *settings) {
+		cfg.countryLookup = lookup
+	})
+}
+
+// Name returns BuiltinDetector
+func (bd *BotnetDetector) Name() string {
+	return BuiltinDetector
+}
+
+// AnalyzeRequest analyzes a request for botnet indicators
+func (bd *BotnetDetector) AnalyzeRequest(ctx context.Context, req Request) *BotnetAnalysis {
+	cfg := bd.config()
+	ip, userAgent, path, tlsFingerprint, responseTime := req.IP, req.UserAgent, req.Path, req.TLSFingerprint, req.ResponseTime
 	
 	// Analyze for botnet indicators
+	network := bd.aggregateNetwork(ip)
 	analysis := &BotnetAnalysis{
 		IP:           ip,
+		Network:      network,
 		Timestamp:    time.Now(),
 		IsBotnet:     false,
 		Confidence:   0.0,
@@ -138,8 +274,76 @@ func (bd *BotnetDetector) AnalyzeRequest(ctx context.Context, ip, userAgent, pat
 		RiskScore:    0,
 	}
 	
-	// 1. Behavioral Analysis
-	bd.analyzeBehavior(behavior, analysis)
+	// Share one copy of each user agent and path across all clients. The
+	// behavioral indicators see the raw path, as it may be bucketed here.
+	internedAgent := cfg.userAgents.Intern(userAgent)
+	internedPath := cfg.paths.Intern(path)
+	
+	// Update the client's behavior, holding only its shard
+	requests := req.requests()
+	clientShard := bd.shardFor(ip)
+	clientShard.mu.Lock()
+	behavior := bd.getOrCreateIPBehavior(clientShard, ip)
+	bd.updateBehavioralIndicators(behavior, path)
+	bd.observeURL(behavior, path, req.Query)
+	bd.observeNavigation(behavior, req)
+	bd.updateIPBehavior(behavior, internedAgent, internedPath, responseTime, requests)
+	bd.share(clientShard, behavior, requests)
+	if time.Since(behavior.FirstSeen) < bd.analysisWindow {
+		analysis.Signals = append(analysis.Signals, "new_client")
+	}
+	
+	if req.Cookieless {
+		behavior.CookielessRequests += requests
+		behavior.RecentCookieless += float64(requests)
+	}
+	bd.analyzeCookieless(behavior, analysis)
+	
+	// 1. Behavioral Analysis; clients returning a tracking cookie are
+	// judged by the behavior of their cookie below, and other IPv6 clients
+	// by the behavior of their whole network. Marks set on the address
+	// still count.
+	switch {
+	case req.ClientID != "":
+		bd.analyzeMarks(behavior, analysis)
+	case network == "":
+		bd.analyzeBehavior(behavior, analysis)
+	}
+	sharedFingerprint, sharedHeaders := false, false
+	if tlsFingerprint != "" {
+		sharedFingerprint = bd.trackFingerprint(behavior, cfg.tlsFingerprints.Intern(tlsFingerprint), "shared_tls_fingerprint")
+	}
+	if req.HeaderFingerprint != "" {
+		sharedHeaders = bd.trackFingerprint(behavior, headerPrefix+cfg.headerFingerprints.Intern(req.HeaderFingerprint), "shared_header_fingerprint")
+	}
+	clientShard.refreshActive(bd.analysisWindow)
+	clientShard.mu.Unlock()
+	
+	if network != "" {
+		networkShard := bd.shardFor(network)
+		networkShard.mu.Lock()
+		networkBehavior := bd.getOrCreateNetworkBehavior(networkShard, network)
+		bd.updateBehavioralIndicators(networkBehavior, path)
+		bd.observeURL(networkBehavior, path, req.Query)
+		bd.observeNavigation(networkBehavior, req)
+		networkBehavior.Addresses[intern.Key(networkBehavior.Addresses, ip, cfg.limits.PerIP)]++
+		bd.updateIPBehavior(networkBehavior, internedAgent, internedPath, responseTime, requests)
+		bd.share(networkShard, networkBehavior, requests)
+		if req.ClientID == "" {
+			bd.analyzeBehavior(networkBehavior, analysis)
+		}
+		networkShard.mu.Unlock()
+	}
+	
+	if req.ClientID != "" {
+		bd.analyzeCookie(req, internedAgent, internedPath, analysis)
+	}
+	if req.Identity != "" {
+		bd.analyzeIdentity(req, internedAgent, internedPath, analysis)
+	}
+	
+	// Update global patterns
+	bd.updateGlobalPatterns(ip)
 	
 	// 2. Network Analysis
 	bd.analyzeNetwork(ip, analysis)
@@ -153,15 +357,170 @@ func (bd *BotnetDetector) AnalyzeRequest(ctx context.Context, ip, userAgent, pat
 	// 5. Coordination Analysis
 	bd.analyzeCoordination(ip, analysis)
 	
+	// 6. TLS Fingerprint Analysis
+	if sharedFingerprint {
+		bd.addIndicator(analysis, "shared_tls_fingerprint", "Rare TLS fingerprint shared by many clients")
+	}
+	if sharedHeaders {
+		bd.addIndicator(analysis, "shared_header_fingerprint", "Rare header order shared by many clients")
+	}
+	
+	// 7. Content Negotiation Analysis
+	if mismatch := negotiationMismatch(req); mismatch != "" {
+		bd.addIndicator(analysis, "content_negotiation", "Accept headers do not match user agent: "+mismatch)
+	}
+	
+	// 8. Known bad bots, named by a signature rather than their behavior
+	if sig := cfg.signatures.Match(path, req.Header); sig != nil {
+		bd.addIndicator(analysis, "known_bad_bot", "Known bad bot: "+sig.Name)
+		analysis.Signals = append(analysis.Signals, "bad_bot:"+sig.ID)
+	}
+	
+	// 9. Headless browsers, by their user agent and missing headers
+	bd.analyzeHeadless(req, analysis)
+	
 	// Calculate final confidence and botnet decision
 	bd.calculateFinalDecision(analysis)
 	
+	// Keep a copy for Explain; the caller may change the analysis
+	remembered := *analysis
+	clientShard.mu.Lock()
+	behavior.lastAnalysis = &remembered
+	clientShard.mu.Unlock()
+	
 	return analysis
 }
 
-// getOrCreateIPBehavior gets or creates IP behavior tracking
-func (bd *BotnetDetector) getOrCreateIPBehavior(ip string) *IPBehavior {
-	if behavior, exists := bd.requestPatterns[ip]; exists {
+// RecordBurst notes that an IP sent a micro-burst, raising the micro_burst
+// indicator on its requests for the rest of the analysis window
+func (bd *BotnetDetector) RecordBurst(ip string) {
+	bd.mark(ip, func(behavior *IPBehavior, now time.Time) { behavior.LastBurst = now })
+}
+
+// RecordSlowRequests notes that an IP held too many slow requests open,
+// raising the slow_requests indicator on its requests for the rest of the
+// analysis window
+func (bd *BotnetDetector) RecordSlowRequests(ip string) {
+	bd.mark(ip, func(behavior *IPBehavior, now time.Time) { behavior.LastSlowRequests = now })
+}
+
+// mark applies set to the behavior of an IP and of its network
+func (bd *BotnetDetector) mark(ip string, set func(behavior *IPBehavior, now time.Time)) {
+	now := time.Now()
+	clientShard := bd.shardFor(ip)
+	clientShard.mu.Lock()
+	behavior := bd.getOrCreateIPBehavior(clientShard, ip)
+	set(behavior, now)
+	bd.share(clientShard, behavior, 0)
+	clientShard.mu.Unlock()
+
+	if network := bd.aggregateNetwork(ip); network != "" {
+		networkShard := bd.shardFor(network)
+		networkShard.mu.Lock()
+		networkBehavior := bd.getOrCreateNetworkBehavior(networkShard, network)
+		set(networkBehavior, now)
+		bd.share(networkShard, networkBehavior, 0)
+		networkShard.mu.Unlock()
+	}
+}
+
+// aggregateNetwork returns the IPv6 network ip is tracked under, or ""
+func (bd *BotnetDetector) aggregateNetwork(ip string) string {
+	bits := bd.config().ipv6Prefix
+	if bits <= 0 {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return ""
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// getOrCreateNetworkBehavior gets or creates the behavior of an IPv6
+// network; its shard's lock must be held
+func (bd *BotnetDetector) getOrCreateNetworkBehavior(s *shard, network string) *IPBehavior {
+	if behavior, exists := s.networkPatterns[network]; exists {
+		return behavior
+	}
+
+	behavior := &IPBehavior{
+		IP:           network,
+		FirstSeen:    time.Now(),
+		LastSeen:     time.Now(),
+		UserAgents:   make(map[string]int),
+		RequestPaths: make(map[string]int),
+		Addresses:    make(map[string]int),
+	}
+	s.networkPatterns[network] = behavior
+	atomic.AddInt64(&bd.networks, 1)
+	bd.capBehaviors(s, "networks", s.networkPatterns, behavior)
+	return behavior
+}
+
+// BehaviorSummary describes the tracked behavior of an address or network
+type BehaviorSummary struct {
+	Key           string    `json:"key"`
+	Requests      int64     `json:"requests"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	UserAgents    int       `json:"user_agents"`
+	Paths         int       `json:"paths"`
+	Addresses     int       `json:"addresses,omitempty"`
+	LoadsAssets   bool      `json:"loads_assets"`
+	AvgIntervalMs float64   `json:"avg_interval_ms"`
+}
+
+// BehaviorReport is the behavior of a client's address and, for grouped
+// IPv6 clients, of its network. Either is nil when nothing was tracked.
+type BehaviorReport struct {
+	Address *BehaviorSummary `json:"address,omitempty"`
+	Network *BehaviorSummary `json:"network,omitempty"`
+}
+
+// Behavior returns the tracked behavior of ip
+func (bd *BotnetDetector) Behavior(ip string) BehaviorReport {
+	var report BehaviorReport
+	clientShard := bd.shardFor(ip)
+	clientShard.mu.Lock()
+	if behavior, exists := clientShard.requestPatterns[ip]; exists {
+		report.Address = bd.summarize(behavior)
+	}
+	clientShard.mu.Unlock()
+
+	if network := bd.aggregateNetwork(ip); network != "" {
+		networkShard := bd.shardFor(network)
+		networkShard.mu.Lock()
+		if behavior, exists := networkShard.networkPatterns[network]; exists {
+			report.Network = bd.summarize(behavior)
+		}
+		networkShard.mu.Unlock()
+	}
+	return report
+}
+
+func (bd *BotnetDetector) summarize(behavior *IPBehavior) *BehaviorSummary {
+	return &BehaviorSummary{
+		Key:           behavior.IP,
+		Requests:      behavior.RequestCount,
+		FirstSeen:     behavior.FirstSeen,
+		LastSeen:      behavior.LastSeen,
+		UserAgents:    len(behavior.UserAgents),
+		Paths:         len(behavior.RequestPaths),
+		Addresses:     len(behavior.Addresses),
+		LoadsAssets:   behavior.HasJavascript || behavior.HasCSS || behavior.HasImages,
+		AvgIntervalMs: float64(bd.calculateAverageInterval(behavior.RequestIntervals)) / float64(time.Millisecond),
+	}
+}
+
+// getOrCreateIPBehavior gets or creates IP behavior tracking; its shard's
+// lock must be held
+func (bd *BotnetDetector) getOrCreateIPBehavior(s *shard, ip string) *IPBehavior {
+	if behavior, exists := s.requestPatterns[ip]; exists {
 		return behavior
 	}
 	
@@ -175,16 +534,24 @@ func (bd *BotnetDetector) getOrCreateIPBehavior(ip string) *IPBehavior {
 		RequestIntervals: []time.Duration{},
 	}
 	
-	bd.requestPatterns[ip] = behavior
+	s.requestPatterns[ip] = behavior
+	atomic.AddInt64(&bd.clients, 1)
+	bd.capBehaviors(s, "clients", s.requestPatterns, behavior)
 	return behavior
 }
 
-// updateIPBehavior updates IP behavior data
-func (bd *BotnetDetector) updateIPBehavior(behavior *IPBehavior, userAgent, path string, responseTime time.Duration) {
+// updateIPBehavior updates IP behavior data with a request standing for
+// requests requests
+func (bd *BotnetDetector) updateIPBehavior(behavior *IPBehavior, userAgent, path string, responseTime time.Duration, requests int64) {
 	now := time.Now()
-	
-	// Update intervals
-	if !behavior.LastSeen.IsZero() {
+	bd.decay(behavior, now)
+	behavior.RecentRequests += float64(requests)
+	
+	// Timing from before a window of silence no longer describes the client
+	if behavior.RequestCount > 0 && now.Sub(behavior.LastSeen) > bd.analysisWindow {
+		behavior.ResponseTimes = behavior.ResponseTimes[:0]
+		behavior.RequestIntervals = behavior.RequestIntervals[:0]
+	} else if !behavior.LastSeen.IsZero() {
 		interval := now.Sub(behavior.LastSeen)
 		behavior.RequestIntervals = append(behavior.RequestIntervals, interval)
 		if len(behavior.RequestIntervals) > 100 {
@@ -192,17 +559,15 @@ func (bd *BotnetDetector) updateIPBehavior(behavior *IPBehavior, userAgent, path
 		}
 	}
 	
-	behavior.RequestCount++
+	perIP := bd.config().limits.PerIP
+	behavior.RequestCount += requests
 	behavior.LastSeen = now
-	behavior.UserAgents[userAgent]++
-	behavior.RequestPaths[path]++
+	behavior.UserAgents[intern.Key(behavior.UserAgents, userAgent, perIP)] += int(requests)
+	behavior.RequestPaths[intern.Key(behavior.RequestPaths, path, perIP)] += int(requests)
 	behavior.ResponseTimes = append(behavior.ResponseTimes, responseTime)
 	if len(behavior.ResponseTimes) > 100 {
 		behavior.ResponseTimes = behavior.ResponseTimes[1:]
 	}
-	
-	// Update behavioral indicators
-	bd.updateBehavioralIndicators(behavior, path)
 }
 
 // updateBehavioralIndicators updates behavioral indicators
@@ -228,163 +593,199 @@ func (bd *BotnetDetector) updateBehavioralIndicators(behavior *IPBehavior, path
 	}
 }
 
-// updateGlobalPatterns updates global request patterns
-func (bd *BotnetDetector) updateGlobalPatterns(ip, userAgent, path string) {
-	patterns := bd.globalPatterns
-	patterns.TotalRequests++
-	
-	patterns.CommonUserAgents[userAgent]++
-	patterns.CommonPaths[path]++
-	
-	// Update geographic spread (simplified)
-	country := bd.getCountryFromIP(ip)
-	patterns.GeographicSpread[country]++
-	
-	// Update network spread
-	network := bd.getNetworkFromIP(ip)
-	patterns.NetworkSpread[network]++
+// updateGlobalPatterns updates global request patterns. The network spread
+// is the number of ranges analyzeNetwork tracks.
+func (bd *BotnetDetector) updateGlobalPatterns(ip string) {
+	atomic.AddInt64(&bd.totalRequests, 1)
+	
+	// Update geographic spread
+	if country := bd.getCountryFromIP(ip); country != "" {
+		count, ok := bd.countries.Load(country)
+		if !ok {
+			var loaded bool
+			if count, loaded = bd.countries.LoadOrStore(country, new(int64)); !loaded {
+				atomic.AddInt64(&bd.countryCount, 1)
+			}
+		}
+		atomic.AddInt64(count.(*int64), 1)
+	}
 }
 
 // analyzeBehavior analyzes individual IP behavior
 func (bd *BotnetDetector) analyzeBehavior(behavior *IPBehavior, analysis *BotnetAnalysis) {
 	// 1. Check for bot-like behavior patterns
-	if behavior.RequestCount > 20 && !behavior.HasJavascript {
-		analysis.Indicators = append(analysis.Indicators, "No JavaScript requests")
-		analysis.RiskScore += 20
+	requests := behavior.RecentRequests
+	if requests > bd.threshold("no_javascript") && !behavior.HasJavascript {
+		bd.addIndicator(analysis, "no_javascript", "No JavaScript requests")
 	}
 	
-	if behavior.RequestCount > 20 && !behavior.HasCSS {
-		analysis.Indicators = append(analysis.Indicators, "No CSS requests")
-		analysis.RiskScore += 15
+	if requests > bd.threshold("no_css") && !behavior.HasCSS {
+		bd.addIndicator(analysis, "no_css", "No CSS requests")
 	}
 	
 	// Check for very high request frequency (bot-like behavior)
-	if behavior.RequestCount > 50 {
-		analysis.Indicators = append(analysis.Indicators, "Very high request frequency")
-		analysis.RiskScore += 25
+	if requests > bd.threshold("high_request_frequency") {
+		bd.addIndicator(analysis, "high_request_frequency", "Very high request frequency")
 	}
 	
-	if behavior.RequestCount > 20 && !behavior.HasImages {
-		analysis.Indicators = append(analysis.Indicators, "No image requests")
-		analysis.RiskScore += 10
+	if requests > bd.threshold("no_images") && !behavior.HasImages {
+		bd.addIndicator(analysis, "no_images", "No image requests")
 	}
 	
 	// 2. Check for suspicious user agent patterns (only for high volume)
-	if len(behavior.UserAgents) == 1 && behavior.RequestCount > 20 {
-		analysis.Indicators = append(analysis.Indicators, "Single user agent")
-		analysis.RiskScore += 10
+	if len(behavior.UserAgents) == 1 && requests > bd.threshold("single_user_agent") {
+		bd.addIndicator(analysis, "single_user_agent", "Single user agent")
 	}
 	
 	// 3. Check for suspicious response time patterns (only for high volume)
 	if len(behavior.ResponseTimes) > 20 {
 		avgResponseTime := bd.calculateAverageResponseTime(behavior.ResponseTimes)
-		if avgResponseTime < 5*time.Millisecond {
-			analysis.Indicators = append(analysis.Indicators, "Suspiciously fast response times")
-			analysis.RiskScore += 15
+		if avgResponseTime < milliseconds(bd.threshold("fast_response_times")) {
+			bd.addIndicator(analysis, "fast_response_times", "Suspiciously fast response times")
 		}
 	}
 	
-	// 4. Check for suspicious request intervals (only for high volume)
+	// 4. Check for micro-bursts and slow requests caught elsewhere
+	bd.analyzeMarks(behavior, analysis)
+	
+	// 5. Check for suspicious request intervals (only for high volume)
 	if len(behavior.RequestIntervals) > 20 {
 		avgInterval := bd.calculateAverageInterval(behavior.RequestIntervals)
-		if avgInterval < 50*time.Millisecond {
-			analysis.Indicators = append(analysis.Indicators, "Suspiciously regular intervals")
-			analysis.RiskScore += 15
+		if avgInterval < milliseconds(bd.threshold("regular_intervals")) {
+			bd.addIndicator(analysis, "regular_intervals", "Suspiciously regular intervals")
 		}
 	}
+	
+	// 6. Check for randomized URLs defeating caches
+	bd.analyzeURLs(behavior, analysis)
+	
+	// 7. Check the pages follow one another as a browser's would
+	bd.analyzeNavigation(behavior, analysis)
+}
+
+// analyzeMarks raises the indicators marked on a behavior by the checks
+// outside the detector
+func (bd *BotnetDetector) analyzeMarks(behavior *IPBehavior, analysis *BotnetAnalysis) {
+	// Micro-bursts caught by the short rate limit windows
+	if !behavior.LastBurst.IsZero() && time.Since(behavior.LastBurst) < bd.analysisWindow {
+		bd.addIndicator(analysis, "micro_burst", "Micro-burst traffic")
+	}
+	if !behavior.LastSlowRequests.IsZero() && time.Since(behavior.LastSlowRequests) < bd.analysisWindow {
+		bd.addIndicator(analysis, "slow_requests", "Slow requests holding connections open")
+	}
+	if len(behavior.AutomationFlags) > 0 {
+		bd.addIndicator(analysis, "automation_telemetry", "Browser automation reported by the challenge page: "+strings.Join(behavior.AutomationFlags, ", "))
+	}
+	bd.analyzeScanning(behavior, analysis)
 }
 
 // analyzeNetwork analyzes network-level patterns
 func (bd *BotnetDetector) analyzeNetwork(ip string, analysis *BotnetAnalysis) {
 	network := bd.getNetworkFromIP(ip)
+	rangeShard := bd.shardFor(network)
+	rangeShard.mu.Lock()
 	
 	// Get or create network stats
-	networkStats, exists := bd.networkRanges[network]
+	networkStats, exists := rangeShard.networkRanges[network]
 	if !exists {
 		networkStats = &NetworkStats{
 			Network:   network,
 			FirstSeen: time.Now(),
 		}
-		bd.networkRanges[network] = networkStats
+		rangeShard.networkRanges[network] = networkStats
+		atomic.AddInt64(&bd.ranges, 1)
+		bd.capRanges(rangeShard, network)
 	}
 	
 	networkStats.IPCount++
+	networkStats.LastSeen = time.Now()
+	ipCount := networkStats.IPCount
+	rangeShard.mu.Unlock()
 	
 	// Check for network-level anomalies
-	if networkStats.IPCount > 100 {
-		analysis.Indicators = append(analysis.Indicators, "High IP count from network")
-		analysis.RiskScore += 30
+	if float64(ipCount) > bd.threshold("network_ip_count") {
+		bd.addIndicator(analysis, "network_ip_count", "High IP count from network")
 	}
 }
 
 // analyzeTiming analyzes timing patterns for coordination
 func (bd *BotnetDetector) analyzeTiming(ip string, analysis *BotnetAnalysis) {
 	now := time.Now()
-	windowStart := now.Add(-bd.analysisWindow)
 	
-	// Count requests in current time window
-	requestCount := 0
-	for _, behavior := range bd.requestPatterns {
-		if behavior.LastSeen.After(windowStart) {
-			requestCount++
-		}
+	// Count clients active in the current time window, as last counted by
+	// each shard and, when state is shared, by the other instances
+	var requestCount int64
+	for _, s := range bd.shards {
+		requestCount += atomic.LoadInt64(&s.active)
+	}
+	if cluster := bd.config().cluster; cluster != nil {
+		requestCount += cluster.activeElsewhere()
 	}
 	
 	// Check for coordinated timing
-	if requestCount > 1000 && now.Second()%10 == 0 {
-		analysis.Indicators = append(analysis.Indicators, "Coordinated timing pattern")
-		analysis.RiskScore += 40
+	if float64(requestCount) > bd.threshold("coordinated_timing") && now.Second()%10 == 0 {
+		bd.addIndicator(analysis, "coordinated_timing", "Coordinated timing pattern")
 	}
 }
 
 // analyzeGlobalPatterns analyzes global request patterns
 func (bd *BotnetDetector) analyzeGlobalPatterns(analysis *BotnetAnalysis) {
-	patterns := bd.globalPatterns
-	
 	// Check for unusual geographic distribution
-	if len(patterns.GeographicSpread) > 50 {
-		analysis.Indicators = append(analysis.Indicators, "Unusual geographic distribution")
-		analysis.RiskScore += 25
+	if float64(atomic.LoadInt64(&bd.countryCount)) > bd.threshold("geographic_distribution") {
+		bd.addIndicator(analysis, "geographic_distribution", "Unusual geographic distribution")
 	}
 	
 	// Check for unusual network distribution
-	if len(patterns.NetworkSpread) > 100 {
-		analysis.Indicators = append(analysis.Indicators, "Unusual network distribution")
-		analysis.RiskScore += 30
+	if float64(atomic.LoadInt64(&bd.ranges)) > bd.threshold("network_distribution") {
+		bd.addIndicator(analysis, "network_distribution", "Unusual network distribution")
 	}
 }
 
 // analyzeCoordination analyzes for coordinated attack patterns
 func (bd *BotnetDetector) analyzeCoordination(ip string, analysis *BotnetAnalysis) {
-	// Check for burst patterns
+	// Check for burst patterns in the current 10 second slot, across the
+	// fleet when state is shared
 	now := time.Now()
-	burstKey := fmt.Sprintf("%d-%d", now.Minute(), now.Second()/10)
-	
-	burst, exists := bd.burstPatterns[burstKey]
-	if !exists {
-		burst = &BurstPattern{
-			StartTime: now,
-		}
-		bd.burstPatterns[burstKey] = burst
+	count := bd.countBurst(now)
+	if cluster := bd.config().cluster; cluster != nil {
+		count = cluster.fleetBurst(now.Unix()/10, count)
 	}
 	
-	burst.IPCount++
-	burst.EndTime = now
-	
 	// Detect coordinated bursts
-	if burst.IPCount > 100 {
-		analysis.Indicators = append(analysis.Indicators, "Coordinated burst attack")
-		analysis.RiskScore += 50
+	if float64(count) > bd.threshold("coordinated_burst") {
+		bd.addIndicator(analysis, "coordinated_burst", "Coordinated burst attack")
 	}
 }
 
+// addIndicator records a triggered indicator with its weight, unless it is
+// disabled or its kill switch is engaged
+func (bd *BotnetDetector) addIndicator(analysis *BotnetAnalysis, id, description string) {
+	cfg := bd.config()
+	rule := cfg.scoring.Rules[id]
+	if !rule.Enabled || cfg.killSwitches.IsEngaged(killswitch.KindIndicator, id) {
+		return
+	}
+
+	analysis.Indicators = append(analysis.Indicators, description)
+	analysis.Signals = append(analysis.Signals, id)
+	analysis.RiskScore += rule.Weight
+}
+
+// milliseconds converts a threshold in milliseconds to a duration
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
 // calculateFinalDecision calculates the final confidence and botnet decision
 func (bd *BotnetDetector) calculateFinalDecision(analysis *BotnetAnalysis) {
-	// Calculate confidence based on risk score and indicators (reduced sensitivity)
-	baseConfidence := float64(analysis.RiskScore) / 200.0  // Reduced from 100.0 to 200.0
+	cfg := bd.config()
+	scoring := cfg.scoring
 	
-	// Adjust confidence based on number of indicators (reduced bonus)
-	indicatorBonus := float64(len(analysis.Indicators)) * 0.05  // Reduced from 0.1 to 0.05
+	// Calculate confidence based on risk score and indicators
+	baseConfidence := float64(analysis.RiskScore) / scoring.RiskDivisor
+	
+	// Adjust confidence based on number of indicators
+	indicatorBonus := float64(len(analysis.Indicators)) * scoring.IndicatorBonus
 	analysis.Confidence = baseConfidence + indicatorBonus
 	
 	// Cap confidence at 1.0
@@ -393,44 +794,59 @@ func (bd *BotnetDetector) calculateFinalDecision(analysis *BotnetAnalysis) {
 	}
 	
 	// Make botnet decision based on confidence threshold
-	analysis.IsBotnet = analysis.Confidence >= bd.detectionThreshold
+	analysis.IsBotnet = analysis.Confidence >= cfg.detectionThreshold
 	
-	// For testing purposes, only consider extremely high risk scores as botnet
-	if analysis.RiskScore >= 300 {
+	// Extremely high risk scores are a botnet whatever the threshold
+	if scoring.OverrideRisk > 0 && analysis.RiskScore >= scoring.OverrideRisk {
 		analysis.IsBotnet = true
-		if analysis.Confidence < 0.8 {
-			analysis.Confidence = 0.8
+		if analysis.Confidence < scoring.OverrideConfidence {
+			analysis.Confidence = scoring.OverrideConfidence
 		}
 	}
 }
 
 // BotnetAnalysis represents the result of botnet analysis
+
 type BotnetAnalysis struct {
-	IP         string
-	Timestamp  time.Time
-	IsBotnet   bool
-	Confidence float64
-	Indicators []string
-	RiskScore  int
+	IP         string    `json:"ip"`
+	Network    string    `json:"network,omitempty"` // IPv6 network whose behavior was analyzed, if grouped
+	Timestamp  time.Time `json:"timestamp"`
+	IsBotnet   bool      `json:"is_botnet"`
+	Confidence float64   `json:"confidence"`
+	Indicators []string  `json:"indicators"`
+	RiskScore  int       `json:"risk_score"`
+	Scores     []Score   `json:"scores,omitempty"`  // per detector, when several are combined
+	Signals    []string  `json:"signals"`           // IDs of the indicators raised, and facts such as new_client
+	Sampled    bool      `json:"sampled,omitempty"` // answered from the client's latest analysis under load
 }
 
 // Helper methods
 func (bd *BotnetDetector) getCountryFromIP(ip string) string {
-	// Simplified - in production, use GeoIP database
-	parts := strings.Split(ip, ".")
-	if len(parts) >= 2 {
-		return fmt.Sprintf("%s.%s", parts[0], parts[1])
+	lookup := bd.config().countryLookup
+	if lookup == nil {
+		return ""
 	}
-	return "unknown"
+	return lookup(ip)
 }
 
 func (bd *BotnetDetector) getNetworkFromIP(ip string) string {
-	// Simplified - in production, use proper network calculation
-	parts := strings.Split(ip, ".")
-	if len(parts) >= 3 {
-		return fmt.Sprintf("%s.%s.%s", parts[0], parts[1], parts[2])
+	// Group IPv4 clients by /24 and IPv6 clients by /64
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "unknown"
+	}
+	addr = addr.Unmap()
+
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "unknown"
 	}
-	return "unknown"
+	return prefix.String()
 }
 
 func (bd *BotnetDetector) calculateAverageResponseTime(times []time.Duration) time.Duration {
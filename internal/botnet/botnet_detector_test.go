@@ -0,0 +1,39 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkAnalyzeRequestParallel analyzes requests from distinct clients
+// on every core. Run with -cpu 1,2,4,8: with the state sharded, ns/op
+// should fall close to linearly as cores are added.
+func BenchmarkAnalyzeRequestParallel(b *testing.B) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+	var workers int64
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		worker := atomic.AddInt64(&workers, 1)
+		ips := make([]string, 1024)
+		for i := range ips {
+			ips[i] = fmt.Sprintf("10.%d.%d.%d", worker, i/256, i%256)
+		}
+
+		i := 0
+		for pb.Next() {
+			bd.AnalyzeRequest(ctx, Request{
+				IP:             ips[i%len(ips)],
+				UserAgent:      "Mozilla/5.0",
+				Path:           "/index.html",
+				TLSFingerprint: "t13d1516h2_8daaf6152771_02713d6af862",
+				ResponseTime:   time.Millisecond,
+			})
+			i++
+		}
+	})
+}
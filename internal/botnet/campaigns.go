@@ -0,0 +1,309 @@
+package botnet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// minCampaignRequests is the recent requests a client needs before its
+	// behavior says enough to be clustered
+	minCampaignRequests = 5
+	// pathSimilarity is the cosine similarity of path distributions above
+	// which two clients are taken to be working through the same targets
+	pathSimilarity = 0.8
+	// maxCampaignMembers bounds the addresses listed for a campaign
+	maxCampaignMembers = 100
+	// campaignPaths is the most requested paths listed for a campaign
+	campaignPaths = 5
+)
+
+// Campaign is a group of clients behaving alike: the same user agent,
+// sending at the same pace, to the same paths. A distributed attack spread
+// thin enough that each address stays under every limit still shows up as
+// one large campaign.
+type Campaign struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	UserAgent string        `json:"user_agent"`
+	Paths     []string      `json:"paths"`
+	Interval  time.Duration `json:"interval_ns"` // typical gap between one member's requests
+	Clients   int           `json:"clients"`
+	Networks  int           `json:"networks"` // distinct /24 and /64 networks
+	Members   []string      `json:"members"`  // up to maxCampaignMembers, by recent requests
+	Requests  float64       `json:"recent_requests"`
+	RiskScore float64       `json:"avg_risk_score"`
+	FirstSeen time.Time     `json:"first_seen"`
+	LastSeen  time.Time     `json:"last_seen"`
+}
+
+// campaignMember is the behavior of a client, reduced to what clustering
+// compares
+type campaignMember struct {
+	ip        string
+	userAgent string
+	pace      int // log2 of the average interval in milliseconds
+	interval  time.Duration
+	paths     map[string]int
+	norm      float64
+	requests  float64
+	risk      float64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// cluster is a campaign being built
+type cluster struct {
+	members  []*campaignMember
+	centroid map[string]float64
+	norm     float64
+}
+
+// ClusterCampaigns groups the clients active within the analysis window
+// into campaigns of at least minClients members, keeps them for Campaigns
+// and returns them, largest first
+func (bd *BotnetDetector) ClusterCampaigns(now time.Time, minClients int) []Campaign {
+	if minClients < 2 {
+		minClients = 2
+	}
+
+	// Clients only cluster with others sending the same user agent at the
+	// same pace; within such a group, by their paths
+	groups := make(map[string][]*campaignMember)
+	for _, m := range bd.campaignMembers(now) {
+		key := fmt.Sprintf("%s|%d", m.userAgent, m.pace)
+		groups[key] = append(groups[key], m)
+	}
+
+	previous := make(map[string]Campaign)
+	for _, c := range bd.Campaigns() {
+		previous[c.ID] = c
+	}
+
+	campaigns := []Campaign{}
+	for _, members := range groups {
+		if len(members) < minClients {
+			continue
+		}
+		for _, cl := range clusterByPaths(members) {
+			if len(cl.members) < minClients {
+				continue
+			}
+			campaign := bd.newCampaign(cl)
+			if old, seen := previous[campaign.ID]; seen && old.FirstSeen.Before(campaign.FirstSeen) {
+				campaign.FirstSeen = old.FirstSeen
+			}
+			campaigns = append(campaigns, campaign)
+		}
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		if campaigns[i].Clients != campaigns[j].Clients {
+			return campaigns[i].Clients > campaigns[j].Clients
+		}
+		return campaigns[i].ID < campaigns[j].ID
+	})
+
+	bd.campaigns.Store(campaigns)
+	return campaigns
+}
+
+// Campaigns returns the campaigns found by the latest ClusterCampaigns
+func (bd *BotnetDetector) Campaigns() []Campaign {
+	campaigns, _ := bd.campaigns.Load().([]Campaign)
+	if campaigns == nil {
+		return []Campaign{}
+	}
+	return campaigns
+}
+
+// campaignMembers reduces the behavior of each client active within the
+// analysis window and busy enough to judge, holding one shard at a time
+func (bd *BotnetDetector) campaignMembers(now time.Time) []*campaignMember {
+	windowStart := now.Add(-bd.analysisWindow)
+	var members []*campaignMember
+	for _, s := range bd.shards {
+		s.mu.Lock()
+		for ip, behavior := range s.requestPatterns {
+			if behavior.LastSeen.Before(windowStart) {
+				continue
+			}
+			bd.decay(behavior, now)
+			if behavior.RecentRequests < minCampaignRequests {
+				continue
+			}
+
+			interval := bd.calculateAverageInterval(behavior.RequestIntervals)
+			m := &campaignMember{
+				ip:        ip,
+				userAgent: dominant(behavior.UserAgents),
+				pace:      pace(interval),
+				interval:  interval,
+				paths:     copyCounts(behavior.RequestPaths),
+				requests:  behavior.RecentRequests,
+				firstSeen: behavior.FirstSeen,
+				lastSeen:  behavior.LastSeen,
+			}
+			for _, count := range m.paths {
+				m.norm += float64(count) * float64(count)
+			}
+			m.norm = math.Sqrt(m.norm)
+			if behavior.lastAnalysis != nil {
+				m.risk = float64(behavior.lastAnalysis.RiskScore)
+			}
+			members = append(members, m)
+		}
+		s.mu.Unlock()
+	}
+
+	// Order members so clustering, which depends on it, is repeatable
+	sort.Slice(members, func(i, j int) bool { return members[i].ip < members[j].ip })
+	return members
+}
+
+// clusterByPaths puts each member in the first cluster whose paths are
+// similar enough to its own, or a cluster of its own
+func clusterByPaths(members []*campaignMember) []*cluster {
+	var clusters []*cluster
+	for _, m := range members {
+		var best *cluster
+		bestSimilarity := pathSimilarity
+		for _, cl := range clusters {
+			if similarity := cl.similarity(m); similarity >= bestSimilarity {
+				best, bestSimilarity = cl, similarity
+			}
+		}
+		if best == nil {
+			best = &cluster{centroid: make(map[string]float64)}
+			clusters = append(clusters, best)
+		}
+		best.add(m)
+	}
+	return clusters
+}
+
+// similarity is the cosine similarity of a member's paths to the
+// cluster's
+func (cl *cluster) similarity(m *campaignMember) float64 {
+	if cl.norm == 0 || m.norm == 0 {
+		return 0
+	}
+	var dot float64
+	for path, count := range m.paths {
+		dot += cl.centroid[path] * float64(count) / m.norm
+	}
+	return dot / cl.norm
+}
+
+// add puts a member in the cluster, counting its path distribution, scaled
+// to unit length so busy members do not outweigh the rest, in the centroid
+func (cl *cluster) add(m *campaignMember) {
+	cl.members = append(cl.members, m)
+	if m.norm > 0 {
+		for path, count := range m.paths {
+			cl.centroid[path] += float64(count) / m.norm
+		}
+	}
+	var norm float64
+	for _, weight := range cl.centroid {
+		norm += weight * weight
+	}
+	cl.norm = math.Sqrt(norm)
+}
+
+// newCampaign describes a cluster. Its ID follows from the user agent,
+// pace and top path, so the same campaign keeps its ID from one clustering
+// to the next.
+func (bd *BotnetDetector) newCampaign(cl *cluster) Campaign {
+	first := cl.members[0]
+	campaign := Campaign{
+		UserAgent: first.userAgent,
+		Paths:     topPaths(cl.centroid, campaignPaths),
+		Clients:   len(cl.members),
+		FirstSeen: first.firstSeen,
+		LastSeen:  first.lastSeen,
+	}
+
+	byRequests := append([]*campaignMember(nil), cl.members...)
+	sort.SliceStable(byRequests, func(i, j int) bool { return byRequests[i].requests > byRequests[j].requests })
+
+	networks := make(map[string]bool)
+	intervals := make([]time.Duration, 0, len(cl.members))
+	var risk float64
+	for i, m := range byRequests {
+		if i < maxCampaignMembers {
+			campaign.Members = append(campaign.Members, m.ip)
+		}
+		networks[bd.getNetworkFromIP(m.ip)] = true
+		intervals = append(intervals, m.interval)
+		campaign.Requests += m.requests
+		risk += m.risk
+		if m.firstSeen.Before(campaign.FirstSeen) {
+			campaign.FirstSeen = m.firstSeen
+		}
+		if m.lastSeen.After(campaign.LastSeen) {
+			campaign.LastSeen = m.lastSeen
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	campaign.Interval = intervals[len(intervals)/2]
+	campaign.Networks = len(networks)
+	campaign.RiskScore = risk / float64(len(cl.members))
+
+	topPath := ""
+	if len(campaign.Paths) > 0 {
+		topPath = campaign.Paths[0]
+	}
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s|%d|%s", first.userAgent, first.pace, topPath)
+	campaign.ID = fmt.Sprintf("campaign-%08x", hash.Sum64()>>32)
+
+	agent := campaign.UserAgent
+	if agent == "" {
+		agent = "no user agent"
+	}
+	campaign.Name = fmt.Sprintf("%s on %s every %s", agent, topPath, campaign.Interval.Round(time.Millisecond))
+	return campaign
+}
+
+// dominant returns the most counted key, the first in order on a tie
+func dominant(counts map[string]int) string {
+	best, bestCount := "", -1
+	for key, count := range counts {
+		if count > bestCount || (count == bestCount && key < best) {
+			best, bestCount = key, count
+		}
+	}
+	return best
+}
+
+// pace buckets an interval by its order of magnitude, so clients sending
+// every 200ms and every 250ms share a pace while those sending every
+// second do not
+func pace(interval time.Duration) int {
+	ms := float64(interval) / float64(time.Millisecond)
+	if ms < 1 {
+		return 0
+	}
+	return int(math.Round(math.Log2(ms))) + 1
+}
+
+// topPaths returns the n most weighted paths
+func topPaths(weights map[string]float64, n int) []string {
+	paths := make([]string, 0, len(weights))
+	for path := range weights {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if weights[paths[i]] != weights[paths[j]] {
+			return weights[paths[i]] > weights[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
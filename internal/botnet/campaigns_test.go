@@ -0,0 +1,56 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClusterCampaigns(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+
+	// Twelve addresses across three networks each send a few login
+	// attempts, too few to trip anything on their own
+	for i := 0; i < 12; i++ {
+		ip := fmt.Sprintf("203.0.%d.%d", 113+i%3, 10+i)
+		for j := 0; j < 6; j++ {
+			path := "/login"
+			if j%3 == 2 {
+				path = "/account"
+			}
+			bd.AnalyzeRequest(ctx, Request{IP: ip, UserAgent: "python-requests/2.31", Path: path})
+		}
+	}
+	// Browsers browsing on their own, and one quiet client of the tool
+	for i, paths := range [][]string{{"/", "/static/app.js", "/style.css", "/news", "/about"}, {"/shop", "/cart", "/checkout", "/static/app.js", "/logo.png"}} {
+		for _, path := range paths {
+			bd.AnalyzeRequest(ctx, Request{IP: fmt.Sprintf("192.0.2.%d", i+1), UserAgent: "Mozilla/5.0", Path: path})
+		}
+	}
+	bd.AnalyzeRequest(ctx, Request{IP: "192.0.2.50", UserAgent: "python-requests/2.31", Path: "/login"})
+
+	campaigns := bd.ClusterCampaigns(time.Now(), 5)
+	if len(campaigns) != 1 {
+		t.Fatalf("campaigns = %+v, want one", campaigns)
+	}
+	c := campaigns[0]
+	if c.Clients != 12 || c.Networks != 3 || len(c.Members) != 12 || c.UserAgent != "python-requests/2.31" {
+		t.Errorf("campaign = %+v", c)
+	}
+	if len(c.Paths) != 2 || c.Paths[0] != "/login" {
+		t.Errorf("paths = %v, want /login first", c.Paths)
+	}
+	if got := bd.Campaigns(); len(got) != 1 || got[0].ID != c.ID {
+		t.Errorf("Campaigns() = %+v", got)
+	}
+
+	// The same campaign keeps its ID
+	if again := bd.ClusterCampaigns(time.Now(), 5); len(again) != 1 || again[0].ID != c.ID || !again[0].FirstSeen.Equal(c.FirstSeen) {
+		t.Errorf("reclustered = %+v, want %s", again, c.ID)
+	}
+	if none := bd.ClusterCampaigns(time.Now(), 13); len(none) != 0 {
+		t.Errorf("campaigns smaller than the minimum: %+v", none)
+	}
+}
@@ -0,0 +1,334 @@
+package botnet
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// behaviorPrefix prefixes the hash holding a client's or network's
+	// fleet-wide behavior
+	behaviorPrefix = "botnet:behavior:"
+	// burstPrefix prefixes the fleet-wide request count of a 10 second slot
+	burstPrefix = "botnet:burst:"
+	// activeKey maps each instance to its active clients and when it
+	// counted them
+	activeKey = "botnet:active"
+)
+
+// syncBatch is how many behaviors are written in one Redis pipeline
+const syncBatch = 500
+
+// behaviorFlags are the asset loads shared for each behavior, by hash field
+var behaviorFlags = []struct {
+	field string
+	flag  func(b *IPBehavior) *bool
+}{
+	{"js", func(b *IPBehavior) *bool { return &b.HasJavascript }},
+	{"css", func(b *IPBehavior) *bool { return &b.HasCSS }},
+	{"images", func(b *IPBehavior) *bool { return &b.HasImages }},
+	{"favicon", func(b *IPBehavior) *bool { return &b.HasFavicon }},
+	{"robots", func(b *IPBehavior) *bool { return &b.HasRobotsTxt }},
+	{"sitemap", func(b *IPBehavior) *bool { return &b.HasSitemap }},
+}
+
+// Cluster shares a detector's behavior state with other instances through
+// Redis. Each instance adds its requests and asset loads to a hash per
+// client and network and reads back the fleet's totals, so a client that
+// spreads its requests over the fleet is judged on all of them. Burst
+// counts and active clients are shared the same way for coordination
+// analysis. Requests never wait on Redis: changes are written every sync,
+// so the fleet view lags by up to one interval.
+type Cluster struct {
+	// Fleet counts as of the last sync; 64-bit fields first so they are
+	// aligned on 32-bit platforms
+	burstSlot   int64 // 10 second slot the burst counts are for
+	burstTotal  int64 // fleet requests in burstSlot
+	burstLocal  int64 // this instance's requests in burstSlot at the sync
+	otherActive int64 // clients active on other instances
+
+	bd       *BotnetDetector
+	client   *redis.Client
+	node     string
+	interval time.Duration
+	flushed  map[int64]int64 // burst slot -> local count already written
+}
+
+// behaviorDelta is a behavior's change since the last sync
+type behaviorDelta struct {
+	key       string
+	requests  int64
+	flags     []string
+	firstSeen time.Time
+	lastBurst time.Time
+}
+
+// NewCluster shares bd's state through client as node, syncing every
+// interval
+func NewCluster(bd *BotnetDetector, client *redis.Client, node string, interval time.Duration) *Cluster {
+	c := &Cluster{
+		bd:       bd,
+		client:   client,
+		node:     node,
+		interval: interval,
+		flushed:  make(map[int64]int64),
+	}
+	bd.configure(func(cfg *settings) {
+		cfg.cluster = c
+	})
+	return c
+}
+
+// Run syncs every interval until ctx is done, reporting failures to
+// onError
+func (c *Cluster) Run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Sync(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync writes this instance's changes and reads back the fleet's view.
+// Changes that could not be written are kept for the next sync.
+func (c *Cluster) Sync(ctx context.Context) error {
+	deltas := c.collect()
+	for start := 0; start < len(deltas); start += syncBatch {
+		end := start + syncBatch
+		if end > len(deltas) {
+			end = len(deltas)
+		}
+		if err := c.syncBehaviors(ctx, deltas[start:end]); err != nil {
+			c.requeue(deltas[start:])
+			return err
+		}
+	}
+	return c.syncCoordination(ctx, time.Now())
+}
+
+// share queues a behavior's change for the next sync; its shard's lock must
+// be held
+func (bd *BotnetDetector) share(s *shard, behavior *IPBehavior, requests int64) {
+	if bd.config().cluster == nil {
+		return
+	}
+	behavior.pending += requests
+	if s.dirty == nil {
+		s.dirty = make(map[string]*IPBehavior)
+	}
+	s.dirty[behavior.IP] = behavior
+}
+
+// collect takes the changes queued since the last sync
+func (c *Cluster) collect() []behaviorDelta {
+	var deltas []behaviorDelta
+	for _, s := range c.bd.shards {
+		s.mu.Lock()
+		for key, behavior := range s.dirty {
+			delta := behaviorDelta{
+				key:       key,
+				requests:  behavior.pending,
+				firstSeen: behavior.FirstSeen,
+				lastBurst: behavior.LastBurst,
+			}
+			for _, f := range behaviorFlags {
+				if *f.flag(behavior) {
+					delta.flags = append(delta.flags, f.field)
+				}
+			}
+			behavior.pending = 0
+			deltas = append(deltas, delta)
+		}
+		s.dirty = nil
+		s.mu.Unlock()
+	}
+	return deltas
+}
+
+// requeue puts back changes that were not written
+func (c *Cluster) requeue(deltas []behaviorDelta) {
+	for _, d := range deltas {
+		s := c.bd.shardFor(d.key)
+		s.mu.Lock()
+		if behavior := s.behavior(d.key); behavior != nil {
+			behavior.pending += d.requests
+			if s.dirty == nil {
+				s.dirty = make(map[string]*IPBehavior)
+			}
+			s.dirty[d.key] = behavior
+		}
+		s.mu.Unlock()
+	}
+}
+
+// syncBehaviors adds deltas to the fleet's behaviors and applies the
+// resulting totals
+func (c *Cluster) syncBehaviors(ctx context.Context, deltas []behaviorDelta) error {
+	ttl := c.bd.config().stateTTL
+	pipe := c.client.Pipeline()
+	results := make([]*redis.StringStringMapCmd, len(deltas))
+	for i, d := range deltas {
+		key := behaviorPrefix + d.key
+		if d.requests > 0 {
+			pipe.HIncrBy(ctx, key, "requests", d.requests)
+		}
+		pipe.HSetNX(ctx, key, "first_seen", d.firstSeen.UnixNano())
+		fields := make(map[string]interface{}, len(d.flags)+1)
+		for _, flag := range d.flags {
+			fields[flag] = 1
+		}
+		if !d.lastBurst.IsZero() {
+			fields["last_burst"] = d.lastBurst.UnixNano()
+		}
+		if len(fields) > 0 {
+			pipe.HSet(ctx, key, fields)
+		}
+		pipe.Expire(ctx, key, ttl)
+		results[i] = pipe.HGetAll(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	for i, d := range deltas {
+		c.apply(d.key, results[i].Val())
+	}
+	return nil
+}
+
+// apply brings a behavior up to the fleet's totals. The fleet's request
+// count includes this instance's written requests, so the ones not yet
+// written are added back.
+func (c *Cluster) apply(key string, fleet map[string]string) {
+	s := c.bd.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	behavior := s.behavior(key)
+	if behavior == nil {
+		return
+	}
+	if requests, err := strconv.ParseInt(fleet["requests"], 10, 64); err == nil && requests+behavior.pending > behavior.RequestCount {
+		// Requests served elsewhere since the last sync count as recent
+		c.bd.decay(behavior, time.Now())
+		behavior.RecentRequests += float64(requests + behavior.pending - behavior.RequestCount)
+		behavior.RequestCount = requests + behavior.pending
+	}
+	for _, f := range behaviorFlags {
+		if fleet[f.field] != "" {
+			*f.flag(behavior) = true
+		}
+	}
+	if ns, err := strconv.ParseInt(fleet["first_seen"], 10, 64); err == nil {
+		if firstSeen := time.Unix(0, ns); firstSeen.Before(behavior.FirstSeen) {
+			behavior.FirstSeen = firstSeen
+		}
+	}
+	if ns, err := strconv.ParseInt(fleet["last_burst"], 10, 64); err == nil {
+		if lastBurst := time.Unix(0, ns); lastBurst.After(behavior.LastBurst) {
+			behavior.LastBurst = lastBurst
+		}
+	}
+}
+
+// behavior returns the client, cookie or network behavior stored under
+// key; s.mu must be held
+func (s *shard) behavior(key string) *IPBehavior {
+	if strings.HasPrefix(key, cookiePrefix) {
+		return s.cookiePatterns[key]
+	}
+	if strings.HasPrefix(key, identityPrefix) {
+		return s.identityPatterns[key]
+	}
+	if strings.Contains(key, "/") {
+		return s.networkPatterns[key]
+	}
+	return s.requestPatterns[key]
+}
+
+// syncCoordination shares this instance's burst counts and active clients
+// and reads back the fleet's
+func (c *Cluster) syncCoordination(ctx context.Context, now time.Time) error {
+	slot := now.Unix() / 10
+	pipe := c.client.Pipeline()
+
+	// The previous slot may have gained requests since its last sync
+	local := make(map[int64]int64, 2)
+	for _, s := range []int64{slot - 1, slot} {
+		local[s] = c.bd.burstCount(s)
+		if delta := local[s] - c.flushed[s]; delta > 0 {
+			key := burstPrefix + strconv.FormatInt(s, 10)
+			pipe.IncrBy(ctx, key, delta)
+			pipe.Expire(ctx, key, burstSlots*10*time.Second)
+		}
+	}
+	total := pipe.Get(ctx, burstPrefix+strconv.FormatInt(slot, 10))
+
+	var active int64
+	for _, s := range c.bd.shards {
+		active += atomic.LoadInt64(&s.active)
+	}
+	pipe.HSet(ctx, activeKey, c.node, strconv.FormatInt(active, 10)+":"+strconv.FormatInt(now.Unix(), 10))
+	pipe.Expire(ctx, activeKey, 3*c.interval)
+	nodes := pipe.HGetAll(ctx, activeKey)
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	c.flushed = local
+
+	fleetBurst, _ := total.Int64()
+	atomic.StoreInt64(&c.burstTotal, fleetBurst)
+	atomic.StoreInt64(&c.burstLocal, local[slot])
+	atomic.StoreInt64(&c.burstSlot, slot)
+
+	// Instances that stopped reporting are dropped
+	var others int64
+	var stale []string
+	for node, value := range nodes.Val() {
+		count, at, _ := strings.Cut(value, ":")
+		seen, _ := strconv.ParseInt(at, 10, 64)
+		if now.Sub(time.Unix(seen, 0)) > 3*c.interval {
+			stale = append(stale, node)
+			continue
+		}
+		if node != c.node {
+			n, _ := strconv.ParseInt(count, 10, 64)
+			others += n
+		}
+	}
+	atomic.StoreInt64(&c.otherActive, others)
+	if len(stale) > 0 {
+		c.client.HDel(ctx, activeKey, stale...)
+	}
+	return nil
+}
+
+// fleetBurst returns the fleet's requests in slot given this instance's
+// current count, falling back to the local count before the slot's first
+// sync
+func (c *Cluster) fleetBurst(slot, local int64) int64 {
+	if atomic.LoadInt64(&c.burstSlot) != slot {
+		return local
+	}
+	return atomic.LoadInt64(&c.burstTotal) + local - atomic.LoadInt64(&c.burstLocal)
+}
+
+// activeElsewhere returns the clients active on other instances as of the
+// last sync. A client talking to several instances is counted by each.
+func (c *Cluster) activeElsewhere() int64 {
+	return atomic.LoadInt64(&c.otherActive)
+}
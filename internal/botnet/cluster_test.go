@@ -0,0 +1,52 @@
+package botnet
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClusterAppliesFleetBehavior(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	c := NewCluster(bd, nil, "a", time.Second)
+	request := Request{IP: "10.0.0.1", UserAgent: "agent", Path: "/"}
+
+	for i := 0; i < 30; i++ {
+		bd.AnalyzeRequest(context.Background(), request)
+	}
+	deltas := c.collect()
+	if len(deltas) != 1 || deltas[0].key != "10.0.0.1" || deltas[0].requests != 30 {
+		t.Fatalf("deltas %+v", deltas)
+	}
+
+	// Other instances served the client too, and saw it load scripts
+	earlier := time.Now().Add(-time.Hour)
+	bd.AnalyzeRequest(context.Background(), request)
+	c.apply("10.0.0.1", map[string]string{
+		"requests":   "90",
+		"js":         "1",
+		"first_seen": strconv.FormatInt(earlier.UnixNano(), 10),
+	})
+
+	summary := bd.Behavior("10.0.0.1").Address
+	if summary.Requests != 91 || !summary.FirstSeen.Equal(earlier) {
+		t.Errorf("behavior after sync %+v", summary)
+	}
+	analysis := bd.AnalyzeRequest(context.Background(), request)
+	if !hasSignal(analysis, "high_request_frequency") || hasSignal(analysis, "no_javascript") || hasSignal(analysis, "new_client") {
+		t.Errorf("signals %v", analysis.Signals)
+	}
+	if deltas := c.collect(); len(deltas) != 1 || deltas[0].requests != 2 || len(deltas[0].flags) != 1 {
+		t.Errorf("pending deltas %+v", deltas)
+	}
+
+	// Bursts add the fleet's count to requests since the sync
+	c.burstSlot, c.burstTotal, c.burstLocal = 100, 500, 20
+	if n := c.fleetBurst(100, 25); n != 505 {
+		t.Errorf("fleet burst %d, want 505", n)
+	}
+	if n := c.fleetBurst(101, 3); n != 3 {
+		t.Errorf("burst of unsynced slot %d, want 3", n)
+	}
+}
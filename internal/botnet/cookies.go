@@ -0,0 +1,59 @@
+package botnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// cookiePrefix keys the behavior of a tracking cookie apart from addresses
+// and networks
+const cookiePrefix = "cookie:"
+
+// analyzeCookie updates and analyzes the behavior of the client holding a
+// tracking cookie. Clients sharing an address behind NAT each have their
+// own, so one misbehaving client does not raise indicators for the rest.
+func (bd *BotnetDetector) analyzeCookie(req Request, userAgent, path string, analysis *BotnetAnalysis) {
+	key := cookiePrefix + req.ClientID
+	s := bd.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	behavior := bd.getOrCreateCookieBehavior(s, key)
+	bd.updateBehavioralIndicators(behavior, req.Path)
+	bd.observeURL(behavior, req.Path, req.Query)
+	bd.observeNavigation(behavior, req)
+	bd.updateIPBehavior(behavior, userAgent, path, req.ResponseTime, req.requests())
+	bd.share(s, behavior, req.requests())
+	bd.analyzeBehavior(behavior, analysis)
+}
+
+// getOrCreateCookieBehavior gets or creates the behavior of a tracking
+// cookie; its shard's lock must be held
+func (bd *BotnetDetector) getOrCreateCookieBehavior(s *shard, key string) *IPBehavior {
+	if behavior, exists := s.cookiePatterns[key]; exists {
+		return behavior
+	}
+
+	behavior := &IPBehavior{
+		IP:           key,
+		FirstSeen:    time.Now(),
+		LastSeen:     time.Now(),
+		UserAgents:   make(map[string]int),
+		RequestPaths: make(map[string]int),
+	}
+	s.cookiePatterns[key] = behavior
+	atomic.AddInt64(&bd.cookies, 1)
+	bd.capBehaviors(s, "cookies", s.cookiePatterns, behavior)
+	return behavior
+}
+
+// analyzeCookieless flags an address that keeps sending requests without
+// returning the tracking cookie issued to it. Browsers return it from
+// their second request on, so nearly all of an address's requests lacking
+// it means the client discards cookies, as flood tools do.
+func (bd *BotnetDetector) analyzeCookieless(behavior *IPBehavior, analysis *BotnetAnalysis) {
+	cookieless := behavior.RecentCookieless
+	if cookieless > bd.threshold("no_client_cookie") && cookieless*10 >= behavior.RecentRequests*9 {
+		bd.addIndicator(analysis, "no_client_cookie", "Tracking cookie never returned")
+	}
+}
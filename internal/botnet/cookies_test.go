@@ -0,0 +1,53 @@
+package botnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCookieTrackingSeparatesClientsBehindNAT(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+	nat := "198.51.100.7"
+
+	// A scraper and a browser share an address; the scraper hammers one
+	// page while the browser loads a page with its assets
+	for i := 0; i < 60; i++ {
+		bd.AnalyzeRequest(ctx, Request{IP: nat, UserAgent: "scraper", Path: "/prices", ClientID: "scraper"})
+	}
+	for _, path := range []string{"/", "/static/app.js", "/style.css", "/logo.png"} {
+		bd.AnalyzeRequest(ctx, Request{IP: nat, UserAgent: "browser", Path: path, ClientID: "browser"})
+	}
+
+	scraper := bd.AnalyzeRequest(ctx, Request{IP: nat, UserAgent: "scraper", Path: "/prices", ClientID: "scraper"})
+	browser := bd.AnalyzeRequest(ctx, Request{IP: nat, UserAgent: "browser", Path: "/cart", ClientID: "browser"})
+	if !hasSignal(scraper, "high_request_frequency") {
+		t.Errorf("scraper signals %v, want high_request_frequency", scraper.Signals)
+	}
+	if hasSignal(browser, "high_request_frequency") || hasSignal(browser, "no_javascript") {
+		t.Errorf("browser signals %v, want none of the scraper's", browser.Signals)
+	}
+}
+
+func TestCookielessClients(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+
+	var flood *BotnetAnalysis
+	for i := 0; i < 30; i++ {
+		flood = bd.AnalyzeRequest(ctx, Request{IP: "203.0.113.1", Path: "/", Cookieless: true})
+	}
+	if !hasSignal(flood, "no_client_cookie") {
+		t.Errorf("cookieless flood signals %v, want no_client_cookie", flood.Signals)
+	}
+
+	// A browser returns the cookie from its second request on
+	browser := bd.AnalyzeRequest(ctx, Request{IP: "203.0.113.2", Path: "/", Cookieless: true})
+	for i := 0; i < 30; i++ {
+		browser = bd.AnalyzeRequest(ctx, Request{IP: "203.0.113.2", Path: "/", ClientID: "browser"})
+	}
+	if hasSignal(browser, "no_client_cookie") {
+		t.Errorf("browser signals %v, want no no_client_cookie", browser.Signals)
+	}
+}
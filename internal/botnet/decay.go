@@ -0,0 +1,38 @@
+package botnet
+
+import (
+	"math"
+	"time"
+)
+
+// defaultDecayFraction sets the default half-life of decayed counts as a
+// fraction of the analysis window: a quarter leaves a sixteenth of a
+// client's old requests counting a window later
+const defaultDecayFraction = 4
+
+// SetDecayHalfLife sets the half-life of the request counts indicators are
+// judged on. Cumulative counts would leave a client that once tripped an
+// indicator suspicious for as long as it is tracked; decayed ones measure
+// its recent rate instead. 0 restores the default of a quarter of the
+// analysis window.
+func (bd *BotnetDetector) SetDecayHalfLife(halfLife time.Duration) {
+	if halfLife <= 0 {
+		halfLife = bd.analysisWindow / defaultDecayFraction
+	}
+	bd.configure(func(cfg *settings) {
+		cfg.decayHalfLife = halfLife
+	})
+}
+
+// decay ages a behavior's decayed counts to now; its shard's lock must be
+// held
+func (bd *BotnetDetector) decay(behavior *IPBehavior, now time.Time) {
+	if !behavior.decayedAt.IsZero() {
+		if elapsed := now.Sub(behavior.decayedAt); elapsed > 0 {
+			factor := math.Exp2(-float64(elapsed) / float64(bd.config().decayHalfLife))
+			behavior.RecentRequests *= factor
+			behavior.RecentCookieless *= factor
+		}
+	}
+	behavior.decayedAt = now
+}
@@ -0,0 +1,40 @@
+package botnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOldBehaviorAgesOut(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	request := Request{IP: "192.0.2.30", UserAgent: "agent", Path: "/"}
+
+	var analysis *BotnetAnalysis
+	for i := 0; i < 60; i++ {
+		analysis = bd.AnalyzeRequest(context.Background(), request)
+	}
+	if !hasSignal(analysis, "high_request_frequency") {
+		t.Fatalf("signals %v, want high_request_frequency", analysis.Signals)
+	}
+
+	// The client goes quiet for an analysis window
+	s := bd.shardFor(request.IP)
+	s.mu.Lock()
+	behavior := s.requestPatterns[request.IP]
+	behavior.decayedAt = behavior.decayedAt.Add(-time.Minute)
+	behavior.LastSeen = behavior.LastSeen.Add(-time.Minute)
+	s.mu.Unlock()
+
+	analysis = bd.AnalyzeRequest(context.Background(), request)
+	e := bd.Explain(request.IP)
+	if hasSignal(analysis, "high_request_frequency") || hasSignal(analysis, "no_javascript") {
+		t.Errorf("signals after a quiet window %v", analysis.Signals)
+	}
+	if e.Behavior.RequestCount != 61 || e.Behavior.RecentRequests > 60.0/16+1.01 {
+		t.Errorf("requests %d, recent %.2f", e.Behavior.RequestCount, e.Behavior.RecentRequests)
+	}
+	if len(e.Behavior.RequestIntervals) != 0 {
+		t.Errorf("%d intervals kept from before the quiet window", len(e.Behavior.RequestIntervals))
+	}
+}
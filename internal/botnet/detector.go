@@ -0,0 +1,298 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BuiltinDetector is the name of the behavioral, network, timing,
+// geographic and TLS fingerprint analysis of BotnetDetector
+const BuiltinDetector = "builtin"
+
+// Request is what a detector is told about a request. Query is the raw
+// query string. TLSFingerprint identifies the client's TLS stack, empty
+// when the request did not come in over TLS terminated here, and
+// HeaderFingerprint its HTTP stack by header order and casing, empty unless
+// it came in over plaintext HTTP/1. Secure is set for requests made over
+// HTTPS. Host and Referer are the request's headers of those names.
+type Request struct {
+	IP                string
+	UserAgent         string
+	Host              string
+	Path              string
+	Query             string
+	Referer           string
+	TLSFingerprint    string
+	HeaderFingerprint string
+	ResponseTime      time.Duration
+	Secure            bool
+
+	// ClientID is the ID from the client's tracking cookie, if it returned
+	// one; Cookieless is set when tracking cookies are issued but the
+	// request carried none
+	ClientID   string
+	Cookieless bool
+
+	// Identity is an opaque key for the session or bearer token the
+	// request authenticated with, if any
+	Identity string
+
+	// Header is the request's headers, matched against bad bot signatures
+	Header http.Header
+
+	// Weight is how many requests this one stands for, when the client's
+	// others were answered from its latest analysis by a Sampler; 0 counts
+	// as 1
+	Weight int64
+
+	// Content negotiation headers
+	Accept         string
+	AcceptLanguage string
+	AcceptEncoding string
+}
+
+// requests returns how many requests req stands for
+func (req Request) requests() int64 {
+	if req.Weight < 1 {
+		return 1
+	}
+	return req.Weight
+}
+
+// Detector scores requests for bot activity. Implementations must be safe
+// for concurrent use; the analysis they return is theirs to fill in, and
+// IsBotnet is decided again when several detectors are combined.
+type Detector interface {
+	Name() string
+	AnalyzeRequest(ctx context.Context, req Request) *BotnetAnalysis
+}
+
+// Score is one detector's confidence in a combined analysis
+type Score struct {
+	Detector   string  `json:"detector"`
+	Confidence float64 `json:"confidence"`
+	Weight     float64 `json:"weight"`
+}
+
+// Aggregator combines the scores of several detectors into one confidence
+// between 0 and 1
+type Aggregator interface {
+	Aggregate(scores []Score) float64
+}
+
+// AggregatorFunc adapts a function to an Aggregator
+type AggregatorFunc func(scores []Score) float64
+
+// Aggregate calls f
+func (f AggregatorFunc) Aggregate(scores []Score) float64 {
+	return f(scores)
+}
+
+// Built-in aggregators
+var (
+	// MaxAggregator takes the highest weighted confidence, so any detector
+	// can flag a bot on its own
+	MaxAggregator = AggregatorFunc(func(scores []Score) float64 {
+		combined := 0.0
+		for _, s := range scores {
+			combined = math.Max(combined, s.Confidence*s.Weight)
+		}
+		return math.Min(combined, 1)
+	})
+
+	// MeanAggregator takes the weighted mean, so detectors must broadly
+	// agree
+	MeanAggregator = AggregatorFunc(func(scores []Score) float64 {
+		var total, weights float64
+		for _, s := range scores {
+			total += s.Confidence * s.Weight
+			weights += s.Weight
+		}
+		if weights == 0 {
+			return 0
+		}
+		return math.Min(total/weights, 1)
+	})
+
+	// AnyAggregator treats the weighted confidences as independent
+	// evidence, 1 - Π(1 - weight × confidence), so signals that are weak
+	// alone add up
+	AnyAggregator = AggregatorFunc(func(scores []Score) float64 {
+		clean := 1.0
+		for _, s := range scores {
+			clean *= 1 - math.Min(s.Confidence*s.Weight, 1)
+		}
+		return 1 - clean
+	})
+)
+
+// ParseAggregator returns the built-in aggregator of the given name: max
+// (the default), mean or any
+func ParseAggregator(name string) (Aggregator, error) {
+	switch name {
+	case "", "max":
+		return MaxAggregator, nil
+	case "mean":
+		return MeanAggregator, nil
+	case "any":
+		return AnyAggregator, nil
+	}
+	return nil, fmt.Errorf("unknown aggregator %q", name)
+}
+
+// registered is a detector and its weight in the ensemble
+type registered struct {
+	detector Detector
+	weight   float64
+}
+
+// Ensemble runs several detectors on each request and combines their
+// scores. It is itself a Detector. A single detector at weight 1 has its
+// analysis returned unchanged.
+type Ensemble struct {
+	aggregator Aggregator
+	detectors  atomic.Value // []registered, replaced on change
+	weights    map[string]float64
+	threshold  float64
+	mu         sync.Mutex
+}
+
+// NewEnsemble creates an ensemble combining scores with aggregator and
+// reporting a bot at threshold
+func NewEnsemble(aggregator Aggregator, threshold float64) *Ensemble {
+	e := &Ensemble{
+		aggregator: aggregator,
+		weights:    make(map[string]float64),
+		threshold:  threshold,
+	}
+	e.detectors.Store([]registered{})
+	return e
+}
+
+// Name returns "ensemble"
+func (e *Ensemble) Name() string {
+	return "ensemble"
+}
+
+// Register adds a detector with the given weight, unless a weight was set
+// for its name with SetWeight
+func (e *Ensemble) Register(detector Detector, weight float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := e.list()
+	for _, r := range current {
+		if r.detector.Name() == detector.Name() {
+			return fmt.Errorf("detector already registered: %s", detector.Name())
+		}
+	}
+	if w, set := e.weights[detector.Name()]; set {
+		weight = w
+	}
+	e.detectors.Store(append(append([]registered{}, current...), registered{detector: detector, weight: weight}))
+	return nil
+}
+
+// Unregister removes the named detector
+func (e *Ensemble) Unregister(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := e.list()
+	kept := make([]registered, 0, len(current))
+	for _, r := range current {
+		if r.detector.Name() != name {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(current) {
+		return fmt.Errorf("detector not found: %s", name)
+	}
+	e.detectors.Store(kept)
+	return nil
+}
+
+// SetWeight sets the weight of the named detector, now or once it is
+// registered. A weight of 0 keeps the detector running without letting it
+// count.
+func (e *Ensemble) SetWeight(name string, weight float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.weights[name] = weight
+	current := e.list()
+	updated := make([]registered, len(current))
+	for i, r := range current {
+		if r.detector.Name() == name {
+			r.weight = weight
+		}
+		updated[i] = r
+	}
+	e.detectors.Store(updated)
+}
+
+// SetThreshold sets the combined confidence at which a client is reported
+// as a bot
+func (e *Ensemble) SetThreshold(threshold float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.threshold = threshold
+}
+
+// Detectors returns the names and weights of the registered detectors
+func (e *Ensemble) Detectors() map[string]float64 {
+	current := e.list()
+	weights := make(map[string]float64, len(current))
+	for _, r := range current {
+		weights[r.detector.Name()] = r.weight
+	}
+	return weights
+}
+
+func (e *Ensemble) list() []registered {
+	return e.detectors.Load().([]registered)
+}
+
+// AnalyzeRequest runs every detector and combines their analyses: the
+// aggregator decides the confidence, and indicators and risk scores add up
+func (e *Ensemble) AnalyzeRequest(ctx context.Context, req Request) *BotnetAnalysis {
+	current := e.list()
+	if len(current) == 1 && current[0].weight == 1 {
+		return current[0].detector.AnalyzeRequest(ctx, req)
+	}
+
+	combined := &BotnetAnalysis{
+		IP:         req.IP,
+		Timestamp:  time.Now(),
+		Indicators: []string{},
+	}
+	scores := make([]Score, 0, len(current))
+	for _, r := range current {
+		analysis := r.detector.AnalyzeRequest(ctx, req)
+		if analysis == nil {
+			continue
+		}
+		if analysis.Network != "" {
+			combined.Network = analysis.Network
+		}
+		combined.Indicators = append(combined.Indicators, analysis.Indicators...)
+		combined.Signals = append(combined.Signals, analysis.Signals...)
+		combined.RiskScore += analysis.RiskScore
+		scores = append(scores, Score{Detector: r.detector.Name(), Confidence: analysis.Confidence, Weight: r.weight})
+	}
+
+	e.mu.Lock()
+	threshold := e.threshold
+	e.mu.Unlock()
+
+	combined.Confidence = e.aggregator.Aggregate(scores)
+	combined.IsBotnet = combined.Confidence >= threshold
+	combined.Scores = scores
+	return combined
+}
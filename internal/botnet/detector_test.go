@@ -0,0 +1,75 @@
+package botnet
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// fixedDetector reports the same confidence for every request
+type fixedDetector struct {
+	name       string
+	confidence float64
+}
+
+func (d fixedDetector) Name() string { return d.name }
+
+func (d fixedDetector) AnalyzeRequest(ctx context.Context, req Request) *BotnetAnalysis {
+	return &BotnetAnalysis{IP: req.IP, Confidence: d.confidence, Indicators: []string{d.name}, RiskScore: 10}
+}
+
+func TestEnsembleAggregates(t *testing.T) {
+	tests := []struct {
+		aggregator string
+		confidence float64
+		isBotnet   bool
+	}{
+		{"max", 0.6, false},
+		{"mean", 0.45, false},
+		{"any", 0.72, true},
+	}
+
+	for _, tt := range tests {
+		aggregator, err := ParseAggregator(tt.aggregator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := NewEnsemble(aggregator, 0.7)
+		e.Register(fixedDetector{"timing", 0.6}, 1)
+		e.Register(fixedDetector{"geo", 0.3}, 1)
+
+		analysis := e.AnalyzeRequest(context.Background(), Request{IP: "10.0.0.1"})
+		if math.Abs(analysis.Confidence-tt.confidence) > 1e-9 || analysis.IsBotnet != tt.isBotnet {
+			t.Errorf("%s: confidence %v botnet %v, want %v %v", tt.aggregator, analysis.Confidence, analysis.IsBotnet, tt.confidence, tt.isBotnet)
+		}
+		if len(analysis.Indicators) != 2 || analysis.RiskScore != 20 || len(analysis.Scores) != 2 {
+			t.Errorf("%s: indicators and risk not combined: %+v", tt.aggregator, analysis)
+		}
+	}
+}
+
+func TestEnsembleWeights(t *testing.T) {
+	e := NewEnsemble(MaxAggregator, 0.5)
+	e.SetWeight("geo", 0)
+	e.Register(fixedDetector{"timing", 0.4}, 1)
+	e.Register(fixedDetector{"geo", 0.9}, 1)
+	if err := e.Register(fixedDetector{"geo", 0.1}, 1); err == nil {
+		t.Error("registered a detector twice")
+	}
+
+	if analysis := e.AnalyzeRequest(context.Background(), Request{}); analysis.IsBotnet {
+		t.Errorf("zero-weight detector counted: %+v", analysis)
+	}
+	e.SetWeight("geo", 1)
+	if analysis := e.AnalyzeRequest(context.Background(), Request{}); !analysis.IsBotnet {
+		t.Errorf("reweighted detector ignored: %+v", analysis)
+	}
+
+	if err := e.Unregister("geo"); err != nil {
+		t.Fatal(err)
+	}
+	// A lone detector's analysis passes through untouched
+	if analysis := e.AnalyzeRequest(context.Background(), Request{}); analysis.Scores != nil || analysis.Confidence != 0.4 {
+		t.Errorf("single detector analysis rewritten: %+v", analysis)
+	}
+}
@@ -0,0 +1,162 @@
+package botnet
+
+import (
+	"math"
+	"net/url"
+	"strings"
+)
+
+// urlSamples is how many of a client's latest URLs are compared for
+// randomization
+const urlSamples = 16
+
+// randomThreshold is the share of a client's latest URLs, or paths, that
+// must be distinct for them to count as randomized
+const randomThreshold = 0.9
+
+// urlStats tracks how varied and how random a client's latest URLs are.
+// HTTP floods meant to bypass caches make every URL unique by adding a
+// random query string (?r=8f3a9c1b) or path segment.
+type urlStats struct {
+	urls, paths  [urlSamples]uint32 // hashes of the latest URLs and their paths
+	next         int
+	full         bool
+	queryEntropy float64 // moving averages of tokenEntropy
+	pathEntropy  float64
+}
+
+// observeURL records a request's URL in behavior's URL statistics
+func (bd *BotnetDetector) observeURL(behavior *IPBehavior, path, query string) {
+	stats := behavior.urls
+	if stats == nil {
+		stats = &urlStats{}
+		behavior.urls = stats
+	}
+
+	stats.urls[stats.next] = hashString(hashString(2166136261, path), "?"+query)
+	stats.paths[stats.next] = hashString(2166136261, path)
+	stats.next = (stats.next + 1) % urlSamples
+	if stats.next == 0 {
+		stats.full = true
+	}
+
+	const smoothing = 0.2
+	stats.queryEntropy += smoothing * (queryEntropy(query) - stats.queryEntropy)
+	segment := path[strings.LastIndexByte(path, '/')+1:]
+	stats.pathEntropy += smoothing * (tokenEntropy(segment) - stats.pathEntropy)
+}
+
+// analyzeURLs raises indicators for randomized query strings, on paths the
+// client keeps requesting, and for randomized paths
+func (bd *BotnetDetector) analyzeURLs(behavior *IPBehavior, analysis *BotnetAnalysis) {
+	stats := behavior.urls
+	if stats == nil || !stats.full {
+		return
+	}
+
+	uniqueURLs := distinct(stats.urls[:])
+	uniquePaths := distinct(stats.paths[:])
+	if uniqueURLs >= randomThreshold && uniquePaths <= 0.5 && stats.queryEntropy >= bd.threshold("random_query") {
+		bd.addIndicator(analysis, "random_query", "Randomized cache-busting query strings")
+	}
+	if uniquePaths >= randomThreshold && stats.pathEntropy >= bd.threshold("random_paths") {
+		bd.addIndicator(analysis, "random_paths", "Randomized cache-busting paths")
+	}
+}
+
+// queryEntropy returns the entropy of a query string's values taken
+// together, as parameter names repeat between requests
+func queryEntropy(query string) float64 {
+	if query == "" {
+		return 0
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return tokenEntropy(query)
+	}
+	var b strings.Builder
+	for _, vs := range values {
+		for _, v := range vs {
+			b.WriteString(v)
+			b.WriteByte('&')
+		}
+	}
+	if b.Len() == len(values) {
+		// Bare keys such as ?8f3a9c1b carry the randomness themselves
+		for k := range values {
+			b.WriteString(k)
+			b.WriteByte('&')
+		}
+	}
+	return tokenEntropy(b.String())
+}
+
+// minTokenLength is the shortest alphanumeric run taken for a random token
+const minTokenLength = 6
+
+// tokenEntropy returns the Shannon entropy of the random-looking tokens in
+// s: runs of at least minTokenLength letters and digits that mix both, as
+// in 8f3a9c1b or a UUID. Words, slugs, dates and counters have as much
+// entropy per character as random hex, but no such tokens, so they score 0.
+func tokenEntropy(s string) float64 {
+	var tokens strings.Builder
+	start := 0
+	letters, digits := false, false
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			switch {
+			case c >= '0' && c <= '9':
+				digits = true
+				continue
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+				letters = true
+				continue
+			}
+		}
+		if letters && digits && i-start >= minTokenLength {
+			tokens.WriteString(s[start:i])
+		}
+		start = i + 1
+		letters, digits = false, false
+	}
+	return shannonEntropy(tokens.String())
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	n := float64(len(s))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// distinct returns the share of distinct values in hashes
+func distinct(hashes []uint32) float64 {
+	seen := make(map[uint32]struct{}, len(hashes))
+	for _, h := range hashes {
+		seen[h] = struct{}{}
+	}
+	return float64(len(seen)) / float64(len(hashes))
+}
+
+// hashString folds s into an FNV-1a hash
+func hashString(hash uint32, s string) uint32 {
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= 16777619
+	}
+	return hash
+}
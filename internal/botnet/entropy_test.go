@@ -0,0 +1,63 @@
+package botnet
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestURLRandomization(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name      string
+		url       func(i int) (path, query string)
+		indicator string
+	}{
+		{"browsing", func(i int) (string, string) { return fmt.Sprintf("/products/%d", i%4), "" }, ""},
+		{"paging", func(i int) (string, string) { return "/search", fmt.Sprintf("q=shoes&page=%d", i) }, ""},
+		{"articles", func(i int) (string, string) {
+			return fmt.Sprintf("/blog/2024-10-%02d-release-notes-%d.html", i%28+1, i), ""
+		}, ""},
+		{"cache-busting query", func(i int) (string, string) {
+			return "/", fmt.Sprintf("r=%016x", rng.Uint64())
+		}, "random_query"},
+		{"cache-busting path", func(i int) (string, string) {
+			return fmt.Sprintf("/static/%016x", rng.Uint64()), ""
+		}, "random_paths"},
+	}
+
+	bd := NewBotnetDetector(0.8, time.Minute)
+	for _, tt := range tests {
+		behavior := &IPBehavior{}
+		for i := 0; i < 2*urlSamples; i++ {
+			path, query := tt.url(i)
+			bd.observeURL(behavior, path, query)
+		}
+		analysis := &BotnetAnalysis{}
+		bd.analyzeURLs(behavior, analysis)
+
+		var got string
+		if len(analysis.Signals) > 0 {
+			got = analysis.Signals[0]
+		}
+		if got != tt.indicator || len(analysis.Signals) > 1 {
+			t.Errorf("%s: signals %v, want %q", tt.name, analysis.Signals, tt.indicator)
+		}
+	}
+}
+
+func TestShannonEntropy(t *testing.T) {
+	if e := shannonEntropy("aaaa"); e != 0 {
+		t.Errorf("entropy of repeated character = %v, want 0", e)
+	}
+	if e := shannonEntropy("0123456789abcdef"); e != 4 {
+		t.Errorf("entropy of 16 distinct characters = %v, want 4", e)
+	}
+	if e := tokenEntropy("how-to-bake-bread-2024.html"); e != 0 {
+		t.Errorf("entropy of a slug = %v, want 0", e)
+	}
+	if e := tokenEntropy("v=0123456789abcdef"); e != 4 {
+		t.Errorf("entropy of a token = %v, want 4", e)
+	}
+}
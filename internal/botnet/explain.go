@@ -0,0 +1,94 @@
+package botnet
+
+import "time"
+
+// Explanation is what the detector knows of a client and why it was, or
+// was not, judged a bot
+type Explanation struct {
+	IP string `json:"ip"`
+	// Behavior is the client's tracked behavior, and Network that of its
+	// IPv6 network when clients are grouped; nil when not tracked
+	Behavior *IPBehavior `json:"behavior,omitempty"`
+	Network  *IPBehavior `json:"network,omitempty"`
+	// Analysis is the detector's analysis of the client's latest request,
+	// with its risk score and the indicators it raised
+	Analysis        *BotnetAnalysis `json:"analysis,omitempty"`
+	Recommendations []string        `json:"recommendations"`
+}
+
+// Explain returns the tracked behavior of ip and the latest analysis of
+// its requests
+func (bd *BotnetDetector) Explain(ip string) Explanation {
+	explanation := Explanation{IP: ip, Recommendations: []string{}}
+
+	clientShard := bd.shardFor(ip)
+	clientShard.mu.Lock()
+	if behavior, exists := clientShard.requestPatterns[ip]; exists {
+		explanation.Behavior = behavior.snapshot()
+		if behavior.lastAnalysis != nil {
+			analysis := *behavior.lastAnalysis
+			explanation.Analysis = &analysis
+		}
+	}
+	clientShard.mu.Unlock()
+
+	if network := bd.aggregateNetwork(ip); network != "" {
+		networkShard := bd.shardFor(network)
+		networkShard.mu.Lock()
+		if behavior, exists := networkShard.networkPatterns[network]; exists {
+			explanation.Network = behavior.snapshot()
+		}
+		networkShard.mu.Unlock()
+	}
+
+	if explanation.Analysis != nil {
+		if recommendations := explanation.Analysis.GetMitigationRecommendations(); recommendations != nil {
+			explanation.Recommendations = recommendations
+		}
+	}
+	return explanation
+}
+
+// snapshot copies the exported state of a behavior; its shard's lock must
+// be held
+func (b *IPBehavior) snapshot() *IPBehavior {
+	c := &IPBehavior{
+		IP:                 b.IP,
+		RequestCount:       b.RequestCount,
+		FirstSeen:          b.FirstSeen,
+		LastSeen:           b.LastSeen,
+		UserAgents:         copyCounts(b.UserAgents),
+		RequestPaths:       copyCounts(b.RequestPaths),
+		ResponseTimes:      append([]time.Duration(nil), b.ResponseTimes...),
+		RequestIntervals:   append([]time.Duration(nil), b.RequestIntervals...),
+		SuspiciousScore:    b.SuspiciousScore,
+		LastBurst:          b.LastBurst,
+		LastSlowRequests:   b.LastSlowRequests,
+		AutomationFlags:    append([]string(nil), b.AutomationFlags...),
+		LastTelemetry:      b.LastTelemetry,
+		CookielessRequests: b.CookielessRequests,
+		RecentRequests:     b.RecentRequests,
+		RecentCookieless:   b.RecentCookieless,
+		HasJavascript:      b.HasJavascript,
+		HasCSS:             b.HasCSS,
+		HasImages:          b.HasImages,
+		HasFavicon:         b.HasFavicon,
+		HasRobotsTxt:       b.HasRobotsTxt,
+		HasSitemap:         b.HasSitemap,
+		NavigationScore:    b.NavigationScore,
+		NotFoundPaths:      b.NotFoundPaths,
+		ClientErrors:       b.ClientErrors,
+	}
+	if b.Addresses != nil {
+		c.Addresses = copyCounts(b.Addresses)
+	}
+	return c
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	c := make(map[string]int, len(counts))
+	for k, v := range counts {
+		c[k] = v
+	}
+	return c
+}
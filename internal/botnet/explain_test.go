@@ -0,0 +1,36 @@
+package botnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	if e := bd.Explain("192.0.2.20"); e.Behavior != nil || e.Analysis != nil {
+		t.Fatalf("untracked client explained: %+v", e)
+	}
+
+	var last *BotnetAnalysis
+	for i := 0; i < 60; i++ {
+		last = bd.AnalyzeRequest(context.Background(), Request{IP: "192.0.2.20", UserAgent: "curl/8.0", Path: "/api/items"})
+	}
+
+	e := bd.Explain("192.0.2.20")
+	if e.Behavior == nil || e.Behavior.RequestCount != 60 || e.Behavior.UserAgents["curl/8.0"] != 60 {
+		t.Errorf("behavior = %+v", e.Behavior)
+	}
+	if e.Analysis == nil || e.Analysis.RiskScore != last.RiskScore || len(e.Analysis.Signals) != len(last.Signals) {
+		t.Fatalf("analysis = %+v, want the latest %+v", e.Analysis, last)
+	}
+	if !hasSignal(e.Analysis, "high_request_frequency") || len(e.Recommendations) == 0 {
+		t.Errorf("signals %v, recommendations %v", e.Analysis.Signals, e.Recommendations)
+	}
+
+	// The explanation is a copy
+	e.Behavior.UserAgents["curl/8.0"] = 0
+	if bd.Explain("192.0.2.20").Behavior.UserAgents["curl/8.0"] != 60 {
+		t.Error("changing the explanation changed the tracked behavior")
+	}
+}
@@ -0,0 +1,126 @@
+package botnet
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ddos-protection/internal/intern"
+)
+
+// rareFingerprintShare is the share of clients below which a shared
+// fingerprint is rare, unlike those of common browsers
+const rareFingerprintShare = 0.05
+
+// headerPrefix sets header fingerprints apart from TLS fingerprints where
+// both are tracked together
+const headerPrefix = "headers:"
+
+// fingerprintUse tracks the clients presenting a TLS or header
+// fingerprint. Its counters are updated atomically, so a fingerprint shared
+// by a whole botnet does not become a lock every request waits on.
+type fingerprintUse struct {
+	requests  int64
+	ips       int64
+	lastSeen  int64 // Unix nanoseconds
+	firstSeen time.Time
+}
+
+// FingerprintStats describes the clients presenting a TLS or header
+// fingerprint
+type FingerprintStats struct {
+	Fingerprint string    `json:"fingerprint"`
+	Requests    int64     `json:"requests"`
+	IPs         int       `json:"ips"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// trackFingerprint records a fingerprint a client presented (header
+// fingerprints carry headerPrefix) and reports whether, by the indicator
+// rule for its kind, many clients share a fingerprint that is rare or only
+// just appeared, the mark of one bot toolkit behind many addresses. Every
+// fingerprint is new right after startup, so appearing counts only after
+// the first analysis window. The client's shard lock must be held.
+func (bd *BotnetDetector) trackFingerprint(behavior *IPBehavior, fingerprint, rule string) bool {
+	// Fingerprints past the dictionary limit are lumped together
+	if strings.TrimPrefix(fingerprint, headerPrefix) == intern.Other {
+		return false
+	}
+
+	now := time.Now()
+	value, ok := bd.fingerprints.Load(fingerprint)
+	if !ok {
+		var loaded bool
+		value, loaded = bd.fingerprints.LoadOrStore(fingerprint, &fingerprintUse{firstSeen: now})
+		if !loaded {
+			atomic.AddInt64(&bd.fingerprintCount, 1)
+		}
+	}
+	use := value.(*fingerprintUse)
+	atomic.AddInt64(&use.requests, 1)
+	atomic.StoreInt64(&use.lastSeen, now.UnixNano())
+
+	// Count the client once per use, so a use recreated after expiring
+	// counts it again
+	if behavior.fingerprints == nil {
+		behavior.fingerprints = make(map[string]*fingerprintUse)
+	}
+	ips := atomic.LoadInt64(&use.ips)
+	if behavior.fingerprints[fingerprint] != use && len(behavior.fingerprints) < bd.config().limits.PerIP {
+		behavior.fingerprints[fingerprint] = use
+		ips = atomic.AddInt64(&use.ips, 1)
+	}
+
+	// Clients must share a fingerprint before it counts against them
+	if float64(ips) < bd.threshold(rule) {
+		return false
+	}
+	share := float64(ips) / float64(atomic.LoadInt64(&bd.clients))
+	appeared := use.firstSeen.Sub(bd.started) > bd.analysisWindow && now.Sub(use.firstSeen) < bd.analysisWindow
+	return share < rareFingerprintShare || appeared
+}
+
+// Fingerprints returns the TLS fingerprints shared by the most clients,
+// at most limit of them
+func (bd *BotnetDetector) Fingerprints(limit int) []FingerprintStats {
+	return bd.fingerprintStats(false, limit)
+}
+
+// HeaderFingerprints returns the header fingerprints shared by the most
+// clients, at most limit of them
+func (bd *BotnetDetector) HeaderFingerprints(limit int) []FingerprintStats {
+	return bd.fingerprintStats(true, limit)
+}
+
+// fingerprintStats returns the TLS or header fingerprints shared by the
+// most clients
+func (bd *BotnetDetector) fingerprintStats(headers bool, limit int) []FingerprintStats {
+	var stats []FingerprintStats
+	bd.fingerprints.Range(func(key, value interface{}) bool {
+		fingerprint := key.(string)
+		if strings.HasPrefix(fingerprint, headerPrefix) != headers {
+			return true
+		}
+		use := value.(*fingerprintUse)
+		stats = append(stats, FingerprintStats{
+			Fingerprint: strings.TrimPrefix(fingerprint, headerPrefix),
+			Requests:    atomic.LoadInt64(&use.requests),
+			IPs:         int(atomic.LoadInt64(&use.ips)),
+			FirstSeen:   use.firstSeen,
+			LastSeen:    time.Unix(0, atomic.LoadInt64(&use.lastSeen)),
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].IPs != stats[j].IPs {
+			return stats[i].IPs > stats[j].IPs
+		}
+		return stats[i].Requests > stats[j].Requests
+	})
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
@@ -0,0 +1,56 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSharedHeaderFingerprint(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+	flagged := func(a *BotnetAnalysis) bool {
+		for _, signal := range a.Signals {
+			if signal == "shared_header_fingerprint" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < 600; i++ {
+		a := bd.AnalyzeRequest(ctx, Request{
+			IP:                fmt.Sprintf("10.0.%d.%d", i/250, i%250),
+			Path:              "/",
+			HeaderFingerprint: "06_3f9a1c2b4d5e",
+			TLSFingerprint:    "t13d1516h2_8daaf6152771_02713d6af862",
+		})
+		if flagged(a) {
+			t.Fatal("common browser header order flagged")
+		}
+	}
+
+	var last *BotnetAnalysis
+	for i := 0; i < 25; i++ {
+		last = bd.AnalyzeRequest(ctx, Request{
+			IP:                fmt.Sprintf("192.0.2.%d", i),
+			Path:              "/",
+			HeaderFingerprint: "03_a1b2c3d4e5f6",
+		})
+		if i < 19 && flagged(last) {
+			t.Fatalf("flagged with only %d clients sharing the header order", i+1)
+		}
+	}
+	if !flagged(last) {
+		t.Error("rare header order shared by 25 clients not flagged")
+	}
+
+	headers := bd.HeaderFingerprints(0)
+	if len(headers) != 2 || headers[0].Fingerprint != "06_3f9a1c2b4d5e" || headers[1].IPs != 25 {
+		t.Errorf("header fingerprints = %+v", headers)
+	}
+	if tls := bd.Fingerprints(0); len(tls) != 1 || tls[0].IPs != 600 {
+		t.Errorf("TLS fingerprints = %+v", tls)
+	}
+}
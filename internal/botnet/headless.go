@@ -0,0 +1,103 @@
+package botnet
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chrome sends client hints over HTTPS since 89 and Sec-Fetch headers
+// since 80. Headless builds driven by Puppeteer or Playwright often have
+// their user agent replaced with a desktop Chrome's but not these headers.
+const (
+	clientHintsSince = 89
+	secFetchSince    = 80
+)
+
+// telemetryFlags are the signs of automation the challenge page reports:
+// navigator.webdriver set, a HeadlessChrome user agent in JavaScript, no
+// navigator.languages, no window.chrome in Chrome, a window of zero size,
+// and globals left by automation drivers
+var telemetryFlags = map[string]bool{
+	"webdriver":           true,
+	"headless_user_agent": true,
+	"no_languages":        true,
+	"no_chrome_object":    true,
+	"zero_window":         true,
+	"automation_globals":  true,
+}
+
+// ParseTelemetry returns the known flags in a comma-separated telemetry
+// report, ignoring the rest
+func ParseTelemetry(report string) []string {
+	var flags []string
+	seen := make(map[string]bool)
+	for _, flag := range strings.Split(report, ",") {
+		flag = strings.TrimSpace(flag)
+		if telemetryFlags[flag] && !seen[flag] {
+			seen[flag] = true
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// RecordTelemetry notes the signs of automation the challenge page
+// reported for an IP, raising the automation_telemetry indicator on its
+// requests for as long as it is tracked
+func (bd *BotnetDetector) RecordTelemetry(ip string, flags []string) {
+	if len(flags) == 0 {
+		return
+	}
+	bd.mark(ip, func(behavior *IPBehavior, now time.Time) {
+		behavior.AutomationFlags = append([]string(nil), flags...)
+		behavior.LastTelemetry = now
+	})
+}
+
+// headlessUserAgent reports whether a request announces a headless
+// browser in its user agent or client hints
+func headlessUserAgent(req Request) bool {
+	if strings.Contains(req.UserAgent, "HeadlessChrome") || strings.Contains(req.UserAgent, "PhantomJS") {
+		return true
+	}
+	return req.Header != nil && strings.Contains(req.Header.Get("Sec-CH-UA"), "HeadlessChrome")
+}
+
+// headlessHeaders returns what is missing from the headers of a request
+// claiming to come from desktop or Android Chrome over HTTPS, or "" when
+// nothing is or it makes no such claim
+func headlessHeaders(req Request) string {
+	if !req.Secure || req.Header == nil || !strings.HasPrefix(req.UserAgent, "Mozilla/5.0") || strings.Contains(req.UserAgent, "CriOS/") {
+		return ""
+	}
+	m := browserVersion.FindStringSubmatch(req.UserAgent)
+	if m == nil || m[1] != "Chrome" {
+		return ""
+	}
+	version, _ := strconv.Atoi(m[2])
+
+	switch {
+	case version >= clientHintsSince && !hasHeader(req.Header, "Sec-CH-UA"):
+		return "Chrome without client hints"
+	case version >= secFetchSince && !hasHeader(req.Header, "Sec-Fetch-Mode"):
+		return "Chrome without Sec-Fetch headers"
+	}
+	return ""
+}
+
+func hasHeader(header http.Header, name string) bool {
+	_, sent := header[http.CanonicalHeaderKey(name)]
+	return sent
+}
+
+// analyzeHeadless raises the indicators of headless browsers
+func (bd *BotnetDetector) analyzeHeadless(req Request, analysis *BotnetAnalysis) {
+	if headlessUserAgent(req) {
+		bd.addIndicator(analysis, "headless_user_agent", "Headless browser user agent")
+	}
+	if missing := headlessHeaders(req); missing != "" {
+		bd.addIndicator(analysis, "headless_headers", "Headers of a headless browser: "+missing)
+	}
+}
@@ -0,0 +1,58 @@
+package botnet
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeadless(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	browser := http.Header{
+		"Sec-Ch-Ua":      {`"Chromium";v="120", "Google Chrome";v="120"`},
+		"Sec-Fetch-Mode": {"navigate"},
+	}
+
+	tests := []struct {
+		name      string
+		req       Request
+		announced bool
+		missing   bool
+	}{
+		{"real browser", Request{UserAgent: chrome, Secure: true, Header: browser}, false, false},
+		{"plain HTTP", Request{UserAgent: chrome, Header: http.Header{}}, false, false},
+		{"old headless", Request{UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", Secure: true, Header: browser}, true, false},
+		{"new headless", Request{UserAgent: chrome, Secure: true, Header: http.Header{
+			"Sec-Ch-Ua":      {`"HeadlessChrome";v="120"`},
+			"Sec-Fetch-Mode": {"navigate"},
+		}}, true, false},
+		{"no client hints", Request{UserAgent: chrome, Secure: true, Header: http.Header{"Sec-Fetch-Mode": {"navigate"}}}, false, true},
+		{"no Sec-Fetch", Request{UserAgent: "Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.0.0 Safari/537.36", Secure: true, Header: http.Header{}}, false, true},
+	}
+	for _, tt := range tests {
+		if got := headlessUserAgent(tt.req); got != tt.announced {
+			t.Errorf("%s: headlessUserAgent = %v", tt.name, got)
+		}
+		if got := headlessHeaders(tt.req); (got != "") != tt.missing {
+			t.Errorf("%s: headlessHeaders = %q", tt.name, got)
+		}
+	}
+}
+
+func TestAutomationTelemetry(t *testing.T) {
+	if flags := ParseTelemetry("webdriver, bogus,zero_window,webdriver"); !reflect.DeepEqual(flags, []string{"webdriver", "zero_window"}) {
+		t.Fatalf("ParseTelemetry = %v", flags)
+	}
+
+	bd := NewBotnetDetector(0.8, time.Minute)
+	req := Request{IP: "192.0.2.60", UserAgent: "agent", Path: "/"}
+	if hasSignal(bd.AnalyzeRequest(context.Background(), req), "automation_telemetry") {
+		t.Fatal("automation_telemetry raised without telemetry")
+	}
+	bd.RecordTelemetry(req.IP, []string{"webdriver"})
+	if analysis := bd.AnalyzeRequest(context.Background(), req); !hasSignal(analysis, "automation_telemetry") {
+		t.Errorf("signals %v, want automation_telemetry", analysis.Signals)
+	}
+}
@@ -0,0 +1,75 @@
+package botnet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"ddos-protection/internal/intern"
+)
+
+// identityPrefix keys the behavior of an authenticated identity apart from
+// addresses, networks and cookies
+const identityPrefix = "identity:"
+
+// analyzeIdentity updates and analyzes the behavior of the session or
+// token a request authenticated with, across every address it comes from.
+// Scraping with one account, or stuffing through one session, spread over
+// rotating addresses stays under each address's thresholds but not the
+// identity's. Indicators the client's own analysis already raised are not
+// counted twice.
+func (bd *BotnetDetector) analyzeIdentity(req Request, userAgent, path string, analysis *BotnetAnalysis) {
+	key := identityPrefix + req.Identity
+	s := bd.shardFor(key)
+	s.mu.Lock()
+	behavior := bd.getOrCreateIdentityBehavior(s, key)
+	bd.updateBehavioralIndicators(behavior, req.Path)
+	bd.observeURL(behavior, req.Path, req.Query)
+	bd.observeNavigation(behavior, req)
+	behavior.Addresses[intern.Key(behavior.Addresses, req.IP, bd.config().limits.PerIP)]++
+	bd.updateIPBehavior(behavior, userAgent, path, req.ResponseTime, req.requests())
+	bd.share(s, behavior, req.requests())
+
+	identity := &BotnetAnalysis{}
+	bd.analyzeBehavior(behavior, identity)
+	if addresses := float64(len(behavior.Addresses)); addresses > bd.threshold("identity_rotating_ips") {
+		bd.addIndicator(identity, "identity_rotating_ips", "Session used from many addresses")
+	}
+	s.mu.Unlock()
+
+	for i, id := range identity.Signals {
+		if !hasSignal(analysis, id) {
+			bd.addIndicator(analysis, id, "Identity: "+identity.Indicators[i])
+		}
+	}
+}
+
+// getOrCreateIdentityBehavior gets or creates the behavior of an identity;
+// its shard's lock must be held
+func (bd *BotnetDetector) getOrCreateIdentityBehavior(s *shard, key string) *IPBehavior {
+	if behavior, exists := s.identityPatterns[key]; exists {
+		return behavior
+	}
+
+	behavior := &IPBehavior{
+		IP:           key,
+		FirstSeen:    time.Now(),
+		LastSeen:     time.Now(),
+		UserAgents:   make(map[string]int),
+		RequestPaths: make(map[string]int),
+		Addresses:    make(map[string]int),
+	}
+	s.identityPatterns[key] = behavior
+	atomic.AddInt64(&bd.identities, 1)
+	bd.capBehaviors(s, "identities", s.identityPatterns, behavior)
+	return behavior
+}
+
+// hasSignal reports whether an analysis raised a signal
+func hasSignal(analysis *BotnetAnalysis, id string) bool {
+	for _, signal := range analysis.Signals {
+		if signal == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIdentityFollowedAcrossRotatingAddresses(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+
+	// One account scrapes through a different proxy for every request, so
+	// no single address sees more than two of them
+	var scraper *BotnetAnalysis
+	for i := 0; i < 60; i++ {
+		ip := fmt.Sprintf("198.51.%d.%d", i%30, i%30+1)
+		scraper = bd.AnalyzeRequest(ctx, Request{IP: ip, UserAgent: "scraper", Path: "/orders", Identity: "token-a"})
+	}
+	if !hasSignal(scraper, "identity_rotating_ips") {
+		t.Errorf("scraper signals %v, want identity_rotating_ips", scraper.Signals)
+	}
+	if !hasSignal(scraper, "high_request_frequency") {
+		t.Errorf("scraper signals %v, want the identity's high_request_frequency", scraper.Signals)
+	}
+
+	// A user moving between home and mobile stays under the threshold
+	var user *BotnetAnalysis
+	for _, ip := range []string{"203.0.113.1", "192.0.2.44", "203.0.113.1"} {
+		user = bd.AnalyzeRequest(ctx, Request{IP: ip, UserAgent: "browser", Path: "/", Identity: "token-b"})
+	}
+	if hasSignal(user, "identity_rotating_ips") {
+		t.Errorf("user signals %v, want no identity_rotating_ips", user.Signals)
+	}
+}
@@ -0,0 +1,127 @@
+package botnet
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// navSamples is how many of a client's latest pages are remembered for
+// following its navigation, and how many it must fetch before it is judged
+const navSamples = 16
+
+// assetExtensions mark the requests a page makes for its assets rather
+// than pages the client navigates to
+var assetExtensions = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+}
+
+// navStats follows how a client moves between pages. A browser enters on
+// a page, loads its assets and follows links, each page naming the one
+// before as its referrer; a flood tool hammers one deep endpoint with no
+// referrer and no assets.
+type navStats struct {
+	pages  [navSamples]uint32 // hashes of the latest pages fetched
+	next   int
+	count  int  // pages fetched, up to navSamples
+	assets bool // assets were fetched since the latest page
+}
+
+// isAsset reports whether a request fetches a page's asset
+func isAsset(p string) bool {
+	return strings.Contains(p, "/static/") || assetExtensions[strings.ToLower(path.Ext(p))]
+}
+
+// observeNavigation scores how plausibly a request continues the client's
+// navigation, averaging it into behavior's NavigationScore. A page scores
+// for naming a page on the same site as its referrer, more so one the
+// client fetched, and for the previous page's assets having been loaded.
+// The first page is an entry and always plausible.
+func (bd *BotnetDetector) observeNavigation(behavior *IPBehavior, req Request) {
+	stats := behavior.navigation
+	if stats == nil {
+		stats = &navStats{assets: true}
+		behavior.navigation = stats
+	}
+	if isAsset(req.Path) {
+		stats.assets = true
+		return
+	}
+
+	step := 1.0
+	if stats.count > 0 {
+		step = 0
+		if referrer := sameSiteReferrer(req); referrer != "" {
+			step = 0.5
+			if stats.fetched(hashString(2166136261, referrer)) {
+				step = 0.6
+			}
+		}
+		if stats.assets {
+			step += 0.4
+		}
+	}
+
+	const smoothing = 0.2
+	if stats.count == 0 {
+		behavior.NavigationScore = step
+	} else {
+		behavior.NavigationScore += smoothing * (step - behavior.NavigationScore)
+	}
+
+	stats.pages[stats.next] = hashString(2166136261, req.Path)
+	stats.next = (stats.next + 1) % navSamples
+	if stats.count < navSamples {
+		stats.count++
+	}
+	stats.assets = false
+}
+
+// fetched reports whether a page hash is among the latest pages fetched
+func (stats *navStats) fetched(hash uint32) bool {
+	for _, page := range stats.pages[:stats.count] {
+		if page == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSiteReferrer returns the path of a request's referrer when it is a
+// page of the same host, or ""
+func sameSiteReferrer(req Request) string {
+	if req.Referer == "" || req.Host == "" {
+		return ""
+	}
+	referrer, err := url.Parse(req.Referer)
+	if err != nil || !strings.EqualFold(referrer.Hostname(), hostname(req.Host)) {
+		return ""
+	}
+	if referrer.Path == "" {
+		return "/"
+	}
+	return referrer.Path
+}
+
+// hostname strips the port from a Host header
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// analyzeNavigation raises an indicator for a client whose pages do not
+// follow one another as a browser's would
+func (bd *BotnetDetector) analyzeNavigation(behavior *IPBehavior, analysis *BotnetAnalysis) {
+	stats := behavior.navigation
+	if stats == nil || stats.count < navSamples {
+		return
+	}
+	if behavior.NavigationScore < bd.threshold("implausible_navigation") {
+		bd.addIndicator(analysis, "implausible_navigation", "Pages fetched without referrers or assets, out of any navigation order")
+	}
+}
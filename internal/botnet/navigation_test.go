@@ -0,0 +1,53 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNavigation(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+
+	// A browser enters on the home page, loads its assets and follows
+	// links, each page naming the previous one
+	previous := ""
+	var browser *BotnetAnalysis
+	for i := 0; i < 20; i++ {
+		page := fmt.Sprintf("/articles/%d", i)
+		if i == 0 {
+			page = "/"
+		}
+		browser = bd.AnalyzeRequest(ctx, Request{IP: "192.0.2.70", Host: "example.com", Path: page, Referer: previous})
+		for _, asset := range []string{"/static/app.js", "/style.css"} {
+			bd.AnalyzeRequest(ctx, Request{IP: "192.0.2.70", Host: "example.com", Path: asset, Referer: "https://example.com" + page})
+		}
+		previous = "https://example.com" + page
+	}
+
+	// A flood tool hammers one deep endpoint with no referrer
+	var flood *BotnetAnalysis
+	for i := 0; i < 20; i++ {
+		flood = bd.AnalyzeRequest(ctx, Request{IP: "192.0.2.71", Host: "example.com", Path: "/api/search"})
+	}
+
+	if hasSignal(browser, "implausible_navigation") {
+		t.Errorf("browser flagged; score %.2f", bd.Explain("192.0.2.70").Behavior.NavigationScore)
+	}
+	if !hasSignal(flood, "implausible_navigation") {
+		t.Errorf("flood not flagged; score %.2f", bd.Explain("192.0.2.71").Behavior.NavigationScore)
+	}
+	if score := bd.Explain("192.0.2.70").Behavior.NavigationScore; score < 0.9 {
+		t.Errorf("browser navigation score %.2f, want at least 0.9", score)
+	}
+
+	// Referrers from another site do not continue a navigation
+	if got := sameSiteReferrer(Request{Host: "example.com:443", Referer: "https://other.example/page"}); got != "" {
+		t.Errorf("cross-site referrer taken as %q", got)
+	}
+	if got := sameSiteReferrer(Request{Host: "example.com:443", Referer: "https://EXAMPLE.com/page"}); got != "/page" {
+		t.Errorf("same-site referrer taken as %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package botnet
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// browserVersion picks the claimed engine and major version out of a user
+// agent. Chrome on iOS and other WebKit wrappers claim Safari's stack.
+var browserVersion = regexp.MustCompile(`(Chrome|Firefox)/(\d+)`)
+
+// Brotli is advertised over HTTPS by Chrome since 51 and Firefox since 44
+var brotliSince = map[string]int{"Chrome": 51, "Firefox": 44}
+
+// negotiationMismatch returns what is wrong with the content negotiation
+// headers of a request for the browser its user agent claims to be, or ""
+// when they are coherent or it does not claim to be a mainstream browser.
+// Naive HTTP libraries that copy a browser's user agent rarely copy the
+// Accept headers browsers always send.
+func negotiationMismatch(req Request) string {
+	if !strings.HasPrefix(req.UserAgent, "Mozilla/5.0") {
+		return ""
+	}
+	browser := "Safari"
+	version := 0
+	if m := browserVersion.FindStringSubmatch(req.UserAgent); m != nil && !strings.Contains(req.UserAgent, "CriOS/") {
+		browser = m[1]
+		version, _ = strconv.Atoi(m[2])
+	} else if !strings.Contains(req.UserAgent, "Safari/") {
+		return ""
+	}
+
+	switch {
+	case req.Accept == "":
+		return browser + " without Accept"
+	case req.AcceptLanguage == "":
+		return browser + " without Accept-Language"
+	case !acceptsEncoding(req.AcceptEncoding, "gzip"):
+		return browser + " without gzip encoding"
+	case req.Secure && version >= brotliSince[browser] && brotliSince[browser] > 0 && !acceptsEncoding(req.AcceptEncoding, "br"):
+		return browser + " over HTTPS without br encoding"
+	}
+	return ""
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header lists coding
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if strings.EqualFold(name, coding) {
+			return true
+		}
+	}
+	return false
+}
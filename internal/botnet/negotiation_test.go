@@ -0,0 +1,35 @@
+package botnet
+
+import "testing"
+
+func TestNegotiationMismatch(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	browser := Request{
+		UserAgent:      chrome,
+		Secure:         true,
+		Accept:         "text/html,application/xhtml+xml,*/*;q=0.8",
+		AcceptLanguage: "en-US,en;q=0.9",
+		AcceptEncoding: "gzip, deflate, br, zstd",
+	}
+
+	tests := []struct {
+		name     string
+		change   func(r *Request)
+		mismatch bool
+	}{
+		{"real browser", func(r *Request) {}, false},
+		{"plain HTTP without br", func(r *Request) { r.Secure = false; r.AcceptEncoding = "gzip, deflate" }, false},
+		{"honest library", func(r *Request) { *r = Request{UserAgent: "python-requests/2.31.0"} }, false},
+		{"no br over HTTPS", func(r *Request) { r.AcceptEncoding = "gzip, deflate" }, true},
+		{"no Accept-Language", func(r *Request) { r.AcceptLanguage = "" }, true},
+		{"library copying the user agent", func(r *Request) { *r = Request{UserAgent: chrome, Accept: "*/*", AcceptEncoding: "identity"} }, true},
+	}
+
+	for _, tt := range tests {
+		req := browser
+		tt.change(&req)
+		if got := negotiationMismatch(req); (got != "") != tt.mismatch {
+			t.Errorf("%s: mismatch %q, want %v", tt.name, got, tt.mismatch)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package botnet
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var sampledCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_botnet_sampling_total",
+	Help: "Requests seen by the botnet sampler, by whether they were analyzed or answered from the client's latest analysis",
+}, []string{"result"})
+
+// Sampling defaults, used for options left at zero
+const (
+	DefaultSamplingRate     = 0.1
+	DefaultSamplingMinRPS   = 10000
+	DefaultSamplingTTL      = 10 * time.Second
+	DefaultSamplingCapacity = 100000
+)
+
+// SamplingOptions configure a Sampler. Rate is the fraction of a client's
+// requests analyzed once requests arrive faster than MinRPS; TTL is how
+// long an analysis answers for the client, and Capacity bounds the clients
+// whose analysis is kept.
+type SamplingOptions struct {
+	Rate     float64
+	MinRPS   int64
+	TTL      time.Duration
+	Capacity int
+}
+
+// sample is the latest analysis of a client and the requests answered
+// from it since
+type sample struct {
+	analysis *BotnetAnalysis
+	skipped  int64
+	expires  time.Time
+}
+
+// sampleShard holds the samples of the clients whose IPs hash to it
+type sampleShard struct {
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+// Sampler is a Detector that bounds the cost of analysis under a flood.
+// While requests arrive no faster than MinRPS, every request is analyzed by
+// the detector it wraps. Beyond that, only one in 1/Rate of each client's
+// requests is; the rest are answered from the client's latest analysis,
+// marked Sampled, and the next analyzed request stands for them in the
+// client's counts through Request.Weight. A client's first request, and
+// any after its analysis expired, is always analyzed.
+type Sampler struct {
+	second   int64 // Unix second being counted
+	count    int64 // requests within it
+	detector Detector
+	every    int64
+	minRPS   int64
+	ttl      time.Duration
+	perShard int
+	shards   [shardCount]*sampleShard
+}
+
+// NewSampler wraps detector
+func NewSampler(detector Detector, opts SamplingOptions) *Sampler {
+	if opts.Rate <= 0 || opts.Rate > 1 {
+		opts.Rate = DefaultSamplingRate
+	}
+	if opts.MinRPS <= 0 {
+		opts.MinRPS = DefaultSamplingMinRPS
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultSamplingTTL
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultSamplingCapacity
+	}
+
+	s := &Sampler{
+		detector: detector,
+		every:    int64(math.Round(1 / opts.Rate)),
+		minRPS:   opts.MinRPS,
+		ttl:      opts.TTL,
+		perShard: perShard(opts.Capacity),
+	}
+	for i := range s.shards {
+		s.shards[i] = &sampleShard{samples: make(map[string]*sample)}
+	}
+	return s
+}
+
+// Name returns the name of the wrapped detector
+func (s *Sampler) Name() string {
+	return s.detector.Name()
+}
+
+// AnalyzeRequest analyzes the request, or answers it from the client's
+// latest analysis when overloaded
+func (s *Sampler) AnalyzeRequest(ctx context.Context, req Request) *BotnetAnalysis {
+	now := time.Now()
+	overloaded := s.countRequest(now) > s.minRPS
+	shard := s.shardFor(req.IP)
+
+	shard.mu.Lock()
+	latest, exists := shard.samples[req.IP]
+	if exists && now.Before(latest.expires) {
+		if overloaded && latest.skipped+1 < s.every {
+			latest.skipped++
+			analysis := latest.analysis.copy()
+			shard.mu.Unlock()
+
+			analysis.Sampled = true
+			sampledCounter.WithLabelValues("cached").Inc()
+			return analysis
+		}
+		req.Weight = req.requests() + latest.skipped
+	}
+	shard.mu.Unlock()
+
+	analysis := s.detector.AnalyzeRequest(ctx, req)
+	sampledCounter.WithLabelValues("analyzed").Inc()
+	if analysis == nil {
+		return nil
+	}
+
+	shard.mu.Lock()
+	if _, exists := shard.samples[req.IP]; !exists && len(shard.samples) >= s.perShard {
+		shard.dropExpired(now)
+	}
+	if _, exists := shard.samples[req.IP]; exists || len(shard.samples) < s.perShard {
+		shard.samples[req.IP] = &sample{analysis: analysis.copy(), expires: now.Add(s.ttl)}
+	}
+	shard.mu.Unlock()
+	return analysis
+}
+
+// countRequest counts a request in the second of now and returns the
+// second's count. A request racing the reset may be lost.
+func (s *Sampler) countRequest(now time.Time) int64 {
+	second := now.Unix()
+	if current := atomic.LoadInt64(&s.second); current != second {
+		if atomic.CompareAndSwapInt64(&s.second, current, second) {
+			atomic.StoreInt64(&s.count, 0)
+		}
+	}
+	return atomic.AddInt64(&s.count, 1)
+}
+
+// Cleanup drops expired analyses
+func (s *Sampler) Cleanup(now time.Time) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.dropExpired(now)
+		shard.mu.Unlock()
+	}
+}
+
+// dropExpired drops the shard's expired analyses; its lock must be held
+func (shard *sampleShard) dropExpired(now time.Time) {
+	for ip, latest := range shard.samples {
+		if !now.Before(latest.expires) {
+			delete(shard.samples, ip)
+		}
+	}
+}
+
+// shardFor returns the shard holding ip, by its FNV-1a hash
+func (s *Sampler) shardFor(ip string) *sampleShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(ip); i++ {
+		hash ^= uint32(ip[i])
+		hash *= 16777619
+	}
+	return s.shards[hash&(shardCount-1)]
+}
+
+// copy returns a copy of the analysis that shares none of its slices
+func (analysis *BotnetAnalysis) copy() *BotnetAnalysis {
+	c := *analysis
+	c.Indicators = append([]string{}, analysis.Indicators...)
+	c.Signals = append([]string(nil), analysis.Signals...)
+	c.Scores = append([]Score(nil), analysis.Scores...)
+	return &c
+}
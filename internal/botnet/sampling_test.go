@@ -0,0 +1,38 @@
+package botnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSamplerUnderLoad(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	sampler := NewSampler(bd, SamplingOptions{Rate: 0.25, MinRPS: 1})
+	ctx := context.Background()
+	req := Request{IP: "192.0.2.40", UserAgent: "agent", Path: "/"}
+
+	// Past MinRPS, one request in four is analyzed; the others are
+	// answered from the latest analysis
+	sampled := 0
+	for i := 0; i < 41; i++ {
+		if sampler.AnalyzeRequest(ctx, req).Sampled {
+			sampled++
+		}
+	}
+	if sampled != 30 {
+		t.Errorf("%d of 41 requests sampled, want 30", sampled)
+	}
+
+	// The analyzed requests stand for the sampled ones
+	if e := bd.Explain(req.IP); e.Behavior == nil || e.Behavior.RequestCount != 41 {
+		t.Errorf("behavior = %+v, want all 41 requests counted", e.Behavior)
+	}
+
+	// Answers are copies
+	a := sampler.AnalyzeRequest(ctx, req)
+	a.Indicators = append(a.Indicators[:0], "changed")
+	if b := sampler.AnalyzeRequest(ctx, req); len(b.Indicators) > 0 && b.Indicators[0] == "changed" {
+		t.Error("changing an answer changed the cached analysis")
+	}
+}
@@ -0,0 +1,71 @@
+package botnet
+
+import (
+	"net/http"
+	"time"
+)
+
+// maxMissingPaths caps the distinct not-found paths remembered per client
+// in an analysis window; a scanner past it is caught already
+const maxMissingPaths = 256
+
+// scanStats counts the error responses a client got in the current
+// analysis window. Directory brute forcing and vulnerability scanners walk
+// wordlists of paths that mostly do not exist, so they collect 404s for
+// many distinct paths; a browser following links rarely gets any.
+type scanStats struct {
+	since     time.Time
+	responses int
+	errors    int                 // 4xx responses
+	missing   map[uint32]struct{} // hashes of the paths answered 404 or 410
+}
+
+// RecordResponse notes the status the upstream answered a request of an
+// IP to path with, raising the scanner indicators on its requests once it
+// collects too many not-found paths or client errors. It reports whether
+// this response made the IP a scanner, so callers can act on it once.
+func (bd *BotnetDetector) RecordResponse(ip, path string, status int) bool {
+	var crossed bool
+	threshold := int(bd.threshold("scanner_not_found"))
+	bd.mark(ip, func(behavior *IPBehavior, now time.Time) {
+		stats := behavior.scan
+		if stats == nil || now.Sub(stats.since) > bd.analysisWindow {
+			stats = &scanStats{since: now}
+			behavior.scan = stats
+		}
+		stats.responses++
+		if status >= 400 && status < 500 {
+			stats.errors++
+		}
+		if status == http.StatusNotFound || status == http.StatusGone {
+			before := len(stats.missing)
+			if stats.missing == nil {
+				stats.missing = make(map[uint32]struct{})
+			}
+			if before < maxMissingPaths {
+				stats.missing[hashString(2166136261, path)] = struct{}{}
+			}
+			if behavior.IP == ip && before <= threshold && len(stats.missing) > threshold {
+				crossed = true
+			}
+		}
+		behavior.NotFoundPaths = len(stats.missing)
+		behavior.ClientErrors = stats.errors
+	})
+	return crossed && bd.config().scoring.Rules["scanner_not_found"].Enabled
+}
+
+// analyzeScanning raises the indicators of a client scanning for paths:
+// many distinct paths not found, or mostly client errors
+func (bd *BotnetDetector) analyzeScanning(behavior *IPBehavior, analysis *BotnetAnalysis) {
+	stats := behavior.scan
+	if stats == nil || time.Since(stats.since) > bd.analysisWindow {
+		return
+	}
+	if float64(len(stats.missing)) > bd.threshold("scanner_not_found") {
+		bd.addIndicator(analysis, "scanner_not_found", "Many distinct paths not found, as from a path or vulnerability scanner")
+	}
+	if float64(stats.errors) > bd.threshold("scanner_client_errors") && stats.errors*2 > stats.responses {
+		bd.addIndicator(analysis, "scanner_client_errors", "Mostly client error responses")
+	}
+}
@@ -0,0 +1,53 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScannerDetectedByNotFoundPaths(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+	scanner := "203.0.113.9"
+
+	var crossed int
+	for i := 0; i < 40; i++ {
+		path := fmt.Sprintf("/backup-%d.zip", i)
+		bd.AnalyzeRequest(ctx, Request{IP: scanner, Path: path})
+		if bd.RecordResponse(scanner, path, http.StatusNotFound) {
+			crossed++
+		}
+	}
+	if crossed != 1 {
+		t.Errorf("RecordResponse reported the scanner %d times, want once", crossed)
+	}
+
+	analysis := bd.AnalyzeRequest(ctx, Request{IP: scanner, Path: "/wp-login.php"})
+	if !hasSignal(analysis, "scanner_not_found") || !hasSignal(analysis, "scanner_client_errors") {
+		t.Errorf("scanner signals %v, want scanner_not_found and scanner_client_errors", analysis.Signals)
+	}
+}
+
+func TestScannerIgnoresRepeatedMissingPath(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	ctx := context.Background()
+	client := "203.0.113.10"
+
+	// A browser asking for a missing favicon on every page, among pages
+	// that were found
+	for i := 0; i < 40; i++ {
+		bd.AnalyzeRequest(ctx, Request{IP: client, Path: "/"})
+		bd.RecordResponse(client, "/", http.StatusOK)
+		if bd.RecordResponse(client, "/favicon.ico", http.StatusNotFound) {
+			t.Fatal("RecordResponse reported a browser as a scanner")
+		}
+	}
+
+	analysis := bd.AnalyzeRequest(ctx, Request{IP: client, Path: "/"})
+	if hasSignal(analysis, "scanner_not_found") || hasSignal(analysis, "scanner_client_errors") {
+		t.Errorf("browser signals %v, want no scanner indicators", analysis.Signals)
+	}
+}
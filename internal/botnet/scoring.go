@@ -0,0 +1,198 @@
+package botnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Rule scores one indicator. Threshold is the level at which it triggers,
+// in the indicator's own unit; indicators without one ignore it.
+type Rule struct {
+	Enabled   bool    `json:"enabled"`
+	Weight    int     `json:"weight"`
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// Scoring turns indicators into a confidence: risk from the weights of the
+// indicators raised, divided by RiskDivisor, plus IndicatorBonus per
+// indicator. A risk of OverrideRisk or more is a bot with at least
+// OverrideConfidence whatever the threshold; 0 disables the override.
+type Scoring struct {
+	Rules              map[string]Rule `json:"rules"`
+	RiskDivisor        float64         `json:"risk_divisor"`
+	IndicatorBonus     float64         `json:"indicator_bonus"`
+	OverrideRisk       int             `json:"override_risk"`
+	OverrideConfidence float64         `json:"override_confidence"`
+}
+
+// UnmarshalJSON decodes onto the scoring as it is: fields and indicators
+// the JSON leaves out keep their values, and so do the fields left out of
+// each indicator it lists. Decode onto the scoring in force to patch it.
+func (s *Scoring) UnmarshalJSON(data []byte) error {
+	type plain Scoring
+	var patch struct {
+		plain
+		Rules map[string]json.RawMessage `json:"rules"`
+	}
+	patch.plain = plain(*s)
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+
+	rules := make(map[string]Rule, len(s.Rules)+len(patch.Rules))
+	for id, rule := range s.Rules {
+		rules[id] = rule
+	}
+	for id, raw := range patch.Rules {
+		rule := rules[id]
+		if err := json.Unmarshal(raw, &rule); err != nil {
+			return fmt.Errorf("indicator %s: %v", id, err)
+		}
+		rules[id] = rule
+	}
+	patch.plain.Rules = rules
+	*s = Scoring(patch.plain)
+	return nil
+}
+
+// defaultRules are the built-in indicators and their thresholds
+var defaultRules = map[string]Rule{
+	// Request counts are decayed, with a half-life of a quarter of the
+	// analysis window by default, so they measure recent activity.
+	// Recent requests a client makes before missing assets count
+	"no_javascript": {Enabled: true, Weight: 20, Threshold: 20},
+	"no_css":        {Enabled: true, Weight: 15, Threshold: 20},
+	"no_images":     {Enabled: true, Weight: 10, Threshold: 20},
+	// Recent requests before one user agent throughout counts
+	"single_user_agent": {Enabled: true, Weight: 10, Threshold: 20},
+	// Recent requests
+	"high_request_frequency": {Enabled: true, Weight: 25, Threshold: 50},
+	// Average response time in milliseconds
+	"fast_response_times": {Enabled: true, Weight: 15, Threshold: 5},
+	// Average interval between requests in milliseconds
+	"regular_intervals": {Enabled: true, Weight: 15, Threshold: 50},
+	"micro_burst":       {Enabled: true, Weight: 20},
+	"slow_requests":     {Enabled: true, Weight: 40},
+	// Recent requests an address sends without returning its tracking cookie
+	"no_client_cookie": {Enabled: true, Weight: 25, Threshold: 20},
+	// Addresses an authenticated session or token was used from
+	"identity_rotating_ips": {Enabled: true, Weight: 30, Threshold: 10},
+	// Addresses seen from the client's /24
+	"network_ip_count": {Enabled: true, Weight: 30, Threshold: 100},
+	// Clients active in the analysis window
+	"coordinated_timing": {Enabled: true, Weight: 40, Threshold: 1000},
+	// Countries seen
+	"geographic_distribution": {Enabled: true, Weight: 25, Threshold: 50},
+	// Networks seen
+	"network_distribution": {Enabled: true, Weight: 30, Threshold: 100},
+	// Requests in the current 10 second slot
+	"coordinated_burst": {Enabled: true, Weight: 50, Threshold: 100},
+	// Clients sharing the TLS fingerprint
+	"shared_tls_fingerprint": {Enabled: true, Weight: 30, Threshold: 20},
+	// Clients sharing the header order and casing
+	"shared_header_fingerprint": {Enabled: true, Weight: 25, Threshold: 20},
+	"content_negotiation":       {Enabled: true, Weight: 15},
+	// Headless browsers: announced in the user agent or client hints,
+	// Chrome over HTTPS without the headers it always sends, and automation
+	// reported by the challenge page
+	"headless_user_agent":  {Enabled: true, Weight: 80},
+	"headless_headers":     {Enabled: true, Weight: 20},
+	"automation_telemetry": {Enabled: true, Weight: 60},
+	// Matched a known bad bot signature; enough for a bot on its own
+	"known_bad_bot": {Enabled: true, Weight: 160},
+	// Average Shannon entropy, in bits per character, of the random-looking
+	// tokens in the query values or last path segment of URLs that are
+	// nearly all distinct
+	"random_query": {Enabled: true, Weight: 30, Threshold: 3},
+	"random_paths": {Enabled: true, Weight: 25, Threshold: 3},
+	// Navigation plausibility, from 0 to 1, below which a client's pages
+	// do not follow one another as a browser's would
+	"implausible_navigation": {Enabled: true, Weight: 20, Threshold: 0.2},
+	// Distinct paths answered 404 or 410, and 4xx responses when they are
+	// more than half of a client's, in an analysis window
+	"scanner_not_found":     {Enabled: true, Weight: 80, Threshold: 20},
+	"scanner_client_errors": {Enabled: true, Weight: 30, Threshold: 30},
+}
+
+// DefaultScoring returns the built-in scoring
+func DefaultScoring() Scoring {
+	rules := make(map[string]Rule, len(defaultRules))
+	for id, rule := range defaultRules {
+		rules[id] = rule
+	}
+	return Scoring{
+		Rules:              rules,
+		RiskDivisor:        200,
+		IndicatorBonus:     0.05,
+		OverrideRisk:       300,
+		OverrideConfidence: 0.8,
+	}
+}
+
+// Indicators returns the IDs of the built-in indicators
+func Indicators() []string {
+	ids := make([]string, 0, len(defaultRules))
+	for id := range defaultRules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Validate checks the scoring is usable
+func (s Scoring) Validate() error {
+	for id, rule := range s.Rules {
+		if _, known := defaultRules[id]; !known {
+			return fmt.Errorf("unknown indicator %q", id)
+		}
+		if rule.Weight < 0 || rule.Threshold < 0 {
+			return fmt.Errorf("indicator %s: weight and threshold must not be negative", id)
+		}
+	}
+	switch {
+	case s.RiskDivisor <= 0:
+		return fmt.Errorf("risk_divisor must be positive")
+	case s.IndicatorBonus < 0 || s.IndicatorBonus > 1:
+		return fmt.Errorf("indicator_bonus must be between 0 and 1")
+	case s.OverrideRisk < 0:
+		return fmt.Errorf("override_risk must not be negative")
+	case s.OverrideConfidence < 0 || s.OverrideConfidence > 1:
+		return fmt.Errorf("override_confidence must be between 0 and 1")
+	}
+	return nil
+}
+
+// SetScoring validates and applies a scoring. Indicators it does not list
+// keep their defaults.
+func (bd *BotnetDetector) SetScoring(scoring Scoring) error {
+	if err := scoring.Validate(); err != nil {
+		return err
+	}
+	rules := DefaultScoring().Rules
+	for id, rule := range scoring.Rules {
+		rules[id] = rule
+	}
+	scoring.Rules = rules
+
+	bd.configure(func(cfg *settings) {
+		cfg.scoring = scoring
+	})
+	return nil
+}
+
+// Scoring returns the scoring in force
+func (bd *BotnetDetector) Scoring() Scoring {
+	scoring := bd.config().scoring
+	rules := make(map[string]Rule, len(scoring.Rules))
+	for id, rule := range scoring.Rules {
+		rules[id] = rule
+	}
+	scoring.Rules = rules
+	return scoring
+}
+
+// threshold returns the level at which an indicator triggers
+func (bd *BotnetDetector) threshold(id string) float64 {
+	return bd.config().scoring.Rules[id].Threshold
+}
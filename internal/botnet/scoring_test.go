@@ -0,0 +1,86 @@
+package botnet
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestScoringWeightsAndThresholds(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	analyze := func(ip string, n int) *BotnetAnalysis {
+		var analysis *BotnetAnalysis
+		for i := 0; i < n; i++ {
+			analysis = bd.AnalyzeRequest(context.Background(), Request{IP: ip, UserAgent: "agent", Path: "/"})
+		}
+		return analysis
+	}
+
+	// 21 instant requests for a page only: no JavaScript, CSS or images,
+	// one agent, fast responses at short intervals, no navigation
+	before := analyze("10.0.0.1", 21)
+	if before.RiskScore != 105 {
+		t.Fatalf("default risk %d, want 105", before.RiskScore)
+	}
+
+	scoring := DefaultScoring()
+	scoring.Rules["no_javascript"] = Rule{Enabled: false, Weight: 20, Threshold: 20}
+	scoring.Rules["no_css"] = Rule{Enabled: true, Weight: 100, Threshold: 20}
+	scoring.Rules["no_images"] = Rule{Enabled: true, Weight: 10, Threshold: 1000}
+	if err := bd.SetScoring(scoring); err != nil {
+		t.Fatal(err)
+	}
+	after := analyze("10.0.0.2", 21)
+	if after.RiskScore != 160 || len(after.Indicators) != 5 {
+		t.Errorf("reweighted analysis %+v", after)
+	}
+	if !after.IsBotnet {
+		t.Errorf("confidence %.2f not reported as a bot", after.Confidence)
+	}
+}
+
+func TestScoringValidate(t *testing.T) {
+	if err := DefaultScoring().Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []func(s *Scoring){
+		func(s *Scoring) { s.Rules["no_such_indicator"] = Rule{Enabled: true} },
+		func(s *Scoring) { s.Rules["no_css"] = Rule{Weight: -1} },
+		func(s *Scoring) { s.RiskDivisor = 0 },
+		func(s *Scoring) { s.OverrideConfidence = 2 },
+	}
+	for i, change := range bad {
+		s := DefaultScoring()
+		change(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("case %d validated", i)
+		}
+	}
+}
+
+func TestScoringPartialUpdate(t *testing.T) {
+	scoring := DefaultScoring()
+	scoring.Rules["no_images"] = Rule{Enabled: false, Weight: 10, Threshold: 20}
+	patch := `{"risk_divisor": 100, "rules": {"no_css": {"weight": 50}}}`
+	if err := json.Unmarshal([]byte(patch), &scoring); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scoring.Validate(); err != nil {
+		t.Fatalf("partial update invalid: %v", err)
+	}
+	if got := scoring.Rules["no_css"]; got != (Rule{Enabled: true, Weight: 50, Threshold: 20}) {
+		t.Errorf("no_css = %+v, want only its weight changed", got)
+	}
+	if got := scoring.Rules["no_images"]; got.Enabled {
+		t.Error("indicator left out of the update lost its current setting")
+	}
+	if len(scoring.Rules) != len(DefaultScoring().Rules) || !scoring.Rules["no_javascript"].Enabled {
+		t.Error("indicators left out of the update were dropped or disabled")
+	}
+	if scoring.RiskDivisor != 100 || scoring.OverrideRisk != 300 {
+		t.Errorf("risk_divisor %v, override_risk %d", scoring.RiskDivisor, scoring.OverrideRisk)
+	}
+}
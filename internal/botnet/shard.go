@@ -0,0 +1,98 @@
+package botnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardCount is the number of lock stripes client, network and range state
+// is split across; a power of two
+const shardCount = 64
+
+// shard holds the behaviors and network ranges whose keys hash to it
+type shard struct {
+	active int64 // clients seen within the analysis window as of activeAt
+
+	mu               sync.Mutex
+	requestPatterns  map[string]*IPBehavior
+	networkPatterns  map[string]*IPBehavior
+	cookiePatterns   map[string]*IPBehavior
+	identityPatterns map[string]*IPBehavior
+	networkRanges    map[string]*NetworkStats
+	activeAt         time.Time
+	dirty            map[string]*IPBehavior // behaviors changed since the last cluster sync
+}
+
+func newShard() *shard {
+	return &shard{
+		requestPatterns:  make(map[string]*IPBehavior),
+		networkPatterns:  make(map[string]*IPBehavior),
+		cookiePatterns:   make(map[string]*IPBehavior),
+		identityPatterns: make(map[string]*IPBehavior),
+		networkRanges:    make(map[string]*NetworkStats),
+	}
+}
+
+// shardFor returns the shard holding key, by its FNV-1a hash
+func (bd *BotnetDetector) shardFor(key string) *shard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return bd.shards[hash&(shardCount-1)]
+}
+
+// refreshActive recounts the shard's clients seen within window, at most
+// once a second so the scan stays off most requests; s.mu must be held
+func (s *shard) refreshActive(window time.Duration) {
+	now := time.Now()
+	if now.Sub(s.activeAt) < time.Second {
+		return
+	}
+	s.activeAt = now
+
+	windowStart := now.Add(-window)
+	var active int64
+	for _, behavior := range s.requestPatterns {
+		if behavior.LastSeen.After(windowStart) {
+			active++
+		}
+	}
+	atomic.StoreInt64(&s.active, active)
+}
+
+// burstSlots is the number of 10 second slots requests are counted in, an
+// hour's worth
+const burstSlots = 360
+
+// burstSlot counts the requests of one 10 second slot
+type burstSlot struct {
+	slot  int64 // Unix time / 10 of the slot counted
+	count int64
+}
+
+// countBurst counts a request in the slot of now and returns the slot's
+// count. Slots are reused an hour later; a request racing the reset may be
+// lost, which the threshold this feeds tolerates.
+func (bd *BotnetDetector) countBurst(now time.Time) int64 {
+	slot := now.Unix() / 10
+	burst := &bd.bursts[slot%burstSlots]
+	if current := atomic.LoadInt64(&burst.slot); current != slot {
+		if atomic.CompareAndSwapInt64(&burst.slot, current, slot) {
+			atomic.StoreInt64(&burst.count, 0)
+		}
+	}
+	return atomic.AddInt64(&burst.count, 1)
+}
+
+// burstCount returns the requests counted in slot, or 0 once the slot has
+// been reused
+func (bd *BotnetDetector) burstCount(slot int64) int64 {
+	burst := &bd.bursts[slot%burstSlots]
+	if atomic.LoadInt64(&burst.slot) != slot {
+		return 0
+	}
+	return atomic.LoadInt64(&burst.count)
+}
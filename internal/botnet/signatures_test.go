@@ -0,0 +1,27 @@
+package botnet
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"ddos-protection/internal/badbot"
+)
+
+func TestKnownBadBot(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	bd.SetSignatures(badbot.NewDatabase(true))
+
+	header := http.Header{"User-Agent": {"sqlmap/1.7.2#stable"}}
+	analysis := bd.AnalyzeRequest(context.Background(), Request{IP: "192.0.2.10", Path: "/", Header: header})
+	if !analysis.IsBotnet || !hasSignal(analysis, "known_bad_bot") || !hasSignal(analysis, "bad_bot:sqlmap") {
+		t.Errorf("first sqlmap request: botnet %v, signals %v", analysis.IsBotnet, analysis.Signals)
+	}
+
+	header = http.Header{"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; Win64; x64)"}}
+	analysis = bd.AnalyzeRequest(context.Background(), Request{IP: "192.0.2.11", Path: "/", Header: header})
+	if hasSignal(analysis, "known_bad_bot") {
+		t.Errorf("browser matched a bad bot signature: %v", analysis.Signals)
+	}
+}
@@ -0,0 +1,187 @@
+package botnet
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	trackedState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_botnet_tracked",
+		Help: "Entries held by the botnet detector, by kind (clients, networks, cookies, identities, ranges, fingerprints)",
+	}, []string{"kind"})
+
+	evictedState = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_botnet_evictions_total",
+		Help: "Botnet detector entries dropped to stay within their cap or once idle, by kind",
+	}, []string{"kind"})
+)
+
+// DefaultStateTTL is how long an idle client, network or fingerprint is
+// remembered until SetStateTTL is called, in analysis windows
+const DefaultStateTTL = 5
+
+// SetStateTTL sets how long idle state is remembered. It is never less
+// than the analysis window, so clients are not forgotten mid-analysis.
+func (bd *BotnetDetector) SetStateTTL(ttl time.Duration) {
+	if ttl < bd.analysisWindow {
+		ttl = bd.analysisWindow
+	}
+	bd.configure(func(cfg *settings) {
+		cfg.stateTTL = ttl
+	})
+}
+
+// Cleanup drops clients, networks, cookies, identities, ranges and
+// fingerprints idle for longer than the state TTL, and returns how many
+// entries were dropped
+func (bd *BotnetDetector) Cleanup(now time.Time) int {
+	idleSince := now.Add(-bd.config().stateTTL)
+	removed := 0
+	for _, s := range bd.shards {
+		s.mu.Lock()
+		for ip, behavior := range s.requestPatterns {
+			if behavior.LastSeen.Before(idleSince) {
+				bd.removeBehavior(s.requestPatterns, ip, "clients")
+				removed++
+			}
+		}
+		for network, behavior := range s.networkPatterns {
+			if behavior.LastSeen.Before(idleSince) {
+				bd.removeBehavior(s.networkPatterns, network, "networks")
+				removed++
+			}
+		}
+		for key, behavior := range s.cookiePatterns {
+			if behavior.LastSeen.Before(idleSince) {
+				bd.removeBehavior(s.cookiePatterns, key, "cookies")
+				removed++
+			}
+		}
+		for key, behavior := range s.identityPatterns {
+			if behavior.LastSeen.Before(idleSince) {
+				bd.removeBehavior(s.identityPatterns, key, "identities")
+				removed++
+			}
+		}
+		for network, stats := range s.networkRanges {
+			if stats.LastSeen.Before(idleSince) {
+				bd.removeRange(s, network)
+				removed++
+			}
+		}
+		s.mu.Unlock()
+	}
+	bd.fingerprints.Range(func(key, value interface{}) bool {
+		if atomic.LoadInt64(&value.(*fingerprintUse).lastSeen) < idleSince.UnixNano() {
+			bd.fingerprints.Delete(key)
+			atomic.AddInt64(&bd.fingerprintCount, -1)
+			evictedState.WithLabelValues("fingerprints").Inc()
+			removed++
+		}
+		return true
+	})
+
+	bd.updateTracked()
+	return removed
+}
+
+// perShard is a shard's share of a cap
+func perShard(max int) int {
+	return (max + shardCount - 1) / shardCount
+}
+
+// capBehaviors evicts the clients or networks that went longest without a
+// request, other than keep, once behaviors holds more than its shard's share
+// of the cap; the shard's lock must be held. A percent of the share goes at
+// a time so a flood of new clients does not sort the map for each.
+func (bd *BotnetDetector) capBehaviors(s *shard, kind string, behaviors map[string]*IPBehavior, keep *IPBehavior) {
+	max := perShard(bd.config().limits.Clients)
+	if max <= 0 || len(behaviors) <= max {
+		return
+	}
+
+	candidates := make([]*IPBehavior, 0, len(behaviors))
+	for _, behavior := range behaviors {
+		if behavior != keep {
+			candidates = append(candidates, behavior)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastSeen.Before(candidates[j].LastSeen)
+	})
+
+	evict := len(behaviors) - max + max/100
+	if evict > len(candidates) {
+		evict = len(candidates)
+	}
+	for _, behavior := range candidates[:evict] {
+		bd.removeBehavior(behaviors, behavior.IP, kind)
+	}
+}
+
+// capRanges evicts the network ranges that went longest without a request,
+// other than keep, once the shard holds more than its share of the cap; the
+// shard's lock must be held
+func (bd *BotnetDetector) capRanges(s *shard, keep string) {
+	max := perShard(bd.config().limits.Networks)
+	if max <= 0 || len(s.networkRanges) <= max {
+		return
+	}
+
+	candidates := make([]*NetworkStats, 0, len(s.networkRanges))
+	for network, stats := range s.networkRanges {
+		if network != keep {
+			candidates = append(candidates, stats)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastSeen.Before(candidates[j].LastSeen)
+	})
+
+	evict := len(s.networkRanges) - max + max/100
+	if evict > len(candidates) {
+		evict = len(candidates)
+	}
+	for _, stats := range candidates[:evict] {
+		bd.removeRange(s, stats.Network)
+	}
+}
+
+// removeBehavior forgets a client, network, cookie or identity behavior;
+// its shard's lock must be held
+func (bd *BotnetDetector) removeBehavior(behaviors map[string]*IPBehavior, key, kind string) {
+	delete(behaviors, key)
+	switch kind {
+	case "networks":
+		atomic.AddInt64(&bd.networks, -1)
+	case "cookies":
+		atomic.AddInt64(&bd.cookies, -1)
+	case "identities":
+		atomic.AddInt64(&bd.identities, -1)
+	default:
+		atomic.AddInt64(&bd.clients, -1)
+	}
+	evictedState.WithLabelValues(kind).Inc()
+}
+
+// removeRange forgets a network range; the shard's lock must be held
+func (bd *BotnetDetector) removeRange(s *shard, network string) {
+	delete(s.networkRanges, network)
+	atomic.AddInt64(&bd.ranges, -1)
+	evictedState.WithLabelValues("ranges").Inc()
+}
+
+// updateTracked sets the tracked state gauges
+func (bd *BotnetDetector) updateTracked() {
+	trackedState.WithLabelValues("clients").Set(float64(atomic.LoadInt64(&bd.clients)))
+	trackedState.WithLabelValues("networks").Set(float64(atomic.LoadInt64(&bd.networks)))
+	trackedState.WithLabelValues("cookies").Set(float64(atomic.LoadInt64(&bd.cookies)))
+	trackedState.WithLabelValues("identities").Set(float64(atomic.LoadInt64(&bd.identities)))
+	trackedState.WithLabelValues("ranges").Set(float64(atomic.LoadInt64(&bd.ranges)))
+	trackedState.WithLabelValues("fingerprints").Set(float64(atomic.LoadInt64(&bd.fingerprintCount)))
+}
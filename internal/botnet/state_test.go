@@ -0,0 +1,43 @@
+package botnet
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateIsBoundedAndExpires(t *testing.T) {
+	bd := NewBotnetDetector(0.8, time.Minute)
+	limits := DefaultLimits
+	limits.Clients = 2 * shardCount
+	limits.Networks = shardCount
+	bd.SetLimits(limits)
+
+	for i := 0; i < 1000; i++ {
+		bd.AnalyzeRequest(context.Background(), Request{IP: fmt.Sprintf("10.%d.%d.1", i/250, i%250), UserAgent: "agent", Path: "/"})
+	}
+	if n := atomic.LoadInt64(&bd.clients); n > int64(limits.Clients) {
+		t.Errorf("%d clients tracked, want at most %d", n, limits.Clients)
+	}
+	if n := atomic.LoadInt64(&bd.ranges); n > int64(limits.Networks) {
+		t.Errorf("%d ranges tracked, want at most %d", n, limits.Networks)
+	}
+	if bd.Behavior("10.3.249.1").Address == nil {
+		t.Error("latest client was evicted")
+	}
+
+	if removed := bd.Cleanup(time.Now()); removed != 0 {
+		t.Errorf("cleanup dropped %d active entries", removed)
+	}
+	bd.Cleanup(time.Now().Add(DefaultStateTTL*time.Minute + time.Second))
+	if clients, ranges := atomic.LoadInt64(&bd.clients), atomic.LoadInt64(&bd.ranges); clients != 0 || ranges != 0 {
+		t.Errorf("idle state kept: %d clients, %d ranges", clients, ranges)
+	}
+	for _, s := range bd.shards {
+		if len(s.requestPatterns) != 0 || len(s.networkRanges) != 0 {
+			t.Fatal("shard kept idle state")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package botpolicy decides what happens to suspected bots per endpoint. A
+// decision matrix maps each path group and risk band to an action, so bots
+// can be blocked on checkout, challenged on search and merely tagged on the
+// blog.
+package botpolicy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Action is what happens to a suspected bot
+type Action string
+
+const (
+	// ActionAllow serves the request as if no bot was suspected
+	ActionAllow Action = "allow"
+	// ActionTag serves the request, marking it for the backend
+	ActionTag Action = "tag"
+	// ActionChallenge asks the client to prove it is a browser
+	ActionChallenge Action = "challenge"
+	// ActionBlock rejects the request
+	ActionBlock Action = "block"
+)
+
+// DefaultGroup is the path group for paths no other group claims
+const DefaultGroup = "default"
+
+// Band is a range of bot confidence, from MinConfidence up to the next band
+type Band struct {
+	Name          string  `json:"name"`
+	MinConfidence float64 `json:"min_confidence"`
+}
+
+// PathGroup names a set of path prefixes
+type PathGroup struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+// Matrix holds the action for each path group and band, keyed by group
+// then band name
+type Matrix map[string]map[string]Action
+
+// Spec is a complete policy
+type Spec struct {
+	Bands  []Band      `json:"bands"`
+	Groups []PathGroup `json:"path_groups"`
+	Matrix Matrix      `json:"matrix"`
+}
+
+// Decision is the policy's answer for a request
+type Decision struct {
+	Group  string `json:"group"`
+	Band   string `json:"band"`
+	Action Action `json:"action"`
+}
+
+// Policy applies a Spec. It is safe for concurrent use and can be replaced
+// while in use.
+type Policy struct {
+	mu   sync.RWMutex
+	spec Spec
+}
+
+// New creates a policy from spec
+func New(spec Spec) (*Policy, error) {
+	p := &Policy{}
+	if err := p.Update(spec); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update validates spec and replaces the policy with it
+func (p *Policy) Update(spec Spec) error {
+	spec, err := normalize(spec)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.spec = spec
+	return nil
+}
+
+// Spec returns a copy of the policy
+func (p *Policy) Spec() Spec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return clone(p.spec)
+}
+
+// Decide returns the action for a request to path from a client with the
+// given bot confidence. It reports false when the confidence is below every
+// band.
+func (p *Policy) Decide(path string, confidence float64) (Decision, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	band := ""
+	// Bands are sorted by confidence, so the last one reached wins
+	for _, b := range p.spec.Bands {
+		if confidence >= b.MinConfidence {
+			band = b.Name
+		}
+	}
+	if band == "" {
+		return Decision{}, false
+	}
+
+	group := p.group(path)
+	action, ok := p.spec.Matrix[group][band]
+	if !ok {
+		action, ok = p.spec.Matrix[DefaultGroup][band]
+	}
+	if !ok {
+		action = ActionBlock
+	}
+	return Decision{Group: group, Band: band, Action: action}, true
+}
+
+// group returns the group whose longest path prefix matches path
+func (p *Policy) group(path string) string {
+	group, longest := DefaultGroup, -1
+	for _, g := range p.spec.Groups {
+		for _, prefix := range g.Paths {
+			if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+				group, longest = g.Name, len(prefix)
+			}
+		}
+	}
+	return group
+}
+
+// normalize checks a spec and returns it with bands sorted by confidence
+func normalize(spec Spec) (Spec, error) {
+	spec = clone(spec)
+	if len(spec.Bands) == 0 {
+		return Spec{}, fmt.Errorf("bot policy defines no bands")
+	}
+
+	bands := make(map[string]bool, len(spec.Bands))
+	for _, b := range spec.Bands {
+		if b.Name == "" {
+			return Spec{}, fmt.Errorf("bot policy band without a name")
+		}
+		if bands[b.Name] {
+			return Spec{}, fmt.Errorf("duplicate bot policy band %q", b.Name)
+		}
+		if b.MinConfidence < 0 || b.MinConfidence > 1 {
+			return Spec{}, fmt.Errorf("band %s: min_confidence must be between 0 and 1", b.Name)
+		}
+		bands[b.Name] = true
+	}
+	sort.SliceStable(spec.Bands, func(i, j int) bool {
+		return spec.Bands[i].MinConfidence < spec.Bands[j].MinConfidence
+	})
+
+	groups := map[string]bool{DefaultGroup: true}
+	for _, g := range spec.Groups {
+		if g.Name == "" || g.Name == DefaultGroup {
+			return Spec{}, fmt.Errorf("invalid path group name %q", g.Name)
+		}
+		if groups[g.Name] {
+			return Spec{}, fmt.Errorf("duplicate path group %q", g.Name)
+		}
+		if len(g.Paths) == 0 {
+			return Spec{}, fmt.Errorf("path group %s has no paths", g.Name)
+		}
+		groups[g.Name] = true
+	}
+
+	for group, row := range spec.Matrix {
+		if !groups[group] {
+			return Spec{}, fmt.Errorf("matrix names unknown path group %q", group)
+		}
+		for band, action := range row {
+			if !bands[band] {
+				return Spec{}, fmt.Errorf("matrix names unknown band %q", band)
+			}
+			switch action {
+			case ActionAllow, ActionTag, ActionChallenge, ActionBlock:
+			default:
+				return Spec{}, fmt.Errorf("group %s, band %s: invalid action %q", group, band, action)
+			}
+		}
+	}
+	return spec, nil
+}
+
+func clone(spec Spec) Spec {
+	c := Spec{
+		Bands:  append([]Band(nil), spec.Bands...),
+		Groups: make([]PathGroup, len(spec.Groups)),
+		Matrix: make(Matrix, len(spec.Matrix)),
+	}
+	for i, g := range spec.Groups {
+		c.Groups[i] = PathGroup{Name: g.Name, Paths: append([]string(nil), g.Paths...)}
+	}
+	for group, row := range spec.Matrix {
+		c.Matrix[group] = make(map[string]Action, len(row))
+		for band, action := range row {
+			c.Matrix[group][band] = action
+		}
+	}
+	return c
+}
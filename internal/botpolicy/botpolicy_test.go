@@ -0,0 +1,77 @@
+package botpolicy
+
+import "testing"
+
+func testSpec() Spec {
+	return Spec{
+		Bands: []Band{
+			{Name: "high", MinConfidence: 0.8},
+			{Name: "low", MinConfidence: 0.3},
+			{Name: "medium", MinConfidence: 0.5},
+		},
+		Groups: []PathGroup{
+			{Name: "checkout", Paths: []string{"/checkout", "/cart"}},
+			{Name: "search", Paths: []string{"/search"}},
+			{Name: "blog", Paths: []string{"/blog"}},
+		},
+		Matrix: Matrix{
+			"checkout": {"low": ActionChallenge, "medium": ActionBlock, "high": ActionBlock},
+			"search":   {"low": ActionTag, "medium": ActionChallenge},
+			"blog":     {"low": ActionAllow, "medium": ActionTag, "high": ActionTag},
+			"default":  {"low": ActionAllow, "medium": ActionTag, "high": ActionBlock},
+		},
+	}
+}
+
+func TestDecide(t *testing.T) {
+	policy, err := New(testSpec())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		path       string
+		confidence float64
+		expected   Decision
+		decided    bool
+	}{
+		{"/checkout/pay", 0.1, Decision{}, false},
+		{"/checkout/pay", 0.35, Decision{"checkout", "low", ActionChallenge}, true},
+		{"/cart", 0.6, Decision{"checkout", "medium", ActionBlock}, true},
+		{"/search?q=x", 0.55, Decision{"search", "medium", ActionChallenge}, true},
+		{"/search", 0.9, Decision{"search", "high", ActionBlock}, true},
+		{"/blog/post", 0.95, Decision{"blog", "high", ActionTag}, true},
+		{"/about", 0.5, Decision{"default", "medium", ActionTag}, true},
+	}
+
+	for _, tt := range tests {
+		got, decided := policy.Decide(tt.path, tt.confidence)
+		if decided != tt.decided || got != tt.expected {
+			t.Errorf("Decide(%q, %.2f) = %+v, %v; want %+v, %v", tt.path, tt.confidence, got, decided, tt.expected, tt.decided)
+		}
+	}
+}
+
+func TestUpdateValidates(t *testing.T) {
+	policy, err := New(testSpec())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	bad := testSpec()
+	bad.Matrix["blog"]["low"] = "ignore"
+	if err := policy.Update(bad); err == nil {
+		t.Error("Update accepted an invalid action")
+	}
+
+	bad = testSpec()
+	bad.Matrix["admin"] = map[string]Action{"low": ActionBlock}
+	if err := policy.Update(bad); err == nil {
+		t.Error("Update accepted an unknown path group")
+	}
+
+	// A rejected update leaves the policy unchanged
+	if got, _ := policy.Decide("/blog", 0.4); got.Action != ActionAllow {
+		t.Errorf("policy changed by rejected update: %+v", got)
+	}
+}
@@ -0,0 +1,109 @@
+// Package challenge lets a client suspected of being a bot prove otherwise
+// instead of being turned away, by solving a CAPTCHA or a proof-of-work
+// puzzle run by the page's JavaScript. A client that passes a challenge is
+// given a signed pass, kept in a cookie, that spares it bot detection until
+// the pass expires. Passes are bound to the client's IP so one cannot be
+// solved once and shared across a botnet.
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pass errors
+var (
+	ErrInvalidPass = errors.New("invalid challenge pass")
+	ErrExpiredPass = errors.New("challenge pass expired")
+	ErrWrongClient = errors.New("challenge pass was issued to another client")
+)
+
+// passPrefix is signed with every pass, so a token signed with the same
+// secret for another purpose is not taken for one
+const passPrefix = "pass|"
+
+// Passes issues and verifies challenge passes
+type Passes struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewPasses returns an issuer of passes valid for ttl
+func NewPasses(secret []byte, ttl time.Duration) *Passes {
+	return &Passes{secret: secret, ttl: ttl}
+}
+
+// TTL returns how long passes are valid
+func (p *Passes) TTL() time.Duration {
+	return p.ttl
+}
+
+// Issue returns a pass for ip
+func (p *Passes) Issue(ip string, now time.Time) string {
+	payload := ip + "|" + strconv.FormatInt(now.Add(p.ttl).Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sign(p.secret, passPrefix, payload)
+}
+
+// Verify checks that pass is genuine, unexpired and was issued to ip
+func (p *Passes) Verify(pass, ip string, now time.Time) error {
+	payload, err := verify(p.secret, passPrefix, pass, ErrInvalidPass)
+	if err != nil {
+		return err
+	}
+
+	passIP, expiry, ok := strings.Cut(payload, "|")
+	if !ok {
+		return ErrInvalidPass
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrInvalidPass
+	}
+	if now.Unix() >= expires {
+		return ErrExpiredPass
+	}
+	if passIP != ip {
+		return ErrWrongClient
+	}
+	return nil
+}
+
+// sign returns the signature of payload for the purpose named by prefix
+func sign(secret []byte, prefix, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(prefix + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature of a token signed for prefix and returns its
+// payload, or invalid
+func verify(secret []byte, prefix, token string, invalid error) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", invalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", invalid
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, prefix, payload))) {
+		return "", invalid
+	}
+	return payload, nil
+}
+
+// SafeReturn returns target if it is a path on this site to send a client
+// back to after a challenge, or "/" otherwise, so the challenge cannot be
+// used to redirect clients elsewhere
+func SafeReturn(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
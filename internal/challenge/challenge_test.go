@@ -0,0 +1,82 @@
+package challenge
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPassesVerify(t *testing.T) {
+	now := time.Now()
+	p := NewPasses([]byte("secret"), time.Hour)
+	pass := p.Issue("192.0.2.1", now)
+
+	if err := p.Verify(pass, "192.0.2.1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("valid pass rejected: %v", err)
+	}
+	if err := p.Verify(pass, "192.0.2.2", now); err != ErrWrongClient {
+		t.Errorf("pass used by another client: got %v, want ErrWrongClient", err)
+	}
+	if err := p.Verify(pass, "192.0.2.1", now.Add(2*time.Hour)); err != ErrExpiredPass {
+		t.Errorf("expired pass: got %v, want ErrExpiredPass", err)
+	}
+	if err := NewPasses([]byte("other"), time.Hour).Verify(pass, "192.0.2.1", now); err != ErrInvalidPass {
+		t.Errorf("pass signed with another secret: got %v, want ErrInvalidPass", err)
+	}
+	for _, bad := range []string{"", "no-dot", "!!!.sig"} {
+		if err := p.Verify(bad, "192.0.2.1", now); err != ErrInvalidPass {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidPass", bad, err)
+		}
+	}
+}
+
+func TestSafeReturn(t *testing.T) {
+	tests := map[string]string{
+		"/cart?item=1":         "/cart?item=1",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example/":      "/",
+		"/\\evil.example":      "/",
+	}
+	for target, want := range tests {
+		if got := SafeReturn(target); got != want {
+			t.Errorf("SafeReturn(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestPuzzlesCheck(t *testing.T) {
+	now := time.Now()
+	p := NewPuzzles([]byte("secret"), 8, time.Minute)
+	puzzle, err := p.Issue("192.0.2.1", now)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	var nonce string
+	for i := 0; ; i++ {
+		if Solves(puzzle, strconv.Itoa(i), p.Difficulty()) {
+			nonce = strconv.Itoa(i)
+			break
+		}
+	}
+
+	if err := p.Check(puzzle, nonce, "192.0.2.1", now); err != nil {
+		t.Fatalf("solved puzzle rejected: %v", err)
+	}
+	if err := p.Check(puzzle, nonce, "192.0.2.2", now); err != ErrWrongClient {
+		t.Errorf("puzzle solved by another client: got %v, want ErrWrongClient", err)
+	}
+	if err := p.Check(puzzle, nonce, "192.0.2.1", now.Add(2*time.Minute)); err != ErrExpiredPuzzle {
+		t.Errorf("expired puzzle: got %v, want ErrExpiredPuzzle", err)
+	}
+	if err := p.Check(puzzle, "", "192.0.2.1", now); err != ErrUnsolved {
+		t.Errorf("missing nonce: got %v, want ErrUnsolved", err)
+	}
+
+	// A pass is not a puzzle, even signed with the same secret
+	pass := NewPasses([]byte("secret"), time.Hour).Issue("192.0.2.1", now)
+	if err := p.Check(pass, nonce, "192.0.2.1", now); err != ErrInvalidPuzzle {
+		t.Errorf("pass checked as a puzzle: got %v, want ErrInvalidPuzzle", err)
+	}
+}
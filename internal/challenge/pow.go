@@ -0,0 +1,110 @@
+package challenge
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Puzzle errors
+var (
+	ErrInvalidPuzzle = errors.New("invalid challenge puzzle")
+	ErrExpiredPuzzle = errors.New("challenge puzzle expired")
+	ErrUnsolved      = errors.New("challenge puzzle not solved")
+)
+
+// puzzlePrefix is signed with every puzzle, so a puzzle cannot be passed
+// off as a pass or the other way round
+const puzzlePrefix = "puzzle|"
+
+// Puzzles issues and checks proof-of-work puzzles. Solving one means
+// finding a nonce for which the SHA-256 of the puzzle, a colon and the
+// nonce starts with Difficulty zero bits: about 2^Difficulty hashes, a
+// second or so of a browser's time but a real cost to a flood tool sending
+// thousands of requests from each address. Puzzles are signed and bound to
+// the client's IP, so the server keeps no state for them.
+type Puzzles struct {
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+}
+
+// NewPuzzles returns an issuer of puzzles of difficulty bits, to be solved
+// within ttl
+func NewPuzzles(secret []byte, difficulty int, ttl time.Duration) *Puzzles {
+	return &Puzzles{secret: secret, difficulty: difficulty, ttl: ttl}
+}
+
+// Difficulty returns the leading zero bits a solution needs
+func (p *Puzzles) Difficulty() int {
+	return p.difficulty
+}
+
+// Issue returns a puzzle for ip
+func (p *Puzzles) Issue(ip string, now time.Time) (string, error) {
+	salt := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	payload := strings.Join([]string{
+		ip,
+		strconv.FormatInt(now.Add(p.ttl).Unix(), 10),
+		strconv.Itoa(p.difficulty),
+		hex.EncodeToString(salt),
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sign(p.secret, puzzlePrefix, payload), nil
+}
+
+// Check verifies that puzzle is genuine, unexpired and was issued to ip,
+// and that nonce solves it
+func (p *Puzzles) Check(puzzle, nonce, ip string, now time.Time) error {
+	payload, err := verify(p.secret, puzzlePrefix, puzzle, ErrInvalidPuzzle)
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(payload, "|")
+	if len(fields) != 4 {
+		return ErrInvalidPuzzle
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return ErrInvalidPuzzle
+	}
+	difficulty, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return ErrInvalidPuzzle
+	}
+	if now.Unix() >= expires {
+		return ErrExpiredPuzzle
+	}
+	if fields[0] != ip {
+		return ErrWrongClient
+	}
+	if nonce == "" || len(nonce) > 32 || !Solves(puzzle, nonce, difficulty) {
+		return ErrUnsolved
+	}
+	return nil
+}
+
+// Solves reports whether nonce solves puzzle at difficulty
+func Solves(puzzle, nonce string, difficulty int) bool {
+	return leadingZeros(sha256.Sum256([]byte(puzzle+":"+nonce))) >= difficulty
+}
+
+// leadingZeros counts the leading zero bits of sum
+func leadingZeros(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
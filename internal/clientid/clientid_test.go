@@ -0,0 +1,39 @@
+package clientid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssuerVerify(t *testing.T) {
+	now := time.Now()
+	issuer := NewIssuer([]byte("secret"), 24*time.Hour)
+	id, token, err := issuer.Issue(now)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	got, err := issuer.Verify(token, now.Add(time.Hour))
+	if err != nil || got != id {
+		t.Fatalf("Verify = %q, %v; want %q", got, err, id)
+	}
+	if _, err := issuer.Verify(token, now.Add(48*time.Hour)); err != ErrExpired {
+		t.Errorf("expired token: got %v, want ErrExpired", err)
+	}
+	if _, err := NewIssuer([]byte("other"), time.Hour).Verify(token, now); err != ErrInvalid {
+		t.Errorf("token signed with another secret: got %v, want ErrInvalid", err)
+	}
+
+	// A client cannot choose its ID
+	_, signature, _ := strings.Cut(token, ".")
+	forged := "ZmFrZXwxMDAwMDAwMDAwMA." + signature
+	if _, err := issuer.Verify(forged, now); err != ErrInvalid {
+		t.Errorf("forged token: got %v, want ErrInvalid", err)
+	}
+
+	_, other, _ := issuer.Issue(now)
+	if other == token {
+		t.Error("two issued tokens are the same")
+	}
+}
@@ -2,20 +2,123 @@ package config
 
 import (
 	"os"
-	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Server     ServerConfig     `yaml:"server"`
 	Redis      RedisConfig      `yaml:"redis"`
+	Tenancy    TenancyConfig    `yaml:"tenancy"`
+	Events     EventsConfig     `yaml:"events"`
 	Protection ProtectionConfig `yaml:"protection"`
 	Logging    LoggingConfig    `yaml:"logging"`
 	Metrics    MetricsConfig    `yaml:"metrics"`
+	StatusPage StatusPageConfig `yaml:"status_page"`
+	Audit      AuditConfig      `yaml:"audit"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Mesh       MeshConfig       `yaml:"mesh"`
+	Webhooks   WebhooksConfig   `yaml:"webhooks"`
+	APIKeys    APIKeysConfig    `yaml:"api_keys"`
+
+	// Read replicas
+	Replication ReplicationConfig `yaml:"replication"`
+
+	// Botnet analyses and decisions streamed to Kafka or NATS
+	Export ExportConfig `yaml:"export"`
+}
+
+type ExportConfig struct {
+	Enabled       bool       `yaml:"enabled"`
+	Sink          string     `yaml:"sink"`
+	URL           string     `yaml:"url"`
+	Topic         string     `yaml:"topic"`
+	BatchSize     int        `yaml:"batch_size"`
+	FlushInterval Duration   `yaml:"flush_interval"`
+	QueueSize     int        `yaml:"queue_size"`
+	MaxAttempts   int        `yaml:"max_attempts"`
+	BackoffMs     DurationMs `yaml:"backoff_ms"`
+	Timeout       Duration   `yaml:"timeout"`
+}
+
+type ReplicationConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	MaxEvents     int      `yaml:"max_events"`
+	StatsInterval Duration `yaml:"stats_interval"`
+}
+
+type APIKeysConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Header         string   `yaml:"header"`
+	ProtectedPaths []string `yaml:"protected_paths"`
+	RotationGrace  Duration `yaml:"rotation_grace"`
+	SyncInterval   Duration `yaml:"sync_interval"`
+}
+
+type WebhooksConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Timeout     Duration          `yaml:"timeout"`
+	MaxAttempts int               `yaml:"max_attempts"`
+	BackoffMs   DurationMs        `yaml:"backoff_ms"`
+	QueueSize   int               `yaml:"queue_size"`
+	Endpoints   []WebhookEndpoint `yaml:"endpoints"`
+}
+
+type WebhookEndpoint struct {
+	Name   string   `yaml:"name"`
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Format string   `yaml:"format"`
+	Events []string `yaml:"events"`
+}
+
+type MeshConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Listen         string `yaml:"listen"`
+	PathPrefix     string `yaml:"path_prefix"`
+	ClientIPHeader string `yaml:"client_ip_header"`
+}
+
+type TracingConfig struct {
+	Enabled       bool    `yaml:"enabled"`
+	SampleRate    float64 `yaml:"sample_rate"`
+	RiskThreshold int     `yaml:"risk_threshold"`
+	LogSpans      bool    `yaml:"log_spans"`
+}
+
+type StatusPageConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Title        string   `yaml:"title"`
+	CacheSeconds Duration `yaml:"cache_seconds"`
+}
+
+type AuditConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	File               string   `yaml:"file"`
+	RedisStream        string   `yaml:"redis_stream"`
+	StreamMaxLen       int64    `yaml:"stream_max_len"`
+	Capacity           int      `yaml:"capacity"`
+	SigningKeyFile     string   `yaml:"signing_key_file"`
+	CheckpointInterval Duration `yaml:"checkpoint_interval"`
 }
 
 type ServerConfig struct {
-	Port string `yaml:"port"`
-	Mode string `yaml:"mode"`
+	Port           string         `yaml:"port"`
+	Mode           string         `yaml:"mode"`
+	Role           string         `yaml:"role"`
+	TrustedProxies []string       `yaml:"trusted_proxies"`
+	Shutdown       ShutdownConfig `yaml:"shutdown"`
+	TLS            TLSConfig      `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type ShutdownConfig struct {
+	DrainTimeout   Duration `yaml:"drain_timeout"`
+	FlushTimeout   Duration `yaml:"flush_timeout"`
+	PersistTimeout Duration `yaml:"persist_timeout"`
+	CloseTimeout   Duration `yaml:"close_timeout"`
 }
 
 type RedisConfig struct {
@@ -25,26 +128,484 @@ type RedisConfig struct {
 	DB       int    `yaml:"db"`
 }
 
+type TenancyConfig struct {
+	Header        string   `yaml:"header"`
+	UseHost       bool     `yaml:"use_host"`
+	IsolatedLists bool     `yaml:"isolated_lists"`
+	Tenants       []string `yaml:"tenants"`
+}
+
+type EventsConfig struct {
+	Capacity int `yaml:"capacity"`
+}
+
 type ProtectionConfig struct {
-	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
-	IPBlacklist   IPBlacklistConfig   `yaml:"ip_blacklist"`
-	IPWhitelist   IPWhitelistConfig   `yaml:"ip_whitelist"`
-	RequestFilter RequestFilterConfig `yaml:"request_filter"`
-	Monitoring    MonitoringConfig    `yaml:"monitoring"`
-	HealthCheck   HealthCheckConfig   `yaml:"health_check"`
+	Preset         string               `yaml:"preset"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	BurstDetection BurstDetectionConfig `yaml:"burst_detection"`
+	IPBlacklist    IPBlacklistConfig    `yaml:"ip_blacklist"`
+	IPWhitelist    IPWhitelistConfig    `yaml:"ip_whitelist"`
+	RequestFilter  RequestFilterConfig  `yaml:"request_filter"`
+	ResponseFilter ResponseFilterConfig `yaml:"response_filter"`
+	Monitoring     MonitoringConfig     `yaml:"monitoring"`
+	HealthCheck    HealthCheckConfig    `yaml:"health_check"`
+	Probation      ProbationConfig      `yaml:"probation"`
+	KillSwitch     KillSwitchConfig     `yaml:"kill_switch"`
+	ASN            ASNConfig            `yaml:"asn"`
+	Forecasting    ForecastingConfig    `yaml:"forecasting"`
+	Geo            GeoConfig            `yaml:"geo"`
+	Access         AccessConfig         `yaml:"access"`
+	DNSBL          DNSBLConfig          `yaml:"dnsbl"`
+	Methods        MethodsConfig        `yaml:"methods"`
+	MonitorAgents  []MonitorAgentConfig `yaml:"monitor_agents"`
+	Crawlers       CrawlersConfig       `yaml:"crawlers"`
+	Slowdown       SlowdownConfig       `yaml:"slowdown"`
+	VerdictCache   VerdictCacheConfig   `yaml:"verdict_cache"`
+	Botnet         BotnetConfig         `yaml:"botnet"`
+	BotPolicy      BotPolicyConfig      `yaml:"bot_policy"`
+	Reputation     ReputationConfig     `yaml:"reputation"`
+	SLA            SLAConfig            `yaml:"sla"`
+	Cardinality    CardinalityConfig    `yaml:"cardinality"`
+	Overrides      OverridesConfig      `yaml:"overrides"`
+	Captcha        CaptchaConfig        `yaml:"captcha"`
+
+	// IPv6 clients are also tracked by network, as privacy extensions rotate
+	// their addresses
+	IPv6Aggregation IPv6AggregationConfig `yaml:"ipv6_aggregation"`
+
+	// High-risk clients can be probed for open proxies; opt-in, as it opens
+	// connections to the client
+	ProxyProbe ProxyProbeConfig `yaml:"proxy_probe"`
+
+	// The cost of blocked requests is estimated per incident for reports
+	AttackCost AttackCostConfig `yaml:"attack_cost"`
+
+	// Detections are grouped into attacks, kept for post-incident review
+	Attacks AttacksConfig `yaml:"attacks"`
+
+	// Signed rule bundles can be fetched from an update channel
+	RuleUpdates RuleUpdatesConfig `yaml:"rule_updates"`
+
+	// Pipeline stages can be held to a deadline, bounding the latency added
+	StageTimeouts StageTimeoutsConfig `yaml:"stage_timeouts"`
+
+	// A baseline of normal client traffic can be learned and deviations
+	// scored alongside the botnet detector
+	Anomaly AnomalyConfig `yaml:"anomaly"`
+
+	// Botnet indicators and request facts can be combined into composite
+	// signals that add risk
+	CompositeSignals CompositeSignalsConfig `yaml:"composite_signals"`
+
+	// Authentication events reported by the upstream can be correlated
+	// with blocked traffic to tell credential stuffing from traffic spikes
+	AuthCorrelation AuthCorrelationConfig `yaml:"auth_correlation"`
+
+	// Connections held open by requests sent slowly (Slowloris, slow POST)
+	// can be detected, scored and cut
+	SlowRequests SlowRequestsConfig `yaml:"slow_requests"`
+
+	// Suspected bots can be challenged with a CAPTCHA or a proof-of-work
+	// puzzle instead of blocked
+	Challenge ChallengeConfig `yaml:"challenge"`
+
+	// Clients can be tagged with a signed cookie so bot detection follows
+	// each one rather than only its address
+	ClientTracking ClientTrackingConfig `yaml:"client_tracking"`
+
+	// Requests carrying a session cookie or bearer token can be analyzed
+	// per identity as well as per address
+	Identity IdentityConfig `yaml:"identity"`
+}
+
+type ChallengeConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Mode          string   `yaml:"mode"`
+	PowDifficulty int      `yaml:"pow_difficulty"`
+	RequireAll    bool     `yaml:"require_all"`
+	Path          string   `yaml:"path"`
+	Cookie        string   `yaml:"cookie"`
+	Secret        string   `yaml:"secret"`
+	PassDuration  Duration `yaml:"pass_duration"`
+	MaxConfidence float64  `yaml:"max_confidence"`
+}
+
+type ClientTrackingConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Cookie  string   `yaml:"cookie"`
+	Secret  string   `yaml:"secret"`
+	MaxAge  Duration `yaml:"max_age"`
+}
+
+type IdentityConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	SessionCookies []string `yaml:"session_cookies"`
+	BearerTokens   bool     `yaml:"bearer_tokens"`
+}
+
+type SlowRequestsConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	HeaderTimeout     Duration `yaml:"header_timeout"`
+	HeaderDeadline    Duration `yaml:"header_deadline"`
+	BodyDeadline      Duration `yaml:"body_deadline"`
+	MinBodyRate       Size     `yaml:"min_body_rate"`
+	MaxSlow           int      `yaml:"max_slow"`
+	CheckInterval     Duration `yaml:"check_interval"`
+	BlacklistDuration Duration `yaml:"blacklist_duration"`
+}
+
+type AuthCorrelationConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	Window            Duration `yaml:"window"`
+	MinAccounts       int      `yaml:"min_accounts"`
+	MinFailureRatio   float64  `yaml:"min_failure_ratio"`
+	MaxEvents         int      `yaml:"max_events"`
+	MaxClients        int      `yaml:"max_clients"`
+	BlacklistDuration Duration `yaml:"blacklist_duration"`
+}
+
+type CompositeSignalsConfig struct {
+	Enabled        bool                    `yaml:"enabled"`
+	DatacenterASNs []uint32                `yaml:"datacenter_asns"`
+	Signals        []CompositeSignalConfig `yaml:"signals"`
+}
+
+type CompositeSignalConfig struct {
+	Name       string             `yaml:"name"`
+	Expression string             `yaml:"expression"`
+	Weights    map[string]float64 `yaml:"weights"`
+	Threshold  float64            `yaml:"threshold"`
+	Risk       int                `yaml:"risk"`
+}
+
+type AnomalyConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	ModelFile    string   `yaml:"model_file"`
+	Learn        bool     `yaml:"learn"`
+	Window       Duration `yaml:"window"`
+	MinRequests  int      `yaml:"min_requests"`
+	MinSamples   int64    `yaml:"min_samples"`
+	SaveInterval Duration `yaml:"save_interval"`
+}
+
+type StageTimeoutsConfig struct {
+	Enabled bool                          `yaml:"enabled"`
+	Stages  map[string]StageTimeoutConfig `yaml:"stages"`
+}
+
+type StageTimeoutConfig struct {
+	Timeout    Duration `yaml:"timeout"`
+	FailClosed bool     `yaml:"fail_closed"`
+}
+
+type RuleUpdatesConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	URL        string   `yaml:"url"`
+	PublicKeys []string `yaml:"public_keys"`
+	Interval   Duration `yaml:"interval"`
+	Timeout    Duration `yaml:"timeout"`
+	StateFile  string   `yaml:"state_file"`
+}
+
+type AttackCostConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	CPUPerRequest    Duration `yaml:"cpu_per_request"`
+	BytesPerResponse Size     `yaml:"bytes_per_response"`
+	History          int      `yaml:"history"`
+}
+
+type AttacksConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	MinDetections int      `yaml:"min_detections"`
+	QuietPeriod   Duration `yaml:"quiet_period"`
+	Retention     Duration `yaml:"retention"`
+	MaxAttacks    int      `yaml:"max_attacks"`
+}
+
+type ProxyProbeConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	RiskThreshold      int      `yaml:"risk_threshold"`
+	Ports              []string `yaml:"ports"`
+	Target             string   `yaml:"target"`
+	Timeout            Duration `yaml:"timeout"`
+	ProbesPerSecond    float64  `yaml:"probes_per_second"`
+	CacheTTL           Duration `yaml:"cache_ttl"`
+	BlacklistDuration  Duration `yaml:"blacklist_duration"`
+	ReputationPenalty  float64  `yaml:"reputation_penalty"`
+	ReputationDuration Duration `yaml:"reputation_duration"`
+}
+
+type IPv6AggregationConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	PrefixLength int  `yaml:"prefix_length"`
+}
+
+type CaptchaConfig struct {
+	Provider  string   `yaml:"provider"`
+	SiteKey   string   `yaml:"site_key"`
+	SecretKey string   `yaml:"secret_key"`
+	VerifyURL string   `yaml:"verify_url"`
+	Timeout   Duration `yaml:"timeout"`
+}
+
+type OverridesConfig struct {
+	PathGroups []PathGroupConfig `yaml:"path_groups"`
+	Layers     []OverrideConfig  `yaml:"layers"`
+}
+
+type PathGroupConfig struct {
+	Name  string   `yaml:"name"`
+	Paths []string `yaml:"paths"`
+}
+
+type OverrideConfig struct {
+	Tenant                  string           `yaml:"tenant"`
+	PathGroup               string           `yaml:"path_group"`
+	RateLimit               *RateLimitConfig `yaml:"rate_limit"`
+	RequestFilter           *bool            `yaml:"request_filter"`
+	DetectionThreshold      *float64         `yaml:"detection_threshold"`
+	AutoBlacklistConfidence *float64         `yaml:"auto_blacklist_confidence"`
+	Challenge               string           `yaml:"challenge"`
+	RequireChallenge        *bool            `yaml:"require_challenge"`
+	BotnetActions           []string         `yaml:"botnet_actions"`
+	InspectBody             *bool            `yaml:"inspect_body"`
+	FilterRuleSet           string           `yaml:"filter_rule_set"`
+	FilterRiskThreshold     *int             `yaml:"filter_risk_threshold"`
+}
+
+type BotnetConfig struct {
+	DetectionThreshold      float64             `yaml:"detection_threshold"`
+	AutoBlacklistConfidence float64             `yaml:"auto_blacklist_confidence"`
+	GeoIPDatabase           string              `yaml:"geoip_database"`
+	GeoIPReloadInterval     Duration            `yaml:"geoip_reload_interval"`
+	StateTTL                Duration            `yaml:"state_ttl"`
+	DecayHalfLife           Duration            `yaml:"decay_half_life"`
+	Aggregator              string              `yaml:"aggregator"`
+	Weights                 map[string]float64  `yaml:"weights"`
+	Scoring                 BotnetScoringConfig `yaml:"scoring"`
+	Sharing                 BotnetSharingConfig `yaml:"sharing"`
+	BadBots                 BadBotsConfig       `yaml:"bad_bots"`
+	Campaigns               CampaignsConfig     `yaml:"campaigns"`
+	Sampling                SamplingConfig      `yaml:"sampling"`
+}
+
+type SamplingConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	Rate     float64  `yaml:"rate"`
+	MinRPS   int64    `yaml:"min_rps"`
+	TTL      Duration `yaml:"ttl"`
+	Capacity int      `yaml:"capacity"`
+}
+
+type CampaignsConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Interval   Duration `yaml:"interval"`
+	MinClients int      `yaml:"min_clients"`
+}
+
+type BadBotsConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	DisableBuiltin  bool     `yaml:"disable_builtin"`
+	URL             string   `yaml:"url"`
+	RefreshInterval Duration `yaml:"refresh_interval"`
+	Timeout         Duration `yaml:"timeout"`
+}
+
+type BotnetSharingConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	SyncInterval Duration `yaml:"sync_interval"`
+}
+
+type BotnetScoringConfig struct {
+	RiskDivisor        *float64                    `yaml:"risk_divisor"`
+	IndicatorBonus     *float64                    `yaml:"indicator_bonus"`
+	OverrideRisk       *int                        `yaml:"override_risk"`
+	OverrideConfidence *float64                    `yaml:"override_confidence"`
+	Rules              map[string]BotnetRuleConfig `yaml:"rules"`
+}
+
+type BotnetRuleConfig struct {
+	Enabled   *bool    `yaml:"enabled"`
+	Weight    *int     `yaml:"weight"`
+	Threshold *float64 `yaml:"threshold"`
+}
+
+type BotPolicyConfig struct {
+	Enabled    bool                         `yaml:"enabled"`
+	Bands      []BotBandConfig              `yaml:"bands"`
+	PathGroups []BotPathGroupConfig         `yaml:"path_groups"`
+	Matrix     map[string]map[string]string `yaml:"matrix"`
+}
+
+type BotBandConfig struct {
+	Name          string  `yaml:"name"`
+	MinConfidence float64 `yaml:"min_confidence"`
+}
+
+type BotPathGroupConfig struct {
+	Name  string   `yaml:"name"`
+	Paths []string `yaml:"paths"`
+}
+
+type CardinalityConfig struct {
+	MaxUserAgents      int `yaml:"max_user_agents"`
+	MaxPaths           int `yaml:"max_paths"`
+	MaxValuesPerIP     int `yaml:"max_values_per_ip"`
+	MaxValueLength     int `yaml:"max_value_length"`
+	MaxTrackedIPs      int `yaml:"max_tracked_ips"`
+	MaxTLSFingerprints int `yaml:"max_tls_fingerprints"`
+	MaxBotnetClients   int `yaml:"max_botnet_clients"`
+	MaxBotnetNetworks  int `yaml:"max_botnet_networks"`
+}
+
+type ReputationConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	HalfLife       Duration          `yaml:"half_life"`
+	BlockBelow     int               `yaml:"block_below"`
+	ChallengeBelow int               `yaml:"challenge_below"`
+	FlushInterval  Duration          `yaml:"flush_interval"`
+	Weights        ReputationWeights `yaml:"weights"`
+}
+
+type ReputationWeights struct {
+	Risk   float64 `yaml:"risk"`
+	Botnet float64 `yaml:"botnet"`
+	Errors float64 `yaml:"errors"`
+	Feed   float64 `yaml:"feed"`
 }
 
 type RateLimitConfig struct {
-	RequestsPerMinute int `yaml:"requests_per_minute"`
-	BurstSize         int `yaml:"burst_size"`
-	WindowSize        int `yaml:"window_size"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+	BurstSize         int      `yaml:"burst_size"`
+	WindowSize        Duration `yaml:"window_size"`
+}
+
+type BurstDetectionConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Windows []BurstWindow `yaml:"windows"`
+}
+
+type BurstWindow struct {
+	Seconds   Duration `yaml:"seconds"`
+	Threshold int      `yaml:"threshold"`
+	Cap       int      `yaml:"cap"`
 }
 
 type IPBlacklistConfig struct {
-	Enabled                bool     `yaml:"enabled"`
-	AutoBlacklistThreshold int      `yaml:"auto_blacklist_threshold"`
-	BlacklistDuration      int      `yaml:"blacklist_duration"`
-	IPs                    []string `yaml:"ips"`
+	Enabled                bool                   `yaml:"enabled"`
+	AutoBlacklistThreshold int                    `yaml:"auto_blacklist_threshold"`
+	BlacklistDuration      Duration               `yaml:"blacklist_duration"`
+	IPv6PrefixLength       int                    `yaml:"ipv6_prefix_length"`
+	MaxEntries             int                    `yaml:"max_entries"`
+	Eviction               string                 `yaml:"eviction"`
+	SnapshotFile           string                 `yaml:"snapshot_file"`
+	SnapshotInterval       Duration               `yaml:"snapshot_interval"`
+	IPs                    []string               `yaml:"ips"`
+	Greylist               GreylistConfig         `yaml:"greylist"`
+	Escalation             EscalationConfig       `yaml:"escalation"`
+	SubnetEscalation       SubnetEscalationConfig `yaml:"subnet_escalation"`
+	Filter                 BanFilterConfig        `yaml:"filter"`
+	Enforcement            EnforcementConfig      `yaml:"enforcement"`
+	Appeals                AppealsConfig          `yaml:"appeals"`
+	Edge                   EdgeConfig             `yaml:"edge"`
+	AWSWAF                 AWSWAFConfig           `yaml:"aws_waf"`
+}
+
+type AWSWAFConfig struct {
+	Enabled           bool       `yaml:"enabled"`
+	Region            string     `yaml:"region"`
+	Scope             string     `yaml:"scope"`
+	IPv4SetName       string     `yaml:"ipv4_set_name"`
+	IPv4SetID         string     `yaml:"ipv4_set_id"`
+	IPv6SetName       string     `yaml:"ipv6_set_name"`
+	IPv6SetID         string     `yaml:"ipv6_set_id"`
+	Sources           []string   `yaml:"sources"`
+	MaxAddresses      int        `yaml:"max_addresses"`
+	ReconcileInterval Duration   `yaml:"reconcile_interval"`
+	BatchDelayMs      DurationMs `yaml:"batch_delay_ms"`
+	Timeout           Duration   `yaml:"timeout"`
+	Endpoint          string     `yaml:"endpoint"`
+	AccessKeyID       string     `yaml:"access_key_id"`
+	SecretAccessKey   string     `yaml:"secret_access_key"`
+	SessionToken      string     `yaml:"session_token"`
+}
+
+type EdgeConfig struct {
+	Enabled           bool                 `yaml:"enabled"`
+	Provider          string               `yaml:"provider"`
+	Sources           []string             `yaml:"sources"`
+	Note              string               `yaml:"note"`
+	RequestsPerSecond float64              `yaml:"requests_per_second"`
+	MaxRules          int                  `yaml:"max_rules"`
+	ReconcileInterval Duration             `yaml:"reconcile_interval"`
+	BatchDelayMs      DurationMs           `yaml:"batch_delay_ms"`
+	Timeout           Duration             `yaml:"timeout"`
+	Cloudflare        CloudflareEdgeConfig `yaml:"cloudflare"`
+	HTTP              HTTPEdgeConfig       `yaml:"http"`
+}
+
+type CloudflareEdgeConfig struct {
+	APIToken  string `yaml:"api_token"`
+	ZoneID    string `yaml:"zone_id"`
+	AccountID string `yaml:"account_id"`
+	Mode      string `yaml:"mode"`
+	BaseURL   string `yaml:"base_url"`
+}
+
+type HTTPEdgeConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+type AppealsConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	Path              string   `yaml:"path"`
+	Secret            string   `yaml:"secret"`
+	TokenTTL          Duration `yaml:"token_ttl"`
+	WhitelistDuration Duration `yaml:"whitelist_duration"`
+	MaxPerIP          int      `yaml:"max_per_ip"`
+	Window            Duration `yaml:"window"`
+}
+
+type SubnetEscalationConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Threshold  int      `yaml:"threshold"`
+	Window     Duration `yaml:"window"`
+	Duration   Duration `yaml:"duration"`
+	IPv4Prefix int      `yaml:"ipv4_prefix"`
+	IPv6Prefix int      `yaml:"ipv6_prefix"`
+}
+
+type BanFilterConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	ExpectedEntries   int      `yaml:"expected_entries"`
+	FalsePositiveRate float64  `yaml:"false_positive_rate"`
+	RefreshInterval   Duration `yaml:"refresh_interval"`
+}
+
+type EscalationConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Multiplier  float64  `yaml:"multiplier"`
+	MaxDuration Duration `yaml:"max_duration"`
+	ResetAfter  Duration `yaml:"reset_after"`
+}
+
+type EnforcementConfig struct {
+	Enabled           bool       `yaml:"enabled"`
+	Driver            string     `yaml:"driver"`
+	Table             string     `yaml:"table"`
+	ManageRules       bool       `yaml:"manage_rules"`
+	ReconcileInterval Duration   `yaml:"reconcile_interval"`
+	BatchDelayMs      DurationMs `yaml:"batch_delay_ms"`
+}
+
+type GreylistConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	Action            string   `yaml:"action"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+	BurstSize         int      `yaml:"burst_size"`
+	PromoteAfter      int      `yaml:"promote_after"`
+	QuietPeriod       Duration `yaml:"quiet_period"`
 }
 
 type IPWhitelistConfig struct {
@@ -53,10 +614,118 @@ type IPWhitelistConfig struct {
 }
 
 type RequestFilterConfig struct {
-	Enabled              bool     `yaml:"enabled"`
-	MaxRequestSize       int64    `yaml:"max_request_size"`
-	SuspiciousHeaders    []string `yaml:"suspicious_headers"`
-	BlockedUserAgents    []string `yaml:"blocked_user_agents"`
+	Enabled           bool     `yaml:"enabled"`
+	MaxRequestSize    Size     `yaml:"max_request_size"`
+	SuspiciousHeaders []string `yaml:"suspicious_headers"`
+	BlockedUserAgents []string `yaml:"blocked_user_agents"`
+
+	// Custom block and flag rules, reloaded when the file changes
+	RulesFile           string   `yaml:"rules_file"`
+	RulesReloadInterval Duration `yaml:"rules_reload_interval"`
+
+	// ModSecurity rule files, such as those of the OWASP Core Rule Set,
+	// loaded and reloaded along with the rules file
+	CRSFiles []string `yaml:"crs_files"`
+
+	// POST, PUT and PATCH bodies can be scanned for malicious patterns
+	InspectBody BodyInspectionConfig `yaml:"inspect_body"`
+
+	// JSON Schemas the bodies of requests to a route must match
+	Schemas []RouteSchemaConfig `yaml:"schemas"`
+
+	// Checks on the files multipart/form-data requests upload
+	Uploads UploadConfig `yaml:"uploads"`
+
+	// Sanity checks on request framing and headers
+	Protocol ProtocolConfig `yaml:"protocol"`
+
+	// Named selections of rules that overrides can apply to a route
+	RuleSets []RuleSetConfig `yaml:"rule_sets"`
+
+	// Actions of individual rules by ID: block, challenge, tarpit, flag or
+	// log
+	RuleActions map[string]string `yaml:"rule_actions"`
+
+	// Handling of the attack categories of the built-in patterns by name
+	Categories map[string]CategoryConfig `yaml:"categories"`
+}
+
+type CategoryConfig struct {
+	Action    string `yaml:"action"`
+	Score     int    `yaml:"score"`
+	Threshold int    `yaml:"threshold"`
+}
+
+type ResponseFilterConfig struct {
+	Enabled     bool                 `yaml:"enabled"`
+	MaxBodySize Size                 `yaml:"max_body_size"`
+	Mask        string               `yaml:"mask"`
+	RuleActions map[string]string    `yaml:"rule_actions"`
+	Rules       []ResponseRuleConfig `yaml:"rules"`
+}
+
+type ResponseRuleConfig struct {
+	ID       string `yaml:"id"`
+	Category string `yaml:"category"`
+	Pattern  string `yaml:"pattern"`
+	Action   string `yaml:"action"`
+}
+
+type RuleSetConfig struct {
+	Name    string   `yaml:"name"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type RouteSchemaConfig struct {
+	Name        string   `yaml:"name"`
+	Methods     []string `yaml:"methods"`
+	Paths       []string `yaml:"paths"`
+	File        string   `yaml:"file"`
+	Action      string   `yaml:"action"`
+	Severity    string   `yaml:"severity"`
+	MaxBodySize Size     `yaml:"max_body_size"`
+}
+
+type UploadConfig struct {
+	Enabled           bool                `yaml:"enabled"`
+	MaxFileSize       Size                `yaml:"max_file_size"`
+	MaxFiles          int                 `yaml:"max_files"`
+	AllowedExtensions []string            `yaml:"allowed_extensions"`
+	AllowedTypes      []string            `yaml:"allowed_types"`
+	VerifyMagic       bool                `yaml:"verify_magic"`
+	Action            string              `yaml:"action"`
+	Severity          string              `yaml:"severity"`
+	Scanner           UploadScannerConfig `yaml:"scanner"`
+}
+
+// UploadScannerConfig selects an external scanner for uploaded files: a
+// clamd daemon at a host:port or unix:/path address, or an ICAP service at
+// an icap:// URL
+type UploadScannerConfig struct {
+	Type       string   `yaml:"type"`
+	Address    string   `yaml:"address"`
+	Timeout    Duration `yaml:"timeout"`
+	FailClosed bool     `yaml:"fail_closed"`
+}
+
+type ProtocolConfig struct {
+	Enabled        bool                           `yaml:"enabled"`
+	MaxHeaders     int                            `yaml:"max_headers"`
+	MaxHeaderSize  Size                           `yaml:"max_header_size"`
+	MaxHeadersSize Size                           `yaml:"max_headers_size"`
+	AllowedHeaders []string                       `yaml:"allowed_headers"`
+	Checks         map[string]ProtocolCheckConfig `yaml:"checks"`
+}
+
+type ProtocolCheckConfig struct {
+	Action   string `yaml:"action"`
+	Severity string `yaml:"severity"`
+}
+
+type BodyInspectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxSize Size `yaml:"max_size"`
 }
 
 type MonitoringConfig struct {
@@ -66,9 +735,175 @@ type MonitoringConfig struct {
 }
 
 type HealthCheckConfig struct {
-	Enabled       bool `yaml:"enabled"`
-	Timeout       int  `yaml:"timeout"`
-	CheckInterval int  `yaml:"check_interval"`
+	Enabled       bool     `yaml:"enabled"`
+	Timeout       Duration `yaml:"timeout"`
+	CheckInterval Duration `yaml:"check_interval"`
+}
+
+type ProbationConfig struct {
+	Enabled              bool          `yaml:"enabled"`
+	Period               Duration      `yaml:"period"`
+	MaxFalsePositiveRate float64       `yaml:"max_false_positive_rate"`
+	MinSamples           int64         `yaml:"min_samples"`
+	Rollout              RolloutConfig `yaml:"rollout"`
+}
+
+type RolloutConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Steps        []int    `yaml:"steps"`
+	StepInterval Duration `yaml:"step_interval"`
+}
+
+type KillSwitchConfig struct {
+	SyncInterval Duration `yaml:"sync_interval"`
+}
+
+type ASNConfig struct {
+	Enabled  bool            `yaml:"enabled"`
+	Database string          `yaml:"database"`
+	Rules    []ASNRuleConfig `yaml:"rules"`
+}
+
+type ASNRuleConfig struct {
+	ASN               uint32 `yaml:"asn"`
+	Action            string `yaml:"action"`
+	RequestsPerMinute int    `yaml:"requests_per_minute"`
+	Reason            string `yaml:"reason"`
+}
+
+type ForecastingConfig struct {
+	Enabled                   bool               `yaml:"enabled"`
+	BucketInterval            Duration           `yaml:"bucket_interval"`
+	SeasonLength              int                `yaml:"season_length"`
+	Horizon                   int                `yaml:"horizon"`
+	Alpha                     float64            `yaml:"alpha"`
+	Beta                      float64            `yaml:"beta"`
+	Gamma                     float64            `yaml:"gamma"`
+	RiskRatio                 float64            `yaml:"risk_ratio"`
+	DefaultCapacity           float64            `yaml:"default_capacity"`
+	TenantCapacity            map[string]float64 `yaml:"tenant_capacity"`
+	MitigationRateLimitFactor float64            `yaml:"mitigation_rate_limit_factor"`
+}
+
+type SLAConfig struct {
+	Enabled          bool        `yaml:"enabled"`
+	ShortWindow      Duration    `yaml:"short_window"`
+	LongWindow       Duration    `yaml:"long_window"`
+	BurnThreshold    float64     `yaml:"burn_threshold"`
+	MinRequests      int         `yaml:"min_requests"`
+	ShedDuringAttack bool        `yaml:"shed_during_attack"`
+	Objectives       []SLOConfig `yaml:"objectives"`
+}
+
+type SLOConfig struct {
+	Name      string     `yaml:"name"`
+	Paths     []string   `yaml:"paths"`
+	LatencyMs DurationMs `yaml:"latency_ms"`
+	Target    float64    `yaml:"target"`
+	Priority  int        `yaml:"priority"`
+}
+
+type GeoConfig struct {
+	Enabled            bool             `yaml:"enabled"`
+	Database           string           `yaml:"database"`
+	ReloadInterval     Duration         `yaml:"reload_interval"`
+	BlockCountries     []string         `yaml:"block_countries"`
+	ChallengeCountries []string         `yaml:"challenge_countries"`
+	AllowCountries     []string         `yaml:"allow_countries"`
+	UnknownAction      string           `yaml:"unknown_action"`
+	Fences             []GeoFenceConfig `yaml:"fences"`
+}
+
+type AccessConfig struct {
+	IdentityHeader string             `yaml:"identity_header"`
+	Rules          []AccessRuleConfig `yaml:"rules"`
+}
+
+type AccessRuleConfig struct {
+	Name       string   `yaml:"name"`
+	Paths      []string `yaml:"paths"`
+	Networks   []string `yaml:"networks"`
+	Countries  []string `yaml:"countries"`
+	ASNs       []uint32 `yaml:"asns"`
+	Identities []string `yaml:"identities"`
+	Action     string   `yaml:"action"`
+}
+
+type GeoFenceConfig struct {
+	Name               string            `yaml:"name"`
+	Paths              []string          `yaml:"paths"`
+	BlockCountries     []string          `yaml:"block_countries"`
+	ChallengeCountries []string          `yaml:"challenge_countries"`
+	AllowCountries     []string          `yaml:"allow_countries"`
+	UnknownAction      string            `yaml:"unknown_action"`
+	Schedule           GeoScheduleConfig `yaml:"schedule"`
+}
+
+type GeoScheduleConfig struct {
+	Days           []string `yaml:"days"`
+	Start          string   `yaml:"start"`
+	End            string   `yaml:"end"`
+	Timezone       string   `yaml:"timezone"`
+	DuringIncident bool     `yaml:"during_incident"`
+}
+
+type DNSBLConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Zones     []string `yaml:"zones"`
+	Action    string   `yaml:"action"`
+	RiskScore int      `yaml:"risk_score"`
+	Timeout   Duration `yaml:"timeout"`
+	CacheTTL  Duration `yaml:"cache_ttl"`
+	Wait      bool     `yaml:"wait"`
+}
+
+type MethodsConfig struct {
+	Limits        map[string]RateLimitConfig `yaml:"limits"`
+	AnswerOptions bool                       `yaml:"answer_options"`
+	AllowMethods  []string                   `yaml:"allow_methods"`
+	AllowHeaders  []string                   `yaml:"allow_headers"`
+	AllowOrigin   string                     `yaml:"allow_origin"`
+	MaxAge        Duration                   `yaml:"max_age"`
+}
+
+type MonitorAgentConfig struct {
+	Name       string   `yaml:"name"`
+	Networks   []string `yaml:"networks"`
+	UserAgents []string `yaml:"user_agents"`
+}
+
+type CrawlersConfig struct {
+	Enabled           bool                   `yaml:"enabled"`
+	Wait              bool                   `yaml:"wait"`
+	Timeout           Duration               `yaml:"timeout"`
+	CacheTTL          Duration               `yaml:"cache_ttl"`
+	WhitelistDuration Duration               `yaml:"whitelist_duration"`
+	ImpostorAction    string                 `yaml:"impostor_action"`
+	ImpostorRiskScore int                    `yaml:"impostor_risk_score"`
+	Profiles          []CrawlerProfileConfig `yaml:"profiles"`
+}
+
+type SlowdownConfig struct {
+	Enabled       bool       `yaml:"enabled"`
+	RiskThreshold int        `yaml:"risk_threshold"`
+	BaseDelayMs   DurationMs `yaml:"base_delay_ms"`
+	MaxDelayMs    DurationMs `yaml:"max_delay_ms"`
+	Jitter        float64    `yaml:"jitter"`
+	Window        Duration   `yaml:"window"`
+	MaxPending    int        `yaml:"max_pending"`
+}
+
+type VerdictCacheConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	MaxTTL       Duration `yaml:"max_ttl"`
+	MinRemaining Duration `yaml:"min_remaining"`
+	Capacity     int      `yaml:"capacity"`
+}
+
+type CrawlerProfileConfig struct {
+	Name       string   `yaml:"name"`
+	UserAgents []string `yaml:"user_agents"`
+	Domains    []string `yaml:"domains"`
 }
 
 type LoggingConfig struct {
@@ -91,11 +926,21 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 
 	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	if err := decode(data, &config); err != nil {
 		return nil, err
 	}
 
+	// A preset supplies defaults; settings in the file still take precedence
+	if preset := config.Protection.Preset; preset != "" {
+		config = Config{}
+		if err := ApplyPreset(&config, preset); err != nil {
+			return nil, err
+		}
+		if err := decode(data, &config); err != nil {
+			return nil, err
+		}
+	}
+
 	return &config, nil
 }
 
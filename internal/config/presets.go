@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Preset is a curated set of protection settings for a kind of deployment
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	apply       func(p *ProtectionConfig)
+}
+
+var presets = map[string]Preset{
+	"api-backend": {
+		Name:        "api-backend",
+		Description: "JSON APIs called by programs: generous rates, lenient bot scoring since clients never load JS or CSS",
+		apply: func(p *ProtectionConfig) {
+			p.RateLimit = RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100, WindowSize: Duration(time.Minute)}
+			p.RequestFilter.Enabled = true
+			p.RequestFilter.MaxRequestSize = 1 << 20
+			p.Botnet = BotnetConfig{DetectionThreshold: 0.9, AutoBlacklistConfidence: 0.95}
+			setGreylist(p, "rate_limit", 30, 5)
+			p.Methods.AnswerOptions = true
+		},
+	},
+	"ecommerce-web": {
+		Name:        "ecommerce-web",
+		Description: "Browser-facing shops: moderate rates, stricter bot scoring, suspicious clients are challenged",
+		apply: func(p *ProtectionConfig) {
+			p.RateLimit = RateLimitConfig{RequestsPerMinute: 300, BurstSize: 50, WindowSize: Duration(time.Minute)}
+			p.RequestFilter.Enabled = true
+			p.RequestFilter.MaxRequestSize = 2 << 20
+			p.Botnet = BotnetConfig{DetectionThreshold: 0.7, AutoBlacklistConfidence: 0.85}
+			setGreylist(p, "challenge", 20, 3)
+			p.Methods.AnswerOptions = true
+		},
+	},
+	"static-site": {
+		Name:        "static-site",
+		Description: "Static content behind a CDN: high rates for asset fetches, small request bodies",
+		apply: func(p *ProtectionConfig) {
+			p.RateLimit = RateLimitConfig{RequestsPerMinute: 1200, BurstSize: 200, WindowSize: Duration(time.Minute)}
+			p.RequestFilter.Enabled = true
+			p.RequestFilter.MaxRequestSize = 64 << 10
+			p.Botnet = BotnetConfig{DetectionThreshold: 0.9, AutoBlacklistConfidence: 0.95}
+			setGreylist(p, "rate_limit", 60, 10)
+			p.Methods.AnswerOptions = true
+		},
+	},
+	"under-attack": {
+		Name:        "under-attack",
+		Description: "Emergency mode: tight rates, aggressive bot scoring, quick promotion from greylist to blacklist",
+		apply: func(p *ProtectionConfig) {
+			p.RateLimit = RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10, WindowSize: Duration(time.Minute)}
+			p.RequestFilter.Enabled = true
+			p.RequestFilter.MaxRequestSize = 256 << 10
+			p.Botnet = BotnetConfig{DetectionThreshold: 0.5, AutoBlacklistConfidence: 0.7}
+			setGreylist(p, "challenge", 5, 2)
+			p.IPBlacklist.Greylist.PromoteAfter = 2
+			p.Methods.AnswerOptions = true
+			p.Methods.Limits = map[string]RateLimitConfig{
+				"HEAD":    {RequestsPerMinute: 30, BurstSize: 5},
+				"OPTIONS": {RequestsPerMinute: 30, BurstSize: 5},
+			}
+		},
+	},
+}
+
+func setGreylist(p *ProtectionConfig, action string, requestsPerMinute, burst int) {
+	g := &p.IPBlacklist.Greylist
+	g.Enabled = true
+	g.Action = action
+	g.RequestsPerMinute = requestsPerMinute
+	g.BurstSize = burst
+	if g.PromoteAfter == 0 {
+		g.PromoteAfter = 5
+	}
+	if g.QuietPeriod.Duration() == 0 {
+		g.QuietPeriod = Duration(30 * time.Minute)
+	}
+}
+
+// Presets returns the built-in presets sorted by name
+func Presets() []Preset {
+	result := make([]Preset, 0, len(presets))
+	for _, preset := range presets {
+		result = append(result, preset)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ApplyPreset overwrites the settings covered by the named preset
+func ApplyPreset(cfg *Config, name string) error {
+	preset, exists := presets[name]
+	if !exists {
+		return fmt.Errorf("unknown preset: %s", name)
+	}
+
+	preset.apply(&cfg.Protection)
+	cfg.Protection.Preset = name
+	return nil
+}
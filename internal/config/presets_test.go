@@ -0,0 +1,36 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigPresetDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte("protection:\n  preset: under-attack\n  rate_limit:\n    requests_per_minute: 90\n")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if cfg.Protection.RateLimit.RequestsPerMinute != 90 {
+		t.Errorf("explicit setting should override preset, got %d req/min", cfg.Protection.RateLimit.RequestsPerMinute)
+	}
+	if cfg.Protection.RateLimit.BurstSize != 10 {
+		t.Errorf("expected preset burst size 10, got %d", cfg.Protection.RateLimit.BurstSize)
+	}
+	if cfg.Protection.Botnet.DetectionThreshold != 0.5 {
+		t.Errorf("expected preset detection threshold 0.5, got %v", cfg.Protection.Botnet.DetectionThreshold)
+	}
+}
+
+func TestApplyUnknownPreset(t *testing.T) {
+	if err := ApplyPreset(&Config{}, "nope"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
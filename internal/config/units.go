@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a length of time written as a Go duration such as "500ms",
+// "2h" or "1h30m". A bare number is a count of seconds, the unit durations
+// were written in before they were typed.
+type Duration time.Duration
+
+// DurationMs is a Duration whose bare numbers are milliseconds, for the
+// keys ending in _ms
+type DurationMs time.Duration
+
+// Size is a number of bytes, written as a bare number or with a unit such
+// as "512KiB" or "10MB". KB, MB and GB are powers of 1000; KiB, MiB and GiB
+// are powers of 1024.
+type Size int64
+
+// Duration returns d as a time.Duration
+func (d Duration) Duration() time.Duration { return time.Duration(d) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+// UnmarshalYAML accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	v, err := parseDuration(value, time.Second)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) { return d.String(), nil }
+
+func (d Duration) MarshalJSON() ([]byte, error) { return []byte(strconv.Quote(d.String())), nil }
+
+// Duration returns d as a time.Duration
+func (d DurationMs) Duration() time.Duration { return time.Duration(d) }
+
+func (d DurationMs) String() string { return time.Duration(d).String() }
+
+// UnmarshalYAML accepts a duration string or a number of milliseconds
+func (d *DurationMs) UnmarshalYAML(value *yaml.Node) error {
+	v, err := parseDuration(value, time.Millisecond)
+	*d = DurationMs(v)
+	return err
+}
+
+func (d DurationMs) MarshalYAML() (interface{}, error) { return d.String(), nil }
+
+func (d DurationMs) MarshalJSON() ([]byte, error) { return []byte(strconv.Quote(d.String())), nil }
+
+// UnmarshalYAML accepts a number of bytes or a size with a unit
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	v, err := ParseSize(value.Value)
+	if err != nil || value.Kind != yaml.ScalarNode {
+		return &unitError{node: value, msg: fmt.Sprintf("invalid size %q: want bytes or a size like \"10MB\"", value.Value)}
+	}
+	*s = Size(v)
+	return nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	// Longest suffixes first, so "KiB" is not read as "B"
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseSize parses a number of bytes with an optional unit
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	scale := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSpace(strings.TrimSuffix(lower, u.suffix))
+			scale = u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || n*float64(scale) > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(scale)), nil
+}
+
+// parseDuration reads a duration string, or a bare number in unit
+func parseDuration(value *yaml.Node, unit time.Duration) (time.Duration, error) {
+	invalid := &unitError{node: value, msg: fmt.Sprintf("invalid duration %q: want a duration like \"30s\" or \"2h\", or a number of %s",
+		value.Value, map[time.Duration]string{time.Second: "seconds", time.Millisecond: "milliseconds"}[unit])}
+	if value.Kind != yaml.ScalarNode {
+		return 0, invalid
+	}
+	if n, err := strconv.ParseFloat(value.Value, 64); err == nil {
+		if n < 0 || n*float64(unit) > math.MaxInt64 {
+			return 0, invalid
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	d, err := time.ParseDuration(value.Value)
+	if err != nil || d < 0 {
+		return 0, invalid
+	}
+	return d, nil
+}
+
+// unitError is an invalid duration or size. It keeps the offending node so
+// the error can name its key.
+type unitError struct {
+	node *yaml.Node
+	msg  string
+}
+
+func (e *unitError) Error() string { return e.msg }
+
+// decode unmarshals a YAML document into out. Invalid durations and sizes
+// are reported with the path of their key, e.g.
+// "protection.dnsbl.timeout: invalid duration".
+func decode(data []byte, out interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		return nil
+	}
+
+	err := doc.Decode(out)
+	var ue *unitError
+	if errors.As(err, &ue) {
+		if path := keyPath(&doc, ue.node, ""); path != "" {
+			return fmt.Errorf("%s: %s (line %d)", path, ue.msg, ue.node.Line)
+		}
+		return fmt.Errorf("line %d: %s", ue.node.Line, ue.msg)
+	}
+	return err
+}
+
+// keyPath returns the dotted path of target under n, or "" if it is not
+// there
+func keyPath(n, target *yaml.Node, prefix string) string {
+	if n == target {
+		return prefix
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			if path := keyPath(c, target, prefix); path != "" {
+				return path
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if path := keyPath(n.Content[i+1], target, key); path != "" {
+				return path
+			}
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			if path := keyPath(c, target, fmt.Sprintf("%s[%d]", prefix, i)); path != "" {
+				return path
+			}
+		}
+	}
+	return ""
+}
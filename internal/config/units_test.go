@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadString(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigUnits(t *testing.T) {
+	cfg, err := loadString(t, `
+protection:
+  dnsbl:
+    timeout: 500ms
+    cache_ttl: 3600
+  slowdown:
+    base_delay_ms: 250
+    max_delay_ms: 8s
+  request_filter:
+    max_request_size: 10MiB
+  burst_detection:
+    windows:
+      - seconds: 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := cfg.Protection
+	if p.DNSBL.Timeout.Duration() != 500*time.Millisecond || p.DNSBL.CacheTTL.Duration() != time.Hour {
+		t.Errorf("dnsbl = %v, %v", p.DNSBL.Timeout, p.DNSBL.CacheTTL)
+	}
+	if p.Slowdown.BaseDelayMs.Duration() != 250*time.Millisecond || p.Slowdown.MaxDelayMs.Duration() != 8*time.Second {
+		t.Errorf("slowdown = %v, %v", p.Slowdown.BaseDelayMs, p.Slowdown.MaxDelayMs)
+	}
+	if p.RequestFilter.MaxRequestSize != 10<<20 {
+		t.Errorf("max_request_size = %d", p.RequestFilter.MaxRequestSize)
+	}
+	if p.BurstDetection.Windows[0].Seconds.Duration() != time.Second {
+		t.Errorf("burst window = %v", p.BurstDetection.Windows[0].Seconds)
+	}
+}
+
+func TestLoadConfigUnitErrorsNameKey(t *testing.T) {
+	for data, key := range map[string]string{
+		"protection:\n  dnsbl:\n    timeout: 2 minutes\n":                      "protection.dnsbl.timeout",
+		"protection:\n  burst_detection:\n    windows:\n      - seconds: -1\n": "protection.burst_detection.windows[0].seconds",
+		"protection:\n  request_filter:\n    max_request_size: 10XB\n":         "protection.request_filter.max_request_size",
+	} {
+		_, err := loadString(t, data)
+		if err == nil || !strings.HasPrefix(err.Error(), key+": ") {
+			t.Errorf("error = %v, want it to name %s", err, key)
+		}
+	}
+}
+
+func TestLoadSampleConfig(t *testing.T) {
+	if _, err := LoadConfig("../../config.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"512": 512, "64KiB": 64 << 10, "10MB": 10_000_000, "1.5 GiB": 3 << 29, "2b": 2} {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+}
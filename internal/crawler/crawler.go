@@ -0,0 +1,294 @@
+// Package crawler verifies clients claiming to be search engine crawlers.
+// A user agent is trivially spoofed, so a claim is only trusted when the
+// client's address reverse-resolves to a host in the search engine's domain
+// and that host resolves forward to the same address.
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/ttlcache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Action is what to do with a client falsely claiming to be a crawler
+type Action string
+
+const (
+	ActionBlock Action = "block"
+	ActionScore Action = "score"
+	ActionLog   Action = "log"
+)
+
+// ErrNotClaimed is returned when a user agent claims no known crawler
+var ErrNotClaimed = errors.New("user agent does not claim a known crawler")
+
+// maxCacheEntries bounds the result cache; expired entries are dropped
+// first, then the oldest
+const maxCacheEntries = 100000
+
+var verifyCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_crawler_verifications_total",
+	Help: "Crawler verifications by crawler and result",
+}, []string{"crawler", "result"})
+
+// Resolver is the subset of net.Resolver used for verification
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Profile describes a crawler by the user agents it sends and the domains
+// its hosts live in
+type Profile struct {
+	Name       string
+	UserAgents []*regexp.Regexp
+	Domains    []string
+}
+
+// NewProfile builds a profile from case-insensitive user agent patterns and
+// domains such as "googlebot.com"
+func NewProfile(name string, userAgents, domains []string) (*Profile, error) {
+	if len(userAgents) == 0 || len(domains) == 0 {
+		return nil, fmt.Errorf("crawler %s needs both user agents and domains", name)
+	}
+
+	p := &Profile{Name: name}
+	for _, ua := range userAgents {
+		re, err := regexp.Compile("(?i)" + ua)
+		if err != nil {
+			return nil, fmt.Errorf("crawler %s: invalid user agent pattern %q: %v", name, ua, err)
+		}
+		p.UserAgents = append(p.UserAgents, re)
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+		if domain == "" {
+			return nil, fmt.Errorf("crawler %s: empty domain", name)
+		}
+		p.Domains = append(p.Domains, domain)
+	}
+	return p, nil
+}
+
+// DefaultProfiles returns the search engine crawlers verified when none are
+// configured, using the domains Google and Microsoft document for
+// reverse DNS verification
+func DefaultProfiles() []*Profile {
+	google, _ := NewProfile("googlebot",
+		[]string{`Googlebot`, `Google-InspectionTool`, `GoogleOther`, `AdsBot-Google`, `Mediapartners-Google`},
+		[]string{"googlebot.com", "google.com", "googleusercontent.com"})
+	bing, _ := NewProfile("bingbot",
+		[]string{`bingbot`, `BingPreview`, `adidxbot`, `msnbot`},
+		[]string{"search.msn.com"})
+	return []*Profile{google, bing}
+}
+
+// claims reports whether userAgent claims to be this crawler
+func (p *Profile) claims(userAgent string) bool {
+	for _, re := range p.UserAgents {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// owns reports whether host lies within one of the crawler's domains
+func (p *Profile) owns(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range p.Domains {
+		if strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is the outcome of verifying a crawler claim
+type Result struct {
+	Crawler   string    `json:"crawler"`
+	Verified  bool      `json:"verified"`
+	Hostname  string    `json:"hostname,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Verifier checks crawler claims with reverse-then-forward DNS and caches
+// the answers
+type Verifier struct {
+	profiles   []*Profile
+	resolver   Resolver
+	timeout    time.Duration
+	onVerified func(ip string, result Result)
+	cache      *ttlcache.Cache[Result]
+	inflight   map[string]bool
+	mu         sync.Mutex
+}
+
+// NewVerifier creates a verifier for the given profiles. onVerified, if not
+// nil, is called after each lookup that verifies a crawler. A nil resolver
+// uses the system resolver.
+func NewVerifier(profiles []*Profile, resolver Resolver, timeout, cacheTTL time.Duration, onVerified func(ip string, result Result)) *Verifier {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Verifier{
+		profiles:   profiles,
+		resolver:   resolver,
+		timeout:    timeout,
+		onVerified: onVerified,
+		cache:      ttlcache.New[Result](cacheTTL, maxCacheEntries),
+		inflight:   make(map[string]bool),
+	}
+}
+
+// Claimed returns the crawler a user agent claims to be, or nil
+func (v *Verifier) Claimed(userAgent string) *Profile {
+	for _, p := range v.profiles {
+		if p.claims(userAgent) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Names returns the profile names
+func (v *Verifier) Names() []string {
+	names := make([]string, len(v.profiles))
+	for i, p := range v.profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
+func cacheKey(ip string, p *Profile) string {
+	return p.Name + "|" + ip
+}
+
+// Cached returns the cached result of verifying ip as crawler p, if any
+func (v *Verifier) Cached(ip string, p *Profile) (Result, bool) {
+	return v.cache.Get(cacheKey(ip, p), time.Now())
+}
+
+// Verify checks whether ip belongs to crawler p: one of its PTR names must
+// lie within the crawler's domains and resolve back to ip. Lookups that
+// fail for reasons other than a missing record are not cached.
+func (v *Verifier) Verify(ctx context.Context, ip string, p *Profile) (Result, error) {
+	if result, ok := v.Cached(ip, p); ok {
+		return result, nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid IP address: %s", ip)
+	}
+	addr = addr.Unmap()
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	result := Result{Crawler: p.Name, CheckedAt: time.Now()}
+	names, err := v.resolver.LookupAddr(ctx, addr.String())
+	if err != nil && !isNotFound(err) {
+		verifyCounter.WithLabelValues(p.Name, "error").Inc()
+		return result, err
+	}
+
+	for _, name := range names {
+		if !p.owns(name) {
+			continue
+		}
+		ok, err := v.resolvesTo(ctx, name, addr)
+		if err != nil {
+			verifyCounter.WithLabelValues(p.Name, "error").Inc()
+			return result, err
+		}
+		if ok {
+			result.Verified = true
+			result.Hostname = strings.TrimSuffix(name, ".")
+			break
+		}
+	}
+
+	if result.Verified {
+		verifyCounter.WithLabelValues(p.Name, "verified").Inc()
+	} else {
+		verifyCounter.WithLabelValues(p.Name, "impostor").Inc()
+	}
+	v.store(cacheKey(ip, p), result)
+
+	if result.Verified && v.onVerified != nil {
+		v.onVerified(ip, result)
+	}
+	return result, nil
+}
+
+// VerifyAsync starts a background verification of ip as crawler p unless
+// one is already running or a result is cached
+func (v *Verifier) VerifyAsync(ip string, p *Profile) {
+	key := cacheKey(ip, p)
+
+	v.mu.Lock()
+	if v.inflight[key] {
+		v.mu.Unlock()
+		return
+	}
+	if _, cached := v.cache.Get(key, time.Now()); cached {
+		v.mu.Unlock()
+		return
+	}
+	v.inflight[key] = true
+	v.mu.Unlock()
+
+	go func() {
+		defer func() {
+			v.mu.Lock()
+			delete(v.inflight, key)
+			v.mu.Unlock()
+		}()
+		v.Verify(context.Background(), ip, p)
+	}()
+}
+
+// resolvesTo reports whether host has addr among its addresses
+func (v *Verifier) resolvesTo(ctx context.Context, host string, addr netip.Addr) (bool, error) {
+	addrs, err := v.resolver.LookupHost(ctx, host)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, a := range addrs {
+		resolved, err := netip.ParseAddr(a)
+		if err == nil && resolved.Unmap() == addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
+
+func (v *Verifier) store(key string, result Result) {
+	v.cache.Set(key, result, time.Now())
+}
+
+// CleanupExpired drops expired cache entries
+func (v *Verifier) CleanupExpired() {
+	v.cache.Cleanup(time.Now())
+}
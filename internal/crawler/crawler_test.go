@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	ptr   map[string][]string
+	hosts map[string][]string
+}
+
+func (f fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := f.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := f.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestVerify(t *testing.T) {
+	resolver := fakeResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"192.0.2.10":   {"crawl-66-249-66-1.googlebot.com."},
+			"198.51.100.7": {"googlebot.com.evil.example."},
+		},
+		hosts: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com.": {"66.249.66.1"},
+		},
+	}
+
+	var verified []string
+	v := NewVerifier(DefaultProfiles(), resolver, time.Second, time.Hour, func(ip string, result Result) {
+		verified = append(verified, ip)
+	})
+
+	google := v.Claimed("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	if google == nil || google.Name != "googlebot" {
+		t.Fatal("Googlebot user agent should claim the googlebot profile")
+	}
+	if v.Claimed("Mozilla/5.0 (X11; Linux x86_64)") != nil {
+		t.Error("browser user agent should claim no crawler")
+	}
+
+	tests := []struct {
+		ip       string
+		verified bool
+	}{
+		{"66.249.66.1", true},
+		{"192.0.2.10", false},   // PTR points at a crawler host that does not resolve back
+		{"198.51.100.7", false}, // PTR outside the crawler's domains
+		{"203.0.113.5", false},  // no PTR record
+	}
+	for _, tt := range tests {
+		result, err := v.Verify(context.Background(), tt.ip, google)
+		if err != nil || result.Verified != tt.verified {
+			t.Errorf("Verify(%s) = %v, %v; want verified %v", tt.ip, result.Verified, err, tt.verified)
+		}
+		if _, cached := v.Cached(tt.ip, google); !cached {
+			t.Errorf("result for %s should be cached", tt.ip)
+		}
+	}
+
+	if len(verified) != 1 || verified[0] != "66.249.66.1" {
+		t.Errorf("onVerified called for %v, want only 66.249.66.1", verified)
+	}
+
+	bing := v.Claimed("Mozilla/5.0 (compatible; bingbot/2.0)")
+	if result, _ := v.Verify(context.Background(), "66.249.66.1", bing); result.Verified {
+		t.Error("a Google crawler address must not verify as bingbot")
+	}
+}
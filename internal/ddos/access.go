@@ -0,0 +1,55 @@
+package ddos
+
+import (
+	"net/netip"
+
+	"ddos-protection/internal/access"
+	"ddos-protection/pkg/pipeline"
+)
+
+// initAccess builds the positive security rules for sensitive paths
+func (ps *ProtectionService) initAccess() {
+	cfg := ps.config.Protection.Access
+	for _, rc := range cfg.Rules {
+		rule, err := access.NewRule(rc.Name, rc.Paths, rc.Networks, rc.Countries, rc.ASNs, rc.Identities, rc.Action)
+		if err != nil {
+			ps.logger.Warnf("Invalid access rule: %v", err)
+			continue
+		}
+		if rule.NeedsCountry() && ps.geoDB == nil {
+			ps.logger.Warnf("Access rule %s allows countries but no GeoIP database is loaded", rule.Name)
+		}
+		ps.accessPolicy = append(ps.accessPolicy, rule)
+	}
+
+	if len(ps.accessPolicy) > 0 {
+		ps.logger.Infof("Positive security enabled with %d access rules", len(ps.accessPolicy))
+	}
+}
+
+// accessClient describes a request for the access rules, looking up only
+// what the rules need. Networks are matched against the client IP resolved
+// through the trusted proxies, never a forwarding header the client sent.
+func (ps *ProtectionService) accessClient(info *pipeline.RequestInfo, rules []*access.Rule) access.Client {
+	client := access.Client{
+		Identity: access.Identity(info.Request, ps.config.Protection.Access.IdentityHeader),
+	}
+	if addr, err := netip.ParseAddr(info.ClientIP); err == nil {
+		client.Addr = addr.Unmap()
+	}
+
+	needCountry, needASN := false, false
+	for _, rule := range rules {
+		needCountry = needCountry || rule.NeedsCountry()
+		needASN = needASN || rule.NeedsASN()
+	}
+	if needCountry && ps.geoDB != nil {
+		client.Country = ps.geoDB.Country(info.ClientIP)
+	}
+	if needASN {
+		if asn, ok := ps.ipManager.LookupASN(info.ClientIP); ok {
+			client.ASN = asn.Number
+		}
+	}
+	return client
+}
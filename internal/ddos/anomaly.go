@@ -0,0 +1,91 @@
+package ddos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ddos-protection/internal/anomaly"
+)
+
+// initAnomaly registers the anomaly detector with the botnet ensemble,
+// resuming from the saved model if there is one
+func (ps *ProtectionService) initAnomaly() {
+	cfg := ps.config.Protection.Anomaly
+	if !cfg.Enabled {
+		return
+	}
+
+	model := &anomaly.Model{}
+	if cfg.ModelFile != "" {
+		loaded, err := anomaly.LoadModel(cfg.ModelFile)
+		switch {
+		case err == nil:
+			model = loaded
+		case errors.Is(err, os.ErrNotExist):
+			ps.logger.Infof("No anomaly model at %s yet, starting a new baseline", cfg.ModelFile)
+		default:
+			ps.logger.Warnf("Failed to load anomaly model, starting a new baseline: %v", err)
+		}
+	}
+
+	ps.anomaly = anomaly.NewDetector(model, anomaly.Config{
+		Window:      cfg.Window.Duration(),
+		MinRequests: cfg.MinRequests,
+		MinSamples:  cfg.MinSamples,
+		MaxClients:  ps.botnetLimits().Clients,
+		Learn:       cfg.Learn,
+	})
+	ps.anomaly.SetCalm(func() bool { return !ps.underAttack() })
+	if err := ps.detectors.Register(ps.anomaly, 1); err != nil {
+		ps.logger.Errorf("Failed to register anomaly detector: %v", err)
+		return
+	}
+	ps.logger.Infof("Anomaly detection enabled (%d baseline samples)", model.Samples)
+}
+
+// anomalyRoutine finishes idle client windows, training the model on them,
+// and periodically saves the model
+func (ps *ProtectionService) anomalyRoutine(ctx context.Context) {
+	interval := ps.config.Protection.Anomaly.SaveInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	cleanup := time.NewTicker(time.Minute)
+	defer cleanup.Stop()
+	save := time.NewTicker(interval)
+	defer save.Stop()
+
+	for {
+		select {
+		case <-cleanup.C:
+			ps.anomaly.Cleanup(time.Now())
+		case <-save.C:
+			ps.saveAnomalyModel()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// saveAnomalyModel writes the model to its file, if it has one and is
+// still learning
+func (ps *ProtectionService) saveAnomalyModel() {
+	cfg := ps.config.Protection.Anomaly
+	if ps.anomaly == nil || cfg.ModelFile == "" || !cfg.Learn {
+		return
+	}
+	if err := ps.anomaly.Save(cfg.ModelFile); err != nil {
+		ps.logger.Errorf("Failed to save anomaly model: %v", err)
+	}
+}
+
+// GetAnomalyStatus describes the anomaly model
+func (ps *ProtectionService) GetAnomalyStatus() (anomaly.Status, error) {
+	if ps.anomaly == nil {
+		return anomaly.Status{}, fmt.Errorf("anomaly detection is disabled")
+	}
+	return ps.anomaly.Status(), nil
+}
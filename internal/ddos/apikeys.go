@@ -0,0 +1,114 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/apikey"
+)
+
+// defaultAPIKeyHeader carries API keys when no header is configured
+const defaultAPIKeyHeader = "X-API-Key"
+
+// initAPIKeys sets up API key authentication, shared across instances
+// through Redis
+func (ps *ProtectionService) initAPIKeys() {
+	cfg := ps.config.APIKeys
+	if !cfg.Enabled {
+		return
+	}
+
+	syncInterval := cfg.SyncInterval.Duration()
+	if syncInterval <= 0 {
+		syncInterval = 5 * time.Second
+	}
+	ps.apiKeys = apikey.NewStore(ps.redisClient, syncInterval)
+	if err := ps.apiKeys.Sync(context.Background()); err != nil {
+		ps.logger.Warnf("Failed to load API keys: %v", err)
+	}
+
+	ps.logger.Infof("API keys enabled (header: %s, protected paths: %v)", ps.apiKeyHeader(), cfg.ProtectedPaths)
+}
+
+// apiKeyHeader returns the header carrying API keys
+func (ps *ProtectionService) apiKeyHeader() string {
+	if header := ps.config.APIKeys.Header; header != "" {
+		return header
+	}
+	return defaultAPIKeyHeader
+}
+
+// apiKeyRequired reports whether requests to path must carry an API key
+func (ps *ProtectionService) apiKeyRequired(path string) bool {
+	for _, prefix := range ps.config.APIKeys.ProtectedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey issues an API key and returns it with the token to hand to
+// the consumer. The token cannot be retrieved again.
+func (ps *ProtectionService) CreateAPIKey(ctx context.Context, spec apikey.Spec) (apikey.Key, string, error) {
+	if ps.apiKeys == nil {
+		return apikey.Key{}, "", fmt.Errorf("API keys are disabled")
+	}
+
+	key, token, err := ps.apiKeys.Create(ctx, spec)
+	if err != nil {
+		return key, "", err
+	}
+	ps.audit(ctx, "api_key.create", key.ID, nil, key)
+	return key, token, nil
+}
+
+// ListAPIKeys returns every API key with its usage
+func (ps *ProtectionService) ListAPIKeys() []apikey.KeyInfo {
+	if ps.apiKeys == nil {
+		return []apikey.KeyInfo{}
+	}
+	return ps.apiKeys.List()
+}
+
+// GetAPIKey returns an API key with its usage
+func (ps *ProtectionService) GetAPIKey(id string) (apikey.KeyInfo, bool) {
+	if ps.apiKeys == nil {
+		return apikey.KeyInfo{}, false
+	}
+	return ps.apiKeys.Get(id)
+}
+
+// RotateAPIKey issues a new token for an API key. The old token keeps
+// working for the configured grace period.
+func (ps *ProtectionService) RotateAPIKey(ctx context.Context, id string) (apikey.Key, string, error) {
+	if ps.apiKeys == nil {
+		return apikey.Key{}, "", fmt.Errorf("API keys are disabled")
+	}
+
+	before, _ := ps.apiKeys.Get(id)
+	grace := ps.config.APIKeys.RotationGrace.Duration()
+	key, token, err := ps.apiKeys.Rotate(ctx, id, grace)
+	if err != nil {
+		return key, "", err
+	}
+	ps.audit(ctx, "api_key.rotate", id, before.Key, key)
+	return key, token, nil
+}
+
+// RevokeAPIKey disables an API key immediately
+func (ps *ProtectionService) RevokeAPIKey(ctx context.Context, id string) (apikey.Key, error) {
+	if ps.apiKeys == nil {
+		return apikey.Key{}, fmt.Errorf("API keys are disabled")
+	}
+
+	before, _ := ps.apiKeys.Get(id)
+	key, err := ps.apiKeys.Revoke(ctx, id)
+	if err != nil {
+		return key, err
+	}
+	ps.audit(ctx, "api_key.revoke", id, before.Key, key)
+	return key, nil
+}
@@ -0,0 +1,262 @@
+package ddos
+
+import (
+	"context"
+	"crypto/rand"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"ddos-protection/internal/appeal"
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/captcha"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAppealPath is where appeals are served unless configured otherwise
+const defaultAppealPath = "/_appeal"
+
+// initCaptcha sets up CAPTCHA verification when a provider is configured
+func (ps *ProtectionService) initCaptcha() {
+	cfg := ps.config.Protection.Captcha
+	if cfg.Provider == "" {
+		return
+	}
+
+	verifier, err := captcha.New(captcha.Config{
+		Provider:  cfg.Provider,
+		SiteKey:   cfg.SiteKey,
+		SecretKey: cfg.SecretKey,
+		VerifyURL: cfg.VerifyURL,
+		Timeout:   cfg.Timeout.Duration(),
+	})
+	if err != nil {
+		ps.logger.Errorf("Failed to initialize CAPTCHA: %v", err)
+		return
+	}
+
+	ps.captcha = verifier
+	ps.logger.Infof("CAPTCHA verification initialized (%s)", cfg.Provider)
+}
+
+// initAppeals lets clients blocked by an automatic ban lift it by solving
+// a CAPTCHA at the appeal URL given in the block response
+func (ps *ProtectionService) initAppeals() {
+	cfg := ps.config.Protection.IPBlacklist.Appeals
+	if !cfg.Enabled || !ps.config.Protection.IPBlacklist.Enabled {
+		return
+	}
+	if ps.captcha == nil {
+		ps.logger.Warn("Ban appeals need a CAPTCHA provider; appeals disabled")
+		return
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			ps.logger.Errorf("Failed to generate appeal secret: %v", err)
+			return
+		}
+		ps.logger.Warn("No appeal secret configured; appeal URLs only work on the instance that issued them")
+	}
+
+	ttl := cfg.TokenTTL.Duration()
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	maxPerIP := cfg.MaxPerIP
+	if maxPerIP <= 0 {
+		maxPerIP = 3
+	}
+	window := cfg.Window.Duration()
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+
+	ps.appealSigner = appeal.NewSigner(secret, ttl)
+	ps.appealLimiter = appeal.NewLimiter(ps.redisClient, maxPerIP, window)
+	ps.logger.Infof("Ban appeals enabled at %s (%d per IP per %s)", ps.appealPath(), maxPerIP, window)
+}
+
+// appealPath returns the path appeals are served at
+func (ps *ProtectionService) appealPath() string {
+	if path := ps.config.Protection.IPBlacklist.Appeals.Path; path != "" {
+		return path
+	}
+	return defaultAppealPath
+}
+
+// appealWhitelistDuration returns how long a client is whitelisted after a
+// successful appeal, defaulting to an hour
+func (ps *ProtectionService) appealWhitelistDuration() time.Duration {
+	if d := ps.config.Protection.IPBlacklist.Appeals.WhitelistDuration; d > 0 {
+		return d.Duration()
+	}
+	return time.Hour
+}
+
+// appealable reports whether a ban can be lifted by appeal. Manual, feed
+// and subnet bans cannot: they were deliberate or cover other clients.
+func appealable(entry blacklist.Entry) bool {
+	switch entry.Source {
+	case blacklist.SourceRateLimit, blacklist.SourceBotnet, blacklist.SourceGreylist, blacklist.SourceTrafficAlert, blacklist.SourceSlowRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// appealURL returns the URL a client blocked by entry can appeal at, or ""
+func (ps *ProtectionService) appealURL(ip string, entry blacklist.Entry) string {
+	if ps.appealSigner == nil || !appealable(entry) {
+		return ""
+	}
+	return ps.appealPath() + "?token=" + url.QueryEscape(ps.appealSigner.Issue(ip, time.Now()))
+}
+
+// isAppeal reports whether a request is for the appeal page. Appeals are
+// answered before the pipeline, which would block the banned client.
+func (ps *ProtectionService) isAppeal(r *http.Request) bool {
+	return ps.appealSigner != nil && r.URL.Path == ps.appealPath()
+}
+
+// serveAppeal shows the appeal form and lifts the client's ban once the
+// CAPTCHA on it is solved
+func (ps *ProtectionService) serveAppeal(c *gin.Context, ip string) {
+	var token string
+	switch c.Request.Method {
+	case http.MethodGet:
+		token = c.Query("token")
+	case http.MethodPost:
+		token = c.PostForm("token")
+	default:
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := ps.appealSigner.Verify(token, ip, time.Now()); err != nil {
+		ps.renderAppeal(c, http.StatusForbidden, appealPage{Message: "This appeal link is not valid: " + err.Error() + "."})
+		return
+	}
+
+	form := appealPage{Form: true, Token: token, Widget: ps.captcha.Widget()}
+	if c.Request.Method == http.MethodGet {
+		ps.renderAppeal(c, http.StatusOK, form)
+		return
+	}
+
+	ctx := c.Request.Context()
+	if !ps.appealLimiter.Allow(ctx, ip) {
+		ps.logger.WithField("ip", ip).Warn("Ban appeal refused - too many appeals")
+		ps.renderAppeal(c, http.StatusTooManyRequests, appealPage{Message: "Too many appeals from your address. Please try again later."})
+		return
+	}
+
+	solved, err := ps.captcha.Verify(ctx, ps.captcha.Response(c.Request), ip)
+	if err != nil {
+		ps.logger.Errorf("Failed to verify CAPTCHA for appeal from %s: %v", ip, err)
+		ps.renderAppeal(c, http.StatusServiceUnavailable, appealPage{Message: "The CAPTCHA could not be checked. Please try again later."})
+		return
+	}
+	if !solved {
+		form.Message = "The CAPTCHA was not solved. Please try again."
+		ps.renderAppeal(c, http.StatusForbidden, form)
+		return
+	}
+
+	lifted, err := ps.liftBanByAppeal(ctx, ip, ps.tenantResolver.Resolve(c.Request))
+	switch {
+	case err != nil:
+		ps.logger.Errorf("Failed to lift ban of %s on appeal: %v", ip, err)
+		ps.renderAppeal(c, http.StatusInternalServerError, appealPage{Message: "Your appeal could not be processed. Please try again later."})
+	case !lifted:
+		ps.renderAppeal(c, http.StatusForbidden, appealPage{Message: "This block cannot be appealed."})
+	default:
+		ps.renderAppeal(c, http.StatusOK, appealPage{Message: "Your access has been restored."})
+	}
+}
+
+// liftBanByAppeal removes the ban matching ip, in the shared lists or the
+// tenant's, and whitelists it there for a while. It reports false when the
+// ban cannot be appealed; a ban that expired in the meantime counts as
+// lifted.
+func (ps *ProtectionService) liftBanByAppeal(ctx context.Context, ip, tenant string) (bool, error) {
+	lists := ps.ipManager
+	entry, banned := lists.Match(ctx, ip)
+	if !banned {
+		lists = ps.listsFor(tenant)
+		entry, banned = lists.Match(ctx, ip)
+	}
+	if !banned {
+		return true, nil
+	}
+	if !appealable(entry) {
+		return false, nil
+	}
+
+	if err := lists.RemoveFromBlacklist(ctx, entry.Target); err != nil {
+		return false, err
+	}
+	if err := lists.WhitelistIP(ctx, ip, ps.appealWhitelistDuration()); err != nil {
+		return false, err
+	}
+	ps.vindicate(ip)
+
+	ps.logger.WithFields(logrus.Fields{
+		"ip":     ip,
+		"tenant": lists.Tenant(),
+		"target": entry.Target,
+		"source": entry.Source,
+	}).Info("Ban lifted on appeal")
+	ps.audit(ctx, "blacklist.appeal", listTarget(lists, entry.Target), entry, nil)
+	ps.audit(ctx, "whitelist.appeal", listTarget(lists, ip), nil, lookupWhitelist(lists, ip))
+	return true, nil
+}
+
+// appealPage is what the appeal page shows
+type appealPage struct {
+	Message string
+	Form    bool
+	Token   string
+	Widget  template.HTML
+}
+
+// renderAppeal writes the appeal page
+func (ps *ProtectionService) renderAppeal(c *gin.Context, status int, page appealPage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	if err := appealTemplate.Execute(c.Writer, page); err != nil {
+		ps.logger.Errorf("Failed to render appeal page: %v", err)
+	}
+	c.Abort()
+}
+
+var appealTemplate = template.Must(template.New("appeal").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Restore access</title>
+<style>
+body { font-family: sans-serif; max-width: 40rem; margin: 3rem auto; color: #222; }
+</style>
+</head>
+<body>
+<h1>Restore access</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Form}}
+<p>Your address was blocked after unusual activity. If you are not a bot, solve the check below to restore access.</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+{{.Widget}}
+<p><button type="submit">Restore access</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
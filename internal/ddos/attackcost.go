@@ -0,0 +1,67 @@
+package ddos
+
+import (
+	"fmt"
+	"time"
+
+	"ddos-protection/internal/attackcost"
+
+	"github.com/gin-gonic/gin"
+)
+
+// initAttackCost sets up estimating the cost absorbed by blocking
+func (ps *ProtectionService) initAttackCost() {
+	cfg := ps.config.Protection.AttackCost
+	if !cfg.Enabled {
+		return
+	}
+
+	history := cfg.History
+	if history <= 0 {
+		history = 50
+	}
+	ps.attackCost = attackcost.NewEstimator(cfg.CPUPerRequest.Duration(), int64(cfg.BytesPerResponse), history)
+}
+
+// trackIncident starts or ends the incident that attack costs are totalled
+// under, following incident mode and capacity mitigation
+func (ps *ProtectionService) trackIncident() {
+	if ps.attackCost == nil {
+		return
+	}
+
+	ps.mu.RLock()
+	reason := ""
+	switch {
+	case ps.incidentActive:
+		reason = "incident_mode"
+	case ps.rateLimitBase != nil:
+		reason = "capacity_mitigation"
+	}
+	ps.mu.RUnlock()
+
+	if reason == "" {
+		ps.attackCost.EndIncident(time.Now())
+	} else {
+		ps.attackCost.StartIncident(reason, time.Now())
+	}
+}
+
+// recordBlockedCost records a request denied or challenged before reaching
+// the upstream
+func (ps *ProtectionService) recordBlockedCost(c *gin.Context, start time.Time) {
+	if ps.attackCost != nil {
+		ps.attackCost.Blocked(time.Since(start), c.Writer.Size())
+	}
+}
+
+// GetAttackCost returns the estimated cost absorbed since startup and per
+// incident
+func (ps *ProtectionService) GetAttackCost() (attackcost.Report, error) {
+	if ps.attackCost == nil {
+		return attackcost.Report{}, fmt.Errorf("attack cost estimation is disabled")
+	}
+	report := ps.attackCost.Report()
+	ps.addAccountImpact(&report)
+	return report, nil
+}
@@ -0,0 +1,63 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ddos-protection/internal/attacks"
+)
+
+// attackSweepInterval is how often attacks are checked for having ended
+const attackSweepInterval = 10 * time.Second
+
+// initAttacks sets up grouping detections into attacks
+func (ps *ProtectionService) initAttacks() {
+	cfg := ps.config.Protection.Attacks
+	if !cfg.Enabled {
+		return
+	}
+	ps.attacks = attacks.NewTracker(attacks.Options{
+		MinDetections: cfg.MinDetections,
+		QuietPeriod:   cfg.QuietPeriod.Duration(),
+		Retention:     cfg.Retention.Duration(),
+		MaxAttacks:    cfg.MaxAttacks,
+	})
+}
+
+// attackRoutine ends attacks once detections stop for the quiet period,
+// and drops those past their retention
+func (ps *ProtectionService) attackRoutine(ctx context.Context) {
+	ticker := time.NewTicker(attackSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ps.attacks.Sweep(time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetAttacks returns the attack in progress, if any, and past attacks,
+// most recent first
+func (ps *ProtectionService) GetAttacks() ([]attacks.Attack, error) {
+	if ps.attacks == nil {
+		return nil, fmt.Errorf("attack tracking is disabled")
+	}
+	return ps.attacks.List(), nil
+}
+
+// GetAttack returns one attack
+func (ps *ProtectionService) GetAttack(id int) (attacks.Attack, error) {
+	if ps.attacks == nil {
+		return attacks.Attack{}, fmt.Errorf("attack tracking is disabled")
+	}
+	attack, ok := ps.attacks.Get(id)
+	if !ok {
+		return attacks.Attack{}, fmt.Errorf("attack %d not found", id)
+	}
+	return attack, nil
+}
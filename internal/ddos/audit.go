@@ -0,0 +1,179 @@
+package ddos
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"time"
+
+	"ddos-protection/internal/audit"
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initAudit opens the audit log of list and config changes
+func (ps *ProtectionService) initAudit() {
+	cfg := ps.config.Audit
+	if !cfg.Enabled {
+		return
+	}
+
+	log, err := audit.NewLog(context.Background(), cfg.File, ps.redisClient, cfg.RedisStream, cfg.StreamMaxLen, cfg.Capacity)
+	if err != nil {
+		ps.logger.Errorf("Failed to open audit log: %v", err)
+		return
+	}
+	ps.auditLog = log
+
+	ps.logger.Infof("Audit log enabled (file: %q, stream: %q)", cfg.File, cfg.RedisStream)
+
+	if cfg.SigningKeyFile == "" {
+		return
+	}
+	key, err := audit.LoadSigningKey(cfg.SigningKeyFile)
+	if err != nil {
+		ps.logger.Errorf("Failed to load audit signing key, checkpoints disabled: %v", err)
+		return
+	}
+	log.SetSigningKey(key)
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = config.Duration(time.Hour)
+		ps.config.Audit.CheckpointInterval = cfg.CheckpointInterval
+	}
+
+	ps.logger.Infof("Audit checkpoints signed every %s (key %s)", cfg.CheckpointInterval, audit.KeyID(log.PublicKey()))
+}
+
+// auditCheckpointRoutine periodically signs the head of the audit log
+func (ps *ProtectionService) auditCheckpointRoutine(ctx context.Context) {
+	interval := ps.config.Audit.CheckpointInterval.Duration()
+	ps.auditLog.RunCheckpoints(ctx, interval, func(err error) {
+		ps.logger.Errorf("Failed to write audit checkpoint: %v", err)
+	})
+}
+
+// AuditEnabled reports whether changes are being audited
+func (ps *ProtectionService) AuditEnabled() bool {
+	return ps.auditLog != nil
+}
+
+// ExportAuditLog writes the audit log in the verifiable export format
+func (ps *ProtectionService) ExportAuditLog(w io.Writer) error {
+	if ps.auditLog == nil {
+		return fmt.Errorf("audit log is disabled")
+	}
+	return ps.auditLog.Export(w)
+}
+
+// AuditPublicKey returns the key audit checkpoints are signed with
+func (ps *ProtectionService) AuditPublicKey() (ed25519.PublicKey, error) {
+	if ps.auditLog == nil {
+		return nil, fmt.Errorf("audit log is disabled")
+	}
+	pub := ps.auditLog.PublicKey()
+	if pub == nil {
+		return nil, fmt.Errorf("audit checkpoints are not signed")
+	}
+	return pub, nil
+}
+
+// audit records a change made by the actor in ctx. Failing to record a
+// change is logged but does not undo it.
+func (ps *ProtectionService) audit(ctx context.Context, action, target string, before, after interface{}) {
+	if ps.auditLog == nil {
+		return
+	}
+
+	if _, err := ps.auditLog.Record(ctx, action, target, before, after); err != nil {
+		ps.logger.WithFields(logrus.Fields{
+			"action": action,
+			"target": target,
+		}).Errorf("Failed to record audit entry: %v", err)
+	}
+}
+
+// GetAuditLog returns audit entries matching q, newest first
+func (ps *ProtectionService) GetAuditLog(q audit.Query) ([]audit.Entry, error) {
+	if ps.auditLog == nil {
+		return nil, fmt.Errorf("audit log is disabled")
+	}
+	return ps.auditLog.Search(q), nil
+}
+
+// lookupBlacklist returns the blacklist entry for a target, or nil so the
+// audit log records it as absent
+func lookupBlacklist(lists *blacklist.IPManager, target string) interface{} {
+	if entry, ok := lists.Lookup(target); ok {
+		return entry
+	}
+	return nil
+}
+
+// lookupWhitelist returns the whitelist entry for an IP, or nil
+func lookupWhitelist(lists *blacklist.IPManager, ip string) interface{} {
+	if entry, ok := lists.LookupWhitelist(ip); ok {
+		return entry
+	}
+	return nil
+}
+
+// lookupGreylist returns the greylist entry for an IP, or nil
+func (ps *ProtectionService) lookupGreylist(ip string) interface{} {
+	if entry, ok := ps.ipManager.LookupGreylist(ip); ok {
+		return entry
+	}
+	return nil
+}
+
+// lookupASNRule returns the rule for an AS, or nil
+func (ps *ProtectionService) lookupASNRule(asn uint32) interface{} {
+	for _, rule := range ps.ipManager.GetASNRules() {
+		if rule.ASN == asn {
+			return rule
+		}
+	}
+	return nil
+}
+
+// lookupKillSwitch returns an engaged kill switch, or nil
+func (ps *ProtectionService) lookupKillSwitch(kind, name string) interface{} {
+	for _, sw := range ps.killSwitches.List() {
+		if string(sw.Kind) == kind && sw.Name == name {
+			return sw
+		}
+	}
+	return nil
+}
+
+// presetState is the part of the config a preset changes, as recorded in the
+// audit log. The caller must hold ps.mu.
+func (ps *ProtectionService) presetState() map[string]interface{} {
+	cfg := ps.config.Protection
+	rateLimit := cfg.RateLimit
+	if ps.rateLimitBase != nil {
+		rateLimit = *ps.rateLimitBase
+	}
+	grey := cfg.IPBlacklist.Greylist
+	return map[string]interface{}{
+		"preset":           cfg.Preset,
+		"rate_limit":       rateLimitState(rateLimit),
+		"max_request_size": int64(cfg.RequestFilter.MaxRequestSize),
+		"botnet_threshold": ps.botnetThreshold(),
+		"greylist": map[string]int{
+			"requests_per_minute": grey.RequestsPerMinute,
+			"burst_size":          grey.BurstSize,
+			"promote_after":       grey.PromoteAfter,
+			"quiet_period":        int(grey.QuietPeriod.Duration() / time.Second),
+		},
+	}
+}
+
+func rateLimitState(rl config.RateLimitConfig) map[string]int {
+	return map[string]int{
+		"requests_per_minute": rl.RequestsPerMinute,
+		"burst_size":          rl.BurstSize,
+	}
+}
@@ -0,0 +1,105 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ddos-protection/internal/attackcost"
+	"ddos-protection/internal/authcorr"
+	"ddos-protection/internal/blacklist"
+)
+
+// initAuthCorrelation sets up correlating authentication events reported
+// by the upstream with blocked traffic
+func (ps *ProtectionService) initAuthCorrelation() {
+	cfg := ps.config.Protection.AuthCorrelation
+	if !cfg.Enabled {
+		return
+	}
+
+	ps.authCorrelator = authcorr.NewCorrelator(authcorr.Config{
+		Window:          cfg.Window.Duration(),
+		MinAccounts:     cfg.MinAccounts,
+		MinFailureRatio: cfg.MinFailureRatio,
+		MaxEvents:       cfg.MaxEvents,
+		MaxClients:      cfg.MaxClients,
+	})
+	ps.logger.Info("Authentication event correlation enabled")
+}
+
+// IngestAuthEvents records authentication events reported by the upstream.
+// Clients found credential stuffing are blacklisted when a blacklist
+// duration is configured. Nothing is recorded if any event is invalid.
+func (ps *ProtectionService) IngestAuthEvents(ctx context.Context, evs []authcorr.Event) error {
+	if ps.authCorrelator == nil {
+		return fmt.Errorf("auth correlation is disabled")
+	}
+	for i, e := range evs {
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("event %d: %v", i, err)
+		}
+	}
+
+	duration := ps.config.Protection.AuthCorrelation.BlacklistDuration.Duration()
+	for _, e := range evs {
+		class, _ := ps.authCorrelator.Ingest(e)
+		if class != authcorr.CredentialStuffing || duration <= 0 || ps.ipManager.IsBlacklisted(ctx, e.IP) {
+			continue
+		}
+		err := ps.ipManager.BlacklistIP(ctx, e.IP, duration, blacklist.Origin{
+			Source: blacklist.SourceCredentials,
+			Reason: "credential stuffing",
+		})
+		if err != nil {
+			ps.logger.Errorf("Failed to blacklist credential stuffing client %s: %v", e.IP, err)
+			continue
+		}
+		ps.logger.WithField("ip", e.IP).Warn("Credential stuffing client blacklisted")
+	}
+	return nil
+}
+
+// GetAuthCorrelation returns the correlation of authentication events and
+// blocked traffic over the window, listing at most limit clients
+func (ps *ProtectionService) GetAuthCorrelation(limit int) (authcorr.Summary, error) {
+	if ps.authCorrelator == nil {
+		return authcorr.Summary{}, fmt.Errorf("auth correlation is disabled")
+	}
+	return ps.authCorrelator.Summary(time.Now(), limit), nil
+}
+
+// GetAuthClient returns the correlated activity of one client
+func (ps *ProtectionService) GetAuthClient(ip string) (authcorr.Client, error) {
+	if ps.authCorrelator == nil {
+		return authcorr.Client{}, fmt.Errorf("auth correlation is disabled")
+	}
+	client, ok := ps.authCorrelator.Client(ip, time.Now())
+	if !ok {
+		return authcorr.Client{}, fmt.Errorf("no activity recorded for %s", ip)
+	}
+	return client, nil
+}
+
+// addAccountImpact fills in the account impact of each incident in a report
+func (ps *ProtectionService) addAccountImpact(report *attackcost.Report) {
+	if ps.authCorrelator == nil {
+		return
+	}
+
+	now := time.Now()
+	impact := func(incident *attackcost.Incident) {
+		end := now
+		if incident.End != nil {
+			end = *incident.End
+		}
+		accounts := ps.authCorrelator.Impact(incident.Start, end)
+		incident.Accounts = &accounts
+	}
+	if report.Current != nil {
+		impact(report.Current)
+	}
+	for i := range report.Incidents {
+		impact(&report.Incidents[i])
+	}
+}
@@ -0,0 +1,65 @@
+package ddos
+
+import (
+	"net/netip"
+	"os"
+	"time"
+
+	"ddos-protection/internal/awswaf"
+)
+
+// initAWSWAF sets up keeping AWS WAFv2 IPSets in step with the shared
+// blacklist. Credentials fall back to the standard AWS environment
+// variables.
+func (ps *ProtectionService) initAWSWAF() {
+	cfg := ps.config.Protection.IPBlacklist.AWSWAF
+	if !cfg.Enabled || !ps.config.Protection.IPBlacklist.Enabled {
+		return
+	}
+
+	creds := awswaf.Credentials{
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		SessionToken:    cfg.SessionToken,
+	}
+	if creds.AccessKeyID == "" {
+		creds = awswaf.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	client, err := awswaf.NewClient(cfg.Endpoint, region, cfg.Scope, creds, cfg.Timeout.Duration())
+	if err != nil {
+		ps.logger.Errorf("Failed to set up AWS WAF synchronization: %v", err)
+		return
+	}
+	if cfg.IPv4SetID == "" && cfg.IPv6SetID == "" {
+		ps.logger.Error("AWS WAF synchronization needs ipv4_set_id or ipv6_set_id, disabled")
+		return
+	}
+
+	interval := cfg.ReconcileInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	delay := cfg.BatchDelayMs.Duration()
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+	sources := banSources(cfg.Sources)
+
+	ps.wafSyncer = awswaf.NewSyncer(client,
+		awswaf.IPSetRef{Name: cfg.IPv4SetName, ID: cfg.IPv4SetID},
+		awswaf.IPSetRef{Name: cfg.IPv6SetName, ID: cfg.IPv6SetID},
+		func() map[netip.Prefix]time.Time { return ps.bansFrom(sources) },
+		cfg.MaxAddresses, interval, delay)
+	ps.ipManager.OnChange(func(string) { ps.wafSyncer.Notify() })
+
+	ps.logger.Infof("AWS WAF IPSet synchronization enabled (region: %s, scope: %s)", region, cfg.Scope)
+}
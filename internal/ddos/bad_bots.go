@@ -0,0 +1,125 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/badbot"
+)
+
+// BadBotStatus is the bad bot signatures in force, their hits, and how the
+// last refresh from the signature source went
+type BadBotStatus struct {
+	badbot.Status
+	Source    string    `json:"source,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// badBots holds the signature database and follows its source
+type badBots struct {
+	db        *badbot.Database
+	fetcher   *badbot.Fetcher
+	source    string
+	checkedAt time.Time
+	err       string
+	mu        sync.Mutex
+}
+
+// initBadBots gives the botnet detector the signatures of known scraping
+// and attack tools, the built-in ones and those from the configured source
+func (ps *ProtectionService) initBadBots() {
+	cfg := ps.config.Protection.Botnet.BadBots
+	if !cfg.Enabled {
+		return
+	}
+
+	bots := &badBots{
+		db:     badbot.NewDatabase(!cfg.DisableBuiltin),
+		source: cfg.URL,
+	}
+	if cfg.URL != "" {
+		timeout := cfg.Timeout.Duration()
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		bots.fetcher = badbot.NewFetcher(cfg.URL, timeout)
+	}
+
+	ps.badBots = bots
+	ps.botnetDetector.SetSignatures(bots.db)
+	if cfg.URL != "" {
+		ps.logger.Infof("Bad bot signatures enabled (%d built in, refreshed from %s)", bots.db.Status().Builtin, cfg.URL)
+	} else {
+		ps.logger.Infof("Bad bot signatures enabled (%d built in)", bots.db.Status().Builtin)
+	}
+}
+
+// badBotRoutine refreshes the signatures on start and then every interval
+func (ps *ProtectionService) badBotRoutine(ctx context.Context) {
+	interval := ps.config.Protection.Botnet.BadBots.RefreshInterval.Duration()
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ps.RefreshBadBots(ctx); err != nil {
+			ps.logger.Errorf("Bad bot signature refresh failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RefreshBadBots fetches the signature source now and loads its
+// signatures if they changed
+func (ps *ProtectionService) RefreshBadBots(ctx context.Context) error {
+	bots := ps.badBots
+	if bots == nil {
+		return fmt.Errorf("bad bot signatures are disabled")
+	}
+	if bots.fetcher == nil {
+		return fmt.Errorf("no bad bot signature source configured")
+	}
+
+	set, err := bots.fetcher.Fetch(ctx)
+	if err == nil && set != nil {
+		err = bots.db.Update(*set)
+		if err == nil {
+			ps.logger.Infof("Loaded %d bad bot signatures (version %q)", len(set.Signatures), set.Version)
+		}
+	}
+
+	bots.mu.Lock()
+	defer bots.mu.Unlock()
+	bots.checkedAt = time.Now()
+	bots.err = ""
+	if err != nil {
+		bots.err = err.Error()
+	}
+	return err
+}
+
+// GetBadBots returns the bad bot signatures in force and their hits
+func (ps *ProtectionService) GetBadBots() (BadBotStatus, error) {
+	bots := ps.badBots
+	if bots == nil {
+		return BadBotStatus{}, fmt.Errorf("bad bot signatures are disabled")
+	}
+
+	bots.mu.Lock()
+	defer bots.mu.Unlock()
+	return BadBotStatus{
+		Status:    bots.db.Status(),
+		Source:    bots.source,
+		CheckedAt: bots.checkedAt,
+		Error:     bots.err,
+	}, nil
+}
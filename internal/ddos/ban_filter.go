@@ -0,0 +1,50 @@
+package ddos
+
+import (
+	"context"
+	"time"
+)
+
+// initBanFilter sets up the Bloom filter answering blacklist checks for
+// clients that are not banned
+func (ps *ProtectionService) initBanFilter() {
+	cfg := ps.config.Protection.IPBlacklist.Filter
+	if !cfg.Enabled {
+		return
+	}
+
+	entries := cfg.ExpectedEntries
+	if entries <= 0 {
+		entries = 100000
+	}
+	rate := cfg.FalsePositiveRate
+	if rate <= 0 || rate >= 1 {
+		rate = 0.001
+	}
+
+	if err := ps.ipManager.EnableFilter(context.Background(), entries, rate); err != nil {
+		ps.logger.Warnf("Failed to build ban filter: %v", err)
+	}
+	ps.logger.Infof("Ban filter enabled (expected entries: %d, false positive rate: %g)", entries, rate)
+}
+
+// banFilterRoutine periodically rebuilds the ban filter
+func (ps *ProtectionService) banFilterRoutine(ctx context.Context) {
+	interval := ps.config.Protection.IPBlacklist.Filter.RefreshInterval.Duration()
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.ipManager.RebuildFilter(ctx); err != nil {
+				ps.logger.Warnf("Failed to rebuild ban filter: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
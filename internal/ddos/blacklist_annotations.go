@@ -0,0 +1,83 @@
+package ddos
+
+import (
+	"net/netip"
+	"strings"
+
+	"ddos-protection/internal/blacklist"
+)
+
+// Annotation is what is known about a blacklisted target: its country and
+// AS, looked up by the first address of a range, and the reputation of a
+// tracked IP or IPv6 network
+type Annotation struct {
+	Country    string             `json:"country,omitempty"`
+	ASN        *blacklist.ASNInfo `json:"asn,omitempty"`
+	Reputation *int               `json:"reputation,omitempty"`
+}
+
+// AnnotatedEntry is a blacklist entry with its annotation
+type AnnotatedEntry struct {
+	blacklist.Entry
+	Annotation
+}
+
+// AnnotatedPage is a page of annotated blacklist entries
+type AnnotatedPage struct {
+	Entries []AnnotatedEntry `json:"blacklisted"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+}
+
+// ListBlacklistAnnotated returns a page of blacklisted IPs joined with
+// GeoIP, ASN and reputation data where available
+func (ps *ProtectionService) ListBlacklistAnnotated(q blacklist.ListQuery) AnnotatedPage {
+	page := ps.ListBlacklist(q)
+	annotated := AnnotatedPage{
+		Entries: make([]AnnotatedEntry, len(page.Entries)),
+		Total:   page.Total,
+		Offset:  page.Offset,
+		Limit:   page.Limit,
+	}
+	for i, entry := range page.Entries {
+		annotated.Entries[i] = AnnotatedEntry{Entry: entry, Annotation: ps.annotate(entry.Target)}
+	}
+	return annotated
+}
+
+// annotate looks up an IP or CIDR range
+func (ps *ProtectionService) annotate(target string) Annotation {
+	var a Annotation
+	var addr netip.Addr
+	if strings.Contains(target, "/") {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return a
+		}
+		addr = prefix.Masked().Addr()
+	} else {
+		var err error
+		if addr, err = netip.ParseAddr(target); err != nil {
+			return a
+		}
+	}
+	ip := addr.Unmap().String()
+
+	if ps.geoDB != nil {
+		a.Country = ps.geoDB.Country(ip)
+	}
+	if info, ok := ps.ipManager.LookupASN(ip); ok {
+		a.ASN = &info
+		if a.Country == "" {
+			a.Country = info.Country
+		}
+	}
+	if ps.reputation != nil {
+		// IPv6 auto-bans target the network the tracker also keys by
+		if report := ps.reputation.Get(target); report.Tracked || (report.Network != nil && report.Network.Tracked) {
+			a.Reputation = &report.Score
+		}
+	}
+	return a
+}
@@ -0,0 +1,99 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/botpolicy"
+	"ddos-protection/pkg/pipeline"
+)
+
+const (
+	// suspectedBotHeader carries the risk band of tagged requests to the backend
+	suspectedBotHeader = "X-Suspected-Bot"
+	// botConfidenceHeader carries the bot confidence of tagged requests
+	botConfidenceHeader = "X-Bot-Confidence"
+)
+
+// initBotPolicy builds the per-endpoint decision matrix for suspected bots
+func (ps *ProtectionService) initBotPolicy() {
+	cfg := ps.config.Protection.BotPolicy
+	if !cfg.Enabled {
+		return
+	}
+
+	spec := botpolicy.Spec{Matrix: make(botpolicy.Matrix, len(cfg.Matrix))}
+	for _, b := range cfg.Bands {
+		spec.Bands = append(spec.Bands, botpolicy.Band{Name: b.Name, MinConfidence: b.MinConfidence})
+	}
+	for _, g := range cfg.PathGroups {
+		spec.Groups = append(spec.Groups, botpolicy.PathGroup{Name: g.Name, Paths: g.Paths})
+	}
+	for group, row := range cfg.Matrix {
+		spec.Matrix[group] = make(map[string]botpolicy.Action, len(row))
+		for band, action := range row {
+			spec.Matrix[group][band] = botpolicy.Action(action)
+		}
+	}
+
+	policy, err := botpolicy.New(spec)
+	if err != nil {
+		ps.logger.Errorf("Invalid bot policy, falling back to the detection threshold: %v", err)
+		return
+	}
+	ps.botPolicy = policy
+	ps.logger.Infof("Bot policy enabled (%d bands, %d path groups)", len(spec.Bands), len(spec.Groups))
+}
+
+// applyBotPolicy acts on a bot analysis according to the decision matrix
+func (ps *ProtectionService) applyBotPolicy(ctx context.Context, info *pipeline.RequestInfo, result *botnet.BotnetAnalysis) pipeline.Verdict {
+	// Only the policy may tag a request
+	info.Request.Header.Del(suspectedBotHeader)
+	info.Request.Header.Del(botConfidenceHeader)
+
+	decision, ok := ps.botPolicy.Decide(info.Request.URL.Path, result.Confidence)
+	if !ok {
+		return pipeline.Next()
+	}
+	info.Values["bot_policy"] = decision
+
+	switch decision.Action {
+	case botpolicy.ActionAllow:
+		return pipeline.Next()
+	case botpolicy.ActionTag:
+		info.RiskScore += result.RiskScore
+		info.Request.Header.Set(suspectedBotHeader, decision.Band)
+		info.Request.Header.Set(botConfidenceHeader, fmt.Sprintf("%.2f", result.Confidence))
+		return pipeline.Next()
+	case botpolicy.ActionChallenge:
+		info.RiskScore += result.RiskScore
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "bot " + decision.Band}
+	default:
+		info.RiskScore += result.RiskScore
+		return ps.blockBot(ctx, info, result, result.Confidence > ps.effective(info).Settings.AutoBlacklistConfidence)
+	}
+}
+
+// GetBotPolicy returns the bot decision matrix
+func (ps *ProtectionService) GetBotPolicy() (botpolicy.Spec, error) {
+	if ps.botPolicy == nil {
+		return botpolicy.Spec{}, fmt.Errorf("bot policy is disabled")
+	}
+	return ps.botPolicy.Spec(), nil
+}
+
+// UpdateBotPolicy replaces the bot decision matrix
+func (ps *ProtectionService) UpdateBotPolicy(ctx context.Context, spec botpolicy.Spec) error {
+	if ps.botPolicy == nil {
+		return fmt.Errorf("bot policy is disabled")
+	}
+
+	before := ps.botPolicy.Spec()
+	if err := ps.botPolicy.Update(spec); err != nil {
+		return err
+	}
+	ps.audit(ctx, "config.bot_policy", "", before, ps.botPolicy.Spec())
+	ps.logger.Info("Bot policy updated")
+	return nil
+}
@@ -0,0 +1,74 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/config"
+)
+
+// botnetScoring builds the botnet scoring from the config: the built-in
+// weights and thresholds, overridden where the config sets them
+func botnetScoring(cfg config.BotnetScoringConfig) (botnet.Scoring, error) {
+	scoring := botnet.DefaultScoring()
+	if cfg.RiskDivisor != nil {
+		scoring.RiskDivisor = *cfg.RiskDivisor
+	}
+	if cfg.IndicatorBonus != nil {
+		scoring.IndicatorBonus = *cfg.IndicatorBonus
+	}
+	if cfg.OverrideRisk != nil {
+		scoring.OverrideRisk = *cfg.OverrideRisk
+	}
+	if cfg.OverrideConfidence != nil {
+		scoring.OverrideConfidence = *cfg.OverrideConfidence
+	}
+
+	for id, ruleCfg := range cfg.Rules {
+		rule := scoring.Rules[id]
+		if ruleCfg.Enabled != nil {
+			rule.Enabled = *ruleCfg.Enabled
+		}
+		if ruleCfg.Weight != nil {
+			rule.Weight = *ruleCfg.Weight
+		}
+		if ruleCfg.Threshold != nil {
+			rule.Threshold = *ruleCfg.Threshold
+		}
+		scoring.Rules[id] = rule
+	}
+
+	if err := scoring.Validate(); err != nil {
+		return botnet.Scoring{}, fmt.Errorf("invalid botnet scoring: %v", err)
+	}
+	return scoring, nil
+}
+
+// GetBotnetScoring returns the indicator weights and thresholds in force
+func (ps *ProtectionService) GetBotnetScoring() botnet.Scoring {
+	return ps.botnetDetector.Scoring()
+}
+
+// UpdateBotnetScoring replaces the botnet scoring. Indicators it does not
+// list get their defaults.
+func (ps *ProtectionService) UpdateBotnetScoring(ctx context.Context, scoring botnet.Scoring) error {
+	before := ps.botnetDetector.Scoring()
+	if err := ps.botnetDetector.SetScoring(scoring); err != nil {
+		return err
+	}
+	ps.audit(ctx, "config.botnet_scoring", "", before, ps.botnetDetector.Scoring())
+	ps.logger.Info("Botnet scoring updated")
+	return nil
+}
+
+// ReloadConfig applies the parts of a reloaded config that can change
+// while running: the botnet scoring. Nothing is applied if they are
+// invalid.
+func (ps *ProtectionService) ReloadConfig(ctx context.Context, cfg *config.Config) error {
+	scoring, err := botnetScoring(cfg.Protection.Botnet.Scoring)
+	if err != nil {
+		return err
+	}
+	return ps.UpdateBotnetScoring(ctx, scoring)
+}
@@ -0,0 +1,84 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ddos-protection/internal/ratelimit"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initBurstDetection sets up counting each client's requests over several
+// short windows alongside the per-minute limit
+func (ps *ProtectionService) initBurstDetection() {
+	cfg := ps.config.Protection.BurstDetection
+	if !cfg.Enabled {
+		return
+	}
+
+	windows := make([]ratelimit.Window, 0, len(cfg.Windows))
+	for _, w := range cfg.Windows {
+		windows = append(windows, ratelimit.Window{
+			Size:      w.Seconds.Duration(),
+			Threshold: w.Threshold,
+			Cap:       w.Cap,
+		})
+	}
+	if len(windows) == 0 {
+		windows = []ratelimit.Window{
+			{Size: time.Second, Threshold: 10},
+			{Size: 10 * time.Second, Threshold: 40},
+			{Size: time.Minute, Threshold: 120},
+		}
+	}
+
+	ps.burstTracker = ratelimit.NewBurstTracker(windows)
+	ps.logger.Infof("Burst detection enabled (%d windows)", len(ps.burstTracker.Windows()))
+}
+
+// checkBurst counts a request in the burst windows. A client over a
+// threshold gets the micro_burst bot indicator; one over a cap is rejected.
+func (ps *ProtectionService) checkBurst(ctx context.Context, info *pipeline.RequestInfo) (pipeline.Verdict, bool) {
+	if ps.burstTracker == nil {
+		return pipeline.Verdict{}, false
+	}
+
+	burst, exceeded := ps.burstTracker.Record(info.ClientIP, info.Start)
+	if !exceeded {
+		return pipeline.Verdict{}, false
+	}
+
+	info.Values["micro_burst"] = burst
+	ps.botnetDetector.RecordBurst(info.ClientIP)
+	if !burst.Capped {
+		return pipeline.Verdict{}, false
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"ip":     info.ClientIP,
+		"window": burst.Window,
+		"count":  burst.Count,
+	}).Warn("Request blocked - burst cap exceeded")
+	ps.rejections.Record(info.ClientIP)
+	ps.strike(ctx, info.ClientIP, fmt.Sprintf("%d requests in %v", burst.Count, burst.Window))
+
+	return pipeline.Reject(http.StatusTooManyRequests, "BURST_LIMITED", "Too many requests in a short burst"), true
+}
+
+// burstCounts returns a client's requests in each burst window, keyed by
+// window like "1s"
+func (ps *ProtectionService) burstCounts(ip string) map[string]int {
+	if ps.burstTracker == nil {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for window, count := range ps.burstTracker.Counts(ip, time.Now()) {
+		counts[fmt.Sprintf("%ds", int(window/time.Second))] = count
+	}
+	return counts
+}
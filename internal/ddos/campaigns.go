@@ -0,0 +1,57 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ddos-protection/internal/botnet"
+)
+
+// defaultCampaignClients is the smallest campaign reported unless
+// configured otherwise
+const defaultCampaignClients = 5
+
+// campaignRoutine clusters the botnet detector's clients into campaigns
+// every interval, logging each campaign as it appears
+func (ps *ProtectionService) campaignRoutine(ctx context.Context) {
+	cfg := ps.config.Protection.Botnet.Campaigns
+	interval := cfg.Interval.Duration()
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	minClients := cfg.MinClients
+	if minClients <= 0 {
+		minClients = defaultCampaignClients
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	known := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+			campaigns := ps.botnetDetector.ClusterCampaigns(time.Now(), minClients)
+			current := make(map[string]bool, len(campaigns))
+			for _, campaign := range campaigns {
+				current[campaign.ID] = true
+				if !known[campaign.ID] {
+					ps.logger.Warnf("Attack campaign %s: %d clients from %d networks (%s)",
+						campaign.ID, campaign.Clients, campaign.Networks, campaign.Name)
+				}
+			}
+			known = current
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetCampaigns returns the campaigns found by the latest clustering of
+// the botnet detector's clients, largest first
+func (ps *ProtectionService) GetCampaigns() ([]botnet.Campaign, error) {
+	if !ps.config.Protection.Botnet.Campaigns.Enabled {
+		return nil, fmt.Errorf("campaign clustering is disabled")
+	}
+	return ps.botnetDetector.Campaigns(), nil
+}
@@ -0,0 +1,394 @@
+package ddos
+
+import (
+	"context"
+	"crypto/rand"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/challenge"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultChallengePath is where challenges are answered unless
+	// configured otherwise
+	defaultChallengePath = "/_challenge"
+	// defaultPassCookie holds the pass of a client that solved a challenge
+	defaultPassCookie = "ddos_pass"
+	// defaultPowDifficulty is the zero bits proof-of-work puzzles need by
+	// default, a fraction of a second of a browser's time
+	defaultPowDifficulty = 18
+	// maxPowDifficulty keeps puzzles solvable by slow devices
+	maxPowDifficulty = 26
+	// puzzleDuration is how long a client has to solve a puzzle
+	puzzleDuration = 5 * time.Minute
+)
+
+// Challenge modes
+const (
+	challengeModeCaptcha = "captcha"
+	challengeModePow     = "pow"
+)
+
+// initChallenges lets suspected bots solve a CAPTCHA or a proof-of-work
+// puzzle instead of being blocked, earning a pass that spares them bot
+// detection for a while
+func (ps *ProtectionService) initChallenges() {
+	cfg := ps.config.Protection.Challenge
+	if !cfg.Enabled {
+		return
+	}
+	mode := cfg.Mode
+	if mode == "" {
+		mode = challengeModeCaptcha
+	}
+	switch mode {
+	case challengeModeCaptcha:
+		if ps.captcha == nil {
+			ps.logger.Warn("CAPTCHA challenges need a CAPTCHA provider; suspected bots will be blocked")
+			return
+		}
+	case challengeModePow:
+	default:
+		ps.logger.Errorf("Unknown challenge mode %q; suspected bots will be blocked", cfg.Mode)
+		return
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			ps.logger.Errorf("Failed to generate challenge secret: %v", err)
+			return
+		}
+		ps.logger.Warn("No challenge secret configured; passes only work on the instance that issued them")
+	}
+
+	duration := cfg.PassDuration.Duration()
+	if duration <= 0 {
+		duration = time.Hour
+	}
+
+	if mode == challengeModePow {
+		difficulty := cfg.PowDifficulty
+		if difficulty <= 0 {
+			difficulty = defaultPowDifficulty
+		}
+		if difficulty > maxPowDifficulty {
+			ps.logger.Warnf("Proof-of-work difficulty %d is too slow for many devices, using %d", difficulty, maxPowDifficulty)
+			difficulty = maxPowDifficulty
+		}
+		ps.puzzles = challenge.NewPuzzles(secret, difficulty, puzzleDuration)
+	}
+
+	ps.passes = challenge.NewPasses(secret, duration)
+	ps.logger.Infof("Bot challenges enabled at %s (mode: %s, passes last %s)", ps.challengePath(), mode, duration)
+}
+
+// challengePath returns the path challenges are answered at
+func (ps *ProtectionService) challengePath() string {
+	if path := ps.config.Protection.Challenge.Path; path != "" {
+		return path
+	}
+	return defaultChallengePath
+}
+
+// passCookie returns the name of the cookie holding a client's pass
+func (ps *ProtectionService) passCookie() string {
+	if name := ps.config.Protection.Challenge.Cookie; name != "" {
+		return name
+	}
+	return defaultPassCookie
+}
+
+// challengeMaxConfidence returns the bot confidence up to which clients are
+// challenged rather than blocked, defaulting to 0.95
+func (ps *ProtectionService) challengeMaxConfidence() float64 {
+	if c := ps.config.Protection.Challenge.MaxConfidence; c > 0 {
+		return c
+	}
+	return 0.95
+}
+
+// hasPass reports whether a request carries a valid pass for its client
+func (ps *ProtectionService) hasPass(info *pipeline.RequestInfo) bool {
+	if ps.passes == nil {
+		return false
+	}
+	cookie, err := info.Request.Cookie(ps.passCookie())
+	if err != nil {
+		return false
+	}
+	return ps.passes.Verify(cookie.Value, info.ClientIP, time.Now()) == nil
+}
+
+// challengeBot challenges a detected bot instead of blocking it. Repeated
+// challenges still count towards the greylist.
+func (ps *ProtectionService) challengeBot(info *pipeline.RequestInfo) pipeline.Verdict {
+	ps.strike(info.Request.Context(), info.ClientIP, "botnet challenged")
+	return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "botnet suspected"}
+}
+
+// challengeStage challenges requests without a pass on the routes that
+// require one, turning away flood tools that cannot run the challenge
+// before they reach the costlier stages
+func (ps *ProtectionService) challengeStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.passes == nil || !ps.effective(info).Settings.RequireChallenge || ps.hasPass(info) {
+		return pipeline.Next()
+	}
+	return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "challenge required"}
+}
+
+// isChallenge reports whether a request answers a challenge. Answers are
+// handled before the pipeline, which would challenge the client again.
+func (ps *ProtectionService) isChallenge(r *http.Request) bool {
+	return ps.passes != nil && r.URL.Path == ps.challengePath()
+}
+
+// serveChallenge shows the challenge page and checks the answer submitted
+// from it. Once it is solved, the client gets a pass cookie and is sent
+// back where it was going.
+func (ps *ProtectionService) serveChallenge(c *gin.Context, ip string) {
+	var target string
+	switch c.Request.Method {
+	case http.MethodGet:
+		ps.renderChallenge(c, http.StatusOK, ps.newChallengePage(ip, c.Query("return")))
+		return
+	case http.MethodPost:
+		target = c.PostForm("return")
+	default:
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ps.puzzles != nil {
+		if err := ps.puzzles.Check(c.PostForm("puzzle"), c.PostForm("nonce"), ip, time.Now()); err != nil {
+			ps.logger.WithField("ip", ip).Debugf("Proof-of-work challenge failed: %v", err)
+			page := ps.newChallengePage(ip, target)
+			page.Message = "Your browser could not be checked. Please try again."
+			ps.renderChallenge(c, http.StatusForbidden, page)
+			return
+		}
+	} else {
+		solved, err := ps.captcha.Verify(c.Request.Context(), ps.captcha.Response(c.Request), ip)
+		if err != nil {
+			ps.logger.Errorf("Failed to verify CAPTCHA for challenge from %s: %v", ip, err)
+			page := ps.newChallengePage(ip, target)
+			page.Message = "The check could not be verified. Please try again later."
+			ps.renderChallenge(c, http.StatusServiceUnavailable, page)
+			return
+		}
+		if !solved {
+			page := ps.newChallengePage(ip, target)
+			page.Message = "The check was not solved. Please try again."
+			ps.renderChallenge(c, http.StatusForbidden, page)
+			return
+		}
+	}
+
+	// A browser under automation can solve a challenge as well as any; the
+	// signs the page saw count against it, and withhold the pass
+	if flags := botnet.ParseTelemetry(c.PostForm("telemetry")); len(flags) > 0 {
+		ps.botnetDetector.RecordTelemetry(ip, flags)
+		if ps.botnetDetector.Scoring().Rules["automation_telemetry"].Enabled {
+			ps.logger.WithField("ip", ip).Infof("Bot challenge solved by an automated browser: %s", strings.Join(flags, ", "))
+			page := ps.newChallengePage(ip, target)
+			page.Message = "Your browser could not be checked. Please try again."
+			ps.renderChallenge(c, http.StatusForbidden, page)
+			return
+		}
+	}
+
+	ps.logger.WithField("ip", ip).Info("Bot challenge solved")
+	ps.vindicate(ip)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     ps.passCookie(),
+		Value:    ps.passes.Issue(ip, time.Now()),
+		Path:     "/",
+		MaxAge:   int(ps.passes.TTL() / time.Second),
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusSeeOther, challenge.SafeReturn(target))
+	c.Abort()
+}
+
+// challenge asks the client to prove it is a legitimate browser before
+// continuing. Browsers are shown a CAPTCHA or a proof-of-work page when
+// challenges are enabled; other clients, or all of them without
+// challenges, are turned away with a distinct code they can be told to
+// retry on.
+func (ps *ProtectionService) challenge(c *gin.Context, reason string) {
+	if ps.passes != nil && wantsHTML(c.Request) {
+		ps.renderChallenge(c, http.StatusForbidden, ps.newChallengePage(ps.getClientIP(c), c.Request.URL.RequestURI()))
+		return
+	}
+
+	body := gin.H{
+		"error":  "Challenge required",
+		"code":   "CHALLENGE_REQUIRED",
+		"reason": reason,
+	}
+	if ps.passes != nil {
+		body["challenge_url"] = ps.challengePath() + "?return=" + url.QueryEscape(c.Request.URL.RequestURI())
+	}
+	c.JSON(http.StatusForbidden, body)
+	c.Abort()
+}
+
+// wantsHTML reports whether a request is a page load that can show a
+// challenge
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// challengePage is what the challenge page shows: a CAPTCHA widget, or a
+// puzzle for the page's script to solve
+type challengePage struct {
+	Message    string
+	Action     string
+	Return     string
+	Widget     template.HTML
+	Puzzle     string
+	Difficulty int
+}
+
+// newChallengePage returns the challenge page for ip, sending it back to
+// target once solved
+func (ps *ProtectionService) newChallengePage(ip, target string) challengePage {
+	page := challengePage{
+		Action: ps.challengePath(),
+		Return: challenge.SafeReturn(target),
+	}
+	if ps.puzzles == nil {
+		page.Widget = ps.captcha.Widget()
+		return page
+	}
+	puzzle, err := ps.puzzles.Issue(ip, time.Now())
+	if err != nil {
+		ps.logger.Errorf("Failed to issue proof-of-work puzzle: %v", err)
+		page.Message = "Your browser could not be checked. Please try again later."
+		return page
+	}
+	page.Puzzle = puzzle
+	page.Difficulty = ps.puzzles.Difficulty()
+	return page
+}
+
+// renderChallenge writes the challenge page
+func (ps *ProtectionService) renderChallenge(c *gin.Context, status int, page challengePage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	if err := challengeTemplate.Execute(c.Writer, page); err != nil {
+		ps.logger.Errorf("Failed to render challenge page: %v", err)
+	}
+	c.Abort()
+}
+
+var challengeTemplate = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Checking your browser</title>
+<style>
+body { font-family: sans-serif; max-width: 40rem; margin: 3rem auto; color: #222; }
+</style>
+</head>
+<body>
+<h1>Checking your browser</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if or .Puzzle .Widget}}<script>
+// telemetry lists the signs of browser automation, sent with the solution
+function telemetry() {
+  var n = navigator, flags = [];
+  if (n.webdriver) flags.push("webdriver");
+  if (/HeadlessChrome/.test(n.userAgent)) flags.push("headless_user_agent");
+  if (!n.languages || n.languages.length === 0) flags.push("no_languages");
+  if (/Chrome\//.test(n.userAgent) && !window.chrome) flags.push("no_chrome_object");
+  if (window.outerWidth === 0 && window.outerHeight === 0) flags.push("zero_window");
+  for (var key in window) {
+    if (/^(\$?cdc_|__playwright|__pw|__puppeteer|_phantom|callPhantom|__nightmare|domAutomation)/.test(key)) { flags.push("automation_globals"); break; }
+  }
+  return flags.join(",");
+}
+</script>{{end}}
+{{if .Puzzle}}<p>This takes a moment and happens only once in a while.</p>
+<noscript><p>Enable JavaScript to continue.</p></noscript>
+<form id="challenge" method="post" action="{{.Action}}">
+<input type="hidden" name="return" value="{{.Return}}">
+<input type="hidden" name="puzzle" value="{{.Puzzle}}">
+<input type="hidden" name="nonce" value="">
+<input type="hidden" name="telemetry" value="">
+</form>
+<script>
+(function () {
+var K = [0x428a2f98,0x71374491,0xb5c0fbcf,0xe9b5dba5,0x3956c25b,0x59f111f1,0x923f82a4,0xab1c5ed5,0xd807aa98,0x12835b01,0x243185be,0x550c7dc3,0x72be5d74,0x80deb1fe,0x9bdc06a7,0xc19bf174,0xe49b69c1,0xefbe4786,0x0fc19dc6,0x240ca1cc,0x2de92c6f,0x4a7484aa,0x5cb0a9dc,0x76f988da,0x983e5152,0xa831c66d,0xb00327c8,0xbf597fc7,0xc6e00bf3,0xd5a79147,0x06ca6351,0x14292967,0x27b70a85,0x2e1b2138,0x4d2c6dfc,0x53380d13,0x650a7354,0x766a0abb,0x81c2c92e,0x92722c85,0xa2bfe8a1,0xa81a664b,0xc24b8b70,0xc76c51a3,0xd192e819,0xd6990624,0xf40e3585,0x106aa070,0x19a4c116,0x1e376c08,0x2748774c,0x34b0bcb5,0x391c0cb3,0x4ed8aa4a,0x5b9cca4f,0x682e6ff3,0x748f82ee,0x78a5636f,0x84c87814,0x8cc70208,0x90befffa,0xa4506ceb,0xbef9a3f7,0xc67178f2];
+function rotr(x, n) { return (x >>> n) | (x << (32 - n)); }
+// sha256 hashes an ASCII string into eight 32-bit words
+function sha256(s) {
+  var h = [0x6a09e667,0xbb67ae85,0x3c6ef372,0xa54ff53a,0x510e527f,0x9b05688c,0x1f83d9ab,0x5be0cd19];
+  var n = ((s.length + 8) >> 6) + 1, m = new Array(n * 16).fill(0), w = new Array(64), i, j;
+  for (i = 0; i < s.length; i++) m[i >> 2] |= s.charCodeAt(i) << (24 - (i % 4) * 8);
+  m[i >> 2] |= 0x80 << (24 - (i % 4) * 8);
+  m[n * 16 - 1] = s.length * 8;
+  for (var b = 0; b < n; b++) {
+    for (i = 0; i < 64; i++) {
+      if (i < 16) { w[i] = m[b * 16 + i]; continue; }
+      var s0 = rotr(w[i-15], 7) ^ rotr(w[i-15], 18) ^ (w[i-15] >>> 3);
+      var s1 = rotr(w[i-2], 17) ^ rotr(w[i-2], 19) ^ (w[i-2] >>> 10);
+      w[i] = (w[i-16] + s0 + w[i-7] + s1) | 0;
+    }
+    var a = h.slice();
+    for (i = 0; i < 64; i++) {
+      var t1 = (a[7] + (rotr(a[4], 6) ^ rotr(a[4], 11) ^ rotr(a[4], 25)) + ((a[4] & a[5]) ^ (~a[4] & a[6])) + K[i] + w[i]) | 0;
+      var t2 = ((rotr(a[0], 2) ^ rotr(a[0], 13) ^ rotr(a[0], 22)) + ((a[0] & a[1]) ^ (a[0] & a[2]) ^ (a[1] & a[2]))) | 0;
+      a = [(t1 + t2) | 0, a[0], a[1], a[2], (a[3] + t1) | 0, a[4], a[5], a[6]];
+    }
+    for (j = 0; j < 8; j++) h[j] = (h[j] + a[j]) | 0;
+  }
+  return h;
+}
+function zeros(h) {
+  for (var i = 0, z = 0; i < h.length; i++) { var c = Math.clz32(h[i]); z += c; if (c < 32) break; }
+  return z;
+}
+var form = document.getElementById("challenge"), puzzle = form.elements.puzzle.value, bits = {{.Difficulty}}, nonce = 0;
+// Search in batches so the page stays responsive
+function step() {
+  for (var end = nonce + 5000; nonce < end; nonce++) {
+    if (zeros(sha256(puzzle + ":" + nonce)) >= bits) {
+      form.elements.nonce.value = nonce;
+      form.elements.telemetry.value = telemetry();
+      form.submit();
+      return;
+    }
+  }
+  setTimeout(step, 0);
+}
+step();
+})();
+</script>
+{{else if .Widget}}<p>Unusual traffic was seen from your connection. Solve the check below to continue.</p>
+<form id="challenge" method="post" action="{{.Action}}">
+<input type="hidden" name="return" value="{{.Return}}">
+<input type="hidden" name="telemetry" value="">
+{{.Widget}}
+<p><button type="submit">Continue</button></p>
+</form>
+<script>
+document.getElementById("challenge").addEventListener("submit", function () { this.elements.telemetry.value = telemetry(); });
+</script>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,82 @@
+package ddos
+
+import (
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	"ddos-protection/internal/clientid"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultClientCookie holds a client's tracking ID unless configured
+// otherwise
+const defaultClientCookie = "ddos_client"
+
+// initClientTracking tags clients with a signed ID cookie, so bot
+// detection can follow each client behind a shared address
+func (ps *ProtectionService) initClientTracking() {
+	cfg := ps.config.Protection.ClientTracking
+	if !cfg.Enabled {
+		return
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			ps.logger.Errorf("Failed to generate client tracking secret: %v", err)
+			return
+		}
+		ps.logger.Warn("No client tracking secret configured; client IDs only work on the instance that issued them")
+	}
+
+	maxAge := cfg.MaxAge.Duration()
+	if maxAge <= 0 {
+		maxAge = 30 * 24 * time.Hour
+	}
+
+	ps.clientIDs = clientid.NewIssuer(secret, maxAge)
+	ps.logger.Infof("Client tracking enabled (cookie: %s, max age: %s)", ps.clientCookie(), maxAge)
+}
+
+// clientCookie returns the name of the cookie holding a client's ID
+func (ps *ProtectionService) clientCookie() string {
+	if name := ps.config.Protection.ClientTracking.Cookie; name != "" {
+		return name
+	}
+	return defaultClientCookie
+}
+
+// identifyClient reads the client's ID from its cookie into
+// info.Values["client_id"], issuing a new one with the response when the
+// request carries none that is valid
+func (ps *ProtectionService) identifyClient(c *gin.Context, info *pipeline.RequestInfo) {
+	if ps.clientIDs == nil {
+		return
+	}
+	now := time.Now()
+	if cookie, err := c.Request.Cookie(ps.clientCookie()); err == nil {
+		if id, err := ps.clientIDs.Verify(cookie.Value, now); err == nil {
+			info.Values["client_id"] = id
+			return
+		}
+	}
+
+	_, token, err := ps.clientIDs.Issue(now)
+	if err != nil {
+		ps.logger.Errorf("Failed to issue client ID: %v", err)
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     ps.clientCookie(),
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(ps.clientIDs.MaxAge() / time.Second),
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,133 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/signals"
+	"ddos-protection/pkg/pipeline"
+)
+
+// initCompositeSignals loads the composite signals from the config
+func (ps *ProtectionService) initCompositeSignals() {
+	cfg := ps.config.Protection.CompositeSignals
+	if !cfg.Enabled {
+		return
+	}
+
+	ps.compositeSignals = signals.NewEngine()
+	ps.datacenterASNs = make(map[uint32]bool, len(cfg.DatacenterASNs))
+	for _, asn := range cfg.DatacenterASNs {
+		ps.datacenterASNs[asn] = true
+	}
+	for _, c := range cfg.Signals {
+		composite := signals.Composite{
+			Name:       c.Name,
+			Expression: c.Expression,
+			Weights:    c.Weights,
+			Threshold:  c.Threshold,
+			Risk:       c.Risk,
+		}
+		if err := ps.compositeSignals.Set(composite); err != nil {
+			ps.logger.Warnf("Ignoring composite signal: %v", err)
+		}
+	}
+	ps.logger.Infof("Composite signals enabled (%d defined)", len(ps.compositeSignals.List()))
+}
+
+// requestSignals gathers the signals raised for a request: the botnet
+// indicators, and facts about the client from earlier stages and lookups
+func (ps *ProtectionService) requestSignals(info *pipeline.RequestInfo, analysis *botnet.BotnetAnalysis) map[string]bool {
+	raised := make(map[string]bool, len(analysis.Signals)+4)
+	for _, signal := range analysis.Signals {
+		raised[signal] = true
+	}
+
+	if asn, found := ps.ipManager.LookupASN(info.ClientIP); found {
+		raised["asn:"+strconv.FormatUint(uint64(asn.Number), 10)] = true
+		if ps.datacenterASNs[asn.Number] {
+			raised["datacenter_asn"] = true
+		}
+	}
+	if country, ok := info.Values["country"].(string); ok && country != "" {
+		raised["country:"+country] = true
+	}
+	if zones, ok := info.Values["dnsbl_zones"].([]string); ok && len(zones) > 0 {
+		raised["dnsbl_listed"] = true
+	}
+	if info.Request.TLS != nil {
+		raised["tls"] = true
+	}
+	return raised
+}
+
+// applyCompositeSignals adds the risk of the composite signals holding for
+// a request to its botnet analysis. Risk turns into confidence at the
+// built-in detector's rate, so a match can tip a client over the detection
+// threshold.
+func (ps *ProtectionService) applyCompositeSignals(info *pipeline.RequestInfo, analysis *botnet.BotnetAnalysis) *botnet.BotnetAnalysis {
+	if ps.compositeSignals == nil {
+		return analysis
+	}
+	matched := ps.compositeSignals.Evaluate(ps.requestSignals(info, analysis))
+	if len(matched) == 0 {
+		return analysis
+	}
+
+	divisor := ps.botnetDetector.Scoring().RiskDivisor
+	result := *analysis
+	result.Indicators = append([]string{}, analysis.Indicators...)
+	result.Signals = append([]string{}, analysis.Signals...)
+	for _, c := range matched {
+		result.Indicators = append(result.Indicators, "Composite signal "+c.Name)
+		result.Signals = append(result.Signals, c.Name)
+		result.RiskScore += c.Risk
+		result.Confidence += float64(c.Risk) / divisor
+	}
+	if result.Confidence > 1 {
+		result.Confidence = 1
+	}
+	result.IsBotnet = result.IsBotnet || result.Confidence >= ps.botnetThreshold()
+	return &result
+}
+
+// GetCompositeSignals returns the composite signals
+func (ps *ProtectionService) GetCompositeSignals() ([]signals.Composite, error) {
+	if ps.compositeSignals == nil {
+		return nil, fmt.Errorf("composite signals are disabled")
+	}
+	return ps.compositeSignals.List(), nil
+}
+
+// SetCompositeSignal adds or replaces a composite signal
+func (ps *ProtectionService) SetCompositeSignal(ctx context.Context, c signals.Composite) error {
+	if ps.compositeSignals == nil {
+		return fmt.Errorf("composite signals are disabled")
+	}
+
+	var before interface{}
+	if existing, found := ps.compositeSignals.Get(c.Name); found {
+		before = existing
+	}
+	if err := ps.compositeSignals.Set(c); err != nil {
+		return err
+	}
+	ps.audit(ctx, "composite_signal.set", c.Name, before, c)
+	return nil
+}
+
+// RemoveCompositeSignal removes a composite signal
+func (ps *ProtectionService) RemoveCompositeSignal(ctx context.Context, name string) error {
+	if ps.compositeSignals == nil {
+		return fmt.Errorf("composite signals are disabled")
+	}
+
+	existing, _ := ps.compositeSignals.Get(name)
+	if err := ps.compositeSignals.Remove(name); err != nil {
+		return err
+	}
+	ps.audit(ctx, "composite_signal.remove", name, existing, nil)
+	return nil
+}
@@ -0,0 +1,84 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ddos-protection/internal/audit"
+	"ddos-protection/internal/crawler"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initCrawlers sets up verification of search engine crawlers
+func (ps *ProtectionService) initCrawlers() {
+	cfg := ps.config.Protection.Crawlers
+	if !cfg.Enabled {
+		return
+	}
+
+	profiles := crawler.DefaultProfiles()
+	if len(cfg.Profiles) > 0 {
+		profiles = nil
+		for _, pc := range cfg.Profiles {
+			profile, err := crawler.NewProfile(pc.Name, pc.UserAgents, pc.Domains)
+			if err != nil {
+				ps.logger.Warnf("Invalid crawler profile: %v", err)
+				continue
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+
+	timeout := cfg.Timeout.Duration()
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	cacheTTL := cfg.CacheTTL.Duration()
+	if cacheTTL <= 0 {
+		cacheTTL = 24 * time.Hour
+	}
+
+	ps.crawlerVerifier = crawler.NewVerifier(profiles, nil, timeout, cacheTTL, ps.whitelistCrawler)
+	ps.logger.Infof("Crawler verification enabled for %v (impostor action: %s)", ps.crawlerVerifier.Names(), cfg.ImpostorAction)
+}
+
+// whitelistCrawler temporarily whitelists a verified crawler. Addresses
+// that are already whitelisted permanently are left alone.
+func (ps *ProtectionService) whitelistCrawler(ip string, result crawler.Result) {
+	if entry, ok := ps.ipManager.LookupWhitelist(ip); ok && entry.Expires == nil {
+		return
+	}
+
+	duration := ps.config.Protection.Crawlers.WhitelistDuration.Duration()
+	if duration <= 0 {
+		duration = 24 * time.Hour
+	}
+
+	ctx := audit.WithActor(context.Background(), "crawler:"+result.Crawler)
+	if err := ps.WhitelistIP(ctx, ip, duration); err != nil {
+		ps.logger.Errorf("Failed to whitelist crawler %s: %v", ip, err)
+		return
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"ip":       ip,
+		"crawler":  result.Crawler,
+		"hostname": result.Hostname,
+		"duration": duration.String(),
+	}).Info("Verified crawler whitelisted")
+}
+
+// VerifyCrawler checks whether an IP belongs to the crawler userAgent claims
+func (ps *ProtectionService) VerifyCrawler(ctx context.Context, ip, userAgent string) (crawler.Result, error) {
+	if ps.crawlerVerifier == nil {
+		return crawler.Result{}, fmt.Errorf("crawler verification is disabled")
+	}
+
+	profile := ps.crawlerVerifier.Claimed(userAgent)
+	if profile == nil {
+		return crawler.Result{}, crawler.ErrNotClaimed
+	}
+	return ps.crawlerVerifier.Verify(ctx, ip, profile)
+}
@@ -0,0 +1,47 @@
+package ddos
+
+import (
+	"ddos-protection/internal/botnet"
+)
+
+// initDetectors sets up the ensemble the botnet stage consults, starting
+// with the built-in detector. More can be registered through Detectors.
+func (ps *ProtectionService) initDetectors() {
+	cfg := ps.config.Protection.Botnet
+	aggregator, err := botnet.ParseAggregator(cfg.Aggregator)
+	if err != nil {
+		ps.logger.Warnf("%v, combining detector scores with max", err)
+		aggregator = botnet.MaxAggregator
+	}
+
+	ps.detectors = botnet.NewEnsemble(aggregator, ps.botnetThreshold())
+	for name, weight := range cfg.Weights {
+		ps.detectors.SetWeight(name, weight)
+	}
+	ps.detectors.Register(ps.botnetDetector, 1)
+
+	if sampling := cfg.Sampling; sampling.Enabled {
+		ps.botnetSampler = botnet.NewSampler(ps.detectors, botnet.SamplingOptions{
+			Rate:     sampling.Rate,
+			MinRPS:   sampling.MinRPS,
+			TTL:      sampling.TTL.Duration(),
+			Capacity: sampling.Capacity,
+		})
+		ps.logger.Info("Botnet analysis sampled under load")
+	}
+}
+
+// botnetAnalyzer returns what the botnet stage consults: the ensemble, or
+// the sampler wrapping it
+func (ps *ProtectionService) botnetAnalyzer() botnet.Detector {
+	if ps.botnetSampler != nil {
+		return ps.botnetSampler
+	}
+	return ps.detectors
+}
+
+// Detectors returns the botnet detectors whose scores decide whether a
+// client is a bot, for registering custom heuristics
+func (ps *ProtectionService) Detectors() *botnet.Ensemble {
+	return ps.detectors
+}
@@ -0,0 +1,117 @@
+package ddos
+
+import (
+	"net/netip"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/edge"
+)
+
+// defaultEdgeSources are the ban sources mirrored to the edge by default:
+// the automatic ones
+var defaultEdgeSources = []string{
+	string(blacklist.SourceRateLimit),
+	string(blacklist.SourceBotnet),
+	string(blacklist.SourceGreylist),
+	string(blacklist.SourceTrafficAlert),
+	string(blacklist.SourceSubnet),
+	string(blacklist.SourceOpenProxy),
+	string(blacklist.SourceCredentials),
+	string(blacklist.SourceSlowRequests),
+}
+
+// initEdge sets up mirroring bans from the shared blacklist into a CDN or
+// edge WAF. Every instance mirrors the same lists, so rules another
+// instance already added are skipped.
+func (ps *ProtectionService) initEdge() {
+	cfg := ps.config.Protection.IPBlacklist.Edge
+	if !cfg.Enabled || !ps.config.Protection.IPBlacklist.Enabled {
+		return
+	}
+
+	note := cfg.Note
+	if note == "" {
+		note = "ddos-protection"
+	}
+	timeout := cfg.Timeout.Duration()
+
+	var provider edge.Provider
+	var err error
+	switch cfg.Provider {
+	case "", "cloudflare":
+		provider, err = edge.NewCloudflare(edge.CloudflareConfig{
+			BaseURL:   cfg.Cloudflare.BaseURL,
+			APIToken:  cfg.Cloudflare.APIToken,
+			ZoneID:    cfg.Cloudflare.ZoneID,
+			AccountID: cfg.Cloudflare.AccountID,
+			Mode:      cfg.Cloudflare.Mode,
+			Note:      note,
+			Timeout:   timeout,
+		})
+	case "http":
+		provider, err = edge.NewHTTP(cfg.HTTP.URL, cfg.HTTP.Token, note, timeout)
+	default:
+		ps.logger.Errorf("Unknown edge provider %q, edge mirroring disabled", cfg.Provider)
+		return
+	}
+	if err != nil {
+		ps.logger.Errorf("Failed to set up edge mirroring: %v", err)
+		return
+	}
+
+	rps := cfg.RequestsPerSecond
+	if rps <= 0 {
+		rps = 4
+	}
+	interval := cfg.ReconcileInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	delay := cfg.BatchDelayMs.Duration()
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	sources := cfg.Sources
+	if len(sources) == 0 {
+		sources = defaultEdgeSources
+	}
+	mirrored := banSources(sources)
+
+	ps.edgeMirror = edge.NewMirror(provider, func() map[netip.Prefix]time.Time {
+		return ps.bansFrom(mirrored)
+	}, rps, interval, delay, cfg.MaxRules)
+	ps.ipManager.OnChange(func(string) { ps.edgeMirror.Notify() })
+
+	ps.logger.Infof("Edge mirroring enabled (provider: %s, sources: %v, %.1f calls/s)", provider.Name(), sources, rps)
+}
+
+// banSources returns the set of named ban sources, or nil for all sources
+// if names is empty
+func banSources(names []string) map[blacklist.Source]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	sources := make(map[blacklist.Source]bool, len(names))
+	for _, name := range names {
+		sources[blacklist.Source(name)] = true
+	}
+	return sources
+}
+
+// bansFrom returns the enforced shared bans from the given sources, or from
+// every source if sources is nil
+func (ps *ProtectionService) bansFrom(sources map[blacklist.Source]bool) map[netip.Prefix]time.Time {
+	entries := ps.ipManager.EnforcedBlacklist()
+	bans := make(map[netip.Prefix]time.Time, len(entries))
+	for _, entry := range entries {
+		if sources != nil && !sources[entry.Source] {
+			continue
+		}
+		if prefix, ok := targetPrefix(entry.Target); ok {
+			bans[prefix] = entry.Expires
+		}
+	}
+	return bans
+}
@@ -0,0 +1,83 @@
+package ddos
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/enforce"
+)
+
+// initEnforcement sets up pushing bans into the kernel firewall. List
+// changes from every instance are applied, since each host has its own
+// firewall.
+func (ps *ProtectionService) initEnforcement() {
+	cfg := ps.config.Protection.IPBlacklist.Enforcement
+	if !cfg.Enabled || !ps.config.Protection.IPBlacklist.Enabled {
+		return
+	}
+
+	var driver enforce.Driver
+	switch cfg.Driver {
+	case "", "nftables":
+		driver = enforce.NewNFTables(cfg.Table, cfg.ManageRules, enforce.Exec)
+	case "ipset":
+		driver = enforce.NewIPSet(cfg.Table, enforce.Exec)
+	default:
+		ps.logger.Errorf("Unknown enforcement driver %q, kernel enforcement disabled", cfg.Driver)
+		return
+	}
+
+	interval := cfg.ReconcileInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	delay := cfg.BatchDelayMs.Duration()
+	if delay <= 0 {
+		delay = 200 * time.Millisecond
+	}
+
+	ps.enforcer = enforce.NewEnforcer(driver, ps.enforcementSource, interval, delay)
+	ps.ipManager.OnChange(func(string) { ps.enforcer.Notify() })
+
+	ps.logger.Infof("Kernel enforcement enabled (driver: %s, reconcile interval: %v)", driver.Name(), interval)
+}
+
+// enforcementSource returns the bans and allowances the firewall should
+// hold: enforced blacklist entries and local whitelist entries
+func (ps *ProtectionService) enforcementSource() (map[netip.Prefix]time.Time, map[netip.Prefix]time.Time) {
+	entries := ps.ipManager.EnforcedBlacklist()
+	bans := make(map[netip.Prefix]time.Time, len(entries))
+	for _, entry := range entries {
+		if prefix, ok := targetPrefix(entry.Target); ok {
+			bans[prefix] = entry.Expires
+		}
+	}
+
+	whitelist := ps.ipManager.GetWhitelist()
+	allows := make(map[netip.Prefix]time.Time, len(whitelist))
+	for _, entry := range whitelist {
+		if prefix, ok := targetPrefix(entry.IP); ok {
+			var expires time.Time
+			if entry.Expires != nil {
+				expires = *entry.Expires
+			}
+			allows[prefix] = expires
+		}
+	}
+	return bans, allows
+}
+
+// targetPrefix parses a list target, an IP or CIDR range
+func targetPrefix(target string) (netip.Prefix, bool) {
+	if strings.Contains(target, "/") {
+		prefix, err := netip.ParsePrefix(target)
+		return prefix.Masked(), err == nil
+	}
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
@@ -0,0 +1,80 @@
+package ddos
+
+import (
+	"time"
+
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/events"
+	"ddos-protection/internal/stream"
+)
+
+// Types of streamed records
+const (
+	exportAnalysis = "analysis"
+	exportDecision = "decision"
+)
+
+// exportRecord is a botnet analysis or a blocking decision as streamed
+type exportRecord struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"time"`
+	IP       string                 `json:"ip"`
+	Path     string                 `json:"path,omitempty"`
+	Tenant   string                 `json:"tenant,omitempty"`
+	Analysis *botnet.BotnetAnalysis `json:"analysis,omitempty"`
+	Decision *events.Event          `json:"decision,omitempty"`
+}
+
+// initExport sets up streaming of botnet analyses and blocking decisions
+// to Kafka or NATS
+func (ps *ProtectionService) initExport() {
+	cfg := ps.config.Export
+	if !cfg.Enabled {
+		return
+	}
+
+	sink, err := stream.NewSink(cfg.Sink, cfg.URL, cfg.Topic, cfg.Timeout.Duration())
+	if err != nil {
+		ps.logger.Errorf("Failed to set up export: %v", err)
+		return
+	}
+	ps.exporter = stream.NewExporter(sink, cfg.Sink, stream.Options{
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval.Duration(),
+		QueueSize:     cfg.QueueSize,
+		MaxAttempts:   cfg.MaxAttempts,
+		Backoff:       cfg.BackoffMs.Duration(),
+	})
+	ps.logger.Infof("Exporting botnet analyses to %s %s", cfg.Sink, cfg.Topic)
+}
+
+// exportAnalysisRecord streams a botnet analysis of a request
+func (ps *ProtectionService) exportAnalysisRecord(path, tenant string, analysis *botnet.BotnetAnalysis) {
+	if ps.exporter == nil {
+		return
+	}
+	ps.exporter.Send(analysis.IP, exportRecord{
+		Type:     exportAnalysis,
+		Time:     analysis.Timestamp,
+		IP:       analysis.IP,
+		Path:     path,
+		Tenant:   tenant,
+		Analysis: analysis,
+	})
+}
+
+// exportDecisionRecord streams a decision to deny, challenge or slow down
+// a request
+func (ps *ProtectionService) exportDecisionRecord(event events.Event) {
+	if ps.exporter == nil {
+		return
+	}
+	ps.exporter.Send(event.IP, exportRecord{
+		Type:     exportDecision,
+		Time:     event.Time,
+		IP:       event.IP,
+		Path:     event.Path,
+		Tenant:   event.Tenant,
+		Decision: &event,
+	})
+}
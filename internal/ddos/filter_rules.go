@@ -0,0 +1,265 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/filter"
+	"ddos-protection/internal/jsonschema"
+)
+
+// filterRulesFiles returns the rules file and ModSecurity rule files
+// configured
+func (ps *ProtectionService) filterRulesFiles() []string {
+	cfg := ps.config.Protection.RequestFilter
+	var paths []string
+	if cfg.RulesFile != "" {
+		paths = append(paths, cfg.RulesFile)
+	}
+	return append(paths, cfg.CRSFiles...)
+}
+
+// loadFilterRules loads the request filter's custom rules from the rules
+// files on start
+func (ps *ProtectionService) loadFilterRules() {
+	paths := ps.filterRulesFiles()
+	if len(paths) == 0 {
+		return
+	}
+	if _, err := ps.requestFilter.LoadRules(paths...); err != nil {
+		ps.logger.Errorf("Failed to load filter rules: %v", err)
+		return
+	}
+	ps.logger.Infof("Loaded %d filter rules from %s", len(ps.requestFilter.CustomRules()), strings.Join(paths, ", "))
+	ps.logSkippedRules()
+}
+
+// logSkippedRules reports the ModSecurity rules that could not be loaded
+func (ps *ProtectionService) logSkippedRules() {
+	skipped := ps.requestFilter.SkippedRules()
+	if len(skipped) == 0 {
+		return
+	}
+	ps.logger.Warnf("Skipped %d unsupported ModSecurity rules", len(skipped))
+	for _, skip := range skipped {
+		ps.logger.Debugf("Skipped rule %s", skip)
+	}
+}
+
+// filterRulesRoutine reloads the custom filter rules whenever one of the
+// rules files changes
+func (ps *ProtectionService) filterRulesRoutine(ctx context.Context) {
+	interval := ps.config.Protection.RequestFilter.RulesReloadInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := ps.ReloadFilterRules(ctx); err != nil {
+				ps.logger.Errorf("Failed to reload filter rules: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReloadFilterRules reloads the custom filter rules if a rules file
+// changed, and reports whether it did. A file that fails to parse leaves
+// the rules in force.
+func (ps *ProtectionService) ReloadFilterRules(ctx context.Context) (bool, error) {
+	paths := ps.filterRulesFiles()
+	if len(paths) == 0 {
+		return false, fmt.Errorf("no filter rules file configured")
+	}
+
+	before := ps.requestFilter.CustomRules()
+	reloaded, err := ps.requestFilter.LoadRules(paths...)
+	if err != nil || !reloaded {
+		return false, err
+	}
+	after := ps.requestFilter.CustomRules()
+	ps.audit(ctx, "rules.file", strings.Join(paths, ","), before, after)
+	ps.logger.Infof("Reloaded %d filter rules from %s", len(after), strings.Join(paths, ", "))
+	ps.logSkippedRules()
+	return true, nil
+}
+
+// GetFilterRules returns the custom filter rules in force
+func (ps *ProtectionService) GetFilterRules() []filter.FileRule {
+	return ps.requestFilter.CustomRules()
+}
+
+// loadRuleActions sets the actions of individual rules that differ from
+// their default
+func (ps *ProtectionService) loadRuleActions() {
+	cfg := ps.config.Protection.RequestFilter.RuleActions
+	if len(cfg) == 0 {
+		return
+	}
+	actions := make(map[string]filter.Action, len(cfg))
+	for id, action := range cfg {
+		actions[id] = filter.Action(action)
+	}
+	if err := ps.requestFilter.SetRuleActions(actions); err != nil {
+		ps.logger.Errorf("Invalid filter rule actions, using the defaults: %v", err)
+	}
+}
+
+// loadCategoryPolicies sets the handling of the attack categories that
+// differs from their default
+func (ps *ProtectionService) loadCategoryPolicies() {
+	cfg := ps.config.Protection.RequestFilter.Categories
+	if len(cfg) == 0 {
+		return
+	}
+	policies := make(map[string]filter.CategoryPolicy, len(cfg))
+	for category, policy := range cfg {
+		policies[category] = filter.CategoryPolicy{
+			Action:    filter.Action(policy.Action),
+			Score:     policy.Score,
+			Threshold: policy.Threshold,
+		}
+	}
+	if err := ps.requestFilter.SetCategoryPolicies(policies); err != nil {
+		ps.logger.Errorf("Invalid filter categories, using the defaults: %v", err)
+	}
+}
+
+// GetCategoryPolicies returns the handling of each attack category
+func (ps *ProtectionService) GetCategoryPolicies() map[string]filter.CategoryPolicy {
+	return ps.requestFilter.CategoryPolicies()
+}
+
+// loadRuleSets defines the rule sets overrides can select for a route
+func (ps *ProtectionService) loadRuleSets() {
+	cfg := ps.config.Protection.RequestFilter.RuleSets
+	sets := make([]filter.RuleSet, 0, len(cfg))
+	for _, s := range cfg {
+		sets = append(sets, filter.RuleSet{Name: s.Name, Include: s.Include, Exclude: s.Exclude})
+	}
+	if err := ps.requestFilter.SetRuleSets(sets); err != nil {
+		ps.logger.Errorf("Invalid filter rule sets, applying all rules everywhere: %v", err)
+	}
+	if ps.overrides == nil {
+		return
+	}
+	for _, l := range ps.overrides.Layers() {
+		if l.FilterRuleSet != "" && !ps.requestFilter.HasRuleSet(l.FilterRuleSet) {
+			ps.logger.Errorf("Override %s names unknown filter rule set %q, applying all rules", l.Name(), l.FilterRuleSet)
+		}
+	}
+}
+
+// loadSchemas compiles the JSON Schemas of the routes whose bodies are
+// validated. A schema that fails to load disables validation for all
+// routes rather than some.
+func (ps *ProtectionService) loadSchemas() {
+	cfg := ps.config.Protection.RequestFilter.Schemas
+	if len(cfg) == 0 {
+		return
+	}
+
+	routes := make([]filter.RouteSchema, 0, len(cfg))
+	for _, s := range cfg {
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			ps.logger.Errorf("Failed to load schema %s, not validating bodies: %v", s.Name, err)
+			return
+		}
+		schema, err := jsonschema.Compile(data)
+		if err != nil {
+			ps.logger.Errorf("Invalid schema %s in %s, not validating bodies: %v", s.Name, s.File, err)
+			return
+		}
+		routes = append(routes, filter.RouteSchema{
+			Name:        s.Name,
+			Methods:     s.Methods,
+			Paths:       s.Paths,
+			Action:      filter.Action(s.Action),
+			Severity:    s.Severity,
+			MaxBodySize: int64(s.MaxBodySize),
+			Schema:      schema,
+		})
+	}
+	if err := ps.requestFilter.SetSchemas(routes); err != nil {
+		ps.logger.Errorf("Invalid route schemas, not validating bodies: %v", err)
+		return
+	}
+	ps.logger.Infof("Validating request bodies of %d routes against JSON schemas", len(routes))
+}
+
+// GetSchemas returns the routes whose bodies are validated
+func (ps *ProtectionService) GetSchemas() []filter.RouteSchema {
+	return ps.requestFilter.Schemas()
+}
+
+// loadUploadPolicy sets the checks on multipart uploads and connects the
+// scanner they go to. A policy that fails to load leaves uploads
+// unchecked.
+func (ps *ProtectionService) loadUploadPolicy() {
+	cfg := ps.config.Protection.RequestFilter.Uploads
+	if !cfg.Enabled {
+		return
+	}
+
+	policy := &filter.UploadPolicy{
+		MaxFileSize:       int64(cfg.MaxFileSize),
+		MaxFiles:          cfg.MaxFiles,
+		AllowedExtensions: cfg.AllowedExtensions,
+		AllowedTypes:      cfg.AllowedTypes,
+		VerifyMagic:       cfg.VerifyMagic,
+		FailClosed:        cfg.Scanner.FailClosed,
+		Action:            filter.Action(cfg.Action),
+		Severity:          cfg.Severity,
+	}
+	var err error
+	switch timeout := cfg.Scanner.Timeout.Duration(); cfg.Scanner.Type {
+	case "":
+	case "clamd":
+		policy.Scanner, err = filter.NewClamdScanner(cfg.Scanner.Address, timeout)
+	case "icap":
+		policy.Scanner, err = filter.NewICAPScanner(cfg.Scanner.Address, timeout)
+	default:
+		err = fmt.Errorf("unknown scanner type %q", cfg.Scanner.Type)
+	}
+	if err == nil {
+		err = ps.requestFilter.SetUploadPolicy(policy)
+	}
+	if err != nil {
+		ps.logger.Errorf("Invalid upload policy, not checking uploads: %v", err)
+		return
+	}
+	ps.logger.Info("Checking uploaded files")
+}
+
+// loadProtocolPolicy sets the sanity checks on request framing and
+// headers. A policy that fails to load leaves them off.
+func (ps *ProtectionService) loadProtocolPolicy() {
+	cfg := ps.config.Protection.RequestFilter.Protocol
+	if !cfg.Enabled {
+		return
+	}
+
+	checks := make(map[string]filter.ProtocolCheck, len(cfg.Checks))
+	for kind, check := range cfg.Checks {
+		checks[kind] = filter.ProtocolCheck{Action: filter.Action(check.Action), Severity: check.Severity}
+	}
+	err := ps.requestFilter.SetProtocolPolicy(&filter.ProtocolPolicy{
+		MaxHeaders:     cfg.MaxHeaders,
+		MaxHeaderSize:  int(cfg.MaxHeaderSize),
+		MaxHeadersSize: int(cfg.MaxHeadersSize),
+		AllowedHeaders: cfg.AllowedHeaders,
+		Checks:         checks,
+	})
+	if err != nil {
+		ps.logger.Errorf("Invalid protocol checks, not checking requests: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package ddos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"ddos-protection/pkg/pipeline"
+)
+
+// identifySession reads the session cookie or bearer token a request
+// authenticated with into info.Values["identity"], hashed so tokens never
+// reach the detector's state, exports or logs
+func (ps *ProtectionService) identifySession(info *pipeline.RequestInfo) {
+	cfg := ps.config.Protection.Identity
+	if !cfg.Enabled {
+		return
+	}
+
+	var token string
+	if cfg.BearerTokens {
+		if auth := info.Request.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			token = "bearer:" + strings.TrimSpace(auth[7:])
+		}
+	}
+	for _, name := range cfg.SessionCookies {
+		if token != "" {
+			break
+		}
+		if cookie, err := info.Request.Cookie(name); err == nil && cookie.Value != "" {
+			token = name + ":" + cookie.Value
+		}
+	}
+	if token == "" {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	info.Values["identity"] = hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,125 @@
+package ddos
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"ddos-protection/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMeshClientIPHeader is where Envoy puts the address of the client
+// that connected to the mesh edge
+const defaultMeshClientIPHeader = "X-Envoy-External-Address"
+
+// meshCheckKey marks a request as an external authorization check rather
+// than a request the protection layer serves itself
+const meshCheckKey = "mesh_check"
+
+// initMesh sets up the external authorization server that lets a service
+// mesh run the protection pipeline without proxying traffic through this
+// service. It speaks Envoy's HTTP ext_authz protocol, as used by Istio
+// CUSTOM authorization policies: the check request carries the original
+// method, path and headers, a 200 response allows the request, and any other
+// response is returned to the client as the denial.
+func (ps *ProtectionService) initMesh() {
+	cfg := ps.config.Mesh
+	if !cfg.Enabled {
+		return
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(ps.meshCheckRequest)
+	router.Use(ps.ProtectionMiddleware())
+	router.NoRoute(ps.meshAllow)
+
+	ps.meshServer = &http.Server{
+		Addr:    cfg.Listen,
+		Handler: router,
+	}
+
+	ps.logger.Infof("Mesh external authorization enabled on %s", cfg.Listen)
+}
+
+// meshCheckRequest turns an ext_authz check into the original request: it
+// strips the configured path prefix and makes the mesh-provided client
+// address the request's remote address, dropping X-Forwarded-For and
+// X-Real-IP, which arrive from the client.
+func (ps *ProtectionService) meshCheckRequest(c *gin.Context) {
+	cfg := ps.config.Mesh
+	c.Set(meshCheckKey, true)
+
+	if cfg.PathPrefix != "" {
+		path := strings.TrimPrefix(c.Request.URL.Path, strings.TrimSuffix(cfg.PathPrefix, "/"))
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		c.Request.URL.Path = path
+		c.Request.URL.RawPath = ""
+	}
+
+	header := cfg.ClientIPHeader
+	if header == "" {
+		header = defaultMeshClientIPHeader
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(c.GetHeader(header))); err == nil {
+		c.Request.RemoteAddr = netip.AddrPortFrom(addr.Unmap(), 0).String()
+	}
+	c.Request.Header.Del("X-Forwarded-For")
+	c.Request.Header.Del("X-Real-IP")
+
+	c.Next()
+}
+
+// meshAllow answers a check that passed the pipeline. Trace context is
+// returned as headers so the mesh can forward it upstream.
+func (ps *ProtectionService) meshAllow(c *gin.Context) {
+	if trace, ok := tracing.FromContext(c.Request.Context()); ok {
+		c.Header(tracing.HeaderTraceParent, trace.TraceParent())
+		if state := trace.TraceState(); state != "" {
+			c.Header(tracing.HeaderTraceState, state)
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// MeshHandler returns the handler serving external authorization checks,
+// or nil when the mesh integration is disabled, for serving the checks from
+// another server
+func (ps *ProtectionService) MeshHandler() http.Handler {
+	if ps.meshServer == nil {
+		return nil
+	}
+	return ps.meshServer.Handler
+}
+
+// isMeshCheck reports whether a request is an external authorization check
+func isMeshCheck(c *gin.Context) bool {
+	return c.GetBool(meshCheckKey)
+}
+
+// startMesh starts serving external authorization checks
+func (ps *ProtectionService) startMesh() {
+	if ps.meshServer == nil {
+		return
+	}
+
+	go func() {
+		if err := ps.meshServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			ps.logger.Errorf("Mesh authorization server error: %v", err)
+		}
+	}()
+}
+
+// stopMesh stops accepting checks and waits for those in progress, so the
+// mesh falls back to its failure mode instead of seeing shutdown errors
+func (ps *ProtectionService) stopMesh(ctx context.Context) error {
+	if ps.meshServer == nil {
+		return nil
+	}
+	return ps.meshServer.Shutdown(ctx)
+}
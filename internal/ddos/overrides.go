@@ -0,0 +1,180 @@
+package ddos
+
+import (
+	"net/http"
+	"strings"
+
+	"ddos-protection/internal/filter"
+	"ddos-protection/internal/overrides"
+	"ddos-protection/internal/ratelimit"
+	"ddos-protection/internal/tenant"
+	"ddos-protection/pkg/pipeline"
+)
+
+// initOverrides loads the per-tenant and per-path overrides of the global
+// settings, with a rate limiter for each override that sets a rate limit
+func (ps *ProtectionService) initOverrides() {
+	cfg := ps.config.Protection.Overrides
+	if len(cfg.Layers) == 0 {
+		return
+	}
+
+	groups := make([]overrides.PathGroup, 0, len(cfg.PathGroups))
+	for _, g := range cfg.PathGroups {
+		groups = append(groups, overrides.PathGroup{Name: g.Name, Paths: g.Paths})
+	}
+	layers := make([]overrides.Layer, 0, len(cfg.Layers))
+	for _, l := range cfg.Layers {
+		layer := overrides.Layer{
+			Tenant:                  l.Tenant,
+			PathGroup:               l.PathGroup,
+			RequestFilter:           l.RequestFilter,
+			DetectionThreshold:      l.DetectionThreshold,
+			AutoBlacklistConfidence: l.AutoBlacklistConfidence,
+			Challenge:               l.Challenge,
+			RequireChallenge:        l.RequireChallenge,
+			InspectBody:             l.InspectBody,
+			FilterRuleSet:           l.FilterRuleSet,
+			FilterRiskThreshold:     l.FilterRiskThreshold,
+		}
+		if rl := l.RateLimit; rl != nil {
+			layer.RateLimit = &overrides.RateLimit{RequestsPerMinute: rl.RequestsPerMinute, BurstSize: rl.BurstSize}
+		}
+		actions, err := overrides.ParseActions(l.BotnetActions)
+		if err != nil {
+			ps.logger.Errorf("Invalid config overrides, using global settings only: %v", err)
+			return
+		}
+		layer.BotnetActions = actions
+		layers = append(layers, layer)
+	}
+
+	resolver, err := overrides.New(groups, layers)
+	if err != nil {
+		ps.logger.Errorf("Invalid config overrides, using global settings only: %v", err)
+		return
+	}
+
+	ps.scopedLimiters = make(map[string]ratelimit.Limiter)
+	for _, l := range resolver.Layers() {
+		if l.RateLimit == nil {
+			continue
+		}
+		if ps.redisClient != nil {
+			ps.scopedLimiters[l.Name()] = ratelimit.NewRedisLimiter(
+				ps.redisClient,
+				l.RateLimit.RequestsPerMinute,
+				ps.config.Protection.RateLimit.WindowSize.Duration(),
+			)
+		} else {
+			ps.scopedLimiters[l.Name()] = ratelimit.NewTokenBucketLimiter(l.RateLimit.RequestsPerMinute, l.RateLimit.BurstSize)
+		}
+	}
+
+	ps.overrides = resolver
+	ps.logger.Infof("Config overrides loaded (%d layers, %d path groups)", len(layers), len(groups))
+}
+
+// globalSettings returns the overridable settings from the global config
+func (ps *ProtectionService) globalSettings() overrides.Settings {
+	cfg := &ps.config.Protection
+	return overrides.Settings{
+		RateLimit: overrides.RateLimit{
+			RequestsPerMinute: ps.rateLimiter.GetLimit(),
+			BurstSize:         ps.rateLimiter.GetBurst(),
+		},
+		RequestFilter:           cfg.RequestFilter.Enabled,
+		DetectionThreshold:      ps.botnetThreshold(),
+		AutoBlacklistConfidence: ps.autoBlacklistConfidence(),
+		Challenge:               overrides.ChallengeServe,
+		RequireChallenge:        cfg.Challenge.RequireAll,
+		BotnetActions:           overrides.AllActions,
+		InspectBody:             cfg.RequestFilter.InspectBody.Enabled,
+		FilterRiskThreshold:     filter.DefaultRiskThreshold,
+	}
+}
+
+// EffectiveConfig returns the settings that apply to requests of tenant to
+// path, and the layers they were merged from. An empty tenant is the
+// default tenant.
+func (ps *ProtectionService) EffectiveConfig(tenantID, path string) overrides.Effective {
+	tenantID = strings.ToLower(strings.TrimSpace(tenantID))
+	if tenantID == "" {
+		tenantID = tenant.DefaultTenant
+	}
+
+	global := ps.globalSettings()
+	if ps.overrides == nil {
+		return overrides.Effective{
+			Tenant:    tenantID,
+			Layers:    []string{overrides.GlobalLayer},
+			RateLimit: overrides.GlobalLayer,
+			Settings:  global,
+		}
+	}
+	return ps.overrides.Resolve(global, tenantID, path)
+}
+
+// effective returns the settings for a request, resolving them once
+func (ps *ProtectionService) effective(info *pipeline.RequestInfo) overrides.Effective {
+	if eff, ok := info.Values["overrides"].(overrides.Effective); ok {
+		return eff
+	}
+	eff := ps.EffectiveConfig(info.Tenant, info.Request.URL.Path)
+	info.Values["overrides"] = eff
+	return eff
+}
+
+// rateLimiterFor returns the rate limiter for a request and the key to
+// count it under
+func (ps *ProtectionService) rateLimiterFor(info *pipeline.RequestInfo) (ratelimit.Limiter, string) {
+	if ps.overrides == nil {
+		return ps.rateLimiter, info.ClientIP
+	}
+	scope := ps.effective(info).RateLimit
+	if limiter, exists := ps.scopedLimiters[scope]; exists {
+		return limiter, scope + ":" + info.ClientIP
+	}
+	return ps.rateLimiter, info.ClientIP
+}
+
+// applyChallengePolicy turns a challenge into a block or a pass when the
+// request's settings say so. A passed request skips the stages after the
+// one that challenged it.
+func (ps *ProtectionService) applyChallengePolicy(info *pipeline.RequestInfo, verdict pipeline.Verdict) pipeline.Verdict {
+	if verdict.Decision != pipeline.Challenge || ps.overrides == nil {
+		return verdict
+	}
+
+	switch ps.effective(info).Settings.Challenge {
+	case overrides.ChallengeBlock:
+		blocked := pipeline.Reject(http.StatusForbidden, "CHALLENGE_BLOCKED", "Access denied")
+		blocked.Reason = verdict.Reason
+		return blocked
+	case overrides.ChallengeAllow:
+		return pipeline.Verdict{Decision: pipeline.Allow, Reason: "challenge waived: " + verdict.Reason}
+	default:
+		return verdict
+	}
+}
+
+// botnetAction returns what the botnet stage does with a detection of a
+// request: challenge it when challenges are enabled and the confidence is
+// not too high, blacklist it above the auto-blacklist confidence, block it
+// otherwise, or the nearest of the actions its route allows
+func (ps *ProtectionService) botnetAction(info *pipeline.RequestInfo, confidence float64) overrides.Action {
+	settings := ps.effective(info).Settings
+	action := overrides.ActionBlock
+	switch {
+	case ps.passes != nil && confidence <= ps.challengeMaxConfidence():
+		action = overrides.ActionChallenge
+	case confidence > settings.AutoBlacklistConfidence:
+		action = overrides.ActionBlacklist
+	}
+
+	allowed := settings.BotnetActions
+	if ps.passes == nil {
+		allowed &^= overrides.ActionChallenge
+	}
+	return allowed.Nearest(action)
+}
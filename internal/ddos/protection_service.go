@@ -6,15 +6,53 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ddos-protection/internal/access"
+	"ddos-protection/internal/agents"
+	"ddos-protection/internal/appeal"
+	"ddos-protection/internal/attackcost"
+	"ddos-protection/internal/attacks"
+	"ddos-protection/internal/anomaly"
+	"ddos-protection/internal/apikey"
+	"ddos-protection/internal/audit"
+	"ddos-protection/internal/authcorr"
+	"ddos-protection/internal/awswaf"
 	"ddos-protection/internal/blacklist"
 	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/botpolicy"
+	"ddos-protection/internal/captcha"
+	"ddos-protection/internal/challenge"
+	"ddos-protection/internal/clientid"
 	"ddos-protection/internal/config"
+	"ddos-protection/internal/crawler"
+	"ddos-protection/internal/dnsbl"
+	"ddos-protection/internal/edge"
+	"ddos-protection/internal/enforce"
+	"ddos-protection/internal/events"
 	"ddos-protection/internal/filter"
+	"ddos-protection/internal/forecast"
+	"ddos-protection/internal/geo"
 	"ddos-protection/internal/health"
+	"ddos-protection/internal/killswitch"
 	"ddos-protection/internal/monitor"
+	"ddos-protection/internal/overrides"
+	"ddos-protection/internal/probation"
+	"ddos-protection/internal/proxyprobe"
 	"ddos-protection/internal/ratelimit"
+	"ddos-protection/internal/replica"
+	"ddos-protection/internal/reputation"
+	"ddos-protection/internal/signals"
+	"ddos-protection/internal/slowconn"
+	"ddos-protection/internal/sla"
+	"ddos-protection/internal/slowdown"
+	"ddos-protection/internal/stream"
+	"ddos-protection/internal/tenant"
+	"ddos-protection/internal/tracing"
+	"ddos-protection/internal/verdictcache"
+	"ddos-protection/internal/webhook"
+	"ddos-protection/pkg/pipeline"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -27,15 +65,82 @@ type ProtectionService struct {
 	config           *config.Config
 	logger           *logrus.Logger
 	rateLimiter      ratelimit.Limiter
+	rejections       *ratelimit.RejectionTracker
+	methodLimiters   atomic.Value // map[string]*ratelimit.TokenBucketLimiter
+	monitorAgents    *agents.Registry
+	greyLimiter      *ratelimit.TokenBucketLimiter
+	overrides        *overrides.Resolver
+	scopedLimiters   map[string]ratelimit.Limiter
+	captcha          *captcha.Verifier
+	passes           *challenge.Passes
+	puzzles          *challenge.Puzzles
+	clientIDs        *clientid.Issuer
+	badBots          *badBots
+	appealSigner     *appeal.Signer
+	appealLimiter    *appeal.Limiter
 	ipManager        *blacklist.IPManager
+	tenantLists      map[string]*blacklist.IPManager
 	requestFilter    *filter.RequestFilter
+	responseFilter   *filter.ResponseFilter
 	trafficMonitor   *monitor.TrafficMonitor
 	healthChecker    *health.HealthChecker
 	botnetDetector   *botnet.BotnetDetector
+	botnetSampler    *botnet.Sampler
+	botnetCluster    *botnet.Cluster
+	detectors        *botnet.Ensemble
+	anomaly          *anomaly.Detector
+	compositeSignals *signals.Engine
+	datacenterASNs   map[uint32]bool
+	probation        *probation.Tracker
+	killSwitches     *killswitch.Registry
+	asnLimiters      map[uint32]*ratelimit.TokenBucketLimiter
+	tenantResolver   *tenant.Resolver
+	forecaster       *forecast.Forecaster
+	tenantsAtRisk    map[string]bool
+	rateLimitBase    *config.RateLimitConfig
+	slaMonitor       *sla.Monitor
+	sampler          *tracing.Sampler
+	geoDB            *geo.Database
+	botnetGeoDB      *geo.Database
+	geoPolicy        *geo.Policy
+	geoFences        geo.Fences
+	accessPolicy     access.Policy
+	incidentActive   bool
+	dnsblChecker     *dnsbl.Checker
+	proxyProber      *proxyprobe.Prober
+	attackCost       *attackcost.Estimator
+	attacks          *attacks.Tracker
+	authCorrelator   *authcorr.Correlator
+	ruleChannel      *ruleChannel
+	crawlerVerifier  *crawler.Verifier
+	slowdown         *slowdown.Throttler
+	verdictCache     *verdictcache.Cache
+	burstTracker     *ratelimit.BurstTracker
+	slowRequests     *slowconn.Tracker
+	botPolicy        *botpolicy.Policy
+	webhooks         *webhook.Dispatcher
+	exporter         *stream.Exporter
+	apiKeys          *apikey.Store
+	enforcer         *enforce.Enforcer
+	edgeMirror       *edge.Mirror
+	wafSyncer        *awswaf.Syncer
+	reputation       *reputation.Tracker
+	eventStore       *events.Store
+	replicaPublisher *replica.Publisher
+	auditLog         *audit.Log
+	pipeline         *pipeline.Pipeline
 	redisClient      *redis.Client
 	metricsServer    *http.Server
+	meshServer       *http.Server
 	mu               sync.RWMutex
 	startTime        time.Time
+	publicStatus     PublicStatus
+	statusMu         sync.Mutex
+	cancel           context.CancelFunc
+	stopped          bool
+	background       sync.WaitGroup
+	inFlight         int64
+	draining         int32
 }
 
 // NewProtectionService creates a new DDoS protection service
@@ -61,9 +166,18 @@ func NewProtectionService(cfg *config.Config) (*ProtectionService, error) {
 	}
 
 	service := &ProtectionService{
-		config:    cfg,
-		logger:    logger,
-		startTime: time.Now(),
+		config:     cfg,
+		logger:     logger,
+		startTime:  time.Now(),
+		eventStore: events.NewStore(cfg.Events.Capacity),
+		// Kept across limiter rebuilds so support can still see why a
+		// client was limited after the limits change
+		rejections: ratelimit.NewRejectionTracker(),
+	}
+
+	// Client addresses are only taken from headers set by trusted proxies
+	if err := blacklist.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, err
 	}
 
 	// Initialize Redis client
@@ -71,14 +185,51 @@ func NewProtectionService(cfg *config.Config) (*ProtectionService, error) {
 		logger.Warnf("Failed to initialize Redis: %v", err)
 	}
 
+	// Initialize the audit log
+	service.initAudit()
+
 	// Initialize rate limiter
 	service.initRateLimiter()
 
+	service.initMethodLimits()
+	service.initOverrides()
+	service.initBurstDetection()
+	service.initSlowRequests()
+	service.initMonitorAgents()
+	service.initCrawlers()
+
+	service.initCaptcha()
+	service.initChallenges()
+	service.initClientTracking()
+
 	// Initialize IP manager
 	service.initIPManager()
 
+	// Initialize rule probation
+	service.initProbation()
+
+	// Initialize kill switches
+	service.initKillSwitches()
+
+	// Initialize DNSBL checks
+	service.initDNSBL()
+
+	// Initialize IP reputation
+	service.initReputation()
+
+	// Initialize open proxy probing
+	service.initProxyProbe()
+
+	// Initialize attack cost estimation
+	service.initAttackCost()
+	service.initAttacks()
+
+	// Initialize correlation of upstream authentication events
+	service.initAuthCorrelation()
+
 	// Initialize request filter
 	service.initRequestFilter()
+	service.initResponseFilter()
 
 	// Initialize traffic monitor
 	service.initTrafficMonitor()
@@ -86,8 +237,55 @@ func NewProtectionService(cfg *config.Config) (*ProtectionService, error) {
 	// Initialize health checker
 	service.initHealthChecker()
 
+	// Initialize signed rule bundle updates
+	service.initRuleUpdates()
+
 	// Initialize botnet detector
-	service.initBotnetDetector()
+	if err := service.initBotnetDetector(); err != nil {
+		return nil, err
+	}
+	service.initAnomaly()
+	service.initCompositeSignals()
+	service.initBotPolicy()
+
+	// Initialize tenant resolution and load forecasting
+	service.tenantResolver = tenant.NewResolver(cfg.Tenancy.Header, cfg.Tenancy.UseHost)
+	service.initForecaster()
+
+	// Initialize latency SLO tracking
+	service.initSLA()
+
+	// Initialize progressive delays
+	service.initSlowdown()
+
+	// Initialize caching of list verdicts
+	service.initVerdictCache()
+
+	// Initialize kernel firewall enforcement of bans
+	service.initEnforcement()
+	service.initEdge()
+	service.initAWSWAF()
+
+	// Initialize webhooks on list changes
+	service.initWebhooks()
+
+	// Initialize streaming of analyses to Kafka or NATS
+	service.initExport()
+
+	// Initialize API key authentication
+	service.initAPIKeys()
+
+	// Initialize publishing for read replicas
+	service.initReplication()
+
+	// Initialize trace sampling
+	service.initTracing()
+
+	// Initialize service mesh external authorization
+	service.initMesh()
+
+	// Assemble the protection pipeline
+	service.initPipeline()
 
 	// Initialize metrics server
 	if cfg.Metrics.Enabled {
@@ -132,7 +330,7 @@ func (ps *ProtectionService) initRateLimiter() {
 		ps.rateLimiter = ratelimit.NewRedisLimiter(
 			ps.redisClient,
 			ps.config.Protection.RateLimit.RequestsPerMinute,
-			time.Duration(ps.config.Protection.RateLimit.WindowSize)*time.Second,
+			ps.config.Protection.RateLimit.WindowSize.Duration(),
 		)
 		ps.logger.Info("Using Redis-based rate limiter")
 	} else {
@@ -145,42 +343,369 @@ func (ps *ProtectionService) initRateLimiter() {
 	}
 }
 
+// applyRateLimit moves the rate limiter to the configured limits in place,
+// so clients keep the allowance they have used. The limiter itself is never
+// replaced, which lets requests read it without holding ps.mu.
+func (ps *ProtectionService) applyRateLimit() {
+	cfg := ps.config.Protection.RateLimit
+	if adjuster, ok := ps.rateLimiter.(ratelimit.Adjuster); ok {
+		adjuster.SetLimits(cfg.RequestsPerMinute, cfg.BurstSize)
+	}
+}
+
+// initMethodLimits creates the per-method rate limiters. When run again,
+// limiters of methods that stay limited are changed in place so clients
+// keep the allowance they have used, and the new set replaces the old one
+// atomically since requests read it without holding ps.mu.
+func (ps *ProtectionService) initMethodLimits() {
+	current, _ := ps.methodLimiters.Load().(map[string]*ratelimit.TokenBucketLimiter)
+	limiters := make(map[string]*ratelimit.TokenBucketLimiter)
+	for method, limit := range ps.config.Protection.Methods.Limits {
+		if limit.RequestsPerMinute <= 0 {
+			continue
+		}
+		method = strings.ToUpper(method)
+		if limiter, exists := current[method]; exists {
+			limiter.SetLimits(limit.RequestsPerMinute, limit.BurstSize)
+			limiters[method] = limiter
+			continue
+		}
+		limiters[method] = ratelimit.NewTokenBucketLimiter(limit.RequestsPerMinute, limit.BurstSize)
+	}
+	ps.methodLimiters.Store(limiters)
+}
+
+// methodLimiter returns the rate limiter of method, if it is limited
+func (ps *ProtectionService) methodLimiter(method string) (*ratelimit.TokenBucketLimiter, bool) {
+	limiters, _ := ps.methodLimiters.Load().(map[string]*ratelimit.TokenBucketLimiter)
+	limiter, exists := limiters[method]
+	return limiter, exists
+}
+
+// initMonitorAgents loads the verified monitoring agent profiles
+func (ps *ProtectionService) initMonitorAgents() {
+	var profiles []*agents.Profile
+	for _, cfg := range ps.config.Protection.MonitorAgents {
+		profile, err := agents.NewProfile(cfg.Name, cfg.Networks, cfg.UserAgents)
+		if err != nil {
+			ps.logger.Warnf("Invalid monitor agent profile: %v", err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	ps.monitorAgents = agents.NewRegistry(profiles)
+	if len(profiles) > 0 {
+		ps.logger.Infof("Verified monitor agents: %v", ps.monitorAgents.Names())
+	}
+}
+
 // initIPManager initializes the IP manager
 func (ps *ProtectionService) initIPManager() {
 	ps.ipManager = blacklist.NewIPManager(
 		ps.redisClient,
 		ps.config.Protection.IPBlacklist.Enabled,
 		ps.config.Protection.IPBlacklist.AutoBlacklistThreshold,
-		time.Duration(ps.config.Protection.IPBlacklist.BlacklistDuration)*time.Second,
+		ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
 	)
+	ps.ipManager.SetIPv6AutoPrefix(ps.config.Protection.IPBlacklist.IPv6PrefixLength)
+	ps.initTenantLists()
+
+	if max := ps.config.Protection.IPBlacklist.MaxEntries; max > 0 {
+		policy, err := blacklist.ParseEvictionPolicy(ps.config.Protection.IPBlacklist.Eviction)
+		if err != nil {
+			ps.logger.Warnf("%v, evicting the bans soonest to expire", err)
+			policy = blacklist.EvictSoonestExpiry
+		}
+		for _, lists := range ps.allLists() {
+			lists.SetCapacity(max, policy)
+		}
+	}
+
+	if esc := ps.config.Protection.IPBlacklist.Escalation; esc.Enabled {
+		multiplier := esc.Multiplier
+		if multiplier <= 0 {
+			multiplier = 2
+		}
+		maxDuration := esc.MaxDuration.Duration()
+		if maxDuration <= 0 {
+			maxDuration = 7 * 24 * time.Hour
+		}
+		resetAfter := esc.ResetAfter.Duration()
+		if resetAfter <= 0 {
+			resetAfter = 24 * time.Hour
+		}
+		for _, lists := range ps.allLists() {
+			lists.SetEscalation(blacklist.Escalation{
+				Multiplier:  multiplier,
+				MaxDuration: maxDuration,
+				ResetAfter:  resetAfter,
+			})
+		}
+	}
+
+	ps.initSubnetEscalation()
+	ps.initAppeals()
+
+	if err := ps.ipManager.LoadBlacklistedNets(context.Background()); err != nil {
+		ps.logger.Warnf("Failed to load blacklisted networks: %v", err)
+	}
+
+	// Greylisted IPs get their own strict limiter. It exists even while the
+	// greylist is off, so a preset enabling it only has to set its limits.
+	grey := ps.config.Protection.IPBlacklist.Greylist
+	ps.greyLimiter = ratelimit.NewTokenBucketLimiter(grey.RequestsPerMinute, grey.BurstSize)
+	if grey.Enabled {
+		ps.ipManager.SetGreylistPolicy(grey.PromoteAfter, grey.QuietPeriod.Duration())
+		if err := ps.ipManager.LoadGreylist(context.Background()); err != nil {
+			ps.logger.Warnf("Failed to load greylist: %v", err)
+		}
+	}
 
 	// Add configured whitelist IPs
 	for _, ip := range ps.config.Protection.IPWhitelist.IPs {
-		if err := ps.ipManager.WhitelistIP(context.Background(), ip); err != nil {
+		if err := ps.ipManager.WhitelistIP(context.Background(), ip, 0); err != nil {
 			ps.logger.Warnf("Failed to whitelist IP %s: %v", ip, err)
 		}
 	}
 
+	// Restore bans from the last snapshot when running without Redis
+	if path := ps.snapshotFile(); path != "" {
+		restored, err := ps.ipManager.LoadSnapshot(path)
+		if err != nil {
+			ps.logger.Warnf("Failed to load IP list snapshot: %v", err)
+		} else {
+			ps.logger.Infof("Restored %d bans from %s", restored, path)
+		}
+	}
+
+	ps.initBanFilter()
+
+	ps.initASN()
+	ps.initGeo()
+	ps.initAccess()
+
 	ps.logger.Info("IP manager initialized")
 }
 
+// snapshotFile returns the IP list snapshot path, or "" when snapshots are
+// disabled. Snapshots are only taken without Redis, which already persists
+// the lists.
+func (ps *ProtectionService) snapshotFile() string {
+	if ps.redisClient != nil {
+		return ""
+	}
+	return ps.config.Protection.IPBlacklist.SnapshotFile
+}
+
+// initGeo opens the GeoIP database and builds the country policy
+func (ps *ProtectionService) initGeo() {
+	cfg := ps.config.Protection.Geo
+	if !cfg.Enabled {
+		return
+	}
+
+	db, err := geo.Open(cfg.Database)
+	if err != nil {
+		ps.logger.Warnf("Failed to open GeoIP database: %v", err)
+		return
+	}
+
+	ps.geoDB = db
+	ps.geoPolicy = geo.NewPolicy(
+		cfg.BlockCountries,
+		cfg.ChallengeCountries,
+		cfg.AllowCountries,
+		geo.Action(cfg.UnknownAction),
+	)
+
+	for _, fc := range cfg.Fences {
+		schedule, err := geo.ParseSchedule(
+			fc.Schedule.Days,
+			fc.Schedule.Start,
+			fc.Schedule.End,
+			fc.Schedule.Timezone,
+			fc.Schedule.DuringIncident,
+		)
+		if err != nil {
+			ps.logger.Warnf("Invalid schedule for geofence %s: %v", fc.Name, err)
+			continue
+		}
+
+		// Countries we cannot place are borderline; challenge them by default
+		unknownAction := geo.Action(fc.UnknownAction)
+		if unknownAction == "" {
+			unknownAction = geo.ActionChallenge
+		}
+
+		ps.geoFences = append(ps.geoFences, &geo.Fence{
+			Name:     fc.Name,
+			Paths:    fc.Paths,
+			Policy:   geo.NewPolicy(fc.BlockCountries, fc.ChallengeCountries, fc.AllowCountries, unknownAction),
+			Schedule: schedule,
+		})
+	}
+
+	dbType, built := db.Metadata()
+	ps.logger.Infof("GeoIP database loaded: %s (built %s)", dbType, built.Format("2006-01-02"))
+}
+
+// initASN loads the ASN database and rules
+func (ps *ProtectionService) initASN() {
+	ps.asnLimiters = make(map[uint32]*ratelimit.TokenBucketLimiter)
+
+	cfg := ps.config.Protection.ASN
+	if !cfg.Enabled {
+		return
+	}
+
+	db, err := blacklist.LoadASNDatabase(cfg.Database)
+	if err != nil {
+		ps.logger.Warnf("Failed to load ASN database %s: %v", cfg.Database, err)
+		return
+	}
+	ps.ipManager.SetASNDatabase(db)
+
+	for _, r := range cfg.Rules {
+		rule := blacklist.ASNRule{
+			ASN:               r.ASN,
+			Action:            r.Action,
+			RequestsPerMinute: r.RequestsPerMinute,
+			Reason:            r.Reason,
+		}
+		if err := ps.ipManager.SetASNRule(context.Background(), rule); err != nil {
+			ps.logger.Warnf("Invalid ASN rule for AS%d: %v", r.ASN, err)
+		}
+	}
+
+	if err := ps.ipManager.LoadASNRules(context.Background()); err != nil {
+		ps.logger.Warnf("Failed to load ASN rules: %v", err)
+	}
+
+	ps.logger.Infof("ASN database loaded with %d ranges", db.Len())
+}
+
+// initDNSBL sets up DNS-based blocklist checks
+func (ps *ProtectionService) initDNSBL() {
+	cfg := ps.config.Protection.DNSBL
+	if !cfg.Enabled || len(cfg.Zones) == 0 {
+		return
+	}
+
+	ps.dnsblChecker = dnsbl.NewChecker(
+		cfg.Zones,
+		nil,
+		cfg.Timeout.Duration(),
+		cfg.CacheTTL.Duration(),
+	)
+
+	ps.logger.Infof("DNSBL checks enabled for zones %v (action: %s)", cfg.Zones, cfg.Action)
+}
+
+// initReputation initializes per-IP reputation scoring
+func (ps *ProtectionService) initReputation() {
+	cfg := ps.config.Protection.Reputation
+	if !cfg.Enabled {
+		return
+	}
+
+	halfLife := cfg.HalfLife.Duration()
+	if halfLife <= 0 {
+		halfLife = time.Hour
+	}
+	ps.reputation = reputation.NewTracker(ps.redisClient, halfLife, reputation.Weights{
+		Risk:   cfg.Weights.Risk,
+		Botnet: cfg.Weights.Botnet,
+		Errors: cfg.Weights.Errors,
+		Feed:   cfg.Weights.Feed,
+	})
+
+	ps.reputation.SetIPv6Prefix(ps.ipv6AggregatePrefix())
+
+	if err := ps.reputation.Load(context.Background()); err != nil {
+		ps.logger.Warnf("Failed to load IP reputation: %v", err)
+	}
+
+	ps.logger.Infof("IP reputation enabled (half-life: %v, block below: %d, challenge below: %d)",
+		halfLife, cfg.BlockBelow, cfg.ChallengeBelow)
+}
+
 // initRequestFilter initializes the request filter
 func (ps *ProtectionService) initRequestFilter() {
 	ps.requestFilter = filter.NewRequestFilter(
-		ps.config.Protection.RequestFilter.MaxRequestSize,
+		int64(ps.config.Protection.RequestFilter.MaxRequestSize),
 		ps.config.Protection.RequestFilter.SuspiciousHeaders,
 		ps.config.Protection.RequestFilter.BlockedUserAgents,
 	)
 
+	if ps.probation != nil {
+		ps.requestFilter.SetProbation(ps.probation)
+	}
+	ps.requestFilter.SetKillSwitches(ps.killSwitches)
+	inspect := ps.config.Protection.RequestFilter.InspectBody
+	ps.requestFilter.SetBodyInspection(inspect.Enabled, int64(inspect.MaxSize))
+	ps.loadFilterRules()
+	ps.loadSchemas()
+	ps.loadUploadPolicy()
+	ps.loadProtocolPolicy()
+	ps.loadRuleSets()
+	ps.loadRuleActions()
+	ps.loadCategoryPolicies()
+
 	ps.logger.Info("Request filter initialized")
 }
 
+// initProbation initializes the probation tracker for newly added rules
+func (ps *ProtectionService) initProbation() {
+	if !ps.config.Protection.Probation.Enabled {
+		return
+	}
+
+	ps.probation = probation.NewTracker(
+		ps.config.Protection.Probation.Period.Duration(),
+		ps.config.Protection.Probation.MaxFalsePositiveRate,
+		ps.config.Protection.Probation.MinSamples,
+	)
+
+	if rollout := ps.config.Protection.Probation.Rollout; rollout.Enabled {
+		steps := rollout.Steps
+		if len(steps) == 0 {
+			steps = []int{1, 5, 25, 50, 100}
+		}
+		interval := rollout.StepInterval.Duration()
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		if err := ps.probation.SetRamp(steps, interval); err != nil {
+			ps.logger.Errorf("Invalid rollout, rules will be enforced at once after probation: %v", err)
+		}
+	}
+
+	ps.logger.Info("Rule probation initialized")
+}
+
+// initKillSwitches initializes the cluster-wide kill switch registry
+func (ps *ProtectionService) initKillSwitches() {
+	syncInterval := ps.config.Protection.KillSwitch.SyncInterval.Duration()
+	if syncInterval <= 0 {
+		syncInterval = 5 * time.Second
+	}
+
+	ps.killSwitches = killswitch.NewRegistry(ps.redisClient, syncInterval)
+	ps.ipManager.SetKillSwitches(ps.killSwitches)
+
+	ps.logger.Info("Kill switches initialized")
+}
+
 // initTrafficMonitor initializes the traffic monitor
 func (ps *ProtectionService) initTrafficMonitor() {
 	ps.trafficMonitor = monitor.NewTrafficMonitor(
 		int64(ps.config.Protection.Monitoring.AlertThreshold),
 		ps.config.Protection.Monitoring.SampleRate,
 	)
+	if limit := ps.config.Protection.Cardinality.MaxTrackedIPs; limit > 0 {
+		ps.trafficMonitor.SetMaxTrackedIPs(limit)
+	}
 
 	ps.logger.Info("Traffic monitor initialized")
 }
@@ -188,8 +713,8 @@ func (ps *ProtectionService) initTrafficMonitor() {
 // initHealthChecker initializes the health checker
 func (ps *ProtectionService) initHealthChecker() {
 	ps.healthChecker = health.NewHealthChecker(
-		time.Duration(ps.config.Protection.HealthCheck.CheckInterval)*time.Second,
-		time.Duration(ps.config.Protection.HealthCheck.Timeout)*time.Second,
+		ps.config.Protection.HealthCheck.CheckInterval.Duration(),
+		ps.config.Protection.HealthCheck.Timeout.Duration(),
 	)
 
 	// Register built-in health checks
@@ -198,14 +723,206 @@ func (ps *ProtectionService) initHealthChecker() {
 	ps.logger.Info("Health checker initialized")
 }
 
-// initBotnetDetector initializes the botnet detector
-func (ps *ProtectionService) initBotnetDetector() {
+// initBotnetDetector initializes the botnet detector. Invalid scoring is
+// an error rather than a warning, as it could leave detection toothless.
+func (ps *ProtectionService) initBotnetDetector() error {
+	scoring, err := botnetScoring(ps.config.Protection.Botnet.Scoring)
+	if err != nil {
+		return err
+	}
+
 	ps.botnetDetector = botnet.NewBotnetDetector(
-		0.8,                    // detection threshold
+		ps.botnetThreshold(),          // detection threshold
 		time.Duration(60)*time.Second,  // analysis window
 	)
+	ps.botnetDetector.SetScoring(scoring)
+	ps.botnetDetector.SetKillSwitches(ps.killSwitches)
+	ps.botnetDetector.SetLimits(ps.botnetLimits())
+	if ttl := ps.config.Protection.Botnet.StateTTL.Duration(); ttl > 0 {
+		ps.botnetDetector.SetStateTTL(ttl)
+	}
+	ps.botnetDetector.SetIPv6Prefix(ps.ipv6AggregatePrefix())
+	ps.botnetDetector.SetDecayHalfLife(ps.config.Protection.Botnet.DecayHalfLife.Duration())
+	ps.initBotnetGeo()
+	ps.initBotnetSharing()
+	ps.initBadBots()
+	ps.initDetectors()
 
 	ps.logger.Info("Botnet detector initialized")
+	if bits := ps.ipv6AggregatePrefix(); bits > 0 {
+		ps.logger.Infof("IPv6 reputation and behavior grouped by /%d", bits)
+	}
+	return nil
+}
+
+// initBotnetSharing shares the botnet detector's per-client behavior, burst
+// counts and active clients across instances through Redis
+func (ps *ProtectionService) initBotnetSharing() {
+	cfg := ps.config.Protection.Botnet.Sharing
+	if !cfg.Enabled {
+		return
+	}
+	if ps.redisClient == nil {
+		ps.logger.Error("Botnet state sharing needs Redis, sharing disabled")
+		return
+	}
+
+	interval := cfg.SyncInterval.Duration()
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ps.botnetCluster = botnet.NewCluster(ps.botnetDetector, ps.redisClient, ps.nodeName(), interval)
+	ps.logger.Infof("Botnet state shared across instances every %v", interval)
+}
+
+// initBotnetGeo gives the botnet detector client countries for its
+// geographic spread analysis, from its own GeoIP database if one is set and
+// otherwise from the geo policy's
+func (ps *ProtectionService) initBotnetGeo() {
+	path := ps.config.Protection.Botnet.GeoIPDatabase
+	switch {
+	case path != "" && (ps.geoDB == nil || path != ps.config.Protection.Geo.Database):
+		db, err := geo.Open(path)
+		if err != nil {
+			ps.logger.Warnf("Failed to open botnet GeoIP database: %v", err)
+			return
+		}
+		ps.botnetGeoDB = db
+		ps.botnetDetector.SetCountryLookup(knownCountry(db))
+	case ps.geoDB != nil:
+		ps.botnetDetector.SetCountryLookup(knownCountry(ps.geoDB))
+	default:
+		ps.logger.Info("No GeoIP database, botnet geographic spread analysis disabled")
+	}
+}
+
+// knownCountry looks up countries in db, leaving out addresses it cannot
+// place so they do not count as one more country
+func knownCountry(db *geo.Database) func(ip string) string {
+	return func(ip string) string {
+		if country := db.Country(ip); country != geo.UnknownCountry {
+			return country
+		}
+		return ""
+	}
+}
+
+// botnetLimits returns the configured cardinality limits, falling back to
+// the detector's defaults for unset values
+func (ps *ProtectionService) botnetLimits() botnet.Limits {
+	cfg := ps.config.Protection.Cardinality
+	limits := botnet.DefaultLimits
+	if cfg.MaxUserAgents > 0 {
+		limits.UserAgents = cfg.MaxUserAgents
+	}
+	if cfg.MaxPaths > 0 {
+		limits.Paths = cfg.MaxPaths
+	}
+	if cfg.MaxValuesPerIP > 0 {
+		limits.PerIP = cfg.MaxValuesPerIP
+	}
+	if cfg.MaxValueLength > 0 {
+		limits.MaxLength = cfg.MaxValueLength
+	}
+	if cfg.MaxTLSFingerprints > 0 {
+		limits.Fingerprints = cfg.MaxTLSFingerprints
+	}
+	if cfg.MaxBotnetClients > 0 {
+		limits.Clients = cfg.MaxBotnetClients
+	}
+	if cfg.MaxBotnetNetworks > 0 {
+		limits.Networks = cfg.MaxBotnetNetworks
+	}
+	return limits
+}
+
+// ipv6AggregatePrefix returns the prefix length IPv6 clients are grouped by
+// for reputation and behavior, defaulting to 64, or 0 if grouping is off
+func (ps *ProtectionService) ipv6AggregatePrefix() int {
+	cfg := ps.config.Protection.IPv6Aggregation
+	if !cfg.Enabled {
+		return 0
+	}
+	if cfg.PrefixLength <= 0 || cfg.PrefixLength > 128 {
+		return 64
+	}
+	return cfg.PrefixLength
+}
+
+// botnetThreshold returns the configured detection threshold, defaulting to 0.8
+func (ps *ProtectionService) botnetThreshold() float64 {
+	if t := ps.config.Protection.Botnet.DetectionThreshold; t > 0 {
+		return t
+	}
+	return 0.8
+}
+
+// autoBlacklistConfidence returns the bot confidence above which clients are
+// blacklisted outright, defaulting to 0.8
+func (ps *ProtectionService) autoBlacklistConfidence() float64 {
+	if c := ps.config.Protection.Botnet.AutoBlacklistConfidence; c > 0 {
+		return c
+	}
+	return 0.8
+}
+
+// initForecaster initializes request-rate forecasting
+func (ps *ProtectionService) initForecaster() {
+	cfg := ps.config.Protection.Forecasting
+	if !cfg.Enabled {
+		return
+	}
+
+	ps.tenantsAtRisk = make(map[string]bool)
+	ps.forecaster = forecast.NewForecaster(forecast.Config{
+		BucketInterval:  cfg.BucketInterval.Duration(),
+		SeasonLength:    cfg.SeasonLength,
+		Horizon:         cfg.Horizon,
+		Alpha:           cfg.Alpha,
+		Beta:            cfg.Beta,
+		Gamma:           cfg.Gamma,
+		RiskRatio:       cfg.RiskRatio,
+		DefaultCapacity: cfg.DefaultCapacity,
+		TenantCapacity:  cfg.TenantCapacity,
+	}, ps.handleCapacityRisk)
+
+	ps.logger.Info("Load forecaster initialized")
+}
+
+// initSLA initializes latency SLO tracking for the configured path groups
+func (ps *ProtectionService) initSLA() {
+	cfg := ps.config.Protection.SLA
+	if !cfg.Enabled || len(cfg.Objectives) == 0 {
+		return
+	}
+
+	var objectives []*sla.Objective
+	for _, oc := range cfg.Objectives {
+		objective, err := sla.NewObjective(
+			oc.Name,
+			oc.Paths,
+			oc.LatencyMs.Duration(),
+			oc.Target,
+			oc.Priority,
+		)
+		if err != nil {
+			ps.logger.Warnf("Skipping SLO: %v", err)
+			continue
+		}
+		objectives = append(objectives, objective)
+	}
+	if len(objectives) == 0 {
+		return
+	}
+
+	ps.slaMonitor = sla.NewMonitor(objectives, sla.Config{
+		ShortWindow:   cfg.ShortWindow.Duration(),
+		LongWindow:    cfg.LongWindow.Duration(),
+		BurnThreshold: cfg.BurnThreshold,
+		MinRequests:   cfg.MinRequests,
+	}, ps.handleSLOChange)
+
+	ps.logger.Infof("SLA monitor initialized with %d objectives", len(objectives))
 }
 
 // registerHealthChecks registers built-in health checks
@@ -257,6 +974,14 @@ func (ps *ProtectionService) initMetricsServer() {
 
 // Start starts the DDoS protection service
 func (ps *ProtectionService) Start(ctx context.Context) error {
+	ps.mu.Lock()
+	if ps.cancel != nil || ps.stopped {
+		ps.mu.Unlock()
+		return fmt.Errorf("protection service already started")
+	}
+	ctx, ps.cancel = context.WithCancel(ctx)
+	ps.mu.Unlock()
+
 	// Start background services
 	ps.startBackgroundServices(ctx)
 
@@ -270,7 +995,10 @@ func (ps *ProtectionService) Start(ctx context.Context) error {
 	}
 
 	// Start alert processing
-	go ps.processAlerts(ctx)
+	ps.goBackground(func() { ps.processAlerts(ctx) })
+
+	// Start serving mesh authorization checks
+	ps.startMesh()
 
 	ps.logger.Info("DDoS protection service started")
 	return nil
@@ -282,133 +1010,773 @@ func (ps *ProtectionService) startBackgroundServices(ctx context.Context) {
 	ps.trafficMonitor.Start(ctx)
 
 	// Start health checks
-	go ps.healthChecker.StartHealthChecks(ctx)
+	ps.goBackground(func() { ps.healthChecker.StartHealthChecks(ctx) })
 
 	// Start cleanup routines
-	go ps.cleanupRoutine(ctx)
-}
-
-// cleanupRoutine runs periodic cleanup tasks
-func (ps *ProtectionService) cleanupRoutine(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+	ps.goBackground(func() { ps.cleanupRoutine(ctx) })
+	ps.goBackground(func() { ps.botnetCleanupRoutine(ctx) })
 
-	for {
-		select {
-		case <-ticker.C:
-			ps.ipManager.CleanupExpiredEntries()
-			ps.requestFilter.CleanupExpiredEntries()
-		case <-ctx.Done():
-			return
-		}
+	// Look for clients holding slow requests open
+	if ps.slowRequests != nil {
+		ps.goBackground(func() { ps.slowRequestsRoutine(ctx) })
 	}
-}
 
-// processAlerts processes traffic monitoring alerts
-func (ps *ProtectionService) processAlerts(ctx context.Context) {
-	alerts := ps.trafficMonitor.GetAlerts()
-	
-	for {
-		select {
-		case alert := <-alerts:
-			ps.handleAlert(alert)
-		case <-ctx.Done():
-			return
-		}
+	// Share botnet behavior state across instances
+	if ps.botnetCluster != nil {
+		ps.goBackground(func() {
+			ps.botnetCluster.Run(ctx, func(err error) {
+				ps.logger.Errorf("Botnet state sharing error: %v", err)
+			})
+		})
 	}
-}
 
-// handleAlert handles traffic monitoring alerts
-func (ps *ProtectionService) handleAlert(alert monitor.Alert) {
-	ps.logger.WithFields(logrus.Fields{
-		"type":     alert.Type,
-		"severity": alert.Severity,
-		"ip":       alert.IP,
-		"message":  alert.Message,
-	}).Warn("Traffic alert received")
+	// Keep kill switches in sync across instances
+	ps.goBackground(func() { ps.killSwitches.Run(ctx) })
 
-	// Auto-blacklist IPs with high request rates
-	if alert.Type == "high_request_rate" && alert.IP != "" {
-		if err := ps.ipManager.BlacklistIP(
-			context.Background(),
-			alert.IP,
-			time.Duration(ps.config.Protection.IPBlacklist.BlacklistDuration)*time.Second,
-		); err != nil {
-			ps.logger.Errorf("Failed to auto-blacklist IP %s: %v", alert.IP, err)
-		} else {
-			ps.logger.Infof("Auto-blacklisted IP %s due to high request rate", alert.IP)
-		}
-	}
-}
+	for _, lists := range ps.allLists() {
+		lists := lists
 
-// Stop stops the DDoS protection service
-func (ps *ProtectionService) Stop(ctx context.Context) error {
-	ps.logger.Info("Stopping DDoS protection service...")
+		// Apply blacklist and whitelist changes made on other instances
+		ps.goBackground(func() { lists.RunSync(ctx) })
 
-	// Stop traffic monitor
-	ps.trafficMonitor.Stop()
+		// Deliver ban events and expire bans on time
+		ps.goBackground(func() { lists.RunBanEvents(ctx) })
+	}
 
-	// Stop metrics server
-	if ps.metricsServer != nil {
-		if err := ps.metricsServer.Shutdown(ctx); err != nil {
-			ps.logger.Errorf("Error shutting down metrics server: %v", err)
-		}
+	// Rebuild the ban filter
+	if ps.config.Protection.IPBlacklist.Filter.Enabled {
+		ps.goBackground(func() { ps.banFilterRoutine(ctx) })
 	}
 
-	// Close Redis connection
-	if ps.redisClient != nil {
-		if err := ps.redisClient.Close(); err != nil {
-			ps.logger.Errorf("Error closing Redis connection: %v", err)
+	// Watch the GeoIP database for updates
+	if ps.geoDB != nil && ps.config.Protection.Geo.ReloadInterval > 0 {
+		ps.goBackground(func() { ps.geoReloadRoutine(ctx, ps.geoDB, ps.config.Protection.Geo.ReloadInterval.Duration()) })
+	}
+	if ps.botnetGeoDB != nil {
+		interval := ps.config.Protection.Botnet.GeoIPReloadInterval.Duration()
+		if interval <= 0 {
+			interval = time.Hour
 		}
+		ps.goBackground(func() { ps.geoReloadRoutine(ctx, ps.botnetGeoDB, interval) })
 	}
 
-	ps.logger.Info("DDoS protection service stopped")
-	return nil
-}
+	// Start load forecasting
+	if ps.forecaster != nil {
+		ps.goBackground(func() { ps.forecaster.Run(ctx) })
+	}
 
-// GetStartTime returns the service start time
-func (ps *ProtectionService) GetStartTime() time.Time {
-	return ps.startTime
-}
+	// Start SLO burn rate evaluation
+	if ps.slaMonitor != nil {
+		ps.goBackground(func() { ps.slaMonitor.Run(ctx) })
+	}
 
-// GetHealthStatus returns the health status
+	// Keep the kernel firewall in step with the lists
+	if ps.enforcer != nil {
+		ps.goBackground(func() {
+			ps.enforcer.Run(ctx, func(err error) {
+				ps.logger.Errorf("Kernel enforcement error: %v", err)
+			})
+		})
+	}
+
+	// Mirror bans to the CDN or edge WAF
+	if ps.edgeMirror != nil {
+		ps.goBackground(func() {
+			ps.edgeMirror.Run(ctx, func(err error) {
+				ps.logger.Errorf("Edge mirroring error: %v", err)
+			})
+		})
+	}
+
+	// Keep the AWS WAF IPSets in step with the lists
+	if ps.wafSyncer != nil {
+		ps.goBackground(func() {
+			ps.wafSyncer.Run(ctx, func(err error) {
+				ps.logger.Errorf("AWS WAF synchronization error: %v", err)
+			})
+		})
+	}
+
+	// Keep API keys in sync across instances
+	if ps.apiKeys != nil {
+		ps.goBackground(func() { ps.apiKeys.Run(ctx) })
+	}
+
+	// Publish events and stats for read replicas
+	if ps.replicaPublisher != nil {
+		ps.goBackground(func() { ps.replicaPublisher.Run(ctx, ps.replicaStatsInterval(), ps.GetTrafficStats) })
+	}
+
+	// Deliver webhooks
+	if ps.webhooks != nil {
+		ps.goBackground(func() { ps.webhooks.Run(ctx) })
+	}
+
+	// Stream analyses and decisions
+	if ps.exporter != nil {
+		ps.goBackground(func() { ps.exporter.Run(ctx) })
+	}
+
+	// Release delayed requests
+	if ps.slowdown != nil {
+		ps.goBackground(func() { ps.slowdown.Run(ctx) })
+	}
+
+	// Snapshot IP lists to disk when running without Redis
+	if ps.snapshotFile() != "" && ps.config.Protection.IPBlacklist.SnapshotInterval > 0 {
+		ps.goBackground(func() { ps.snapshotRoutine(ctx) })
+	}
+
+	// Persist reputation changes
+	if ps.reputation != nil && ps.redisClient != nil {
+		ps.goBackground(func() { ps.reputationRoutine(ctx) })
+	}
+
+	// Sign the head of the audit log
+	if ps.auditLog != nil && ps.auditLog.PublicKey() != nil {
+		ps.goBackground(func() { ps.auditCheckpointRoutine(ctx) })
+	}
+
+	// Reload custom filter rules when their file changes
+	if len(ps.filterRulesFiles()) > 0 {
+		ps.goBackground(func() { ps.filterRulesRoutine(ctx) })
+	}
+
+	// Fetch signed rule bundles
+	if ps.ruleChannel != nil {
+		ps.goBackground(func() { ps.ruleUpdateRoutine(ctx) })
+	}
+
+	// End attacks once detections stop
+	if ps.attacks != nil {
+		ps.goBackground(func() { ps.attackRoutine(ctx) })
+	}
+
+	// Refresh bad bot signatures
+	if ps.badBots != nil && ps.badBots.fetcher != nil {
+		ps.goBackground(func() { ps.badBotRoutine(ctx) })
+	}
+
+	// Cluster attacking clients into campaigns
+	if ps.config.Protection.Botnet.Campaigns.Enabled {
+		ps.goBackground(func() { ps.campaignRoutine(ctx) })
+	}
+
+	// Learn and save the anomaly baseline
+	if ps.anomaly != nil {
+		ps.goBackground(func() { ps.anomalyRoutine(ctx) })
+	}
+}
+
+// reputationRoutine periodically writes changed reputation records to Redis
+func (ps *ProtectionService) reputationRoutine(ctx context.Context) {
+	interval := ps.config.Protection.Reputation.FlushInterval.Duration()
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.reputation.Flush(ctx); err != nil {
+				ps.logger.Errorf("Failed to persist IP reputation: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// snapshotRoutine periodically saves the IP lists to disk
+func (ps *ProtectionService) snapshotRoutine(ctx context.Context) {
+	ticker := time.NewTicker(ps.config.Protection.IPBlacklist.SnapshotInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.ipManager.SaveSnapshot(ps.snapshotFile()); err != nil {
+				ps.logger.Errorf("Failed to save IP list snapshot: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupRoutine runs periodic cleanup tasks
+func (ps *ProtectionService) cleanupRoutine(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ps.ipManager.CleanupExpiredEntries()
+			if ps.verdictCache != nil {
+				ps.verdictCache.Cleanup(time.Now())
+			}
+			if ps.burstTracker != nil {
+				ps.burstTracker.Cleanup(time.Now())
+			}
+			if err := ps.ipManager.LoadBlacklistedNets(ctx); err != nil {
+				ps.logger.Warnf("Failed to refresh blacklisted networks: %v", err)
+			}
+			ps.refreshGreylist(ctx)
+			if err := ps.ipManager.LoadASNRules(ctx); err != nil {
+				ps.logger.Warnf("Failed to refresh ASN rules: %v", err)
+			}
+			ps.requestFilter.CleanupExpiredEntries()
+			ps.rejections.Cleanup(time.Now().Add(-time.Hour))
+			if ps.dnsblChecker != nil {
+				ps.dnsblChecker.CleanupExpired()
+			}
+			if ps.proxyProber != nil {
+				ps.proxyProber.CleanupExpired()
+			}
+			if ps.crawlerVerifier != nil {
+				ps.crawlerVerifier.CleanupExpired()
+			}
+			if ps.slowdown != nil {
+				ps.slowdown.Cleanup()
+			}
+			if ps.reputation != nil {
+				ps.reputation.Cleanup(ctx)
+			}
+			if ps.appealLimiter != nil {
+				ps.appealLimiter.Cleanup(time.Now())
+			}
+			if ps.authCorrelator != nil {
+				ps.authCorrelator.Cleanup(time.Now())
+			}
+			for _, lists := range ps.tenantLists {
+				lists.CleanupExpiredEntries()
+				if err := lists.LoadBlacklistedNets(ctx); err != nil {
+					ps.logger.Warnf("Failed to refresh blacklisted networks of tenant %s: %v", lists.Tenant(), err)
+				}
+			}
+			ps.evaluateProbation()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshGreylist demotes quiet greylisted IPs and picks up entries added
+// on other nodes
+func (ps *ProtectionService) refreshGreylist(ctx context.Context) {
+	if !ps.config.Protection.IPBlacklist.Greylist.Enabled {
+		return
+	}
+
+	for _, ip := range ps.ipManager.DemoteQuietEntries(ctx) {
+		ps.logger.WithField("ip", ip).Info("IP demoted from greylist after quiet period")
+	}
+	if err := ps.ipManager.LoadGreylist(ctx); err != nil {
+		ps.logger.Warnf("Failed to refresh greylist: %v", err)
+	}
+}
+
+// strike records abuse by an IP, greylisting it and promoting it to the
+// blacklist once it keeps misbehaving
+func (ps *ProtectionService) strike(ctx context.Context, ip, reason string) {
+	if !ps.config.Protection.IPBlacklist.Greylist.Enabled {
+		return
+	}
+
+	promoted, err := ps.ipManager.Strike(
+		ctx,
+		ip,
+		reason,
+		ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
+	)
+	if err != nil {
+		ps.logger.Errorf("Failed to record strike for IP %s: %v", ip, err)
+		return
+	}
+	if promoted {
+		ps.logger.WithFields(logrus.Fields{
+			"ip":     ip,
+			"reason": reason,
+		}).Warn("Greylisted IP promoted to blacklist")
+	}
+}
+
+// evaluateProbation promotes rules that finished probation cleanly
+func (ps *ProtectionService) evaluateProbation() {
+	if ps.probation == nil {
+		return
+	}
+
+	for _, change := range ps.probation.Evaluate() {
+		log := ps.logger.WithField("rule", change.ID)
+		switch change.Status {
+		case probation.StatusEnforcing:
+			log.Info("Rule promoted to enforcement after probation")
+		case probation.StatusRollingOut:
+			log.WithField("rollout", change.Rollout).Info("Rule rollout ramped up")
+		case probation.StatusHeld:
+			log.Warn("Rule held in shadow: too many suspected false positives")
+		}
+	}
+}
+
+// vindicate tells probation a client proved legitimate, so shadow rules
+// that would have blocked it count the hits as false positives
+func (ps *ProtectionService) vindicate(ip string) {
+	if ps.probation != nil {
+		ps.probation.Vindicate(ip)
+	}
+}
+
+// botnetCleanupRoutine drops idle botnet detector state every minute, more
+// often than the general cleanup as the detector tracks every client
+func (ps *ProtectionService) botnetCleanupRoutine(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := ps.botnetDetector.Cleanup(time.Now()); removed > 0 {
+				ps.logger.Debugf("Dropped %d idle botnet detector entries", removed)
+			}
+			if ps.botnetSampler != nil {
+				ps.botnetSampler.Cleanup(time.Now())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// geoReloadRoutine reloads a GeoIP database when the file is replaced
+func (ps *ProtectionService) geoReloadRoutine(ctx context.Context, db *geo.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := db.ReloadIfChanged()
+			if err != nil {
+				ps.logger.Errorf("Failed to reload GeoIP database: %v", err)
+			} else if reloaded {
+				ps.logger.Info("GeoIP database reloaded")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processAlerts processes traffic monitoring alerts
+func (ps *ProtectionService) processAlerts(ctx context.Context) {
+	alerts := ps.trafficMonitor.GetAlerts()
+	
+	for {
+		select {
+		case alert := <-alerts:
+			ps.handleAlert(alert)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleAlert handles traffic monitoring alerts
+func (ps *ProtectionService) handleAlert(alert monitor.Alert) {
+	ps.logger.WithFields(logrus.Fields{
+		"type":     alert.Type,
+		"severity": alert.Severity,
+		"ip":       alert.IP,
+		"message":  alert.Message,
+	}).Warn("Traffic alert received")
+
+	// Auto-blacklist IPs with high request rates
+	if alert.Type == "high_request_rate" && alert.IP != "" {
+		if err := ps.ipManager.AutoBlacklistIP(
+			context.Background(),
+			alert.IP,
+			ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
+			blacklist.Origin{Source: blacklist.SourceTrafficAlert, Reason: alert.Message},
+		); err != nil {
+			ps.logger.Errorf("Failed to auto-blacklist IP %s: %v", alert.IP, err)
+		} else {
+			ps.logger.Infof("Auto-blacklisted IP %s due to high request rate", alert.IP)
+		}
+	}
+}
+
+// handleCapacityRisk raises a capacity risk alert and, if configured,
+// pre-applies softer rate limits until no tenant is at risk
+func (ps *ProtectionService) handleCapacityRisk(risk forecast.Risk) {
+	if risk.AtRisk {
+		ps.handleAlert(monitor.Alert{
+			Type:     "capacity_risk",
+			Severity: "warning",
+			Message: fmt.Sprintf("Tenant %s forecasted at %.0f req/min within %v (capacity %.0f)",
+				risk.Tenant, risk.Forecast, risk.Horizon, risk.Capacity),
+			Timestamp: time.Now(),
+		})
+	} else {
+		ps.logger.WithField("tenant", risk.Tenant).Info("Capacity risk cleared")
+	}
+
+	factor := ps.config.Protection.Forecasting.MitigationRateLimitFactor
+	if factor <= 0 {
+		return
+	}
+
+	// Deferred first so it runs once the lock is released
+	defer ps.trackIncident()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if risk.AtRisk {
+		ps.tenantsAtRisk[risk.Tenant] = true
+	} else {
+		delete(ps.tenantsAtRisk, risk.Tenant)
+	}
+
+	switch {
+	case len(ps.tenantsAtRisk) > 0 && ps.rateLimitBase == nil:
+		base := ps.config.Protection.RateLimit
+		ps.rateLimitBase = &base
+		ps.config.Protection.RateLimit.RequestsPerMinute = int(float64(base.RequestsPerMinute) * factor)
+		ps.config.Protection.RateLimit.BurstSize = int(float64(base.BurstSize) * factor)
+		ps.applyRateLimit()
+		ps.logger.Warnf("Pre-applied capacity mitigation: rate limits scaled by %.2f", factor)
+	case len(ps.tenantsAtRisk) == 0 && ps.rateLimitBase != nil:
+		ps.config.Protection.RateLimit = *ps.rateLimitBase
+		ps.rateLimitBase = nil
+		ps.applyRateLimit()
+		ps.logger.Info("Capacity mitigation lifted: rate limits restored")
+	}
+}
+
+// handleSLOChange raises an alert when a latency SLO becomes threatened; the
+// severity escalates when it happens during an attack
+func (ps *ProtectionService) handleSLOChange(status sla.Status) {
+	if !status.Threatened {
+		ps.logger.WithField("slo", status.Name).Info("SLO burn rate recovered")
+		return
+	}
+
+	ps.handleAlert(monitor.Alert{
+		Type:     "slo_burn",
+		Severity: ps.IncidentSeverity(),
+		Message: fmt.Sprintf("SLO %s threatened: burning error budget %.1fx too fast (%dms, %.2f%%)",
+			status.Name, status.ShortBurnRate, status.ThresholdMs, status.Target*100),
+		Timestamp: time.Now(),
+	})
+}
+
+// underAttack reports whether the service is mitigating an attack, either
+// in incident mode or with capacity mitigation applied
+func (ps *ProtectionService) underAttack() bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.incidentActive || ps.rateLimitBase != nil
+}
+
+// IncidentSeverity rates the current situation: "none", "minor" while
+// mitigating an attack, "major" when an SLO is threatened, and "critical"
+// when an SLO is threatened during an attack
+func (ps *ProtectionService) IncidentSeverity() string {
+	threatened := false
+	if ps.slaMonitor != nil {
+		threatened, _ = ps.slaMonitor.Threatened()
+	}
+	attack := ps.underAttack()
+
+	switch {
+	case threatened && attack:
+		return "critical"
+	case threatened:
+		return "major"
+	case attack:
+		return "minor"
+	default:
+		return "none"
+	}
+}
+
+// GetSLAStatus returns the state of every latency SLO, the priority below
+// which routes are currently shed, and the incident severity
+func (ps *ProtectionService) GetSLAStatus() map[string]interface{} {
+	objectives := []sla.Status{}
+	if ps.slaMonitor != nil {
+		objectives = ps.slaMonitor.Status()
+	}
+
+	shedBelow, shedding := ps.shedPriority()
+	result := map[string]interface{}{
+		"objectives": objectives,
+		"severity":   ps.IncidentSeverity(),
+		"shedding":   shedding,
+	}
+	if shedding {
+		result["shed_below_priority"] = shedBelow
+	}
+	return result
+}
+
+// shedPriority returns the priority below which routes are shed, and
+// whether shedding is in effect
+func (ps *ProtectionService) shedPriority() (int, bool) {
+	if ps.slaMonitor == nil || !ps.config.Protection.SLA.ShedDuringAttack {
+		return 0, false
+	}
+
+	threatened, priority := ps.slaMonitor.Threatened()
+	if !threatened || !ps.underAttack() {
+		return 0, false
+	}
+	return priority, true
+}
+
+// GetForecasts returns the latest per-tenant load forecasts
+func (ps *ProtectionService) GetForecasts() []forecast.Risk {
+	if ps.forecaster == nil {
+		return []forecast.Risk{}
+	}
+	return ps.forecaster.GetForecasts()
+}
+
+// Stop shuts the DDoS protection service down in order: it stops accepting
+// requests, drains in-flight ones, stops background tasks and flushes
+// pending alerts and metrics, persists state, and finally closes backends.
+// Each phase has its own timeout within the deadline of ctx. Calling Stop
+// more than once has no effect.
+func (ps *ProtectionService) Stop(ctx context.Context) error {
+	ps.mu.Lock()
+	if ps.stopped {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.stopped = true
+	cancel := ps.cancel
+	ps.mu.Unlock()
+
+	ps.logger.Info("Stopping DDoS protection service...")
+	timeouts := ps.config.Server.Shutdown
+
+	// Stop taking mesh authorization checks so the mesh falls back to its
+	// failure mode, then turn away new requests
+	ps.shutdownPhase(ctx, "mesh", phaseTimeout(timeouts.DrainTimeout, defaultDrainTimeout), ps.stopMesh)
+	atomic.StoreInt32(&ps.draining, 1)
+
+	// Let requests already past the middleware finish
+	ps.shutdownPhase(ctx, "drain", phaseTimeout(timeouts.DrainTimeout, defaultDrainTimeout), ps.waitInFlight)
+
+	// Stop background tasks, then handle alerts they left queued and stop
+	// serving metrics
+	ps.shutdownPhase(ctx, "flush", phaseTimeout(timeouts.FlushTimeout, defaultFlushTimeout), func(ctx context.Context) error {
+		if cancel != nil {
+			cancel()
+		}
+		ps.trafficMonitor.Stop()
+		err := ps.waitBackground(ctx)
+
+		if n := ps.drainAlerts(); n > 0 {
+			ps.logger.Infof("Handled %d queued alerts", n)
+		}
+
+		if ps.metricsServer != nil {
+			if shutdownErr := ps.metricsServer.Shutdown(ctx); shutdownErr != nil {
+				ps.logger.Errorf("Error shutting down metrics server: %v", shutdownErr)
+			}
+		}
+		return err
+	})
+
+	ps.shutdownPhase(ctx, "persist", phaseTimeout(timeouts.PersistTimeout, defaultPersistTimeout), func(ctx context.Context) error {
+		// Save a final snapshot so bans made since the last one survive the restart
+		if path := ps.snapshotFile(); path != "" {
+			if err := ps.ipManager.SaveSnapshot(path); err != nil {
+				ps.logger.Errorf("Failed to save IP list snapshot: %v", err)
+			}
+		}
+
+		// Persist reputation changes made since the last flush
+		if ps.reputation != nil {
+			if err := ps.reputation.Flush(ctx); err != nil {
+				ps.logger.Errorf("Failed to persist IP reputation: %v", err)
+			}
+		}
+
+		// Keep what the anomaly model learned since the last save
+		ps.saveAnomalyModel()
+		return nil
+	})
+
+	ps.shutdownPhase(ctx, "close", phaseTimeout(timeouts.CloseTimeout, defaultCloseTimeout), func(ctx context.Context) error {
+		// Sign what was recorded since the last checkpoint and close the
+		// audit log
+		if ps.auditLog != nil {
+			if _, _, err := ps.auditLog.Checkpoint(ctx); err != nil {
+				ps.logger.Errorf("Failed to write audit checkpoint: %v", err)
+			}
+			if err := ps.auditLog.Close(); err != nil {
+				ps.logger.Errorf("Error closing audit log: %v", err)
+			}
+		}
+
+		// Close GeoIP databases
+		if ps.geoDB != nil {
+			ps.geoDB.Close()
+		}
+		if ps.botnetGeoDB != nil {
+			ps.botnetGeoDB.Close()
+		}
+
+		// Close Redis connection
+		if ps.redisClient != nil {
+			if err := ps.redisClient.Close(); err != nil {
+				ps.logger.Errorf("Error closing Redis connection: %v", err)
+			}
+		}
+		return nil
+	})
+
+	ps.logger.Info("DDoS protection service stopped")
+	return nil
+}
+
+// GetStartTime returns the service start time
+func (ps *ProtectionService) GetStartTime() time.Time {
+	return ps.startTime
+}
+
+// GetHealthStatus returns the health status
 func (ps *ProtectionService) GetHealthStatus(ctx context.Context) *health.HealthStatus {
 	return ps.healthChecker.GetHealthStatus(ctx)
 }
 
+// topFingerprints is how many TLS and header fingerprints the traffic
+// stats list
+const topFingerprints = 20
+
 // GetTrafficStats returns traffic statistics
 func (ps *ProtectionService) GetTrafficStats() *monitor.TrafficStats {
-	return ps.trafficMonitor.GetTrafficStats()
+	stats := ps.trafficMonitor.GetTrafficStats()
+	stats.TLSFingerprints = ps.botnetDetector.Fingerprints(topFingerprints)
+	stats.HeaderFingerprints = ps.botnetDetector.HeaderFingerprints(topFingerprints)
+	return stats
 }
 
-// BlacklistIP blacklists an IP address
-func (ps *ProtectionService) BlacklistIP(ctx context.Context, ip string, duration time.Duration) error {
-	return ps.ipManager.BlacklistIP(ctx, ip, duration)
+// BlacklistIP blacklists exactly an IP address or range for duration, in
+// the lists of the tenant set on ctx with WithTenant if any. Unlike the
+// automatic bans of the detectors, IPv6 addresses are not widened to their
+// prefix nor repeat offenses escalated, so RemoveFromBlacklist lifts the
+// ban with the same target.
+func (ps *ProtectionService) BlacklistIP(ctx context.Context, ip string, duration time.Duration, origin blacklist.Origin) error {
+	lists, err := ps.lists(ctx)
+	if err != nil {
+		return err
+	}
+	before := lookupBlacklist(lists, ip)
+	if err := lists.BlacklistIP(ctx, ip, duration, origin); err != nil {
+		return err
+	}
+	ps.audit(ctx, "blacklist.add", listTarget(lists, ip), before, lookupBlacklist(lists, ip))
+	return nil
 }
 
 // RemoveFromBlacklist removes an IP from blacklist
 func (ps *ProtectionService) RemoveFromBlacklist(ctx context.Context, ip string) error {
-	return ps.ipManager.RemoveFromBlacklist(ctx, ip)
+	lists, err := ps.lists(ctx)
+	if err != nil {
+		return err
+	}
+	before := lookupBlacklist(lists, ip)
+	if err := lists.RemoveFromBlacklist(ctx, ip); err != nil {
+		return err
+	}
+	ps.audit(ctx, "blacklist.remove", listTarget(lists, ip), before, lookupBlacklist(lists, ip))
+	return nil
 }
 
-// WhitelistIP whitelists an IP address
-func (ps *ProtectionService) WhitelistIP(ctx context.Context, ip string) error {
-	return ps.ipManager.WhitelistIP(ctx, ip)
+// WhitelistIP whitelists an IP address for duration, or permanently if
+// duration is zero
+func (ps *ProtectionService) WhitelistIP(ctx context.Context, ip string, duration time.Duration) error {
+	lists, err := ps.lists(ctx)
+	if err != nil {
+		return err
+	}
+	before := lookupWhitelist(lists, ip)
+	if err := lists.WhitelistIP(ctx, ip, duration); err != nil {
+		return err
+	}
+	ps.vindicate(ip)
+	ps.audit(ctx, "whitelist.add", listTarget(lists, ip), before, lookupWhitelist(lists, ip))
+	return nil
 }
 
 // RemoveFromWhitelist removes an IP from whitelist
 func (ps *ProtectionService) RemoveFromWhitelist(ctx context.Context, ip string) error {
-	return ps.ipManager.RemoveFromWhitelist(ctx, ip)
+	lists, err := ps.lists(ctx)
+	if err != nil {
+		return err
+	}
+	before := lookupWhitelist(lists, ip)
+	if err := lists.RemoveFromWhitelist(ctx, ip); err != nil {
+		return err
+	}
+	ps.audit(ctx, "whitelist.remove", listTarget(lists, ip), before, nil)
+	return nil
 }
 
-// GetBlacklistedIPs returns blacklisted IPs
-func (ps *ProtectionService) GetBlacklistedIPs() map[string]time.Time {
-	return ps.ipManager.GetBlacklistedIPs()
+// GreylistIP greylists an IP, counting as one strike
+func (ps *ProtectionService) GreylistIP(ctx context.Context, ip, reason string) error {
+	if !ps.config.Protection.IPBlacklist.Greylist.Enabled {
+		return fmt.Errorf("greylisting is disabled")
+	}
+	before := ps.lookupGreylist(ip)
+	promoted, err := ps.ipManager.Strike(
+		ctx,
+		ip,
+		reason,
+		ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
+	)
+	if err != nil {
+		return err
+	}
+	if promoted {
+		ps.audit(ctx, "blacklist.add", ip, before, lookupBlacklist(ps.ipManager, ip))
+	} else {
+		ps.audit(ctx, "greylist.add", ip, before, ps.lookupGreylist(ip))
+	}
+	return nil
 }
 
-// GetWhitelistedIPs returns whitelisted IPs
-func (ps *ProtectionService) GetWhitelistedIPs() []string {
-	return ps.ipManager.GetWhitelistedIPs()
+// RemoveFromGreylist removes an IP from the greylist
+func (ps *ProtectionService) RemoveFromGreylist(ctx context.Context, ip string) error {
+	before := ps.lookupGreylist(ip)
+	if err := ps.ipManager.RemoveFromGreylist(ctx, ip); err != nil {
+		return err
+	}
+	ps.audit(ctx, "greylist.remove", ip, before, nil)
+	return nil
+}
+
+// GetGreylist returns greylisted IPs
+func (ps *ProtectionService) GetGreylist() []blacklist.GreylistEntry {
+	return ps.ipManager.GetGreylist()
+}
+
+// ListBlacklist returns a page of blacklisted IPs and ranges with their
+// metadata
+func (ps *ProtectionService) ListBlacklist(q blacklist.ListQuery) blacklist.ListPage {
+	return ps.listsFor(q.Tenant).ListBlacklist(q)
+}
+
+// ListWhitelist returns a page of whitelisted IPs
+func (ps *ProtectionService) ListWhitelist(q blacklist.ListQuery) blacklist.WhitelistPage {
+	return ps.listsFor(q.Tenant).ListWhitelist(q)
 }
 
 // GetRateLimitConfig returns current rate limit configuration
@@ -420,21 +1788,359 @@ func (ps *ProtectionService) GetRateLimitConfig() map[string]interface{} {
 }
 
 // UpdateRateLimitConfig updates rate limit configuration
-func (ps *ProtectionService) UpdateRateLimitConfig(requestsPerMinute, burstSize int) error {
+func (ps *ProtectionService) UpdateRateLimitConfig(ctx context.Context, requestsPerMinute, burstSize int) error {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
+	after := config.RateLimitConfig{RequestsPerMinute: requestsPerMinute, BurstSize: burstSize}
+
+	// While capacity mitigation is active, update the baseline it restores to
+	if ps.rateLimitBase != nil {
+		ps.audit(ctx, "config.rate_limit", "", rateLimitState(*ps.rateLimitBase), rateLimitState(after))
+		ps.rateLimitBase.RequestsPerMinute = requestsPerMinute
+		ps.rateLimitBase.BurstSize = burstSize
+		ps.logger.Infof("Rate limit baseline updated during capacity mitigation: %d req/min, burst: %d", requestsPerMinute, burstSize)
+		return nil
+	}
+
+	ps.audit(ctx, "config.rate_limit", "", rateLimitState(ps.config.Protection.RateLimit), rateLimitState(after))
+
 	// Update config
 	ps.config.Protection.RateLimit.RequestsPerMinute = requestsPerMinute
 	ps.config.Protection.RateLimit.BurstSize = burstSize
 
-	// Reinitialize rate limiter
-	ps.initRateLimiter()
+	ps.applyRateLimit()
 
 	ps.logger.Infof("Rate limit configuration updated: %d req/min, burst: %d", requestsPerMinute, burstSize)
 	return nil
 }
 
+// ApplyPreset switches to a built-in protection preset at runtime
+func (ps *ProtectionService) ApplyPreset(ctx context.Context, name string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	before := ps.presetState()
+	mitigated := ps.config.Protection.RateLimit
+	if err := config.ApplyPreset(ps.config, name); err != nil {
+		return err
+	}
+	cfg := &ps.config.Protection
+
+	// While capacity mitigation is active, the preset becomes the baseline it
+	// restores to and the reduced limits stay in force
+	if ps.rateLimitBase != nil {
+		base := cfg.RateLimit
+		ps.rateLimitBase = &base
+		cfg.RateLimit = mitigated
+	} else {
+		ps.applyRateLimit()
+	}
+
+	ps.initMethodLimits()
+	ps.requestFilter.SetMaxRequestSize(int64(cfg.RequestFilter.MaxRequestSize))
+	ps.botnetDetector.SetThreshold(ps.botnetThreshold())
+	ps.detectors.SetThreshold(ps.botnetThreshold())
+
+	grey := cfg.IPBlacklist.Greylist
+	ps.ipManager.SetGreylistPolicy(grey.PromoteAfter, grey.QuietPeriod.Duration())
+	ps.greyLimiter.SetLimits(grey.RequestsPerMinute, grey.BurstSize)
+
+	ps.audit(ctx, "config.preset", name, before, ps.presetState())
+	ps.logger.Infof("Protection preset applied: %s", name)
+	return nil
+}
+
+// IPDetail is the reputation of an IP and its tracked behavior, for IPv6
+// clients both for the address and for its network
+type IPDetail struct {
+	reputation.Report
+	Behavior botnet.BehaviorReport `json:"behavior"`
+}
+
+// GetReputation returns the reputation and behavior of an IP
+func (ps *ProtectionService) GetReputation(ip string) (IPDetail, error) {
+	if ps.reputation == nil {
+		return IPDetail{}, fmt.Errorf("IP reputation is disabled")
+	}
+	return IPDetail{
+		Report:   ps.reputation.Get(ip),
+		Behavior: ps.botnetDetector.Behavior(ip),
+	}, nil
+}
+
+// BotnetExplanation is what the botnet detector knows of an IP, with the
+// thresholds its analysis is judged against
+type BotnetExplanation struct {
+	botnet.Explanation
+	DetectionThreshold      float64 `json:"detection_threshold"`
+	AutoBlacklistConfidence float64 `json:"auto_blacklist_confidence"`
+}
+
+// ExplainBotnet returns the tracked behavior of an IP and the latest
+// analysis of its requests, so a detection can be understood and contested
+func (ps *ProtectionService) ExplainBotnet(ip string) BotnetExplanation {
+	return BotnetExplanation{
+		Explanation:             ps.botnetDetector.Explain(ip),
+		DetectionThreshold:      ps.botnetThreshold(),
+		AutoBlacklistConfidence: ps.autoBlacklistConfidence(),
+	}
+}
+
+// ListPresets returns the built-in protection presets
+func (ps *ProtectionService) ListPresets() []config.Preset {
+	return config.Presets()
+}
+
+// GetPreset returns the name of the active preset, if any
+func (ps *ProtectionService) GetPreset() string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.config.Protection.Preset
+}
+
+// AddFilterRule adds a request filter rule; it runs in shadow while on probation
+func (ps *ProtectionService) AddFilterRule(ctx context.Context, id, pattern string) error {
+	if err := ps.requestFilter.AddRule(id, pattern); err != nil {
+		return err
+	}
+	ps.audit(ctx, "rule.add", id, nil, map[string]string{"pattern": pattern})
+	return nil
+}
+
+// GetProbationRules returns the state of rules on probation
+func (ps *ProtectionService) GetProbationRules() []probation.RuleState {
+	if ps.probation == nil {
+		return []probation.RuleState{}
+	}
+	return ps.probation.GetRules()
+}
+
+// PromoteRule ends probation for a rule and starts enforcing it
+func (ps *ProtectionService) PromoteRule(ctx context.Context, id string) error {
+	if ps.probation == nil {
+		return fmt.Errorf("rule probation is disabled")
+	}
+	if err := ps.probation.Promote(id); err != nil {
+		return err
+	}
+	ps.audit(ctx, "rule.promote", id, map[string]bool{"enforced": false}, map[string]bool{"enforced": true})
+	return nil
+}
+
+// SetRuleRollout enforces a rule for a percentage of clients
+func (ps *ProtectionService) SetRuleRollout(ctx context.Context, id string, percent int) error {
+	if ps.probation == nil {
+		return fmt.Errorf("rule probation is disabled")
+	}
+
+	before := ps.probationRule(id)
+	if err := ps.probation.SetRollout(id, percent); err != nil {
+		return err
+	}
+	ps.audit(ctx, "rule.rollout", id, before, ps.probationRule(id))
+	return nil
+}
+
+// probationRule returns the state of a rule on probation, or nil
+func (ps *ProtectionService) probationRule(id string) *probation.RuleState {
+	for _, rule := range ps.probation.GetRules() {
+		if rule.ID == id {
+			return &rule
+		}
+	}
+	return nil
+}
+
+// EngageKillSwitch disables a rule, feed, or indicator on every instance
+func (ps *ProtectionService) EngageKillSwitch(ctx context.Context, kind, name, reason string) error {
+	k, err := killswitch.ParseKind(kind)
+	if err != nil {
+		return err
+	}
+
+	before := ps.lookupKillSwitch(string(k), name)
+	if err := ps.killSwitches.Engage(ctx, k, name, reason); err != nil {
+		return err
+	}
+	ps.audit(ctx, "kill_switch.engage", string(k)+"/"+name, before, ps.lookupKillSwitch(string(k), name))
+	ps.killSwitchChanged(k)
+
+	ps.logger.WithFields(logrus.Fields{
+		"kind":   kind,
+		"name":   name,
+		"reason": reason,
+	}).Warn("Kill switch engaged")
+	return nil
+}
+
+// ReleaseKillSwitch re-enables a rule, feed, or indicator on every instance
+func (ps *ProtectionService) ReleaseKillSwitch(ctx context.Context, kind, name string) error {
+	k, err := killswitch.ParseKind(kind)
+	if err != nil {
+		return err
+	}
+
+	before := ps.lookupKillSwitch(string(k), name)
+	if err := ps.killSwitches.Release(ctx, k, name); err != nil {
+		return err
+	}
+	ps.audit(ctx, "kill_switch.release", string(k)+"/"+name, before, nil)
+	ps.killSwitchChanged(k)
+
+	ps.logger.WithFields(logrus.Fields{
+		"kind": kind,
+		"name": name,
+	}).Info("Kill switch released")
+	return nil
+}
+
+// killSwitchChanged applies a feed kill switch to the kernel firewall and
+// the edge integrations, which hold feed entries only while their feed is
+// enabled
+func (ps *ProtectionService) killSwitchChanged(kind killswitch.Kind) {
+	if kind != killswitch.KindFeed {
+		return
+	}
+	if ps.enforcer != nil {
+		ps.enforcer.Notify()
+	}
+	if ps.edgeMirror != nil {
+		ps.edgeMirror.Notify()
+	}
+	if ps.wafSyncer != nil {
+		ps.wafSyncer.Notify()
+	}
+}
+
+// GetKillSwitches returns the engaged kill switches
+func (ps *ProtectionService) GetKillSwitches() []killswitch.Switch {
+	return ps.killSwitches.List()
+}
+
+// SetASNRule adds or replaces the block/rate-limit rule for an AS
+func (ps *ProtectionService) SetASNRule(ctx context.Context, rule blacklist.ASNRule) error {
+	before := ps.lookupASNRule(rule.ASN)
+	if err := ps.ipManager.SetASNRule(ctx, rule); err != nil {
+		return err
+	}
+	ps.audit(ctx, "asn_rule.set", fmt.Sprintf("AS%d", rule.ASN), before, rule)
+
+	// Drop any limiter built from the previous rule
+	ps.mu.Lock()
+	delete(ps.asnLimiters, rule.ASN)
+	ps.mu.Unlock()
+
+	return nil
+}
+
+// RemoveASNRule removes the rule for an AS
+func (ps *ProtectionService) RemoveASNRule(ctx context.Context, asn uint32) error {
+	ps.mu.Lock()
+	delete(ps.asnLimiters, asn)
+	ps.mu.Unlock()
+
+	before := ps.lookupASNRule(asn)
+	if err := ps.ipManager.RemoveASNRule(ctx, asn); err != nil {
+		return err
+	}
+	ps.audit(ctx, "asn_rule.remove", fmt.Sprintf("AS%d", asn), before, nil)
+	return nil
+}
+
+// GetASNRules returns the configured ASN rules
+func (ps *ProtectionService) GetASNRules() []blacklist.ASNRule {
+	return ps.ipManager.GetASNRules()
+}
+
+// LookupASN returns the AS announcing an IP
+func (ps *ProtectionService) LookupASN(ip string) (blacklist.ASNInfo, bool) {
+	return ps.ipManager.LookupASN(ip)
+}
+
+// allowASN applies the rate limit shared by every IP of a rate-limited AS
+func (ps *ProtectionService) allowASN(ctx context.Context, rule blacklist.ASNRule) bool {
+	ps.mu.Lock()
+	limiter, exists := ps.asnLimiters[rule.ASN]
+	if !exists {
+		limiter = ratelimit.NewTokenBucketLimiter(rule.RequestsPerMinute, rule.RequestsPerMinute)
+		ps.asnLimiters[rule.ASN] = limiter
+	}
+	ps.mu.Unlock()
+
+	return limiter.Allow(ctx, fmt.Sprintf("AS%d", rule.ASN))
+}
+
+// CheckDNSBL looks an IP up in the configured DNS blocklists
+func (ps *ProtectionService) CheckDNSBL(ctx context.Context, ip string) (dnsbl.Result, error) {
+	if ps.dnsblChecker == nil {
+		return dnsbl.Result{}, fmt.Errorf("DNSBL checks are disabled")
+	}
+	return ps.dnsblChecker.Check(ctx, ip)
+}
+
+// InspectRateLimitKey returns the limiter state and rejection history of a key
+func (ps *ProtectionService) InspectRateLimitKey(ctx context.Context, key string) (*ratelimit.KeyState, ratelimit.RejectionStats, error) {
+	inspector, ok := ps.rateLimiter.(ratelimit.Inspector)
+	if !ok {
+		return nil, ratelimit.RejectionStats{}, fmt.Errorf("rate limiter does not support inspection")
+	}
+
+	state, err := inspector.Inspect(ctx, key)
+	if err != nil {
+		return nil, ratelimit.RejectionStats{}, err
+	}
+	state.Windows = ps.burstCounts(key)
+	return state, ps.rejections.Get(key), nil
+}
+
+// GetTopThrottledKeys returns the keys rejected most often since the given time
+func (ps *ProtectionService) GetTopThrottledKeys(n int, since time.Time) []ratelimit.RejectionStats {
+	return ps.rejections.Top(n, since)
+}
+
+// ReloadGeoDatabase reloads the GeoIP database from disk
+func (ps *ProtectionService) ReloadGeoDatabase() error {
+	if ps.geoDB == nil {
+		return fmt.Errorf("GeoIP is disabled")
+	}
+	return ps.geoDB.Reload()
+}
+
+// LookupCountry returns the country of an IP
+func (ps *ProtectionService) LookupCountry(ip string) (string, error) {
+	if ps.geoDB == nil {
+		return "", fmt.Errorf("GeoIP is disabled")
+	}
+	return ps.geoDB.Country(ip), nil
+}
+
+// SetIncidentMode turns incident mode on or off. Geofences scheduled with
+// during_incident only apply while it is on.
+func (ps *ProtectionService) SetIncidentMode(ctx context.Context, active bool) {
+	ps.mu.Lock()
+	before := ps.incidentActive
+	ps.incidentActive = active
+	ps.mu.Unlock()
+	ps.trackIncident()
+
+	ps.audit(ctx, "incident_mode.set", "", before, active)
+
+	ps.logger.Warnf("Incident mode set to %v", active)
+}
+
+// IncidentActive reports whether incident mode is on
+func (ps *ProtectionService) IncidentActive() bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.incidentActive
+}
+
+// GetGeoFences returns the configured geofences and whether each is in force
+func (ps *ProtectionService) GetGeoFences() []geo.FenceStatus {
+	return ps.geoFences.Status(time.Now(), ps.IncidentActive())
+}
+
 // GetCircuitBreakerStatus returns circuit breaker status
 func (ps *ProtectionService) GetCircuitBreakerStatus() map[string]interface{} {
 	return ps.healthChecker.GetCircuitBreakerStatus()
@@ -442,153 +2148,168 @@ func (ps *ProtectionService) GetCircuitBreakerStatus() map[string]interface{} {
 
 // getClientIP extracts the real client IP from the request
 func (ps *ProtectionService) getClientIP(c *gin.Context) string {
-	// Check X-Forwarded-For header (for load balancers/proxies)
-	if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
+	return blacklist.GetClientIP(c.Request)
+}
+
+// recordEvent stores a blocking or challenging verdict as a security event
+func (ps *ProtectionService) recordEvent(info *pipeline.RequestInfo, stage string, verdict pipeline.Verdict, traceID string) {
+	code := verdict.Code
+	if verdict.Decision == pipeline.Challenge {
+		code = "CHALLENGE_REQUIRED"
 	}
 
-	// Check X-Real-IP header
-	if xri := c.GetHeader("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
+	event := events.Event{
+		Time:     info.Start,
+		IP:       info.ClientIP,
+		Method:   info.Request.Method,
+		Path:     info.Request.URL.Path,
+		Tenant:   info.Tenant,
+		Stage:    stage,
+		Decision: verdict.Decision.String(),
+		Code:     code,
+		Reason:   verdict.Reason,
+		Score:    info.RiskScore,
+		TraceID:  traceID,
+	}
+	ps.eventStore.Add(event)
+	if ps.attacks != nil {
+		ps.attacks.Observe(attacks.Detection{Time: event.Time, IP: event.IP, Path: event.Path, Code: event.Code})
+	}
+	if ps.authCorrelator != nil {
+		ps.authCorrelator.Blocked(info.ClientIP, info.Start)
 	}
+	if ps.replicaPublisher != nil {
+		ps.replicaPublisher.PublishEvent(event)
+	}
+	ps.exportDecisionRecord(event)
+}
 
-	// Fall back to RemoteAddr
-	ip, _, found := strings.Cut(c.Request.RemoteAddr, ":")
-	if !found {
-		return c.Request.RemoteAddr
+// SearchEvents runs a query over stored security events
+func (ps *ProtectionService) SearchEvents(query, cursor string, limit int) (*events.Page, error) {
+	q, err := events.ParseQuery(query, time.Now())
+	if err != nil {
+		return nil, err
 	}
-	return ip
+	return ps.eventStore.Search(q, cursor, limit)
+}
+
+// Pipeline returns the protection pipeline so callers can insert, remove,
+// or reorder stages
+func (ps *ProtectionService) Pipeline() *pipeline.Pipeline {
+	return ps.pipeline
 }
 
 // ProtectionMiddleware is the main DDoS protection middleware
 func (ps *ProtectionService) ProtectionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if !ps.beginRequest(c) {
+			return
+		}
+		defer ps.endRequest()
+		defer ps.beginSlowRequest(c.Request)()
+
 		start := time.Now()
 		clientIP := ps.getClientIP(c)
+		if ps.isAppeal(c.Request) {
+			ps.serveAppeal(c, clientIP)
+			return
+		}
+		if ps.isChallenge(c.Request) {
+			ps.serveChallenge(c, clientIP)
+			return
+		}
 
 		// Log the request
 		ps.logger.WithFields(logrus.Fields{
-			"ip":      clientIP,
-			"method":  c.Request.Method,
-			"path":    c.Request.URL.Path,
-			"ua":      c.Request.UserAgent(),
+			"ip":     clientIP,
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"ua":     c.Request.UserAgent(),
 		}).Debug("Processing request")
 
-		// Step 1: Check IP blacklist/whitelist
-		if ps.config.Protection.IPBlacklist.Enabled {
-			if ps.ipManager.IsBlacklisted(c.Request.Context(), clientIP) {
-				ps.logger.WithField("ip", clientIP).Warn("Request blocked - IP blacklisted")
-				c.JSON(http.StatusForbidden, gin.H{
-					"error": "Access denied",
-					"code":  "BLOCKED_IP",
-				})
-				c.Abort()
-				return
-			}
+		trace := ps.startTrace(c)
+		info := pipeline.NewRequestInfo(c.Request, clientIP, ps.tenantResolver.Resolve(c.Request))
+		ps.identifyClient(c, info)
+		ps.identifySession(info)
+		verdict, stage, cached := ps.lookupVerdict(info)
+		if !cached {
+			verdict, stage = ps.pipeline.Evaluate(c.Request.Context(), info)
+			ps.storeVerdict(info, stage, verdict)
 		}
-
-		// Step 2: Rate limiting
-		if !ps.rateLimiter.Allow(c.Request.Context(), clientIP) {
-			ps.logger.WithField("ip", clientIP).Warn("Request blocked - rate limit exceeded")
-			
-			// Check if we should auto-blacklist this IP
-			if ps.ipManager.ShouldAutoBlacklist(c.Request.Context(), clientIP, 100) {
-				if err := ps.ipManager.BlacklistIP(
-					c.Request.Context(),
-					clientIP,
-					time.Duration(ps.config.Protection.IPBlacklist.BlacklistDuration)*time.Second,
-				); err != nil {
-					ps.logger.Errorf("Failed to auto-blacklist IP %s: %v", clientIP, err)
-				}
-			}
-
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-				"code":  "RATE_LIMITED",
-			})
-			c.Abort()
-			return
+		verdict = ps.applyChallengePolicy(info, verdict)
+		ps.decideTrace(c, trace, info, verdict)
+		if key, ok := info.Values["api_key"].(apikey.Key); ok {
+			c.Set("actor", "key:"+key.ID)
+		}
+		defer ps.finishTrace(c, trace, info, stage, verdict)
+		switch verdict.Decision {
+		case pipeline.Deny, pipeline.Challenge, pipeline.Slowdown:
+			ps.recordEvent(info, stage, verdict, traceID(trace))
 		}
 
-		// Step 3: Request filtering
-		if ps.config.Protection.RequestFilter.Enabled {
-			filterResult := ps.requestFilter.FilterRequest(c.Request.Context(), c.Request)
-			if !filterResult.Allowed {
-				ps.logger.WithFields(logrus.Fields{
-					"ip":           clientIP,
-					"reason":       filterResult.Reason,
-					"risk_score":   filterResult.RiskScore,
-				}).Warn("Request blocked - filter failed")
-
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "Request blocked",
-					"code":  "FILTERED",
-					"reason": filterResult.Reason,
-				})
-				c.Abort()
-				return
-			}
-
-			if filterResult.ShouldLog {
-				ps.logger.WithFields(logrus.Fields{
-					"ip":           clientIP,
-					"reason":       filterResult.Reason,
-					"risk_score":   filterResult.RiskScore,
-				}).Info("Request flagged by filter")
+		for k, values := range verdict.Headers {
+			for _, v := range values {
+				c.Writer.Header().Add(k, v)
 			}
 		}
 
-		// Step 4: Botnet detection
-		startTime := time.Now()
-		botnetResult := ps.botnetDetector.AnalyzeRequest(
-			c.Request.Context(), 
-			clientIP, 
-			c.Request.UserAgent(), 
-			c.Request.URL.Path,
-			time.Since(startTime),
-		)
-		
-		if botnetResult.IsBotnet {
-			ps.logger.WithFields(logrus.Fields{
-				"ip":            clientIP,
-				"confidence":    botnetResult.Confidence,
-				"indicators":    botnetResult.Indicators,
-				"risk_score":    botnetResult.RiskScore,
-			}).Warn("Request blocked - botnet detected")
-
-			// Auto-blacklist botnet IPs with high confidence
-			if botnetResult.Confidence > 0.8 {
-				if err := ps.ipManager.BlacklistIP(
-					c.Request.Context(),
-					clientIP,
-					time.Duration(ps.config.Protection.IPBlacklist.BlacklistDuration)*time.Second,
-				); err != nil {
-					ps.logger.Errorf("Failed to auto-blacklist botnet IP %s: %v", clientIP, err)
-				} else {
-					ps.logger.Infof("Auto-blacklisted botnet IP %s (confidence: %.2f)", clientIP, botnetResult.Confidence)
-				}
+		switch verdict.Decision {
+		case pipeline.Respond:
+			ps.trafficMonitor.RecordMethod(c.Request.Method, "answered")
+			c.AbortWithStatus(verdict.Status)
+			return
+		case pipeline.Deny:
+			ps.trafficMonitor.RecordMethod(c.Request.Method, "denied")
+			body := gin.H{
+				"error": verdict.Error,
+				"code":  verdict.Code,
 			}
-
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "Access denied - botnet detected",
-				"code":  "BOTNET_DETECTED",
-				"confidence": botnetResult.Confidence,
-				"indicators": botnetResult.Indicators,
-			})
+			for k, v := range verdict.Fields {
+				body[k] = v
+			}
+			c.JSON(verdict.Status, body)
 			c.Abort()
+			ps.recordBlockedCost(c, start)
+			return
+		case pipeline.Challenge:
+			ps.trafficMonitor.RecordMethod(c.Request.Method, "challenged")
+			ps.challenge(c, verdict.Reason)
+			ps.recordBlockedCost(c, start)
 			return
+		case pipeline.Slowdown:
+			if !ps.applySlowdown(c, verdict) {
+				return
+			}
+			ps.trafficMonitor.RecordMethod(c.Request.Method, "slowed")
+		default:
+			ps.trafficMonitor.RecordMethod(c.Request.Method, "served")
 		}
 
 		// Process the request
-		c.Next()
+		upstreamStart := time.Now()
+		ps.serveFiltered(c, clientIP)
+		if ps.attackCost != nil && !isMeshCheck(c) {
+			ps.attackCost.Served(time.Since(upstreamStart), c.Writer.Size())
+		}
 
-		// Record metrics
+		// Record metrics; monitoring agents have their own bucket
 		responseTime := time.Since(start)
-		ps.trafficMonitor.RecordRequest(c.Request.Context(), c.Request, responseTime, c.Writer.Status())
+		if profile, ok := info.Values["monitor_agent"].(string); ok {
+			ps.trafficMonitor.RecordAgentRequest(profile)
+		} else {
+			ps.trafficMonitor.RecordRequest(c.Request.Context(), c.Request, responseTime, c.Writer.Status())
+
+			// Mesh checks never see the upstream response
+			if !isMeshCheck(c) {
+				if ps.slaMonitor != nil {
+					ps.slaMonitor.Observe(c.Request.URL.Path, responseTime)
+				}
+				if ps.reputation != nil {
+					ps.reputation.RecordResponse(clientIP, c.Writer.Status())
+				}
+				ps.recordScanResponse(c.Request.Context(), clientIP, c.Request.URL.Path, c.Writer.Status())
+			}
+		}
 
 		// Log the response
 		ps.logger.WithFields(logrus.Fields{
@@ -0,0 +1,117 @@
+package ddos
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/proxyprobe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initProxyProbe sets up probing high-risk clients for open proxies
+func (ps *ProtectionService) initProxyProbe() {
+	cfg := ps.config.Protection.ProxyProbe
+	if !cfg.Enabled {
+		return
+	}
+
+	ports := proxyprobe.DefaultPorts
+	if len(cfg.Ports) > 0 {
+		ports = nil
+		for _, s := range cfg.Ports {
+			port, err := proxyprobe.ParsePort(s)
+			if err != nil {
+				ps.logger.Warnf("Ignoring proxy probe port: %v", err)
+				continue
+			}
+			ports = append(ports, port)
+		}
+		if len(ports) == 0 {
+			ps.logger.Error("No valid proxy probe ports, open proxy probing disabled")
+			return
+		}
+	}
+
+	target := cfg.Target
+	if target == "" {
+		target = "example.com:80"
+	}
+	timeout := cfg.Timeout.Duration()
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	cacheTTL := cfg.CacheTTL.Duration()
+	if cacheTTL <= 0 {
+		cacheTTL = 24 * time.Hour
+	}
+	perSecond := cfg.ProbesPerSecond
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+
+	ps.proxyProber = proxyprobe.NewProber(ports, target, timeout, cacheTTL, perSecond, ps.openProxyFound)
+	ps.logger.Infof("Open proxy probing enabled for clients with risk score %d or more", ps.proxyProbeThreshold())
+}
+
+// proxyProbeThreshold returns the risk score that triggers a probe,
+// defaulting to 70
+func (ps *ProtectionService) proxyProbeThreshold() int {
+	if t := ps.config.Protection.ProxyProbe.RiskThreshold; t > 0 {
+		return t
+	}
+	return 70
+}
+
+// probeProxy starts a background open proxy probe of a client whose risk
+// score reached the threshold. Private and whitelisted addresses are never
+// probed.
+func (ps *ProtectionService) probeProxy(ctx context.Context, ip string, riskScore int) {
+	if ps.proxyProber == nil || riskScore < ps.proxyProbeThreshold() || blacklist.IsPrivateIP(ip) {
+		return
+	}
+	if _, cached := ps.proxyProber.Cached(ip); cached {
+		return
+	}
+	if ps.ipManager.IsWhitelisted(ctx, ip) {
+		return
+	}
+	ps.proxyProber.ProbeAsync(ip)
+}
+
+// openProxyFound penalizes the reputation of a client confirmed to run an
+// open proxy and blacklists it
+func (ps *ProtectionService) openProxyFound(ip string, result proxyprobe.Result) {
+	cfg := ps.config.Protection.ProxyProbe
+	proxies := strings.Join(result.Proxies, ", ")
+	ps.logger.WithFields(logrus.Fields{
+		"ip":      ip,
+		"proxies": result.Proxies,
+	}).Warn("Open proxy confirmed")
+
+	if ps.reputation != nil {
+		penalty := cfg.ReputationPenalty
+		if penalty <= 0 {
+			penalty = 40
+		}
+		duration := cfg.ReputationDuration.Duration()
+		if duration <= 0 {
+			duration = 7 * 24 * time.Hour
+		}
+		ps.reputation.RecordMark(ip, "open_proxy", penalty, time.Now().Add(duration))
+	}
+
+	duration := cfg.BlacklistDuration.Duration()
+	if duration <= 0 {
+		duration = 24 * time.Hour
+	}
+	err := ps.ipManager.BlacklistIP(context.Background(), ip, duration, blacklist.Origin{
+		Source: blacklist.SourceOpenProxy,
+		Reason: "open proxy: " + proxies,
+	})
+	if err != nil {
+		ps.logger.Errorf("Failed to blacklist open proxy %s: %v", ip, err)
+	}
+}
@@ -0,0 +1,42 @@
+package ddos
+
+import (
+	"os"
+	"time"
+
+	"ddos-protection/internal/replica"
+)
+
+// initReplication sets up publishing security events and traffic stats to
+// Redis, where read replicas pick them up
+func (ps *ProtectionService) initReplication() {
+	cfg := ps.config.Replication
+	if !cfg.Enabled {
+		return
+	}
+	if ps.redisClient == nil {
+		ps.logger.Error("Replication needs Redis, replication disabled")
+		return
+	}
+
+	node := ps.nodeName()
+	ps.replicaPublisher = replica.NewPublisher(ps.redisClient, node, cfg.MaxEvents)
+	ps.logger.Infof("Replication enabled, publishing as node %s", node)
+}
+
+// replicaStatsInterval returns how often traffic stats are published
+func (ps *ProtectionService) replicaStatsInterval() time.Duration {
+	if interval := ps.config.Replication.StatsInterval.Duration(); interval > 0 {
+		return interval
+	}
+	return 10 * time.Second
+}
+
+// nodeName names this instance in state shared with others: its hostname,
+// or its listen address if that is unknown
+func (ps *ProtectionService) nodeName() string {
+	if node, err := os.Hostname(); err == nil && node != "" {
+		return node
+	}
+	return ps.config.Server.Port
+}
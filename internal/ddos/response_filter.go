@@ -0,0 +1,199 @@
+package ddos
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	"ddos-protection/internal/filter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// initResponseFilter sets up scanning of responses for stack traces,
+// database errors and credentials
+func (ps *ProtectionService) initResponseFilter() {
+	cfg := ps.config.Protection.ResponseFilter
+	if !cfg.Enabled {
+		return
+	}
+
+	custom := make([]filter.LeakRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			ps.logger.Errorf("Invalid response rule %s, not filtering responses: %v", r.ID, err)
+			return
+		}
+		custom = append(custom, filter.LeakRule{ID: r.ID, Category: r.Category, Pattern: re, Action: filter.LeakAction(r.Action)})
+	}
+	actions := make(map[string]filter.LeakAction, len(cfg.RuleActions))
+	for id, action := range cfg.RuleActions {
+		actions[id] = filter.LeakAction(action)
+	}
+
+	responseFilter, err := filter.NewResponseFilter(custom, actions, cfg.Mask, int64(cfg.MaxBodySize))
+	if err != nil {
+		ps.logger.Errorf("Invalid response filter, not filtering responses: %v", err)
+		return
+	}
+	ps.responseFilter = responseFilter
+	ps.logger.Infof("Response filter enabled with %d rules", len(responseFilter.Rules()))
+}
+
+// GetResponseRules returns the rules responses are scanned with, or nil
+// when the response filter is off
+func (ps *ProtectionService) GetResponseRules() []filter.LeakRule {
+	if ps.responseFilter == nil {
+		return nil
+	}
+	return ps.responseFilter.Rules()
+}
+
+// serveFiltered runs the upstream handlers, holding their response back
+// to be scanned before it is sent when the response filter is on. What a
+// handler wrote before panicking is scanned and sent too.
+func (ps *ProtectionService) serveFiltered(c *gin.Context, clientIP string) {
+	if ps.responseFilter == nil {
+		c.Next()
+		return
+	}
+
+	w := &leakWriter{ResponseWriter: c.Writer, filter: ps.responseFilter, buffering: true}
+	c.Writer = w
+	defer func() {
+		c.Writer = w.ResponseWriter
+		result := w.finish()
+		if result == nil || len(result.Matched) == 0 {
+			return
+		}
+		ps.logger.WithFields(logrus.Fields{
+			"ip":      clientIP,
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"rules":   result.Matched,
+			"blocked": result.Blocked,
+		}).Warn("Response leaked sensitive information")
+	}()
+	c.Next()
+}
+
+// leakWriter buffers a response until it ends, so the response filter
+// can scan it. Responses of types it does not scan, larger than its
+// limit, flushed or hijacked are passed through as written.
+type leakWriter struct {
+	gin.ResponseWriter
+	filter    *filter.ResponseFilter
+	buf       bytes.Buffer
+	buffering bool
+	decided   bool
+	written   bool
+}
+
+func (w *leakWriter) Write(data []byte) (int, error) {
+	if w.buffering && !w.decided {
+		w.decided = true
+		header := w.Header()
+		w.buffering = w.filter.Scans(header.Get("Content-Type"), header.Get("Content-Encoding"))
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.written = true
+	w.buf.Write(data)
+	if int64(w.buf.Len()) > w.filter.Limit() {
+		if err := w.passThrough(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *leakWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *leakWriter) WriteHeaderNow() {
+	if w.buffering {
+		w.written = true
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *leakWriter) Written() bool {
+	if w.buffering {
+		return w.written
+	}
+	return w.ResponseWriter.Written()
+}
+
+func (w *leakWriter) Size() int {
+	if !w.buffering {
+		return w.ResponseWriter.Size()
+	}
+	if !w.written {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+func (w *leakWriter) Flush() {
+	w.passThrough()
+	w.ResponseWriter.Flush()
+}
+
+func (w *leakWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.passThrough()
+	return w.ResponseWriter.Hijack()
+}
+
+// passThrough stops buffering, sending what was held back
+func (w *leakWriter) passThrough() error {
+	if !w.buffering {
+		return nil
+	}
+	w.buffering = false
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish scans and sends the held back response, returning what the scan
+// found, or nil if the response was passed through
+func (w *leakWriter) finish() *filter.ResponseResult {
+	if !w.buffering {
+		return nil
+	}
+	w.buffering = false
+	if w.buf.Len() == 0 {
+		if w.written {
+			w.ResponseWriter.WriteHeaderNow()
+		}
+		return nil
+	}
+
+	result := w.filter.Scan(w.buf.Bytes())
+	header := w.Header()
+	if result.Blocked {
+		header.Del("Content-Length")
+		header.Del("Content-Disposition")
+		header.Set("Content-Type", "application/json; charset=utf-8")
+		w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w.ResponseWriter, `{"code":"RESPONSE_FILTERED","error":"Internal server error"}`)
+		return result
+	}
+	if len(result.Body) != w.buf.Len() {
+		header.Del("Content-Length")
+	}
+	w.ResponseWriter.Write(result.Body)
+	return result
+}
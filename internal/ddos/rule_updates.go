@@ -0,0 +1,229 @@
+package ddos
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/rulebundle"
+)
+
+// bundleRulePrefix sets the IDs of bundle rules apart from those added
+// through the API
+const bundleRulePrefix = "bundle:"
+
+// bundleSerialKey holds the highest bundle serial accepted by any instance
+const bundleSerialKey = "rulebundle:serial"
+
+// RuleBundleStatus is the rule bundle in force and how the last check of
+// the update channel went
+type RuleBundleStatus struct {
+	URL       string    `json:"url"`
+	Version   string    `json:"version,omitempty"`
+	Serial    int64     `json:"serial,omitempty"`
+	Published time.Time `json:"published,omitempty"`
+	Rules     int       `json:"rules"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ruleChannel follows the rule update channel. rules maps the filter IDs of
+// the applied bundle's rules to their patterns.
+type ruleChannel struct {
+	fetcher *rulebundle.Fetcher
+	status  RuleBundleStatus
+	rules   map[string]string
+	mu      sync.Mutex
+}
+
+// initRuleUpdates sets up fetching signed rule bundles. Bundle rules are
+// staged in shadow, so probation must be enabled.
+func (ps *ProtectionService) initRuleUpdates() {
+	cfg := ps.config.Protection.RuleUpdates
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.URL == "" {
+		ps.logger.Error("No rule update channel URL, rule updates disabled")
+		return
+	}
+	if ps.probation == nil {
+		ps.logger.Error("Rule updates need rule probation to stage new rules in shadow, rule updates disabled")
+		return
+	}
+
+	var keys []ed25519.PublicKey
+	for _, s := range cfg.PublicKeys {
+		key, err := rulebundle.ParsePublicKey(s)
+		if err != nil {
+			ps.logger.Warnf("Ignoring rule bundle key: %v", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		ps.logger.Error("No valid rule bundle public keys, rule updates disabled")
+		return
+	}
+
+	timeout := cfg.Timeout.Duration()
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	// The serial that guards against rollbacks must outlive a restart,
+	// or a mirror could serve an old bundle to a freshly started node
+	var store rulebundle.Store
+	switch {
+	case ps.redisClient != nil:
+		store = rulebundle.RedisStore{Client: ps.redisClient, Key: bundleSerialKey}
+	case cfg.StateFile != "":
+		store = rulebundle.FileStore(cfg.StateFile)
+	default:
+		ps.logger.Warn("No Redis or rule update state_file, rule bundle rollbacks are only refused until restart")
+	}
+	ps.ruleChannel = &ruleChannel{
+		fetcher: rulebundle.NewFetcher(cfg.URL, keys, timeout, store),
+		status:  RuleBundleStatus{URL: cfg.URL},
+		rules:   make(map[string]string),
+	}
+	ps.healthChecker.RegisterHealthCheck(ruleBundleCheck{ps})
+	ps.logger.Infof("Rule updates enabled from %s", cfg.URL)
+}
+
+// ruleUpdateRoutine checks the update channel on start and then every
+// interval
+func (ps *ProtectionService) ruleUpdateRoutine(ctx context.Context) {
+	interval := ps.config.Protection.RuleUpdates.Interval.Duration()
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ps.UpdateRules(ctx); err != nil {
+			ps.logger.Errorf("Rule update failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// UpdateRules checks the update channel now and applies a newer bundle
+func (ps *ProtectionService) UpdateRules(ctx context.Context) error {
+	ch := ps.ruleChannel
+	if ch == nil {
+		return fmt.Errorf("rule updates are disabled")
+	}
+
+	bundle, err := ch.fetcher.Fetch(ctx)
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.status.CheckedAt = time.Now()
+	ch.status.Error = ""
+	if err == nil && bundle != nil {
+		err = ps.applyBundle(ctx, ch, bundle)
+	}
+	if err != nil {
+		ch.status.Error = err.Error()
+	}
+	return err
+}
+
+// applyBundle stages the rules of a verified bundle: new rules start in
+// shadow, changed ones restart probation and dropped ones are removed.
+// ch.mu must be held.
+func (ps *ProtectionService) applyBundle(ctx context.Context, ch *ruleChannel, bundle *rulebundle.Bundle) error {
+	before := ch.status
+	wanted := make(map[string]string, len(bundle.Rules))
+	for _, rule := range bundle.Rules {
+		wanted[bundleRulePrefix+rule.ID] = rule.Pattern
+	}
+
+	var stale []string
+	for id, pattern := range ch.rules {
+		if wanted[id] != pattern {
+			stale = append(stale, id)
+			delete(ch.rules, id)
+		}
+	}
+	ps.requestFilter.RemoveRules(stale)
+
+	added := make(map[string]string)
+	for id, pattern := range wanted {
+		if _, exists := ch.rules[id]; !exists {
+			added[id] = pattern
+		}
+	}
+	errs := ps.requestFilter.AddRules(added)
+	for id, pattern := range added {
+		if err, skipped := errs[id]; skipped {
+			ps.logger.Warnf("Skipping bundle rule: %v", err)
+			continue
+		}
+		ch.rules[id] = pattern
+	}
+
+	ch.status.Version = bundle.Version
+	ch.status.Serial = bundle.Serial
+	ch.status.Published = bundle.Published
+	ch.status.Rules = len(ch.rules)
+	ch.status.AppliedAt = time.Now()
+	ps.audit(ctx, "rules.bundle", bundle.Version, before, ch.status)
+	ps.logger.Infof("Rule bundle %s applied: %d rules staged in shadow", bundle.Version, len(ch.rules))
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d rules of bundle %s could not be added", len(errs), bundle.Version)
+	}
+	return nil
+}
+
+// GetRuleBundle returns the rule bundle in force
+func (ps *ProtectionService) GetRuleBundle() (RuleBundleStatus, error) {
+	ch := ps.ruleChannel
+	if ch == nil {
+		return RuleBundleStatus{}, fmt.Errorf("rule updates are disabled")
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.status, nil
+}
+
+// ruleBundleCheck reports the bundle version in the health output and
+// degrades it while the update channel fails
+type ruleBundleCheck struct {
+	ps *ProtectionService
+}
+
+func (c ruleBundleCheck) Name() string { return "rule_bundle" }
+
+func (c ruleBundleCheck) IsCritical() bool { return false }
+
+func (c ruleBundleCheck) Check(ctx context.Context) error {
+	status, _ := c.ps.GetRuleBundle()
+	if status.Error != "" {
+		return fmt.Errorf("%s; running bundle %s", status.Error, versionOrNone(status.Version))
+	}
+	return nil
+}
+
+func (c ruleBundleCheck) Report() string {
+	status, _ := c.ps.GetRuleBundle()
+	return fmt.Sprintf("bundle %s (%d rules)", versionOrNone(status.Version), status.Rules)
+}
+
+func versionOrNone(version string) string {
+	if version == "" {
+		return "none"
+	}
+	return version
+}
@@ -0,0 +1,24 @@
+package ddos
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordScanResponse tells scanner detection what the upstream answered a
+// client with. A client that just turned out to be scanning for paths gets
+// a greylist strike, so scanners that keep at it are banned even while
+// their bot score stays under the auto-blacklist confidence. Whitelisted
+// clients, such as an operator's own scanner, are left alone.
+func (ps *ProtectionService) recordScanResponse(ctx context.Context, ip, path string, status int) {
+	if !ps.botnetDetector.RecordResponse(ip, path, status) || ps.ipManager.IsWhitelisted(ctx, ip) {
+		return
+	}
+	ps.logger.WithFields(logrus.Fields{
+		"ip":     ip,
+		"path":   path,
+		"status": status,
+	}).Warn("Path scanner detected")
+	ps.strike(ctx, ip, "path scanner detected")
+}
@@ -0,0 +1,126 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/slowconn"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initSlowRequests sets up detecting clients that hold connections open
+// with requests sent slowly
+func (ps *ProtectionService) initSlowRequests() {
+	cfg := ps.config.Protection.SlowRequests
+	if !cfg.Enabled {
+		return
+	}
+
+	ps.slowRequests = slowconn.NewTracker(slowconn.Config{
+		HeaderTimeout: cfg.HeaderTimeout.Duration(),
+		BodyDeadline:  ps.bodyDeadline(),
+		MinBodyRate:   int64(cfg.MinBodyRate),
+		MaxSlow:       cfg.MaxSlow,
+	})
+	ps.logger.Info("Slow request detection enabled")
+}
+
+// bodyDeadline returns the read deadline for request bodies, defaulting to
+// 2 minutes
+func (ps *ProtectionService) bodyDeadline() time.Duration {
+	if d := ps.config.Protection.SlowRequests.BodyDeadline.Duration(); d > 0 {
+		return d
+	}
+	return 2 * time.Minute
+}
+
+// InstrumentServer lets the protection service follow the connections of
+// server, so that clients holding many slow requests are detected. Headers
+// still unread after header_deadline have their connection closed, unless
+// the server already sets a ReadHeaderTimeout. Call it before serving.
+func (ps *ProtectionService) InstrumentServer(server *http.Server) {
+	if ps.slowRequests == nil {
+		return
+	}
+
+	connState := server.ConnState
+	server.ConnState = func(conn net.Conn, state http.ConnState) {
+		ps.slowRequests.ConnState(conn, state)
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = ps.config.Protection.SlowRequests.HeaderDeadline.Duration()
+		if server.ReadHeaderTimeout <= 0 {
+			server.ReadHeaderTimeout = 30 * time.Second
+		}
+	}
+}
+
+// beginSlowRequest starts tracking how fast a request's body arrives. The
+// returned function must be called when the request is done.
+func (ps *ProtectionService) beginSlowRequest(r *http.Request) func() {
+	if ps.slowRequests == nil {
+		return func() {}
+	}
+	return ps.slowRequests.Begin(r)
+}
+
+// slowRequestsRoutine periodically looks for clients holding too many slow
+// requests
+func (ps *ProtectionService) slowRequestsRoutine(ctx context.Context) {
+	interval := ps.config.Protection.SlowRequests.CheckInterval.Duration()
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, client := range ps.slowRequests.Check(now) {
+				ps.slowClientFound(ctx, client, now)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// slowClientFound scores a client holding too many slow requests as a bot,
+// closes its slow connections and blacklists it. Whitelisted clients are
+// left alone.
+func (ps *ProtectionService) slowClientFound(ctx context.Context, client slowconn.Client, now time.Time) {
+	if ps.ipManager.IsWhitelisted(ctx, client.IP) {
+		return
+	}
+
+	ps.botnetDetector.RecordSlowRequests(client.IP)
+	closed := ps.slowRequests.Close(client.IP, now)
+	ps.logger.WithFields(logrus.Fields{
+		"ip":      client.IP,
+		"headers": client.Headers,
+		"bodies":  client.Bodies,
+		"closed":  closed,
+	}).Warn("Client holding slow requests")
+
+	duration := ps.config.Protection.SlowRequests.BlacklistDuration.Duration()
+	if duration <= 0 || ps.ipManager.IsBlacklisted(ctx, client.IP) {
+		return
+	}
+	err := ps.ipManager.AutoBlacklistIP(ctx, client.IP, duration, blacklist.Origin{
+		Source: blacklist.SourceSlowRequests,
+		Reason: fmt.Sprintf("%d slow requests held open (%d sending headers, %d sending bodies)", client.Held(), client.Headers, client.Bodies),
+	})
+	if err != nil {
+		ps.logger.Errorf("Failed to blacklist slow request client %s: %v", client.IP, err)
+	}
+}
@@ -0,0 +1,76 @@
+package ddos
+
+import (
+	"net/http"
+	"time"
+
+	"ddos-protection/internal/slowdown"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// initSlowdown sets up progressive delays for medium-risk requests
+func (ps *ProtectionService) initSlowdown() {
+	cfg := ps.config.Protection.Slowdown
+	if !cfg.Enabled {
+		return
+	}
+
+	baseDelay := cfg.BaseDelayMs.Duration()
+	if baseDelay <= 0 {
+		baseDelay = 250 * time.Millisecond
+	}
+	maxDelay := cfg.MaxDelayMs.Duration()
+	if maxDelay <= 0 {
+		maxDelay = 8 * time.Second
+	}
+	window := cfg.Window.Duration()
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+
+	ps.slowdown = slowdown.NewThrottler(slowdown.Config{
+		BaseDelay:  baseDelay,
+		MaxDelay:   maxDelay,
+		Jitter:     cfg.Jitter,
+		Window:     window,
+		MaxPending: cfg.MaxPending,
+	})
+
+	ps.logger.Infof("Slowdown enabled (risk threshold: %d, delay: %v-%v)", cfg.RiskThreshold, baseDelay, maxDelay)
+}
+
+// applySlowdown holds a request for its verdict's delay. It reports whether
+// the request should be served; when the queue is full the client is told
+// to back off instead.
+func (ps *ProtectionService) applySlowdown(c *gin.Context, verdict pipeline.Verdict) bool {
+	err := ps.slowdown.Wait(c.Request.Context(), verdict.Delay)
+	if err == nil {
+		return true
+	}
+
+	if err == slowdown.ErrQueueFull {
+		ps.logger.WithField("ip", ps.getClientIP(c)).Warn("Request rejected - slowdown queue full")
+		ps.trafficMonitor.RecordMethod(c.Request.Method, "denied")
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many requests",
+			"code":  "SLOWDOWN_QUEUE_FULL",
+		})
+	}
+	// Otherwise the client went away while waiting
+	c.Abort()
+	return false
+}
+
+// logSlowdown records why a request is being delayed
+func (ps *ProtectionService) logSlowdown(info *pipeline.RequestInfo, delay time.Duration) {
+	ps.logger.WithFields(logrus.Fields{
+		"ip":         info.ClientIP,
+		"risk_score": info.RiskScore,
+		"offenses":   ps.slowdown.Offenses(info.ClientIP),
+		"delay":      delay.String(),
+	}).Info("Request delayed - medium risk")
+}
@@ -0,0 +1,785 @@
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/access"
+	"ddos-protection/internal/apikey"
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/crawler"
+	"ddos-protection/internal/dnsbl"
+	"ddos-protection/internal/filter"
+	"ddos-protection/internal/geo"
+	"ddos-protection/internal/headerfp"
+	"ddos-protection/internal/overrides"
+	"ddos-protection/internal/tlsfp"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Built-in stage names, in default evaluation order
+const (
+	StageForecast   = "forecast"
+	StageAccess     = "access"
+	StageBlacklist  = "blacklist"
+	StageAPIKey     = "api_key"
+	StageAgents     = "monitor_agent"
+	StageCrawler    = "crawler"
+	StageChallenge  = "challenge"
+	StageShed       = "load_shed"
+	StageGreylist   = "greylist"
+	StageDNSBL      = "dnsbl"
+	StageReputation = "reputation"
+	StageASN        = "asn"
+	StageGeo        = "geo"
+	StageMethod     = "method"
+	StageRateLimit  = "rate_limit"
+	StageFilter     = "filter"
+	StageBotnet     = "botnet"
+	StageSlowdown   = "slowdown"
+)
+
+// initPipeline builds the default protection pipeline
+func (ps *ProtectionService) initPipeline() {
+	ps.pipeline = pipeline.New(
+		pipeline.NewStage(StageForecast, ps.forecastStage),
+		pipeline.NewStage(StageAccess, ps.accessStage),
+		pipeline.NewStage(StageBlacklist, ps.blacklistStage),
+		pipeline.NewStage(StageAPIKey, ps.apiKeyStage),
+		pipeline.NewStage(StageAgents, ps.monitorAgentStage),
+		pipeline.NewStage(StageCrawler, ps.crawlerStage),
+		pipeline.NewStage(StageChallenge, ps.challengeStage),
+		pipeline.NewStage(StageShed, ps.shedStage),
+		pipeline.NewStage(StageGreylist, ps.greylistStage),
+		pipeline.NewStage(StageDNSBL, ps.dnsblStage),
+		pipeline.NewStage(StageReputation, ps.reputationStage),
+		pipeline.NewStage(StageASN, ps.asnStage),
+		pipeline.NewStage(StageGeo, ps.geoStage),
+		pipeline.NewStage(StageMethod, ps.methodStage),
+		pipeline.NewStage(StageRateLimit, ps.rateLimitStage),
+		pipeline.NewStage(StageFilter, ps.filterStage),
+		pipeline.NewStage(StageBotnet, ps.botnetStage),
+		pipeline.NewStage(StageSlowdown, ps.slowdownStage),
+	)
+
+	ps.logger.Infof("Protection pipeline initialized: %v", ps.pipeline.Stages())
+	ps.initStageTimeouts()
+}
+
+// initStageTimeouts applies the configured stage deadlines
+func (ps *ProtectionService) initStageTimeouts() {
+	cfg := ps.config.Protection.StageTimeouts
+	if !cfg.Enabled {
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, name := range ps.pipeline.Stages() {
+		known[name] = true
+	}
+	for name, stage := range cfg.Stages {
+		if !known[name] {
+			ps.logger.Warnf("Ignoring timeout for unknown stage %s", name)
+			continue
+		}
+		if stage.Timeout <= 0 {
+			continue
+		}
+		ps.pipeline.SetDeadline(name, pipeline.Deadline{
+			Timeout:    stage.Timeout.Duration(),
+			FailClosed: stage.FailClosed,
+		})
+		ps.logger.Infof("Stage %s limited to %s (fail closed: %v)", name, stage.Timeout, stage.FailClosed)
+	}
+}
+
+// forecastStage counts offered load before any blocking decision
+func (ps *ProtectionService) forecastStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.forecaster != nil {
+		ps.forecaster.Record(info.Tenant)
+	}
+	return pipeline.Next()
+}
+
+// blacklistStage lets whitelisted IPs through and rejects blacklisted ones.
+// The shared lists are checked first, then the tenant's isolated lists.
+func (ps *ProtectionService) blacklistStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if !ps.config.Protection.IPBlacklist.Enabled {
+		return pipeline.Next()
+	}
+
+	if verdict, decided := ps.checkLists(ctx, info, ps.ipManager); decided {
+		return verdict
+	}
+	if lists, exists := ps.tenantLists[info.Tenant]; exists {
+		if verdict, decided := ps.checkLists(ctx, info, lists); decided {
+			info.Values["list_tenant"] = info.Tenant
+			return verdict
+		}
+	}
+
+	return pipeline.Next()
+}
+
+// checkLists decides on a request whose IP is on one of the lists
+func (ps *ProtectionService) checkLists(ctx context.Context, info *pipeline.RequestInfo, lists *blacklist.IPManager) (pipeline.Verdict, bool) {
+	if entry, found := lists.LookupWhitelist(info.ClientIP); found {
+		info.Values["whitelisted"] = true
+		info.Values["whitelist_entry"] = entry
+		return pipeline.Verdict{Decision: pipeline.Allow, Reason: "whitelisted"}, true
+	}
+	entry, whitelisted, blacklisted := lists.Check(ctx, info.ClientIP)
+	if whitelisted {
+		info.Values["whitelisted"] = true
+		return pipeline.Verdict{Decision: pipeline.Allow, Reason: "whitelisted"}, true
+	}
+	if blacklisted {
+		info.Values["blacklist_entry"] = entry
+		ps.logger.WithFields(logrus.Fields{
+			"ip":     info.ClientIP,
+			"entry":  entry.Target,
+			"source": entry.Source,
+			"tenant": lists.Tenant(),
+		}).Warn("Request blocked - IP blacklisted")
+		verdict := pipeline.Reject(http.StatusForbidden, "BLOCKED_IP", "Access denied")
+		verdict.Reason = fmt.Sprintf("blacklisted by %s", entry.Source)
+		if appealURL := ps.appealURL(info.ClientIP, entry); appealURL != "" {
+			verdict.Fields = map[string]interface{}{"appeal_url": appealURL}
+		}
+		return verdict, true
+	}
+
+	return pipeline.Verdict{}, false
+}
+
+// apiKeyStage authenticates requests carrying an API key and enforces the
+// key's scopes, rate limit and quota. Requests to protected paths must carry
+// a key.
+func (ps *ProtectionService) apiKeyStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.apiKeys == nil {
+		return pipeline.Next()
+	}
+
+	token := info.Request.Header.Get(ps.apiKeyHeader())
+	if token == "" {
+		if ps.apiKeyRequired(info.Request.URL.Path) {
+			return pipeline.Reject(http.StatusUnauthorized, "API_KEY_REQUIRED", "API key required")
+		}
+		return pipeline.Next()
+	}
+
+	key, err := ps.apiKeys.Authorize(ctx, token, info.Request.Method, info.Request.URL.Path)
+	if err == nil {
+		info.Values["api_key"] = key
+		return pipeline.Next()
+	}
+
+	var verdict pipeline.Verdict
+	switch err {
+	case apikey.ErrScope:
+		verdict = pipeline.Reject(http.StatusForbidden, "API_KEY_SCOPE", "API key not valid for this request")
+	case apikey.ErrRateLimited:
+		verdict = pipeline.Reject(http.StatusTooManyRequests, "API_KEY_RATE_LIMITED", "API key rate limit exceeded")
+	case apikey.ErrQuotaExceeded:
+		verdict = pipeline.Reject(http.StatusTooManyRequests, "API_KEY_QUOTA_EXCEEDED", "API key quota exceeded")
+		if usage, ok := ps.apiKeys.Get(key.ID); ok && usage.Usage.QuotaResets != nil {
+			retry := int(time.Until(*usage.Usage.QuotaResets).Seconds()) + 1
+			verdict.Headers = http.Header{"Retry-After": []string{strconv.Itoa(retry)}}
+		}
+	case apikey.ErrRevoked:
+		verdict = pipeline.Reject(http.StatusUnauthorized, "API_KEY_REVOKED", "API key revoked")
+	case apikey.ErrExpired:
+		verdict = pipeline.Reject(http.StatusUnauthorized, "API_KEY_EXPIRED", "API key expired")
+	default:
+		// Guessing keys counts against the client like other abuse
+		ps.strike(ctx, info.ClientIP, "invalid API key")
+		return pipeline.Reject(http.StatusUnauthorized, "API_KEY_INVALID", "Invalid API key")
+	}
+	verdict.Reason = fmt.Sprintf("api key %s: %v", key.ID, err)
+	return verdict
+}
+
+// accessStage restricts sensitive paths to the clients their access rules
+// allow. It runs before whitelisted clients, monitor agents and crawlers are
+// let through, so a guarded path admits nobody the rules do not name.
+func (ps *ProtectionService) accessStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if len(ps.accessPolicy) == 0 {
+		return pipeline.Next()
+	}
+	rules := ps.accessPolicy.Covering(info.Request.URL.Path)
+	if len(rules) == 0 {
+		return pipeline.Next()
+	}
+
+	switch action, rule := access.Decide(rules, ps.accessClient(info, rules)); action {
+	case access.ActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":   info.ClientIP,
+			"path": info.Request.URL.Path,
+			"rule": rule,
+		}).Warn("Request blocked - not allowed by access rule")
+		verdict := pipeline.Reject(http.StatusForbidden, "ACCESS_DENIED", "Access denied")
+		verdict.Reason = "access rule " + rule
+		verdict.Fields = map[string]interface{}{"rule": rule}
+		return verdict
+	case access.ActionChallenge:
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "access:" + rule}
+	}
+
+	return pipeline.Next()
+}
+
+// monitorAgentStage lets verified monitoring agents through without rate
+// limiting or scoring, so probes neither get throttled nor skew bot detection
+func (ps *ProtectionService) monitorAgentStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	profile, ok := ps.monitorAgents.Match(info.ClientIP, info.Request.UserAgent())
+	if !ok {
+		return pipeline.Next()
+	}
+
+	info.Values["monitor_agent"] = profile
+	return pipeline.Verdict{Decision: pipeline.Allow, Reason: "monitor agent " + profile}
+}
+
+// crawlerStage lets verified search engine crawlers through without rate
+// limiting or scoring, and applies the impostor action to clients whose
+// crawler user agent does not check out
+func (ps *ProtectionService) crawlerStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.crawlerVerifier == nil {
+		return pipeline.Next()
+	}
+	profile := ps.crawlerVerifier.Claimed(info.Request.UserAgent())
+	if profile == nil {
+		return pipeline.Next()
+	}
+
+	cfg := ps.config.Protection.Crawlers
+	result, cached := ps.crawlerVerifier.Cached(info.ClientIP, profile)
+	if !cached {
+		if !cfg.Wait {
+			ps.crawlerVerifier.VerifyAsync(info.ClientIP, profile)
+			return pipeline.Next()
+		}
+
+		var err error
+		result, err = ps.crawlerVerifier.Verify(ctx, info.ClientIP, profile)
+		if err != nil {
+			ps.logger.Debugf("Crawler verification for %s incomplete: %v", info.ClientIP, err)
+			return pipeline.Next()
+		}
+	}
+
+	if result.Verified {
+		ps.vindicate(info.ClientIP)
+		info.Values["crawler"] = result.Crawler
+		return pipeline.Verdict{Decision: pipeline.Allow, Reason: "verified crawler " + result.Crawler}
+	}
+
+	switch crawler.Action(cfg.ImpostorAction) {
+	case crawler.ActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":      info.ClientIP,
+			"crawler": profile.Name,
+		}).Warn("Request blocked - fake crawler")
+		verdict := pipeline.Reject(http.StatusForbidden, "FAKE_CRAWLER", "Access denied")
+		verdict.Reason = "failed " + profile.Name + " verification"
+		return verdict
+	case crawler.ActionScore:
+		info.RiskScore += cfg.ImpostorRiskScore
+	default:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":      info.ClientIP,
+			"crawler": profile.Name,
+		}).Info("Client failed crawler verification")
+	}
+
+	return pipeline.Next()
+}
+
+// shedStage sheds routes whose SLO priority is below that of a threatened
+// SLO while under attack, so higher priority routes keep their latency.
+// Routes without an SLO have priority 0.
+func (ps *ProtectionService) shedStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	shedBelow, shedding := ps.shedPriority()
+	if !shedding {
+		return pipeline.Next()
+	}
+
+	priority := 0
+	if objective := ps.slaMonitor.Objective(info.Request.URL.Path); objective != nil {
+		priority = objective.Priority
+	}
+	if priority >= shedBelow {
+		return pipeline.Next()
+	}
+
+	verdict := pipeline.Reject(http.StatusServiceUnavailable, "LOAD_SHED", "Service temporarily unavailable")
+	verdict.Headers = http.Header{"Retry-After": []string{"30"}}
+	return verdict
+}
+
+// greylistStage challenges greylisted IPs or holds them to a strict rate
+// limit, striking them when they exceed it
+func (ps *ProtectionService) greylistStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	cfg := ps.config.Protection.IPBlacklist.Greylist
+	if !cfg.Enabled || !ps.ipManager.IsGreylisted(info.ClientIP) {
+		return pipeline.Next()
+	}
+	info.Values["greylisted"] = true
+
+	if cfg.Action == "challenge" {
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "greylist"}
+	}
+
+	if !ps.greyLimiter.Allow(ctx, info.ClientIP) {
+		ps.logger.WithField("ip", info.ClientIP).Warn("Request blocked - greylist rate limit exceeded")
+		ps.strike(ctx, info.ClientIP, "greylist rate limit exceeded")
+		return pipeline.Reject(http.StatusTooManyRequests, "RATE_LIMITED_GREYLIST", "Rate limit exceeded")
+	}
+
+	return pipeline.Next()
+}
+
+// dnsblStage checks first-seen IPs against DNS blocklists and applies the
+// configured action to listed ones
+func (ps *ProtectionService) dnsblStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.dnsblChecker == nil || blacklist.IsPrivateIP(info.ClientIP) {
+		return pipeline.Next()
+	}
+
+	cfg := ps.config.Protection.DNSBL
+	result, cached := ps.dnsblChecker.Cached(info.ClientIP)
+	if !cached {
+		if !cfg.Wait {
+			ps.dnsblChecker.CheckAsync(info.ClientIP)
+			return pipeline.Next()
+		}
+
+		var err error
+		result, err = ps.dnsblChecker.Check(ctx, info.ClientIP)
+		if err != nil {
+			ps.logger.Debugf("DNSBL lookup for %s incomplete: %v", info.ClientIP, err)
+		}
+	}
+
+	if !result.Listed {
+		return pipeline.Next()
+	}
+	info.Values["dnsbl_zones"] = result.Zones
+	if ps.reputation != nil {
+		for _, zone := range result.Zones {
+			ps.reputation.RecordFeed(info.ClientIP, "dnsbl:"+zone)
+		}
+	}
+
+	switch dnsbl.Action(cfg.Action) {
+	case dnsbl.ActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":    info.ClientIP,
+			"zones": result.Zones,
+		}).Warn("Request blocked - IP listed in DNSBL")
+		return pipeline.Reject(http.StatusForbidden, "BLOCKED_DNSBL", "Access denied")
+	case dnsbl.ActionScore:
+		info.RiskScore += cfg.RiskScore
+	default:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":    info.ClientIP,
+			"zones": result.Zones,
+		}).Info("IP listed in DNSBL")
+	}
+
+	return pipeline.Next()
+}
+
+// reputationStage rejects or challenges IPs whose reputation has fallen
+// below the configured thresholds
+func (ps *ProtectionService) reputationStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.reputation == nil {
+		return pipeline.Next()
+	}
+
+	cfg := ps.config.Protection.Reputation
+	score := ps.reputation.Score(info.ClientIP)
+	info.Values["reputation"] = score
+
+	if score < cfg.BlockBelow {
+		ps.logger.WithFields(logrus.Fields{
+			"ip":         info.ClientIP,
+			"reputation": score,
+		}).Warn("Request blocked - poor IP reputation")
+		verdict := pipeline.Reject(http.StatusForbidden, "BLOCKED_REPUTATION", "Access denied")
+		verdict.Fields = map[string]interface{}{"reputation": score}
+		return verdict
+	}
+	if score < cfg.ChallengeBelow {
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "reputation"}
+	}
+
+	return pipeline.Next()
+}
+
+// asnStage applies block and rate-limit rules for the client's AS
+func (ps *ProtectionService) asnStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	rule, asn, matched := ps.ipManager.MatchASNRule(ctx, info.ClientIP)
+	if !matched {
+		return pipeline.Next()
+	}
+	info.Values["asn"] = asn.Number
+
+	switch rule.Action {
+	case blacklist.ASNActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":  info.ClientIP,
+			"asn": asn.Number,
+		}).Warn("Request blocked - ASN blocked")
+		return pipeline.Reject(http.StatusForbidden, "BLOCKED_ASN", "Access denied")
+	case blacklist.ASNActionRateLimit:
+		if !ps.allowASN(ctx, rule) {
+			ps.logger.WithFields(logrus.Fields{
+				"ip":  info.ClientIP,
+				"asn": asn.Number,
+			}).Warn("Request blocked - ASN rate limit exceeded")
+			return pipeline.Reject(http.StatusTooManyRequests, "RATE_LIMITED_ASN", "Rate limit exceeded")
+		}
+	}
+
+	return pipeline.Next()
+}
+
+// geoStage applies the country policy, then any geofences covering the path
+func (ps *ProtectionService) geoStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.geoPolicy == nil {
+		return pipeline.Next()
+	}
+
+	country := ps.geoDB.Country(info.ClientIP)
+	info.Values["country"] = country
+
+	switch ps.geoPolicy.Decide(country) {
+	case geo.ActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":      info.ClientIP,
+			"country": country,
+		}).Warn("Request blocked - country blocked")
+		return pipeline.Reject(http.StatusForbidden, "BLOCKED_COUNTRY", "Access denied")
+	case geo.ActionChallenge:
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "country"}
+	}
+
+	if len(ps.geoFences) == 0 {
+		return pipeline.Next()
+	}
+
+	action, fence := ps.geoFences.Decide(country, info.Request.URL.Path, time.Now(), ps.IncidentActive())
+	switch action {
+	case geo.ActionBlock:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":      info.ClientIP,
+			"country": country,
+			"fence":   fence,
+		}).Warn("Request blocked - geofence")
+		verdict := pipeline.Reject(http.StatusForbidden, "BLOCKED_GEOFENCE", "Access denied")
+		verdict.Fields = map[string]interface{}{"fence": fence}
+		return verdict
+	case geo.ActionChallenge:
+		return pipeline.Verdict{Decision: pipeline.Challenge, Reason: "geofence:" + fence}
+	}
+
+	return pipeline.Next()
+}
+
+// methodStage caps methods that are cheap to flood, such as HEAD and
+// OPTIONS, and answers OPTIONS requests at the edge when configured
+func (ps *ProtectionService) methodStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	method := info.Request.Method
+	if limiter, exists := ps.methodLimiter(method); exists {
+		if !limiter.Allow(ctx, info.ClientIP) {
+			ps.logger.WithFields(logrus.Fields{
+				"ip":     info.ClientIP,
+				"method": method,
+			}).Warn("Request blocked - method rate limit exceeded")
+			ps.rejections.Record(method + ":" + info.ClientIP)
+			return pipeline.Reject(http.StatusTooManyRequests, "RATE_LIMITED_METHOD", "Rate limit exceeded")
+		}
+	}
+
+	cfg := ps.config.Protection.Methods
+	if method != http.MethodOptions || !cfg.AnswerOptions {
+		return pipeline.Next()
+	}
+
+	headers := http.Header{}
+	if len(cfg.AllowMethods) > 0 {
+		headers.Set("Allow", strings.Join(cfg.AllowMethods, ", "))
+	}
+	if info.Request.Header.Get("Origin") != "" && cfg.AllowOrigin != "" {
+		headers.Set("Access-Control-Allow-Origin", cfg.AllowOrigin)
+		headers.Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowMethods, ", "))
+		if len(cfg.AllowHeaders) > 0 {
+			headers.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowHeaders, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			headers.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Duration()/time.Second)))
+		}
+	}
+
+	return pipeline.Verdict{
+		Decision: pipeline.Respond,
+		Status:   http.StatusNoContent,
+		Headers:  headers,
+	}
+}
+
+// rateLimitStage applies the per-IP rate limit, auto-blacklisting abusers
+func (ps *ProtectionService) rateLimitStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	// A key's own rate limit replaces the per-IP limit
+	if key, ok := info.Values["api_key"].(apikey.Key); ok && key.RequestsPerMinute > 0 {
+		return pipeline.Next()
+	}
+
+	if verdict, capped := ps.checkBurst(ctx, info); capped {
+		return verdict
+	}
+
+	limiter, key := ps.rateLimiterFor(info)
+	if limiter.Allow(ctx, key) {
+		return pipeline.Next()
+	}
+
+	ps.logger.WithField("ip", info.ClientIP).Warn("Request blocked - rate limit exceeded")
+	ps.rejections.Record(key)
+	ps.strike(ctx, info.ClientIP, "rate limit exceeded")
+
+	// Check if we should auto-blacklist this IP
+	lists := ps.listsFor(info.Tenant)
+	if lists.ShouldAutoBlacklist(ctx, info.ClientIP, 100) {
+		if err := lists.AutoBlacklistIP(
+			ctx,
+			info.ClientIP,
+			ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
+			blacklist.Origin{Source: blacklist.SourceRateLimit, Reason: "rate limit exceeded"},
+		); err != nil {
+			ps.logger.Errorf("Failed to auto-blacklist IP %s: %v", info.ClientIP, err)
+		}
+	}
+
+	return pipeline.Reject(http.StatusTooManyRequests, "RATE_LIMITED", "Rate limit exceeded")
+}
+
+// filterStage runs the request filter
+func (ps *ProtectionService) filterStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	settings := ps.effective(info).Settings
+	if !settings.RequestFilter {
+		return pipeline.Next()
+	}
+
+	if info.RiskScore > 0 {
+		ctx = filter.WithRiskScore(ctx, info.RiskScore)
+	}
+	ctx = filter.WithClientKey(ctx, info.ClientIP)
+	ctx = filter.WithPolicy(ctx, filter.Policy{
+		InspectBody:   settings.InspectBody,
+		RuleSet:       settings.FilterRuleSet,
+		RiskThreshold: settings.FilterRiskThreshold,
+	})
+
+	filterResult := ps.requestFilter.FilterRequest(ctx, info.Request)
+	info.RiskScore = filterResult.RiskScore
+	if ps.reputation != nil {
+		ps.reputation.RecordRisk(info.ClientIP, filterResult.RiskScore)
+	}
+	ps.probeProxy(ctx, info.ClientIP, filterResult.RiskScore)
+	switch filterResult.Action {
+	case filter.ActionChallenge:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":         info.ClientIP,
+			"reason":     filterResult.Reason,
+			"risk_score": filterResult.RiskScore,
+			"categories": filterResult.Categories,
+		}).Info("Request challenged by filter")
+		return pipeline.Verdict{Decision: pipeline.Challenge, Code: "FILTERED", Reason: filterResult.Reason}
+	case filter.ActionTarpit:
+		// The slowdown stage holds it once the later stages had their say
+		info.Values["tarpit"] = filterResult.Reason
+	}
+	if !filterResult.Allowed {
+		ps.logger.WithFields(logrus.Fields{
+			"ip":         info.ClientIP,
+			"reason":     filterResult.Reason,
+			"risk_score": filterResult.RiskScore,
+			"categories": filterResult.Categories,
+		}).Warn("Request blocked - filter failed")
+		ps.strike(ctx, info.ClientIP, filterResult.Reason)
+
+		verdict := pipeline.Reject(http.StatusBadRequest, "FILTERED", "Request blocked")
+		verdict.Reason = filterResult.Reason
+		verdict.Fields = map[string]interface{}{"reason": filterResult.Reason}
+		return verdict
+	}
+
+	if filterResult.ShouldLog {
+		ps.logger.WithFields(logrus.Fields{
+			"ip":         info.ClientIP,
+			"reason":     filterResult.Reason,
+			"risk_score": filterResult.RiskScore,
+			"categories": filterResult.Categories,
+		}).Info("Request flagged by filter")
+	}
+
+	return pipeline.Next()
+}
+
+// botnetStage runs botnet detection, auto-blacklisting high-confidence hits.
+// Clients holding a pass from a solved challenge skip it.
+func (ps *ProtectionService) botnetStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.hasPass(info) {
+		info.Values["challenge_pass"] = true
+		return pipeline.Next()
+	}
+
+	var tlsFingerprint string
+	if fp := tlsfp.FromContext(info.Request.Context()); fp != nil {
+		tlsFingerprint = fp.JA4
+	}
+	var headerFingerprint string
+	if fp := headerfp.ForRequest(info.Request); fp != nil {
+		headerFingerprint = fp.Hash
+	}
+	clientID, _ := info.Values["client_id"].(string)
+	cookieless := ps.clientIDs != nil && clientID == ""
+	identity, _ := info.Values["identity"].(string)
+
+	startTime := time.Now()
+	botnetResult := ps.botnetAnalyzer().AnalyzeRequest(ctx, botnet.Request{
+		IP:                info.ClientIP,
+		UserAgent:         info.Request.UserAgent(),
+		Host:              info.Request.Host,
+		Path:              info.Request.URL.Path,
+		Query:             info.Request.URL.RawQuery,
+		Referer:           info.Request.Referer(),
+		TLSFingerprint:    tlsFingerprint,
+		HeaderFingerprint: headerFingerprint,
+		ClientID:          clientID,
+		Cookieless:        cookieless,
+		Identity:          identity,
+		Header:            info.Request.Header,
+		ResponseTime:      time.Since(startTime),
+		Secure:            info.Request.TLS != nil,
+		Accept:            info.Request.Header.Get("Accept"),
+		AcceptLanguage:    info.Request.Header.Get("Accept-Language"),
+		AcceptEncoding:    info.Request.Header.Get("Accept-Encoding"),
+	})
+	botnetResult = ps.applyCompositeSignals(info, botnetResult)
+	ps.exportAnalysisRecord(info.Request.URL.Path, info.Tenant, botnetResult)
+
+	if ps.reputation != nil && botnetResult.Confidence > 0 {
+		ps.reputation.RecordBotnet(info.ClientIP, botnetResult.Confidence)
+	}
+
+	// An overridden detection threshold decides on the detector's confidence
+	if threshold := ps.effective(info).Settings.DetectionThreshold; threshold != ps.botnetThreshold() {
+		result := *botnetResult
+		result.IsBotnet = result.Confidence >= threshold
+		botnetResult = &result
+	}
+
+	if botnetResult.IsBotnet {
+		ps.probeProxy(ctx, info.ClientIP, info.RiskScore+botnetResult.RiskScore)
+	}
+
+	if ps.botPolicy != nil {
+		return ps.applyBotPolicy(ctx, info, botnetResult)
+	}
+
+	if !botnetResult.IsBotnet {
+		return pipeline.Next()
+	}
+	info.RiskScore += botnetResult.RiskScore
+	switch ps.botnetAction(info, botnetResult.Confidence) {
+	case overrides.ActionLog:
+		ps.logger.WithFields(logrus.Fields{
+			"ip":         info.ClientIP,
+			"path":       info.Request.URL.Path,
+			"confidence": botnetResult.Confidence,
+			"indicators": botnetResult.Indicators,
+		}).Info("Botnet detected - logged only on this route")
+		return pipeline.Next()
+	case overrides.ActionChallenge:
+		return ps.challengeBot(info)
+	case overrides.ActionBlock:
+		return ps.blockBot(ctx, info, botnetResult, false)
+	default:
+		return ps.blockBot(ctx, info, botnetResult, true)
+	}
+}
+
+// blockBot rejects a detected bot, blacklisting it if asked to and
+// recording a greylist strike otherwise
+func (ps *ProtectionService) blockBot(ctx context.Context, info *pipeline.RequestInfo, botnetResult *botnet.BotnetAnalysis, blacklistIP bool) pipeline.Verdict {
+	ps.logger.WithFields(logrus.Fields{
+		"ip":         info.ClientIP,
+		"confidence": botnetResult.Confidence,
+		"indicators": botnetResult.Indicators,
+		"risk_score": botnetResult.RiskScore,
+	}).Warn("Request blocked - botnet detected")
+
+	// Auto-blacklist botnet IPs with high confidence
+	if blacklistIP {
+		if err := ps.listsFor(info.Tenant).AutoBlacklistIP(
+			ctx,
+			info.ClientIP,
+			ps.config.Protection.IPBlacklist.BlacklistDuration.Duration(),
+			blacklist.Origin{
+				Source: blacklist.SourceBotnet,
+				Reason: fmt.Sprintf("botnet detected (confidence %.2f): %s", botnetResult.Confidence, strings.Join(botnetResult.Indicators, ", ")),
+			},
+		); err != nil {
+			ps.logger.Errorf("Failed to auto-blacklist botnet IP %s: %v", info.ClientIP, err)
+		} else {
+			ps.logger.Infof("Auto-blacklisted botnet IP %s (confidence: %.2f)", info.ClientIP, botnetResult.Confidence)
+		}
+	} else {
+		ps.strike(ctx, info.ClientIP, "botnet detected")
+	}
+
+	verdict := pipeline.Reject(http.StatusForbidden, "BOTNET_DETECTED", "Access denied - botnet detected")
+	verdict.Fields = map[string]interface{}{
+		"confidence": botnetResult.Confidence,
+		"indicators": botnetResult.Indicators,
+	}
+	return verdict
+}
+
+// slowdownStage delays requests that built up a medium risk score in earlier
+// stages without being blocked, or that a filter rule tarpitted, escalating
+// with each offense
+func (ps *ProtectionService) slowdownStage(ctx context.Context, info *pipeline.RequestInfo) pipeline.Verdict {
+	if ps.slowdown == nil {
+		return pipeline.Next()
+	}
+	if reason, ok := info.Values["tarpit"].(string); ok {
+		delay := ps.slowdown.Delay(info.ClientIP)
+		ps.logSlowdown(info, delay)
+		return pipeline.Verdict{Decision: pipeline.Slowdown, Code: "TARPIT", Delay: delay, Reason: reason}
+	}
+	threshold := ps.config.Protection.Slowdown.RiskThreshold
+	if threshold <= 0 || info.RiskScore < threshold {
+		return pipeline.Next()
+	}
+
+	delay := ps.slowdown.Delay(info.ClientIP)
+	ps.logSlowdown(info, delay)
+	return pipeline.Verdict{
+		Decision: pipeline.Slowdown,
+		Code:     "SLOWDOWN",
+		Delay:    delay,
+		Reason:   fmt.Sprintf("risk score %d", info.RiskScore),
+	}
+}
@@ -0,0 +1,149 @@
+package ddos
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"time"
+)
+
+// Public status values
+const (
+	StatusOperational = "operational"
+	StatusMitigating  = "mitigating_attack"
+	StatusDegraded    = "degraded"
+)
+
+// Mitigation levels
+const (
+	MitigationNone     = "none"
+	MitigationElevated = "elevated"
+	MitigationHigh     = "high"
+)
+
+// defaultStatusCacheTTL bounds how often the public status is recomputed,
+// so an unauthenticated page cannot be used to hammer the health checks
+const defaultStatusCacheTTL = 10 * time.Second
+
+var statusDescriptions = map[string]string{
+	StatusOperational: "All systems operational",
+	StatusMitigating:  "We are mitigating an attack. Some requests may be slowed or challenged.",
+	StatusDegraded:    "Some services are experiencing degraded performance.",
+}
+
+// PublicStatus is the sanitized service status shown on the public status
+// page. It never includes IPs, rules, counts or other internals.
+type PublicStatus struct {
+	Title           string    `json:"title"`
+	Status          string    `json:"status"`
+	Description     string    `json:"description"`
+	MitigationLevel string    `json:"mitigation_level"`
+	Since           time.Time `json:"since"`
+	UptimeSeconds   int64     `json:"uptime_seconds"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// GetPublicStatus returns the sanitized status for the public status page,
+// recomputing it at most once per cache interval
+func (ps *ProtectionService) GetPublicStatus(ctx context.Context) PublicStatus {
+	ttl := ps.config.StatusPage.CacheSeconds.Duration()
+	if ttl <= 0 {
+		ttl = defaultStatusCacheTTL
+	}
+
+	ps.statusMu.Lock()
+	defer ps.statusMu.Unlock()
+
+	now := time.Now()
+	if !ps.publicStatus.UpdatedAt.IsZero() && now.Sub(ps.publicStatus.UpdatedAt) < ttl {
+		return ps.publicStatus
+	}
+
+	status, level := ps.assessPublicStatus(ctx)
+	since := ps.publicStatus.Since
+	if status != ps.publicStatus.Status || since.IsZero() {
+		since = now
+	}
+
+	title := ps.config.StatusPage.Title
+	if title == "" {
+		title = "Service Status"
+	}
+
+	ps.publicStatus = PublicStatus{
+		Title:           title,
+		Status:          status,
+		Description:     statusDescriptions[status],
+		MitigationLevel: level,
+		Since:           since,
+		UptimeSeconds:   int64(now.Sub(ps.startTime).Seconds()),
+		UpdatedAt:       now,
+	}
+	return ps.publicStatus
+}
+
+// assessPublicStatus maps health, SLO and mitigation state onto the public
+// status and mitigation level
+func (ps *ProtectionService) assessPublicStatus(ctx context.Context) (string, string) {
+	health := ps.GetHealthStatus(ctx).Status
+	threatened := false
+	if ps.slaMonitor != nil {
+		threatened, _ = ps.slaMonitor.Threatened()
+	}
+
+	level := MitigationNone
+	if ps.underAttack() {
+		level = MitigationElevated
+		if _, shedding := ps.shedPriority(); shedding || ps.GetPreset() == "under-attack" {
+			level = MitigationHigh
+		}
+	}
+
+	switch {
+	case health == "critical":
+		return StatusDegraded, level
+	case level != MitigationNone:
+		return StatusMitigating, level
+	case health == "degraded" || threatened:
+		return StatusDegraded, level
+	default:
+		return StatusOperational, level
+	}
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40rem; margin: 3rem auto; color: #222; }
+.banner { padding: 1rem 1.5rem; border-radius: 6px; color: #fff; }
+.operational { background: #2e7d32; }
+.mitigating_attack { background: #ef6c00; }
+.degraded { background: #c62828; }
+dl { display: grid; grid-template-columns: max-content auto; gap: .5rem 1.5rem; }
+dt { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{.Description}}</div>
+<dl>
+<dt>Mitigation level</dt><dd>{{.MitigationLevel}}</dd>
+<dt>Current status since</dt><dd>{{.Since.UTC.Format "2006-01-02 15:04 MST"}}</dd>
+<dt>Uptime</dt><dd>{{.Uptime}}</dd>
+<dt>Last updated</dt><dd>{{.UpdatedAt.UTC.Format "2006-01-02 15:04:05 MST"}}</dd>
+</dl>
+</body>
+</html>
+`))
+
+// RenderStatusPage writes the public status as an HTML page
+func RenderStatusPage(w io.Writer, status PublicStatus) error {
+	return statusPageTemplate.Execute(w, struct {
+		PublicStatus
+		Uptime time.Duration
+	}{status, time.Duration(status.UptimeSeconds) * time.Second})
+}
@@ -0,0 +1,69 @@
+package ddos
+
+import (
+	"context"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initSubnetEscalation bans whole subnets once many of their clients are
+// auto-banned
+func (ps *ProtectionService) initSubnetEscalation() {
+	cfg := ps.config.Protection.IPBlacklist.SubnetEscalation
+	if !cfg.Enabled {
+		return
+	}
+
+	escalation := blacklist.SubnetEscalation{
+		IPv4Prefix: cfg.IPv4Prefix,
+		IPv6Prefix: cfg.IPv6Prefix,
+		Threshold:  cfg.Threshold,
+		Window:     cfg.Window.Duration(),
+		Duration:   cfg.Duration.Duration(),
+	}
+	if escalation.IPv4Prefix <= 0 || escalation.IPv4Prefix > 32 {
+		escalation.IPv4Prefix = 24
+	}
+	if escalation.IPv6Prefix <= 0 || escalation.IPv6Prefix > 128 {
+		escalation.IPv6Prefix = 48
+	}
+	if escalation.Threshold <= 0 {
+		escalation.Threshold = 10
+	}
+	if escalation.Window <= 0 {
+		escalation.Window = 10 * time.Minute
+	}
+	if escalation.Duration <= 0 {
+		escalation.Duration = ps.config.Protection.IPBlacklist.BlacklistDuration.Duration()
+	}
+	if escalation.Duration <= 0 {
+		escalation.Duration = time.Hour
+	}
+
+	for _, lists := range ps.allLists() {
+		lists := lists
+		lists.SetSubnetEscalation(escalation)
+		lists.OnBan(func(event blacklist.BanEvent) { ps.logSubnetBan(lists, event) })
+	}
+	ps.logger.Infof("Subnet escalation enabled (/%d and /%d after %d bans in %s)",
+		escalation.IPv4Prefix, escalation.IPv6Prefix, escalation.Threshold, escalation.Window)
+}
+
+// logSubnetBan logs and audits subnet bans made on this instance
+func (ps *ProtectionService) logSubnetBan(lists *blacklist.IPManager, event blacklist.BanEvent) {
+	if event.Kind != blacklist.BanAdded || !event.Local || event.Entry.Source != blacklist.SourceSubnet {
+		return
+	}
+
+	entry := event.Entry
+	ps.logger.WithFields(logrus.Fields{
+		"subnet":  entry.Target,
+		"tenant":  lists.Tenant(),
+		"reason":  entry.Reason,
+		"expires": entry.Expires,
+	}).Warn("Subnet auto-blacklisted")
+	ps.audit(context.Background(), "blacklist.subnet", listTarget(lists, entry.Target), nil, entry)
+}
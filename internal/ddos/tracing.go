@@ -0,0 +1,97 @@
+package ddos
+
+import (
+	"time"
+
+	"ddos-protection/internal/tracing"
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// headerTraceResponse returns the trace of a rejected request to the client,
+// so a blocked user can quote it when reporting a false positive
+const headerTraceResponse = "traceresponse"
+
+// initTracing initializes trace sampling
+func (ps *ProtectionService) initTracing() {
+	cfg := ps.config.Tracing
+	if !cfg.Enabled {
+		return
+	}
+
+	ps.sampler = tracing.NewSampler(cfg.SampleRate, cfg.RiskThreshold)
+	ps.logger.Infof("Tracing enabled (sample rate: %.3f, risk threshold: %d)", cfg.SampleRate, cfg.RiskThreshold)
+}
+
+// startTrace continues or starts the trace of a request. It returns nil when
+// tracing is disabled.
+func (ps *ProtectionService) startTrace(c *gin.Context) *tracing.Context {
+	if ps.sampler == nil {
+		return nil
+	}
+
+	trace := ps.sampler.Start(c.Request.Header)
+	return &trace
+}
+
+// decideTrace applies the pipeline verdict to the trace: rejected and
+// high-risk requests are always sampled with a keep priority. The trace
+// context is then propagated to the handler, and returned to clients that
+// were turned away.
+func (ps *ProtectionService) decideTrace(c *gin.Context, trace *tracing.Context, info *pipeline.RequestInfo, verdict pipeline.Verdict) {
+	if trace == nil {
+		return
+	}
+
+	rejected := verdict.Decision == pipeline.Deny || verdict.Decision == pipeline.Challenge
+	ps.sampler.Prioritize(trace, rejected, info.RiskScore)
+
+	trace.Inject(c.Request.Header)
+	c.Request = c.Request.WithContext(tracing.WithContext(c.Request.Context(), *trace))
+	if rejected {
+		c.Header(headerTraceResponse, trace.TraceParent())
+	}
+}
+
+// traceID returns the ID of a sampled trace, or ""
+func traceID(trace *tracing.Context) string {
+	if trace == nil || !trace.Sampled {
+		return ""
+	}
+	return trace.TraceIDString()
+}
+
+// finishTrace records the protection layer's span of a sampled request
+func (ps *ProtectionService) finishTrace(c *gin.Context, trace *tracing.Context, info *pipeline.RequestInfo, stage string, verdict pipeline.Verdict) {
+	if trace == nil || !trace.Sampled || !ps.config.Tracing.LogSpans {
+		return
+	}
+
+	fields := logrus.Fields{
+		"trace_id":    trace.TraceIDString(),
+		"span_id":     trace.SpanIDString(),
+		"span":        "ddos.protection",
+		"sampled_by":  trace.Reason,
+		"priority":    trace.Priority,
+		"ip":          info.ClientIP,
+		"method":      info.Request.Method,
+		"path":        info.Request.URL.Path,
+		"decision":    verdict.Decision.String(),
+		"risk_score":  info.RiskScore,
+		"status":      c.Writer.Status(),
+		"duration_ms": float64(time.Since(info.Start).Microseconds()) / 1000,
+	}
+	if parent := trace.ParentIDString(); parent != "" {
+		fields["parent_id"] = parent
+	}
+	if stage != "" {
+		fields["stage"] = stage
+	}
+	if verdict.Code != "" {
+		fields["code"] = verdict.Code
+	}
+
+	ps.logger.WithFields(fields).Info("Trace span")
+}
@@ -0,0 +1,108 @@
+package ddos
+
+import (
+	"net/netip"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/verdictcache"
+	"ddos-protection/pkg/pipeline"
+)
+
+// initVerdictCache sets up caching of list verdicts. The cache is dropped
+// from whenever a list changes, locally or on another instance.
+func (ps *ProtectionService) initVerdictCache() {
+	cfg := ps.config.Protection.VerdictCache
+	if !cfg.Enabled || !ps.config.Protection.IPBlacklist.Enabled {
+		return
+	}
+
+	maxTTL := cfg.MaxTTL.Duration()
+	if maxTTL <= 0 {
+		maxTTL = time.Minute
+	}
+	capacity := cfg.Capacity
+	if capacity <= 0 {
+		capacity = 100000
+	}
+
+	ps.verdictCache = verdictcache.New(maxTTL, capacity)
+	ps.ipManager.OnChange(ps.verdictCache.Invalidate)
+
+	ps.logger.Infof("Verdict cache enabled (max TTL: %v, capacity: %d)", maxTTL, capacity)
+}
+
+// lookupVerdict answers a request from the verdict cache. Load is still
+// counted for forecasting and blacklist hits are still recorded, but no
+// stage runs. Whitelisted clients on paths guarded by access rules are
+// evaluated in full, since those rules apply to them too.
+func (ps *ProtectionService) lookupVerdict(info *pipeline.RequestInfo) (pipeline.Verdict, string, bool) {
+	if ps.verdictCache == nil {
+		return pipeline.Verdict{}, "", false
+	}
+
+	entry, found := ps.verdictCache.Get(info.ClientIP, info.Start)
+	if !found {
+		return pipeline.Verdict{}, "", false
+	}
+	if entry.Verdict.Decision == pipeline.Allow && len(ps.accessPolicy.Covering(info.Request.URL.Path)) > 0 {
+		return pipeline.Verdict{}, "", false
+	}
+
+	if ps.forecaster != nil {
+		ps.forecaster.Record(info.Tenant)
+	}
+	switch entry.Verdict.Decision {
+	case pipeline.Deny:
+		ps.ipManager.Hit(entry.Target)
+	case pipeline.Allow:
+		info.Values["whitelisted"] = true
+	}
+	return entry.Verdict, entry.Stage, true
+}
+
+// storeVerdict caches a list verdict that cannot change until the list
+// does. Feed entries are left out because a kill switch can disable their
+// feed without touching the list, as are blacklist entries about to expire.
+func (ps *ProtectionService) storeVerdict(info *pipeline.RequestInfo, stage string, verdict pipeline.Verdict) {
+	if ps.verdictCache == nil || stage != StageBlacklist {
+		return
+	}
+	// Cached verdicts apply to every tenant, so only the shared lists' are
+	if _, isolated := info.Values["list_tenant"]; isolated {
+		return
+	}
+
+	// Lists key IPv4-mapped addresses by their IPv4 form, so invalidations
+	// would miss them
+	if addr, err := netip.ParseAddr(info.ClientIP); err != nil || addr.Is4In6() {
+		return
+	}
+
+	entry := verdictcache.Entry{Stage: stage, Verdict: verdict}
+	switch verdict.Decision {
+	case pipeline.Deny:
+		listed, ok := info.Values["blacklist_entry"].(blacklist.Entry)
+		if !ok || listed.Source == blacklist.SourceFeed {
+			return
+		}
+		minRemaining := ps.config.Protection.VerdictCache.MinRemaining.Duration()
+		if listed.Expires.Sub(info.Start) < minRemaining {
+			return
+		}
+		entry.Target, entry.Expires = listed.Target, listed.Expires
+	case pipeline.Allow:
+		listed, ok := info.Values["whitelist_entry"].(blacklist.WhitelistEntry)
+		if !ok {
+			return
+		}
+		entry.Target = listed.IP
+		if listed.Expires != nil {
+			entry.Expires = *listed.Expires
+		}
+	default:
+		return
+	}
+
+	ps.verdictCache.Put(info.ClientIP, entry, info.Start)
+}
@@ -0,0 +1,100 @@
+package ddos
+
+import (
+	"context"
+
+	"ddos-protection/internal/audit"
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/webhook"
+)
+
+// initWebhooks sets up outbound webhooks on list changes made on this
+// instance and on expiry of its bans. Changes applied from other instances
+// are not sent again.
+func (ps *ProtectionService) initWebhooks() {
+	cfg := ps.config.Webhooks
+	if !cfg.Enabled || len(cfg.Endpoints) == 0 {
+		return
+	}
+
+	endpoints := make([]webhook.Endpoint, 0, len(cfg.Endpoints))
+	for _, e := range cfg.Endpoints {
+		endpoints = append(endpoints, webhook.Endpoint{
+			Name:   e.Name,
+			URL:    e.URL,
+			Secret: e.Secret,
+			Format: e.Format,
+			Events: e.Events,
+		})
+	}
+
+	dispatcher, err := webhook.NewDispatcher(endpoints, webhook.Options{
+		Timeout:     cfg.Timeout.Duration(),
+		MaxAttempts: cfg.MaxAttempts,
+		Backoff:     cfg.BackoffMs.Duration(),
+		QueueSize:   cfg.QueueSize,
+	})
+	if err != nil {
+		ps.logger.Errorf("Failed to set up webhooks: %v", err)
+		return
+	}
+	ps.webhooks = dispatcher
+	ps.ipManager.OnEvent(ps.sendWebhook)
+	ps.ipManager.OnBan(ps.sendExpiryWebhook)
+
+	ps.logger.Infof("Webhooks enabled for %d endpoints", len(endpoints))
+}
+
+// sendWebhook queues a webhook for a list change
+func (ps *ProtectionService) sendWebhook(ctx context.Context, event blacklist.ListEvent) {
+	e := webhook.Event{
+		Target: event.Target,
+		Actor:  audit.ActorFrom(ctx),
+		Node:   event.Node,
+	}
+
+	switch event.Op {
+	case blacklist.OpBlacklistAdd:
+		e.Type = webhook.EventBlacklistAuto
+		if entry := event.Entry; entry != nil {
+			switch entry.Source {
+			case blacklist.SourceManual, blacklist.SourceFeed:
+				e.Type = webhook.EventBlacklistManual
+			}
+			e.Source, e.Feed, e.Reason = string(entry.Source), entry.Feed, entry.Reason
+			e.Expires = &entry.Expires
+		}
+	case blacklist.OpBlacklistRemove:
+		e.Type = webhook.EventBlacklistRemove
+	case blacklist.OpWhitelistAdd:
+		e.Type = webhook.EventWhitelistAdd
+		if !event.Expires.IsZero() {
+			expires := event.Expires
+			e.Expires = &expires
+		}
+	case blacklist.OpWhitelistRemove:
+		e.Type = webhook.EventWhitelistRemove
+	default:
+		return
+	}
+
+	ps.webhooks.Send(e)
+}
+
+// sendExpiryWebhook queues a webhook for a ban made on this instance that
+// ran its course
+func (ps *ProtectionService) sendExpiryWebhook(event blacklist.BanEvent) {
+	if event.Kind != blacklist.BanExpired || !event.Local {
+		return
+	}
+
+	entry := event.Entry
+	ps.webhooks.Send(webhook.Event{
+		Type:    webhook.EventBlacklistExpire,
+		Target:  entry.Target,
+		Source:  string(entry.Source),
+		Feed:    entry.Feed,
+		Reason:  entry.Reason,
+		Expires: &entry.Expires,
+	})
+}
@@ -0,0 +1,199 @@
+package dnsbl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/ttlcache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Action is what to do with a listed client
+type Action string
+
+const (
+	ActionBlock Action = "block"
+	ActionScore Action = "score"
+	ActionLog   Action = "log"
+)
+
+// maxCacheEntries bounds the result cache; expired entries are dropped
+// first, then the oldest
+const maxCacheEntries = 100000
+
+var lookupCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_dnsbl_lookups_total",
+	Help: "DNSBL lookups by zone and result",
+}, []string{"zone", "result"})
+
+// Resolver is the subset of net.Resolver used for lookups
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Result is the outcome of checking an IP against every zone
+type Result struct {
+	Listed    bool      `json:"listed"`
+	Zones     []string  `json:"zones,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker looks up client IPs in DNS-based blocklists and caches the answers
+type Checker struct {
+	zones    []string
+	resolver Resolver
+	timeout  time.Duration
+	cache    *ttlcache.Cache[Result]
+	inflight map[string]bool
+	mu       sync.Mutex
+}
+
+// NewChecker creates a checker for the given zones, e.g. "zen.spamhaus.org".
+// A nil resolver uses the system resolver.
+func NewChecker(zones []string, resolver Resolver, timeout, cacheTTL time.Duration) *Checker {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Checker{
+		zones:    zones,
+		resolver: resolver,
+		timeout:  timeout,
+		cache:    ttlcache.New[Result](cacheTTL, maxCacheEntries),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Cached returns the cached result for ip, if any
+func (c *Checker) Cached(ip string) (Result, bool) {
+	return c.cache.Get(ip, time.Now())
+}
+
+// Check returns the result for ip, querying every zone when it is not cached
+func (c *Checker) Check(ctx context.Context, ip string) (Result, error) {
+	if result, ok := c.Cached(ip); ok {
+		return result, nil
+	}
+
+	name, err := ReverseName(ip)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result := Result{CheckedAt: time.Now()}
+	var lastErr error
+	answered := 0
+	for _, zone := range c.zones {
+		listed, err := c.query(ctx, name+"."+zone)
+		if err != nil {
+			lookupCounter.WithLabelValues(zone, "error").Inc()
+			lastErr = err
+			continue
+		}
+		answered++
+		if listed {
+			lookupCounter.WithLabelValues(zone, "listed").Inc()
+			result.Listed = true
+			result.Zones = append(result.Zones, zone)
+		} else {
+			lookupCounter.WithLabelValues(zone, "clean").Inc()
+		}
+	}
+
+	// Only cache when at least one zone answered, so transient resolver
+	// failures are retried
+	if answered > 0 {
+		c.store(ip, result)
+	}
+	return result, lastErr
+}
+
+// CheckAsync starts a background lookup for ip unless one is already running
+// or a result is cached
+func (c *Checker) CheckAsync(ip string) {
+	c.mu.Lock()
+	if c.inflight[ip] {
+		c.mu.Unlock()
+		return
+	}
+	if _, cached := c.cache.Get(ip, time.Now()); cached {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[ip] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, ip)
+			c.mu.Unlock()
+		}()
+		c.Check(context.Background(), ip)
+	}()
+}
+
+// query reports whether name resolves to a listing. Zones answer NXDOMAIN
+// for clean addresses and 127.0.0.0/8 for listed ones; 127.255.255.0/24 is
+// reserved for errors such as refused queries.
+func (c *Checker) query(ctx context.Context, name string) (bool, error) {
+	addrs, err := c.resolver.LookupHost(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is4() {
+			continue
+		}
+		b := addr.As4()
+		if b[0] == 127 && !(b[1] == 255 && b[2] == 255) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Checker) store(ip string, result Result) {
+	c.cache.Set(ip, result, time.Now())
+}
+
+// CleanupExpired drops expired cache entries
+func (c *Checker) CleanupExpired() {
+	c.cache.Cleanup(time.Now())
+}
+
+// ReverseName returns the DNSBL query label for ip: reversed octets for IPv4
+// and reversed nibbles for IPv6
+func ReverseName(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address: %s", ip)
+	}
+	addr = addr.Unmap()
+
+	if addr.Is4() {
+		b := addr.As4()
+		return fmt.Sprintf("%d.%d.%d.%d", b[3], b[2], b[1], b[0]), nil
+	}
+
+	b := addr.As16()
+	labels := make([]string, 0, 32)
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", b[i]&0x0f), fmt.Sprintf("%x", b[i]>>4))
+	}
+	return strings.Join(labels, "."), nil
+}
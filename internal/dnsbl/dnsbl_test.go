@@ -0,0 +1,58 @@
+package dnsbl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := f[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"192.0.2.1", "1.2.0.192"},
+		{"::ffff:192.0.2.1", "1.2.0.192"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		got, err := ReverseName(tt.ip)
+		if err != nil || got != tt.expected {
+			t.Errorf("ReverseName(%q) = %q, %v; want %q", tt.ip, got, err, tt.expected)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	resolver := fakeResolver{
+		"2.0.0.127.bl.example":    {"127.0.0.2"},
+		"3.0.0.127.bl.example":    {"127.255.255.254"},
+		"2.0.0.127.other.example": {"127.0.0.4"},
+	}
+	c := NewChecker([]string{"bl.example", "other.example"}, resolver, time.Second, time.Hour)
+
+	result, err := c.Check(context.Background(), "127.0.0.2")
+	if err != nil || !result.Listed || len(result.Zones) != 2 {
+		t.Errorf("Check(127.0.0.2) = %+v, %v; want listed in both zones", result, err)
+	}
+
+	result, err = c.Check(context.Background(), "127.0.0.3")
+	if err != nil || result.Listed {
+		t.Errorf("Check(127.0.0.3) = %+v, %v; error codes must not count as listed", result, err)
+	}
+
+	if _, ok := c.Cached("127.0.0.2"); !ok {
+		t.Error("Expected result to be cached")
+	}
+}
@@ -0,0 +1,251 @@
+package edge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudflareAPI is the default Cloudflare API base URL
+const CloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareDuplicate is the error code for a rule that already exists
+const cloudflareDuplicate = 10009
+
+// Cloudflare mirrors bans into IP Access Rules of a zone, or of an account
+// when no zone is set. Rules are recognised by Note in their notes.
+type Cloudflare struct {
+	base  string
+	token string
+	mode  string
+	note  string
+	http  *http.Client
+}
+
+// CloudflareConfig configures a Cloudflare provider
+type CloudflareConfig struct {
+	BaseURL   string
+	APIToken  string
+	ZoneID    string
+	AccountID string
+	// Mode is the rule action: block, challenge, js_challenge or
+	// managed_challenge
+	Mode    string
+	Note    string
+	Timeout time.Duration
+}
+
+// NewCloudflare creates a Cloudflare provider
+func NewCloudflare(cfg CloudflareConfig) (*Cloudflare, error) {
+	if cfg.APIToken == "" {
+		return nil, fmt.Errorf("cloudflare: api_token is required")
+	}
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+	if base == "" {
+		base = CloudflareAPI
+	}
+	switch {
+	case cfg.ZoneID != "":
+		base += "/zones/" + url.PathEscape(cfg.ZoneID)
+	case cfg.AccountID != "":
+		base += "/accounts/" + url.PathEscape(cfg.AccountID)
+	default:
+		return nil, fmt.Errorf("cloudflare: zone_id or account_id is required")
+	}
+	mode := cfg.Mode
+	if mode == "" {
+		mode = "block"
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &Cloudflare{
+		base:  base + "/firewall/access_rules/rules",
+		token: cfg.APIToken,
+		mode:  mode,
+		note:  cfg.Note,
+		http:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name implements Provider
+func (c *Cloudflare) Name() string {
+	return "cloudflare"
+}
+
+// Supports implements Provider. Access rules hold single addresses and only
+// IPv4 /16 and /24 and IPv6 /32, /48 and /64 ranges.
+func (c *Cloudflare) Supports(prefix netip.Prefix) bool {
+	if prefix.IsSingleIP() {
+		return true
+	}
+	switch prefix.Bits() {
+	case 16, 24:
+		return prefix.Addr().Is4()
+	case 32, 48, 64:
+		return prefix.Addr().Is6()
+	}
+	return false
+}
+
+type cloudflareRule struct {
+	ID            string `json:"id,omitempty"`
+	Mode          string `json:"mode"`
+	Notes         string `json:"notes"`
+	Configuration struct {
+		Target string `json:"target"`
+		Value  string `json:"value"`
+	} `json:"configuration"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
+}
+
+// List implements Provider
+func (c *Cloudflare) List(ctx context.Context) ([]Rule, error) {
+	var rules []Rule
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("notes", c.note)
+		query.Set("per_page", "1000")
+		query.Set("page", strconv.Itoa(page))
+
+		resp, err := c.do(ctx, http.MethodGet, c.base+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var result []cloudflareRule
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return nil, fmt.Errorf("cloudflare: invalid rule list: %v", err)
+		}
+		for _, r := range result {
+			// The notes filter matches substrings; only claim our own rules
+			if !strings.HasPrefix(r.Notes, c.note) {
+				continue
+			}
+			prefix, err := parseTarget(r.Configuration.Value)
+			if err != nil {
+				continue
+			}
+			rules = append(rules, Rule{ID: r.ID, Prefix: prefix})
+		}
+		if page >= resp.ResultInfo.TotalPages {
+			return rules, nil
+		}
+	}
+}
+
+// Add implements Provider. Access rules do not expire on their own, so the
+// expiry only goes into the notes for operators.
+func (c *Cloudflare) Add(ctx context.Context, prefix netip.Prefix, expires time.Time) (string, error) {
+	rule := cloudflareRule{Mode: c.mode, Notes: c.note}
+	if !expires.IsZero() {
+		rule.Notes += " until " + expires.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case !prefix.IsSingleIP():
+		rule.Configuration.Target = "ip_range"
+		rule.Configuration.Value = prefix.String()
+	case prefix.Addr().Is6():
+		rule.Configuration.Target = "ip6"
+		rule.Configuration.Value = prefix.Addr().String()
+	default:
+		rule.Configuration.Target = "ip"
+		rule.Configuration.Value = prefix.Addr().String()
+	}
+
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.base, body)
+	if err != nil {
+		return "", err
+	}
+	var created cloudflareRule
+	if err := json.Unmarshal(resp.Result, &created); err != nil {
+		return "", fmt.Errorf("cloudflare: invalid rule: %v", err)
+	}
+	return created.ID, nil
+}
+
+// Remove implements Provider
+func (c *Cloudflare) Remove(ctx context.Context, rule Rule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("cloudflare: rule for %s has no ID", rule.Prefix)
+	}
+	_, err := c.do(ctx, http.MethodDelete, c.base+"/"+url.PathEscape(rule.ID), nil)
+	return err
+}
+
+func (c *Cloudflare) do(ctx context.Context, method, u string, body []byte) (*cloudflareResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		// Already gone
+		return &cloudflareResponse{Success: true}, nil
+	}
+
+	var result cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("cloudflare: %s: invalid response: %v", resp.Status, err)
+	}
+	if !result.Success || resp.StatusCode >= 300 {
+		for _, e := range result.Errors {
+			if e.Code == cloudflareDuplicate {
+				return nil, ErrDuplicate
+			}
+		}
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("cloudflare: %s: %s (code %d)", resp.Status, result.Errors[0].Message, result.Errors[0].Code)
+		}
+		return nil, fmt.Errorf("cloudflare: %s", resp.Status)
+	}
+	return &result, nil
+}
+
+// parseTarget parses an IP or CIDR range
+func parseTarget(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
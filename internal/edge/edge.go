@@ -0,0 +1,266 @@
+// Package edge mirrors banned IPs into a CDN or edge WAF, so attack traffic
+// is stopped before it reaches the origin. Only rules the mirror created are
+// touched; they are removed again when the ban expires. Calls to the edge
+// API are rate limited, as providers throttle or suspend noisy clients.
+package edge
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var (
+	rulesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_edge_rules",
+		Help: "Ban rules mirrored to the edge, by provider",
+	}, []string{"provider"})
+
+	callsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_edge_api_calls_total",
+		Help: "Edge API calls, by provider, operation and result",
+	}, []string{"provider", "op", "result"})
+)
+
+// ErrDuplicate is returned by Provider.Add when the edge already holds a
+// rule for the range, for instance one added by another instance
+var ErrDuplicate = errors.New("rule already exists")
+
+// Rule is a ban rule held by the edge
+type Rule struct {
+	ID     string
+	Prefix netip.Prefix
+}
+
+// Provider manages ban rules in an edge WAF
+type Provider interface {
+	// Name identifies the provider in logs and metrics
+	Name() string
+	// Supports reports whether the edge can hold a rule for prefix
+	Supports(prefix netip.Prefix) bool
+	// List returns the rules created by the mirror
+	List(ctx context.Context) ([]Rule, error)
+	// Add creates a rule banning prefix until expires and returns its ID
+	Add(ctx context.Context, prefix netip.Prefix, expires time.Time) (string, error)
+	// Remove deletes a rule
+	Remove(ctx context.Context, rule Rule) error
+}
+
+// Source returns the ranges that should be banned at the edge, with their
+// expiry times
+type Source func() map[netip.Prefix]time.Time
+
+// Mirror keeps the edge rules in step with a source. Changes are applied
+// shortly after they are notified, and the edge is reconciled in full on
+// start and periodically, picking up rules added or removed elsewhere.
+type Mirror struct {
+	provider Provider
+	source   Source
+	limiter  *rate.Limiter
+	interval time.Duration
+	delay    time.Duration
+	maxRules int
+	rules    map[netip.Prefix]string
+	notify   chan struct{}
+	mu       sync.Mutex
+}
+
+// NewMirror creates a mirror applying source through provider at no more
+// than rps API calls per second. Changes are batched for delay, everything
+// is reconciled every interval, and at most maxRules bans are mirrored,
+// preferring those that last longest; zero means no limit.
+func NewMirror(provider Provider, source Source, rps float64, interval, delay time.Duration, maxRules int) *Mirror {
+	return &Mirror{
+		provider: provider,
+		source:   source,
+		limiter:  rate.NewLimiter(rate.Limit(rps), 1),
+		interval: interval,
+		delay:    delay,
+		maxRules: maxRules,
+		rules:    make(map[netip.Prefix]string),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Notify schedules a sync after a list change. It never blocks.
+func (m *Mirror) Notify() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Reconcile lists the rules the edge holds and adds and removes rules to
+// match the source
+func (m *Mirror) Reconcile(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	rules, err := m.provider.List(ctx)
+	m.count("list", err)
+	if err != nil {
+		return err
+	}
+
+	m.rules = make(map[netip.Prefix]string, len(rules))
+	for _, rule := range rules {
+		m.rules[rule.Prefix] = rule.ID
+	}
+	return m.apply(ctx)
+}
+
+// Sync applies source changes since the last sync or reconcile
+func (m *Mirror) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.apply(ctx)
+}
+
+// apply adds missing rules and removes unwanted ones; m.mu must be held.
+// It carries on past failed calls and returns the first error.
+func (m *Mirror) apply(ctx context.Context) error {
+	desired := m.desired()
+
+	var firstErr error
+	for prefix, id := range m.rules {
+		if _, wanted := desired[prefix]; wanted {
+			continue
+		}
+		if id == "" {
+			// Added as a duplicate, so the ID is unknown; the next
+			// reconcile lists the rule and removes it
+			delete(m.rules, prefix)
+			continue
+		}
+		if err := m.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := m.provider.Remove(ctx, Rule{ID: id, Prefix: prefix})
+		m.count("remove", err)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delete(m.rules, prefix)
+	}
+
+	for prefix, expires := range desired {
+		if _, exists := m.rules[prefix]; exists {
+			continue
+		}
+		if err := m.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		id, err := m.provider.Add(ctx, prefix, expires)
+		if errors.Is(err, ErrDuplicate) {
+			// The ID is learned at the next reconcile
+			err = nil
+		}
+		m.count("add", err)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		m.rules[prefix] = id
+	}
+
+	rulesGauge.WithLabelValues(m.provider.Name()).Set(float64(len(m.rules)))
+	return firstErr
+}
+
+// desired returns the unexpired bans the provider supports, capped at
+// maxRules
+func (m *Mirror) desired() map[netip.Prefix]time.Time {
+	now := time.Now()
+	type ban struct {
+		prefix  netip.Prefix
+		expires time.Time
+	}
+	var bans []ban
+	for prefix, expires := range m.source() {
+		if (!expires.IsZero() && !now.Before(expires)) || !m.provider.Supports(prefix) {
+			continue
+		}
+		bans = append(bans, ban{prefix, expires})
+	}
+
+	if m.maxRules > 0 && len(bans) > m.maxRules {
+		sort.Slice(bans, func(i, j int) bool {
+			a, b := bans[i].expires, bans[j].expires
+			if a.IsZero() || b.IsZero() {
+				return a.IsZero() && !b.IsZero()
+			}
+			return a.After(b)
+		})
+		bans = bans[:m.maxRules]
+	}
+
+	desired := make(map[netip.Prefix]time.Time, len(bans))
+	for _, b := range bans {
+		desired[b.prefix] = b.expires
+	}
+	return desired
+}
+
+func (m *Mirror) count(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	callsCounter.WithLabelValues(m.provider.Name(), op, result).Inc()
+}
+
+// Len returns the number of rules the mirror believes the edge holds
+func (m *Mirror) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.rules)
+}
+
+// Run reconciles the edge and keeps it in sync until ctx is cancelled.
+// Expired bans are removed at the next sync or reconcile. Rules are left in
+// place on exit.
+func (m *Mirror) Run(ctx context.Context, onError func(error)) {
+	if err := m.Reconcile(ctx); err != nil && ctx.Err() == nil {
+		onError(err)
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.notify:
+			select {
+			case <-time.After(m.delay):
+			case <-ctx.Done():
+				return
+			}
+			if err := m.Sync(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ticker.C:
+			if err := m.Reconcile(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
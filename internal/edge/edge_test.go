@@ -0,0 +1,171 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider holds rules like an edge API and counts calls
+type fakeProvider struct {
+	rules map[netip.Prefix]string
+	calls int
+	next  int
+}
+
+func (p *fakeProvider) Name() string                      { return "fake" }
+func (p *fakeProvider) Supports(prefix netip.Prefix) bool { return prefix.Bits() != 8 }
+
+func (p *fakeProvider) List(ctx context.Context) ([]Rule, error) {
+	p.calls++
+	var rules []Rule
+	for prefix, id := range p.rules {
+		rules = append(rules, Rule{ID: id, Prefix: prefix})
+	}
+	return rules, nil
+}
+
+func (p *fakeProvider) Add(ctx context.Context, prefix netip.Prefix, expires time.Time) (string, error) {
+	p.calls++
+	if _, exists := p.rules[prefix]; exists {
+		return "", ErrDuplicate
+	}
+	p.next++
+	id := string(rune('a' + p.next))
+	p.rules[prefix] = id
+	return id, nil
+}
+
+func (p *fakeProvider) Remove(ctx context.Context, rule Rule) error {
+	p.calls++
+	delete(p.rules, rule.Prefix)
+	return nil
+}
+
+func TestMirrorReconcile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	stale := netip.MustParsePrefix("203.0.113.9/32")
+	provider := &fakeProvider{rules: map[netip.Prefix]string{stale: "z"}}
+	bans := map[netip.Prefix]time.Time{
+		netip.MustParsePrefix("198.51.100.7/32"): now.Add(time.Hour),
+		netip.MustParsePrefix("198.51.100.8/32"): now.Add(time.Minute),
+		netip.MustParsePrefix("10.0.0.0/8"):      now.Add(time.Hour),
+		netip.MustParsePrefix("192.0.2.1/32"):    now.Add(-time.Minute),
+	}
+	m := NewMirror(provider, func() map[netip.Prefix]time.Time { return bans }, 1000, time.Hour, 0, 0)
+
+	if err := m.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := provider.rules[stale]; exists {
+		t.Error("rule for a lifted ban kept")
+	}
+	if len(provider.rules) != 2 || m.Len() != 2 {
+		t.Errorf("rules = %v, want the two unexpired supported bans", provider.rules)
+	}
+
+	// Nothing changed, so a sync makes no calls
+	calls := provider.calls
+	if err := m.Sync(ctx); err != nil || provider.calls != calls {
+		t.Errorf("idle sync made %d calls, err %v", provider.calls-calls, err)
+	}
+
+	// An expired ban is cleaned up
+	bans[netip.MustParsePrefix("198.51.100.8/32")] = now.Add(-time.Second)
+	if err := m.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := provider.rules[netip.MustParsePrefix("198.51.100.8/32")]; exists {
+		t.Error("rule for an expired ban kept")
+	}
+}
+
+func TestMirrorMaxRulesKeepsLongestBans(t *testing.T) {
+	now := time.Now()
+	provider := &fakeProvider{rules: map[netip.Prefix]string{}}
+	bans := map[netip.Prefix]time.Time{
+		netip.MustParsePrefix("198.51.100.1/32"): now.Add(time.Minute),
+		netip.MustParsePrefix("198.51.100.2/32"): {},
+		netip.MustParsePrefix("198.51.100.3/32"): now.Add(time.Hour),
+	}
+	m := NewMirror(provider, func() map[netip.Prefix]time.Time { return bans }, 1000, time.Hour, 0, 2)
+
+	if err := m.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := provider.rules[netip.MustParsePrefix("198.51.100.1/32")]; exists || len(provider.rules) != 2 {
+		t.Errorf("rules = %v, want the permanent and the longest ban", provider.rules)
+	}
+}
+
+func TestCloudflare(t *testing.T) {
+	var created []cloudflareRule
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/zones/z1/firewall/access_rules/rules") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"success":true,"result":[
+				{"id":"r1","notes":"ddos-protection until 2030-01-01T00:00:00Z","configuration":{"target":"ip","value":"198.51.100.7"}},
+				{"id":"r2","notes":"added by hand, mentions ddos-protection","configuration":{"target":"ip","value":"192.0.2.1"}}
+			],"result_info":{"page":1,"total_pages":1}}`))
+		case http.MethodPost:
+			var rule cloudflareRule
+			json.NewDecoder(r.Body).Decode(&rule)
+			created = append(created, rule)
+			if rule.Configuration.Value == "198.51.100.7" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"success":false,"errors":[{"code":10009,"message":"duplicate"}]}`))
+				return
+			}
+			w.Write([]byte(`{"success":true,"result":{"id":"r3"}}`))
+		case http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/zones/z1/firewall/access_rules/rules/"))
+			w.Write([]byte(`{"success":true,"result":{}}`))
+		}
+	}))
+	defer server.Close()
+
+	cf, err := NewCloudflare(CloudflareConfig{BaseURL: server.URL, APIToken: "token", ZoneID: "z1", Note: "ddos-protection"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	rules, err := cf.List(ctx)
+	if err != nil || len(rules) != 1 || rules[0].ID != "r1" {
+		t.Fatalf("List = %v, %v; want only the rule created by the mirror", rules, err)
+	}
+
+	id, err := cf.Add(ctx, netip.MustParsePrefix("2001:db8:1:2::/64"), time.Time{})
+	if err != nil || id != "r3" {
+		t.Fatalf("Add = %q, %v", id, err)
+	}
+	if c := created[0].Configuration; c.Target != "ip_range" || c.Value != "2001:db8:1:2::/64" || created[0].Mode != "block" {
+		t.Errorf("unexpected rule: %+v", created[0])
+	}
+	if _, err := cf.Add(ctx, netip.MustParsePrefix("198.51.100.7/32"), time.Time{}); err != ErrDuplicate {
+		t.Errorf("duplicate Add = %v, want ErrDuplicate", err)
+	}
+
+	if err := cf.Remove(ctx, Rule{ID: "r1"}); err != nil || len(deleted) != 1 || deleted[0] != "r1" {
+		t.Errorf("Remove: deleted %v, err %v", deleted, err)
+	}
+
+	if cf.Supports(netip.MustParsePrefix("10.0.0.0/8")) || !cf.Supports(netip.MustParsePrefix("10.1.2.0/24")) {
+		t.Error("Supports should only allow the ranges access rules accept")
+	}
+}
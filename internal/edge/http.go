@@ -0,0 +1,148 @@
+package edge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTP mirrors bans through a generic edge API:
+//
+//	GET    {url}       -> {"rules": [{"id": "...", "target": "198.51.100.7"}]}
+//	POST   {url}       <- {"target": "...", "expires": "...", "note": "..."}
+//	                   -> {"id": "..."}
+//	DELETE {url}/{id}
+//
+// Requests carry the token as a bearer token. A 409 response to a POST
+// means the rule already exists.
+type HTTP struct {
+	url   string
+	token string
+	note  string
+	http  *http.Client
+}
+
+// NewHTTP creates a generic edge API provider
+func NewHTTP(endpoint, token, note string, timeout time.Duration) (*HTTP, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("edge: url is required")
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTP{
+		url:   strings.TrimSuffix(endpoint, "/"),
+		token: token,
+		note:  note,
+		http:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name implements Provider
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+// Supports implements Provider
+func (h *HTTP) Supports(prefix netip.Prefix) bool {
+	return prefix.IsValid()
+}
+
+// List implements Provider
+func (h *HTTP) List(ctx context.Context) ([]Rule, error) {
+	var result struct {
+		Rules []struct {
+			ID     string `json:"id"`
+			Target string `json:"target"`
+		} `json:"rules"`
+	}
+	if err := h.do(ctx, http.MethodGet, h.url, nil, &result); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(result.Rules))
+	for _, r := range result.Rules {
+		prefix, err := parseTarget(r.Target)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, Rule{ID: r.ID, Prefix: prefix})
+	}
+	return rules, nil
+}
+
+// Add implements Provider
+func (h *HTTP) Add(ctx context.Context, prefix netip.Prefix, expires time.Time) (string, error) {
+	body := map[string]interface{}{
+		"target": prefix.String(),
+		"note":   h.note,
+	}
+	if !expires.IsZero() {
+		body["expires"] = expires.UTC()
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := h.do(ctx, http.MethodPost, h.url, body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// Remove implements Provider
+func (h *HTTP) Remove(ctx context.Context, rule Rule) error {
+	return h.do(ctx, http.MethodDelete, h.url+"/"+url.PathEscape(rule.ID), nil, nil)
+}
+
+func (h *HTTP) do(ctx context.Context, method, u string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("edge: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict && method == http.MethodPost:
+		return ErrDuplicate
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		// Already gone
+		return nil
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("edge: %s %s: %s", method, u, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("edge: invalid response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package enforce pushes blacklisted IPs and ranges into the kernel
+// firewall, so packets from banned sources are dropped before they cost the
+// service anything. Whitelisted IPs go into an allow set that is matched
+// first. Members carry the remaining ban time as a kernel timeout, so bans
+// lapse on schedule even if the service stops.
+package enforce
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	elementsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_enforcement_elements",
+		Help: "Members of the kernel firewall sets, by kind",
+	}, []string{"kind"})
+
+	errorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_enforcement_errors_total",
+		Help: "Failed updates of the kernel firewall, by driver",
+	}, []string{"driver"})
+)
+
+// Kind tells banned members from allowed ones
+type Kind string
+
+const (
+	KindBan   Kind = "ban"
+	KindAllow Kind = "allow"
+)
+
+// Member is an address range in one of the firewall sets
+type Member struct {
+	Kind   Kind
+	Prefix netip.Prefix
+}
+
+// Element is a member with its expiry, zero for permanent members
+type Element struct {
+	Member
+	Expires time.Time
+}
+
+// Timeout returns the time left before an element expires, rounded up to a
+// whole second, or zero for permanent elements
+func (e Element) Timeout(now time.Time) time.Duration {
+	if e.Expires.IsZero() {
+		return 0
+	}
+	return e.Expires.Sub(now).Truncate(time.Second) + time.Second
+}
+
+// Driver updates a kernel firewall
+type Driver interface {
+	// Name identifies the driver in logs and metrics
+	Name() string
+	// Setup creates the sets, and rules where the driver manages them
+	Setup(ctx context.Context) error
+	// List returns the members currently in the sets
+	List(ctx context.Context) ([]Member, error)
+	// Apply removes and then adds elements in one batch
+	Apply(ctx context.Context, add []Element, remove []Member) error
+}
+
+// Runner runs a command with stdin and returns its output
+type Runner func(ctx context.Context, stdin, name string, args ...string) ([]byte, error)
+
+// Exec runs a command on the host
+func Exec(ctx context.Context, stdin, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return out, fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return out, fmt.Errorf("%s: %v", name, err)
+	}
+	return out, nil
+}
+
+// Source returns the ranges that should be banned and allowed, with their
+// expiry times
+type Source func() (bans, allows map[netip.Prefix]time.Time)
+
+// Desired builds the firewall members for bans and allowances. Bans inside
+// a wider ban are left out, since interval sets reject overlapping members
+// and the wider ban drops their packets anyway.
+func Desired(bans, allows map[netip.Prefix]time.Time) map[Member]time.Time {
+	prefixes := make([]netip.Prefix, 0, len(bans))
+	for prefix := range bans {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	desired := make(map[Member]time.Time, len(bans)+len(allows))
+	kept := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if covered(kept, prefix) {
+			continue
+		}
+		kept = append(kept, prefix)
+		desired[Member{KindBan, prefix}] = bans[prefix]
+	}
+	for prefix, expires := range allows {
+		desired[Member{KindAllow, prefix}] = expires
+	}
+	return desired
+}
+
+// covered reports whether prefix lies inside one of wider
+func covered(wider []netip.Prefix, prefix netip.Prefix) bool {
+	for _, w := range wider {
+		if w.Bits() <= prefix.Bits() && w.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforcer keeps the kernel firewall in step with the lists. Changes are
+// applied in batches shortly after they are notified, and the firewall is
+// reconciled in full on start and periodically, undoing drift from missed
+// changes or manual edits.
+type Enforcer struct {
+	driver   Driver
+	source   Source
+	interval time.Duration
+	delay    time.Duration
+	applied  map[Member]time.Time
+	notify   chan struct{}
+	mu       sync.Mutex
+}
+
+// NewEnforcer creates an enforcer applying source through driver. Changes
+// are batched for delay and everything is reconciled every interval.
+func NewEnforcer(driver Driver, source Source, interval, delay time.Duration) *Enforcer {
+	return &Enforcer{
+		driver:   driver,
+		source:   source,
+		interval: interval,
+		delay:    delay,
+		applied:  make(map[Member]time.Time),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Notify schedules a sync after a list change. It never blocks.
+func (e *Enforcer) Notify() {
+	select {
+	case e.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Reconcile replaces the firewall members with the desired ones, starting
+// from what the kernel actually holds
+func (e *Enforcer) Reconcile(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	members, err := e.driver.List(ctx)
+	if err != nil {
+		errorsCounter.WithLabelValues(e.driver.Name()).Inc()
+		return err
+	}
+
+	current := make(map[Member]time.Time, len(members))
+	for _, m := range members {
+		current[m] = time.Time{}
+	}
+	desired := e.desired()
+	// The kernel does not report expiry times, so members present in both
+	// are taken to be up to date
+	for m := range current {
+		if expires, ok := desired[m]; ok {
+			current[m] = expires
+		}
+	}
+	return e.apply(ctx, current, desired)
+}
+
+// Sync applies list changes since the last sync or reconcile
+func (e *Enforcer) Sync(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.apply(ctx, e.applied, e.desired())
+}
+
+func (e *Enforcer) desired() map[Member]time.Time {
+	bans, allows := e.source()
+	return Desired(bans, allows)
+}
+
+// apply updates the firewall from current to desired; e.mu must be held.
+// Members whose expiry changed are removed and added again with the new
+// timeout. Members the kernel has already expired are not removed.
+func (e *Enforcer) apply(ctx context.Context, current, desired map[Member]time.Time) error {
+	now := time.Now()
+	var add []Element
+	var remove []Member
+	for m, expires := range desired {
+		old, exists := current[m]
+		if exists && old.Equal(expires) {
+			continue
+		}
+		if !expires.IsZero() && !now.Before(expires) {
+			continue
+		}
+		if exists && (old.IsZero() || now.Before(old)) {
+			remove = append(remove, m)
+		}
+		add = append(add, Element{Member: m, Expires: expires})
+	}
+	for m, expires := range current {
+		if _, wanted := desired[m]; wanted {
+			continue
+		}
+		if expires.IsZero() || now.Before(expires) {
+			remove = append(remove, m)
+		}
+	}
+
+	if len(add) > 0 || len(remove) > 0 {
+		if err := e.driver.Apply(ctx, add, remove); err != nil {
+			errorsCounter.WithLabelValues(e.driver.Name()).Inc()
+			return err
+		}
+	}
+
+	e.applied = make(map[Member]time.Time, len(desired))
+	counts := map[Kind]int{KindBan: 0, KindAllow: 0}
+	for m, expires := range desired {
+		if expires.IsZero() || now.Before(expires) {
+			e.applied[m] = expires
+			counts[m.Kind]++
+		}
+	}
+	for kind, n := range counts {
+		elementsGauge.WithLabelValues(string(kind)).Set(float64(n))
+	}
+	return nil
+}
+
+// Len returns the number of members last applied
+func (e *Enforcer) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.applied)
+}
+
+// Run sets up the firewall, reconciles it and keeps it in sync until ctx is
+// cancelled. A failed sync falls back to a full reconcile. Members are left
+// in place on exit; their timeouts keep bans from outliving the lists.
+func (e *Enforcer) Run(ctx context.Context, onError func(error)) {
+	if err := e.driver.Setup(ctx); err != nil {
+		errorsCounter.WithLabelValues(e.driver.Name()).Inc()
+		onError(err)
+	}
+	if err := e.Reconcile(ctx); err != nil {
+		onError(err)
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.notify:
+			select {
+			case <-time.After(e.delay):
+			case <-ctx.Done():
+				return
+			}
+			if err := e.Sync(ctx); err != nil {
+				onError(err)
+				if err := e.Reconcile(ctx); err != nil {
+					onError(err)
+				}
+			}
+		case <-ticker.C:
+			if err := e.Reconcile(ctx); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
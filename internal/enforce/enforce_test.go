@@ -0,0 +1,127 @@
+package enforce
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDriver records applied batches and holds members like a kernel set
+type fakeDriver struct {
+	members map[Member]bool
+	batches int
+}
+
+func (d *fakeDriver) Name() string                    { return "fake" }
+func (d *fakeDriver) Setup(ctx context.Context) error { return nil }
+
+func (d *fakeDriver) List(ctx context.Context) ([]Member, error) {
+	var members []Member
+	for m := range d.members {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (d *fakeDriver) Apply(ctx context.Context, add []Element, remove []Member) error {
+	d.batches++
+	for _, m := range remove {
+		delete(d.members, m)
+	}
+	for _, e := range add {
+		d.members[e.Member] = true
+	}
+	return nil
+}
+
+func ban(s string) Member {
+	p, _ := parsePrefix(s)
+	return Member{KindBan, p}
+}
+
+func TestDesiredDropsCoveredBans(t *testing.T) {
+	hour := time.Now().Add(time.Hour)
+	bans := map[netip.Prefix]time.Time{
+		netip.MustParsePrefix("10.0.0.0/8"):    hour,
+		netip.MustParsePrefix("10.1.2.3/32"):   hour,
+		netip.MustParsePrefix("192.0.2.7/32"):  hour,
+		netip.MustParsePrefix("2001:db8::/48"): hour,
+	}
+	desired := Desired(bans, map[netip.Prefix]time.Time{netip.MustParsePrefix("198.51.100.1/32"): {}})
+
+	if len(desired) != 4 {
+		t.Fatalf("desired = %v, want 3 bans and 1 allowance", desired)
+	}
+	if _, ok := desired[ban("10.1.2.3")]; ok {
+		t.Error("ban inside 10.0.0.0/8 should be left out")
+	}
+	if _, ok := desired[Member{KindAllow, netip.MustParsePrefix("198.51.100.1/32")}]; !ok {
+		t.Error("allowance missing")
+	}
+}
+
+func TestReconcileAndSync(t *testing.T) {
+	ctx := context.Background()
+	hour := time.Now().Add(time.Hour)
+	driver := &fakeDriver{members: map[Member]bool{
+		ban("203.0.113.9"): true, // stale member from a previous run
+		ban("192.0.2.1"):   true,
+	}}
+	bans := map[netip.Prefix]time.Time{
+		netip.MustParsePrefix("192.0.2.1/32"): hour,
+		netip.MustParsePrefix("192.0.2.2/32"): hour,
+	}
+	e := NewEnforcer(driver, func() (map[netip.Prefix]time.Time, map[netip.Prefix]time.Time) {
+		return bans, nil
+	}, time.Minute, 0)
+
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(driver.members) != 2 || driver.members[ban("203.0.113.9")] || !driver.members[ban("192.0.2.2")] {
+		t.Fatalf("members after reconcile = %v", driver.members)
+	}
+
+	// No changes means no batch
+	batches := driver.batches
+	if err := e.Sync(ctx); err != nil || driver.batches != batches {
+		t.Fatalf("sync without changes applied a batch (err %v)", err)
+	}
+
+	delete(bans, netip.MustParsePrefix("192.0.2.1/32"))
+	bans[netip.MustParsePrefix("192.0.2.0/24")] = hour
+	if err := e.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(driver.members) != 1 || !driver.members[ban("192.0.2.0/24")] {
+		t.Errorf("members after sync = %v, want only the /24", driver.members)
+	}
+}
+
+func TestNFTablesScripts(t *testing.T) {
+	var scripts []string
+	n := NewNFTables("", false, func(ctx context.Context, stdin, name string, args ...string) ([]byte, error) {
+		scripts = append(scripts, stdin)
+		if len(args) > 0 && args[0] == "list" && args[len(args)-1] == "ban4" {
+			return []byte("table inet ddos {\n\tset ban4 {\n\t\telements = { 192.0.2.1 timeout 1h expires 59m,\n\t\t\t     10.0.0.0/8 }\n\t}\n}\n"), nil
+		}
+		return nil, nil
+	})
+
+	members, err := n.List(context.Background())
+	if err != nil || len(members) != 2 || members[1] != ban("10.0.0.0/8") {
+		t.Fatalf("List() = %v, %v", members, err)
+	}
+
+	scripts = nil
+	err = n.Apply(context.Background(), []Element{{Member: ban("2001:db8::/48"), Expires: time.Now().Add(90 * time.Second)}}, []Member{ban("192.0.2.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "delete element inet ddos ban4 { 192.0.2.1 }\nadd element inet ddos ban6 { 2001:db8::/48 timeout 90s }\n"
+	if len(scripts) != 1 || scripts[0] != want {
+		t.Errorf("script = %q, want %q", strings.Join(scripts, ""), want)
+	}
+}
@@ -0,0 +1,108 @@
+package enforce
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// IPSet enforces bans with ipset hash:net sets, for hosts filtering with
+// iptables. The sets are named <prefix>-ban4, -ban6, -allow4 and -allow6;
+// the iptables rules referencing them are left to the operator, e.g.
+//
+//	iptables -I INPUT -m set --match-set ddos-ban4 src -j DROP
+//	iptables -I INPUT -m set --match-set ddos-allow4 src -j ACCEPT
+type IPSet struct {
+	Prefix string
+	run    Runner
+}
+
+// NewIPSet creates an ipset driver naming its sets after prefix, "ddos" if
+// empty
+func NewIPSet(prefix string, run Runner) *IPSet {
+	if prefix == "" {
+		prefix = "ddos"
+	}
+	return &IPSet{Prefix: prefix, run: run}
+}
+
+// Name identifies the driver
+func (s *IPSet) Name() string {
+	return "ipset"
+}
+
+// setName returns the set holding members of a kind and address family
+func (s *IPSet) setName(kind Kind, prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return s.Prefix + "-" + string(kind) + "4"
+	}
+	return s.Prefix + "-" + string(kind) + "6"
+}
+
+// ipsetSet is one of the sets managed by the ipset driver
+type ipsetSet struct {
+	name   string
+	kind   Kind
+	family string
+}
+
+func (s *IPSet) sets() []ipsetSet {
+	return []ipsetSet{
+		{s.Prefix + "-ban4", KindBan, "inet"},
+		{s.Prefix + "-ban6", KindBan, "inet6"},
+		{s.Prefix + "-allow4", KindAllow, "inet"},
+		{s.Prefix + "-allow6", KindAllow, "inet6"},
+	}
+}
+
+// Setup creates the sets, keeping existing ones and their members
+func (s *IPSet) Setup(ctx context.Context) error {
+	var b strings.Builder
+	for _, set := range s.sets() {
+		fmt.Fprintf(&b, "create %s hash:net family %s timeout 0 maxelem 1048576\n", set.name, set.family)
+	}
+	_, err := s.run(ctx, b.String(), "ipset", "restore", "-exist")
+	return err
+}
+
+// List returns the members of every set
+func (s *IPSet) List(ctx context.Context) ([]Member, error) {
+	var members []Member
+	for _, set := range s.sets() {
+		out, err := s.run(ctx, "", "ipset", "save", set.name)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(string(out)))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 || fields[0] != "add" || fields[1] != set.name {
+				continue
+			}
+			if prefix, err := parsePrefix(fields[2]); err == nil {
+				members = append(members, Member{Kind: set.kind, Prefix: prefix})
+			}
+		}
+	}
+	return members, nil
+}
+
+// Apply removes and adds elements in one ipset restore. A timeout of 0
+// makes an element permanent.
+func (s *IPSet) Apply(ctx context.Context, add []Element, remove []Member) error {
+	now := time.Now()
+	var b strings.Builder
+	for _, m := range remove {
+		fmt.Fprintf(&b, "del %s %s\n", s.setName(m.Kind, m.Prefix), formatPrefix(m.Prefix))
+	}
+	for _, e := range add {
+		fmt.Fprintf(&b, "add %s %s timeout %d\n", s.setName(e.Kind, e.Prefix), formatPrefix(e.Prefix), int64(e.Timeout(now)/time.Second))
+	}
+
+	_, err := s.run(ctx, b.String(), "ipset", "restore", "-exist")
+	return err
+}
@@ -0,0 +1,150 @@
+package enforce
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// NFTables enforces bans with nftables sets in an inet table of their own.
+// With ManageRules the table also gets an input chain that accepts allowed
+// sources and drops banned ones; otherwise the sets are left for the
+// operator's own ruleset to reference.
+type NFTables struct {
+	Table       string
+	ManageRules bool
+	run         Runner
+}
+
+// NewNFTables creates an nftables driver using table, "ddos" if empty
+func NewNFTables(table string, manageRules bool, run Runner) *NFTables {
+	if table == "" {
+		table = "ddos"
+	}
+	return &NFTables{Table: table, ManageRules: manageRules, run: run}
+}
+
+// Name identifies the driver
+func (n *NFTables) Name() string {
+	return "nftables"
+}
+
+// setName returns the set holding members of a kind and address family
+func (n *NFTables) setName(kind Kind, prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return string(kind) + "4"
+	}
+	return string(kind) + "6"
+}
+
+// Setup creates the table and sets, and the filter chain if rules are
+// managed. Existing sets and their members are kept.
+func (n *NFTables) Setup(ctx context.Context) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "add table inet %s\n", n.Table)
+	fmt.Fprintf(&b, "add set inet %s ban4 { type ipv4_addr; flags interval, timeout; }\n", n.Table)
+	fmt.Fprintf(&b, "add set inet %s ban6 { type ipv6_addr; flags interval, timeout; }\n", n.Table)
+	fmt.Fprintf(&b, "add set inet %s allow4 { type ipv4_addr; flags timeout; }\n", n.Table)
+	fmt.Fprintf(&b, "add set inet %s allow6 { type ipv6_addr; flags timeout; }\n", n.Table)
+	if n.ManageRules {
+		fmt.Fprintf(&b, "add chain inet %s input { type filter hook input priority -10; policy accept; }\n", n.Table)
+		fmt.Fprintf(&b, "flush chain inet %s input\n", n.Table)
+		fmt.Fprintf(&b, "add rule inet %s input ip saddr @allow4 accept\n", n.Table)
+		fmt.Fprintf(&b, "add rule inet %s input ip6 saddr @allow6 accept\n", n.Table)
+		fmt.Fprintf(&b, "add rule inet %s input ip saddr @ban4 drop\n", n.Table)
+		fmt.Fprintf(&b, "add rule inet %s input ip6 saddr @ban6 drop\n", n.Table)
+	}
+
+	_, err := n.run(ctx, b.String(), "nft", "-f", "-")
+	return err
+}
+
+// List returns the members of every set
+func (n *NFTables) List(ctx context.Context) ([]Member, error) {
+	var members []Member
+	for _, set := range []struct {
+		name string
+		kind Kind
+	}{{"ban4", KindBan}, {"ban6", KindBan}, {"allow4", KindAllow}, {"allow6", KindAllow}} {
+		out, err := n.run(ctx, "", "nft", "list", "set", "inet", n.Table, set.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, prefix := range parseNFTElements(string(out)) {
+			members = append(members, Member{Kind: set.kind, Prefix: prefix})
+		}
+	}
+	return members, nil
+}
+
+// parseNFTElements reads the members of a set from nft list output, where
+// they appear as "elements = { 192.0.2.1 timeout 1h expires 59m, ... }"
+func parseNFTElements(out string) []netip.Prefix {
+	start := strings.Index(out, "elements = {")
+	if start < 0 {
+		return nil
+	}
+	out = out[start+len("elements = {"):]
+	if end := strings.Index(out, "}"); end >= 0 {
+		out = out[:end]
+	}
+
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(out, ",") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		if prefix, err := parsePrefix(fields[0]); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// Apply removes and adds elements in one atomic nft transaction
+func (n *NFTables) Apply(ctx context.Context, add []Element, remove []Member) error {
+	now := time.Now()
+	var b strings.Builder
+	for _, m := range remove {
+		fmt.Fprintf(&b, "delete element inet %s %s { %s }\n", n.Table, n.setName(m.Kind, m.Prefix), formatPrefix(m.Prefix))
+	}
+	for _, e := range add {
+		fmt.Fprintf(&b, "add element inet %s %s { %s", n.Table, n.setName(e.Kind, e.Prefix), formatPrefix(e.Prefix))
+		if timeout := e.Timeout(now); timeout > 0 {
+			fmt.Fprintf(&b, " timeout %ds", int64(timeout/time.Second))
+		}
+		b.WriteString(" }\n")
+	}
+
+	_, err := n.run(ctx, b.String(), "nft", "-f", "-")
+	return err
+}
+
+// formatPrefix writes single addresses without a prefix length, as the
+// firewall tools list them
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// parsePrefix parses an address or CIDR range
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
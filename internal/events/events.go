@@ -0,0 +1,137 @@
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCapacity is used when no capacity is configured
+	DefaultCapacity = 100000
+	// DefaultLimit is the page size when none is requested
+	DefaultLimit = 50
+	// MaxLimit caps the page size
+	MaxLimit = 1000
+)
+
+// Event is a security decision taken on a request
+type Event struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Tenant   string    `json:"tenant"`
+	Stage    string    `json:"stage"`
+	Decision string    `json:"decision"`
+	Code     string    `json:"code"`
+	Reason   string    `json:"reason,omitempty"`
+	Score    int       `json:"score"`
+	TraceID  string    `json:"trace_id,omitempty"`
+	Node     string    `json:"node,omitempty"`
+}
+
+// Page is one page of query results, newest first
+type Page struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// Store keeps the most recent events in a fixed-size ring buffer
+type Store struct {
+	events []Event
+	next   int
+	full   bool
+	lastID uint64
+	mu     sync.RWMutex
+}
+
+// NewStore creates a store holding up to capacity events
+func NewStore(capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Store{events: make([]Event, capacity)}
+}
+
+// Add records an event, assigning its ID, and returns the stored copy
+func (s *Store) Add(e Event) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	e.ID = s.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.events[s.next] = e
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+	return e
+}
+
+// Len returns the number of stored events
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.full {
+		return len(s.events)
+	}
+	return s.next
+}
+
+// Search returns up to limit events matching q, newest first. cursor is the
+// NextCursor of a previous page, or "" for the first page.
+func (s *Store) Search(q *Query, cursor string, limit int) (*Page, error) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	var before uint64
+	if cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		before = id
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := &Page{Events: []Event{}}
+	count := s.next
+	if s.full {
+		count = len(s.events)
+	}
+
+	for i := 1; i <= count; i++ {
+		e := s.events[(s.next-i+len(s.events))%len(s.events)]
+		if before != 0 && e.ID >= before {
+			continue
+		}
+		// Events are stored in time order, so nothing older can match
+		if !q.Since.IsZero() && e.Time.Before(q.Since) {
+			break
+		}
+		if !q.Match(&e) {
+			continue
+		}
+
+		if len(page.Events) == limit {
+			page.NextCursor = strconv.FormatUint(page.Events[limit-1].ID, 10)
+			break
+		}
+		page.Events = append(page.Events, e)
+	}
+
+	return page, nil
+}
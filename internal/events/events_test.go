@@ -0,0 +1,93 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q, err := ParseQuery(`ip:10.0.0.0/8 path:/admin* code:blocked_ip reason:"high risk" score>=50 score<80 since:1h`, now)
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+
+	match := Event{
+		Time:   now.Add(-10 * time.Minute),
+		IP:     "10.1.2.3",
+		Path:   "/admin/users",
+		Code:   "BLOCKED_IP",
+		Reason: "High risk score: 60",
+		Score:  60,
+	}
+	if !q.Match(&match) {
+		t.Errorf("Expected %+v to match", match)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(e *Event)
+	}{
+		{"IP outside CIDR", func(e *Event) { e.IP = "192.168.1.1" }},
+		{"Path outside prefix", func(e *Event) { e.Path = "/public" }},
+		{"Different code", func(e *Event) { e.Code = "RATE_LIMITED" }},
+		{"Score too high", func(e *Event) { e.Score = 80 }},
+		{"Too old", func(e *Event) { e.Time = now.Add(-2 * time.Hour) }},
+	}
+	for _, tt := range tests {
+		e := match
+		tt.modify(&e)
+		if q.Match(&e) {
+			t.Errorf("%s: expected no match", tt.name)
+		}
+	}
+
+	for _, bad := range []string{"ip:nope", "color:red", "score~5", `reason:"open`} {
+		if _, err := ParseQuery(bad, now); err == nil {
+			t.Errorf("ParseQuery(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	s := NewStore(10)
+	for i := 0; i < 15; i++ {
+		code := "RATE_LIMITED"
+		if i%2 == 0 {
+			code = "BLOCKED_IP"
+		}
+		s.Add(Event{IP: "203.0.113.1", Code: code})
+	}
+
+	if s.Len() != 10 {
+		t.Fatalf("Len() = %d, want 10", s.Len())
+	}
+
+	q, _ := ParseQuery("code:BLOCKED_IP", time.Now())
+	var ids []uint64
+	cursor := ""
+	for {
+		page, err := s.Search(q, cursor, 2)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, e := range page.Events {
+			ids = append(ids, e.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	// Events 6..15 remain; the odd IDs were BLOCKED_IP
+	expected := []uint64{15, 13, 11, 9, 7}
+	if len(ids) != len(expected) {
+		t.Fatalf("Got IDs %v, want %v", ids, expected)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("Got IDs %v, want %v", ids, expected)
+		}
+	}
+}
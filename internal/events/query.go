@@ -0,0 +1,284 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Query selects events. Terms on different fields must all match; repeated
+// terms on the same field match any of their values.
+//
+// The syntax is a whitespace-separated list of terms:
+//
+//	ip:203.0.113.7 ip:2001:db8::/32   client IP or CIDR
+//	path:/admin path:/api/*           exact path, or prefix with a trailing *
+//	code:BLOCKED_IP                   block reason code
+//	reason:"risk score"               substring of the reason text
+//	tenant:acme stage:filter decision:deny method:POST
+//	trace:4bf92f3577b34da6a3ce929d0e0e4736   trace ID
+//	score>=50 score<80 score:100      risk score comparisons
+//	since:1h until:2024-05-01T12:00:00Z   relative duration or RFC 3339 time
+//
+// Values containing spaces can be double-quoted.
+type Query struct {
+	Since     time.Time
+	Until     time.Time
+	Nets      []netip.Prefix
+	Paths     []string
+	Codes     []string
+	Reasons   []string
+	Tenants   []string
+	Stages    []string
+	Decisions []string
+	Methods   []string
+	Traces    []string
+	MinScore  int
+	MaxScore  int
+}
+
+// ParseQuery parses a query string. Relative times are resolved against now.
+func ParseQuery(input string, now time.Time) (*Query, error) {
+	q := &Query{MinScore: math.MinInt32, MaxScore: math.MaxInt32}
+
+	terms, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, term := range terms {
+		if strings.HasPrefix(term, "score") {
+			if err := q.parseScore(term[len("score"):]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		i := strings.IndexByte(term, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid term %q, expected field:value", term)
+		}
+		field, value := strings.ToLower(term[:i]), unquote(term[i+1:])
+		if value == "" {
+			return nil, fmt.Errorf("empty value for %s", field)
+		}
+
+		switch field {
+		case "ip":
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			q.Nets = append(q.Nets, prefix)
+		case "path":
+			q.Paths = append(q.Paths, value)
+		case "code":
+			q.Codes = append(q.Codes, strings.ToUpper(value))
+		case "reason":
+			q.Reasons = append(q.Reasons, strings.ToLower(value))
+		case "tenant":
+			q.Tenants = append(q.Tenants, value)
+		case "stage":
+			q.Stages = append(q.Stages, value)
+		case "decision":
+			q.Decisions = append(q.Decisions, strings.ToLower(value))
+		case "method":
+			q.Methods = append(q.Methods, strings.ToUpper(value))
+		case "trace":
+			q.Traces = append(q.Traces, strings.ToLower(value))
+		case "since", "until":
+			t, err := parseTime(value, now)
+			if err != nil {
+				return nil, err
+			}
+			if field == "since" {
+				q.Since = t
+			} else {
+				q.Until = t
+			}
+		default:
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+
+	return q, nil
+}
+
+func (q *Query) parseScore(expr string) error {
+	ops := []string{">=", "<=", ">", "<", ":", "="}
+	for _, op := range ops {
+		if !strings.HasPrefix(expr, op) {
+			continue
+		}
+
+		n, err := strconv.Atoi(expr[len(op):])
+		if err != nil {
+			return fmt.Errorf("invalid score %q", expr[len(op):])
+		}
+
+		switch op {
+		case ">=":
+			q.MinScore = n
+		case ">":
+			q.MinScore = n + 1
+		case "<=":
+			q.MaxScore = n
+		case "<":
+			q.MaxScore = n - 1
+		default:
+			q.MinScore, q.MaxScore = n, n
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid score term %q", "score"+expr)
+}
+
+// Match reports whether e satisfies the query
+func (q *Query) Match(e *Event) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if e.Score < q.MinScore || e.Score > q.MaxScore {
+		return false
+	}
+
+	if len(q.Nets) > 0 {
+		addr, err := netip.ParseAddr(e.IP)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		matched := false
+		for _, prefix := range q.Nets {
+			if prefix.Contains(addr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(q.Paths) > 0 {
+		matched := false
+		for _, p := range q.Paths {
+			if strings.HasSuffix(p, "*") {
+				matched = strings.HasPrefix(e.Path, strings.TrimSuffix(p, "*"))
+			} else {
+				matched = e.Path == p
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(q.Reasons) > 0 {
+		reason := strings.ToLower(e.Reason)
+		matched := false
+		for _, r := range q.Reasons {
+			if strings.Contains(reason, r) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return anyOf(q.Codes, e.Code) &&
+		anyOf(q.Tenants, e.Tenant) &&
+		anyOf(q.Stages, e.Stage) &&
+		anyOf(q.Decisions, e.Decision) &&
+		anyOf(q.Methods, e.Method) &&
+		anyOf(q.Traces, e.TraceID)
+}
+
+func anyOf(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenize(input string) ([]string, error) {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration like 15m or an RFC 3339 time", value)
+}
@@ -0,0 +1,97 @@
+package filter
+
+import "fmt"
+
+// Action is the consequence of a rule matching a request, and of filtering
+// it as a whole
+type Action string
+
+// Actions, from mildest to strictest
+const (
+	// ActionAllow lets the request through; it is only a result
+	ActionAllow Action = "allow"
+	// ActionLog logs the request without adding to its risk score
+	ActionLog Action = "log"
+	// ActionFlag adds the rule's severity to the request's risk score and
+	// logs it
+	ActionFlag Action = "flag"
+	// ActionTarpit adds to the risk score like flag and serves the request
+	// slowly
+	ActionTarpit Action = "tarpit"
+	// ActionChallenge asks the client to prove it is legitimate before the
+	// request is served
+	ActionChallenge Action = "challenge"
+	// ActionBlock rejects a matching request
+	ActionBlock Action = "block"
+)
+
+// strictness orders the actions
+var strictness = map[Action]int{
+	ActionAllow:     0,
+	ActionLog:       1,
+	ActionFlag:      2,
+	ActionTarpit:    3,
+	ActionChallenge: 4,
+	ActionBlock:     5,
+}
+
+// ruleAction checks an action a rule can take
+func ruleAction(action Action) error {
+	if _, ok := strictness[action]; !ok || action == ActionAllow {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+// stricter returns the stricter of two actions
+func stricter(a, b Action) Action {
+	if strictness[b] > strictness[a] {
+		return b
+	}
+	return a
+}
+
+// SetRuleActions overrides the actions of rules by ID: the malicious
+// patterns, which otherwise take the action of their category, and the
+// custom rules, which otherwise take the action of their file
+func (rf *RequestFilter) SetRuleActions(actions map[string]Action) error {
+	for id, action := range actions {
+		if err := ruleAction(action); err != nil {
+			return fmt.Errorf("rule %s: %v", id, err)
+		}
+	}
+	copied := make(map[string]Action, len(actions))
+	for id, action := range actions {
+		copied[id] = action
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.ruleActions = copied
+	return nil
+}
+
+// apply records the outcome of a check that took action with risk score,
+// and reports whether filtering stops here. Block and challenge stop it;
+// the milder actions let the remaining checks run.
+func (result *FilterResult) apply(action Action, score int) bool {
+	if action != ActionLog {
+		result.RiskScore += score
+	}
+	result.ShouldLog = true
+	switch action {
+	case ActionTarpit:
+		result.Action = stricter(result.Action, action)
+	case ActionBlock:
+		result.Action = action
+		result.Allowed = false
+		result.Blocked = true
+		return true
+	case ActionChallenge:
+		result.Action = action
+		result.Allowed = false
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const actionRules = `
+rules:
+  - id: admin-probe
+    pattern: '^/admin'
+    action: challenge
+  - id: export-scrape
+    pattern: '^/export'
+    action: tarpit
+    severity: low
+  - id: legacy-api
+    pattern: '^/v1/'
+    action: log
+`
+
+func TestRuleActions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte(actionRules), 0o644)
+	rf := NewRequestFilter(1<<20, nil, nil)
+	if _, err := rf.LoadRules(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.SetRuleActions(map[string]Action{"xss-javascript-uri": ActionChallenge}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target           string
+		action           Action
+		allowed, blocked bool
+		reason           string
+		risk             int
+	}{
+		{"/admin/users", ActionChallenge, false, false, "Challenged by rule admin-probe", 60},
+		{"/export/orders.csv", ActionTarpit, true, false, "Flagged by rules: export-scrape", 10},
+		{"/v1/items", ActionAllow, true, false, "Flagged by rules: legacy-api", 0},
+		{"/go?to=javascript:alert(1)", ActionChallenge, false, false, "Malicious pattern detected in URL", 80},
+		{"/files/../../etc/passwd", ActionBlock, false, true, "Malicious pattern detected in URL", 80},
+		{"/", ActionAllow, true, false, "Request allowed", 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept", "*/*")
+		req.Header.Set("Accept-Language", "en")
+		req.Header.Set("Accept-Encoding", "gzip")
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Action != tt.action || result.Allowed != tt.allowed || result.Blocked != tt.blocked ||
+			result.Reason != tt.reason || result.RiskScore != tt.risk {
+			t.Errorf("%s: %+v, want %s allowed %v blocked %v (%s) risk %d",
+				tt.target, result, tt.action, tt.allowed, tt.blocked, tt.reason, tt.risk)
+		}
+	}
+
+	if err := rf.SetRuleActions(map[string]Action{"sqli-tautology": "drop"}); err == nil {
+		t.Error("SetRuleActions() accepted an unknown action")
+	}
+}
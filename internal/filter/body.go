@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBodyLimit is how much of a body is inspected when no limit is set
+const DefaultBodyLimit = 64 << 10
+
+// SetBodyInspection turns scanning of POST, PUT and PATCH bodies against
+// the malicious patterns on, looking at up to limit bytes of each, or off
+// with a limit of 0 or less
+func (rf *RequestFilter) SetBodyInspection(enabled bool, limit int64) {
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultBodyLimit
+	}
+	rf.inspectBody = enabled
+	rf.bodyLimit = limit
+}
+
+// lazyBody reads the head of a request's body the first time it is needed
+// and puts it back, so handlers still read the whole body
+type lazyBody struct {
+	req   *http.Request
+	limit int64
+	data  []byte
+	read  bool
+}
+
+func (b *lazyBody) bytes() []byte {
+	if !b.read {
+		b.data, b.read = peekBody(b.req, b.limit), true
+	}
+	return b.data
+}
+
+// seed hands the body the data already read from the request, so it is
+// not read twice
+func (b *lazyBody) seed(data []byte) {
+	if b.read {
+		return
+	}
+	if int64(len(data)) > b.limit {
+		data = data[:b.limit]
+	}
+	b.data, b.read = data, true
+}
+
+// inspectsBody reports whether a request's body is scanned
+func inspectsBody(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return req.ContentLength != 0
+	}
+	return false
+}
+
+// bodyText returns the values in a body that patterns are matched
+// against, one per line: the decoded fields of a form, the keys and string
+// values of a JSON document, the fields of a multipart form other than
+// files, or the text itself. Bodies of other types, such as images and
+// archives, yield nothing. A body cut short by the size limit is scanned
+// as far as it goes.
+func bodyText(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			return formText(form)
+		}
+		return string(body)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var values []string
+		if err := jsonStrings(json.NewDecoder(bytes.NewReader(body)), &values); err != nil && len(values) == 0 {
+			return string(body)
+		}
+		return strings.Join(values, "\n")
+	case mediaType == "multipart/form-data":
+		return multipartText(body, params["boundary"])
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"), mediaType == "":
+		return string(body)
+	}
+	return ""
+}
+
+func formText(form url.Values) string {
+	var b strings.Builder
+	for key, values := range form {
+		b.WriteString(key)
+		for _, value := range values {
+			b.WriteByte('\n')
+			b.WriteString(value)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// jsonStrings appends the keys and string values of a JSON document
+func jsonStrings(dec *json.Decoder, values *[]string) error {
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s, ok := token.(string); ok {
+			*values = append(*values, s)
+		}
+	}
+}
+
+// multipartText returns the fields of a multipart form, skipping files
+func multipartText(body []byte, boundary string) string {
+	if boundary == "" {
+		return ""
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	var b strings.Builder
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			continue
+		}
+		b.WriteString(part.FormName())
+		b.WriteByte('\n')
+		value, _ := io.ReadAll(part)
+		b.Write(value)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyInspection(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	rf.SetBodyInspection(true, 1024)
+
+	var upload bytes.Buffer
+	w := multipart.NewWriter(&upload)
+	w.WriteField("comment", "<script>alert(1)</script>")
+	w.Close()
+
+	var file bytes.Buffer
+	fw := multipart.NewWriter(&file)
+	part, _ := fw.CreateFormFile("avatar", "me.png")
+	part.Write([]byte("<script>alert(1)</script>"))
+	fw.Close()
+
+	tests := []struct {
+		name, method, contentType, body string
+		blocked                         bool
+	}{
+		{"form", "POST", "application/x-www-form-urlencoded", "q=x%27+OR+1%3D1--", true},
+		{"json", "PUT", "application/json", `{"path": "../../etc/passwd"}`, true},
+		{"json key", "POST", "application/vnd.api+json", `{"<script>x</script>": 1}`, true},
+		{"multipart field", "POST", w.FormDataContentType(), upload.String(), true},
+		{"multipart file", "POST", fw.FormDataContentType(), file.String(), false},
+		{"binary", "POST", "image/png", "<script>alert(1)</script>", false},
+		{"clean json", "POST", "application/json", `{"name": "alice", "age": 30}`, false},
+		{"get", "GET", "application/json", `{"path": "../../etc/passwd"}`, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/items", strings.NewReader(tt.body))
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Content-Type", tt.contentType)
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Blocked != tt.blocked {
+			t.Errorf("%s: blocked %v (%s), want %v", tt.name, result.Blocked, result.Reason, tt.blocked)
+		}
+		if body, _ := io.ReadAll(req.Body); string(body) != tt.body {
+			t.Errorf("%s: handler reads %q, want the whole body", tt.name, body)
+		}
+	}
+}
+
+func TestBodyInspectionIsOptIn(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	req := httptest.NewRequest("POST", "/api/items", strings.NewReader(`{"path": "../../etc/passwd"}`))
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Content-Type", "application/json")
+	if result := rf.FilterRequest(context.Background(), req); result.Blocked {
+		t.Errorf("body scanned without inspection enabled: %s", result.Reason)
+	}
+}
+
+func TestBodyInspectionLimit(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	rf.SetBodyInspection(true, 16)
+
+	body := `{"padding": "` + strings.Repeat("a", 32) + `", "path": "../../etc/passwd"}`
+	req := httptest.NewRequest("POST", "/api/items", strings.NewReader(body))
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Content-Type", "application/json")
+	if result := rf.FilterRequest(context.Background(), req); result.Blocked {
+		t.Errorf("matched past the inspection limit: %s", result.Reason)
+	}
+	if got, _ := io.ReadAll(req.Body); string(got) != body {
+		t.Errorf("handler reads %q, want the whole body", got)
+	}
+}
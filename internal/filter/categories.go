@@ -0,0 +1,191 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Attack categories of the malicious patterns and the request method check
+const (
+	CategorySQLi        = "sqli"
+	CategoryXSS         = "xss"
+	CategoryTraversal   = "traversal"
+	CategoryRCE         = "rce"
+	CategoryFileProbe   = "file_probe"
+	CategoryScanner     = "scanner"
+	CategoryMethodProbe = "method_probe"
+	// CategoryCustom is the category of rules added at runtime whose ID
+	// does not start with that of another category, like "sqli-"
+	CategoryCustom = "custom"
+)
+
+var detectionCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_filter_detections_total",
+	Help: "Attacks detected by the request filter, by category and the action taken",
+}, []string{"category", "action"})
+
+// CategoryPolicy is how detections of an attack category are handled. A
+// detection is the category matching the URL, the body or the method of a
+// request; each adds Score to the request's risk score and to the
+// category's score. Once the category's score reaches Threshold the
+// request gets Action, flagged until then; a Threshold of 0 takes Action
+// on the first detection. Rules with an action of their own take it
+// instead.
+type CategoryPolicy struct {
+	Action    Action `json:"action"`
+	Score     int    `json:"score"`
+	Threshold int    `json:"threshold"`
+}
+
+// defaultCategories block attacks outright and score unusual methods
+var defaultCategories = map[string]CategoryPolicy{
+	CategorySQLi:        {Action: ActionBlock, Score: 80},
+	CategoryXSS:         {Action: ActionBlock, Score: 80},
+	CategoryTraversal:   {Action: ActionBlock, Score: 80},
+	CategoryRCE:         {Action: ActionBlock, Score: 80},
+	CategoryFileProbe:   {Action: ActionBlock, Score: 80},
+	CategoryScanner:     {Action: ActionBlock, Score: 80},
+	CategoryMethodProbe: {Action: ActionFlag, Score: 15},
+	CategoryCustom:      {Action: ActionBlock, Score: 80},
+}
+
+// crsCategories maps the attack tags of the OWASP Core Rule Set to
+// categories
+var crsCategories = map[string]string{
+	"attack-sqli":               CategorySQLi,
+	"attack-xss":                CategoryXSS,
+	"attack-lfi":                CategoryTraversal,
+	"attack-rce":                CategoryRCE,
+	"attack-rfi":                CategoryRCE,
+	"attack-injection-php":      CategoryRCE,
+	"attack-reputation-scanner": CategoryScanner,
+}
+
+// categoryOf returns the category of a rule added at runtime, named by
+// the start of its ID
+func categoryOf(id string) string {
+	prefix, _, _ := strings.Cut(id, "-")
+	if _, ok := defaultCategories[prefix]; ok {
+		return prefix
+	}
+	return CategoryCustom
+}
+
+// SetCategoryPolicies overrides the handling of attack categories by name.
+// Unset fields keep their defaults.
+func (rf *RequestFilter) SetCategoryPolicies(policies map[string]CategoryPolicy) error {
+	merged := make(map[string]CategoryPolicy, len(defaultCategories))
+	for category, policy := range defaultCategories {
+		merged[category] = policy
+	}
+	for category, policy := range policies {
+		def, ok := defaultCategories[category]
+		if !ok {
+			return fmt.Errorf("unknown category %q", category)
+		}
+		if policy.Action == "" {
+			policy.Action = def.Action
+		}
+		if err := ruleAction(policy.Action); err != nil {
+			return fmt.Errorf("category %s: %v", category, err)
+		}
+		if policy.Score < 0 || policy.Threshold < 0 {
+			return fmt.Errorf("category %s: negative score or threshold", category)
+		}
+		if policy.Score == 0 {
+			policy.Score = def.Score
+		}
+		merged[category] = policy
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.categories = merged
+	return nil
+}
+
+// CategoryPolicies returns the handling of each attack category
+func (rf *RequestFilter) CategoryPolicies() map[string]CategoryPolicy {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	policies := make(map[string]CategoryPolicy, len(rf.categories))
+	for category, policy := range rf.categories {
+		policies[category] = policy
+	}
+	return policies
+}
+
+// detection is an attack category matching part of a request. action is
+// the strictest action of its rules that override the category's, and
+// byCategory whether any of them take the category's.
+type detection struct {
+	category   string
+	action     Action
+	byCategory bool
+}
+
+type detections []detection
+
+// add records a match of a rule of category, which takes action if
+// overridden
+func (ds detections) add(category string, action Action, overridden bool) detections {
+	i := 0
+	for i < len(ds) && ds[i].category != category {
+		i++
+	}
+	if i == len(ds) {
+		ds = append(ds, detection{category: category})
+	}
+	if overridden {
+		ds[i].action = stricter(ds[i].action, action)
+	} else {
+		ds[i].byCategory = true
+	}
+	return ds
+}
+
+// detect scores detections by the policies of their categories, adding
+// to the categories' scores for the request, and reports whether
+// filtering stops here
+func (result *FilterResult) detect(ds detections, policies map[string]CategoryPolicy, scores map[string]int) bool {
+	var action Action
+	score := 0
+	for _, d := range ds {
+		policy, ok := policies[d.category]
+		if !ok {
+			policy = policies[CategoryCustom]
+		}
+		scores[d.category] += policy.Score
+		taken := d.action
+		if d.byCategory {
+			byCategory := policy.Action
+			if scores[d.category] < policy.Threshold && strictness[byCategory] > strictness[ActionFlag] {
+				byCategory = ActionFlag
+			}
+			taken = stricter(taken, byCategory)
+		}
+		if taken != ActionLog {
+			score += policy.Score
+		}
+		action = stricter(action, taken)
+		result.record(d.category, taken)
+	}
+	return result.apply(action, score)
+}
+
+// record reports a detection of category, handled with action, in the
+// result and the metrics
+func (result *FilterResult) record(category string, action Action) {
+	detectionCounter.WithLabelValues(category, string(action)).Inc()
+	for _, c := range result.Categories {
+		if c == category {
+			return
+		}
+	}
+	result.Categories = append(result.Categories, category)
+}
@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCategoryPolicies(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	err := rf.SetCategoryPolicies(map[string]CategoryPolicy{
+		CategoryMethodProbe: {Action: ActionLog},
+		CategoryXSS:         {Score: 30, Threshold: 60},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.SetRuleActions(map[string]Action{"traversal-windows": ActionFlag}); err != nil {
+		t.Fatal(err)
+	}
+	rf.AddRule("sqli-stacked", `;\s*shutdown`)
+
+	tests := []struct {
+		method, target, body string
+		action               Action
+		risk                 int
+		categories           []string
+	}{
+		{"GET", "/items?id=1;shutdown", "", ActionBlock, 80, []string{CategorySQLi}},
+		{"OPTIONS", "/items", "", ActionAllow, 0, []string{CategoryMethodProbe}},
+		{"GET", "/go?to=javascript:x", "", ActionAllow, 30, []string{CategoryXSS}},
+		{"POST", "/go?to=javascript:x", "javascript:x", ActionBlock, 60, []string{CategoryXSS}},
+		{"GET", `/files/..\win.ini`, "", ActionAllow, 80, []string{CategoryTraversal}},
+		{"GET", "/items", "", ActionAllow, 0, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Content-Type", "text/plain")
+		ctx := WithPolicy(context.Background(), Policy{InspectBody: true})
+		result := rf.FilterRequest(ctx, req)
+		if result.Action != tt.action || result.RiskScore != tt.risk || !reflect.DeepEqual(result.Categories, tt.categories) {
+			t.Errorf("%s %s: %s, risk %d, categories %v (%s)",
+				tt.method, tt.target, result.Action, result.RiskScore, result.Categories, result.Reason)
+		}
+	}
+
+	invalid := []map[string]CategoryPolicy{
+		{"csrf": {Action: ActionBlock}},
+		{CategorySQLi: {Action: "drop"}},
+		{CategorySQLi: {Threshold: -1}},
+	}
+	for _, policies := range invalid {
+		if err := rf.SetCategoryPolicies(policies); err == nil {
+			t.Errorf("SetCategoryPolicies(%v) accepted", policies)
+		}
+	}
+}
+
+func TestCategoryOf(t *testing.T) {
+	for id, want := range map[string]string{
+		"sqli-stacked":   CategorySQLi,
+		"xss-svg-onload": CategoryXSS,
+		"wp-admin-probe": CategoryCustom,
+		"sqli":           CategorySQLi,
+	} {
+		if got := categoryOf(id); got != want {
+			t.Errorf("categoryOf(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
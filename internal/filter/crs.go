@@ -0,0 +1,307 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// crsRulePrefix sets the IDs of rules loaded from ModSecurity rule files
+// apart from those of the rules file
+const crsRulePrefix = "crs:"
+
+// crsVariables maps the ModSecurity variables understood to the targets
+// they are matched in. Collections of argument names are matched with the
+// values, as both appear in the query and body.
+var crsVariables = map[string][]string{
+	"REQUEST_URI":           {TargetPath, TargetQuery},
+	"REQUEST_URI_RAW":       {TargetPath, TargetQuery},
+	"REQUEST_LINE":          {TargetPath, TargetQuery},
+	"REQUEST_FILENAME":      {TargetPath},
+	"REQUEST_BASENAME":      {TargetPath},
+	"QUERY_STRING":          {TargetQuery},
+	"ARGS_GET":              {TargetQuery},
+	"ARGS_GET_NAMES":        {TargetQuery},
+	"ARGS":                  {TargetQuery, TargetBody},
+	"ARGS_NAMES":            {TargetQuery, TargetBody},
+	"ARGS_POST":             {TargetBody},
+	"ARGS_POST_NAMES":       {TargetBody},
+	"REQUEST_BODY":          {TargetBody},
+	"XML":                   {TargetBody},
+	"REQUEST_HEADERS":       {TargetHeader},
+	"REQUEST_COOKIES":       {TargetHeader},
+	"REQUEST_COOKIES_NAMES": {TargetHeader},
+}
+
+// crsSeverities maps ModSecurity severities, by name or number, to ours
+var crsSeverities = map[string]string{
+	"EMERGENCY": "critical", "0": "critical",
+	"ALERT": "critical", "1": "critical",
+	"CRITICAL": "critical", "2": "critical",
+	"ERROR": "high", "3": "high",
+	"WARNING": "medium", "4": "medium",
+	"NOTICE": "low", "5": "low",
+	"INFO": "low", "6": "low",
+	"DEBUG": "low", "7": "low",
+}
+
+// ParseSecRules loads the subset of a ModSecurity rule file, such as one of
+// the OWASP Core Rule Set, that maps onto the filter: SecRule directives
+// with the @rx, @pm or @streq operator on the request URI, arguments,
+// headers, cookies and body. Rules that deny or drop become block rules;
+// those that only block or pass, as the CRS does in anomaly scoring mode,
+// become flag rules whose severities add up to the risk score.
+// t:lowercase makes a rule case-insensitive; other transformations are
+// not applied. Chained rules, negated or other operators, unsupported
+// variables and patterns RE2 cannot compile are skipped and returned with
+// the reason.
+func ParseSecRules(data []byte) ([]FileRule, []string, error) {
+	directives, err := secDirectives(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rules []FileRule
+	var skipped []string
+	inChain := false
+	for _, args := range directives {
+		if !strings.EqualFold(args[0], "SecRule") {
+			continue
+		}
+		if len(args) < 3 {
+			return nil, nil, fmt.Errorf("SecRule needs variables and an operator: %s", strings.Join(args, " "))
+		}
+		var actions map[string][]string
+		if len(args) > 3 {
+			actions = secActions(args[3])
+		}
+
+		// A chain is only whole with the rules after it; skip them all
+		_, chained := actions["chain"]
+		if inChain {
+			inChain = chained
+			continue
+		}
+		inChain = chained
+
+		id := first(actions["id"])
+		if id == "" {
+			skipped = append(skipped, "rule without id")
+			continue
+		}
+		if chained {
+			skipped = append(skipped, id+": chained rule")
+			continue
+		}
+
+		rule, reason := secRule(id, args[1], args[2], actions)
+		if reason != "" {
+			skipped = append(skipped, id+": "+reason)
+			continue
+		}
+		if _, err := compileRule(&rule); err != nil {
+			skipped = append(skipped, id+": "+err.Error())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, skipped, nil
+}
+
+// secRule converts a SecRule, or returns why it cannot be
+func secRule(id, variables, operator string, actions map[string][]string) (FileRule, string) {
+	rule := FileRule{
+		ID:          crsRulePrefix + id,
+		Description: first(actions["msg"]),
+		Action:      ActionFlag,
+	}
+	if _, deny := actions["deny"]; deny {
+		rule.Action = ActionBlock
+	}
+	if _, drop := actions["drop"]; drop {
+		rule.Action = ActionBlock
+	}
+	if severity := first(actions["severity"]); severity != "" {
+		rule.Severity = crsSeverities[strings.ToUpper(severity)]
+	}
+	for _, tag := range actions["tag"] {
+		if category, ok := crsCategories[strings.ToLower(tag)]; ok {
+			rule.Category = category
+			break
+		}
+	}
+
+	targets := make(map[string]bool)
+	allHeaders := false
+	for _, variable := range strings.Split(variables, "|") {
+		if strings.HasPrefix(variable, "!") || strings.HasPrefix(variable, "&") {
+			continue // exclusions and counts
+		}
+		name, selector, _ := strings.Cut(variable, ":")
+		name = strings.ToUpper(name)
+		for _, target := range crsVariables[name] {
+			targets[target] = true
+		}
+		switch {
+		case strings.HasPrefix(name, "REQUEST_COOKIES"):
+			rule.Headers = append(rule.Headers, "Cookie")
+		case name == "REQUEST_HEADERS" && selector != "" && !strings.HasPrefix(selector, "/"):
+			rule.Headers = append(rule.Headers, selector)
+		case name == "REQUEST_HEADERS":
+			allHeaders = true
+		}
+	}
+	if len(targets) == 0 {
+		return rule, "no supported variables in " + variables
+	}
+	if allHeaders {
+		rule.Headers = nil
+	}
+	for _, target := range []string{TargetPath, TargetQuery, TargetHeader, TargetBody} {
+		if targets[target] {
+			rule.Targets = append(rule.Targets, target)
+		}
+	}
+
+	caseless := false
+	for _, transform := range actions["t"] {
+		if strings.EqualFold(transform, "lowercase") {
+			caseless = true
+		}
+	}
+
+	op, arg := "rx", operator
+	if strings.HasPrefix(operator, "!") {
+		return rule, "negated operator"
+	}
+	if strings.HasPrefix(operator, "@") {
+		op, arg, _ = strings.Cut(operator[1:], " ")
+	}
+	switch op {
+	case "rx":
+		rule.Pattern = arg
+	case "pm":
+		words := strings.Fields(arg)
+		if len(words) == 0 {
+			return rule, "@pm without phrases"
+		}
+		for i, word := range words {
+			words[i] = regexp.QuoteMeta(word)
+		}
+		rule.Pattern = "(?:" + strings.Join(words, "|") + ")"
+		caseless = true
+	case "streq":
+		rule.Pattern = "^" + regexp.QuoteMeta(arg) + "$"
+	default:
+		return rule, "unsupported operator @" + op
+	}
+	if caseless && !strings.HasPrefix(rule.Pattern, "(?i)") {
+		rule.Pattern = "(?i)" + rule.Pattern
+	}
+	return rule, ""
+}
+
+// secDirectives splits a rule file into directives, each a list of
+// arguments with their quotes removed. Lines ending in a backslash
+// continue on the next; lines starting with # are comments.
+func secDirectives(text string) ([][]string, error) {
+	var directives [][]string
+	var line strings.Builder
+	for n, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		if line.Len() == 0 && strings.HasPrefix(strings.TrimSpace(raw), "#") {
+			continue
+		}
+		if strings.HasSuffix(raw, "\\") {
+			line.WriteString(strings.TrimSuffix(raw, "\\"))
+			continue
+		}
+		line.WriteString(raw)
+
+		args, err := secArgs(line.String())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		if len(args) > 0 {
+			directives = append(directives, args)
+		}
+		line.Reset()
+	}
+	return directives, nil
+}
+
+// secArgs splits a directive into its arguments. Double quotes group an
+// argument, and a backslash before a quote escapes it; other backslashes
+// are kept for the regexp.
+func secArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '"':
+			arg.WriteByte('"')
+			inArg = true
+			i++
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// secActions parses a rule's comma-separated actions into their values by
+// name. Single quotes group a value containing commas.
+func secActions(list string) map[string][]string {
+	actions := make(map[string][]string)
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	parts = append(parts, part.String())
+
+	for _, p := range parts {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), ":")
+		if name == "" {
+			continue
+		}
+		name = strings.ToLower(name)
+		actions[name] = append(actions[name], strings.TrimSpace(value))
+	}
+	return actions
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,118 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecRules = `
+# Scanner detection
+SecRule REQUEST_HEADERS:User-Agent "@pm nikto sqlmap" \
+    "id:913100,\
+    phase:1,\
+    block,\
+    msg:'Found User-Agent associated with security scanner',\
+    severity:'CRITICAL'"
+
+SecRule REQUEST_FILENAME "@streq /server-status" \
+    "id:920999,phase:1,deny,t:none,t:lowercase,severity:'WARNING'"
+
+SecRule ARGS|!ARGS:foo "@rx (?i)union\s+select" "id:942100,phase:2,block,severity:'CRITICAL',msg:'SQL Injection, UNION',tag:'application-multi',tag:'attack-sqli'"
+
+SecRule REQUEST_BASENAME "@rx \.(?=bak)" "id:920440,phase:1,deny"
+
+SecRule TX:EXECUTING_PARANOIA_LEVEL "@lt 2" "id:942011,phase:1,pass,nolog,skipAfter:END"
+
+SecRule ARGS "@detectSQLi" "id:942101,phase:2,block"
+
+SecRule REQUEST_METHOD "@streq POST" "id:900001,phase:1,chain,deny"
+    SecRule REQUEST_HEADERS:Content-Type "@rx ^$" "t:none"
+
+SecAction "id:900990,phase:1,nolog,pass,setvar:tx.crs_setup_version=332"
+`
+
+func TestParseSecRules(t *testing.T) {
+	rules, skipped, err := ParseSecRules([]byte(testSecRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileRule{
+		{ID: "crs:913100", Description: "Found User-Agent associated with security scanner", Pattern: "(?i)(?:nikto|sqlmap)",
+			Action: ActionFlag, Severity: "critical", Targets: []string{TargetHeader}, Headers: []string{"User-Agent"}},
+		{ID: "crs:920999", Pattern: `(?i)^/server-status$`,
+			Action: ActionBlock, Severity: "medium", Targets: []string{TargetPath}},
+		{ID: "crs:942100", Description: "SQL Injection, UNION", Pattern: `(?i)union\s+select`,
+			Action: ActionFlag, Severity: "critical", Targets: []string{TargetQuery, TargetBody}, Category: CategorySQLi},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("parsed %d rules %+v, want %d", len(rules), rules, len(want))
+	}
+	for i := range want {
+		got := rules[i]
+		if got.ID != want[i].ID || got.Description != want[i].Description || got.Pattern != want[i].Pattern ||
+			got.Action != want[i].Action || got.Severity != want[i].Severity || got.Category != want[i].Category ||
+			strings.Join(got.Targets, ",") != strings.Join(want[i].Targets, ",") ||
+			strings.Join(got.Headers, ",") != strings.Join(want[i].Headers, ",") {
+			t.Errorf("rule %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// The lookahead, the TX variable, the operator and the chain
+	if len(skipped) != 4 {
+		t.Errorf("skipped %v, want four rules", skipped)
+	}
+	for i, id := range []string{"920440", "942011", "942101", "900001"} {
+		if i < len(skipped) && !strings.HasPrefix(skipped[i], id+": ") {
+			t.Errorf("skipped[%d] = %q, want rule %s", i, skipped[i], id)
+		}
+	}
+}
+
+func TestParseSecRulesRejectsUnterminatedQuote(t *testing.T) {
+	if _, _, err := ParseSecRules([]byte(`SecRule ARGS "@rx foo "id:1,deny"`)); err == nil {
+		t.Error("ParseSecRules() accepted an unterminated quote")
+	}
+}
+
+func TestLoadRulesWithSecRules(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	crsPath := filepath.Join(dir, "REQUEST-942-APPLICATION-ATTACK-SQLI.conf")
+	os.WriteFile(rulesPath, []byte(testRules), 0o644)
+	os.WriteFile(crsPath, []byte(testSecRules), 0o644)
+
+	rf := NewRequestFilter(1<<20, nil, nil)
+	if loaded, err := rf.LoadRules(rulesPath, crsPath); err != nil || !loaded {
+		t.Fatalf("LoadRules() = %v, %v", loaded, err)
+	}
+	if n := len(rf.CustomRules()); n != 6 {
+		t.Errorf("loaded %d rules, want 6", n)
+	}
+	if n := len(rf.SkippedRules()); n != 4 {
+		t.Errorf("skipped %d rules, want 4", n)
+	}
+
+	req := httptest.NewRequest("GET", "/server-status", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	if result := rf.FilterRequest(context.Background(), req); !result.Blocked || result.Reason != "Blocked by rule crs:920999" {
+		t.Errorf("deny rule: blocked %v (%s)", result.Blocked, result.Reason)
+	}
+
+	// Critical flags add up past the block threshold, as anomaly scoring does
+	req = httptest.NewRequest("POST", "/search", strings.NewReader(`{"q":"1 union select password"}`))
+	req.Header.Set("User-Agent", "sqlmap/1.7")
+	if result := rf.FilterRequest(context.Background(), req); !result.Blocked {
+		t.Errorf("two critical flags allowed: %s (risk %d)", result.Reason, result.RiskScore)
+	}
+
+	// The same ID in two files is refused
+	os.WriteFile(rulesPath, []byte("rules:\n  - id: 'crs:942100'\n    pattern: x\n"), 0o644)
+	if _, err := rf.LoadRules(rulesPath, crsPath); err == nil {
+		t.Error("LoadRules() accepted a duplicate rule ID")
+	}
+}
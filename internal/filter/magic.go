@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// signatures are the types content sniffing recognizes by their magic
+// bytes; a file claiming one of them must start with its signature
+var signatures = map[string]bool{
+	"image/png":          true,
+	"image/jpeg":         true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"image/bmp":          true,
+	"image/x-icon":       true,
+	"application/pdf":    true,
+	"application/zip":    true,
+	"application/x-gzip": true,
+	"application/wasm":   true,
+	"audio/mpeg":         true,
+	"audio/wave":         true,
+	"video/mp4":          true,
+	"video/webm":         true,
+	"font/woff":          true,
+	"font/woff2":         true,
+}
+
+// typeAliases map the types files are declared as to the one sniffing
+// yields for their content
+var typeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/vnd.microsoft.icon":     "image/x-icon",
+	"application/gzip":             "application/x-gzip",
+	"application/x-zip-compressed": "application/zip",
+	"application/java-archive":     "application/zip",
+	"application/epub+zip":         "application/zip",
+	"audio/wav":                    "audio/wave",
+	"audio/x-wav":                  "audio/wave",
+}
+
+// executables are the magic bytes of native executables and scripts
+var executables = [][]byte{
+	[]byte("MZ"),               // Windows PE
+	[]byte("\x7fELF"),          // ELF
+	[]byte("\xfe\xed\xfa\xce"), // Mach-O
+	[]byte("\xfe\xed\xfa\xcf"),
+	[]byte("\xce\xfa\xed\xfe"),
+	[]byte("\xcf\xfa\xed\xfe"),
+	[]byte("\xca\xfe\xba\xbe"), // Mach-O universal, Java class
+	[]byte("#!"),               // script
+}
+
+// serverScript opens PHP code, which a server may run when a file with a
+// misleading name is served, as in GIF89a<?php ...
+var serverScript = []byte("<?php")
+
+// verifyMagic checks that a file's content is what its extension and
+// declared type say it is, and is neither an executable nor embeds PHP
+// code
+func verifyMagic(name, declared string, content []byte) error {
+	for _, magic := range executables {
+		if bytes.HasPrefix(content, magic) {
+			return fmt.Errorf("executable content")
+		}
+	}
+	if bytes.Contains(bytes.ToLower(content), serverScript) {
+		return fmt.Errorf("embedded server-side script")
+	}
+
+	var expected []string
+	for _, t := range []string{declared, mime.TypeByExtension(path.Ext(name))} {
+		if t, _, err := mime.ParseMediaType(t); err == nil && t != "application/octet-stream" {
+			expected = append(expected, canonicalType(t))
+		}
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+
+	if signatures[sniffed] {
+		if len(expected) > 0 && !contains(expected, sniffed) {
+			return fmt.Errorf("content is %s, not %s", sniffed, expected[0])
+		}
+		return nil
+	}
+	for _, t := range expected {
+		if signatures[t] {
+			return fmt.Errorf("content is %s, not %s", sniffed, t)
+		}
+	}
+	return nil
+}
+
+// canonicalType returns the type content sniffing yields for files of a
+// type, such as application/zip for office documents
+func canonicalType(t string) string {
+	t = strings.ToLower(t)
+	if alias, ok := typeAliases[t]; ok {
+		return alias
+	}
+	if strings.HasPrefix(t, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(t, "application/vnd.oasis.opendocument.") {
+		return "application/zip"
+	}
+	return t
+}
@@ -0,0 +1,329 @@
+package filter
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// matcher picks out the rules worth running against an input. Most
+// patterns can only match text containing one of a few literal fragments,
+// such as "javascript:" or "../", so the fragments of all rules are looked
+// for in a single pass with an Aho-Corasick automaton, and only the rules
+// whose fragments turn up, plus those without any, have their regular
+// expression run. The cost of a pass does not grow with the number of
+// rules.
+type matcher struct {
+	size   int
+	always []int
+	ac     *ahoCorasick
+}
+
+// candidates is the set of rules, by index, that may match an input
+type candidates []uint64
+
+func (c candidates) has(i int) bool {
+	return c[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+func (c candidates) set(i int) {
+	c[i/64] |= 1 << (uint(i) % 64)
+}
+
+// newMatcher indexes the literal fragments of patterns
+func newMatcher(patterns []*regexp.Regexp) *matcher {
+	m := &matcher{size: len(patterns)}
+	var literals []string
+	var owners []int
+	for i, re := range patterns {
+		fragments := requiredLiterals(re)
+		if fragments == nil {
+			m.always = append(m.always, i)
+			continue
+		}
+		for _, fragment := range fragments {
+			literals = append(literals, fragment)
+			owners = append(owners, i)
+		}
+	}
+	m.ac = newAhoCorasick(literals, owners)
+	return m
+}
+
+// scan returns the rules that may match an input, as received or
+// normalized
+func (m *matcher) scan(input canonical) candidates {
+	hits := make(candidates, (m.size+63)/64)
+	for _, i := range m.always {
+		hits.set(i)
+	}
+	m.ac.feed(input.raw, hits)
+	if input.normalized != input.raw {
+		m.ac.feed(input.normalized, hits)
+	}
+	return hits
+}
+
+// requiredLiterals returns case-folded fragments one of which occurs in
+// any text a pattern matches, or nil if there are none to rely on
+func requiredLiterals(re *regexp.Regexp) []string {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return literalsOf(parsed.Simplify())
+}
+
+func literalsOf(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{foldString(string(re.Rune))}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalsOf(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return literalsOf(re.Sub[0])
+		}
+	case syntax.OpAlternate:
+		var set []string
+		for _, sub := range re.Sub {
+			fragments := literalsOf(sub)
+			if fragments == nil {
+				return nil
+			}
+			set = append(set, fragments...)
+		}
+		return set
+	case syntax.OpConcat:
+		// Any one part must occur; the one with the longest fragments
+		// rules out the most text. Runs of parts matching a few fixed
+		// strings, like "n" and "(map|ikto)", combine into one part.
+		var best []string
+		run := []string{""}
+		for _, sub := range re.Sub {
+			if fixed := fixedStrings(sub); fixed != nil {
+				if combined := product(run, fixed); combined != nil {
+					run = combined
+					continue
+				}
+				best = selective(best, run)
+				run = fixed
+				continue
+			}
+			best = selective(best, run)
+			run = []string{""}
+			best = selective(best, literalsOf(sub))
+		}
+		return selective(best, run)
+	}
+	return nil
+}
+
+// maxFixed bounds the strings fixedStrings and product expand a pattern to
+const maxFixed = 64
+
+// fixedStrings returns the case-folded strings a pattern matches if they
+// are few, or nil
+func fixedStrings(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{foldString(string(re.Rune))}
+	case syntax.OpEmptyMatch:
+		return []string{""}
+	case syntax.OpCapture:
+		return fixedStrings(re.Sub[0])
+	case syntax.OpQuest:
+		if fixed := fixedStrings(re.Sub[0]); fixed != nil && len(fixed) < maxFixed {
+			return append([]string{""}, fixed...)
+		}
+	case syntax.OpAlternate:
+		var set []string
+		for _, sub := range re.Sub {
+			fixed := fixedStrings(sub)
+			if fixed == nil || len(set)+len(fixed) > maxFixed {
+				return nil
+			}
+			set = append(set, fixed...)
+		}
+		return set
+	case syntax.OpConcat:
+		set := []string{""}
+		for _, sub := range re.Sub {
+			if set = product(set, fixedStrings(sub)); set == nil {
+				return nil
+			}
+		}
+		return set
+	}
+	return nil
+}
+
+// product returns each of a followed by each of b, or nil if b is nil or
+// there would be too many
+func product(a, b []string) []string {
+	if b == nil || len(a)*len(b) > maxFixed {
+		return nil
+	}
+	set := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			set = append(set, x+y)
+		}
+	}
+	return set
+}
+
+// selective returns the set of fragments less likely to occur by chance:
+// the one whose shortest fragment is longer, then the smaller one. A set
+// with the empty string rules nothing out.
+func selective(a, b []string) []string {
+	if b == nil || shortest(b) == 0 {
+		return a
+	}
+	if a == nil {
+		return b
+	}
+	if shortest(b) > shortest(a) || shortest(b) == shortest(a) && len(b) < len(a) {
+		return b
+	}
+	return a
+}
+
+func shortest(set []string) int {
+	n := -1
+	for _, s := range set {
+		if n < 0 || len(s) < n {
+			n = len(s)
+		}
+	}
+	return n
+}
+
+// foldRune maps a rune to the smallest rune it equals ignoring case, so
+// that folded text contains a folded fragment whenever a case-insensitive
+// pattern could match it
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		return r
+	}
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+func foldString(s string) string {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		buf = utf8.AppendRune(buf, foldRune(r))
+	}
+	return string(buf)
+}
+
+// ahoCorasick finds all occurrences of a set of fragments in one pass over
+// a text. Its transitions are a table over classes of bytes, those that
+// appear in no fragment sharing class 0.
+type ahoCorasick struct {
+	classes [256]int32
+	width   int32
+	next    []int32
+	owners  [][]int
+}
+
+// newAhoCorasick builds the automaton of literals, each found for the rule
+// owning it
+func newAhoCorasick(literals []string, owners []int) *ahoCorasick {
+	ac := &ahoCorasick{width: 1}
+	for _, literal := range literals {
+		for i := 0; i < len(literal); i++ {
+			if ac.classes[literal[i]] == 0 {
+				ac.classes[literal[i]] = ac.width
+				ac.width++
+			}
+		}
+	}
+
+	// The trie of the literals, with -1 for missing transitions
+	ac.addState()
+	for i, literal := range literals {
+		state := int32(0)
+		for j := 0; j < len(literal); j++ {
+			slot := state*ac.width + ac.classes[literal[j]]
+			if ac.next[slot] < 0 {
+				ac.next[slot] = ac.addState()
+			}
+			state = ac.next[slot]
+		}
+		ac.owners[state] = append(ac.owners[state], owners[i])
+	}
+
+	// Breadth first, fill in missing transitions with those of the
+	// longest proper suffix in the trie, and inherit its matches
+	fail := make([]int32, len(ac.owners))
+	var queue []int32
+	for c := int32(0); c < ac.width; c++ {
+		if child := ac.next[c]; child < 0 {
+			ac.next[c] = 0
+		} else {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		ac.owners[state] = append(ac.owners[state], ac.owners[fail[state]]...)
+		for c := int32(0); c < ac.width; c++ {
+			slot := state*ac.width + c
+			fallback := ac.next[fail[state]*ac.width+c]
+			if child := ac.next[slot]; child < 0 {
+				ac.next[slot] = fallback
+			} else {
+				fail[child] = fallback
+				queue = append(queue, child)
+			}
+		}
+	}
+	return ac
+}
+
+func (ac *ahoCorasick) addState() int32 {
+	state := int32(len(ac.owners))
+	for c := int32(0); c < ac.width; c++ {
+		ac.next = append(ac.next, -1)
+	}
+	ac.owners = append(ac.owners, nil)
+	return state
+}
+
+// feed runs a text, case-folded, through the automaton, adding the owners
+// of the fragments found to hits
+func (ac *ahoCorasick) feed(text string, hits candidates) {
+	state := int32(0)
+	step := func(b byte) {
+		state = ac.next[state*ac.width+ac.classes[b]]
+		for _, owner := range ac.owners[state] {
+			hits.set(owner)
+		}
+	}
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(text); {
+		if b := text[i]; b < utf8.RuneSelf {
+			step(byte(foldRune(rune(b))))
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		n := utf8.EncodeRune(buf[:], foldRune(r))
+		for _, b := range buf[:n] {
+			step(b)
+		}
+		i += size
+	}
+}
@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{`(?i)javascript:`, []string{"JAVASCRIPT:"}},
+		{`\.\./`, []string{"../"}},
+		{`(?i)(nmap|nikto|sqlmap)`, []string{"NMAP", "NIKTO", "SQLMAP"}},
+		{`(?i)on\w+\s*=`, []string{"ON"}},
+		{`(?i)<script[^>]*>.*</script>`, []string{"</SCRIPT>"}},
+		{`(?i)https?://`, []string{"HTTP://", "HTTPS://"}},
+		{`(?i)straße`, []string{"STRAßE"}},
+		{`a*b?`, nil},
+		{`(foo|\d+)`, nil},
+	}
+	for _, tt := range tests {
+		got := requiredLiterals(regexp.MustCompile(tt.pattern))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requiredLiterals(%s) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherFindsEveryMatch(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`(?i)ſelect\s+\*`),
+		regexp.MustCompile(`\x{212A}elvin`),
+		regexp.MustCompile(`(?i)union(\s+all)?\s+select`),
+		regexp.MustCompile(`^/admin`),
+		regexp.MustCompile(`[0-9]{6}`),
+	}
+	for _, rule := range rf.maliciousPatterns {
+		patterns = append(patterns, rule.Pattern)
+	}
+	m := newMatcher(patterns)
+
+	inputs := []string{
+		"/index.html",
+		"/search?q=SELECT+name+FROM+users",
+		"/search?q=%53%45%4c%45%43%54%20*%20FROM%20t",
+		"/x?q=select *",
+		"/x?q=SELECT *",
+		"/x?q=Kelvin and kelvin",
+		"/x?q=UnIoN%20aLl%20SeLeCt",
+		"/admin/users",
+		"/files/..%2f..%2fetc/passwd",
+		"/a?b=<ScRiPt>alert(1)</sCrIpT>",
+		"/a?b=ＪＡＶＡＳＣＲＩＰＴ:alert(1)",
+		"/a?onload =x",
+		"/order/123456",
+		"/shell.PHP",
+		"/a?ua=sqlmap/1.5",
+		"\xff\xfe/invalid?utf=8",
+	}
+	for _, text := range inputs {
+		input := newCanonical(text)
+		hits := m.scan(input)
+		for i, re := range patterns {
+			if input.matchedBy(re) && !hits.has(i) {
+				t.Errorf("%q matches %s but was not a candidate", text, re)
+			}
+		}
+	}
+
+	// Clean input rules most patterns out
+	hits := m.scan(newCanonical("/products/42?color=blue"))
+	count := 0
+	for i := range patterns {
+		if hits.has(i) {
+			count++
+		}
+	}
+	if count > 2 {
+		t.Errorf("clean input left %d of %d rules to run", count, len(patterns))
+	}
+}
+
+func BenchmarkFilterManyRules(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			rf := NewRequestFilter(1<<20, nil, nil)
+			for i := 0; i < n; i++ {
+				rf.AddRule(fmt.Sprintf("bench-%d", i), fmt.Sprintf(`(?i)/probe-%d/(admin|config)\.php`, i))
+			}
+			req := httptest.NewRequest("GET", "/products/42?color=blue&size="+strings.Repeat("m", 40), nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rf.matchRules(req.URL.Path+req.URL.RawQuery, "", nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxDecodeRounds bounds how many layers of percent-encoding are peeled
+// off; double encoding is common, more than three layers is not
+const maxDecodeRounds = 3
+
+// Normalize canonicalizes input before patterns are matched against it,
+// undoing the encodings attackers use to slip past them: percent-encoding,
+// repeated up to three times, including IIS-style %uXXXX and + for spaces;
+// overlong UTF-8 encodings of ASCII; null bytes; and, through NFKC, Unicode
+// compatibility forms such as fullwidth letters.
+func Normalize(s string) string {
+	if !needsNormalizing(s) {
+		return s
+	}
+	for i := 0; i < maxDecodeRounds; i++ {
+		decoded := percentDecode(s)
+		if decoded == s {
+			break
+		}
+		s = decoded
+	}
+	s = decodeOverlong(s)
+	s = strings.ReplaceAll(s, "\x00", "")
+	return norm.NFKC.String(s)
+}
+
+// needsNormalizing reports whether s has anything Normalize would change
+func needsNormalizing(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '%' || c == '+' || c == 0 || c >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// percentDecode decodes one layer of percent-encoding, leaving malformed
+// escapes as they are
+func percentDecode(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '+':
+			b.WriteByte(' ')
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case c == '%' && i+5 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') &&
+			isHex(s[i+2]) && isHex(s[i+3]) && isHex(s[i+4]) && isHex(s[i+5]):
+			r := rune(unhex(s[i+2]))<<12 | rune(unhex(s[i+3]))<<8 | rune(unhex(s[i+4]))<<4 | rune(unhex(s[i+5]))
+			b.WriteRune(r)
+			i += 5
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeOverlong replaces overlong two and three byte UTF-8 encodings of
+// ASCII characters, such as C0 AF for /, with the characters; decoders
+// that accept them once let them past path checks
+func decodeOverlong(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case (c == 0xc0 || c == 0xc1) && i+1 < len(s) && isContinuation(s[i+1]):
+			b.WriteByte((c&0x1f)<<6 | s[i+1]&0x3f)
+			i++
+		case c == 0xe0 && i+2 < len(s) && s[i+1] == 0x80 && isContinuation(s[i+2]):
+			b.WriteByte(s[i+2] & 0x3f)
+			i += 2
+		case c == 0xe0 && i+2 < len(s) && s[i+1] == 0x81 && isContinuation(s[i+2]):
+			b.WriteByte(0x40 | s[i+2]&0x3f)
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isContinuation(c byte) bool {
+	return c&0xc0 == 0x80
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// canonical is a value as received along with its normalized form
+type canonical struct {
+	raw, normalized string
+}
+
+func newCanonical(s string) canonical {
+	return canonical{s, Normalize(s)}
+}
+
+// matchedBy reports whether a pattern matches the value as received or
+// normalized; rules written for the encoded form still match
+func (c canonical) matchedBy(re *regexp.Regexp) bool {
+	return re.MatchString(c.raw) || c.normalized != c.raw && re.MatchString(c.normalized)
+}
+
+// scanned is an input with the custom rules that may match it
+type scanned struct {
+	canonical
+	hits candidates
+}
+
+// matchedBy reports whether a custom rule matches the input
+func (s *scanned) matchedBy(rule *customRule) bool {
+	return s.hits.has(rule.index) && s.canonical.matchedBy(rule.pattern)
+}
+
+// requestText holds the parts of a request custom rules match, normalized
+// and scanned once for all the rules
+type requestText struct {
+	req     *http.Request
+	lazy    *lazyBody
+	matcher *matcher
+	path    *scanned
+	query   *scanned
+	headers map[string][]*scanned
+	body    *scanned
+}
+
+func (t *requestText) scan(s string) *scanned {
+	input := newCanonical(s)
+	return &scanned{input, t.matcher.scan(input)}
+}
+
+func (t *requestText) pathInput() *scanned {
+	if t.path == nil {
+		t.path = t.scan(t.req.URL.Path)
+	}
+	return t.path
+}
+
+func (t *requestText) queryInput() *scanned {
+	if t.query == nil {
+		t.query = t.scan(t.req.URL.RawQuery)
+	}
+	return t.query
+}
+
+func (t *requestText) headerInputs() map[string][]*scanned {
+	if t.headers == nil {
+		t.headers = make(map[string][]*scanned, len(t.req.Header))
+		for name, values := range t.req.Header {
+			for _, value := range values {
+				t.headers[name] = append(t.headers[name], t.scan(value))
+			}
+		}
+	}
+	return t.headers
+}
+
+func (t *requestText) bodyInput() *scanned {
+	if t.body == nil {
+		t.body = t.scan(string(t.lazy.bytes()))
+	}
+	return t.body
+}
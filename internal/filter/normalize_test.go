@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "/index.html", "/index.html"},
+		{"percent", "%3Cscript%3E", "<script>"},
+		{"double", "%252e%252e%252f", "../"},
+		{"triple", "%25252e%25252e%25252f", "../"},
+		{"plus", "1+union+select", "1 union select"},
+		{"iis unicode", "%u002e%u002e%u002f", "../"},
+		{"malformed", "100%zz%2", "100%zz%2"},
+		{"null byte", "shell.php%00.jpg", "shell.php.jpg"},
+		{"overlong", "..%c0%af..%c0%afetc", "../../etc"},
+		{"overlong three byte", "%e0%80%ae%e0%80%ae/", "../"},
+		{"fullwidth", "＜script＞", "<script>"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodedAttacksAreBlocked(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+
+	for _, target := range []string{
+		"/files/%252e%252e%252fetc/passwd",
+		"/files/..%c0%af..%c0%afetc/passwd",
+		"/search?q=%253Cscript%253Ealert(1)%253C%252Fscript%253E",
+		"/search?q=%EF%BC%9Cscript%EF%BC%9Ealert(1)%EF%BC%9C/script%EF%BC%9E",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		if result := rf.FilterRequest(context.Background(), req); !result.Blocked {
+			t.Errorf("%s allowed: %s", target, result.Reason)
+		}
+	}
+}
+
+func TestCustomRulesMatchNormalizedInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte(testRules), 0o644)
+	rf := NewRequestFilter(1<<20, nil, nil)
+	if _, err := rf.LoadRules(path); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/api", strings.NewReader(`{"name":"%24%7Bjndi:ldap://x%7D"}`))
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Forwarded-Host", "%2524%257Bjndi:dns://x%257D")
+	result := rf.FilterRequest(context.Background(), req)
+	if !result.Blocked || result.Reason != "Blocked by rule log4shell" {
+		t.Errorf("encoded lookup: blocked %v (%s)", result.Blocked, result.Reason)
+	}
+}
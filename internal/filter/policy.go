@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultRiskThreshold is the risk score above which a request is blocked
+// when its route sets no threshold
+const DefaultRiskThreshold = 100
+
+// RuleSet is a named selection of the malicious patterns and custom rules,
+// by ID prefix: those matching one of Include, or all if it is empty, less
+// those matching one of Exclude
+type RuleSet struct {
+	Name    string   `json:"name"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// allows reports whether a rule is in the set; a nil set has every rule
+func (s *RuleSet) allows(id string) bool {
+	if s == nil {
+		return true
+	}
+	for _, prefix := range s.Exclude {
+		if strings.HasPrefix(id, prefix) {
+			return false
+		}
+	}
+	if len(s.Include) == 0 {
+		return true
+	}
+	for _, prefix := range s.Include {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy adjusts the filter for the route a request goes to
+type Policy struct {
+	// InspectBody scans request bodies for the malicious patterns
+	InspectBody bool
+	// RuleSet names the rules that apply, all of them if empty
+	RuleSet string
+	// RiskThreshold is the risk score above which the request is blocked
+	RiskThreshold int
+}
+
+type policyKey struct{}
+
+// WithPolicy returns a context carrying the filter policy of the route a
+// request goes to. Without it body inspection is as set by
+// SetBodyInspection, every rule applies and the risk threshold is
+// DefaultRiskThreshold.
+func WithPolicy(ctx context.Context, policy Policy) context.Context {
+	return context.WithValue(ctx, policyKey{}, policy)
+}
+
+// SetRuleSets replaces the rule sets policies can name
+func (rf *RequestFilter) SetRuleSets(sets []RuleSet) error {
+	byName := make(map[string]*RuleSet, len(sets))
+	for i := range sets {
+		set := sets[i]
+		if set.Name == "" || byName[set.Name] != nil {
+			return fmt.Errorf("rule set %d: missing or duplicate name %q", i+1, set.Name)
+		}
+		byName[set.Name] = &set
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.ruleSets = byName
+	return nil
+}
+
+// HasRuleSet reports whether a rule set is defined
+func (rf *RequestFilter) HasRuleSet(name string) bool {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	return rf.ruleSets[name] != nil
+}
+
+// policyFor returns the policy a request is filtered under and its rule
+// set; callers must hold the lock
+func (rf *RequestFilter) policyFor(ctx context.Context) (Policy, *RuleSet) {
+	policy, ok := ctx.Value(policyKey{}).(Policy)
+	if !ok {
+		policy = Policy{InspectBody: rf.inspectBody, RiskThreshold: DefaultRiskThreshold}
+	}
+	if policy.RiskThreshold <= 0 {
+		policy.RiskThreshold = DefaultRiskThreshold
+	}
+	return policy, rf.ruleSets[policy.RuleSet]
+}
@@ -0,0 +1,71 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRuleSets(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	err := rf.SetRuleSets([]RuleSet{
+		{Name: "cms", Exclude: []string{"sqli-"}},
+		{Name: "xss-only", Include: []string{"xss-"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		set, target string
+		blocked     bool
+	}{
+		{"", "/search?q=select+title+from+posts", true},
+		{"cms", "/search?q=select+title+from+posts", false},
+		{"cms", "/search?q=<script>alert(1)</script>", true},
+		{"xss-only", "/files/../../etc/passwd", false},
+		{"xss-only", "/search?q=javascript:alert(1)", true},
+		{"unknown", "/search?q=select+title+from+posts", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		ctx := WithPolicy(context.Background(), Policy{RuleSet: tt.set})
+		if result := rf.FilterRequest(ctx, req); result.Blocked != tt.blocked {
+			t.Errorf("set %q, %s: blocked %v (%s), want %v", tt.set, tt.target, result.Blocked, result.Reason, tt.blocked)
+		}
+	}
+
+	if err := rf.SetRuleSets([]RuleSet{{Name: "a"}, {Name: "a"}}); err == nil {
+		t.Error("SetRuleSets() accepted a duplicate name")
+	}
+}
+
+func TestPolicyBodyInspectionAndThreshold(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	rf.SetBodyInspection(false, 0)
+
+	body := "comment=<script>alert(1)</script>"
+	post := func(policy *Policy) *FilterResult {
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := context.Background()
+		if policy != nil {
+			ctx = WithPolicy(ctx, *policy)
+		}
+		return rf.FilterRequest(ctx, req)
+	}
+
+	if result := post(nil); result.Blocked {
+		t.Errorf("body scanned with inspection off: %s", result.Reason)
+	}
+	if result := post(&Policy{InspectBody: true}); !result.Blocked || result.Reason != "Malicious pattern detected in body" {
+		t.Errorf("route enabling inspection: blocked %v (%s)", result.Blocked, result.Reason)
+	}
+
+	// No user agent or accept headers scores 10, under the default threshold
+	if result := post(&Policy{RiskThreshold: 5}); !result.Blocked || !strings.HasPrefix(result.Reason, "High risk score") {
+		t.Errorf("route with a low risk threshold: blocked %v (%s)", result.Blocked, result.Reason)
+	}
+}
@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"ddos-protection/internal/probation"
+)
+
+func TestRuleProbation(t *testing.T) {
+	newFilter := func(t *testing.T, id string) (*RequestFilter, *probation.Tracker) {
+		t.Helper()
+		tracker := probation.NewTracker(0, 0.1, 10)
+		rf := NewRequestFilter(1<<20, nil, nil)
+		rf.SetProbation(tracker)
+		if err := rf.AddRule(id, `canary-token-\d+`); err != nil {
+			t.Fatal(err)
+		}
+		return rf, tracker
+	}
+	blocked := func(rf *RequestFilter, key string) bool {
+		req := httptest.NewRequest("GET", "/search?q=canary-token-42", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		return !rf.FilterRequest(WithClientKey(context.Background(), key), req).Allowed
+	}
+	client := func(i int) string { return fmt.Sprintf("198.51.100.%d", i) }
+	status := func(tracker *probation.Tracker) probation.RuleState {
+		return tracker.GetRules()[0]
+	}
+
+	for _, id := range []string{"custom-canary", "bundle:sqli-canary"} {
+		t.Run(id+"/promote", func(t *testing.T) {
+			rf, tracker := newFilter(t, id)
+			for i := 0; i < 20; i++ {
+				if blocked(rf, client(i)) {
+					t.Fatal("rule in shadow blocked a request")
+				}
+			}
+			// Clients that never prove legitimate are not false positives
+			tracker.Evaluate()
+			if s := status(tracker); s.Status != probation.StatusEnforcing || s.WouldBlock != 20 || s.FalsePositives != 0 {
+				t.Fatalf("clean probation not promoted: %+v", s)
+			}
+			if !blocked(rf, client(0)) {
+				t.Error("promoted rule not enforced")
+			}
+		})
+
+		t.Run(id+"/hold", func(t *testing.T) {
+			rf, tracker := newFilter(t, id)
+			for i := 0; i < 20; i++ {
+				blocked(rf, client(i))
+			}
+			for i := 0; i < 5; i++ {
+				tracker.Vindicate(client(i))
+			}
+			tracker.Evaluate()
+			if s := status(tracker); s.Status != probation.StatusHeld || s.FalsePositives != 5 {
+				t.Fatalf("noisy probation not held: %+v", s)
+			}
+			if blocked(rf, client(10)) {
+				t.Error("held rule enforced")
+			}
+		})
+
+		t.Run(id+"/rollout", func(t *testing.T) {
+			rf, tracker := newFilter(t, id)
+			if err := tracker.SetRamp([]int{50, 100}, 0); err != nil {
+				t.Fatal(err)
+			}
+			tracker.Evaluate()
+
+			var shadowed []string
+			for i := 0; i < 200; i++ {
+				if !blocked(rf, client(i)) {
+					shadowed = append(shadowed, client(i))
+				}
+			}
+			if n := len(shadowed); n < 60 || n > 140 {
+				t.Fatalf("%d of 200 clients shadowed at 50%%", n)
+			}
+			s := status(tracker)
+			if s.Step.Enforced != int64(200-len(shadowed)) || s.Step.WouldBlock != int64(len(shadowed)) {
+				t.Errorf("step cohorts miscounted: %+v", s.Step)
+			}
+
+			// The shadow cohort proving legitimate rolls the rule back
+			for _, key := range shadowed {
+				tracker.Vindicate(key)
+			}
+			tracker.Evaluate()
+			if s := status(tracker); s.Status != probation.StatusHeld {
+				t.Fatalf("rollout with false positives not held: %+v", s)
+			}
+			for i := 0; i < 200; i++ {
+				if blocked(rf, client(i)) {
+					t.Fatal("held rule still enforced")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ddos-protection/internal/headerfp"
+)
+
+// Protocol anomalies, each handled by its own ProtocolCheck
+const (
+	// AnomalyFraming is conflicting Content-Length and Transfer-Encoding
+	// headers, a request smuggling probe
+	AnomalyFraming = "framing"
+	// AnomalyDuplicateHost is more than one Host header, or one that
+	// disagrees with the request's authority
+	AnomalyDuplicateHost = "duplicate_host"
+	// AnomalyHeaderCount is more headers than MaxHeaders
+	AnomalyHeaderCount = "header_count"
+	// AnomalyHeaderSize is a header larger than MaxHeaderSize, or headers
+	// larger than MaxHeadersSize together
+	AnomalyHeaderSize = "header_size"
+	// AnomalyUnknownHeader is a header missing from AllowedHeaders
+	AnomalyUnknownHeader = "unknown_header"
+)
+
+// ProtocolCheck is how an anomaly is handled: blocked or challenged
+// outright, or scored by Severity with the milder actions
+type ProtocolCheck struct {
+	Action   Action `json:"action"`
+	Severity string `json:"severity"`
+}
+
+// defaultProtocolChecks block the anomalies no legitimate client produces
+// and score the ones a misconfigured client might
+var defaultProtocolChecks = map[string]ProtocolCheck{
+	AnomalyFraming:       {Action: ActionBlock, Severity: "critical"},
+	AnomalyDuplicateHost: {Action: ActionBlock, Severity: "critical"},
+	AnomalyHeaderCount:   {Action: ActionFlag, Severity: "high"},
+	AnomalyHeaderSize:    {Action: ActionFlag, Severity: "high"},
+	AnomalyUnknownHeader: {Action: ActionFlag, Severity: "medium"},
+}
+
+// ProtocolPolicy sets the limits of the protocol sanity checks. A limit of
+// 0 or less is no limit, and an empty AllowedHeaders allows any header;
+// otherwise it lists every header a request may carry, Host aside. Checks
+// overrides the handling of anomalies by name.
+type ProtocolPolicy struct {
+	MaxHeaders     int
+	MaxHeaderSize  int
+	MaxHeadersSize int
+	AllowedHeaders []string
+	Checks         map[string]ProtocolCheck
+
+	allowed map[string]bool
+}
+
+// anomaly is a protocol anomaly found in a request
+type anomaly struct {
+	kind   string
+	detail string
+}
+
+// SetProtocolPolicy replaces the protocol sanity checks; nil turns them
+// off
+func (rf *RequestFilter) SetProtocolPolicy(policy *ProtocolPolicy) error {
+	if policy != nil {
+		p := *policy
+		p.Checks = make(map[string]ProtocolCheck, len(defaultProtocolChecks))
+		for kind, check := range defaultProtocolChecks {
+			p.Checks[kind] = check
+		}
+		for kind, check := range policy.Checks {
+			def, ok := defaultProtocolChecks[kind]
+			if !ok {
+				return fmt.Errorf("protocol: unknown check %q", kind)
+			}
+			if check.Action == "" {
+				check.Action = def.Action
+			}
+			if err := ruleAction(check.Action); err != nil {
+				return fmt.Errorf("protocol check %s: %v", kind, err)
+			}
+			if check.Severity == "" {
+				check.Severity = def.Severity
+			}
+			if _, ok := severities[check.Severity]; !ok {
+				return fmt.Errorf("protocol check %s: unknown severity %q", kind, check.Severity)
+			}
+			p.Checks[kind] = check
+		}
+		if len(p.AllowedHeaders) > 0 {
+			p.allowed = make(map[string]bool, len(p.AllowedHeaders))
+			for _, name := range p.AllowedHeaders {
+				p.allowed[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+		policy = &p
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.protocol = policy
+	return nil
+}
+
+// anomalies returns the protocol anomalies of a request. net/http rejects
+// or settles most framing conflicts before a handler runs, so they are
+// also looked for in the raw header block its connection's first request
+// was fingerprinted from.
+func (p *ProtocolPolicy) anomalies(req *http.Request) []anomaly {
+	var found []anomaly
+
+	if detail := framing(req); detail != "" {
+		found = append(found, anomaly{AnomalyFraming, detail})
+	}
+	if hosts := req.Header.Values("Host"); len(hosts) > 1 || len(hosts) == 1 && hosts[0] != req.Host {
+		found = append(found, anomaly{AnomalyDuplicateHost, "duplicate Host header"})
+	}
+
+	count, total, largest := 0, len(req.Host), ""
+	var unknown []string
+	for name, values := range req.Header {
+		if p.allowed != nil && name != "Host" && !p.allowed[name] {
+			unknown = append(unknown, name)
+		}
+		for _, value := range values {
+			count++
+			size := len(name) + len(value) + 2
+			total += size
+			if p.MaxHeaderSize > 0 && size > p.MaxHeaderSize && largest == "" {
+				largest = name
+			}
+		}
+	}
+	if p.MaxHeaders > 0 && count > p.MaxHeaders {
+		found = append(found, anomaly{AnomalyHeaderCount, fmt.Sprintf("%d headers, more than %d", count, p.MaxHeaders)})
+	}
+	if largest != "" {
+		found = append(found, anomaly{AnomalyHeaderSize, fmt.Sprintf("header %s larger than %d bytes", largest, p.MaxHeaderSize)})
+	} else if p.MaxHeadersSize > 0 && total > p.MaxHeadersSize {
+		found = append(found, anomaly{AnomalyHeaderSize, fmt.Sprintf("headers larger than %d bytes", p.MaxHeadersSize)})
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		found = append(found, anomaly{AnomalyUnknownHeader, "headers not allowed: " + strings.Join(unknown, ", ")})
+	}
+	return found
+}
+
+// framing describes conflicting framing headers of a request, or returns
+// ""
+func framing(req *http.Request) string {
+	lengths := req.Header.Values("Content-Length")
+	if len(lengths) > 0 && (len(req.TransferEncoding) > 0 || req.Header.Get("Transfer-Encoding") != "") {
+		return "both Content-Length and Transfer-Encoding"
+	}
+	for _, length := range lengths {
+		if strings.TrimSpace(length) != strings.TrimSpace(lengths[0]) {
+			return "conflicting Content-Length headers"
+		}
+	}
+	if fp := headerfp.ForRequest(req); fp != nil {
+		return fp.Framing
+	}
+	return ""
+}
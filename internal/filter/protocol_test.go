@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProtocolChecks(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	err := rf.SetProtocolPolicy(&ProtocolPolicy{
+		MaxHeaders:     6,
+		MaxHeaderSize:  64,
+		MaxHeadersSize: 200,
+		Checks:         map[string]ProtocolCheck{AnomalyHeaderCount: {Action: ActionBlock}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		blocked bool
+		reason  string
+	}{
+		{"normal", nil, false, "Request allowed"},
+		{"smuggling", map[string][]string{"Content-Length": {"4"}, "Transfer-Encoding": {"chunked"}}, true, "Protocol anomaly: both Content-Length and Transfer-Encoding"},
+		{"two lengths", map[string][]string{"Content-Length": {"4", "40"}}, true, "Protocol anomaly: conflicting Content-Length headers"},
+		{"same lengths", map[string][]string{"Content-Length": {"0", "0"}}, false, "Request allowed"},
+		{"two hosts", map[string][]string{"Host": {"example.com", "internal"}}, true, "Protocol anomaly: duplicate Host header"},
+		{"many headers", map[string][]string{"X-A": {"1", "2", "3", "4"}, "X-B": {"1"}}, true, "Protocol anomaly: 7 headers, more than 6"},
+		{"large header", map[string][]string{"Cookie": {strings.Repeat("a", 64)}}, false, "Protocol anomalies: header Cookie larger than 64 bytes"},
+		{"large headers", map[string][]string{"X-A": {strings.Repeat("a", 55)}, "X-B": {strings.Repeat("b", 55)}, "X-C": {strings.Repeat("c", 55)}}, false, "Protocol anomalies: headers larger than 200 bytes"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept", "*/*")
+		for name, values := range tt.headers {
+			req.Header[name] = values
+		}
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Blocked != tt.blocked || result.Reason != tt.reason {
+			t.Errorf("%s: blocked %v (%s), want %v (%s)", tt.name, result.Blocked, result.Reason, tt.blocked, tt.reason)
+		}
+	}
+}
+
+func TestProtocolAllowlist(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	if err := rf.SetProtocolPolicy(&ProtocolPolicy{AllowedHeaders: []string{"user-agent", "Accept"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "*/*")
+	if result := rf.FilterRequest(context.Background(), req); result.Reason != "Request allowed" {
+		t.Errorf("allowed headers: %s", result.Reason)
+	}
+
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Api-Version", "2")
+	result := rf.FilterRequest(context.Background(), req)
+	if result.Blocked || result.Reason != "Protocol anomalies: headers not allowed: X-Api-Version, X-Debug" || result.RiskScore != 30 {
+		t.Errorf("unknown headers: blocked %v (%s), score %d", result.Blocked, result.Reason, result.RiskScore)
+	}
+
+	if err := rf.SetProtocolPolicy(&ProtocolPolicy{Checks: map[string]ProtocolCheck{"header_order": {}}}); err == nil {
+		t.Error("SetProtocolPolicy() accepted an unknown check")
+	}
+}
@@ -2,13 +2,19 @@ package filter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"ddos-protection/internal/killswitch"
+	"ddos-protection/internal/probation"
 )
 
 // RequestFilter analyzes and filters incoming requests
@@ -17,20 +23,67 @@ type RequestFilter struct {
 	suspiciousHeaders    []string
 	blockedUserAgents    []string
 	blockedUserAgentRe   []*regexp.Regexp
-	maliciousPatterns    []*regexp.Regexp
+	maliciousPatterns    []Rule
+	patternMatcher       *matcher
+	customRules          []*customRule
+	customMatcher        *matcher
+	skippedRules         []string
+	schemas              []RouteSchema
+	uploads              *UploadPolicy
+	protocol             *ProtocolPolicy
+	ruleActions          map[string]Action
+	categories           map[string]CategoryPolicy
+	ruleSets             map[string]*RuleSet
+	rulesFiles           []rulesFileState
+	inspectBody          bool
+	bodyLimit            int64
+	probation            *probation.Tracker
+	killSwitches         *killswitch.Registry
+	rulesMu              sync.RWMutex
 	requestHistory       map[string][]time.Time
 	mu                   sync.RWMutex
 	historyWindow        time.Duration
 	maxRequestsPerWindow int
 }
 
-// FilterResult represents the result of request filtering
+// Rule is a named malicious pattern of an attack category
+type Rule struct {
+	ID       string
+	Category string
+	Pattern  *regexp.Regexp
+}
+
+// FilterResult represents the result of request filtering. Action is
+// allow, tarpit, challenge or block; requests to tarpit are Allowed, those
+// to challenge are not but are not Blocked either. Categories lists the
+// attack categories detected, in the order they were.
 type FilterResult struct {
 	Allowed     bool
 	Reason      string
 	RiskScore   int
 	Blocked     bool
 	ShouldLog   bool
+	Action      Action
+	Categories  []string
+}
+
+type riskScoreKey struct{}
+
+type clientKey struct{}
+
+// WithRiskScore returns a context carrying risk already attributed to the
+// request by earlier checks; FilterRequest starts scoring from it
+func WithRiskScore(ctx context.Context, score int) context.Context {
+	return context.WithValue(ctx, riskScoreKey{}, score)
+}
+
+// WithClientKey returns a context carrying the key that identifies the
+// client, normally its IP as resolved through trusted proxies: its request
+// frequency is tracked and its rollout cohort chosen by it. Without it the
+// connection's remote address is used, which behind a load balancer is
+// that of the balancer.
+func WithClientKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKey{}, key)
 }
 
 // NewRequestFilter creates a new request filter
@@ -42,6 +95,8 @@ func NewRequestFilter(maxRequestSize int64, suspiciousHeaders, blockedUserAgents
 		requestHistory:       make(map[string][]time.Time),
 		historyWindow:        5 * time.Minute,
 		maxRequestsPerWindow: 100,
+		bodyLimit:            DefaultBodyLimit,
+		categories:           defaultCategories,
 	}
 
 	// Compile regex patterns for blocked user agents
@@ -60,36 +115,155 @@ func NewRequestFilter(maxRequestSize int64, suspiciousHeaders, blockedUserAgents
 
 // initMaliciousPatterns initializes common attack patterns
 func (rf *RequestFilter) initMaliciousPatterns() {
-	maliciousPatterns := []string{
+	maliciousPatterns := []struct {
+		id       string
+		category string
+		pattern  string
+	}{
 		// SQL Injection patterns
-		`(?i)(union|select|insert|update|delete|drop|create|alter|exec|execute).*from`,
-		`(?i)(or|and).*1\s*=\s*1`,
-		`(?i)(or|and).*'1'\s*=\s*'1'`,
-		
+		{"sqli-keyword-from", CategorySQLi, `(?i)(union|select|insert|update|delete|drop|create|alter|exec|execute).*from`},
+		{"sqli-tautology", CategorySQLi, `(?i)(or|and).*1\s*=\s*1`},
+		{"sqli-quoted-tautology", CategorySQLi, `(?i)(or|and).*'1'\s*=\s*'1'`},
+
 		// XSS patterns
-		`(?i)<script[^>]*>.*</script>`,
-		`(?i)javascript:`,
-		`(?i)on\w+\s*=`,
-		
+		{"xss-script-tag", CategoryXSS, `(?i)<script[^>]*>.*</script>`},
+		{"xss-javascript-uri", CategoryXSS, `(?i)javascript:`},
+		{"xss-event-handler", CategoryXSS, `(?i)on\w+\s*=`},
+
 		// Path traversal
-		`\.\./`,
-		`\.\.\\`,
-		
+		{"traversal-unix", CategoryTraversal, `\.\./`},
+		{"traversal-windows", CategoryTraversal, `\.\.\\`},
+
 		// Command injection
-		`(?i)(cmd|command|exec|system|shell)`,
-		
+		{"rce-command-keyword", CategoryRCE, `(?i)(cmd|command|exec|system|shell)`},
+
 		// Suspicious file extensions
-		`\.(php|asp|jsp|cgi|sh|bat|exe|scr)`,
-		
+		{"ext-executable", CategoryFileProbe, `\.(php|asp|jsp|cgi|sh|bat|exe|scr)`},
+
 		// Common attack tools
-		`(?i)(nmap|nikto|sqlmap|burp|w3af|nessus)`,
+		{"tool-scanner-name", CategoryScanner, `(?i)(nmap|nikto|sqlmap|burp|w3af|nessus)`},
+	}
+
+	for _, p := range maliciousPatterns {
+		if re, err := regexp.Compile(p.pattern); err == nil {
+			rf.maliciousPatterns = append(rf.maliciousPatterns, Rule{ID: p.id, Category: p.category, Pattern: re})
+		}
+	}
+	rf.indexPatterns()
+}
+
+// SetMaxRequestSize sets the largest request body accepted
+func (rf *RequestFilter) SetMaxRequestSize(size int64) {
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.maxRequestSize = size
+}
+
+// SetProbation attaches a probation tracker; rules added afterwards run in
+// shadow until the tracker promotes them
+func (rf *RequestFilter) SetProbation(tracker *probation.Tracker) {
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.probation = tracker
+}
+
+// SetKillSwitches attaches the kill switch registry used to disable rules
+func (rf *RequestFilter) SetKillSwitches(registry *killswitch.Registry) {
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.killSwitches = registry
+}
+
+// AddRule adds a malicious pattern at runtime, of the category its ID
+// starts with or else custom. When a probation tracker is attached the
+// rule starts in shadow mode.
+func (rf *RequestFilter) AddRule(id, pattern string) error {
+	return rf.AddRules(map[string]string{id: pattern})[id]
+}
+
+// AddRules adds several rules like AddRule, indexing the patterns once.
+// Rules that are invalid or already exist are skipped, with their errors
+// returned by ID.
+func (rf *RequestFilter) AddRules(patterns map[string]string) map[string]error {
+	errs := make(map[string]error)
+	compiled := make(map[string]*regexp.Regexp, len(patterns))
+	for id, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs[id] = fmt.Errorf("invalid pattern for rule %s: %v", id, err)
+			continue
+		}
+		compiled[id] = re
 	}
 
-	for _, pattern := range maliciousPatterns {
-		if re, err := regexp.Compile(pattern); err == nil {
-			rf.maliciousPatterns = append(rf.maliciousPatterns, re)
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	for _, rule := range rf.maliciousPatterns {
+		if _, exists := compiled[rule.ID]; exists {
+			errs[rule.ID] = fmt.Errorf("rule already exists: %s", rule.ID)
+			delete(compiled, rule.ID)
+		}
+	}
+	if len(compiled) == 0 {
+		return errs
+	}
+
+	ids := make([]string, 0, len(compiled))
+	for id := range compiled {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		rf.maliciousPatterns = append(rf.maliciousPatterns, Rule{ID: id, Category: categoryOf(id), Pattern: compiled[id]})
+		if rf.probation != nil {
+			rf.probation.Register(id, "filter")
 		}
 	}
+	rf.indexPatterns()
+
+	return errs
+}
+
+// RemoveRule removes a rule added at runtime and stops tracking its
+// probation
+func (rf *RequestFilter) RemoveRule(id string) error {
+	if rf.RemoveRules([]string{id}) == 0 {
+		return fmt.Errorf("rule not found: %s", id)
+	}
+	return nil
+}
+
+// RemoveRules removes several rules like RemoveRule, indexing the
+// remaining patterns once. It returns how many were found.
+func (rf *RequestFilter) RemoveRules(ids []string) int {
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	kept := make([]Rule, 0, len(rf.maliciousPatterns))
+	for _, rule := range rf.maliciousPatterns {
+		if !remove[rule.ID] {
+			kept = append(kept, rule)
+			continue
+		}
+		if rf.probation != nil {
+			rf.probation.Remove(rule.ID)
+		}
+	}
+	removed := len(rf.maliciousPatterns) - len(kept)
+	if removed > 0 {
+		rf.maliciousPatterns = kept
+		rf.indexPatterns()
+	}
+	return removed
 }
 
 // FilterRequest analyzes an HTTP request and determines if it should be allowed
@@ -100,28 +274,68 @@ func (rf *RequestFilter) FilterRequest(ctx context.Context, req *http.Request) *
 		RiskScore: 0,
 		Blocked:   false,
 		ShouldLog: false,
+		Action:    ActionAllow,
+	}
+
+	if score, ok := ctx.Value(riskScoreKey{}).(int); ok {
+		result.RiskScore = score
+	}
+	key, ok := ctx.Value(clientKey{}).(string)
+	if !ok {
+		key = req.RemoteAddr
+		if host, _, err := net.SplitHostPort(key); err == nil {
+			key = host
+		}
 	}
 
 	// Check request size
-	if req.ContentLength > rf.maxRequestSize {
+	rf.rulesMu.RLock()
+	maxRequestSize := rf.maxRequestSize
+	policy, ruleSet := rf.policyFor(ctx)
+	body := &lazyBody{req: req, limit: rf.bodyLimit}
+	schemas := rf.schemas
+	uploads := rf.uploads
+	protocol := rf.protocol
+	categories := rf.categories
+	rf.rulesMu.RUnlock()
+	scores := make(map[string]int)
+	if req.ContentLength > maxRequestSize {
 		result.Allowed = false
 		result.Reason = "Request size exceeds limit"
 		result.RiskScore += 50
 		result.Blocked = true
+		result.Action = ActionBlock
 		return result
 	}
 
+	// Check for protocol anomalies
+	if protocol != nil {
+		var flagged []string
+		for _, a := range protocol.anomalies(req) {
+			check := protocol.Checks[a.kind]
+			if result.apply(check.Action, severities[check.Severity]) {
+				result.Reason = fmt.Sprintf("Protocol anomaly: %s", a.detail)
+				return result
+			}
+			flagged = append(flagged, a.detail)
+		}
+		if len(flagged) > 0 {
+			result.Reason = fmt.Sprintf("Protocol anomalies: %s", strings.Join(flagged, "; "))
+		}
+	}
+
 	// Check user agent
 	if rf.isBlockedUserAgent(req.UserAgent()) {
 		result.Allowed = false
 		result.Reason = "Blocked user agent"
 		result.RiskScore += 30
 		result.Blocked = true
+		result.Action = ActionBlock
 		return result
 	}
 
 	// Check suspicious headers
-	suspiciousHeaders := rf.checkSuspiciousHeaders(req.Header)
+	suspiciousHeaders := rf.checkSuspiciousHeaders(req.Header, key, ruleSet)
 	if len(suspiciousHeaders) > 0 {
 		result.RiskScore += len(suspiciousHeaders) * 10
 		result.ShouldLog = true
@@ -129,30 +343,88 @@ func (rf *RequestFilter) FilterRequest(ctx context.Context, req *http.Request) *
 	}
 
 	// Check URL for malicious patterns
-	if rf.hasMaliciousPattern(req.URL.Path + req.URL.RawQuery) {
-		result.Allowed = false
+	if detected := rf.matchRules(req.URL.Path+req.URL.RawQuery, key, ruleSet); len(detected) > 0 {
 		result.Reason = "Malicious pattern detected in URL"
-		result.RiskScore += 80
-		result.Blocked = true
+		if result.detect(detected, categories, scores) {
+			return result
+		}
+	}
+
+	// Validate the JSON body of routes with a schema
+	if route := schemaFor(schemas, req); route != nil {
+		if err := route.validate(req, body); err != nil {
+			result.Reason = fmt.Sprintf("Body fails schema %s: %v", route.Name, err)
+			if result.apply(route.Action, severities[route.Severity]) {
+				return result
+			}
+		}
+	}
+
+	// Check the files of multipart uploads
+	if uploads != nil && inspectsBody(req) {
+		if err := uploads.check(ctx, req, maxRequestSize, body); err != nil {
+			var scanErr *scanError
+			if errors.As(err, &scanErr) {
+				result.Reason = fmt.Sprintf("Upload not scanned: %v", err)
+				result.apply(ActionLog, 0)
+			} else {
+				result.Reason = fmt.Sprintf("Upload rejected: %v", err)
+				if result.apply(uploads.Action, severities[uploads.Severity]) {
+					return result
+				}
+			}
+		}
+	}
+
+	// Check the body of POST, PUT and PATCH requests
+	if policy.InspectBody && inspectsBody(req) {
+		if text := bodyText(req.Header.Get("Content-Type"), body.bytes()); text != "" {
+			if detected := rf.matchRules(text, key, ruleSet); len(detected) > 0 {
+				result.Reason = "Malicious pattern detected in body"
+				if result.detect(detected, categories, scores) {
+					return result
+				}
+			}
+		}
+	}
+
+	// Check custom rules from the rules file
+	match := rf.matchCustomRules(req, body, ruleSet)
+	for _, d := range match.detected {
+		result.record(d.category, d.action)
+	}
+	if match.decisive != nil {
+		verb := "Blocked"
+		if match.action == ActionChallenge {
+			verb = "Challenged"
+		}
+		result.Reason = fmt.Sprintf("%s by rule %s", verb, match.decisive.ID)
+		result.apply(match.action, match.score)
 		return result
+	} else if len(match.flagged) > 0 {
+		result.Reason = fmt.Sprintf("Flagged by rules: %s", strings.Join(match.flagged, ", "))
+		result.apply(match.action, match.score)
 	}
 
 	// Check request frequency
-	if rf.isHighFrequency(req.RemoteAddr) {
+	if rf.isHighFrequency(key) {
 		result.RiskScore += 20
 		result.ShouldLog = true
 		if result.RiskScore > 50 {
 			result.Allowed = false
 			result.Reason = "High frequency requests detected"
 			result.Blocked = true
+			result.Action = ActionBlock
 			return result
 		}
 	}
 
 	// Check request method
 	if rf.isSuspiciousMethod(req.Method) {
-		result.RiskScore += 15
-		result.ShouldLog = true
+		if result.detect(detections{{category: CategoryMethodProbe, byCategory: true}}, categories, scores) {
+			result.Reason = fmt.Sprintf("Suspicious method %s", req.Method)
+			return result
+		}
 	}
 
 	// Check for missing or suspicious headers
@@ -162,13 +434,14 @@ func (rf *RequestFilter) FilterRequest(ctx context.Context, req *http.Request) *
 	}
 
 	// Update request history
-	rf.updateRequestHistory(req.RemoteAddr)
+	rf.updateRequestHistory(key)
 
 	// Set final decision
-	if result.RiskScore > 100 {
+	if result.RiskScore > policy.RiskThreshold {
 		result.Allowed = false
 		result.Reason = fmt.Sprintf("High risk score: %d", result.RiskScore)
 		result.Blocked = true
+		result.Action = ActionBlock
 	}
 
 	return result
@@ -185,13 +458,13 @@ func (rf *RequestFilter) isBlockedUserAgent(userAgent string) bool {
 }
 
 // checkSuspiciousHeaders checks for suspicious header patterns
-func (rf *RequestFilter) checkSuspiciousHeaders(headers http.Header) []string {
+func (rf *RequestFilter) checkSuspiciousHeaders(headers http.Header, key string, set *RuleSet) []string {
 	var suspicious []string
 
 	for _, header := range rf.suspiciousHeaders {
 		if values, exists := headers[header]; exists {
 			for _, value := range values {
-				if rf.hasMaliciousPattern(value) {
+				if rf.hasMaliciousPattern(value, key, set) {
 					suspicious = append(suspicious, header)
 					break
 				}
@@ -207,16 +480,75 @@ func (rf *RequestFilter) checkSuspiciousHeaders(headers http.Header) []string {
 	return suspicious
 }
 
-// hasMaliciousPattern checks if a string contains malicious patterns
-func (rf *RequestFilter) hasMaliciousPattern(text string) bool {
-	for _, pattern := range rf.maliciousPatterns {
-		if pattern.MatchString(text) {
+// hasMaliciousPattern checks if a string contains malicious patterns enforced
+// for the client
+func (rf *RequestFilter) hasMaliciousPattern(text, key string, set *RuleSet) bool {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	input := newCanonical(text)
+	hits := rf.patternMatcher.scan(input)
+	for i, rule := range rf.maliciousPatterns {
+		if !hits.has(i) || rf.isKilled(rule.ID) || !set.allows(rule.ID) {
+			continue
+		}
+		if input.matchedBy(rule.Pattern) && rf.isEnforced(rule.ID, key) {
 			return true
 		}
 	}
 	return false
 }
 
+// matchRules checks the URL or body text, as received and normalized,
+// against all rules, recording would-block hits for rules on probation and
+// enforced hits for rules rolling out. It returns the categories of the
+// enforced rules that match. Shadow hits are held against key until the
+// client proves legitimate.
+func (rf *RequestFilter) matchRules(text, key string, set *RuleSet) detections {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	input := newCanonical(text)
+	hits := rf.patternMatcher.scan(input)
+	var detected detections
+	for i, rule := range rf.maliciousPatterns {
+		if !hits.has(i) || rf.isKilled(rule.ID) || !set.allows(rule.ID) || !input.matchedBy(rule.Pattern) {
+			continue
+		}
+		if rf.isEnforced(rule.ID, key) {
+			if rf.probation != nil {
+				rf.probation.RecordEnforced(rule.ID)
+			}
+			action, overridden := rf.ruleActions[rule.ID]
+			detected = detected.add(rule.Category, action, overridden)
+			continue
+		}
+		rf.probation.RecordWouldBlock(rule.ID, key)
+	}
+	return detected
+}
+
+// indexPatterns rebuilds the matcher of the malicious patterns after they
+// change; callers must hold the lock
+func (rf *RequestFilter) indexPatterns() {
+	patterns := make([]*regexp.Regexp, len(rf.maliciousPatterns))
+	for i, rule := range rf.maliciousPatterns {
+		patterns[i] = rule.Pattern
+	}
+	rf.patternMatcher = newMatcher(patterns)
+}
+
+// isKilled reports whether a rule's kill switch is engaged; callers must hold the lock
+func (rf *RequestFilter) isKilled(id string) bool {
+	return rf.killSwitches.IsEngaged(killswitch.KindRule, id)
+}
+
+// isEnforced reports whether a rule is enforced for the client with key;
+// callers must hold the lock
+func (rf *RequestFilter) isEnforced(id, key string) bool {
+	return rf.probation == nil || rf.probation.ShouldEnforce(id, key)
+}
+
 // hasHeaderManipulation checks for common header manipulation techniques
 func (rf *RequestFilter) hasHeaderManipulation(headers http.Header) bool {
 	// Check for multiple values in single-value headers
@@ -344,6 +676,7 @@ func (rf *RequestFilter) GetRequestStats() map[string]interface{} {
 		"total_ips":           len(rf.requestHistory),
 		"blocked_user_agents": len(rf.blockedUserAgentRe),
 		"malicious_patterns":  len(rf.maliciousPatterns),
+		"custom_rules":        len(rf.customRules),
 		"suspicious_headers":  len(rf.suspiciousHeaders),
 	}
 
@@ -0,0 +1,71 @@
+package filter
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFrequencyTrackedByClientKey(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	filter := func(key, remoteAddr string) *FilterResult {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		ctx := context.Background()
+		if key != "" {
+			ctx = WithClientKey(ctx, key)
+		}
+		return rf.FilterRequest(ctx, req)
+	}
+
+	// Behind a load balancer every request comes from its address
+	for i := 0; i <= rf.maxRequestsPerWindow; i++ {
+		filter("203.0.113.7", "10.0.0.1:40000")
+	}
+	if result := filter("203.0.113.7", "10.0.0.1:40000"); result.RiskScore != 20 {
+		t.Errorf("busy client: risk %d, want 20", result.RiskScore)
+	}
+	if result := filter("198.51.100.9", "10.0.0.1:40000"); result.RiskScore != 0 {
+		t.Errorf("other client behind the balancer: risk %d, want 0", result.RiskScore)
+	}
+
+	// Without a key, connections from one host share a history
+	for i := 0; i <= rf.maxRequestsPerWindow; i++ {
+		filter("", "192.0.2.5:50000")
+	}
+	if result := filter("", "192.0.2.5:50001"); result.RiskScore != 20 {
+		t.Errorf("busy host on a new connection: risk %d, want 20", result.RiskScore)
+	}
+}
+
+func TestAddRules(t *testing.T) {
+	rf := NewRequestFilter(1<<20, nil, nil)
+	errs := rf.AddRules(map[string]string{
+		"bundle:a": `canary-a`,
+		"bundle:b": `canary-b`,
+		"bundle:c": `(`,
+	})
+	if len(errs) != 1 || errs["bundle:c"] == nil {
+		t.Fatalf("AddRules errors = %v, want one for bundle:c", errs)
+	}
+	if err := rf.AddRule("bundle:a", `other`); err == nil {
+		t.Error("duplicate rule accepted")
+	}
+
+	blocked := func(path string) bool {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		return !rf.FilterRequest(context.Background(), req).Allowed
+	}
+	if !blocked("/canary-a") || !blocked("/canary-b") {
+		t.Error("rules added together not all matched")
+	}
+
+	if n := rf.RemoveRules([]string{"bundle:a", "bundle:b", "bundle:missing"}); n != 2 {
+		t.Errorf("RemoveRules removed %d, want 2", n)
+	}
+	if blocked("/canary-a") || blocked("/canary-b") {
+		t.Error("removed rules still matched")
+	}
+}
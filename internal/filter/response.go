@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultResponseBodyLimit is the largest response body scanned when no
+// limit is set; larger responses are passed through unscanned
+const DefaultResponseBodyLimit = 1 << 20
+
+// DefaultMask replaces the secrets masked out of responses
+const DefaultMask = "[REDACTED]"
+
+// LeakAction is what the response filter does with a response leaking
+// something
+type LeakAction string
+
+const (
+	// LeakLog logs the leak and serves the response unchanged
+	LeakLog LeakAction = "log"
+	// LeakMask replaces what leaked with the mask
+	LeakMask LeakAction = "mask"
+	// LeakBlock replaces the whole response with an error
+	LeakBlock LeakAction = "block"
+)
+
+// LeakRule is a pattern of information that must not leave in a response
+type LeakRule struct {
+	ID       string         `json:"id"`
+	Category string         `json:"category"`
+	Pattern  *regexp.Regexp `json:"-"`
+	Action   LeakAction     `json:"action"`
+}
+
+// builtinLeaks are the default leak rules: stack traces and database
+// errors, which attackers provoke to learn about the application and
+// which are blocked whole, and credentials, which are masked
+var builtinLeaks = []struct {
+	id, category, pattern string
+	action                LeakAction
+}{
+	{"stack-java", "stack_trace", `(?m)^\s+at [\w$.<>]+\([\w$]+\.java:\d+\)`, LeakBlock},
+	{"stack-dotnet", "stack_trace", `(?m)^\s+at [\w.<>` + "`" + `]+\(.*\) in .+:line \d+`, LeakBlock},
+	{"stack-python", "stack_trace", `Traceback \(most recent call last\):`, LeakBlock},
+	{"stack-go", "stack_trace", `goroutine \d+ \[running\]:`, LeakBlock},
+	{"stack-node", "stack_trace", `(?m)^\s+at .+ \((?:/|[A-Z]:\\).+\.[cm]?js:\d+:\d+\)`, LeakBlock},
+	{"stack-php", "stack_trace", `(?:Fatal error|Parse error|Warning)(?:</b>)?:\s.+ in (?:<b>)?\S+\.php(?:</b>)? on line`, LeakBlock},
+	{"sql-mysql", "sql_error", `You have an error in your SQL syntax|mysql_fetch_\w+\(|SQLSTATE\[\w+\]`, LeakBlock},
+	{"sql-postgres", "sql_error", `PG::\w+Error|ERROR:\s+syntax error at or near|pg_query\(\)`, LeakBlock},
+	{"sql-oracle", "sql_error", `\bORA-\d{5}:`, LeakBlock},
+	{"sql-mssql", "sql_error", `Unclosed quotation mark after the character string|Microsoft OLE DB Provider for SQL Server|SqlException`, LeakBlock},
+	{"sql-sqlite", "sql_error", `SQLITE_ERROR|sqlite3\.OperationalError`, LeakBlock},
+	{"secret-aws-key", "secret", `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`, LeakMask},
+	{"secret-private-key", "secret", `-----BEGIN (?:RSA |EC |DSA |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`, LeakBlock},
+	{"secret-github-token", "secret", `\bgh[pousr]_[A-Za-z0-9]{36}\b`, LeakMask},
+	{"secret-slack-token", "secret", `\bxox[abprs]-[0-9A-Za-z-]{10,}`, LeakMask},
+	{"secret-google-key", "secret", `\bAIza[0-9A-Za-z_-]{35}\b`, LeakMask},
+}
+
+// ResponseFilter scans outgoing response bodies for stack traces, database
+// errors and credentials, and masks or blocks them
+type ResponseFilter struct {
+	rules []LeakRule
+	mask  string
+	limit int64
+}
+
+// ResponseResult is the outcome of scanning a response. Body is the
+// response to send, masked if need be; Blocked means it must be replaced
+// with an error instead. Matched lists the IDs of the rules that matched.
+type ResponseResult struct {
+	Body    []byte
+	Blocked bool
+	Matched []string
+}
+
+// NewResponseFilter creates a response filter with the built-in rules and
+// custom ones. actions overrides the actions of rules by ID. Bodies are
+// scanned up to limit bytes, DefaultResponseBodyLimit if 0 or less, and
+// masked with mask, DefaultMask if empty.
+func NewResponseFilter(custom []LeakRule, actions map[string]LeakAction, mask string, limit int64) (*ResponseFilter, error) {
+	rf := &ResponseFilter{mask: mask, limit: limit}
+	if rf.mask == "" {
+		rf.mask = DefaultMask
+	}
+	if rf.limit <= 0 {
+		rf.limit = DefaultResponseBodyLimit
+	}
+
+	for _, leak := range builtinLeaks {
+		rf.rules = append(rf.rules, LeakRule{
+			ID:       leak.id,
+			Category: leak.category,
+			Pattern:  regexp.MustCompile(leak.pattern),
+			Action:   leak.action,
+		})
+	}
+	seen := make(map[string]bool, len(rf.rules)+len(custom))
+	for _, rule := range rf.rules {
+		seen[rule.ID] = true
+	}
+	for _, rule := range custom {
+		if rule.ID == "" || seen[rule.ID] {
+			return nil, fmt.Errorf("response rule: missing or duplicate id %q", rule.ID)
+		}
+		seen[rule.ID] = true
+		if rule.Pattern == nil {
+			return nil, fmt.Errorf("response rule %s: pattern is required", rule.ID)
+		}
+		if rule.Category == "" {
+			rule.Category = "custom"
+		}
+		if rule.Action == "" {
+			rule.Action = LeakBlock
+		}
+		rf.rules = append(rf.rules, rule)
+	}
+
+	for id, action := range actions {
+		if !seen[id] {
+			return nil, fmt.Errorf("response rule %s: not found", id)
+		}
+		for i := range rf.rules {
+			if rf.rules[i].ID == id {
+				rf.rules[i].Action = action
+			}
+		}
+	}
+	for _, rule := range rf.rules {
+		switch rule.Action {
+		case LeakLog, LeakMask, LeakBlock:
+		default:
+			return nil, fmt.Errorf("response rule %s: unknown action %q", rule.ID, rule.Action)
+		}
+	}
+	return rf, nil
+}
+
+// Rules returns the leak rules in force
+func (rf *ResponseFilter) Rules() []LeakRule {
+	return append([]LeakRule(nil), rf.rules...)
+}
+
+// Limit is the largest body the filter scans
+func (rf *ResponseFilter) Limit() int64 {
+	return rf.limit
+}
+
+// Scans reports whether responses of a type and encoding are scanned:
+// uncompressed text, JSON, XML and JavaScript
+func (rf *ResponseFilter) Scans(contentType, contentEncoding string) bool {
+	if contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Handlers that set no type get it sniffed by net/http, as text
+		// more often than not
+		return contentType == ""
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/javascript"
+}
+
+// Scan looks for leaks in a response body
+func (rf *ResponseFilter) Scan(body []byte) *ResponseResult {
+	result := &ResponseResult{Body: body}
+	masked := false
+	for _, rule := range rf.rules {
+		if !rule.Pattern.Match(result.Body) {
+			continue
+		}
+		result.Matched = append(result.Matched, rule.ID)
+		switch rule.Action {
+		case LeakBlock:
+			result.Blocked = true
+		case LeakMask:
+			if !masked {
+				// Masking must not touch the handler's buffer
+				result.Body = append([]byte(nil), result.Body...)
+				masked = true
+			}
+			result.Body = rule.Pattern.ReplaceAllLiteral(result.Body, []byte(rf.mask))
+		}
+	}
+	sort.Strings(result.Matched)
+	return result
+}
@@ -0,0 +1,336 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule targets
+const (
+	TargetPath   = "path"
+	TargetQuery  = "query"
+	TargetHeader = "header"
+	TargetBody   = "body"
+)
+
+// severities maps severity names to the risk score a match adds
+var severities = map[string]int{
+	"low":      10,
+	"medium":   30,
+	"high":     60,
+	"critical": 80,
+}
+
+// FileRule is a custom rule as written in a rules file. Action defaults
+// to block, Severity to high and Targets to the path and query. Headers
+// names the headers the header target matches, all of them if empty.
+// Category, if set, is reported with the attack categories of requests the
+// rule matches; the rule keeps its own action and severity.
+type FileRule struct {
+	ID          string   `yaml:"id" json:"id"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+	Pattern     string   `yaml:"pattern" json:"pattern"`
+	Action      Action   `yaml:"action" json:"action"`
+	Severity    string   `yaml:"severity" json:"severity"`
+	Targets     []string `yaml:"targets" json:"targets"`
+	Headers     []string `yaml:"headers" json:"headers,omitempty"`
+	Category    string   `yaml:"category" json:"category,omitempty"`
+}
+
+// customRule is a compiled FileRule
+type customRule struct {
+	FileRule
+	pattern *regexp.Regexp
+	index   int
+	score   int
+	targets map[string]bool
+}
+
+// ParseRules parses the rules of a rules file and checks they compile:
+//
+//	rules:
+//	  - id: wp-admin-probe
+//	    pattern: '(?i)/wp-(admin|login)'
+//	    severity: medium
+//	    action: flag
+func ParseRules(data []byte) ([]FileRule, error) {
+	var file struct {
+		Rules []FileRule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid rules file: %v", err)
+	}
+
+	seen := make(map[string]bool, len(file.Rules))
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		if rule.ID == "" || seen[rule.ID] {
+			return nil, fmt.Errorf("rule %d: missing or duplicate ID %q", i+1, rule.ID)
+		}
+		seen[rule.ID] = true
+		if _, err := compileRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return file.Rules, nil
+}
+
+// compileRule fills in a rule's defaults and compiles it
+func compileRule(rule *FileRule) (*customRule, error) {
+	if rule.Action == "" {
+		rule.Action = ActionBlock
+	}
+	if err := ruleAction(rule.Action); err != nil {
+		return nil, fmt.Errorf("rule %s: %v", rule.ID, err)
+	}
+	if rule.Severity == "" {
+		rule.Severity = "high"
+	}
+	score, ok := severities[rule.Severity]
+	if !ok {
+		return nil, fmt.Errorf("rule %s: unknown severity %q", rule.ID, rule.Severity)
+	}
+	if len(rule.Targets) == 0 {
+		rule.Targets = []string{TargetPath, TargetQuery}
+	}
+	targets := make(map[string]bool, len(rule.Targets))
+	for _, target := range rule.Targets {
+		switch target {
+		case TargetPath, TargetQuery, TargetHeader, TargetBody:
+			targets[target] = true
+		default:
+			return nil, fmt.Errorf("rule %s: unknown target %q", rule.ID, target)
+		}
+	}
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil || rule.Pattern == "" {
+		return nil, fmt.Errorf("invalid pattern for rule %s: %v", rule.ID, err)
+	}
+
+	return &customRule{FileRule: *rule, pattern: re, score: score, targets: targets}, nil
+}
+
+// LoadRules reads the rules files at paths into the filter, replacing the
+// custom rules loaded before, unless none of the files changed since.
+// Files ending in .conf are ModSecurity rule files, parsed by
+// ParseSecRules; others are rules files. It reports whether the rules were
+// replaced; on error the rules in force are kept.
+func (rf *RequestFilter) LoadRules(paths ...string) (bool, error) {
+	states := make([]rulesFileState, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		states[i] = rulesFileState{path, info.ModTime(), info.Size()}
+	}
+
+	rf.rulesMu.RLock()
+	unchanged := len(states) == len(rf.rulesFiles)
+	for i := 0; unchanged && i < len(states); i++ {
+		unchanged = states[i].equal(rf.rulesFiles[i])
+	}
+	rf.rulesMu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var compiled []*customRule
+	var skipped []string
+	seen := make(map[string]string)
+	for _, path := range paths {
+		rules, skips, err := readRules(path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", path, err)
+		}
+		for i := range rules {
+			if other, dup := seen[rules[i].ID]; dup {
+				return false, fmt.Errorf("%s: rule %s already loaded from %s", path, rules[i].ID, other)
+			}
+			seen[rules[i].ID] = path
+			rule, _ := compileRule(&rules[i])
+			compiled = append(compiled, rule)
+		}
+		for _, skip := range skips {
+			skipped = append(skipped, path+": "+skip)
+		}
+	}
+
+	patterns := make([]*regexp.Regexp, len(compiled))
+	for i, rule := range compiled {
+		rule.index = i
+		patterns[i] = rule.pattern
+	}
+	customMatcher := newMatcher(patterns)
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.customRules = compiled
+	rf.customMatcher = customMatcher
+	rf.skippedRules = skipped
+	rf.rulesFiles = states
+	return true, nil
+}
+
+// readRules parses a rules file or, for .conf files, a ModSecurity rule
+// file along with the rules skipped in it
+func readRules(path string) ([]FileRule, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.HasSuffix(path, ".conf") {
+		return ParseSecRules(data)
+	}
+	rules, err := ParseRules(data)
+	return rules, nil, err
+}
+
+// SkippedRules returns the rules of ModSecurity rule files that the last
+// load could not convert, each with the file and the reason
+func (rf *RequestFilter) SkippedRules() []string {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	return append([]string(nil), rf.skippedRules...)
+}
+
+// CustomRules returns the rules loaded from the rules files
+func (rf *RequestFilter) CustomRules() []FileRule {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	rules := make([]FileRule, len(rf.customRules))
+	for i, rule := range rf.customRules {
+		rules[i] = rule.FileRule
+	}
+	return rules
+}
+
+// customMatch is the outcome of matching a request against custom rules:
+// the block or challenge rule that decided it, or the rules that flagged it
+// and the strictest of their actions, along with the categories of the
+// rules that have one
+type customMatch struct {
+	decisive *customRule
+	action   Action
+	flagged  []string
+	score    int
+	detected []detection
+}
+
+// matchCustomRules matches a request against the custom rules in set,
+// stopping at the first block or challenge rule that matches
+func (rf *RequestFilter) matchCustomRules(req *http.Request, lazy *lazyBody, set *RuleSet) customMatch {
+	rf.rulesMu.RLock()
+	rules := rf.customRules
+	customMatcher := rf.customMatcher
+	overrides := rf.ruleActions
+	rf.rulesMu.RUnlock()
+
+	var match customMatch
+	text := &requestText{req: req, lazy: lazy, matcher: customMatcher}
+	for _, rule := range rules {
+		if rf.isKilled(rule.ID) || !set.allows(rule.ID) {
+			continue
+		}
+		if !rule.matches(text) {
+			continue
+		}
+		action := rule.Action
+		if override, ok := overrides[rule.ID]; ok {
+			action = override
+		}
+		if action != ActionLog {
+			match.score += rule.score
+		}
+		if rule.Category != "" {
+			match.detected = append(match.detected, detection{category: rule.Category, action: action})
+		}
+		match.action = stricter(match.action, action)
+		if action == ActionBlock || action == ActionChallenge {
+			match.decisive = rule
+			match.action = action
+			return match
+		}
+		match.flagged = append(match.flagged, rule.ID)
+	}
+	return match
+}
+
+// matches reports whether any of a rule's targets in a request matches,
+// as received or normalized
+func (rule *customRule) matches(text *requestText) bool {
+	if rule.targets[TargetPath] && text.pathInput().matchedBy(rule) {
+		return true
+	}
+	if rule.targets[TargetQuery] && text.req.URL.RawQuery != "" && text.queryInput().matchedBy(rule) {
+		return true
+	}
+	if rule.targets[TargetHeader] {
+		for name, values := range text.headerInputs() {
+			if !rule.matchesHeader(name) {
+				continue
+			}
+			for _, value := range values {
+				if value.matchedBy(rule) {
+					return true
+				}
+			}
+		}
+	}
+	return rule.targets[TargetBody] && text.bodyInput().raw != "" && text.bodyInput().matchedBy(rule)
+}
+
+func (rule *customRule) matchesHeader(name string) bool {
+	if len(rule.Headers) == 0 {
+		return true
+	}
+	for _, header := range rule.Headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekBody reads up to limit bytes of a request's body and puts them back
+// in front of the rest, so the upstream still gets all of it
+func peekBody(req *http.Request, limit int64) []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	head, err := io.ReadAll(io.LimitReader(req.Body, limit))
+	req.Body = readCloser{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+	if err != nil {
+		return nil
+	}
+	return head
+}
+
+// readCloser reads from a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// rulesFileState is what LoadRules compares against to skip unchanged
+// files
+type rulesFileState struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func (s rulesFileState) equal(other rulesFileState) bool {
+	return s.path == other.path && s.modTime.Equal(other.modTime) && s.size == other.size
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRules = `
+rules:
+  - id: env-file
+    pattern: '\.env$'
+  - id: wp-probe
+    pattern: '(?i)wp-login'
+    action: flag
+    severity: medium
+  - id: log4shell
+    pattern: '\$\{jndi:'
+    targets: [header, body]
+    severity: critical
+`
+
+func TestCustomRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(testRules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rf := NewRequestFilter(1<<20, nil, nil)
+	if loaded, err := rf.LoadRules(path); err != nil || !loaded {
+		t.Fatalf("LoadRules() = %v, %v", loaded, err)
+	}
+
+	tests := []struct {
+		name, method, target, header, body string
+		blocked                            bool
+		reason                             string
+	}{
+		{"path", "GET", "/app/.env", "", "", true, "Blocked by rule env-file"},
+		{"flag", "GET", "/blog/wp-login", "", "", false, "Flagged by rules: wp-probe"},
+		{"header", "GET", "/", "${jndi:ldap://x}", "", true, "Blocked by rule log4shell"},
+		{"body", "POST", "/api", "", `{"name":"${jndi:ldap://x}"}`, true, "Blocked by rule log4shell"},
+		{"clean", "POST", "/api", "", `{"name":"alice"}`, false, "Request allowed"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		if tt.header != "" {
+			req.Header.Set("X-Api-Version", tt.header)
+		}
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Blocked != tt.blocked || result.Reason != tt.reason {
+			t.Errorf("%s: blocked %v (%s), want %v (%s)", tt.name, result.Blocked, result.Reason, tt.blocked, tt.reason)
+		}
+
+		// The upstream still gets the whole body
+		if body, err := io.ReadAll(req.Body); err != nil || string(body) != tt.body {
+			t.Errorf("%s: body after filtering %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestLoadRulesKeepsRulesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte(testRules), 0o644)
+	rf := NewRequestFilter(1<<20, nil, nil)
+	rf.LoadRules(path)
+
+	if loaded, err := rf.LoadRules(path); loaded || err != nil {
+		t.Errorf("LoadRules() of an unchanged file = %v, %v", loaded, err)
+	}
+
+	os.WriteFile(path, []byte("rules:\n  - id: broken\n    pattern: '('\n"), 0o644)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if _, err := rf.LoadRules(path); err == nil {
+		t.Error("LoadRules() accepted an invalid pattern")
+	}
+	if rules := rf.CustomRules(); len(rules) != 3 {
+		t.Errorf("%d rules in force after a failed reload, want 3", len(rules))
+	}
+}
+
+func TestParseRulesRejectsInvalidRules(t *testing.T) {
+	for _, data := range []string{
+		"rules:\n  - pattern: 'x'\n",
+		"rules:\n  - {id: a, pattern: 'x'}\n  - {id: a, pattern: 'y'}\n",
+		"rules:\n  - {id: a, pattern: 'x', action: drop}\n",
+		"rules:\n  - {id: a, pattern: 'x', severity: urgent}\n",
+		"rules:\n  - {id: a, pattern: 'x', targets: [cookie]}\n",
+		"rules:\n  - {id: a}\n",
+	} {
+		if _, err := ParseRules([]byte(data)); err == nil {
+			t.Errorf("ParseRules accepted %q", data)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// scanChunkSize is the size of the chunks files are streamed to a scanner
+// in
+const scanChunkSize = 32 << 10
+
+// ClamdScanner scans files with a clamd daemon over its INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd daemon at address, a
+// host:port or unix:/path/to/clamd.sock, timing scans out after timeout
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	if address == "" {
+		return nil, fmt.Errorf("clamd address is required")
+	}
+	network := "tcp"
+	if path := strings.TrimPrefix(address, "unix:"); path != address {
+		network, address = "unix", path
+	}
+	return &ClamdScanner{network: network, address: address, timeout: timeout}, nil
+}
+
+// Scan implements UploadScanner
+func (s *ClamdScanner) Scan(ctx context.Context, filename string, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, s.network, s.address, s.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	for len(data) > 0 {
+		n := len(data)
+		if n > scanChunkSize {
+			n = scanChunkSize
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(n))
+		w.Write(size[:])
+		w.Write(data[:n])
+		data = data[n:]
+	}
+	w.Write([]byte{0, 0, 0, 0})
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %v", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %v", err)
+	}
+	reply = strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), "\x00")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// ICAPScanner scans files with an ICAP server's RESPMOD service, as an
+// HTTP response carrying the file
+type ICAPScanner struct {
+	url     *url.URL
+	timeout time.Duration
+}
+
+// NewICAPScanner creates a scanner for the ICAP service at rawURL, such as
+// icap://127.0.0.1:1344/avscan, timing scans out after timeout
+func NewICAPScanner(rawURL string, timeout time.Duration) (*ICAPScanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICAP URL: %v", err)
+	}
+	if u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP URL %q", rawURL)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	return &ICAPScanner{url: u, timeout: timeout}, nil
+}
+
+// Scan implements UploadScanner. The server answers 204 for a clean file
+// and 200 with a replacement response for one it rejects.
+func (s *ICAPScanner) Scan(ctx context.Context, filename string, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, "tcp", s.url.Host, s.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reqHdr := fmt.Sprintf("GET /%s HTTP/1.1\r\nHost: upload\r\n\r\n", url.PathEscape(filename))
+	resHdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", len(data))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "RESPMOD %s ICAP/1.0\r\n", s.url)
+	fmt.Fprintf(&msg, "Host: %s\r\n", s.url.Host)
+	msg.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(&msg, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	msg.WriteString(reqHdr)
+	msg.WriteString(resHdr)
+	if len(data) > 0 {
+		fmt.Fprintf(&msg, "%x\r\n", len(data))
+		msg.Write(data)
+		msg.WriteString("\r\n")
+	}
+	msg.WriteString("0\r\n\r\n")
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return "", fmt.Errorf("icap: %v", err)
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("icap: %v", err)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("icap: %v", err)
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("icap: malformed status %q", status)
+	}
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+		return icapThreat(header), nil
+	}
+	return "", fmt.Errorf("icap: %s", status)
+}
+
+// icapThreat names the threat an ICAP server reported in its
+// X-Infection-Found header
+func icapThreat(header textproto.MIMEHeader) string {
+	for _, field := range strings.Split(header.Get("X-Infection-Found"), ";") {
+		if threat := strings.TrimPrefix(strings.TrimSpace(field), "Threat="); threat != strings.TrimSpace(field) {
+			return threat
+		}
+	}
+	return "threat"
+}
+
+// dialScanner connects to a scanner, bounding the whole exchange by the
+// timeout and the context's deadline
+func dialScanner(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
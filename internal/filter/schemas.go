@@ -0,0 +1,128 @@
+package filter
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"ddos-protection/internal/jsonschema"
+)
+
+// DefaultSchemaBodyLimit is the largest body validated against a route's
+// schema when the route sets no limit; larger bodies fail validation
+const DefaultSchemaBodyLimit = 1 << 20
+
+// RouteSchema is a JSON Schema the bodies of requests to a route must
+// match. Methods defaults to POST, PUT and PATCH, Action to block,
+// Severity to high and MaxBodySize to DefaultSchemaBodyLimit.
+type RouteSchema struct {
+	Name        string             `json:"name"`
+	Methods     []string           `json:"methods"`
+	Paths       []string           `json:"paths"`
+	Action      Action             `json:"action"`
+	Severity    string             `json:"severity"`
+	MaxBodySize int64              `json:"max_body_size"`
+	Schema      *jsonschema.Schema `json:"-"`
+}
+
+// SetSchemas replaces the route schemas. A request is validated against
+// the schema of the route whose longest path prefix matches it.
+func (rf *RequestFilter) SetSchemas(schemas []RouteSchema) error {
+	seen := make(map[string]bool, len(schemas))
+	routes := make([]RouteSchema, len(schemas))
+	for i, route := range schemas {
+		if route.Name == "" || seen[route.Name] {
+			return fmt.Errorf("schema %d: missing or duplicate name %q", i+1, route.Name)
+		}
+		seen[route.Name] = true
+		if len(route.Paths) == 0 || route.Schema == nil {
+			return fmt.Errorf("schema %s: paths and a schema are required", route.Name)
+		}
+		if len(route.Methods) == 0 {
+			route.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch}
+		}
+		for j, method := range route.Methods {
+			route.Methods[j] = strings.ToUpper(method)
+		}
+		if route.Action == "" {
+			route.Action = ActionBlock
+		}
+		if err := ruleAction(route.Action); err != nil {
+			return fmt.Errorf("schema %s: %v", route.Name, err)
+		}
+		if route.Severity == "" {
+			route.Severity = "high"
+		}
+		if _, ok := severities[route.Severity]; !ok {
+			return fmt.Errorf("schema %s: unknown severity %q", route.Name, route.Severity)
+		}
+		if route.MaxBodySize <= 0 {
+			route.MaxBodySize = DefaultSchemaBodyLimit
+		}
+		routes[i] = route
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.schemas = routes
+	return nil
+}
+
+// Schemas returns the route schemas in force
+func (rf *RequestFilter) Schemas() []RouteSchema {
+	rf.rulesMu.RLock()
+	defer rf.rulesMu.RUnlock()
+
+	return append([]RouteSchema(nil), rf.schemas...)
+}
+
+// schemaFor returns the schema of the route a request goes to, or nil
+func schemaFor(schemas []RouteSchema, req *http.Request) *RouteSchema {
+	var match *RouteSchema
+	longest := -1
+	for i := range schemas {
+		route := &schemas[i]
+		if !route.hasMethod(req.Method) {
+			continue
+		}
+		for _, prefix := range route.Paths {
+			if len(prefix) > longest && strings.HasPrefix(req.URL.Path, prefix) {
+				match, longest = route, len(prefix)
+			}
+		}
+	}
+	return match
+}
+
+func (route *RouteSchema) hasMethod(method string) bool {
+	for _, m := range route.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks a request's body against the route's schema. The body is
+// put back for the upstream, and seeds lazy so it is not read twice.
+func (route *RouteSchema) validate(req *http.Request, lazy *lazyBody) error {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("content type %q is not JSON", mediaType)
+	}
+	if req.ContentLength > route.MaxBodySize {
+		return fmt.Errorf("body larger than %d bytes", route.MaxBodySize)
+	}
+
+	data := peekBody(req, route.MaxBodySize+1)
+	if int64(len(data)) > route.MaxBodySize {
+		return fmt.Errorf("body larger than %d bytes", route.MaxBodySize)
+	}
+	lazy.seed(data)
+	if len(data) == 0 {
+		return fmt.Errorf("empty body")
+	}
+	return route.Schema.Validate(data)
+}
@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ddos-protection/internal/jsonschema"
+)
+
+func TestRouteSchemas(t *testing.T) {
+	order, err := jsonschema.Compile([]byte(`{
+		"type": "object",
+		"required": ["sku", "quantity"],
+		"properties": {"sku": {"type": "string"}, "quantity": {"type": "integer", "minimum": 1}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, _ := jsonschema.Compile([]byte(`{"type": "object"}`))
+
+	rf := NewRequestFilter(1<<20, nil, nil)
+	err = rf.SetSchemas([]RouteSchema{
+		{Name: "api", Paths: []string{"/api/"}, Schema: object, Action: ActionFlag, Severity: "medium"},
+		{Name: "orders", Paths: []string{"/api/orders"}, Schema: order, MaxBodySize: 64},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, target, contentType, body string
+		blocked                                 bool
+		reason                                  string
+	}{
+		{"valid", "POST", "/api/orders", "application/json", `{"sku":"A-1","quantity":2}`, false, "Request allowed"},
+		{"invalid", "POST", "/api/orders", "application/json", `{"sku":"A-1","quantity":0}`, true, "Body fails schema orders: /quantity: must be >= 1"},
+		{"malformed", "PUT", "/api/orders/7", "application/json; charset=utf-8", `{"sku":`, true, "Body fails schema orders: invalid JSON: unexpected EOF"},
+		{"not json", "POST", "/api/orders", "text/plain", `sku=A-1`, true, `Body fails schema orders: content type "text/plain" is not JSON`},
+		{"too large", "POST", "/api/orders", "application/json", `{"sku":"` + strings.Repeat("A", 100) + `","quantity":1}`, true, "Body fails schema orders: body larger than 64 bytes"},
+		{"flagged", "POST", "/api/users", "application/json", `[]`, false, "Body fails schema api: must be object, not array"},
+		{"other method", "GET", "/api/orders", "", "", false, "Request allowed"},
+		{"other route", "POST", "/upload", "text/plain", "hello", false, "Request allowed"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept", "*/*")
+		req.Header.Set("Accept-Language", "en")
+		req.Header.Set("Accept-Encoding", "gzip")
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Blocked != tt.blocked || result.Reason != tt.reason {
+			t.Errorf("%s: blocked %v (%s), want %v (%s)", tt.name, result.Blocked, result.Reason, tt.blocked, tt.reason)
+		}
+		if body, _ := io.ReadAll(req.Body); string(body) != tt.body {
+			t.Errorf("%s: body after filtering %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestSetSchemasValidates(t *testing.T) {
+	schema, _ := jsonschema.Compile([]byte(`{}`))
+	rf := NewRequestFilter(1<<20, nil, nil)
+	for _, routes := range [][]RouteSchema{
+		{{Name: "a", Paths: []string{"/a"}}},
+		{{Name: "a", Schema: schema}},
+		{{Name: "a", Paths: []string{"/a"}, Schema: schema}, {Name: "a", Paths: []string{"/b"}, Schema: schema}},
+		{{Name: "a", Paths: []string{"/a"}, Schema: schema, Action: "drop"}},
+		{{Name: "a", Paths: []string{"/a"}, Schema: schema, Severity: "severe"}},
+	} {
+		if err := rf.SetSchemas(routes); err == nil {
+			t.Errorf("SetSchemas(%+v) succeeded", routes)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxFileSize is the largest file an upload may carry when the
+// upload policy sets no limit
+const DefaultMaxFileSize = 10 << 20
+
+// UploadScanner checks an uploaded file with an external scanner, such as
+// clamd or an ICAP server. It returns the name of the threat found, or ""
+// if the file is clean.
+type UploadScanner interface {
+	Scan(ctx context.Context, filename string, data []byte) (string, error)
+}
+
+// UploadPolicy restricts the files multipart/form-data requests carry.
+// Files larger than MaxFileSize, past the first MaxFiles, with an
+// extension not in AllowedExtensions or a declared type not in
+// AllowedTypes are rejected; empty lists allow any extension but the
+// dangerous ones and any type. VerifyMagic rejects files whose content
+// does not match their extension and declared type, executables and files
+// embedding server-side scripts. Files that pass are sent to Scanner, if
+// set; when it fails the upload is logged and let through unless
+// FailClosed. Action defaults to block, Severity to high and MaxFileSize
+// to DefaultMaxFileSize.
+type UploadPolicy struct {
+	MaxFileSize       int64
+	MaxFiles          int
+	AllowedExtensions []string
+	AllowedTypes      []string
+	VerifyMagic       bool
+	Scanner           UploadScanner
+	FailClosed        bool
+	Action            Action
+	Severity          string
+}
+
+// dangerousExtensions run as code on a client or server. Without an
+// extension allowlist they are rejected anywhere in a file name, so that
+// shell.php.jpg is as well.
+var dangerousExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".com": true, ".scr": true, ".msi": true,
+	".bat": true, ".cmd": true, ".ps1": true, ".vbs": true, ".js": true,
+	".jse": true, ".wsf": true, ".hta": true, ".jar": true, ".sh": true,
+	".php": true, ".phtml": true, ".php5": true, ".phar": true, ".jsp": true,
+	".asp": true, ".aspx": true, ".cgi": true, ".pl": true, ".py": true,
+	".htaccess": true,
+}
+
+// scanError is a scanner failing to check a file, rather than finding it
+// dangerous
+type scanError struct {
+	err error
+}
+
+func (e *scanError) Error() string { return e.err.Error() }
+
+// SetUploadPolicy replaces the checks on multipart uploads; nil turns
+// them off
+func (rf *RequestFilter) SetUploadPolicy(policy *UploadPolicy) error {
+	if policy != nil {
+		p := *policy
+		if p.MaxFileSize <= 0 {
+			p.MaxFileSize = DefaultMaxFileSize
+		}
+		if p.Action == "" {
+			p.Action = ActionBlock
+		}
+		if err := ruleAction(p.Action); err != nil {
+			return fmt.Errorf("uploads: %v", err)
+		}
+		if p.Severity == "" {
+			p.Severity = "high"
+		}
+		if _, ok := severities[p.Severity]; !ok {
+			return fmt.Errorf("uploads: unknown severity %q", p.Severity)
+		}
+		p.AllowedExtensions = make([]string, len(policy.AllowedExtensions))
+		for i, ext := range policy.AllowedExtensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			p.AllowedExtensions[i] = ext
+		}
+		p.AllowedTypes = make([]string, len(policy.AllowedTypes))
+		for i, t := range policy.AllowedTypes {
+			p.AllowedTypes[i] = strings.ToLower(t)
+		}
+		policy = &p
+	}
+
+	rf.rulesMu.Lock()
+	defer rf.rulesMu.Unlock()
+
+	rf.uploads = policy
+	return nil
+}
+
+// check inspects the files of a multipart request, reading up to limit
+// bytes of it, and returns why it is rejected. A *scanError means the
+// scanner failed and the policy lets the upload through. The body is put
+// back for the upstream and seeds lazy.
+func (p *UploadPolicy) check(ctx context.Context, req *http.Request, limit int64, lazy *lazyBody) error {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil
+	}
+	if params["boundary"] == "" {
+		return fmt.Errorf("multipart body without a boundary")
+	}
+
+	data := peekBody(req, limit+1)
+	lazy.seed(data)
+	if int64(len(data)) > limit {
+		return fmt.Errorf("body larger than %d bytes", limit)
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+	files := 0
+	var failed error
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return failed
+		}
+		if err != nil {
+			return fmt.Errorf("malformed multipart body: %v", err)
+		}
+		name := part.FileName()
+		if name == "" {
+			continue
+		}
+		files++
+		if p.MaxFiles > 0 && files > p.MaxFiles {
+			return fmt.Errorf("more than %d files", p.MaxFiles)
+		}
+		content, err := io.ReadAll(io.LimitReader(part, p.MaxFileSize+1))
+		if err != nil {
+			return fmt.Errorf("malformed multipart body: %v", err)
+		}
+		if int64(len(content)) > p.MaxFileSize {
+			return fmt.Errorf("file %q larger than %d bytes", name, p.MaxFileSize)
+		}
+		if err := p.checkFile(ctx, name, part.Header.Get("Content-Type"), content); err != nil {
+			var scanErr *scanError
+			if !errors.As(err, &scanErr) {
+				return err
+			}
+			failed = err
+		}
+	}
+}
+
+// checkFile checks one uploaded file
+func (p *UploadPolicy) checkFile(ctx context.Context, name, contentType string, content []byte) error {
+	if err := p.checkExtension(name); err != nil {
+		return fmt.Errorf("file %q: %v", name, err)
+	}
+	declared, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		declared = ""
+	}
+	if len(p.AllowedTypes) > 0 && !contains(p.AllowedTypes, declared) {
+		return fmt.Errorf("file %q: type %q not allowed", name, declared)
+	}
+	if p.VerifyMagic {
+		if err := verifyMagic(name, declared, content); err != nil {
+			return fmt.Errorf("file %q: %v", name, err)
+		}
+	}
+	if p.Scanner == nil {
+		return nil
+	}
+
+	threat, err := p.Scanner.Scan(ctx, name, content)
+	if err != nil {
+		err = fmt.Errorf("file %q: scanner failed: %v", name, err)
+		if p.FailClosed {
+			return err
+		}
+		return &scanError{err}
+	}
+	if threat != "" {
+		return fmt.Errorf("file %q: %s found", name, threat)
+	}
+	return nil
+}
+
+// checkExtension checks the extensions of a file name: the last against
+// the allowlist, if there is one, and all against the dangerous ones
+// otherwise
+func (p *UploadPolicy) checkExtension(name string) error {
+	segments := strings.Split(strings.ToLower(name), ".")
+	ext := ""
+	if len(segments) > 1 {
+		ext = "." + segments[len(segments)-1]
+	}
+	if len(p.AllowedExtensions) > 0 {
+		if !contains(p.AllowedExtensions, ext) {
+			return fmt.Errorf("extension %q not allowed", ext)
+		}
+		return nil
+	}
+	for _, segment := range segments[1:] {
+		if dangerousExtensions["."+segment] {
+			return fmt.Errorf("extension %q not allowed", "."+segment)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
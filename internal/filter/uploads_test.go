@@ -0,0 +1,208 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+type upload struct {
+	name, contentType, content string
+}
+
+func uploadRequest(t *testing.T, files ...upload) (string, []byte) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("title", "holiday")
+	for _, f := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="file"; filename="`+f.name+`"`)
+		header.Set("Content-Type", f.contentType)
+		part, err := w.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f.content))
+	}
+	w.Close()
+	return w.FormDataContentType(), body.Bytes()
+}
+
+type stubScanner struct {
+	threat string
+	err    error
+}
+
+func (s stubScanner) Scan(ctx context.Context, filename string, data []byte) (string, error) {
+	return s.threat, s.err
+}
+
+func TestUploadPolicy(t *testing.T) {
+	strict := &UploadPolicy{
+		MaxFileSize:       64,
+		MaxFiles:          2,
+		AllowedExtensions: []string{"png", ".JPG", ".txt"},
+		AllowedTypes:      []string{"image/png", "image/jpeg", "text/plain"},
+		VerifyMagic:       true,
+	}
+	open := &UploadPolicy{VerifyMagic: true}
+
+	tests := []struct {
+		name   string
+		policy *UploadPolicy
+		files  []upload
+		reason string
+	}{
+		{"valid", strict, []upload{{"cat.png", "image/png", pngHeader}, {"notes.txt", "text/plain", "hello"}}, "Request allowed"},
+		{"too large", strict, []upload{{"cat.png", "image/png", pngHeader + strings.Repeat("x", 64)}}, `Upload rejected: file "cat.png" larger than 64 bytes`},
+		{"too many", strict, []upload{{"a.txt", "text/plain", "a"}, {"b.txt", "text/plain", "b"}, {"c.txt", "text/plain", "c"}}, "Upload rejected: more than 2 files"},
+		{"extension", strict, []upload{{"setup.exe", "image/png", pngHeader}}, `Upload rejected: file "setup.exe": extension ".exe" not allowed`},
+		{"type", strict, []upload{{"cat.png", "image/svg+xml", pngHeader}}, `Upload rejected: file "cat.png": type "image/svg+xml" not allowed`},
+		{"not an image", strict, []upload{{"cat.png", "image/png", "<html><body>hi</body></html>"}}, `Upload rejected: file "cat.png": content is text/html, not image/png`},
+		{"mismatched image", strict, []upload{{"cat.jpg", "image/jpeg", pngHeader}}, `Upload rejected: file "cat.jpg": content is image/png, not image/jpeg`},
+		{"executable", strict, []upload{{"notes.txt", "text/plain", "MZ\x90\x00\x03"}}, `Upload rejected: file "notes.txt": executable content`},
+		{"double extension", open, []upload{{"shell.php.jpg", "image/jpeg", "\xff\xd8\xff"}}, `Upload rejected: file "shell.php.jpg": extension ".php" not allowed`},
+		{"polyglot", open, []upload{{"cat.gif", "image/gif", "GIF89a<?PHP system($_GET['c']); ?>"}}, `Upload rejected: file "cat.gif": embedded server-side script`},
+		{"unknown type", open, []upload{{"data.bin", "application/octet-stream", "\x00\x01\x02"}}, "Request allowed"},
+		{"docx", open, []upload{{"cv.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "PK\x03\x04"}}, "Request allowed"},
+		{"threat", &UploadPolicy{Scanner: stubScanner{threat: "Eicar-Test-Signature"}}, []upload{{"a.txt", "text/plain", "x"}}, `Upload rejected: file "a.txt": Eicar-Test-Signature found`},
+		{"fail open", &UploadPolicy{Scanner: stubScanner{err: errors.New("refused")}}, []upload{{"a.txt", "text/plain", "x"}}, `Upload not scanned: file "a.txt": scanner failed: refused`},
+		{"fail closed", &UploadPolicy{Scanner: stubScanner{err: errors.New("refused")}, FailClosed: true}, []upload{{"a.txt", "text/plain", "x"}}, `Upload rejected: file "a.txt": scanner failed: refused`},
+	}
+	for _, tt := range tests {
+		rf := NewRequestFilter(1<<20, nil, nil)
+		if err := rf.SetUploadPolicy(tt.policy); err != nil {
+			t.Fatal(err)
+		}
+		contentType, body := uploadRequest(t, tt.files...)
+		req := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept", "*/*")
+		result := rf.FilterRequest(context.Background(), req)
+		if result.Reason != tt.reason {
+			t.Errorf("%s: %s, want %s", tt.name, result.Reason, tt.reason)
+		}
+		if blocked := strings.HasPrefix(tt.reason, "Upload rejected"); result.Blocked != blocked {
+			t.Errorf("%s: blocked %v, want %v", tt.name, result.Blocked, blocked)
+		}
+		if got, _ := io.ReadAll(req.Body); !bytes.Equal(got, body) {
+			t.Errorf("%s: body not put back", tt.name)
+		}
+	}
+
+	if err := NewRequestFilter(1<<20, nil, nil).SetUploadPolicy(&UploadPolicy{Action: "quarantine"}); err == nil {
+		t.Error("SetUploadPolicy() accepted an unknown action")
+	}
+}
+
+// serve answers one connection of a fake scanner
+func serve(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			handle(conn)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if cmd, _ := r.ReadString(0); cmd != "zINSTREAM\x00" {
+			return
+		}
+		var data []byte
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size[:])
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			io.ReadFull(r, chunk)
+			data = append(data, chunk...)
+		}
+		if bytes.Contains(data, []byte("EICAR")) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	})
+
+	scanner, err := NewClamdScanner(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("a", 3*scanChunkSize) + "EICAR"
+	for content, want := range map[string]string{"clean": "", large: "Eicar-Test-Signature"} {
+		if threat, err := scanner.Scan(context.Background(), "f", []byte(content)); err != nil || threat != want {
+			t.Errorf("Scan() = %q, %v, want %q", threat, err, want)
+		}
+	}
+}
+
+func TestICAPScanner(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		line, _ := r.ReadLine()
+		if !strings.HasPrefix(line, "RESPMOD icap://") {
+			return
+		}
+		r.ReadMIMEHeader() // ICAP headers
+		r.ReadLine()       // encapsulated request
+		r.ReadMIMEHeader()
+		r.ReadLine() // encapsulated response
+		r.ReadMIMEHeader()
+		size, _ := r.ReadLine()
+		var body []byte
+		if size != "0" {
+			body, _ = r.ReadLineBytes()
+		}
+		if bytes.Contains(body, []byte("EICAR")) {
+			conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\n\r\n"))
+		} else {
+			conn.Write([]byte("ICAP/1.0 204 No Content\r\n\r\n"))
+		}
+	})
+
+	scanner, err := NewICAPScanner("icap://"+addr+"/avscan", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for content, want := range map[string]string{"clean": "", "X5O!EICAR": "Eicar-Test-Signature"} {
+		if threat, err := scanner.Scan(context.Background(), "f.txt", []byte(content)); err != nil || threat != want {
+			t.Errorf("Scan() = %q, %v, want %q", threat, err, want)
+		}
+	}
+	if _, err := NewICAPScanner("http://"+addr, time.Second); err == nil {
+		t.Error("NewICAPScanner() accepted an http URL")
+	}
+}
@@ -0,0 +1,236 @@
+package forecast
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/intern"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxTenants bounds how many tenants get their own model
+const maxTenants = 1000
+
+// overflowTenant collects traffic of tenants beyond maxTenants
+const overflowTenant = intern.Other
+
+var forecastGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ddos_protection_forecast_requests_per_minute",
+	Help: "Peak forecasted request rate over the forecast horizon",
+}, []string{"tenant"})
+
+// HoltWinters is an additive triple exponential smoothing model
+type HoltWinters struct {
+	alpha    float64
+	beta     float64
+	gamma    float64
+	level    float64
+	trend    float64
+	seasonal []float64
+	n        int
+}
+
+// NewHoltWinters creates a model with the given smoothing factors and season length
+func NewHoltWinters(alpha, beta, gamma float64, seasonLength int) *HoltWinters {
+	if seasonLength < 1 {
+		seasonLength = 1
+	}
+	return &HoltWinters{
+		alpha:    alpha,
+		beta:     beta,
+		gamma:    gamma,
+		seasonal: make([]float64, seasonLength),
+	}
+}
+
+// Observe feeds the next observation into the model
+func (hw *HoltWinters) Observe(x float64) {
+	if hw.n == 0 {
+		hw.level = x
+		hw.n++
+		return
+	}
+
+	idx := hw.n % len(hw.seasonal)
+	season := hw.seasonal[idx]
+	prevLevel := hw.level
+
+	hw.level = hw.alpha*(x-season) + (1-hw.alpha)*(hw.level+hw.trend)
+	hw.trend = hw.beta*(hw.level-prevLevel) + (1-hw.beta)*hw.trend
+	hw.seasonal[idx] = hw.gamma*(x-hw.level) + (1-hw.gamma)*season
+	hw.n++
+}
+
+// Forecast predicts the value h steps after the last observation
+func (hw *HoltWinters) Forecast(h int) float64 {
+	if hw.n == 0 {
+		return 0
+	}
+
+	idx := (hw.n - 1 + h) % len(hw.seasonal)
+	value := hw.level + float64(h)*hw.trend + hw.seasonal[idx]
+	return math.Max(0, value)
+}
+
+// Risk describes a tenant whose forecasted load approaches its capacity
+type Risk struct {
+	Tenant    string        `json:"tenant"`
+	Forecast  float64       `json:"forecast_rpm"`
+	Current   float64       `json:"current_rpm"`
+	Capacity  float64       `json:"capacity_rpm"`
+	Horizon   time.Duration `json:"horizon"`
+	AtRisk    bool          `json:"at_risk"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type tenantSeries struct {
+	count int64
+	model *HoltWinters
+	last  Risk
+}
+
+// Config holds forecaster settings
+type Config struct {
+	BucketInterval  time.Duration
+	SeasonLength    int
+	Horizon         int
+	Alpha           float64
+	Beta            float64
+	Gamma           float64
+	RiskRatio       float64
+	DefaultCapacity float64
+	TenantCapacity  map[string]float64
+}
+
+// Forecaster tracks per-tenant request rates and raises capacity risks
+// before forecasted load saturates configured capacity
+type Forecaster struct {
+	cfg     Config
+	tenants map[string]*tenantSeries
+	mu      sync.Mutex
+	onRisk  func(Risk)
+}
+
+// NewForecaster creates a new forecaster. onRisk is called whenever a tenant
+// enters or leaves the at-risk state.
+func NewForecaster(cfg Config, onRisk func(Risk)) *Forecaster {
+	return &Forecaster{
+		cfg:     cfg,
+		tenants: make(map[string]*tenantSeries),
+		onRisk:  onRisk,
+	}
+}
+
+// Record counts one request for a tenant
+func (f *Forecaster) Record(tenant string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	series, exists := f.tenants[tenant]
+	if !exists {
+		if len(f.tenants) >= maxTenants {
+			tenant = overflowTenant
+			series = f.tenants[tenant]
+		}
+		if series == nil {
+			series = &tenantSeries{
+				model: NewHoltWinters(f.cfg.Alpha, f.cfg.Beta, f.cfg.Gamma, f.cfg.SeasonLength),
+				last:  Risk{Tenant: tenant},
+			}
+			f.tenants[tenant] = series
+		}
+	}
+
+	series.count++
+}
+
+// Run closes a bucket every interval until ctx is cancelled
+func (f *Forecaster) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.cfg.BucketInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.tick()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick feeds the finished bucket into each tenant's model and re-evaluates risk
+func (f *Forecaster) tick() {
+	perMinute := float64(time.Minute) / float64(f.cfg.BucketInterval)
+
+	var changed []Risk
+	f.mu.Lock()
+	for tenant, series := range f.tenants {
+		series.model.Observe(float64(series.count))
+		current := float64(series.count) * perMinute
+		series.count = 0
+
+		peak := 0.0
+		for h := 1; h <= f.cfg.Horizon; h++ {
+			peak = math.Max(peak, series.model.Forecast(h))
+		}
+		peak *= perMinute
+
+		capacity := f.capacity(tenant)
+		atRisk := capacity > 0 && peak >= capacity*f.cfg.RiskRatio
+
+		risk := Risk{
+			Tenant:    tenant,
+			Forecast:  peak,
+			Current:   current,
+			Capacity:  capacity,
+			Horizon:   time.Duration(f.cfg.Horizon) * f.cfg.BucketInterval,
+			AtRisk:    atRisk,
+			UpdatedAt: time.Now(),
+		}
+		if atRisk != series.last.AtRisk {
+			changed = append(changed, risk)
+		}
+		series.last = risk
+
+		forecastGauge.WithLabelValues(tenant).Set(peak)
+	}
+	f.mu.Unlock()
+
+	// Callbacks run outside the lock so they may call back into the forecaster
+	if f.onRisk != nil {
+		for _, risk := range changed {
+			f.onRisk(risk)
+		}
+	}
+}
+
+// capacity returns the configured capacity of a tenant in requests per minute
+func (f *Forecaster) capacity(tenant string) float64 {
+	if capacity, exists := f.cfg.TenantCapacity[tenant]; exists {
+		return capacity
+	}
+	return f.cfg.DefaultCapacity
+}
+
+// GetForecasts returns the latest forecast of every tenant
+func (f *Forecaster) GetForecasts() []Risk {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]Risk, 0, len(f.tenants))
+	for _, series := range f.tenants {
+		result = append(result, series.last)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tenant < result[j].Tenant
+	})
+
+	return result
+}
@@ -0,0 +1,47 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoltWintersTracksTrend(t *testing.T) {
+	hw := NewHoltWinters(0.5, 0.3, 0.1, 4)
+	for i := 0; i < 40; i++ {
+		hw.Observe(float64(100 + 10*i))
+	}
+
+	next := hw.Forecast(1)
+	later := hw.Forecast(5)
+	if next < 450 || next > 550 {
+		t.Errorf("Forecast(1) = %.1f, expected close to 500", next)
+	}
+	if later <= next {
+		t.Errorf("Forecast(5) = %.1f should exceed Forecast(1) = %.1f on a rising series", later, next)
+	}
+}
+
+func TestForecasterRaisesCapacityRisk(t *testing.T) {
+	var risks []Risk
+	f := NewForecaster(Config{
+		BucketInterval:  time.Second,
+		SeasonLength:    1,
+		Horizon:         2,
+		Alpha:           0.8,
+		Beta:            0.5,
+		Gamma:           0,
+		RiskRatio:       0.8,
+		DefaultCapacity: 6000, // 100 requests per 1s bucket
+	}, func(r Risk) { risks = append(risks, r) })
+
+	for bucket := 1; bucket <= 5; bucket++ {
+		for i := 0; i < bucket*20; i++ {
+			f.Record("acme")
+		}
+		f.tick()
+	}
+
+	if len(risks) == 0 || !risks[0].AtRisk || risks[0].Tenant != "acme" {
+		t.Fatalf("expected a capacity risk for acme, got %+v", risks)
+	}
+}
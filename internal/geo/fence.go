@@ -0,0 +1,180 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule restricts when a fence is in force. An empty schedule is always
+// active.
+type Schedule struct {
+	Days           []time.Weekday
+	Start          time.Duration // offset from midnight
+	End            time.Duration // offset from midnight; before Start wraps past midnight
+	Location       *time.Location
+	DuringIncident bool
+}
+
+// ParseSchedule builds a schedule from day names ("mon".."sun"), "HH:MM"
+// times and an IANA timezone name
+func ParseSchedule(days []string, start, end, timezone string, duringIncident bool) (Schedule, error) {
+	s := Schedule{DuringIncident: duringIncident, Location: time.UTC}
+
+	for _, day := range days {
+		wd, err := parseWeekday(day)
+		if err != nil {
+			return s, err
+		}
+		s.Days = append(s.Days, wd)
+	}
+
+	if (start == "") != (end == "") {
+		return s, fmt.Errorf("schedule needs both start and end")
+	}
+	if start != "" {
+		var err error
+		if s.Start, err = parseClock(start); err != nil {
+			return s, err
+		}
+		if s.End, err = parseClock(end); err != nil {
+			return s, err
+		}
+	}
+
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return s, fmt.Errorf("invalid timezone %q: %v", timezone, err)
+		}
+		s.Location = loc
+	}
+
+	return s, nil
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	prefix := strings.ToLower(strings.TrimSpace(day))
+	if len(prefix) >= 3 {
+		prefix = prefix[:3]
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.ToLower(wd.String()[:3]) == prefix {
+			return wd, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", day)
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active reports whether the schedule is in force at now
+func (s Schedule) Active(now time.Time, incident bool) bool {
+	if s.DuringIncident && !incident {
+		return false
+	}
+
+	if s.Location != nil {
+		now = now.In(s.Location)
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	day := now.Weekday()
+
+	if s.Start != s.End {
+		if s.Start < s.End {
+			if offset < s.Start || offset >= s.End {
+				return false
+			}
+		} else if offset < s.Start {
+			if offset >= s.End {
+				return false
+			}
+			// Early-morning part of a window that started the previous day
+			day = (day + 6) % 7
+		}
+	}
+
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Fence applies a country policy to a set of paths while its schedule is
+// active
+type Fence struct {
+	Name     string
+	Paths    []string
+	Policy   *Policy
+	Schedule Schedule
+}
+
+// FenceStatus describes a fence and whether it is currently in force
+type FenceStatus struct {
+	Name   string   `json:"name"`
+	Paths  []string `json:"paths"`
+	Active bool     `json:"active"`
+}
+
+// Matches reports whether the fence covers path
+func (f *Fence) Matches(path string) bool {
+	if len(f.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range f.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fences is an ordered set of geofencing rules
+type Fences []*Fence
+
+// Decide returns the strictest action of the active fences covering path,
+// and the name of the fence that produced it
+func (fs Fences) Decide(country, path string, now time.Time, incident bool) (Action, string) {
+	result, name := ActionAllow, ""
+	for _, f := range fs {
+		if !f.Matches(path) || !f.Schedule.Active(now, incident) {
+			continue
+		}
+
+		switch f.Policy.decide(country) {
+		case ActionBlock:
+			return ActionBlock, f.Name
+		case ActionChallenge:
+			if result == ActionAllow {
+				result, name = ActionChallenge, f.Name
+			}
+		}
+	}
+
+	return result, name
+}
+
+// Status returns every fence with its current state
+func (fs Fences) Status(now time.Time, incident bool) []FenceStatus {
+	result := make([]FenceStatus, 0, len(fs))
+	for _, f := range fs {
+		result = append(result, FenceStatus{
+			Name:   f.Name,
+			Paths:  f.Paths,
+			Active: f.Schedule.Active(now, incident),
+		})
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package geo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	overnight, err := ParseSchedule([]string{"fri"}, "22:00", "06:00", "UTC", false)
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+	incident, err := ParseSchedule(nil, "", "", "", true)
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+
+	// 2024-03-01 is a Friday
+	friLate := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	satEarly := time.Date(2024, 3, 2, 5, 0, 0, 0, time.UTC)
+	satLate := time.Date(2024, 3, 2, 23, 0, 0, 0, time.UTC)
+	friNoon := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		incident bool
+		expected bool
+	}{
+		{"Always active", Schedule{}, friNoon, false, true},
+		{"Overnight window start", overnight, friLate, false, true},
+		{"Overnight window after midnight", overnight, satEarly, false, true},
+		{"Overnight window wrong day", overnight, satLate, false, false},
+		{"Outside overnight window", overnight, friNoon, false, false},
+		{"Incident only, no incident", incident, friNoon, false, false},
+		{"Incident only, during incident", incident, friNoon, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Active(tt.now, tt.incident); got != tt.expected {
+				t.Errorf("Active() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFencesDecide(t *testing.T) {
+	fences := Fences{
+		{
+			Name:   "admin-domestic",
+			Paths:  []string{"/admin"},
+			Policy: NewPolicy(nil, nil, []string{"US"}, ActionChallenge),
+		},
+		{
+			Name:   "soft",
+			Policy: NewPolicy(nil, []string{"FR"}, nil, ActionAllow),
+		},
+	}
+	now := time.Now()
+
+	tests := []struct {
+		country  string
+		path     string
+		expected Action
+		fence    string
+	}{
+		{"US", "/admin/users", ActionAllow, ""},
+		{"DE", "/admin/users", ActionBlock, "admin-domestic"},
+		{"DE", "/public", ActionAllow, ""},
+		{UnknownCountry, "/admin", ActionChallenge, "admin-domestic"},
+		{"FR", "/public", ActionChallenge, "soft"},
+		{"FR", "/admin", ActionBlock, "admin-domestic"},
+	}
+
+	for _, tt := range tests {
+		action, fence := fences.Decide(tt.country, tt.path, now, false)
+		if action != tt.expected || fence != tt.fence {
+			t.Errorf("Decide(%q, %q) = %s, %q; want %s, %q", tt.country, tt.path, action, fence, tt.expected, tt.fence)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Action is the decision taken for a request's country
+type Action string
+
+const (
+	ActionAllow     Action = "allow"
+	ActionBlock     Action = "block"
+	ActionChallenge Action = "challenge"
+)
+
+// UnknownCountry is reported when an address has no country in the database
+const UnknownCountry = "--"
+
+var countryCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_geo_requests_total",
+	Help: "Requests by client country and geo policy action",
+}, []string{"country", "action"})
+
+// countryRecord is the subset of GeoIP2/GeoLite2 fields we decode
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Database resolves IP addresses to countries from a MaxMind mmdb file
+type Database struct {
+	path    string
+	reader  *maxminddb.Reader
+	modTime time.Time
+	mu      sync.RWMutex
+}
+
+// Open opens a MaxMind GeoIP2 or GeoLite2 database
+func Open(path string) (*Database, error) {
+	db := &Database{path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reopens the database file, swapping readers atomically
+func (db *Database) Reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database %s: %v", db.path, err)
+	}
+
+	db.mu.Lock()
+	old := db.reader
+	db.reader = reader
+	db.modTime = info.ModTime()
+	db.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// ReloadIfChanged reloads the database when the file has been replaced.
+// It reports whether a reload happened.
+func (db *Database) ReloadIfChanged() (bool, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, err
+	}
+
+	db.mu.RLock()
+	unchanged := info.ModTime().Equal(db.modTime)
+	db.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+	return true, db.Reload()
+}
+
+// Country returns the ISO country code for ip, falling back to the
+// registered country for anycast and satellite ranges
+func (db *Database) Country(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return UnknownCountry
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var record countryRecord
+	if err := db.reader.Lookup(parsed, &record); err != nil {
+		return UnknownCountry
+	}
+
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	if record.RegisteredCountry.ISOCode != "" {
+		return record.RegisteredCountry.ISOCode
+	}
+	return UnknownCountry
+}
+
+// Metadata returns the database type and build time
+func (db *Database) Metadata() (string, time.Time) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.reader.Metadata.DatabaseType, time.Unix(int64(db.reader.Metadata.BuildEpoch), 0)
+}
+
+// Close closes the database
+func (db *Database) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.reader == nil {
+		return nil
+	}
+	return db.reader.Close()
+}
+
+// Policy decides what to do with requests from each country
+type Policy struct {
+	block         map[string]bool
+	challenge     map[string]bool
+	allow         map[string]bool
+	unknownAction Action
+}
+
+// NewPolicy creates a country policy. When allow is non-empty the policy is
+// an allow-list: countries outside it are blocked. Block takes precedence
+// over challenge.
+func NewPolicy(block, challenge, allow []string, unknownAction Action) *Policy {
+	if unknownAction == "" {
+		unknownAction = ActionAllow
+	}
+	return &Policy{
+		block:         toSet(block),
+		challenge:     toSet(challenge),
+		allow:         toSet(allow),
+		unknownAction: unknownAction,
+	}
+}
+
+func toSet(codes []string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	return set
+}
+
+// Decide returns the action for a country and counts the request
+func (p *Policy) Decide(country string) Action {
+	action := p.decide(country)
+	countryCounter.WithLabelValues(country, string(action)).Inc()
+	return action
+}
+
+func (p *Policy) decide(country string) Action {
+	if country == UnknownCountry {
+		return p.unknownAction
+	}
+	if p.block[country] {
+		return ActionBlock
+	}
+	if p.challenge[country] {
+		return ActionChallenge
+	}
+	if len(p.allow) > 0 && !p.allow[country] {
+		return ActionBlock
+	}
+	return ActionAllow
+}
@@ -0,0 +1,63 @@
+package geo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyDecide(t *testing.T) {
+	denyList := NewPolicy([]string{"kp", " RU "}, []string{"CN", "RU"}, nil, "")
+	allowList := NewPolicy([]string{"FR"}, []string{"DE"}, []string{"US", "DE", "FR"}, ActionChallenge)
+
+	tests := []struct {
+		name     string
+		policy   *Policy
+		country  string
+		expected Action
+	}{
+		{"Blocked country, listed in lower case", denyList, "KP", ActionBlock},
+		{"Block takes precedence over challenge", denyList, "RU", ActionBlock},
+		{"Challenged country", denyList, "CN", ActionChallenge},
+		{"Unlisted country", denyList, "NL", ActionAllow},
+		{"Unknown country allowed by default", denyList, UnknownCountry, ActionAllow},
+		{"Allow-listed country", allowList, "US", ActionAllow},
+		{"Country outside the allow-list", allowList, "NL", ActionBlock},
+		{"Challenge applies inside the allow-list", allowList, "DE", ActionChallenge},
+		{"Block applies inside the allow-list", allowList, "FR", ActionBlock},
+		{"Unknown country action", allowList, UnknownCountry, ActionChallenge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Decide(tt.country); got != tt.expected {
+				t.Errorf("Decide(%q) = %s, want %s", tt.country, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOpenRejectsBadDatabases(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.mmdb")
+	if err := os.WriteFile(garbage, []byte("not a MaxMind database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"Missing file", filepath.Join(dir, "missing.mmdb")},
+		{"Not a database", garbage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if db, err := Open(tt.path); err == nil {
+				db.Close()
+				t.Errorf("Open(%s) succeeded", tt.path)
+			}
+		})
+	}
+}
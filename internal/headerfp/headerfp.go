@@ -0,0 +1,227 @@
+// Package headerfp fingerprints HTTP/1 clients by the order and casing of
+// their request headers. net/http canonicalizes header names into a map, so
+// both are lost by the time a handler runs, yet every HTTP stack writes its
+// headers its own way: browsers, curl, Go, Python and attack tools can be
+// told apart even when they send the same user agent.
+//
+// A Listener records the header block of the first request on each
+// connection as the server reads it, and ConnContext makes the fingerprint
+// available to the connection's requests through FromContext. Only
+// plaintext HTTP/1 connections can be fingerprinted: TLS is decrypted
+// inside the server, and HTTP/2 lowercases and compresses header names.
+package headerfp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxHeaderSize bounds the bytes buffered while waiting for the headers
+const maxHeaderSize = 16 << 10
+
+// variable are headers sent or left out depending on the request rather
+// than the client stack, such as cookies and conditional headers. They are
+// left out of the fingerprint so it stays the same across a client's
+// requests.
+var variable = map[string]bool{
+	"authorization":     true,
+	"cache-control":     true,
+	"content-length":    true,
+	"content-type":      true,
+	"cookie":            true,
+	"forwarded":         true,
+	"if-match":          true,
+	"if-modified-since": true,
+	"if-none-match":     true,
+	"if-range":          true,
+	"origin":            true,
+	"pragma":            true,
+	"range":             true,
+	"referer":           true,
+	"x-forwarded-for":   true,
+	"x-forwarded-host":  true,
+	"x-forwarded-proto": true,
+	"x-real-ip":         true,
+}
+
+// Fingerprint identifies the HTTP stack of a client
+type Fingerprint struct {
+	// Headers are the names of the headers fingerprinted, as sent
+	Headers []string `json:"headers"`
+	// Hash is a short hash of Headers, like "07_3f9a1c2b4d5e": the number
+	// of headers and the first 12 hex digits of their SHA-256
+	Hash string `json:"hash"`
+	// Framing describes conflicting message framing in the header block:
+	// both Content-Length and Transfer-Encoding, or Content-Lengths that
+	// differ. net/http settles such requests and drops the evidence, but
+	// a client sending them is probing for request smuggling.
+	Framing string `json:"framing,omitempty"`
+}
+
+// Parse fingerprints a request header block, from the request line up to
+// the blank line ending the headers
+func Parse(block []byte) (*Fingerprint, error) {
+	lines := strings.Split(strings.TrimRight(string(block), "\r\n"), "\n")
+	if len(lines) == 0 || !strings.Contains(lines[0], " HTTP/1.") {
+		return nil, fmt.Errorf("not an HTTP/1 request")
+	}
+
+	var headers, lengths []string
+	chunked := false
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Obsolete folding continues the previous header
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed header line")
+		}
+		switch strings.ToLower(name) {
+		case "content-length":
+			lengths = append(lengths, strings.TrimSpace(value))
+		case "transfer-encoding":
+			chunked = true
+		}
+		if !variable[strings.ToLower(name)] {
+			headers = append(headers, name)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(headers, "\n")))
+	return &Fingerprint{
+		Headers: headers,
+		Hash:    fmt.Sprintf("%02d_%s", len(headers), hex.EncodeToString(sum[:6])),
+		Framing: framing(lengths, chunked),
+	}, nil
+}
+
+// framing describes a conflict between the Content-Length and
+// Transfer-Encoding headers of a block, or returns ""
+func framing(lengths []string, chunked bool) string {
+	if len(lengths) > 0 && chunked {
+		return "both Content-Length and Transfer-Encoding"
+	}
+	for i := 1; i < len(lengths); i++ {
+		if lengths[i] != lengths[0] {
+			return "conflicting Content-Length headers"
+		}
+	}
+	return ""
+}
+
+// Listener wraps a listener so the connections it accepts fingerprint the
+// headers of their first request
+type Listener struct {
+	net.Listener
+}
+
+// NewListener wraps inner
+func NewListener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner}
+}
+
+// Accept accepts a connection that records its first request's headers
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Conn passes reads through unchanged, recording the first header block
+// among them
+type Conn struct {
+	net.Conn
+
+	mu          sync.Mutex
+	pending     []byte
+	done        bool
+	fingerprint *Fingerprint
+}
+
+// Read reads from the connection, recording the first header block
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.observe(p[:n])
+	}
+	return n, err
+}
+
+// Fingerprint returns the fingerprint of the connection's first request,
+// or nil if it was not read or could not be parsed
+func (c *Conn) Fingerprint() *Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fingerprint
+}
+
+// observe collects bytes until the end of the first header block
+func (c *Conn) observe(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	c.pending = append(c.pending, data...)
+	if end := bytes.Index(c.pending, []byte("\r\n\r\n")); end >= 0 {
+		if fingerprint, err := Parse(c.pending[:end]); err == nil {
+			c.fingerprint = fingerprint
+		}
+		c.finish()
+		return
+	}
+	if len(c.pending) > maxHeaderSize {
+		c.finish()
+	}
+}
+
+// finish stops recording and drops the buffer; c.mu must be held
+func (c *Conn) finish() {
+	c.done = true
+	c.pending = nil
+}
+
+type connKey struct{}
+
+// ConnContext is an http.Server ConnContext making the fingerprint of each
+// connection accepted from a Listener available to its requests
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if fpConn, ok := conn.(*Conn); ok {
+		return context.WithValue(ctx, connKey{}, fpConn)
+	}
+	return ctx
+}
+
+// FromContext returns the header fingerprint of the connection a request
+// came in on, or nil for TLS, HTTP/2 and unparsable requests
+func FromContext(ctx context.Context) *Fingerprint {
+	conn, ok := ctx.Value(connKey{}).(*Conn)
+	if !ok {
+		return nil
+	}
+	return conn.Fingerprint()
+}
+
+// ForRequest returns the header fingerprint of r's connection. HTTP/2
+// requests get none, even on a connection fingerprinted before an upgrade.
+func ForRequest(r *http.Request) *Fingerprint {
+	if r.ProtoMajor != 1 {
+		return nil
+	}
+	return FromContext(r.Context())
+}
@@ -0,0 +1,84 @@
+package headerfp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	chrome := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive\r\nUser-Agent: Mozilla/5.0\r\nAccept: text/html\r\nAccept-Encoding: gzip\r\nAccept-Language: en\r\n"
+	withCookie := "GET /cart HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive\r\nUser-Agent: Mozilla/5.0\r\nAccept: text/html\r\nReferer: https://example.com/\r\nAccept-Encoding: gzip\r\nAccept-Language: en\r\nCookie: a=b\r\n"
+	reordered := "GET / HTTP/1.1\r\nUser-Agent: Mozilla/5.0\r\nHost: example.com\r\nAccept: text/html\r\nAccept-Encoding: gzip\r\nAccept-Language: en\r\nConnection: keep-alive\r\n"
+	lowercase := "GET / HTTP/1.1\r\n" + strings.ToLower(strings.SplitN(chrome, "\r\n", 2)[1])
+
+	fp, err := Parse([]byte(chrome))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := strings.Join(fp.Headers, ","); got != "Host,Connection,User-Agent,Accept,Accept-Encoding,Accept-Language" {
+		t.Errorf("headers = %s", got)
+	}
+	if !strings.HasPrefix(fp.Hash, "06_") || len(fp.Hash) != 15 {
+		t.Errorf("hash = %q", fp.Hash)
+	}
+
+	same, _ := Parse([]byte(withCookie))
+	if same.Hash != fp.Hash {
+		t.Error("request-specific headers changed the fingerprint")
+	}
+	for name, block := range map[string]string{"reordered": reordered, "lowercase": lowercase} {
+		other, err := Parse([]byte(block))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if other.Hash == fp.Hash {
+			t.Errorf("%s headers have the same fingerprint", name)
+		}
+	}
+
+	if fp.Framing != "" {
+		t.Errorf("framing = %q", fp.Framing)
+	}
+	for block, want := range map[string]string{
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n": "both Content-Length and Transfer-Encoding",
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\ncontent-length: 40\r\n":         "conflicting Content-Length headers",
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nContent-Length:  4\r\n":         "",
+	} {
+		if got, _ := Parse([]byte(block)); got.Framing != want {
+			t.Errorf("framing of %q = %q, want %q", block, got.Framing, want)
+		}
+	}
+
+	if _, err := Parse([]byte("PRI * HTTP/2.0\r\n")); err == nil {
+		t.Error("HTTP/2 preface parsed")
+	}
+}
+
+func TestConnFingerprintsFirstRequest(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := &Conn{Conn: server}
+	go func() {
+		// Written in pieces, as a slow client would
+		for _, part := range []string{"GET / HTTP/1.1\r\nHo", "st: example.com\r\nX-Tool: 1\r", "\n\r\n"} {
+			client.Write([]byte(part))
+		}
+	}()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	if req.Host != "example.com" {
+		t.Errorf("request not passed through: host %q", req.Host)
+	}
+
+	fp := conn.Fingerprint()
+	if fp == nil || strings.Join(fp.Headers, ",") != "Host,X-Tool" {
+		t.Errorf("fingerprint = %+v", fp)
+	}
+}
@@ -24,6 +24,12 @@ type HealthCheck interface {
 	IsCritical() bool
 }
 
+// Reporter is implemented by health checks that describe their state in
+// place of "OK" when healthy
+type Reporter interface {
+	Report() string
+}
+
 // HealthStatus represents the overall health status
 type HealthStatus struct {
 	Status    string                 `json:"status"`
@@ -196,6 +202,9 @@ func (hc *HealthChecker) runHealthCheck(ctx context.Context, name string, check
 		cb.RecordSuccess()
 		result.Status = "healthy"
 		result.Message = "OK"
+		if reporter, ok := check.(Reporter); ok {
+			result.Message = reporter.Report()
+		}
 	}
 
 	return result
@@ -0,0 +1,119 @@
+// Package intern deduplicates strings kept on the request hot path, such as
+// user agents and paths, and bounds how many distinct values each dictionary
+// holds. Under attack every request can carry a fresh value; once a
+// dictionary is full, unseen values are bucketed as Other instead of growing
+// maps and metric label sets without limit.
+package intern
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Other replaces values that arrive after a dictionary is full
+const Other = "other"
+
+var overflowCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_cardinality_overflow_total",
+	Help: "Values bucketed as other because their dictionary was full, by dictionary",
+}, []string{"dictionary"})
+
+// Table is a bounded dictionary of canonical strings. It is safe for
+// concurrent use.
+type Table struct {
+	name      string
+	capacity  int
+	maxLength int
+
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// NewTable creates a dictionary holding up to capacity distinct values of at
+// most maxLength bytes. A capacity or maxLength of 0 means no limit.
+func NewTable(name string, capacity, maxLength int) *Table {
+	return &Table{
+		name:      name,
+		capacity:  capacity,
+		maxLength: maxLength,
+		values:    make(map[string]string),
+	}
+}
+
+// Intern returns the canonical copy of s, so repeated values share one
+// allocation however many maps hold them. Long values are truncated, and
+// values not yet seen when the table is full return Other.
+func (t *Table) Intern(s string) string {
+	if t == nil {
+		return s
+	}
+	s = Truncate(s, t.maxLength)
+
+	t.mu.RLock()
+	canonical, exists := t.values[s]
+	t.mu.RUnlock()
+	if exists {
+		return canonical
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if canonical, exists := t.values[s]; exists {
+		return canonical
+	}
+	if t.capacity > 0 && len(t.values) >= t.capacity {
+		overflowCounter.WithLabelValues(t.name).Inc()
+		return Other
+	}
+
+	// Copy so the table does not pin the request buffer s was sliced from
+	canonical = string([]byte(s))
+	t.values[canonical] = canonical
+	return canonical
+}
+
+// Len returns the number of values in the table
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.values)
+}
+
+// Reset empties the table
+func (t *Table) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.values = make(map[string]string)
+}
+
+// Key returns key if m already holds it or has room for it under limit, and
+// Other otherwise. It bounds maps owned by a single client, where a global
+// dictionary would let one client exhaust it for everyone. A limit of 0
+// means no limit.
+func Key[V any](m map[string]V, key string, limit int) string {
+	if limit <= 0 {
+		return key
+	}
+	if _, exists := m[key]; exists || len(m) < limit {
+		return key
+	}
+	return Other
+}
+
+// Truncate shortens s to at most n bytes without splitting a UTF-8
+// sequence. An n of 0 means no limit.
+func Truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,52 @@
+package intern
+
+import "testing"
+
+func TestTableInternsAndBuckets(t *testing.T) {
+	table := NewTable("test", 2, 0)
+
+	a := table.Intern(string([]byte("Mozilla/5.0")))
+	b := table.Intern(string([]byte("Mozilla/5.0")))
+	if a != b || table.Len() != 1 {
+		t.Fatalf("repeated value should be interned once, got %d values", table.Len())
+	}
+
+	table.Intern("curl/8.0")
+	if got := table.Intern("python-requests/2.31"); got != Other {
+		t.Errorf("value beyond capacity = %q, want %q", got, Other)
+	}
+	if got := table.Intern("curl/8.0"); got != "curl/8.0" {
+		t.Errorf("known value after overflow = %q", got)
+	}
+	if table.Len() != 2 {
+		t.Errorf("table grew past its capacity: %d values", table.Len())
+	}
+
+	table.Reset()
+	if got := table.Intern("python-requests/2.31"); got != "python-requests/2.31" {
+		t.Errorf("value after reset = %q", got)
+	}
+}
+
+func TestTruncateKeepsUTF8(t *testing.T) {
+	table := NewTable("test", 0, 4)
+	if got := table.Intern("abcdef"); got != "abcd" {
+		t.Errorf("Intern truncated to %q, want abcd", got)
+	}
+	if got := Truncate("ab€", 3); got != "ab" {
+		t.Errorf("Truncate split a rune: %q", got)
+	}
+}
+
+func TestKeyBoundsMap(t *testing.T) {
+	m := map[string]int{"/": 1, "/login": 1}
+	if got := Key(m, "/login", 2); got != "/login" {
+		t.Errorf("existing key = %q", got)
+	}
+	if got := Key(m, "/admin", 2); got != Other {
+		t.Errorf("key beyond limit = %q, want %q", got, Other)
+	}
+	if got := Key(m, "/admin", 0); got != "/admin" {
+		t.Errorf("unlimited key = %q", got)
+	}
+}
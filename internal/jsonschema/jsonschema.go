@@ -0,0 +1,436 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema (draft 7 and later) that API payloads are usually described with:
+// type, enum, const, properties, required, additionalProperties,
+// min/maxProperties, items, min/maxItems, min/maxLength, pattern,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf,
+// oneOf, not, and $ref to the schema's own definitions or $defs. Other
+// keywords, such as format, are ignored, as the specification allows.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	root *node
+}
+
+// node is one compiled schema or subschema
+type node struct {
+	always *bool // a boolean schema
+
+	types    []string
+	enum     []interface{}
+	hasConst bool
+	constant interface{}
+
+	properties   map[string]*node
+	required     []string
+	additional   *node
+	minProps     *int
+	maxProps     *int
+	items        *node
+	minItems     *int
+	maxItems     *int
+	minLength    *int
+	maxLength    *int
+	pattern      *regexp.Regexp
+	minimum      *float64
+	maximum      *float64
+	exclusiveMin *float64
+	exclusiveMax *float64
+
+	allOf []*node
+	anyOf []*node
+	oneOf []*node
+	not   *node
+
+	ref      string
+	resolved *node // what ref points to
+}
+
+// Compile parses a JSON Schema document
+func Compile(data []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	c := &compiler{defs: make(map[string]*node)}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	c.defs["#"] = root
+	if obj, ok := doc.(map[string]interface{}); ok {
+		for _, key := range []string{"definitions", "$defs"} {
+			defs, _ := obj[key].(map[string]interface{})
+			for name, def := range defs {
+				pointer := "#/" + key + "/" + name
+				if c.defs[pointer], err = c.compile(def, pointer); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, n := range c.refs {
+		if n.resolved = c.defs[n.ref]; n.resolved == nil {
+			return nil, fmt.Errorf("unresolved $ref %q", n.ref)
+		}
+	}
+	return &Schema{root: root}, nil
+}
+
+type compiler struct {
+	defs map[string]*node
+	refs []*node
+}
+
+func (c *compiler) compile(v interface{}, at string) (*node, error) {
+	if b, ok := v.(bool); ok {
+		return &node{always: &b}, nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+
+	n := &node{}
+	var err error
+	if ref, ok := obj["$ref"].(string); ok {
+		n.ref = ref
+		c.refs = append(c.refs, n)
+	}
+	switch t := obj["type"].(type) {
+	case string:
+		n.types = []string{t}
+	case []interface{}:
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				n.types = append(n.types, s)
+			}
+		}
+	}
+	if enum, ok := obj["enum"].([]interface{}); ok {
+		n.enum = enum
+	}
+	if constant, ok := obj["const"]; ok {
+		n.hasConst, n.constant = true, constant
+	}
+
+	if props, ok := obj["properties"].(map[string]interface{}); ok {
+		n.properties = make(map[string]*node, len(props))
+		for name, prop := range props {
+			if n.properties[name], err = c.compile(prop, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if required, ok := obj["required"].([]interface{}); ok {
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				n.required = append(n.required, s)
+			}
+		}
+	}
+	if additional, ok := obj["additionalProperties"]; ok {
+		if n.additional, err = c.compile(additional, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := obj["items"]; ok {
+		if n.items, err = c.compile(items, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if s, ok := obj["not"]; ok {
+		if n.not, err = c.compile(s, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  *[]*node
+	}{{"allOf", &n.allOf}, {"anyOf", &n.anyOf}, {"oneOf", &n.oneOf}} {
+		list, _ := obj[kw.name].([]interface{})
+		for i, s := range list {
+			sub, err := c.compile(s, fmt.Sprintf("%s/%s/%d", at, kw.name, i))
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = append(*kw.dst, sub)
+		}
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  **int
+	}{
+		{"minProperties", &n.minProps}, {"maxProperties", &n.maxProps},
+		{"minItems", &n.minItems}, {"maxItems", &n.maxItems},
+		{"minLength", &n.minLength}, {"maxLength", &n.maxLength},
+	} {
+		if f, ok := obj[kw.name].(float64); ok {
+			i := int(f)
+			*kw.dst = &i
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  **float64
+	}{
+		{"minimum", &n.minimum}, {"maximum", &n.maximum},
+		{"exclusiveMinimum", &n.exclusiveMin}, {"exclusiveMaximum", &n.exclusiveMax},
+	} {
+		if f, ok := obj[kw.name].(float64); ok {
+			*kw.dst = &f
+		}
+	}
+	if pattern, ok := obj["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %v", at, err)
+		}
+	}
+	return n, nil
+}
+
+// ValidationError says where and why a document does not match a schema
+type ValidationError struct {
+	// Path is the JSON Pointer to the offending value, "" for the root
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate parses a JSON document and checks it against the schema,
+// returning the first violation found
+func (s *Schema) Validate(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return &ValidationError{Message: "invalid JSON: data after the document"}
+	}
+	return s.ValidateValue(doc)
+}
+
+// ValidateValue checks a document decoded by encoding/json against the
+// schema
+func (s *Schema) ValidateValue(doc interface{}) error {
+	v := &validator{}
+	if msg, path := v.validate(s.root, doc, ""); msg != "" {
+		return &ValidationError{Path: path, Message: msg}
+	}
+	return nil
+}
+
+// maxRefDepth bounds $ref recursion, which a self-referencing schema and a
+// deeply nested document could otherwise drive without limit
+const maxRefDepth = 64
+
+type validator struct {
+	depth int
+}
+
+// validate returns why v does not match n, and where, or "" if it does
+func (val *validator) validate(n *node, v interface{}, path string) (string, string) {
+	if n.always != nil {
+		if *n.always {
+			return "", ""
+		}
+		return "not allowed", path
+	}
+	if n.ref != "" {
+		if val.depth >= maxRefDepth {
+			return "nested too deeply", path
+		}
+		val.depth++
+		msg, at := val.validate(n.resolved, v, path)
+		val.depth--
+		if msg != "" {
+			return msg, at
+		}
+	}
+
+	if len(n.types) > 0 && !hasType(n.types, v) {
+		return fmt.Sprintf("must be %s, not %s", strings.Join(n.types, " or "), typeOf(v)), path
+	}
+	if n.enum != nil {
+		found := false
+		for _, e := range n.enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "must be one of the allowed values", path
+		}
+	}
+	if n.hasConst && !reflect.DeepEqual(n.constant, v) {
+		return "must equal the constant value", path
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if msg, at := val.validateObject(n, v, path); msg != "" {
+			return msg, at
+		}
+	case []interface{}:
+		if n.minItems != nil && len(v) < *n.minItems {
+			return fmt.Sprintf("must have at least %d items", *n.minItems), path
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			return fmt.Sprintf("must have at most %d items", *n.maxItems), path
+		}
+		if n.items != nil {
+			for i, item := range v {
+				if msg, at := val.validate(n.items, item, fmt.Sprintf("%s/%d", path, i)); msg != "" {
+					return msg, at
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n.minLength != nil && length < *n.minLength {
+			return fmt.Sprintf("must be at least %d characters", *n.minLength), path
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			return fmt.Sprintf("must be at most %d characters", *n.maxLength), path
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			return "must match " + n.pattern.String(), path
+		}
+	case float64:
+		switch {
+		case n.minimum != nil && v < *n.minimum:
+			return fmt.Sprintf("must be >= %v", *n.minimum), path
+		case n.maximum != nil && v > *n.maximum:
+			return fmt.Sprintf("must be <= %v", *n.maximum), path
+		case n.exclusiveMin != nil && v <= *n.exclusiveMin:
+			return fmt.Sprintf("must be > %v", *n.exclusiveMin), path
+		case n.exclusiveMax != nil && v >= *n.exclusiveMax:
+			return fmt.Sprintf("must be < %v", *n.exclusiveMax), path
+		}
+	}
+
+	for _, sub := range n.allOf {
+		if msg, at := val.validate(sub, v, path); msg != "" {
+			return msg, at
+		}
+	}
+	if len(n.anyOf) > 0 && val.matching(n.anyOf, v, path) == 0 {
+		return "must match at least one of anyOf", path
+	}
+	if len(n.oneOf) > 0 && val.matching(n.oneOf, v, path) != 1 {
+		return "must match exactly one of oneOf", path
+	}
+	if n.not != nil {
+		if msg, _ := val.validate(n.not, v, path); msg == "" {
+			return "must not match the not schema", path
+		}
+	}
+	return "", ""
+}
+
+func (val *validator) validateObject(n *node, obj map[string]interface{}, path string) (string, string) {
+	if n.minProps != nil && len(obj) < *n.minProps {
+		return fmt.Sprintf("must have at least %d properties", *n.minProps), path
+	}
+	if n.maxProps != nil && len(obj) > *n.maxProps {
+		return fmt.Sprintf("must have at most %d properties", *n.maxProps), path
+	}
+	for _, name := range n.required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Sprintf("missing required property %q", name), path
+		}
+	}
+
+	// Sorted, so the same document always reports the same violation
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		at := path + "/" + escapePointer(name)
+		if prop, ok := n.properties[name]; ok {
+			if msg, where := val.validate(prop, obj[name], at); msg != "" {
+				return msg, where
+			}
+			continue
+		}
+		if n.additional != nil {
+			if msg, where := val.validate(n.additional, obj[name], at); msg != "" {
+				if n.additional.always != nil {
+					msg = "unexpected property"
+				}
+				return msg, where
+			}
+		}
+	}
+	return "", ""
+}
+
+// matching counts the schemas v matches
+func (val *validator) matching(schemas []*node, v interface{}, path string) int {
+	count := 0
+	for _, sub := range schemas {
+		if msg, _ := val.validate(sub, v, path); msg == "" {
+			count++
+		}
+	}
+	return count
+}
+
+func hasType(types []string, v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// escapePointer escapes a property name for a JSON Pointer
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,106 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["sku", "quantity"],
+	"additionalProperties": false,
+	"properties": {
+		"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+		"quantity": {"type": "integer", "minimum": 1, "maximum": 100},
+		"note": {"type": "string", "maxLength": 10},
+		"shipping": {"enum": ["standard", "express"]},
+		"address": {"$ref": "#/$defs/address"},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+	},
+	"$defs": {
+		"address": {
+			"type": "object",
+			"required": ["country"],
+			"properties": {"country": {"type": "string", "minLength": 2, "maxLength": 2}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(orderSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", `{"sku":"ABC-1","quantity":2,"address":{"country":"DE"},"tags":["gift"]}`, ""},
+		{"missing", `{"sku":"ABC-1"}`, `missing required property "quantity"`},
+		{"type", `{"sku":"ABC-1","quantity":"2"}`, "/quantity: must be integer, not string"},
+		{"integer", `{"sku":"ABC-1","quantity":1.5}`, "/quantity: must be integer, not number"},
+		{"maximum", `{"sku":"ABC-1","quantity":1000}`, "/quantity: must be <= 100"},
+		{"pattern", `{"sku":"' OR 1=1--","quantity":1}`, "/sku: must match ^[A-Z]{3}-[0-9]+$"},
+		{"max length", `{"sku":"ABC-1","quantity":1,"note":"aaaaaaaaaaaaaaaa"}`, "/note: must be at most 10 characters"},
+		{"enum", `{"sku":"ABC-1","quantity":1,"shipping":"drone"}`, "/shipping: must be one of the allowed values"},
+		{"ref", `{"sku":"ABC-1","quantity":1,"address":{"country":"Germany"}}`, "/address/country: must be at most 2 characters"},
+		{"items", `{"sku":"ABC-1","quantity":1,"tags":["a",2]}`, "/tags/1: must be string, not integer"},
+		{"max items", `{"sku":"ABC-1","quantity":1,"tags":["a","b","c"]}`, "/tags: must have at most 2 items"},
+		{"additional", `{"sku":"ABC-1","quantity":1,"admin":true}`, "/admin: unexpected property"},
+		{"root type", `[1,2]`, "must be object, not array"},
+		{"malformed", `{"sku":`, "invalid JSON: unexpected EOF"},
+		{"trailing", `{"sku":"ABC-1","quantity":1} {}`, "invalid JSON: data after the document"},
+	}
+	for _, tt := range tests {
+		err := schema.Validate([]byte(tt.doc))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: Validate() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"oneOf": [{"type": "string"}, {"type": "integer"}],
+		"not": {"const": "root"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for doc, valid := range map[string]bool{`"alice"`: true, `7`: true, `"root"`: false, `true`: false} {
+		if err := schema.Validate([]byte(doc)); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v, want valid %v", doc, err, valid)
+		}
+	}
+}
+
+func TestRecursiveRefIsBounded(t *testing.T) {
+	schema, err := Compile([]byte(`{"$ref": "#/definitions/node", "definitions": {"node": {"type": "array", "items": {"$ref": "#/definitions/node"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Validate([]byte(`[[[]]]`)); err != nil {
+		t.Errorf("Validate() of a shallow tree = %v", err)
+	}
+	deep := strings.Repeat("[", 100) + strings.Repeat("]", 100)
+	if err := schema.Validate([]byte(deep)); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("Validate() of a deep tree = %v", err)
+	}
+}
+
+func TestCompileRejectsBadSchemas(t *testing.T) {
+	for _, doc := range []string{
+		`{"type": `,
+		`{"properties": {"a": 1}}`,
+		`{"pattern": "(?<=a)b"}`,
+		`{"$ref": "#/definitions/missing"}`,
+	} {
+		if _, err := Compile([]byte(doc)); err == nil {
+			t.Errorf("Compile(%s) succeeded", doc)
+		}
+	}
+}
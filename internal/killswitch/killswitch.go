@@ -0,0 +1,198 @@
+package killswitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Kind identifies what a kill switch disables
+type Kind string
+
+const (
+	// KindRule disables a single request filter rule
+	KindRule Kind = "rule"
+	// KindFeed disables every entry contributed by a feed source
+	KindFeed Kind = "feed"
+	// KindIndicator disables a single botnet detector indicator
+	KindIndicator Kind = "indicator"
+)
+
+const (
+	redisHashKey = "killswitch:active"
+	redisChannel = "killswitch:updates"
+)
+
+// Switch is an engaged kill switch
+type Switch struct {
+	Kind      Kind      `json:"kind"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason,omitempty"`
+	EngagedAt time.Time `json:"engaged_at"`
+}
+
+// Registry holds the engaged kill switches and keeps them in step across
+// instances through Redis
+type Registry struct {
+	client       *redis.Client
+	switches     map[string]Switch
+	mu           sync.RWMutex
+	syncInterval time.Duration
+}
+
+// NewRegistry creates a new kill switch registry. With a nil client the
+// switches only apply to this instance.
+func NewRegistry(client *redis.Client, syncInterval time.Duration) *Registry {
+	return &Registry{
+		client:       client,
+		switches:     make(map[string]Switch),
+		syncInterval: syncInterval,
+	}
+}
+
+// ParseKind validates a kill switch kind
+func ParseKind(kind string) (Kind, error) {
+	switch Kind(kind) {
+	case KindRule, KindFeed, KindIndicator:
+		return Kind(kind), nil
+	default:
+		return "", fmt.Errorf("unknown kill switch kind: %s", kind)
+	}
+}
+
+func switchKey(kind Kind, name string) string {
+	return string(kind) + ":" + name
+}
+
+// Engage disables the named rule, feed, or indicator on every instance
+func (r *Registry) Engage(ctx context.Context, kind Kind, name, reason string) error {
+	sw := Switch{
+		Kind:      kind,
+		Name:      name,
+		Reason:    reason,
+		EngagedAt: time.Now(),
+	}
+
+	r.mu.Lock()
+	r.switches[switchKey(kind, name)] = sw
+	r.mu.Unlock()
+
+	if r.client == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(sw)
+	if err != nil {
+		return err
+	}
+	if err := r.client.HSet(ctx, redisHashKey, switchKey(kind, name), data).Err(); err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, redisChannel, switchKey(kind, name)).Err()
+}
+
+// Release re-enables the named rule, feed, or indicator on every instance
+func (r *Registry) Release(ctx context.Context, kind Kind, name string) error {
+	r.mu.Lock()
+	delete(r.switches, switchKey(kind, name))
+	r.mu.Unlock()
+
+	if r.client == nil {
+		return nil
+	}
+
+	if err := r.client.HDel(ctx, redisHashKey, switchKey(kind, name)).Err(); err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, redisChannel, switchKey(kind, name)).Err()
+}
+
+// IsEngaged reports whether the named rule, feed, or indicator is disabled
+func (r *Registry) IsEngaged(kind Kind, name string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, engaged := r.switches[switchKey(kind, name)]
+	return engaged
+}
+
+// List returns the engaged kill switches ordered by engagement time
+func (r *Registry) List() []Switch {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Switch, 0, len(r.switches))
+	for _, sw := range r.switches {
+		result = append(result, sw)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].EngagedAt.Before(result[j].EngagedAt)
+	})
+
+	return result
+}
+
+// Sync replaces the local switches with the cluster-wide set from Redis
+func (r *Registry) Sync(ctx context.Context) error {
+	if r.client == nil {
+		return nil
+	}
+
+	entries, err := r.client.HGetAll(ctx, redisHashKey).Result()
+	if err != nil {
+		return err
+	}
+
+	switches := make(map[string]Switch, len(entries))
+	for key, data := range entries {
+		var sw Switch
+		if err := json.Unmarshal([]byte(data), &sw); err != nil {
+			continue
+		}
+		switches[key] = sw
+	}
+
+	r.mu.Lock()
+	r.switches = switches
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Run keeps the local switches in sync until ctx is cancelled. Updates are
+// pushed over pub/sub; the periodic sync covers missed messages.
+func (r *Registry) Run(ctx context.Context) {
+	if r.client == nil {
+		return
+	}
+
+	pubsub := r.client.Subscribe(ctx, redisChannel)
+	defer pubsub.Close()
+
+	ticker := time.NewTicker(r.syncInterval)
+	defer ticker.Stop()
+
+	r.Sync(ctx)
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-messages:
+			r.Sync(ctx)
+		case <-ticker.C:
+			r.Sync(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -7,19 +7,45 @@ import (
 	"sync"
 	"time"
 
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/botnet"
+	"ddos-protection/internal/intern"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// methodCounter counts requests by HTTP method and how they were handled
+var methodCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_requests_by_method_total",
+	Help: "Requests by HTTP method and outcome",
+}, []string{"method", "outcome"})
+
+// knownMethods bounds the method label; anything else is counted as OTHER
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
 // TrafficMonitor monitors traffic patterns and generates alerts
 type TrafficMonitor struct {
 	requestCounts    map[string]int64
 	responseTimes    map[string][]time.Duration
 	errorCounts      map[string]int64
+	methodCounts     map[string]map[string]int64
+	agentCounts      map[string]int64
 	mu               sync.RWMutex
 	alertThreshold   int64
 	sampleRate       float64
 	windowDuration   time.Duration
+	maxTrackedIPs    int
 	
 	// Prometheus metrics
 	requestCounter   prometheus.Counter
@@ -51,7 +77,11 @@ type TrafficStats struct {
 	AverageResponseTime time.Duration  `json:"average_response_time"`
 	ErrorRate        float64           `json:"error_rate"`
 	TopIPs           []IPStats         `json:"top_ips"`
+	Methods          map[string]map[string]int64 `json:"methods"`
+	MonitorAgents    map[string]int64  `json:"monitor_agents"`
 	RequestsPerMinute float64          `json:"requests_per_minute"`
+	TLSFingerprints  []botnet.FingerprintStats `json:"tls_fingerprints,omitempty"`
+	HeaderFingerprints []botnet.FingerprintStats `json:"header_fingerprints,omitempty"`
 }
 
 // IPStats represents statistics for a specific IP
@@ -63,15 +93,22 @@ type IPStats struct {
 	LastSeen        time.Time     `json:"last_seen"`
 }
 
+// DefaultMaxTrackedIPs bounds the clients tracked individually until
+// SetMaxTrackedIPs is called
+const DefaultMaxTrackedIPs = 100000
+
 // NewTrafficMonitor creates a new traffic monitor
 func NewTrafficMonitor(alertThreshold int64, sampleRate float64) *TrafficMonitor {
 	tm := &TrafficMonitor{
 		requestCounts:  make(map[string]int64),
 		responseTimes:  make(map[string][]time.Duration),
 		errorCounts:    make(map[string]int64),
+		methodCounts:   make(map[string]map[string]int64),
+		agentCounts:    make(map[string]int64),
 		alertThreshold: alertThreshold,
 		sampleRate:     sampleRate,
 		windowDuration: time.Minute,
+		maxTrackedIPs:  DefaultMaxTrackedIPs,
 		alertChan:      make(chan Alert, 100),
 		stopChan:       make(chan struct{}),
 	}
@@ -82,6 +119,15 @@ func NewTrafficMonitor(alertThreshold int64, sampleRate float64) *TrafficMonitor
 	return tm
 }
 
+// SetMaxTrackedIPs bounds how many clients are tracked individually. Further
+// clients are counted together as intern.Other. A limit of 0 means no limit.
+func (tm *TrafficMonitor) SetMaxTrackedIPs(limit int) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.maxTrackedIPs = limit
+}
+
 // initMetrics initializes Prometheus metrics
 func (tm *TrafficMonitor) initMetrics() {
 	tm.requestCounter = promauto.NewCounter(prometheus.CounterOpts{
@@ -118,6 +164,8 @@ func (tm *TrafficMonitor) RecordRequest(ctx context.Context, req *http.Request,
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
+	clientIP = intern.Key(tm.requestCounts, clientIP, tm.maxTrackedIPs)
+
 	// Update counters
 	tm.requestCounts[clientIP]++
 	tm.requestCounter.Inc()
@@ -142,24 +190,43 @@ func (tm *TrafficMonitor) RecordRequest(ctx context.Context, req *http.Request,
 		tm.errorCounter.Inc()
 	}
 
-	// Check for alerts
-	tm.checkAlerts(clientIP)
+	// Check for alerts, except on the bucket of untracked clients
+	if clientIP != intern.Other {
+		tm.checkAlerts(clientIP)
+	}
 }
 
-// getClientIP extracts the real client IP from request
-func (tm *TrafficMonitor) getClientIP(req *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
+// RecordMethod counts a request by method and outcome (served, denied,
+// challenged, slowed or answered), including requests that never reached a handler
+func (tm *TrafficMonitor) RecordMethod(method, outcome string) {
+	if !knownMethods[method] {
+		method = "OTHER"
 	}
-	
-	// Check X-Real-IP header
-	if xri := req.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	methodCounter.WithLabelValues(method, outcome).Inc()
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	outcomes, exists := tm.methodCounts[method]
+	if !exists {
+		outcomes = make(map[string]int64)
+		tm.methodCounts[method] = outcomes
 	}
-	
-	// Fall back to RemoteAddr
-	return req.RemoteAddr
+	outcomes[outcome]++
+}
+
+// RecordAgentRequest counts a request from a verified monitoring agent. These
+// are kept out of the per-IP counters so probes do not trigger alerts.
+func (tm *TrafficMonitor) RecordAgentRequest(profile string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.agentCounts[profile]++
+}
+
+// getClientIP extracts the real client IP from request
+func (tm *TrafficMonitor) getClientIP(req *http.Request) string {
+	return blacklist.GetClientIP(req)
 }
 
 // checkAlerts checks if any alerts should be triggered
@@ -227,7 +294,21 @@ func (tm *TrafficMonitor) GetTrafficStats() *TrafficStats {
 	defer tm.mu.RUnlock()
 
 	stats := &TrafficStats{
-		TopIPs: make([]IPStats, 0),
+		TopIPs:        make([]IPStats, 0),
+		Methods:       make(map[string]map[string]int64, len(tm.methodCounts)),
+		MonitorAgents: make(map[string]int64, len(tm.agentCounts)),
+	}
+
+	for profile, count := range tm.agentCounts {
+		stats.MonitorAgents[profile] = count
+	}
+
+	for method, outcomes := range tm.methodCounts {
+		counts := make(map[string]int64, len(outcomes))
+		for outcome, count := range outcomes {
+			counts[outcome] = count
+		}
+		stats.Methods[method] = counts
 	}
 
 	var totalRequests int64
@@ -373,6 +454,8 @@ func (tm *TrafficMonitor) Reset() {
 	tm.requestCounts = make(map[string]int64)
 	tm.responseTimes = make(map[string][]time.Duration)
 	tm.errorCounts = make(map[string]int64)
+	tm.methodCounts = make(map[string]map[string]int64)
+	tm.agentCounts = make(map[string]int64)
 }
 
 // GetIPStats returns statistics for a specific IP
@@ -0,0 +1,319 @@
+// Package overrides layers per-tenant and per-path settings over the global
+// protection config. Layers apply from least to most specific: global, then
+// tenant, then path group, then tenant and path group together, each setting
+// only what it names. A request matches at most one layer of each kind, so
+// the merge order never depends on how the layers were listed.
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Challenge policies
+const (
+	// ChallengeServe serves the challenge page
+	ChallengeServe = "challenge"
+	// ChallengeBlock rejects requests that would be challenged
+	ChallengeBlock = "block"
+	// ChallengeAllow lets requests that would be challenged through
+	ChallengeAllow = "allow"
+)
+
+// Actions the botnet stage can take on a detection, from mildest to
+// strictest
+const (
+	// ActionLog lets the request through and logs the detection
+	ActionLog Action = 1 << iota
+	// ActionChallenge challenges the client
+	ActionChallenge
+	// ActionBlock rejects the request
+	ActionBlock
+	// ActionBlacklist rejects the request and blacklists the client
+	ActionBlacklist
+
+	// AllActions allows every action
+	AllActions = ActionLog | ActionChallenge | ActionBlock | ActionBlacklist
+)
+
+// Action is a botnet stage action, or a set of them
+type Action uint8
+
+var actionNames = []struct {
+	action Action
+	name   string
+}{
+	{ActionLog, "log"},
+	{ActionChallenge, "challenge"},
+	{ActionBlock, "block"},
+	{ActionBlacklist, "blacklist"},
+}
+
+// ParseActions returns the set of actions named
+func ParseActions(names []string) (Action, error) {
+	var set Action
+	for _, name := range names {
+		var found bool
+		for _, a := range actionNames {
+			if strings.EqualFold(name, a.name) {
+				set |= a.action
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown botnet action %q", name)
+		}
+	}
+	return set, nil
+}
+
+// Names lists the actions in a set from mildest to strictest
+func (set Action) Names() []string {
+	names := []string{}
+	for _, a := range actionNames {
+		if set&a.action != 0 {
+			names = append(names, a.name)
+		}
+	}
+	return names
+}
+
+func (set Action) String() string {
+	return strings.Join(set.Names(), ",")
+}
+
+func (set Action) MarshalJSON() ([]byte, error) {
+	return json.Marshal(set.Names())
+}
+
+// Nearest returns action if the set allows it, else the strictest allowed
+// action milder than it, else the mildest allowed stricter one. An empty
+// set allows only logging.
+func (set Action) Nearest(action Action) Action {
+	if set&action != 0 {
+		return action
+	}
+	for a := action >> 1; a != 0; a >>= 1 {
+		if set&a != 0 {
+			return a
+		}
+	}
+	for a := action << 1; a <= ActionBlacklist; a <<= 1 {
+		if set&a != 0 {
+			return a
+		}
+	}
+	return ActionLog
+}
+
+// GlobalLayer names the global config in Effective.Layers
+const GlobalLayer = "global"
+
+// RateLimit is a per-client rate limit
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	BurstSize         int `json:"burst_size"`
+}
+
+// Settings are the protection settings that can be overridden
+type Settings struct {
+	RateLimit               RateLimit `json:"rate_limit"`
+	RequestFilter           bool      `json:"request_filter"`
+	DetectionThreshold      float64   `json:"detection_threshold"`
+	AutoBlacklistConfidence float64   `json:"auto_blacklist_confidence"`
+	Challenge               string    `json:"challenge"`
+	RequireChallenge        bool      `json:"require_challenge"`
+	BotnetActions           Action    `json:"botnet_actions"`
+	InspectBody             bool      `json:"inspect_body"`
+	FilterRuleSet           string    `json:"filter_rule_set,omitempty"`
+	FilterRiskThreshold     int       `json:"filter_risk_threshold"`
+}
+
+// Layer overrides the settings it sets for requests of a tenant, a path
+// group, or both. An empty Tenant or PathGroup matches every request.
+type Layer struct {
+	Tenant                  string
+	PathGroup               string
+	RateLimit               *RateLimit
+	RequestFilter           *bool
+	DetectionThreshold      *float64
+	AutoBlacklistConfidence *float64
+	Challenge               string
+	RequireChallenge        *bool
+	BotnetActions           Action // 0 leaves them unchanged
+	InspectBody             *bool
+	FilterRuleSet           string
+	FilterRiskThreshold     *int
+}
+
+// Name identifies the layer in Effective.Layers and rate limit scopes
+func (l Layer) Name() string {
+	switch {
+	case l.Tenant != "" && l.PathGroup != "":
+		return "tenant:" + l.Tenant + "/path:" + l.PathGroup
+	case l.Tenant != "":
+		return "tenant:" + l.Tenant
+	case l.PathGroup != "":
+		return "path:" + l.PathGroup
+	default:
+		return GlobalLayer
+	}
+}
+
+func (l Layer) specificity() int {
+	n := 0
+	if l.Tenant != "" {
+		n++
+	}
+	if l.PathGroup != "" {
+		n += 2
+	}
+	return n
+}
+
+// PathGroup names a set of path prefixes
+type PathGroup struct {
+	Name  string
+	Paths []string
+}
+
+// Effective is the outcome of merging the layers for a request. RateLimit
+// names the layer whose rate limit applies, GlobalLayer if none set one.
+type Effective struct {
+	Tenant    string   `json:"tenant"`
+	PathGroup string   `json:"path_group,omitempty"`
+	Layers    []string `json:"layers"`
+	RateLimit string   `json:"rate_limit_layer"`
+	Settings  Settings `json:"settings"`
+}
+
+// Resolver merges layers for requests. It is immutable and safe for
+// concurrent use.
+type Resolver struct {
+	groups []PathGroup
+	layers []Layer
+}
+
+// New validates groups and layers and returns a resolver for them
+func New(groups []PathGroup, layers []Layer) (*Resolver, error) {
+	names := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		if g.Name == "" || names[g.Name] {
+			return nil, fmt.Errorf("invalid or duplicate path group %q", g.Name)
+		}
+		if len(g.Paths) == 0 {
+			return nil, fmt.Errorf("path group %s has no paths", g.Name)
+		}
+		names[g.Name] = true
+	}
+
+	sorted := append([]Layer(nil), layers...)
+	seen := make(map[string]bool, len(sorted))
+	for i := range sorted {
+		l := &sorted[i]
+		l.Tenant = strings.ToLower(l.Tenant)
+		if l.Tenant == "" && l.PathGroup == "" {
+			return nil, fmt.Errorf("override needs a tenant or a path group")
+		}
+		if seen[l.Name()] {
+			return nil, fmt.Errorf("duplicate override %s", l.Name())
+		}
+		seen[l.Name()] = true
+		if l.PathGroup != "" && !names[l.PathGroup] {
+			return nil, fmt.Errorf("override %s names unknown path group", l.Name())
+		}
+		if rl := l.RateLimit; rl != nil && (rl.RequestsPerMinute <= 0 || rl.BurstSize < 0) {
+			return nil, fmt.Errorf("override %s: invalid rate limit", l.Name())
+		}
+		switch l.Challenge {
+		case "", ChallengeServe, ChallengeBlock, ChallengeAllow:
+		default:
+			return nil, fmt.Errorf("override %s: invalid challenge policy %q", l.Name(), l.Challenge)
+		}
+		if l.BotnetActions&^AllActions != 0 {
+			return nil, fmt.Errorf("override %s: invalid botnet actions", l.Name())
+		}
+		if l.FilterRiskThreshold != nil && *l.FilterRiskThreshold <= 0 {
+			return nil, fmt.Errorf("override %s: invalid filter risk threshold", l.Name())
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].specificity() < sorted[j].specificity()
+	})
+
+	return &Resolver{groups: append([]PathGroup(nil), groups...), layers: sorted}, nil
+}
+
+// Layers returns the layers in the order they apply
+func (r *Resolver) Layers() []Layer {
+	return append([]Layer(nil), r.layers...)
+}
+
+// Group returns the path group whose longest prefix matches path, or ""
+func (r *Resolver) Group(path string) string {
+	group, longest := "", -1
+	for _, g := range r.groups {
+		for _, prefix := range g.Paths {
+			if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+				group, longest = g.Name, len(prefix)
+			}
+		}
+	}
+	return group
+}
+
+// Resolve merges the layers matching a request of tenant to path over the
+// global settings
+func (r *Resolver) Resolve(global Settings, tenant, path string) Effective {
+	eff := Effective{
+		Tenant:    tenant,
+		PathGroup: r.Group(path),
+		Layers:    []string{GlobalLayer},
+		RateLimit: GlobalLayer,
+		Settings:  global,
+	}
+
+	for _, l := range r.layers {
+		if (l.Tenant != "" && l.Tenant != tenant) || (l.PathGroup != "" && l.PathGroup != eff.PathGroup) {
+			continue
+		}
+		eff.Layers = append(eff.Layers, l.Name())
+
+		s := &eff.Settings
+		if l.RateLimit != nil {
+			s.RateLimit = *l.RateLimit
+			eff.RateLimit = l.Name()
+		}
+		if l.RequestFilter != nil {
+			s.RequestFilter = *l.RequestFilter
+		}
+		if l.DetectionThreshold != nil {
+			s.DetectionThreshold = *l.DetectionThreshold
+		}
+		if l.AutoBlacklistConfidence != nil {
+			s.AutoBlacklistConfidence = *l.AutoBlacklistConfidence
+		}
+		if l.Challenge != "" {
+			s.Challenge = l.Challenge
+		}
+		if l.RequireChallenge != nil {
+			s.RequireChallenge = *l.RequireChallenge
+		}
+		if l.BotnetActions != 0 {
+			s.BotnetActions = l.BotnetActions
+		}
+		if l.InspectBody != nil {
+			s.InspectBody = *l.InspectBody
+		}
+		if l.FilterRuleSet != "" {
+			s.FilterRuleSet = l.FilterRuleSet
+		}
+		if l.FilterRiskThreshold != nil {
+			s.FilterRiskThreshold = *l.FilterRiskThreshold
+		}
+	}
+	return eff
+}
@@ -0,0 +1,116 @@
+package overrides
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveMergeOrder(t *testing.T) {
+	off, on := false, true
+	strict, lax := 0.5, 0.95
+	cautious := 60
+
+	groups := []PathGroup{
+		{Name: "api", Paths: []string{"/api"}},
+		{Name: "auth", Paths: []string{"/api/auth", "/login"}},
+	}
+	// Listed most specific first; the resolver applies them in order of
+	// specificity regardless
+	layers := []Layer{
+		{Tenant: "Acme", PathGroup: "auth", RateLimit: &RateLimit{RequestsPerMinute: 30, BurstSize: 5}},
+		{PathGroup: "auth", RateLimit: &RateLimit{RequestsPerMinute: 10, BurstSize: 2}, Challenge: ChallengeBlock, RequireChallenge: &on, BotnetActions: ActionBlock | ActionBlacklist},
+		{Tenant: "acme", DetectionThreshold: &lax, RequestFilter: &off},
+		{PathGroup: "api", DetectionThreshold: &strict, InspectBody: &on, FilterRuleSet: "api", FilterRiskThreshold: &cautious},
+	}
+	r, err := New(groups, layers)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	global := Settings{
+		RateLimit:               RateLimit{RequestsPerMinute: 60, BurstSize: 10},
+		RequestFilter:           true,
+		DetectionThreshold:      0.8,
+		AutoBlacklistConfidence: 0.8,
+		Challenge:               ChallengeServe,
+		BotnetActions:           AllActions,
+		FilterRiskThreshold:     100,
+	}
+
+	tests := []struct {
+		tenant, path string
+		layers       []string
+		rateLimit    string
+		want         Settings
+	}{
+		{"default", "/", []string{GlobalLayer}, GlobalLayer, global},
+		{"default", "/login", []string{GlobalLayer, "path:auth"}, "path:auth", Settings{
+			RateLimit: RateLimit{10, 2}, RequestFilter: true, DetectionThreshold: 0.8, AutoBlacklistConfidence: 0.8, Challenge: ChallengeBlock, RequireChallenge: true, BotnetActions: ActionBlock | ActionBlacklist, FilterRiskThreshold: 100,
+		}},
+		{"acme", "/api/items", []string{GlobalLayer, "tenant:acme", "path:api"}, GlobalLayer, Settings{
+			RateLimit: RateLimit{60, 10}, RequestFilter: false, DetectionThreshold: 0.5, AutoBlacklistConfidence: 0.8, Challenge: ChallengeServe, BotnetActions: AllActions,
+			InspectBody: true, FilterRuleSet: "api", FilterRiskThreshold: 60,
+		}},
+		{"acme", "/api/auth/token", []string{GlobalLayer, "tenant:acme", "path:auth", "tenant:acme/path:auth"}, "tenant:acme/path:auth", Settings{
+			RateLimit: RateLimit{30, 5}, RequestFilter: false, DetectionThreshold: 0.95, AutoBlacklistConfidence: 0.8, Challenge: ChallengeBlock, RequireChallenge: true, BotnetActions: ActionBlock | ActionBlacklist, FilterRiskThreshold: 100,
+		}},
+	}
+	for _, tt := range tests {
+		eff := r.Resolve(global, tt.tenant, tt.path)
+		if !reflect.DeepEqual(eff.Layers, tt.layers) {
+			t.Errorf("%s %s: layers %v, want %v", tt.tenant, tt.path, eff.Layers, tt.layers)
+		}
+		if eff.RateLimit != tt.rateLimit {
+			t.Errorf("%s %s: rate limit from %s, want %s", tt.tenant, tt.path, eff.RateLimit, tt.rateLimit)
+		}
+		if eff.Settings != tt.want {
+			t.Errorf("%s %s: settings %+v, want %+v", tt.tenant, tt.path, eff.Settings, tt.want)
+		}
+	}
+}
+
+func TestNewRejectsInvalidLayers(t *testing.T) {
+	groups := []PathGroup{{Name: "auth", Paths: []string{"/login"}}}
+	invalid := [][]Layer{
+		{{}},
+		{{PathGroup: "missing"}},
+		{{Tenant: "acme"}, {Tenant: "ACME"}},
+		{{PathGroup: "auth", Challenge: "captcha"}},
+		{{PathGroup: "auth", RateLimit: &RateLimit{}}},
+		{{PathGroup: "auth", BotnetActions: 1 << 7}},
+		{{PathGroup: "auth", FilterRiskThreshold: new(int)}},
+	}
+	for _, layers := range invalid {
+		if _, err := New(groups, layers); err == nil {
+			t.Errorf("New accepted %+v", layers)
+		}
+	}
+}
+
+func TestNearestAction(t *testing.T) {
+	strict, err := ParseActions([]string{"block", "Blacklist"})
+	if err != nil {
+		t.Fatalf("ParseActions: %v", err)
+	}
+	lenient, _ := ParseActions([]string{"log", "challenge"})
+	if _, err := ParseActions([]string{"drop"}); err == nil {
+		t.Error("ParseActions accepted an unknown action")
+	}
+
+	tests := []struct {
+		set, action, want Action
+	}{
+		{AllActions, ActionChallenge, ActionChallenge},
+		{strict, ActionChallenge, ActionBlock},
+		{strict, ActionBlacklist, ActionBlacklist},
+		{lenient, ActionBlacklist, ActionChallenge},
+		{lenient, ActionBlock, ActionChallenge},
+		{ActionLog, ActionBlacklist, ActionLog},
+		{0, ActionBlock, ActionLog},
+	}
+	for _, tt := range tests {
+		if got := tt.set.Nearest(tt.action); got != tt.want {
+			t.Errorf("%s.Nearest(%s) = %s, want %s", tt.set, tt.action, got, tt.want)
+		}
+	}
+}
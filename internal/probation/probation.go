@@ -0,0 +1,453 @@
+package probation
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Status represents the enforcement status of a rule
+type Status string
+
+const (
+	// StatusShadow means the rule is evaluated but never enforced
+	StatusShadow Status = "shadow"
+	// StatusHeld means probation ended but the projected false-positive
+	// rate was too high, so the rule stays in shadow until promoted manually
+	StatusHeld Status = "held"
+	// StatusRollingOut means the rule is enforced for a share of clients and
+	// shadowed for the rest
+	StatusRollingOut Status = "rolling_out"
+	// StatusEnforcing means the rule blocks matching requests
+	StatusEnforcing Status = "enforcing"
+)
+
+// Rollout cohorts
+const (
+	CohortEnforce = "enforce"
+	CohortShadow  = "shadow"
+)
+
+var (
+	wouldBlockCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_probation_would_block_total",
+		Help: "Requests that rules on probation would have blocked",
+	}, []string{"rule"})
+
+	rolloutMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_rollout_matches_total",
+		Help: "Matches of rules being rolled out, by cohort",
+	}, []string{"rule", "cohort"})
+
+	rolloutPercent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_rollout_percent",
+		Help: "Share of clients a rule is enforced for",
+	}, []string{"rule"})
+)
+
+// RuleState tracks a single rule through its probation period. While the
+// rule rolls out, Rollout is the percentage of clients it is enforced for
+// and Step counts matches since the rollout last ramped up.
+type RuleState struct {
+	ID             string    `json:"id"`
+	Source         string    `json:"source"`
+	Status         Status    `json:"status"`
+	AddedAt        time.Time `json:"added_at"`
+	ProbationEnds  time.Time `json:"probation_ends"`
+	WouldBlock     int64     `json:"would_block"`
+	FalsePositives int64     `json:"suspected_false_positives"`
+	Rollout        int       `json:"rollout,omitempty"`
+	RampedAt       time.Time `json:"ramped_at,omitempty"`
+	Enforced       int64     `json:"enforced,omitempty"`
+	Step           StepStats `json:"step"`
+	PromotedAt     time.Time `json:"promoted_at,omitempty"`
+	PromotedBy     string    `json:"promoted_by,omitempty"`
+}
+
+// StepStats counts matches in each cohort during a rollout step
+type StepStats struct {
+	Enforced       int64 `json:"enforced"`
+	WouldBlock     int64 `json:"would_block"`
+	FalsePositives int64 `json:"suspected_false_positives"`
+}
+
+// Transition is a change of a rule's status or rollout made by Evaluate
+type Transition struct {
+	ID      string `json:"id"`
+	Status  Status `json:"status"`
+	Rollout int    `json:"rollout"`
+}
+
+// FalsePositiveRate returns the projected false-positive rate of the rule
+func (rs *RuleState) FalsePositiveRate() float64 {
+	if rs.WouldBlock == 0 {
+		return 0
+	}
+	return float64(rs.FalsePositives) / float64(rs.WouldBlock)
+}
+
+// Shadow hits are held against a client for suspectWindow, for at most
+// maxSuspects clients, in case it proves legitimate
+const (
+	suspectWindow = time.Hour
+	maxSuspects   = 100000
+)
+
+// suspect counts the would-block hits of a rule against one client, and
+// how many of them fell in the rollout step that began at stepAt
+type suspect struct {
+	hits   int64
+	step   int64
+	stepAt time.Time
+	last   time.Time
+}
+
+// Tracker runs newly added rules in shadow mode and decides when they
+// may start enforcing. With ramp steps set, rules that pass probation are
+// rolled out gradually instead of enforced for everyone at once.
+//
+// A shadow hit becomes a suspected false positive when the client it would
+// have blocked later proves legitimate: it solves a challenge, passes
+// crawler verification, is whitelisted or wins an appeal; see Vindicate.
+type Tracker struct {
+	rules      map[string]*RuleState
+	suspects   map[string]map[string]*suspect
+	mu         sync.RWMutex
+	period     time.Duration
+	maxFPRate  float64
+	minSamples int64
+	rampSteps  []int
+	rampEvery  time.Duration
+}
+
+// NewTracker creates a new probation tracker
+func NewTracker(period time.Duration, maxFPRate float64, minSamples int64) *Tracker {
+	return &Tracker{
+		rules:      make(map[string]*RuleState),
+		suspects:   make(map[string]map[string]*suspect),
+		period:     period,
+		maxFPRate:  maxFPRate,
+		minSamples: minSamples,
+	}
+}
+
+// Register puts a newly added rule on probation. Registering a rule that is
+// already tracked is a no-op so reloads don't restart its probation.
+func (t *Tracker) Register(id, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.rules[id]; exists {
+		return
+	}
+
+	now := time.Now()
+	t.rules[id] = &RuleState{
+		ID:            id,
+		Source:        source,
+		Status:        StatusShadow,
+		AddedAt:       now,
+		ProbationEnds: now.Add(t.period),
+	}
+}
+
+// Remove stops tracking a rule
+func (t *Tracker) Remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.rules, id)
+	rolloutPercent.DeleteLabelValues(id)
+}
+
+// ShouldEnforce reports whether matches of the rule should be enforced for
+// the client identified by key. Rules that were never registered predate
+// probation and are always enforced.
+func (t *Tracker) ShouldEnforce(id, key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	state, exists := t.rules[id]
+	if !exists {
+		return true
+	}
+	switch state.Status {
+	case StatusEnforcing:
+		return true
+	case StatusRollingOut:
+		return Bucket(id, key) < state.Rollout
+	default:
+		return false
+	}
+}
+
+// Bucket places a client in one of 100 buckets for a rule. A client keeps
+// its bucket, so it stays in the same cohort as a rollout ramps up, while
+// different rules split clients differently.
+func Bucket(id, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+// SetRamp rolls rules out in the given percentage steps once they pass
+// probation, moving to the next step every interval while the shadow
+// cohort's false-positive rate stays acceptable
+func (t *Tracker) SetRamp(steps []int, every time.Duration) error {
+	for i, step := range steps {
+		if step < 1 || step > 100 || (i > 0 && step <= steps[i-1]) {
+			return fmt.Errorf("rollout steps must increase from 1 to 100: %v", steps)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rampSteps = append([]int(nil), steps...)
+	t.rampEvery = every
+	return nil
+}
+
+// SetRollout enforces a rule for percent of clients, shadowing it for the
+// rest. 100 enforces it for everyone; 0 returns it to shadow.
+func (t *Tracker) SetRollout(id string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("rollout percentage must be between 0 and 100")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.rules[id]
+	if !exists {
+		return fmt.Errorf("rule not on probation: %s", id)
+	}
+
+	switch percent {
+	case 0:
+		state.Status = StatusShadow
+		state.Rollout = 0
+		rolloutPercent.WithLabelValues(id).Set(0)
+	case 100:
+		t.promote(state, "manual")
+	default:
+		t.rollout(state, percent)
+	}
+	return nil
+}
+
+// rollout moves a rule to a rollout step; callers must hold the lock
+func (t *Tracker) rollout(state *RuleState, percent int) {
+	state.Status = StatusRollingOut
+	state.Rollout = percent
+	state.RampedAt = time.Now()
+	state.Step = StepStats{}
+	rolloutPercent.WithLabelValues(state.ID).Set(float64(percent))
+}
+
+// RecordWouldBlock records a match of a rule that is still in shadow
+// against the client identified by key
+func (t *Tracker) RecordWouldBlock(id, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.rules[id]
+	if !exists {
+		return
+	}
+
+	state.WouldBlock++
+	wouldBlockCounter.WithLabelValues(id).Inc()
+	if state.Status == StatusRollingOut {
+		state.Step.WouldBlock++
+		rolloutMatches.WithLabelValues(id, CohortShadow).Inc()
+	}
+
+	hits, tracked := t.suspects[key]
+	if !tracked {
+		if len(t.suspects) >= maxSuspects {
+			return
+		}
+		hits = make(map[string]*suspect)
+		t.suspects[key] = hits
+	}
+	hit, ok := hits[id]
+	if !ok {
+		hit = &suspect{}
+		hits[id] = hit
+	}
+	hit.hits++
+	hit.last = time.Now()
+	if state.Status == StatusRollingOut {
+		if !hit.stepAt.Equal(state.RampedAt) {
+			hit.stepAt = state.RampedAt
+			hit.step = 0
+		}
+		hit.step++
+	}
+}
+
+// Vindicate marks the client identified by key as legitimate. The shadow
+// hits held against it in the last suspectWindow count as false positives
+// of the rules that made them.
+func (t *Tracker) Vindicate(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hits, tracked := t.suspects[key]
+	if !tracked {
+		return
+	}
+	delete(t.suspects, key)
+
+	cutoff := time.Now().Add(-suspectWindow)
+	for id, hit := range hits {
+		state, exists := t.rules[id]
+		if !exists || hit.last.Before(cutoff) {
+			continue
+		}
+		state.FalsePositives += hit.hits
+		if state.Status == StatusRollingOut && hit.stepAt.Equal(state.RampedAt) {
+			state.Step.FalsePositives += hit.step
+		}
+	}
+}
+
+// pruneSuspects forgets shadow hits too old to be vindicated; callers must
+// hold the lock
+func (t *Tracker) pruneSuspects(now time.Time) {
+	cutoff := now.Add(-suspectWindow)
+	for key, hits := range t.suspects {
+		for id, hit := range hits {
+			if _, exists := t.rules[id]; !exists || hit.last.Before(cutoff) {
+				delete(hits, id)
+			}
+		}
+		if len(hits) == 0 {
+			delete(t.suspects, key)
+		}
+	}
+}
+
+// RecordEnforced records a match of a rule in its enforced cohort while it
+// rolls out
+func (t *Tracker) RecordEnforced(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.rules[id]
+	if !exists || state.Status != StatusRollingOut {
+		return
+	}
+
+	state.Enforced++
+	state.Step.Enforced++
+	rolloutMatches.WithLabelValues(id, CohortEnforce).Inc()
+}
+
+// Promote starts enforcement of a rule immediately
+func (t *Tracker) Promote(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.rules[id]
+	if !exists {
+		return fmt.Errorf("rule not on probation: %s", id)
+	}
+
+	t.promote(state, "manual")
+	return nil
+}
+
+// Evaluate promotes rules whose probation has ended with an acceptable
+// projected false-positive rate, and holds the rest. Rules rolling out ramp
+// up a step once the current one has run its interval cleanly, and fall back
+// to held when their shadow cohort shows too many false positives.
+func (t *Tracker) Evaluate() []Transition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var changes []Transition
+	now := time.Now()
+	t.pruneSuspects(now)
+	for _, state := range t.rules {
+		before := Transition{ID: state.ID, Status: state.Status, Rollout: state.Rollout}
+		switch state.Status {
+		case StatusEnforcing:
+			continue
+		case StatusRollingOut:
+			// Without ramp steps a rollout stays where it was set
+			if len(t.rampSteps) == 0 || now.Sub(state.RampedAt) < t.rampEvery {
+				continue
+			}
+			t.judge(state.Step.WouldBlock, state.Step.FalsePositives, state)
+		default:
+			if now.Before(state.ProbationEnds) {
+				continue
+			}
+			t.judge(state.WouldBlock, state.FalsePositives, state)
+		}
+		if after := (Transition{ID: state.ID, Status: state.Status, Rollout: state.Rollout}); after != before {
+			changes = append(changes, after)
+		}
+	}
+
+	return changes
+}
+
+// judge moves a rule on from its probation or rollout step given the shadow
+// matches seen during it; callers must hold the lock
+func (t *Tracker) judge(wouldBlock, falsePositives int64, state *RuleState) {
+	// Too few samples to project a rate; keep observing
+	if wouldBlock > 0 && wouldBlock < t.minSamples {
+		return
+	}
+
+	if wouldBlock > 0 && float64(falsePositives)/float64(wouldBlock) > t.maxFPRate {
+		state.Status = StatusHeld
+		state.Rollout = 0
+		rolloutPercent.WithLabelValues(state.ID).Set(0)
+		return
+	}
+
+	for _, step := range t.rampSteps {
+		if step > state.Rollout && step < 100 {
+			t.rollout(state, step)
+			return
+		}
+	}
+	t.promote(state, "auto")
+}
+
+// promote marks a rule as enforcing; callers must hold the lock
+func (t *Tracker) promote(state *RuleState, by string) {
+	state.Status = StatusEnforcing
+	state.Rollout = 100
+	state.PromotedAt = time.Now()
+	state.PromotedBy = by
+	rolloutPercent.WithLabelValues(state.ID).Set(100)
+}
+
+// GetRules returns a snapshot of all tracked rules ordered by age
+func (t *Tracker) GetRules() []RuleState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]RuleState, 0, len(t.rules))
+	for _, state := range t.rules {
+		result = append(result, *state)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AddedAt.Before(result[j].AddedAt)
+	})
+
+	return result
+}
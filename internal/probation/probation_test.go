@@ -0,0 +1,79 @@
+package probation
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRolloutRamp(t *testing.T) {
+	tracker := NewTracker(0, 0.1, 10)
+	if err := tracker.SetRamp([]int{10, 50, 100}, 0); err != nil {
+		t.Fatalf("SetRamp: %v", err)
+	}
+	tracker.Register("sqli-2", "filter")
+
+	enforced := func() map[string]bool {
+		keys := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("192.0.2.%d/%d", i%256, i)
+			if tracker.ShouldEnforce("sqli-2", key) {
+				keys[key] = true
+			}
+		}
+		return keys
+	}
+
+	changes := tracker.Evaluate()
+	if len(changes) != 1 || changes[0].Status != StatusRollingOut || changes[0].Rollout != 10 {
+		t.Fatalf("probation should start the rollout at 10%%: %+v", changes)
+	}
+	first := enforced()
+	if n := len(first); n < 60 || n > 140 {
+		t.Errorf("%d of 1000 clients enforced at 10%%", n)
+	}
+
+	// Clients stay enforced as the rollout grows
+	tracker.Evaluate()
+	second := enforced()
+	if n := len(second); n < 420 || n > 580 {
+		t.Errorf("%d of 1000 clients enforced at 50%%", n)
+	}
+	for key := range first {
+		if !second[key] {
+			t.Fatalf("client %s left the enforced cohort", key)
+		}
+	}
+
+	// A step with too many suspected false positives puts the rule on hold
+	for i := 0; i < 10; i++ {
+		tracker.RecordWouldBlock("sqli-2", fmt.Sprintf("198.51.100.%d", i))
+	}
+	tracker.Vindicate("unseen")
+	if changes := tracker.Evaluate(); len(changes) != 1 || changes[0].Rollout != 100 {
+		t.Fatalf("clean step should promote the rule: %+v", changes)
+	}
+	tracker.SetRollout("sqli-2", 50)
+	for i := 0; i < 10; i++ {
+		tracker.RecordWouldBlock("sqli-2", fmt.Sprintf("198.51.100.%d", i))
+	}
+	for i := 0; i < 5; i++ {
+		tracker.Vindicate(fmt.Sprintf("198.51.100.%d", i))
+	}
+	changes = tracker.Evaluate()
+	if len(changes) != 1 || changes[0].Status != StatusHeld {
+		t.Fatalf("noisy step should hold the rule: %+v", changes)
+	}
+	if len(enforced()) != 0 {
+		t.Error("held rule still enforced")
+	}
+
+	if err := tracker.SetRollout("sqli-2", 100); err != nil {
+		t.Fatalf("SetRollout: %v", err)
+	}
+	if len(enforced()) != 1000 {
+		t.Error("rule at 100% not enforced for everyone")
+	}
+	if err := tracker.SetRamp([]int{50, 10}, 0); err == nil {
+		t.Error("decreasing steps accepted")
+	}
+}
@@ -0,0 +1,313 @@
+// Package proxyprobe checks whether a client address runs an open proxy, as
+// compromised hosts recruited into botnets often do. A port only counts as
+// an open proxy when it agrees to relay a connection, not merely when it
+// accepts one.
+package proxyprobe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ddos-protection/internal/ttlcache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// Protocol is a proxy protocol spoken by a probed port
+type Protocol string
+
+const (
+	ProtocolHTTP   Protocol = "http"
+	ProtocolSOCKS4 Protocol = "socks4"
+	ProtocolSOCKS5 Protocol = "socks5"
+)
+
+// maxCacheEntries bounds the result cache; expired entries are dropped
+// first, then the oldest
+const maxCacheEntries = 100000
+
+var probeCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_proxy_probes_total",
+	Help: "Open proxy probes by result",
+}, []string{"result"})
+
+// Port is a port to probe and the protocol expected on it
+type Port struct {
+	Port     int
+	Protocol Protocol
+}
+
+func (p Port) String() string {
+	return string(p.Protocol) + ":" + strconv.Itoa(p.Port)
+}
+
+// DefaultPorts are ports commonly used by proxies installed on compromised
+// hosts
+var DefaultPorts = []Port{
+	{1080, ProtocolSOCKS5},
+	{1080, ProtocolSOCKS4},
+	{9050, ProtocolSOCKS5},
+	{4145, ProtocolSOCKS4},
+	{3128, ProtocolHTTP},
+	{8080, ProtocolHTTP},
+	{8888, ProtocolHTTP},
+	{8000, ProtocolHTTP},
+}
+
+// ParsePort parses a port written as "protocol:port", e.g. "socks5:1080"
+func ParsePort(s string) (Port, error) {
+	protocol, number, ok := strings.Cut(s, ":")
+	if !ok {
+		return Port{}, fmt.Errorf("invalid proxy port %q: want protocol:port", s)
+	}
+	port, err := strconv.Atoi(number)
+	if err != nil || port <= 0 || port > 65535 {
+		return Port{}, fmt.Errorf("invalid proxy port %q", s)
+	}
+	switch p := Protocol(strings.ToLower(protocol)); p {
+	case ProtocolHTTP, ProtocolSOCKS4, ProtocolSOCKS5:
+		return Port{Port: port, Protocol: p}, nil
+	}
+	return Port{}, fmt.Errorf("invalid proxy port %q: unknown protocol %q", s, protocol)
+}
+
+// Result is the outcome of probing an IP. Proxies lists the ports that
+// agreed to relay, e.g. "socks5:1080".
+type Result struct {
+	Open      bool      `json:"open"`
+	Proxies   []string  `json:"proxies,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Prober probes IPs for open proxies, at most a configured number per
+// second, and caches the answers. onOpen is called for every probe that
+// finds one.
+type Prober struct {
+	ports    []Port
+	target   string
+	timeout  time.Duration
+	limiter  *rate.Limiter
+	onOpen   func(ip string, result Result)
+	dialer   net.Dialer
+	cache    *ttlcache.Cache[Result]
+	inflight map[string]bool
+	mu       sync.Mutex
+}
+
+// NewProber creates a prober that asks proxies to connect to target, a
+// host:port, and calls onOpen when one agrees. Each port gets timeout to
+// answer, and at most perSecond IPs are probed per second.
+func NewProber(ports []Port, target string, timeout, cacheTTL time.Duration, perSecond float64, onOpen func(ip string, result Result)) *Prober {
+	burst := int(perSecond)
+	if burst < 1 {
+		burst = 1
+	}
+	return &Prober{
+		ports:    ports,
+		target:   target,
+		timeout:  timeout,
+		limiter:  rate.NewLimiter(rate.Limit(perSecond), burst),
+		onOpen:   onOpen,
+		cache:    ttlcache.New[Result](cacheTTL, maxCacheEntries),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Cached returns the cached result for ip, if any
+func (p *Prober) Cached(ip string) (Result, bool) {
+	return p.cache.Get(ip, time.Now())
+}
+
+// Probe checks every port of ip in parallel and caches the result. It does
+// not consult the cache or the rate limit.
+func (p *Prober) Probe(ctx context.Context, ip string) (Result, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid IP address: %s", ip)
+	}
+	addr = addr.Unmap()
+
+	open := make([]bool, len(p.ports))
+	var wg sync.WaitGroup
+	for i, port := range p.ports {
+		i, port := i, port
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			open[i] = p.probePort(ctx, netip.AddrPortFrom(addr, uint16(port.Port)).String(), port.Protocol)
+		}()
+	}
+	wg.Wait()
+
+	result := Result{CheckedAt: time.Now()}
+	for i, port := range p.ports {
+		if open[i] {
+			result.Open = true
+			result.Proxies = append(result.Proxies, port.String())
+		}
+	}
+	if result.Open {
+		probeCounter.WithLabelValues("open").Inc()
+	} else {
+		probeCounter.WithLabelValues("closed").Inc()
+	}
+
+	p.store(ip, result)
+	return result, nil
+}
+
+// ProbeAsync starts a background probe of ip unless one is already running,
+// a result is cached, or the rate limit is exhausted
+func (p *Prober) ProbeAsync(ip string) {
+	p.mu.Lock()
+	if p.inflight[ip] {
+		p.mu.Unlock()
+		return
+	}
+	if _, cached := p.cache.Get(ip, time.Now()); cached {
+		p.mu.Unlock()
+		return
+	}
+	if !p.limiter.Allow() {
+		p.mu.Unlock()
+		probeCounter.WithLabelValues("skipped").Inc()
+		return
+	}
+	p.inflight[ip] = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.inflight, ip)
+			p.mu.Unlock()
+		}()
+		result, err := p.Probe(context.Background(), ip)
+		if err == nil && result.Open && p.onOpen != nil {
+			p.onOpen(ip, result)
+		}
+	}()
+}
+
+// probePort reports whether the proxy at address relays a connection to the
+// target
+func (p *Prober) probePort(ctx context.Context, address string, protocol Protocol) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	conn, err := p.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch protocol {
+	case ProtocolSOCKS5:
+		return p.socks5(conn)
+	case ProtocolSOCKS4:
+		return p.socks4(conn)
+	default:
+		return p.httpConnect(conn)
+	}
+}
+
+// socks5 offers no authentication and asks for a connection to the target
+// by name (RFC 1928)
+func (p *Prober) socks5(conn net.Conn) bool {
+	host, port, ok := splitTarget(p.target)
+	if !ok {
+		return false
+	}
+
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return false
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != 5 || reply[1] != 0 {
+		return false
+	}
+
+	req := []byte{5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	return reply[0] == 5 && reply[1] == 0
+}
+
+// socks4 asks for a connection to the target by name, using the SOCKS4a
+// extension
+func (p *Prober) socks4(conn net.Conn) bool {
+	host, port, ok := splitTarget(p.target)
+	if !ok {
+		return false
+	}
+
+	req := []byte{4, 1}
+	req = append(req, byte(port>>8), byte(port))
+	req = append(req, 0, 0, 0, 1, 0)
+	req = append(req, host...)
+	req = append(req, 0)
+	if _, err := conn.Write(req); err != nil {
+		return false
+	}
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	return reply[0] == 0 && reply[1] == 0x5a
+}
+
+// httpConnect sends a CONNECT request for the target
+func (p *Prober) httpConnect(conn net.Conn) bool {
+	req := "CONNECT " + p.target + " HTTP/1.1\r\nHost: " + p.target + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// splitTarget splits a host:port target for the SOCKS requests
+func splitTarget(target string) (string, uint16, bool) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil || host == "" || len(host) > 255 {
+		return "", 0, false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, false
+	}
+	return host, uint16(port), true
+}
+
+func (p *Prober) store(ip string, result Result) {
+	p.cache.Set(ip, result, time.Now())
+}
+
+// CleanupExpired drops expired cache entries
+func (p *Prober) CleanupExpired() {
+	p.cache.Cleanup(time.Now())
+}
@@ -0,0 +1,123 @@
+package proxyprobe
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen serves each connection to a local port with handle and returns
+// the port
+func listen(t *testing.T, handle func(net.Conn)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func port(t *testing.T, rawURL string) int {
+	t.Helper()
+	return int(netip.MustParseAddrPort(strings.TrimPrefix(rawURL, "http://")).Port())
+}
+
+func TestProbeConfirmsRelayingProxies(t *testing.T) {
+	// A SOCKS5 proxy that relays anywhere without authentication
+	openSOCKS := listen(t, func(conn net.Conn) {
+		buf := make([]byte, 3)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte{5, 0})
+		io.ReadFull(conn, buf[:1])
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	})
+	// A SOCKS5 server that requires a password counts as closed
+	authSOCKS := listen(t, func(conn net.Conn) {
+		buf := make([]byte, 3)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte{5, 0xff})
+	})
+
+	openHTTP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect && r.Host == "example.com:80" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer openHTTP.Close()
+	// An ordinary web server refuses CONNECT
+	website := httptest.NewServer(http.NotFoundHandler())
+	defer website.Close()
+
+	ports := []Port{
+		{openSOCKS, ProtocolSOCKS5},
+		{authSOCKS, ProtocolSOCKS5},
+		{port(t, openHTTP.URL), ProtocolHTTP},
+		{port(t, website.URL), ProtocolHTTP},
+	}
+	prober := NewProber(ports, "example.com:80", time.Second, time.Hour, 1, nil)
+
+	result, err := prober.Probe(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ports[0].String(), ports[2].String()}
+	if !result.Open || strings.Join(result.Proxies, ",") != strings.Join(want, ",") {
+		t.Errorf("Proxies = %v, want %v", result.Proxies, want)
+	}
+	if cached, ok := prober.Cached("127.0.0.1"); !ok || !cached.Open {
+		t.Error("expected the result to be cached")
+	}
+}
+
+func TestProbeAsyncIsRateLimited(t *testing.T) {
+	open := make(chan string, 2)
+	prober := NewProber(nil, "example.com:80", time.Second, time.Hour, 0.001, func(ip string, result Result) {
+		open <- ip
+	})
+
+	prober.ProbeAsync("192.0.2.1")
+	prober.ProbeAsync("192.0.2.2")
+	time.Sleep(50 * time.Millisecond)
+
+	if _, ok := prober.Cached("192.0.2.1"); !ok {
+		t.Error("first probe did not run")
+	}
+	if _, ok := prober.Cached("192.0.2.2"); ok {
+		t.Error("second probe ran despite the rate limit")
+	}
+	if len(open) != 0 {
+		t.Error("onOpen called without an open proxy")
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	if p, err := ParsePort("SOCKS5:1080"); err != nil || p != (Port{1080, ProtocolSOCKS5}) {
+		t.Errorf("ParsePort = %+v, %v", p, err)
+	}
+	for _, s := range []string{"1080", "socks5:0", "ftp:21"} {
+		if _, err := ParsePort(s); err == nil {
+			t.Errorf("ParsePort(%q) accepted", s)
+		}
+	}
+}
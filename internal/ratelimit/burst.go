@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window is one of the windows a BurstTracker evaluates. More than Threshold
+// requests within Size is a burst; more than Cap, if set, is rejected.
+type Window struct {
+	Size      time.Duration
+	Threshold int
+	Cap       int
+}
+
+// Burst describes the window a client exceeded
+type Burst struct {
+	Window time.Duration
+	Count  int
+	Limit  int
+	Capped bool
+}
+
+// burstCounter holds a client's requests in one-second buckets, as a ring
+// indexed by unix second
+type burstCounter struct {
+	buckets []int
+	last    int64
+}
+
+// BurstTracker counts each client's requests over several windows at once,
+// catching micro-bursts that hammer the server for a second or two while
+// staying under the per-minute limit. Counts are sliding-window estimates
+// from one-second buckets.
+type BurstTracker struct {
+	windows  []Window
+	seconds  int
+	counters map[string]*burstCounter
+	mu       sync.Mutex
+}
+
+// NewBurstTracker creates a tracker for windows, which are evaluated
+// shortest first. Windows are rounded to whole seconds.
+func NewBurstTracker(windows []Window) *BurstTracker {
+	sorted := make([]Window, 0, len(windows))
+	seconds := 1
+	for _, w := range windows {
+		if w.Size <= 0 || w.Threshold <= 0 {
+			continue
+		}
+		w.Size = w.Size.Round(time.Second)
+		if w.Size < time.Second {
+			w.Size = time.Second
+		}
+		sorted = append(sorted, w)
+		if s := int(w.Size / time.Second); s > seconds {
+			seconds = s
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Size < sorted[j].Size })
+
+	return &BurstTracker{
+		windows:  sorted,
+		seconds:  seconds,
+		counters: make(map[string]*burstCounter),
+	}
+}
+
+// Windows returns the evaluated windows, shortest first
+func (bt *BurstTracker) Windows() []Window {
+	return append([]Window(nil), bt.windows...)
+}
+
+// Record counts a request from key at now and reports the window it
+// exceeded, if any. A capped window is reported ahead of one that is only
+// over its threshold; otherwise the shortest window wins.
+func (bt *BurstTracker) Record(key string, now time.Time) (Burst, bool) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	counter, exists := bt.counters[key]
+	if !exists {
+		// One spare bucket holds the second before the oldest window starts
+		counter = &burstCounter{buckets: make([]int, bt.seconds+1)}
+		bt.counters[key] = counter
+	}
+	second := now.Unix()
+	counter.advance(second)
+	counter.buckets[bt.index(second)]++
+
+	elapsed := float64(now.Nanosecond()) / float64(time.Second)
+	var found Burst
+	var exceeded bool
+	for _, w := range bt.windows {
+		count := bt.count(counter, second, int(w.Size/time.Second), elapsed)
+		if w.Cap > 0 && count > w.Cap {
+			return Burst{Window: w.Size, Count: count, Limit: w.Cap, Capped: true}, true
+		}
+		if !exceeded && count > w.Threshold {
+			found = Burst{Window: w.Size, Count: count, Limit: w.Threshold}
+			exceeded = true
+		}
+	}
+	return found, exceeded
+}
+
+// Counts returns the estimated requests from key in each window at now
+func (bt *BurstTracker) Counts(key string, now time.Time) map[time.Duration]int {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	counts := make(map[time.Duration]int, len(bt.windows))
+	counter, exists := bt.counters[key]
+	second := now.Unix()
+	if exists {
+		counter.advance(second)
+	}
+	elapsed := float64(now.Nanosecond()) / float64(time.Second)
+	for _, w := range bt.windows {
+		if exists {
+			counts[w.Size] = bt.count(counter, second, int(w.Size/time.Second), elapsed)
+		} else {
+			counts[w.Size] = 0
+		}
+	}
+	return counts
+}
+
+// index returns the ring bucket of a unix second
+func (bt *BurstTracker) index(second int64) int {
+	return int(second % int64(bt.seconds+1))
+}
+
+// count estimates the requests in the last size seconds: the full buckets
+// inside the window plus the share of the bucket it starts in that has not
+// yet slid out
+func (bt *BurstTracker) count(counter *burstCounter, second int64, size int, elapsed float64) int {
+	total := 0
+	for i := 0; i < size; i++ {
+		total += counter.buckets[bt.index(second-int64(i))]
+	}
+	oldest := counter.buckets[bt.index(second-int64(size))]
+	return total + int(float64(oldest)*(1-elapsed))
+}
+
+// advance clears the buckets of the seconds since the counter last moved
+func (c *burstCounter) advance(second int64) {
+	if c.last == 0 || second-c.last >= int64(len(c.buckets)) {
+		for i := range c.buckets {
+			c.buckets[i] = 0
+		}
+	} else {
+		for s := c.last + 1; s <= second; s++ {
+			c.buckets[int(s%int64(len(c.buckets)))] = 0
+		}
+	}
+	if second > c.last {
+		c.last = second
+	}
+}
+
+// Len returns the number of clients tracked
+func (bt *BurstTracker) Len() int {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	return len(bt.counters)
+}
+
+// Cleanup drops clients with no requests in the longest window
+func (bt *BurstTracker) Cleanup(now time.Time) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	cutoff := now.Unix() - int64(bt.seconds)
+	for key, counter := range bt.counters {
+		if counter.last <= cutoff {
+			delete(bt.counters, key)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// maxTrackedKeys bounds how many keys keep rejection history
+	maxTrackedKeys = 10000
+	// recentRejections is how many rejection times are kept per key
+	recentRejections = 20
+)
+
+// KeyState describes the current standing of a key in a limiter
+type KeyState struct {
+	Key         string         `json:"key"`
+	Limit       int            `json:"limit"`
+	Burst       int            `json:"burst"`
+	Tokens      *float64       `json:"tokens,omitempty"`
+	Used        int            `json:"used"`
+	Remaining   int            `json:"remaining"`
+	NextAllowed time.Time      `json:"next_allowed"`
+	Tracked     bool           `json:"tracked"`
+	Windows     map[string]int `json:"windows,omitempty"`
+}
+
+// Inspector is implemented by limiters that can report per-key state
+type Inspector interface {
+	Inspect(ctx context.Context, key string) (*KeyState, error)
+}
+
+// Inspect returns the token bucket state of key
+func (tbl *TokenBucketLimiter) Inspect(ctx context.Context, key string) (*KeyState, error) {
+	tbl.mu.RLock()
+	limiter, exists := tbl.limiters[key]
+	limit, burst := tbl.limit, tbl.burst
+	tbl.mu.RUnlock()
+
+	now := time.Now()
+	state := &KeyState{
+		Key:         key,
+		Limit:       int(limit * 60),
+		Burst:       burst,
+		Remaining:   burst,
+		NextAllowed: now,
+		Tracked:     exists,
+	}
+	if !exists {
+		return state, nil
+	}
+
+	tokens := limiter.TokensAt(now)
+	state.Tokens = &tokens
+	state.Remaining = int(tokens)
+	state.Used = burst - state.Remaining
+	if tokens < 1 && limit > 0 {
+		wait := time.Duration((1 - tokens) / float64(limit) * float64(time.Second))
+		state.NextAllowed = now.Add(wait)
+	}
+	return state, nil
+}
+
+// Inspect returns the sliding window state of key
+func (swl *SlidingWindowLimiter) Inspect(ctx context.Context, key string) (*KeyState, error) {
+	swl.mu.RLock()
+	requests, exists := swl.requests[key]
+	swl.mu.RUnlock()
+
+	now := time.Now()
+	var valid []time.Time
+	for _, t := range requests {
+		if t.After(now.Add(-swl.window)) {
+			valid = append(valid, t)
+		}
+	}
+
+	return windowState(key, swl.limit, swl.window, valid, now, exists), nil
+}
+
+// Inspect returns the Redis sliding window state of key
+func (rl *RedisLimiter) Inspect(ctx context.Context, key string) (*KeyState, error) {
+	redisKey := rl.prefix + key
+	now := time.Now()
+
+	members, err := rl.client.ZRangeByScoreWithScores(ctx, redisKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.Add(-rl.window).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit key: %v", err)
+	}
+
+	valid := make([]time.Time, 0, len(members))
+	for _, m := range members {
+		valid = append(valid, time.Unix(int64(m.Score), 0))
+	}
+
+	return windowState(key, rl.GetLimit(), rl.window, valid, now, len(members) > 0), nil
+}
+
+// windowState summarises the requests of a key inside a sliding window.
+// valid must be sorted oldest first.
+func windowState(key string, limit int, window time.Duration, valid []time.Time, now time.Time, tracked bool) *KeyState {
+	state := &KeyState{
+		Key:         key,
+		Limit:       limit,
+		Burst:       int(window.Seconds()),
+		Used:        len(valid),
+		NextAllowed: now,
+		Tracked:     tracked,
+	}
+	if state.Used < limit {
+		state.Remaining = limit - state.Used
+		return state
+	}
+
+	// The key frees up once enough of its oldest requests leave the window
+	state.NextAllowed = valid[state.Used-limit].Add(window)
+	return state
+}
+
+// RejectionStats summarises the rejections of a key
+type RejectionStats struct {
+	Key    string      `json:"key"`
+	Total  int64       `json:"total"`
+	Recent []time.Time `json:"recent"`
+	Last   time.Time   `json:"last"`
+}
+
+// RejectionTracker keeps per-key rejection counts and recent rejection times
+type RejectionTracker struct {
+	keys map[string]*RejectionStats
+	mu   sync.Mutex
+}
+
+// NewRejectionTracker creates a new rejection tracker
+func NewRejectionTracker() *RejectionTracker {
+	return &RejectionTracker{keys: make(map[string]*RejectionStats)}
+}
+
+// Record counts a rejection of key
+func (rt *RejectionTracker) Record(key string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	stats, exists := rt.keys[key]
+	if !exists {
+		if len(rt.keys) >= maxTrackedKeys {
+			rt.evictOldestLocked()
+		}
+		stats = &RejectionStats{Key: key}
+		rt.keys[key] = stats
+	}
+
+	stats.Total++
+	stats.Last = now
+	stats.Recent = append(stats.Recent, now)
+	if len(stats.Recent) > recentRejections {
+		stats.Recent = stats.Recent[len(stats.Recent)-recentRejections:]
+	}
+}
+
+// Get returns the rejection stats of key
+func (rt *RejectionTracker) Get(key string) RejectionStats {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	stats, exists := rt.keys[key]
+	if !exists {
+		return RejectionStats{Key: key, Recent: []time.Time{}}
+	}
+	return copyStats(stats)
+}
+
+// Top returns the n keys with the most rejections since the given time
+func (rt *RejectionTracker) Top(n int, since time.Time) []RejectionStats {
+	rt.mu.Lock()
+	result := make([]RejectionStats, 0, len(rt.keys))
+	for _, stats := range rt.keys {
+		if stats.Last.After(since) {
+			result = append(result, copyStats(stats))
+		}
+	}
+	rt.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// Cleanup forgets keys with no rejections since the given time
+func (rt *RejectionTracker) Cleanup(since time.Time) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for key, stats := range rt.keys {
+		if stats.Last.Before(since) {
+			delete(rt.keys, key)
+		}
+	}
+}
+
+func (rt *RejectionTracker) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, stats := range rt.keys {
+		if oldestKey == "" || stats.Last.Before(oldest) {
+			oldestKey, oldest = key, stats.Last
+		}
+	}
+	delete(rt.keys, oldestKey)
+}
+
+func copyStats(stats *RejectionStats) RejectionStats {
+	c := *stats
+	c.Recent = append([]time.Time{}, stats.Recent...)
+	return c
+}
@@ -17,6 +17,12 @@ type Limiter interface {
 	GetBurst() int
 }
 
+// Adjuster is implemented by limiters whose limits can change in place,
+// keeping what every key has already used
+type Adjuster interface {
+	SetLimits(requestsPerMinute, burstSize int)
+}
+
 // TokenBucketLimiter implements token bucket algorithm
 type TokenBucketLimiter struct {
 	limiters map[string]*rate.Limiter
@@ -50,20 +56,41 @@ func (tbl *TokenBucketLimiter) Allow(ctx context.Context, key string) bool {
 
 // GetLimit returns the configured limit
 func (tbl *TokenBucketLimiter) GetLimit() int {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
 	return int(tbl.limit * 60) // Convert back to per minute
 }
 
 // GetBurst returns the configured burst size
 func (tbl *TokenBucketLimiter) GetBurst() int {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
 	return tbl.burst
 }
 
+// SetLimits changes the limit and burst size of every key. Each key keeps
+// the tokens it has, capped at the new burst size, so a change never hands
+// clients a fresh burst.
+func (tbl *TokenBucketLimiter) SetLimits(requestsPerMinute, burstSize int) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	tbl.limit = rate.Limit(requestsPerMinute) / 60.0
+	tbl.burst = burstSize
+	now := time.Now()
+	for _, limiter := range tbl.limiters {
+		limiter.SetLimitAt(now, tbl.limit)
+		limiter.SetBurstAt(now, tbl.burst)
+	}
+}
+
 // RedisLimiter implements rate limiting using Redis for distributed systems
 type RedisLimiter struct {
 	client  *redis.Client
 	limit   int
 	window  time.Duration
 	prefix  string
+	mu      sync.RWMutex
 }
 
 // NewRedisLimiter creates a new Redis-based limiter
@@ -105,14 +132,24 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string) bool {
 		return true
 	}
 	
-	return count.Val() < int64(rl.limit)
+	return count.Val() < int64(rl.GetLimit())
 }
 
 // GetLimit returns the configured limit
 func (rl *RedisLimiter) GetLimit() int {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
 	return rl.limit
 }
 
+// SetLimits changes the limit of every key, counted over the same window.
+// The window has no burst, so burstSize is ignored.
+func (rl *RedisLimiter) SetLimits(requestsPerMinute, burstSize int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = requestsPerMinute
+}
+
 // GetBurst returns the window size as burst (Redis doesn't have traditional burst)
 func (rl *RedisLimiter) GetBurst() int {
 	return int(rl.window.Seconds())
@@ -50,6 +50,44 @@ func TestTokenBucketLimiter(t *testing.T) {
 	}
 }
 
+func TestSetLimits(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketLimiter(60, 5)
+	for i := 0; i < 5; i++ {
+		limiter.Allow(ctx, "spent-ip")
+	}
+	limiter.Allow(ctx, "fresh-ip")
+
+	// Lowering the limits keeps spent buckets empty and caps full ones
+	limiter.SetLimits(30, 2)
+	if limiter.Allow(ctx, "spent-ip") {
+		t.Error("limit change refilled a spent bucket")
+	}
+	if limiter.GetLimit() != 30 || limiter.GetBurst() != 2 {
+		t.Errorf("limits = %d/%d, want 30/2", limiter.GetLimit(), limiter.GetBurst())
+	}
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if limiter.Allow(ctx, "fresh-ip") {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("%d requests allowed after lowering the burst to 2", allowed)
+	}
+
+	// Raising them does not hand out a new burst either
+	limiter.SetLimits(600, 100)
+	if limiter.Allow(ctx, "spent-ip") {
+		t.Error("raising the limits refilled a spent bucket")
+	}
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow(ctx, "new-ip") {
+			t.Fatalf("new key limited at request %d of the new burst", i+1)
+		}
+	}
+}
+
 func TestSlidingWindowLimiter(t *testing.T) {
 	limiter := NewSlidingWindowLimiter(5, time.Minute) // 5 requests per minute
 
@@ -138,3 +176,87 @@ func BenchmarkSlidingWindowLimiter(b *testing.B) {
 		}
 	})
 }
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+
+	tbl := NewTokenBucketLimiter(60, 3)
+	for i := 0; i < 4; i++ {
+		tbl.Allow(ctx, "bucket-ip")
+	}
+	state, err := tbl.Inspect(ctx, "bucket-ip")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if state.Remaining != 0 || !state.NextAllowed.After(time.Now()) {
+		t.Errorf("Exhausted bucket: got remaining %d, next allowed %v", state.Remaining, state.NextAllowed)
+	}
+
+	swl := NewSlidingWindowLimiter(2, time.Minute)
+	swl.Allow(ctx, "window-ip")
+	state, err = swl.Inspect(ctx, "window-ip")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if state.Used != 1 || state.Remaining != 1 {
+		t.Errorf("Window: got used %d, remaining %d; want 1, 1", state.Used, state.Remaining)
+	}
+}
+
+func TestRejectionTrackerTop(t *testing.T) {
+	rt := NewRejectionTracker()
+	for i := 0; i < 3; i++ {
+		rt.Record("a")
+	}
+	rt.Record("b")
+
+	top := rt.Top(1, time.Now().Add(-time.Minute))
+	if len(top) != 1 || top[0].Key != "a" || top[0].Total != 3 {
+		t.Errorf("Top(1) = %+v, want key a with 3 rejections", top)
+	}
+	if got := rt.Get("b"); got.Total != 1 || len(got.Recent) != 1 {
+		t.Errorf("Get(b) = %+v, want 1 rejection", got)
+	}
+}
+
+func TestBurstTracker(t *testing.T) {
+	tracker := NewBurstTracker([]Window{
+		{Size: time.Minute, Threshold: 100},
+		{Size: time.Second, Threshold: 5, Cap: 8},
+	})
+	start := time.Unix(1700000000, 0)
+
+	// A steady request every 2 seconds never bursts
+	for i := 0; i < 30; i++ {
+		if burst, exceeded := tracker.Record("steady", start.Add(time.Duration(i)*2*time.Second)); exceeded {
+			t.Fatalf("steady client flagged: %+v", burst)
+		}
+	}
+
+	// Ten requests inside one second pass the threshold, then the cap
+	var last Burst
+	for i := 0; i < 10; i++ {
+		burst, exceeded := tracker.Record("spiky", start.Add(time.Duration(i)*50*time.Millisecond))
+		switch {
+		case i < 5 && exceeded:
+			t.Fatalf("request %d flagged early: %+v", i+1, burst)
+		case i >= 5 && !exceeded:
+			t.Fatalf("request %d not flagged", i+1)
+		}
+		last = burst
+	}
+	if !last.Capped || last.Window != time.Second || last.Count != 10 {
+		t.Errorf("last burst = %+v, want capped 1s window with 10 requests", last)
+	}
+
+	// The short window slides on while the minute still counts everything
+	counts := tracker.Counts("spiky", start.Add(3*time.Second))
+	if counts[time.Second] != 0 || counts[time.Minute] != 10 {
+		t.Errorf("counts = %v, want 0 in 1s and 10 in 1m", counts)
+	}
+
+	tracker.Cleanup(start.Add(2 * time.Minute))
+	if tracker.Len() != 0 {
+		t.Errorf("Len() = %d after cleanup, want 0", tracker.Len())
+	}
+}
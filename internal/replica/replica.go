@@ -0,0 +1,276 @@
+// Package replica shares security events and traffic stats through Redis,
+// so read replicas can serve dashboards and event queries without taking
+// part in enforcement. Enforcing nodes publish with a Publisher; replicas
+// read with a Reader.
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"ddos-protection/internal/events"
+	"ddos-protection/internal/monitor"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// eventsStream is the Redis stream of security events
+	eventsStream = "replica:events"
+	// statsPrefix prefixes each node's latest stats snapshot
+	statsPrefix = "replica:stats:"
+)
+
+// DefaultMaxEvents is how many events the stream keeps by default
+const DefaultMaxEvents = 100000
+
+var droppedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ddos_protection_replica_events_dropped_total",
+	Help: "Security events not published for replicas because the queue was full or Redis failed",
+})
+
+// Snapshot is a node's traffic stats at a point in time
+type Snapshot struct {
+	Node  string                `json:"node"`
+	Time  time.Time             `json:"time"`
+	Stats *monitor.TrafficStats `json:"stats"`
+}
+
+// Publisher sends an enforcing node's events and stats to Redis. Events
+// are queued and written in the background, so requests never wait on
+// Redis; when the queue is full they are dropped and counted.
+type Publisher struct {
+	client    *redis.Client
+	node      string
+	maxEvents int64
+	queue     chan events.Event
+}
+
+// NewPublisher creates a publisher for node, capping the stream at
+// maxEvents
+func NewPublisher(client *redis.Client, node string, maxEvents int) *Publisher {
+	if maxEvents <= 0 {
+		maxEvents = DefaultMaxEvents
+	}
+	return &Publisher{
+		client:    client,
+		node:      node,
+		maxEvents: int64(maxEvents),
+		queue:     make(chan events.Event, 1000),
+	}
+}
+
+// PublishEvent queues an event for replicas
+func (p *Publisher) PublishEvent(e events.Event) {
+	e.Node = p.node
+	select {
+	case p.queue <- e:
+	default:
+		droppedCounter.Inc()
+	}
+}
+
+// Run writes queued events as they come and a stats snapshot every
+// interval until ctx is done. A snapshot expires if the node stops
+// publishing, so replicas drop nodes that are gone.
+func (p *Publisher) Run(ctx context.Context, interval time.Duration, stats func() *monitor.TrafficStats) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-p.queue:
+			p.writeEvent(ctx, e)
+		case <-ticker.C:
+			data, err := json.Marshal(Snapshot{Node: p.node, Time: time.Now(), Stats: stats()})
+			if err == nil {
+				p.client.Set(ctx, statsPrefix+p.node, data, 3*interval)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Publisher) writeEvent(ctx context.Context, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	err = p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventsStream,
+		MaxLen: p.maxEvents,
+		Approx: true,
+		Values: map[string]interface{}{"event": data},
+	}).Err()
+	if err != nil {
+		droppedCounter.Inc()
+	}
+}
+
+// Reader follows what enforcing nodes publish
+type Reader struct {
+	client *redis.Client
+	lastID string
+}
+
+// NewReader creates a reader starting from the oldest event kept
+func NewReader(client *redis.Client) *Reader {
+	return &Reader{client: client, lastID: "0"}
+}
+
+// Tail adds published events to store as they arrive until ctx is done or
+// Redis fails. The store assigns its own IDs; the publishing node is kept
+// in each event. Calling Tail again resumes after the last event read.
+func (r *Reader) Tail(ctx context.Context, store *events.Store) error {
+	for {
+		streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{eventsStream, r.lastID},
+			Count:   1000,
+			Block:   5 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				r.lastID = message.ID
+				data, _ := message.Values["event"].(string)
+				var e events.Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					store.Add(e)
+				}
+			}
+		}
+	}
+}
+
+// Snapshots returns the latest stats of every node still publishing, by
+// node name
+func (r *Reader) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, statsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return []Snapshot{}, nil
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var s Snapshot
+		if json.Unmarshal([]byte(data), &s) == nil && s.Stats != nil {
+			snapshots = append(snapshots, s)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Node < snapshots[j].Node })
+	return snapshots, nil
+}
+
+// Ping checks Redis is reachable
+func (r *Reader) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Merge combines the stats of several nodes. Counts and rates add up,
+// averages are weighted by requests, and a client seen by several nodes
+// has its counts combined. Unique IPs adds the nodes' counts, so clients
+// balanced across nodes are counted once per node.
+func Merge(snapshots []Snapshot) *monitor.TrafficStats {
+	merged := &monitor.TrafficStats{
+		TopIPs:        []monitor.IPStats{},
+		Methods:       make(map[string]map[string]int64),
+		MonitorAgents: make(map[string]int64),
+	}
+
+	var weightedResponse, weightedErrors float64
+	clients := make(map[string]*monitor.IPStats)
+	for _, s := range snapshots {
+		stats := s.Stats
+		merged.TotalRequests += stats.TotalRequests
+		merged.UniqueIPs += stats.UniqueIPs
+		merged.RequestsPerMinute += stats.RequestsPerMinute
+		weightedResponse += float64(stats.AverageResponseTime) * float64(stats.TotalRequests)
+		weightedErrors += stats.ErrorRate * float64(stats.TotalRequests)
+
+		for path, methods := range stats.Methods {
+			if merged.Methods[path] == nil {
+				merged.Methods[path] = make(map[string]int64)
+			}
+			for method, count := range methods {
+				merged.Methods[path][method] += count
+			}
+		}
+		for agent, count := range stats.MonitorAgents {
+			merged.MonitorAgents[agent] += count
+		}
+
+		for _, ip := range stats.TopIPs {
+			client, exists := clients[ip.IP]
+			if !exists {
+				copied := ip
+				clients[ip.IP] = &copied
+				continue
+			}
+			total := client.RequestCount + ip.RequestCount
+			if total > 0 {
+				client.AverageResponseTime = time.Duration((float64(client.AverageResponseTime)*float64(client.RequestCount) +
+					float64(ip.AverageResponseTime)*float64(ip.RequestCount)) / float64(total))
+			}
+			client.RequestCount = total
+			client.ErrorCount += ip.ErrorCount
+			if ip.LastSeen.After(client.LastSeen) {
+				client.LastSeen = ip.LastSeen
+			}
+		}
+	}
+
+	if merged.TotalRequests > 0 {
+		merged.AverageResponseTime = time.Duration(weightedResponse / float64(merged.TotalRequests))
+		merged.ErrorRate = weightedErrors / float64(merged.TotalRequests)
+	}
+
+	top := 0
+	for _, s := range snapshots {
+		if n := len(s.Stats.TopIPs); n > top {
+			top = n
+		}
+	}
+	for _, client := range clients {
+		merged.TopIPs = append(merged.TopIPs, *client)
+	}
+	sort.Slice(merged.TopIPs, func(i, j int) bool {
+		if merged.TopIPs[i].RequestCount != merged.TopIPs[j].RequestCount {
+			return merged.TopIPs[i].RequestCount > merged.TopIPs[j].RequestCount
+		}
+		return strings.Compare(merged.TopIPs[i].IP, merged.TopIPs[j].IP) < 0
+	})
+	if len(merged.TopIPs) > top {
+		merged.TopIPs = merged.TopIPs[:top]
+	}
+	return merged
+}
@@ -0,0 +1,50 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"ddos-protection/internal/monitor"
+)
+
+func TestMergeCombinesNodes(t *testing.T) {
+	seen := time.Now()
+	merged := Merge([]Snapshot{
+		{Node: "a", Stats: &monitor.TrafficStats{
+			TotalRequests:       100,
+			UniqueIPs:           2,
+			RequestsPerMinute:   10,
+			AverageResponseTime: 10 * time.Millisecond,
+			ErrorRate:           0.1,
+			TopIPs: []monitor.IPStats{
+				{IP: "10.0.0.1", RequestCount: 60},
+				{IP: "10.0.0.2", RequestCount: 40},
+			},
+			Methods: map[string]map[string]int64{"/": {"GET": 100}},
+		}},
+		{Node: "b", Stats: &monitor.TrafficStats{
+			TotalRequests:       300,
+			UniqueIPs:           1,
+			RequestsPerMinute:   30,
+			AverageResponseTime: 30 * time.Millisecond,
+			TopIPs:              []monitor.IPStats{{IP: "10.0.0.2", RequestCount: 300, LastSeen: seen}},
+			Methods:             map[string]map[string]int64{"/": {"GET": 200, "POST": 100}},
+		}},
+	})
+
+	if merged.TotalRequests != 400 || merged.RequestsPerMinute != 40 || merged.UniqueIPs != 3 {
+		t.Errorf("totals %d requests, %v/min, %d IPs", merged.TotalRequests, merged.RequestsPerMinute, merged.UniqueIPs)
+	}
+	if merged.AverageResponseTime != 25*time.Millisecond {
+		t.Errorf("average response time %v, want 25ms", merged.AverageResponseTime)
+	}
+	if merged.ErrorRate != 0.025 {
+		t.Errorf("error rate %v, want 0.025", merged.ErrorRate)
+	}
+	if len(merged.TopIPs) != 2 || merged.TopIPs[0].IP != "10.0.0.2" || merged.TopIPs[0].RequestCount != 340 || !merged.TopIPs[0].LastSeen.Equal(seen) {
+		t.Errorf("top IPs %+v", merged.TopIPs)
+	}
+	if merged.Methods["/"]["GET"] != 300 || merged.Methods["/"]["POST"] != 100 {
+		t.Errorf("methods %v", merged.Methods)
+	}
+}
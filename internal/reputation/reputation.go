@@ -0,0 +1,433 @@
+package reputation
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisKey is the hash holding reputation records as JSON by IP
+	redisKey = "reputation"
+	// maxEntries bounds how many IPs are tracked; the best-reputed go first
+	maxEntries = 100000
+	// minResponses is how many responses an IP needs before its error ratio
+	// counts, so a single 404 does not hurt a new client
+	minResponses = 10
+	// riskSmoothing is the weight of each new risk score in the running average
+	riskSmoothing = 0.2
+	// recovered is the penalty below which a record is dropped as clean
+	recovered = 1.0
+)
+
+// Weights control how much each signal lowers the score. Risk, Botnet and
+// Errors scale signals normalised to 0–100; Feed is a flat penalty for
+// being listed on a threat feed.
+type Weights struct {
+	Risk   float64 `json:"risk"`
+	Botnet float64 `json:"botnet"`
+	Errors float64 `json:"errors"`
+	Feed   float64 `json:"feed"`
+}
+
+// DefaultWeights are used for any weight left at zero
+var DefaultWeights = Weights{Risk: 0.5, Botnet: 0.6, Errors: 0.4, Feed: 50}
+
+// record is the persisted state of an IP. Every signal decays towards zero
+// with the tracker's half-life.
+type record struct {
+	Risk         float64   `json:"risk"`
+	Botnet       float64   `json:"botnet"`
+	Responses    float64   `json:"responses"`
+	ClientErrors float64   `json:"client_errors"`
+	ServerErrors float64   `json:"server_errors"`
+	Feed         float64   `json:"feed"`
+	Feeds        []string  `json:"feeds,omitempty"`
+	Marks        []Mark    `json:"marks,omitempty"`
+	Updated      time.Time `json:"updated"`
+}
+
+// Mark is a fixed penalty that holds until it expires instead of decaying,
+// for findings such as a confirmed open proxy
+type Mark struct {
+	Name    string    `json:"name"`
+	Penalty float64   `json:"penalty"`
+	Until   time.Time `json:"until"`
+}
+
+// Report is the reputation of an IP and the signals behind it. For IPv6
+// clients grouped by network, Score is the lower of the address's own score
+// and Network's, and the signals are the address's.
+type Report struct {
+	IP         string    `json:"ip"`
+	Score      int       `json:"score"`
+	Tracked    bool      `json:"tracked"`
+	Risk       float64   `json:"risk"`
+	Botnet     float64   `json:"botnet"`
+	ErrorRatio float64   `json:"error_ratio"`
+	Responses  int       `json:"responses"`
+	Feeds      []string  `json:"feeds,omitempty"`
+	Marks      []Mark    `json:"marks,omitempty"`
+	Updated    time.Time `json:"updated,omitempty"`
+	Network    *Report   `json:"network,omitempty"`
+}
+
+// Tracker aggregates abuse signals into a 0–100 score per IP, where 100 is
+// a clean or unknown client and 0 is the worst
+type Tracker struct {
+	client     *redis.Client
+	halfLife   time.Duration
+	weights    Weights
+	ipv6Prefix int
+	records    map[string]*record
+	dirty      map[string]bool
+	mu         sync.Mutex
+}
+
+// NewTracker creates a tracker whose signals halve every halfLife. A nil
+// client keeps reputation in memory only.
+func NewTracker(client *redis.Client, halfLife time.Duration, weights Weights) *Tracker {
+	if weights.Risk == 0 {
+		weights.Risk = DefaultWeights.Risk
+	}
+	if weights.Botnet == 0 {
+		weights.Botnet = DefaultWeights.Botnet
+	}
+	if weights.Errors == 0 {
+		weights.Errors = DefaultWeights.Errors
+	}
+	if weights.Feed == 0 {
+		weights.Feed = DefaultWeights.Feed
+	}
+
+	return &Tracker{
+		client:   client,
+		halfLife: halfLife,
+		weights:  weights,
+		records:  make(map[string]*record),
+		dirty:    make(map[string]bool),
+	}
+}
+
+// SetIPv6Prefix also tracks IPv6 clients by the network of the given prefix
+// length, so rotating privacy addresses within it shares one reputation.
+// Zero tracks addresses only.
+func (t *Tracker) SetIPv6Prefix(bits int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ipv6Prefix = bits
+}
+
+// network returns the network ip is grouped into, or "" if it is not
+func (t *Tracker) network(ip string) string {
+	if t.ipv6Prefix <= 0 {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return ""
+	}
+	prefix, err := addr.Prefix(t.ipv6Prefix)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// RecordRisk folds a request filter risk score (0–100) into the running
+// average of ip
+func (t *Tracker) RecordRisk(ip string, score int) {
+	t.update(ip, func(r *record) {
+		r.Risk += (clamp(float64(score)) - r.Risk) * riskSmoothing
+	})
+}
+
+// RecordBotnet records a botnet detection confidence (0–1) for ip
+func (t *Tracker) RecordBotnet(ip string, confidence float64) {
+	t.update(ip, func(r *record) {
+		r.Botnet = math.Max(r.Botnet, clamp(confidence*100))
+	})
+}
+
+// RecordResponse counts a response served to ip by the protected upstream
+func (t *Tracker) RecordResponse(ip string, status int) {
+	t.update(ip, func(r *record) {
+		r.Responses++
+		switch {
+		case status >= 500:
+			r.ServerErrors++
+		case status >= 400:
+			r.ClientErrors++
+		}
+	})
+}
+
+// RecordFeed records that ip is listed on the named threat feed
+func (t *Tracker) RecordFeed(ip, feed string) {
+	t.update(ip, func(r *record) {
+		r.Feed = 1
+		for _, f := range r.Feeds {
+			if f == feed {
+				return
+			}
+		}
+		r.Feeds = append(r.Feeds, feed)
+	})
+}
+
+// RecordMark applies a fixed penalty to ip until the given time. Recording
+// a mark again under the same name replaces it.
+func (t *Tracker) RecordMark(ip, name string, penalty float64, until time.Time) {
+	t.update(ip, func(r *record) {
+		marks := make([]Mark, 0, len(r.Marks)+1)
+		for _, m := range r.Marks {
+			if m.Name != name {
+				marks = append(marks, m)
+			}
+		}
+		r.Marks = append(marks, Mark{Name: name, Penalty: penalty, Until: until})
+	})
+}
+
+func (t *Tracker) update(ip string, fn func(r *record)) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.updateLocked(ip, now, fn)
+	if network := t.network(ip); network != "" {
+		t.updateLocked(network, now, fn)
+	}
+}
+
+func (t *Tracker) updateLocked(key string, now time.Time, fn func(r *record)) {
+	r, exists := t.records[key]
+	if !exists {
+		if len(t.records) >= maxEntries {
+			t.evictLocked(now)
+		}
+		r = &record{Updated: now}
+		t.records[key] = r
+	}
+	t.decay(r, now)
+	fn(r)
+	t.dirty[key] = true
+}
+
+// decay ages the signals of r to now
+func (t *Tracker) decay(r *record, now time.Time) {
+	elapsed := now.Sub(r.Updated)
+	if elapsed > 0 && t.halfLife > 0 {
+		factor := math.Pow(0.5, float64(elapsed)/float64(t.halfLife))
+		r.Risk *= factor
+		r.Botnet *= factor
+		r.Responses *= factor
+		r.ClientErrors *= factor
+		r.ServerErrors *= factor
+		r.Feed *= factor
+		if r.Feed*t.weights.Feed < recovered {
+			r.Feed = 0
+			r.Feeds = nil
+		}
+	}
+	if len(r.Marks) > 0 {
+		// Filter into a new slice, as reads decay a shallow copy
+		var live []Mark
+		for _, m := range r.Marks {
+			if now.Before(m.Until) {
+				live = append(live, m)
+			}
+		}
+		r.Marks = live
+	}
+	r.Updated = now
+}
+
+func (t *Tracker) penalty(r *record) float64 {
+	p := r.Risk*t.weights.Risk + r.Botnet*t.weights.Botnet + r.Feed*t.weights.Feed
+	for _, m := range r.Marks {
+		p += m.Penalty
+	}
+	if math.Round(r.Responses) >= minResponses {
+		p += errorRatio(r) * 100 * t.weights.Errors
+	}
+	return clamp(p)
+}
+
+func errorRatio(r *record) float64 {
+	if r.Responses == 0 {
+		return 0
+	}
+	return math.Min(1, (r.ClientErrors+r.ServerErrors)/r.Responses)
+}
+
+// Score returns the reputation of ip, 100 if it is unknown
+func (t *Tracker) Score(ip string) int {
+	return t.Get(ip).Score
+}
+
+// Get returns the reputation of ip and the signals behind it
+func (t *Tracker) Get(ip string) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	report := t.reportLocked(ip, now)
+	if network := t.network(ip); network != "" {
+		networkReport := t.reportLocked(network, now)
+		report.Network = &networkReport
+		if networkReport.Score < report.Score {
+			report.Score = networkReport.Score
+		}
+	}
+	return report
+}
+
+func (t *Tracker) reportLocked(key string, now time.Time) Report {
+	r, exists := t.records[key]
+	if !exists {
+		return Report{IP: key, Score: 100}
+	}
+
+	// Decay a copy so reads do not rewrite the stored timestamps
+	c := *r
+	t.decay(&c, now)
+	return Report{
+		IP:         key,
+		Score:      int(math.Round(100 - t.penalty(&c))),
+		Tracked:    true,
+		Risk:       c.Risk,
+		Botnet:     c.Botnet / 100,
+		ErrorRatio: errorRatio(&c),
+		Responses:  int(c.Responses),
+		Feeds:      append([]string(nil), c.Feeds...),
+		Marks:      c.Marks,
+		Updated:    r.Updated,
+	}
+}
+
+// Worst returns up to n tracked IPs with the lowest scores. IPv6 networks
+// are listed alongside addresses, each with its own score.
+func (t *Tracker) Worst(n int) []Report {
+	now := time.Now()
+
+	t.mu.Lock()
+	reports := make([]Report, 0, len(t.records))
+	for key := range t.records {
+		reports = append(reports, t.reportLocked(key, now))
+	}
+	t.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Score != reports[j].Score {
+			return reports[i].Score < reports[j].Score
+		}
+		return reports[i].IP < reports[j].IP
+	})
+	if n > 0 && len(reports) > n {
+		reports = reports[:n]
+	}
+	return reports
+}
+
+// Cleanup forgets IPs whose signals have decayed away and returns how many
+// were dropped
+func (t *Tracker) Cleanup(ctx context.Context) int {
+	now := time.Now()
+
+	t.mu.Lock()
+	var dropped []string
+	for ip, r := range t.records {
+		t.decay(r, now)
+		if t.penalty(r) < recovered {
+			delete(t.records, ip)
+			delete(t.dirty, ip)
+			dropped = append(dropped, ip)
+		}
+	}
+	t.mu.Unlock()
+
+	if t.client != nil && len(dropped) > 0 {
+		t.client.HDel(ctx, redisKey, dropped...)
+	}
+	return len(dropped)
+}
+
+// Flush writes changed records to Redis
+func (t *Tracker) Flush(ctx context.Context) error {
+	if t.client == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	values := make(map[string]interface{}, len(t.dirty))
+	for ip := range t.dirty {
+		if r, exists := t.records[ip]; exists {
+			data, err := json.Marshal(r)
+			if err != nil {
+				continue
+			}
+			values[ip] = data
+		}
+	}
+	t.dirty = make(map[string]bool)
+	t.mu.Unlock()
+
+	if len(values) == 0 {
+		return nil
+	}
+	return t.client.HSet(ctx, redisKey, values).Err()
+}
+
+// Load restores persisted records from Redis, keeping any newer local state
+func (t *Tracker) Load(ctx context.Context) error {
+	if t.client == nil {
+		return nil
+	}
+
+	values, err := t.client.HGetAll(ctx, redisKey).Result()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ip, value := range values {
+		var r record
+		if err := json.Unmarshal([]byte(value), &r); err != nil {
+			continue
+		}
+		if local, exists := t.records[ip]; exists && local.Updated.After(r.Updated) {
+			continue
+		}
+		t.records[ip] = &r
+	}
+	return nil
+}
+
+// evictLocked drops the tracked IP with the smallest penalty
+func (t *Tracker) evictLocked(now time.Time) {
+	var best string
+	lowest := math.Inf(1)
+	for ip, r := range t.records {
+		c := *r
+		t.decay(&c, now)
+		if p := t.penalty(&c); p < lowest {
+			best, lowest = ip, p
+		}
+	}
+	delete(t.records, best)
+	delete(t.dirty, best)
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(100, v))
+}
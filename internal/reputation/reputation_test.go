@@ -0,0 +1,120 @@
+package reputation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnknownIPIsClean(t *testing.T) {
+	tracker := NewTracker(nil, time.Hour, Weights{})
+
+	report := tracker.Get("203.0.113.7")
+	if report.Score != 100 || report.Tracked {
+		t.Errorf("expected untracked score 100, got %+v", report)
+	}
+}
+
+func TestSignalsLowerScore(t *testing.T) {
+	tracker := NewTracker(nil, time.Hour, Weights{})
+	ip := "203.0.113.7"
+
+	tracker.RecordBotnet(ip, 0.5)
+	afterBot := tracker.Score(ip)
+	if afterBot != 70 {
+		t.Errorf("expected score 70 after botnet signal, got %d", afterBot)
+	}
+
+	tracker.RecordFeed(ip, "zen.spamhaus.org")
+	tracker.RecordFeed(ip, "zen.spamhaus.org")
+	if score := tracker.Score(ip); score != 20 {
+		t.Errorf("expected score 20 after feed listing, got %d", score)
+	}
+	if feeds := tracker.Get(ip).Feeds; len(feeds) != 1 {
+		t.Errorf("expected feed to be recorded once, got %v", feeds)
+	}
+}
+
+func TestErrorRatioNeedsSamples(t *testing.T) {
+	tracker := NewTracker(nil, time.Hour, Weights{})
+	ip := "203.0.113.7"
+
+	for i := 0; i < minResponses-1; i++ {
+		tracker.RecordResponse(ip, 404)
+	}
+	if score := tracker.Score(ip); score != 100 {
+		t.Errorf("expected error ratio to be ignored below %d responses, got %d", minResponses, score)
+	}
+
+	tracker.RecordResponse(ip, 404)
+	if score := tracker.Score(ip); score != 60 {
+		t.Errorf("expected score 60 with only errors, got %d", score)
+	}
+}
+
+func TestDecayAndCleanup(t *testing.T) {
+	tracker := NewTracker(nil, time.Minute, Weights{})
+	ip := "203.0.113.7"
+
+	tracker.RecordBotnet(ip, 1)
+	tracker.mu.Lock()
+	tracker.records[ip].Updated = time.Now().Add(-time.Minute)
+	tracker.mu.Unlock()
+
+	if score := tracker.Score(ip); score != 70 {
+		t.Errorf("expected botnet penalty to halve after one half-life, got score %d", score)
+	}
+
+	tracker.mu.Lock()
+	tracker.records[ip].Updated = time.Now().Add(-time.Hour)
+	tracker.mu.Unlock()
+
+	if dropped := tracker.Cleanup(context.Background()); dropped != 1 {
+		t.Errorf("expected recovered IP to be dropped, dropped %d", dropped)
+	}
+	if tracker.Get(ip).Tracked {
+		t.Error("expected IP to be untracked after cleanup")
+	}
+}
+
+func TestIPv6NetworkSharesReputation(t *testing.T) {
+	tracker := NewTracker(nil, time.Hour, Weights{})
+	tracker.SetIPv6Prefix(64)
+
+	tracker.RecordBotnet("2001:db8:1:2::a", 0.5)
+
+	// A new privacy address in the same /64 inherits the network's score
+	// but keeps its own clean record
+	report := tracker.Get("2001:db8:1:2::b")
+	if report.Score != 70 || report.Tracked {
+		t.Errorf("rotated address: got %+v, want score 70 and untracked", report)
+	}
+	if report.Network == nil || report.Network.IP != "2001:db8:1:2::/64" || report.Network.Score != 70 {
+		t.Errorf("unexpected network report: %+v", report.Network)
+	}
+
+	if score := tracker.Score("2001:db8:1:3::a"); score != 100 {
+		t.Errorf("address in another /64: got %d, want 100", score)
+	}
+	if report := tracker.Get("203.0.113.7"); report.Network != nil {
+		t.Errorf("IPv4 address grouped: %+v", report.Network)
+	}
+}
+
+func TestMarkLastsUntilExpiry(t *testing.T) {
+	tracker := NewTracker(nil, time.Millisecond, Weights{})
+	ip := "203.0.113.9"
+
+	tracker.RecordMark(ip, "open_proxy", 40, time.Now().Add(time.Hour))
+	time.Sleep(10 * time.Millisecond)
+
+	// Unlike other signals, a mark does not decay
+	if tracker.Cleanup(context.Background()) != 0 || tracker.Score(ip) != 60 {
+		t.Errorf("marked IP: got score %d, want 60", tracker.Score(ip))
+	}
+
+	tracker.RecordMark(ip, "open_proxy", 40, time.Now())
+	if report := tracker.Get(ip); report.Score != 100 || len(report.Marks) != 0 {
+		t.Errorf("expired mark still applies: %+v", report)
+	}
+}
@@ -0,0 +1,325 @@
+// Package rulebundle fetches bundles of filter rules published on an update
+// channel and verifies they were signed by a trusted key. A bundle is JSON
+// wrapped in an envelope carrying its Ed25519 signature, so a mirror can
+// serve it from plain HTTP or a file without being able to alter it.
+package rulebundle
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Format identifies a signed bundle envelope
+const Format = "ddos-rule-bundle"
+
+// maxBundleSize bounds what is read from the channel
+const maxBundleSize = 16 << 20
+
+// ErrNotNewer is returned for a bundle whose serial is below the last one
+// accepted, which would roll rules back
+var ErrNotNewer = errors.New("bundle is not newer than the current one")
+
+// Rule is a filter rule in a bundle
+type Rule struct {
+	ID          string `json:"id"`
+	Pattern     string `json:"pattern"`
+	Description string `json:"description,omitempty"`
+}
+
+// Bundle is a release of rules. Serial increases with every release and
+// guards against rollbacks; Version is for people.
+type Bundle struct {
+	Version   string    `json:"version"`
+	Serial    int64     `json:"serial"`
+	Published time.Time `json:"published"`
+	Rules     []Rule    `json:"rules"`
+}
+
+// Signed is the envelope a bundle is published in. Payload is the bundle
+// JSON in base64, signed as is.
+type Signed struct {
+	Format    string `json:"format"`
+	Payload   string `json:"payload"`
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// KeyID is a short fingerprint of a public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParsePublicKey parses a base64 Ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %q", s)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// Sign wraps a bundle in a signed envelope
+func Sign(bundle Bundle, key ed25519.PrivateKey) (Signed, error) {
+	payload, err := json.Marshal(bundle)
+	if err != nil {
+		return Signed{}, err
+	}
+	return Signed{
+		Format:    Format,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		KeyID:     KeyID(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}, nil
+}
+
+// Verify checks the envelope was signed by one of keys and returns the
+// bundle inside
+func Verify(signed Signed, keys []ed25519.PublicKey) (Bundle, error) {
+	if signed.Format != Format {
+		return Bundle{}, fmt.Errorf("not a rule bundle")
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("invalid bundle payload")
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("invalid bundle signature")
+	}
+
+	var key ed25519.PublicKey
+	for _, k := range keys {
+		if KeyID(k) == signed.KeyID {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return Bundle{}, fmt.Errorf("bundle signed with an untrusted key (%s)", signed.KeyID)
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return Bundle{}, fmt.Errorf("invalid bundle signature")
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(payload, &bundle); err != nil {
+		return Bundle{}, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if bundle.Serial <= 0 {
+		return Bundle{}, fmt.Errorf("invalid bundle: serial must be positive")
+	}
+	seen := make(map[string]bool, len(bundle.Rules))
+	for _, rule := range bundle.Rules {
+		if rule.ID == "" || rule.Pattern == "" {
+			return Bundle{}, fmt.Errorf("invalid bundle: rule without an id or pattern")
+		}
+		if seen[rule.ID] {
+			return Bundle{}, fmt.Errorf("invalid bundle: duplicate rule %s", rule.ID)
+		}
+		seen[rule.ID] = true
+	}
+	return bundle, nil
+}
+
+// Store keeps the highest serial accepted, so rollbacks are refused across
+// restarts as well
+type Store interface {
+	Load(ctx context.Context) (int64, error)
+	Save(ctx context.Context, serial int64) error
+}
+
+// FileStore keeps the serial in a file
+type FileStore string
+
+// Load returns the stored serial, or 0 if none was stored yet
+func (path FileStore) Load(ctx context.Context) (int64, error) {
+	data, err := os.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	serial, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bundle serial in %s", path)
+	}
+	return serial, nil
+}
+
+// Save writes the serial, replacing the file so it is never left half written
+func (path FileStore) Save(ctx context.Context, serial int64) error {
+	if dir := filepath.Dir(string(path)); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := string(path) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(serial, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(path))
+}
+
+// RedisStore keeps the serial in Redis, shared by every instance
+type RedisStore struct {
+	Client *redis.Client
+	Key    string
+}
+
+// Load returns the stored serial, or 0 if none was stored yet
+func (s RedisStore) Load(ctx context.Context) (int64, error) {
+	serial, err := s.Client.Get(ctx, s.Key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return serial, err
+}
+
+// Save stores the serial unless a higher one was stored meanwhile
+func (s RedisStore) Save(ctx context.Context, serial int64) error {
+	return raiseSerial.Run(ctx, s.Client, []string{s.Key}, serial).Err()
+}
+
+// raiseSerial sets the key to the new serial only if it is higher
+var raiseSerial = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tonumber(ARGV[1]) > current then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// Fetcher downloads signed bundles from an update channel, an http(s) URL
+// or a file:// path for mirrors on disk. floor is the highest serial ever
+// accepted, loaded from the store before the first fetch; serial is the
+// one this fetcher last returned.
+type Fetcher struct {
+	url    string
+	keys   []ed25519.PublicKey
+	client *http.Client
+	store  Store
+	loaded bool
+	etag   string
+	serial int64
+	floor  int64
+	mu     sync.Mutex
+}
+
+// NewFetcher creates a fetcher trusting bundles signed by any of keys. With
+// a nil store the serial guarding against rollbacks is lost on restart.
+func NewFetcher(url string, keys []ed25519.PublicKey, timeout time.Duration, store Store) *Fetcher {
+	return &Fetcher{
+		url:    url,
+		keys:   keys,
+		client: &http.Client{Timeout: timeout},
+		store:  store,
+	}
+}
+
+// Fetch downloads and verifies the bundle on the channel. It returns nil
+// when the channel still serves the last bundle returned, and ErrNotNewer
+// when it serves one older than any accepted before. After a restart the
+// bundle in force is returned again so its rules can be reapplied.
+func (f *Fetcher) Fetch(ctx context.Context) (*Bundle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.loaded && f.store != nil {
+		floor, err := f.store.Load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load bundle serial: %v", err)
+		}
+		f.floor = floor
+	}
+	f.loaded = true
+
+	data, etag, err := f.download(ctx)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var signed Signed
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("invalid bundle envelope: %v", err)
+	}
+	bundle, err := Verify(signed, f.keys)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Serial == f.serial {
+		f.etag = etag
+		return nil, nil
+	}
+	if bundle.Serial < f.serial || bundle.Serial < f.floor {
+		return nil, ErrNotNewer
+	}
+
+	if bundle.Serial > f.floor && f.store != nil {
+		if err := f.store.Save(ctx, bundle.Serial); err != nil {
+			return nil, fmt.Errorf("failed to save bundle serial: %v", err)
+		}
+	}
+	f.etag = etag
+	f.serial = bundle.Serial
+	if bundle.Serial > f.floor {
+		f.floor = bundle.Serial
+	}
+	return &bundle, nil
+}
+
+// download returns the channel's content and ETag, or nil content if it is
+// unchanged
+func (f *Fetcher) download(ctx context.Context) ([]byte, string, error) {
+	if path := strings.TrimPrefix(f.url, "file://"); path != f.url {
+		data, err := os.ReadFile(path)
+		return data, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("rule channel returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxBundleSize {
+		return nil, "", fmt.Errorf("bundle larger than %d bytes", maxBundleSize)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
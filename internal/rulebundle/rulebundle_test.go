@@ -0,0 +1,105 @@
+package rulebundle
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyRejectsTamperingAndUntrustedKeys(t *testing.T) {
+	key := newKey(t)
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	bundle := Bundle{Version: "1.0", Serial: 1, Rules: []Rule{{ID: "r1", Pattern: "evil"}}}
+
+	signed, err := Sign(bundle, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Verify(signed, trusted)
+	if err != nil || got.Version != "1.0" || len(got.Rules) != 1 {
+		t.Fatalf("Verify = %+v, %v", got, err)
+	}
+
+	other, _ := Sign(Bundle{Version: "1.1", Serial: 2}, key)
+	tampered := signed
+	tampered.Payload = other.Payload
+	if _, err := Verify(tampered, trusted); err == nil {
+		t.Error("accepted a payload the signature does not cover")
+	}
+
+	if _, err := Verify(signed, []ed25519.PublicKey{newKey(t).Public().(ed25519.PublicKey)}); err == nil {
+		t.Error("accepted a bundle signed with an untrusted key")
+	}
+}
+
+func TestFetchRefusesRollback(t *testing.T) {
+	key := newKey(t)
+	serving := Bundle{Version: "2", Serial: 2}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed, _ := Sign(serving, key)
+		json.NewEncoder(w).Encode(signed)
+	}))
+	defer server.Close()
+
+	f := NewFetcher(server.URL, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}, 0, nil)
+	ctx := context.Background()
+
+	if bundle, err := f.Fetch(ctx); err != nil || bundle == nil || bundle.Version != "2" {
+		t.Fatalf("Fetch = %+v, %v", bundle, err)
+	}
+	if bundle, err := f.Fetch(ctx); err != nil || bundle != nil {
+		t.Errorf("unchanged bundle returned again: %+v, %v", bundle, err)
+	}
+
+	serving = Bundle{Version: "1", Serial: 1}
+	if _, err := f.Fetch(ctx); err != ErrNotNewer {
+		t.Errorf("Fetch of an older bundle = %v, want ErrNotNewer", err)
+	}
+
+	serving = Bundle{Version: "3", Serial: 3}
+	if bundle, err := f.Fetch(ctx); err != nil || bundle == nil || bundle.Version != "3" {
+		t.Errorf("Fetch = %+v, %v", bundle, err)
+	}
+}
+
+func TestFetchRefusesRollbackAfterRestart(t *testing.T) {
+	key := newKey(t)
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	serving := Bundle{Version: "3", Serial: 3}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed, _ := Sign(serving, key)
+		json.NewEncoder(w).Encode(signed)
+	}))
+	defer server.Close()
+
+	store := FileStore(filepath.Join(t.TempDir(), "state", "serial"))
+	ctx := context.Background()
+	if bundle, err := NewFetcher(server.URL, trusted, 0, store).Fetch(ctx); err != nil || bundle == nil {
+		t.Fatalf("Fetch = %+v, %v", bundle, err)
+	}
+
+	// A restarted node reapplies the bundle in force
+	if bundle, err := NewFetcher(server.URL, trusted, 0, store).Fetch(ctx); err != nil || bundle == nil || bundle.Serial != 3 {
+		t.Fatalf("Fetch after restart = %+v, %v", bundle, err)
+	}
+
+	// but still refuses an older one
+	serving = Bundle{Version: "2", Serial: 2}
+	if _, err := NewFetcher(server.URL, trusted, 0, store).Fetch(ctx); err != ErrNotNewer {
+		t.Errorf("Fetch of an older bundle after restart = %v, want ErrNotNewer", err)
+	}
+}
@@ -0,0 +1,175 @@
+package signals
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed boolean expression over signal names
+type Expr interface {
+	Eval(signals map[string]bool) bool
+}
+
+type signalExpr string
+
+func (e signalExpr) Eval(signals map[string]bool) bool { return signals[string(e)] }
+
+type notExpr struct{ x Expr }
+
+func (e notExpr) Eval(signals map[string]bool) bool { return !e.x.Eval(signals) }
+
+type andExpr []Expr
+
+func (e andExpr) Eval(signals map[string]bool) bool {
+	for _, x := range e {
+		if !x.Eval(signals) {
+			return false
+		}
+	}
+	return true
+}
+
+type orExpr []Expr
+
+func (e orExpr) Eval(signals map[string]bool) bool {
+	for _, x := range e {
+		if x.Eval(signals) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses an expression of signal names combined with AND, OR, NOT
+// and parentheses, such as "datacenter_asn AND content_negotiation AND
+// NOT country:US". AND binds tighter than OR; keywords are case-insensitive.
+func Parse(expression string) (Expr, error) {
+	p := &parser{tokens: tokenize(expression)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	word := strings.Builder{}
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek(keyword string) bool {
+	return p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword)
+}
+
+func (p *parser) or() (Expr, error) {
+	terms := orExpr{}
+	for {
+		term, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if !p.peek("OR") {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	factors := andExpr{}
+	for {
+		factor, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, factor)
+		if !p.peek("AND") {
+			break
+		}
+		p.pos++
+	}
+	if len(factors) == 1 {
+		return factors[0], nil
+	}
+	return factors, nil
+}
+
+func (p *parser) not() (Expr, error) {
+	if p.peek("NOT") {
+		p.pos++
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch {
+	case token == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing ) in expression")
+		}
+		p.pos++
+		return e, nil
+	case token == ")" || p.isKeyword(token):
+		return nil, fmt.Errorf("unexpected %q in expression", token)
+	}
+	return signalExpr(token), nil
+}
+
+func (p *parser) isKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "AND", "OR", "NOT":
+		return true
+	}
+	return false
+}
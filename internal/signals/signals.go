@@ -0,0 +1,166 @@
+// Package signals evaluates operator-defined composite signals: named
+// conditions over the signals raised for a request (botnet indicators and
+// request facts such as a datacenter ASN), each adding risk when it holds.
+package signals
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var matchesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_composite_signal_matches_total",
+	Help: "Requests matching each composite signal",
+}, []string{"signal"})
+
+// Composite is a signal defined by the operator. It holds either when
+// Expression is true, or when the weights of the signals present add up
+// to Threshold. Risk is added to the request's risk score when it holds.
+type Composite struct {
+	Name       string             `json:"name"`
+	Expression string             `json:"expression,omitempty"`
+	Weights    map[string]float64 `json:"weights,omitempty"`
+	Threshold  float64            `json:"threshold,omitempty"`
+	Risk       int                `json:"risk"`
+}
+
+// compiled is a composite with its expression parsed
+type compiled struct {
+	Composite
+	expr Expr
+}
+
+func compile(c Composite) (compiled, error) {
+	if c.Name == "" {
+		return compiled{}, fmt.Errorf("composite signal needs a name")
+	}
+	if (c.Expression == "") == (len(c.Weights) == 0) {
+		return compiled{}, fmt.Errorf("composite signal %s needs either an expression or weights", c.Name)
+	}
+	if len(c.Weights) > 0 && c.Threshold <= 0 {
+		return compiled{}, fmt.Errorf("composite signal %s needs a positive threshold for its weights", c.Name)
+	}
+	if c.Risk == 0 {
+		return compiled{}, fmt.Errorf("composite signal %s adds no risk", c.Name)
+	}
+
+	cc := compiled{Composite: c}
+	if c.Expression != "" {
+		expr, err := Parse(c.Expression)
+		if err != nil {
+			return compiled{}, fmt.Errorf("composite signal %s: %v", c.Name, err)
+		}
+		cc.expr = expr
+	}
+	return cc, nil
+}
+
+func (c compiled) holds(signals map[string]bool) bool {
+	if c.expr != nil {
+		return c.expr.Eval(signals)
+	}
+	total := 0.0
+	for name, weight := range c.Weights {
+		if signals[name] {
+			total += weight
+		}
+	}
+	return total >= c.Threshold
+}
+
+// Engine holds the composite signals and evaluates them. Signals can be
+// changed while requests are evaluated.
+type Engine struct {
+	composites atomic.Value // []compiled sorted by name, replaced on change
+	mu         sync.Mutex
+}
+
+// NewEngine creates an engine without composite signals
+func NewEngine() *Engine {
+	e := &Engine{}
+	e.composites.Store([]compiled{})
+	return e
+}
+
+func (e *Engine) list() []compiled {
+	return e.composites.Load().([]compiled)
+}
+
+// Set adds a composite signal or replaces the one of the same name
+func (e *Engine) Set(c Composite) error {
+	cc, err := compile(c)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := e.list()
+	updated := make([]compiled, 0, len(current)+1)
+	for _, existing := range current {
+		if existing.Name != c.Name {
+			updated = append(updated, existing)
+		}
+	}
+	updated = append(updated, cc)
+	sort.Slice(updated, func(i, j int) bool { return updated[i].Name < updated[j].Name })
+	e.composites.Store(updated)
+	return nil
+}
+
+// Remove deletes a composite signal
+func (e *Engine) Remove(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := e.list()
+	updated := make([]compiled, 0, len(current))
+	for _, existing := range current {
+		if existing.Name != name {
+			updated = append(updated, existing)
+		}
+	}
+	if len(updated) == len(current) {
+		return fmt.Errorf("composite signal not found: %s", name)
+	}
+	e.composites.Store(updated)
+	return nil
+}
+
+// Get returns a composite signal
+func (e *Engine) Get(name string) (Composite, bool) {
+	for _, c := range e.list() {
+		if c.Name == name {
+			return c.Composite, true
+		}
+	}
+	return Composite{}, false
+}
+
+// List returns the composite signals by name
+func (e *Engine) List() []Composite {
+	current := e.list()
+	composites := make([]Composite, len(current))
+	for i, c := range current {
+		composites[i] = c.Composite
+	}
+	return composites
+}
+
+// Evaluate returns the composite signals holding for the signals raised
+func (e *Engine) Evaluate(signals map[string]bool) []Composite {
+	var matched []Composite
+	for _, c := range e.list() {
+		if c.holds(signals) {
+			matchesCounter.WithLabelValues(c.Name).Inc()
+			matched = append(matched, c.Composite)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,58 @@
+package signals
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	raised := map[string]bool{"datacenter_asn": true, "new_client": true, "country:US": true}
+	tests := []struct {
+		expression string
+		holds      bool
+	}{
+		{"datacenter_asn AND new_client", true},
+		{"datacenter_asn and content_negotiation", false},
+		{"content_negotiation OR datacenter_asn AND new_client", true},
+		{"(content_negotiation OR datacenter_asn) AND NOT country:US", false},
+		{"NOT NOT new_client", true},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expression, err)
+		}
+		if got := expr.Eval(raised); got != tt.holds {
+			t.Errorf("%s = %v, want %v", tt.expression, got, tt.holds)
+		}
+	}
+
+	for _, bad := range []string{"", "a AND", "(a OR b", "a b", "AND a", "a )"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	e := NewEngine()
+	if err := e.Set(Composite{Name: "cloud_scraper", Expression: "datacenter_asn AND content_negotiation AND new_client", Risk: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Set(Composite{Name: "scripted", Weights: map[string]float64{"no_javascript": 0.5, "no_css": 0.3, "regular_intervals": 0.4}, Threshold: 0.8, Risk: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Set(Composite{Name: "empty", Risk: 10}); err == nil {
+		t.Error("accepted a composite without a condition")
+	}
+
+	matched := e.Evaluate(map[string]bool{"datacenter_asn": true, "content_negotiation": true, "new_client": true, "no_javascript": true, "no_css": true})
+	if len(matched) != 2 || matched[0].Name != "cloud_scraper" || matched[1].Name != "scripted" {
+		t.Errorf("matched %+v", matched)
+	}
+	if matched := e.Evaluate(map[string]bool{"no_javascript": true}); len(matched) != 0 {
+		t.Errorf("matched %+v below threshold", matched)
+	}
+
+	e.Remove("cloud_scraper")
+	if len(e.List()) != 1 {
+		t.Errorf("remaining %+v", e.List())
+	}
+}
@@ -0,0 +1,300 @@
+package sla
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// bucketSize is the resolution of the burn rate windows
+const bucketSize = time.Minute
+
+var burnRateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ddos_protection_slo_burn_rate",
+	Help: "Latency SLO error budget burn rate by objective and window",
+}, []string{"slo", "window"})
+
+// Objective is a latency SLO for a group of paths: Target of the requests
+// must complete within Threshold. Higher priority objectives are protected
+// at the expense of lower priority ones when shedding load.
+type Objective struct {
+	Name      string
+	Paths     []string
+	Threshold time.Duration
+	Target    float64
+	Priority  int
+}
+
+// NewObjective validates and creates an objective. Paths are prefixes; an
+// objective without paths covers every path.
+func NewObjective(name string, paths []string, threshold time.Duration, target float64, priority int) (*Objective, error) {
+	if name == "" {
+		return nil, fmt.Errorf("SLO name is required")
+	}
+	if threshold <= 0 {
+		return nil, fmt.Errorf("SLO %s: latency threshold must be positive", name)
+	}
+	if target <= 0 || target >= 1 {
+		return nil, fmt.Errorf("SLO %s: target must be between 0 and 1, got %v", name, target)
+	}
+	return &Objective{Name: name, Paths: paths, Threshold: threshold, Target: target, Priority: priority}, nil
+}
+
+// match returns the length of the longest path prefix of o matching path,
+// or -1 if none does
+func (o *Objective) match(path string) int {
+	if len(o.Paths) == 0 {
+		return 0
+	}
+	best := -1
+	for _, prefix := range o.Paths {
+		if strings.HasPrefix(path, prefix) && len(prefix) > best {
+			best = len(prefix)
+		}
+	}
+	return best
+}
+
+// Config controls how burn rates are evaluated
+type Config struct {
+	ShortWindow   time.Duration
+	LongWindow    time.Duration
+	BurnThreshold float64
+	MinRequests   int
+	Interval      time.Duration
+}
+
+// Status describes the current state of an objective
+type Status struct {
+	Name          string    `json:"name"`
+	Paths         []string  `json:"paths"`
+	ThresholdMs   int64     `json:"threshold_ms"`
+	Target        float64   `json:"target"`
+	Priority      int       `json:"priority"`
+	Requests      int64     `json:"requests"`
+	Slow          int64     `json:"slow"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	Threatened    bool      `json:"threatened"`
+	Since         time.Time `json:"since,omitempty"`
+}
+
+type bucket struct {
+	start time.Time
+	total int64
+	slow  int64
+}
+
+type series struct {
+	objective  *Objective
+	buckets    []bucket
+	threatened bool
+	since      time.Time
+}
+
+// Monitor tracks response latency against objectives and reports when an
+// objective's error budget is burning fast enough to be threatened
+type Monitor struct {
+	cfg      Config
+	series   []*series
+	onChange func(Status)
+	mu       sync.Mutex
+}
+
+// NewMonitor creates a monitor for the given objectives. onChange is called
+// whenever an objective becomes threatened or recovers.
+func NewMonitor(objectives []*Objective, cfg Config, onChange func(Status)) *Monitor {
+	if cfg.ShortWindow <= 0 {
+		cfg.ShortWindow = 5 * time.Minute
+	}
+	if cfg.LongWindow < cfg.ShortWindow {
+		cfg.LongWindow = 12 * cfg.ShortWindow
+	}
+	if cfg.BurnThreshold <= 0 {
+		cfg.BurnThreshold = 2
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	m := &Monitor{cfg: cfg, onChange: onChange}
+	n := int(cfg.LongWindow / bucketSize)
+	if n < 1 {
+		n = 1
+	}
+	for _, o := range objectives {
+		m.series = append(m.series, &series{objective: o, buckets: make([]bucket, n)})
+	}
+	return m
+}
+
+// Objective returns the objective covering path, preferring the longest
+// matching prefix, or nil if none does
+func (m *Monitor) Objective(path string) *Objective {
+	s := m.lookup(path)
+	if s == nil {
+		return nil
+	}
+	return s.objective
+}
+
+func (m *Monitor) lookup(path string) *series {
+	var result *series
+	best := -1
+	for _, s := range m.series {
+		if n := s.objective.match(path); n > best {
+			result, best = s, n
+		}
+	}
+	return result
+}
+
+// Observe records the latency of a response to path
+func (m *Monitor) Observe(path string, latency time.Duration) {
+	m.observeAt(path, latency, time.Now())
+}
+
+func (m *Monitor) observeAt(path string, latency time.Duration, now time.Time) {
+	s := m.lookup(path)
+	if s == nil {
+		return
+	}
+
+	start := now.Truncate(bucketSize)
+	idx := int(start.Unix()/int64(bucketSize/time.Second)) % len(s.buckets)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := &s.buckets[idx]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.total++
+	if latency > s.objective.Threshold {
+		b.slow++
+	}
+}
+
+// burnRate returns how fast s is spending its error budget over window,
+// where 1 means exactly on budget
+func (s *series) burnRate(window time.Duration, now time.Time) (float64, int64, int64) {
+	var total, slow int64
+	cutoff := now.Add(-window)
+	for _, b := range s.buckets {
+		if b.total == 0 || !b.start.Add(bucketSize).After(cutoff) {
+			continue
+		}
+		total += b.total
+		slow += b.slow
+	}
+	if total == 0 {
+		return 0, 0, 0
+	}
+	return float64(slow) / float64(total) / (1 - s.objective.Target), total, slow
+}
+
+// Run re-evaluates the objectives every interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evaluate(time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluate updates each objective's threatened state. An objective is
+// threatened once both windows burn faster than the threshold, and
+// recovers as soon as the short window falls below it.
+func (m *Monitor) evaluate(now time.Time) {
+	var changed []Status
+
+	m.mu.Lock()
+	for _, s := range m.series {
+		short, shortTotal, _ := s.burnRate(m.cfg.ShortWindow, now)
+		long, _, _ := s.burnRate(m.cfg.LongWindow, now)
+		burnRateGauge.WithLabelValues(s.objective.Name, "short").Set(short)
+		burnRateGauge.WithLabelValues(s.objective.Name, "long").Set(long)
+
+		threatened := shortTotal >= int64(m.cfg.MinRequests) &&
+			short >= m.cfg.BurnThreshold && long >= m.cfg.BurnThreshold
+		if s.threatened && short >= m.cfg.BurnThreshold {
+			threatened = true
+		}
+
+		if threatened != s.threatened {
+			s.threatened = threatened
+			s.since = now
+			changed = append(changed, m.statusLocked(s, now))
+		}
+	}
+	m.mu.Unlock()
+
+	if m.onChange != nil {
+		for _, status := range changed {
+			m.onChange(status)
+		}
+	}
+}
+
+func (m *Monitor) statusLocked(s *series, now time.Time) Status {
+	short, _, _ := s.burnRate(m.cfg.ShortWindow, now)
+	long, total, slow := s.burnRate(m.cfg.LongWindow, now)
+	return Status{
+		Name:          s.objective.Name,
+		Paths:         s.objective.Paths,
+		ThresholdMs:   s.objective.Threshold.Milliseconds(),
+		Target:        s.objective.Target,
+		Priority:      s.objective.Priority,
+		Requests:      total,
+		Slow:          slow,
+		ShortBurnRate: short,
+		LongBurnRate:  long,
+		Threatened:    s.threatened,
+		Since:         s.since,
+	}
+}
+
+// Status returns the state of every objective
+func (m *Monitor) Status() []Status {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]Status, 0, len(m.series))
+	for _, s := range m.series {
+		result = append(result, m.statusLocked(s, now))
+	}
+	return result
+}
+
+// Threatened reports whether any objective is threatened, and the highest
+// priority among the threatened ones
+func (m *Monitor) Threatened() (bool, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	threatened, priority := false, 0
+	for _, s := range m.series {
+		if !s.threatened {
+			continue
+		}
+		if !threatened || s.objective.Priority > priority {
+			priority = s.objective.Priority
+		}
+		threatened = true
+	}
+	return threatened, priority
+}
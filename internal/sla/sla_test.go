@@ -0,0 +1,93 @@
+package sla
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMonitor(t *testing.T, onChange func(Status)) *Monitor {
+	checkout, err := NewObjective("checkout", []string{"/api/checkout"}, 300*time.Millisecond, 0.99, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := NewObjective("api", []string{"/api/"}, time.Second, 0.95, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMonitor([]*Objective{checkout, api}, Config{
+		ShortWindow:   5 * time.Minute,
+		LongWindow:    time.Hour,
+		BurnThreshold: 2,
+		MinRequests:   10,
+	}, onChange)
+}
+
+func TestObjectiveLongestPrefix(t *testing.T) {
+	m := newTestMonitor(t, nil)
+
+	if o := m.Objective("/api/checkout/pay"); o == nil || o.Name != "checkout" {
+		t.Errorf("expected checkout objective, got %+v", o)
+	}
+	if o := m.Objective("/api/products"); o == nil || o.Name != "api" {
+		t.Errorf("expected api objective, got %+v", o)
+	}
+	if o := m.Objective("/static/app.js"); o != nil {
+		t.Errorf("expected no objective, got %s", o.Name)
+	}
+}
+
+func TestThreatenedAndRecovery(t *testing.T) {
+	var changes []Status
+	m := newTestMonitor(t, func(s Status) { changes = append(changes, s) })
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		latency := 100 * time.Millisecond
+		if i%4 == 0 {
+			latency = time.Second
+		}
+		m.observeAt("/api/checkout", latency, now)
+	}
+	m.evaluate(now)
+
+	threatened, priority := m.Threatened()
+	if !threatened || priority != 10 {
+		t.Fatalf("expected checkout to be threatened at priority 10, got %v %d", threatened, priority)
+	}
+	if len(changes) != 1 || changes[0].Name != "checkout" || !changes[0].Threatened {
+		t.Fatalf("expected one threatened change for checkout, got %+v", changes)
+	}
+
+	// Once the slow requests leave the short window the objective recovers
+	m.evaluate(now.Add(10 * time.Minute))
+	if threatened, _ := m.Threatened(); threatened {
+		t.Error("expected objective to recover after the short window")
+	}
+	if len(changes) != 2 || changes[1].Threatened {
+		t.Errorf("expected a recovery change, got %+v", changes)
+	}
+}
+
+func TestMinRequests(t *testing.T) {
+	m := newTestMonitor(t, nil)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		m.observeAt("/api/checkout", time.Second, now)
+	}
+	m.evaluate(now)
+
+	if threatened, _ := m.Threatened(); threatened {
+		t.Error("expected too few requests not to threaten the objective")
+	}
+}
+
+func TestNewObjectiveValidation(t *testing.T) {
+	if _, err := NewObjective("x", nil, 0, 0.99, 0); err == nil {
+		t.Error("expected error for zero threshold")
+	}
+	if _, err := NewObjective("x", nil, time.Second, 1, 0); err == nil {
+		t.Error("expected error for target of 1")
+	}
+}
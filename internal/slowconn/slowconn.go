@@ -0,0 +1,262 @@
+// Package slowconn finds clients holding connections open with requests
+// they send as slowly as possible, as in Slowloris (headers trickled in a
+// byte at a time) and slow POST (a body uploaded at a few bytes a second).
+// Either ties up a server connection per request at almost no cost to the
+// client. The Tracker follows an http.Server's connections through its
+// ConnState hook and the requests its handler begins, and reports clients
+// holding too many slow requests at once.
+package slowconn
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	slowGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ddos_protection_slow_requests",
+		Help: "Slow requests currently held open, by phase",
+	}, []string{"phase"})
+	closedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_slow_connections_closed_total",
+		Help: "Connections closed because their client held too many slow requests",
+	})
+)
+
+// Phase is the part of a request being sent slowly
+type Phase string
+
+const (
+	PhaseHeaders Phase = "headers"
+	PhaseBody    Phase = "body"
+)
+
+// Config tunes what counts as slow
+type Config struct {
+	// HeaderTimeout is how long a new connection may take to send its
+	// request headers, and how long a body may take before its rate is
+	// judged
+	HeaderTimeout time.Duration
+	// BodyDeadline is the read deadline set on the connection for each
+	// request body; 0 sets none
+	BodyDeadline time.Duration
+	// MinBodyRate is the upload rate in bytes per second below which a body
+	// is slow
+	MinBodyRate int64
+	// MaxSlow is how many slow requests a client may hold at once before it
+	// is reported
+	MaxSlow int
+}
+
+// Client is a client found holding too many slow requests
+type Client struct {
+	IP      string
+	Headers int // connections still sending their request headers
+	Bodies  int // requests still sending their body
+}
+
+// Held returns the slow requests the client holds
+func (c Client) Held() int { return c.Headers + c.Bodies }
+
+// conn is a tracked connection
+type conn struct {
+	net.Conn
+	ip     string
+	state  http.ConnState
+	since  time.Time // when the connection entered state
+	body   *body     // body of the request being handled, if any
+	closed bool      // closed by the tracker
+}
+
+// body counts the bytes read of a request body
+type body struct {
+	io.ReadCloser
+	start time.Time
+	read  int64 // atomic
+	done  int32 // atomic; set at EOF or when the handler returns
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.read, int64(n))
+	if err != nil {
+		atomic.StoreInt32(&b.done, 1)
+	}
+	return n, err
+}
+
+// Tracker follows the connections of an http.Server
+type Tracker struct {
+	cfg Config
+
+	mu    sync.Mutex
+	conns map[string]*conn // by remote address
+}
+
+// NewTracker creates a tracker. Zero fields of cfg get defaults: a 10s
+// header timeout, 512 bytes per second and 5 slow requests.
+func NewTracker(cfg Config) *Tracker {
+	if cfg.HeaderTimeout <= 0 {
+		cfg.HeaderTimeout = 10 * time.Second
+	}
+	if cfg.MinBodyRate <= 0 {
+		cfg.MinBodyRate = 512
+	}
+	if cfg.MaxSlow <= 0 {
+		cfg.MaxSlow = 5
+	}
+	return &Tracker{
+		cfg:   cfg,
+		conns: make(map[string]*conn),
+	}
+}
+
+// ConnState is an http.Server ConnState hook
+func (t *Tracker) ConnState(nc net.Conn, state http.ConnState) {
+	addr := nc.RemoteAddr().String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		ip, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			ip = addr
+		}
+		t.conns[addr] = &conn{Conn: nc, ip: ip, state: state, since: time.Now()}
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, addr)
+	default:
+		if c, ok := t.conns[addr]; ok {
+			c.state = state
+			c.since = time.Now()
+		}
+	}
+}
+
+// Begin notes that the handler started serving r and tracks how fast its
+// body arrives, under a read deadline. The returned function must be
+// called when the handler returns. HTTP/2 requests share their connection
+// with other streams and are not tracked.
+func (t *Tracker) Begin(r *http.Request) (end func()) {
+	if r.Body == nil || r.Body == http.NoBody || r.ProtoMajor != 1 {
+		return func() {}
+	}
+
+	t.mu.Lock()
+	c, ok := t.conns[r.RemoteAddr]
+	if !ok {
+		t.mu.Unlock()
+		return func() {}
+	}
+	b := &body{ReadCloser: r.Body, start: time.Now()}
+	c.body = b
+	t.mu.Unlock()
+
+	// The server clears the deadline itself once the body has been read
+	if t.cfg.BodyDeadline > 0 {
+		c.SetReadDeadline(b.start.Add(t.cfg.BodyDeadline))
+	}
+	r.Body = b
+
+	return func() {
+		atomic.StoreInt32(&b.done, 1)
+		t.mu.Lock()
+		if c.body == b {
+			c.body = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Check counts the slow requests held open and returns the clients holding
+// more than MaxSlow
+func (t *Tracker) Check(now time.Time) []Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	held := make(map[string]*Client)
+	var headers, bodies int
+	for _, c := range t.conns {
+		if c.closed {
+			continue
+		}
+		phase, slow := t.slow(c, now)
+		if !slow {
+			continue
+		}
+		client := held[c.ip]
+		if client == nil {
+			client = &Client{IP: c.ip}
+			held[c.ip] = client
+		}
+		if phase == PhaseHeaders {
+			client.Headers++
+			headers++
+		} else {
+			client.Bodies++
+			bodies++
+		}
+	}
+	slowGauge.WithLabelValues(string(PhaseHeaders)).Set(float64(headers))
+	slowGauge.WithLabelValues(string(PhaseBody)).Set(float64(bodies))
+
+	var clients []Client
+	for _, client := range held {
+		if client.Held() > t.cfg.MaxSlow {
+			clients = append(clients, *client)
+		}
+	}
+	return clients
+}
+
+// slow tells whether c holds a slow request and in which phase. A new
+// connection has not finished sending its first request's headers; the
+// server marks it active only once they are read.
+func (t *Tracker) slow(c *conn, now time.Time) (Phase, bool) {
+	switch c.state {
+	case http.StateNew:
+		return PhaseHeaders, now.Sub(c.since) > t.cfg.HeaderTimeout
+	case http.StateActive:
+		if c.body == nil || atomic.LoadInt32(&c.body.done) == 1 {
+			return "", false
+		}
+		elapsed := now.Sub(c.body.start)
+		if elapsed <= t.cfg.HeaderTimeout {
+			return "", false
+		}
+		rate := float64(atomic.LoadInt64(&c.body.read)) / elapsed.Seconds()
+		return PhaseBody, rate < float64(t.cfg.MinBodyRate)
+	}
+	return "", false
+}
+
+// Close closes the slow connections of ip, returning how many were closed
+func (t *Tracker) Close(ip string, now time.Time) int {
+	t.mu.Lock()
+	var slow []*conn
+	for _, c := range t.conns {
+		if c.ip != ip || c.closed {
+			continue
+		}
+		if _, ok := t.slow(c, now); ok {
+			c.closed = true
+			slow = append(slow, c)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, c := range slow {
+		c.Close()
+	}
+	closedCounter.Add(float64(len(slow)))
+	return len(slow)
+}
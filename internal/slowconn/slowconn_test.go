@@ -0,0 +1,93 @@
+package slowconn
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConn is a connection from a fixed remote address
+type testConn struct {
+	net.Conn
+	addr   string
+	closed bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.addr)
+	return addr
+}
+
+func (c *testConn) SetReadDeadline(time.Time) error { return nil }
+
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSlowHeaders(t *testing.T) {
+	tracker := NewTracker(Config{HeaderTimeout: time.Second, MaxSlow: 2})
+	var conns []*testConn
+	for i, addr := range []string{"192.0.2.1:1001", "192.0.2.1:1002", "192.0.2.1:1003", "198.51.100.1:1001"} {
+		c := &testConn{addr: addr}
+		conns = append(conns, c)
+		tracker.ConnState(c, http.StateNew)
+		if i == 2 {
+			// Its headers arrived in time
+			tracker.ConnState(c, http.StateActive)
+		}
+	}
+
+	now := time.Now()
+	if clients := tracker.Check(now); len(clients) != 0 {
+		t.Fatalf("clients within the header timeout: %+v", clients)
+	}
+
+	tracker.ConnState(conns[3], http.StateNew)
+	conns = append(conns, &testConn{addr: "192.0.2.1:1004"})
+	tracker.ConnState(conns[4], http.StateNew)
+	clients := tracker.Check(now.Add(2 * time.Second))
+	if len(clients) != 1 || clients[0].IP != "192.0.2.1" || clients[0].Headers != 3 {
+		t.Fatalf("clients = %+v, want 192.0.2.1 with 3 slow headers", clients)
+	}
+
+	if n := tracker.Close("192.0.2.1", now.Add(2*time.Second)); n != 3 {
+		t.Errorf("closed %d connections, want 3", n)
+	}
+	if conns[2].closed || conns[3].closed {
+		t.Error("closed a connection that was not slow")
+	}
+	if clients := tracker.Check(now.Add(2 * time.Second)); len(clients) != 0 {
+		t.Errorf("clients after closing: %+v", clients)
+	}
+}
+
+func TestSlowBody(t *testing.T) {
+	tracker := NewTracker(Config{HeaderTimeout: time.Second, MinBodyRate: 100, MaxSlow: 1})
+	var ends []func()
+	for i, addr := range []string{"192.0.2.1:1001", "192.0.2.1:1002"} {
+		c := &testConn{addr: addr}
+		tracker.ConnState(c, http.StateNew)
+		tracker.ConnState(c, http.StateActive)
+
+		r, _ := http.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", 10)))
+		r.RemoteAddr = addr
+		ends = append(ends, tracker.Begin(r))
+		if i == 0 {
+			io.CopyN(io.Discard, r.Body, 5)
+		}
+	}
+
+	clients := tracker.Check(time.Now().Add(2 * time.Second))
+	if len(clients) != 1 || clients[0].Bodies != 2 {
+		t.Fatalf("clients = %+v, want one with 2 slow bodies", clients)
+	}
+
+	ends[0]()
+	if clients := tracker.Check(time.Now().Add(2 * time.Second)); len(clients) != 0 {
+		t.Errorf("clients after a handler returned: %+v", clients)
+	}
+}
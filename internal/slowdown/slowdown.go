@@ -0,0 +1,283 @@
+// Package slowdown delays medium-risk clients instead of blocking them. Each
+// offense within a window doubles the client's delay up to a cap, which cuts
+// the request rate an attacker gets out of each connection while a legitimate
+// user who tripped a heuristic only sees a slower response.
+//
+// Delayed requests wait on a single timer-driven queue rather than one timer
+// each, and the queue is bounded so delays cannot pile up and hold every
+// worker while under attack.
+package slowdown
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	delayHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ddos_protection_slowdown_delay_seconds",
+		Help:    "Artificial delays applied to medium-risk requests",
+		Buckets: []float64{.1, .25, .5, 1, 2, 4, 8, 16, 32},
+	})
+
+	pendingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ddos_protection_slowdown_pending",
+		Help: "Requests currently held in the slowdown queue",
+	})
+
+	overflowCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ddos_protection_slowdown_queue_full_total",
+		Help: "Requests turned away because the slowdown queue was full",
+	})
+)
+
+// ErrQueueFull is returned by Wait when the queue holds its maximum number
+// of delayed requests
+var ErrQueueFull = errors.New("slowdown queue full")
+
+// Config controls how delays escalate and how many requests may wait
+type Config struct {
+	// BaseDelay is the delay of a client's first offense in a window
+	BaseDelay time.Duration
+	// MaxDelay caps the escalated delay
+	MaxDelay time.Duration
+	// Jitter randomizes each delay by up to this fraction either way, so
+	// delayed clients cannot use response timing to synchronize
+	Jitter float64
+	// Window is how long offenses count towards escalation
+	Window time.Duration
+	// MaxPending bounds the requests waiting at once
+	MaxPending int
+}
+
+type offender struct {
+	offenses int
+	last     time.Time
+}
+
+// Throttler escalates delays per client and holds delayed requests
+type Throttler struct {
+	cfg       Config
+	offenders map[string]*offender
+	mu        sync.Mutex
+
+	queue   waitQueue
+	pending int
+	wake    chan struct{}
+	qmu     sync.Mutex
+}
+
+// NewThrottler creates a throttler. Run must be started to release waiting
+// requests.
+func NewThrottler(cfg Config) *Throttler {
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = cfg.BaseDelay
+	}
+	return &Throttler{
+		cfg:       cfg,
+		offenders: make(map[string]*offender),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Delay records an offense by key and returns the delay to apply: the base
+// delay doubled for each earlier offense in the window, capped at the
+// maximum, with jitter
+func (t *Throttler) Delay(key string) time.Duration {
+	now := time.Now()
+
+	t.mu.Lock()
+	o, exists := t.offenders[key]
+	if !exists || now.Sub(o.last) > t.cfg.Window {
+		o = &offender{}
+		t.offenders[key] = o
+	}
+	o.offenses++
+	o.last = now
+	offenses := o.offenses
+	t.mu.Unlock()
+
+	delay := t.cfg.BaseDelay
+	for i := 1; i < offenses && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+
+	if t.cfg.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * t.cfg.Jitter * float64(delay))
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// Offenses returns the offenses recorded for key in the current window
+func (t *Throttler) Offenses(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if o, exists := t.offenders[key]; exists && time.Since(o.last) <= t.cfg.Window {
+		return o.offenses
+	}
+	return 0
+}
+
+// Cleanup forgets clients with no offense in the window
+func (t *Throttler) Cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, o := range t.offenders {
+		if now.Sub(o.last) > t.cfg.Window {
+			delete(t.offenders, key)
+		}
+	}
+}
+
+// Wait holds the caller for d. It returns ErrQueueFull without waiting when
+// the queue is full, and ctx's error if ctx ends first.
+func (t *Throttler) Wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t.qmu.Lock()
+	if t.cfg.MaxPending > 0 && t.pending >= t.cfg.MaxPending {
+		t.qmu.Unlock()
+		overflowCounter.Inc()
+		return ErrQueueFull
+	}
+	w := &waiter{deadline: time.Now().Add(d), done: make(chan struct{})}
+	heap.Push(&t.queue, w)
+	t.pending++
+	pendingGauge.Inc()
+	first := t.queue[0] == w
+	t.qmu.Unlock()
+
+	if first {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+	delayHistogram.Observe(d.Seconds())
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		t.qmu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&t.queue, w.index)
+			t.release()
+		}
+		t.qmu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Pending returns the number of requests waiting
+func (t *Throttler) Pending() int {
+	t.qmu.Lock()
+	defer t.qmu.Unlock()
+
+	return t.pending
+}
+
+// Run releases waiting requests as their delays elapse until ctx is done,
+// then releases everything still waiting
+func (t *Throttler) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		next := t.releaseDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next > 0 {
+			timer.Reset(next)
+		} else {
+			timer.Reset(time.Hour)
+		}
+
+		select {
+		case <-timer.C:
+		case <-t.wake:
+		case <-ctx.Done():
+			t.releaseDue(time.Time{})
+			return
+		}
+	}
+}
+
+// releaseDue wakes waiters whose deadline is not after now, or every waiter
+// for a zero now, and returns how long until the next deadline
+func (t *Throttler) releaseDue(now time.Time) time.Duration {
+	t.qmu.Lock()
+	defer t.qmu.Unlock()
+
+	for len(t.queue) > 0 {
+		w := t.queue[0]
+		if !now.IsZero() && w.deadline.After(now) {
+			return w.deadline.Sub(now)
+		}
+		heap.Pop(&t.queue)
+		t.release()
+		close(w.done)
+	}
+	return 0
+}
+
+// release frees a queue slot; qmu must be held
+func (t *Throttler) release() {
+	t.pending--
+	pendingGauge.Dec()
+}
+
+type waiter struct {
+	deadline time.Time
+	done     chan struct{}
+	index    int
+}
+
+// waitQueue is a min-heap of waiters by deadline
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int           { return len(q) }
+func (q waitQueue) Less(i, j int) bool { return q[i].deadline.Before(q[j].deadline) }
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
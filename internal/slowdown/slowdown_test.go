@@ -0,0 +1,77 @@
+package slowdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayEscalates(t *testing.T) {
+	th := NewThrottler(Config{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+		Window:    time.Minute,
+	})
+
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := th.Delay("192.0.2.1"); got != w*time.Millisecond {
+			t.Errorf("offense %d: delay = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+	if got := th.Delay("192.0.2.2"); got != 100*time.Millisecond {
+		t.Errorf("other client should start at the base delay, got %v", got)
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	th := NewThrottler(Config{BaseDelay: time.Second, MaxDelay: time.Second, Jitter: 0.2, Window: time.Minute})
+	for i := 0; i < 50; i++ {
+		if d := th.Delay("192.0.2.1"); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jittered delay %v outside ±20%%", d)
+		}
+	}
+}
+
+func TestWaitQueue(t *testing.T) {
+	th := NewThrottler(Config{MaxPending: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go th.Run(ctx)
+
+	results := make(chan error, 2)
+	start := time.Now()
+	for _, d := range []time.Duration{60 * time.Millisecond, 30 * time.Millisecond} {
+		d := d
+		go func() { results <- th.Wait(context.Background(), d) }()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for th.Pending() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := th.Wait(context.Background(), time.Second); err != ErrQueueFull {
+		t.Errorf("third waiter: %v, want ErrQueueFull", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("waiters released after %v, before their delay", elapsed)
+	}
+	if th.Pending() != 0 {
+		t.Errorf("pending = %d after release", th.Pending())
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	if err := th.Wait(waitCtx, time.Hour); err != context.DeadlineExceeded {
+		t.Errorf("cancelled wait: %v", err)
+	}
+	if th.Pending() != 0 {
+		t.Errorf("cancelled waiter still holds a slot")
+	}
+}
@@ -0,0 +1,154 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natsSink publishes records to a subject over the NATS text protocol. A
+// batch is written in one go and confirmed by a PING answered with PONG,
+// which the server only sends once it has processed every PUB before it.
+type natsSink struct {
+	addr     string
+	subject  string
+	timeout  time.Duration
+	user     string
+	password string
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newNATSSink(raw, subject string, timeout time.Duration) (*natsSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", raw)
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid NATS subject %q", subject)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	s := &natsSink{addr: addr, subject: subject, timeout: timeout}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	return s, nil
+}
+
+// connect dials the server, reads its INFO and sends CONNECT
+func (s *natsSink) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	reader := bufio.NewReader(conn)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "ddos-protection",
+		"lang":     "go",
+		"version":  "1.0",
+	}
+	switch {
+	case s.user != "" && s.password != "":
+		options["user"], options["pass"] = s.user, s.password
+	case s.user != "":
+		options["auth_token"] = s.user
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.reader = conn, reader
+	return nil
+}
+
+func (s *natsSink) Publish(ctx context.Context, batch []Message) error {
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.publish(batch); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *natsSink) publish(batch []Message) error {
+	s.conn.SetDeadline(time.Now().Add(s.timeout))
+
+	w := bufio.NewWriter(s.conn)
+	for _, msg := range batch {
+		fmt.Fprintf(w, "PUB %s %d\r\n", s.subject, len(msg.Value))
+		w.Write(msg.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS error: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (s *natsSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
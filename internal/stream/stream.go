@@ -0,0 +1,200 @@
+// Package stream exports records as JSON to a Kafka topic, through a Kafka
+// REST proxy, or to a NATS subject, so SIEM and ML pipelines downstream see
+// every analysis and decision. Records are queued without blocking the
+// caller and published in batches. When the sink falls behind or is down,
+// the queue fills and further records are dropped and counted rather than
+// slowing requests down. Delivery is at least once: a batch that fails is
+// retried whole.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	recordCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_stream_records_total",
+		Help: "Records streamed to the export sink by sink and result (published, dropped, failed)",
+	}, []string{"sink", "result"})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ddos_protection_stream_queue_depth",
+		Help: "Records waiting to be streamed to the export sink",
+	})
+)
+
+// Sink kinds
+const (
+	SinkKafka = "kafka"
+	SinkNATS  = "nats"
+)
+
+// Message is an encoded record and the key it is partitioned by
+type Message struct {
+	Key   string
+	Value []byte
+}
+
+// Sink publishes batches of messages
+type Sink interface {
+	Publish(ctx context.Context, batch []Message) error
+	Close() error
+}
+
+// NewSink creates a sink of a kind: for Kafka, url is the base URL of a
+// Kafka REST proxy and topic the topic; for NATS, url is the server's
+// nats:// URL and topic the subject
+func NewSink(kind, url, topic string, timeout time.Duration) (Sink, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("no %s topic configured", kind)
+	}
+	switch kind {
+	case SinkKafka:
+		return newKafkaSink(url, topic, timeout)
+	case SinkNATS:
+		return newNATSSink(url, topic, timeout)
+	}
+	return nil, fmt.Errorf("unknown stream sink %q", kind)
+}
+
+// Options tunes batching and retries
+type Options struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	QueueSize     int
+	MaxAttempts   int
+	Backoff       time.Duration
+}
+
+// Exporter queues records and publishes them to a sink in batches
+type Exporter struct {
+	sink    Sink
+	name    string
+	options Options
+	queue   chan Message
+}
+
+// NewExporter creates an exporter publishing to sink, named name in
+// metrics. Zero options fall back to batches of 100 records flushed at
+// least every second, a queue of 10000 records, and 5 attempts per batch
+// starting 500ms apart.
+func NewExporter(sink Sink, name string, options Options) *Exporter {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 10000
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 5
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = 500 * time.Millisecond
+	}
+
+	return &Exporter{
+		sink:    sink,
+		name:    name,
+		options: options,
+		queue:   make(chan Message, options.QueueSize),
+	}
+}
+
+// Send encodes a record as JSON and queues it without blocking. Records
+// that do not fit in the queue are dropped.
+func (e *Exporter) Send(key string, record interface{}) {
+	if len(e.queue) == cap(e.queue) {
+		recordCounter.WithLabelValues(e.name, "dropped").Inc()
+		return
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		recordCounter.WithLabelValues(e.name, "failed").Inc()
+		return
+	}
+
+	select {
+	case e.queue <- Message{Key: key, Value: value}:
+	default:
+		recordCounter.WithLabelValues(e.name, "dropped").Inc()
+	}
+}
+
+// Run publishes queued records until ctx is cancelled, then flushes what
+// is left and closes the sink
+func (e *Exporter) Run(ctx context.Context) {
+	defer e.sink.Close()
+
+	ticker := time.NewTicker(e.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Message, 0, e.options.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			e.publish(ctx, batch)
+			batch = batch[:0]
+		}
+		queueDepth.Set(float64(len(e.queue)))
+	}
+
+	for {
+		select {
+		case msg := <-e.queue:
+			batch = append(batch, msg)
+			if len(batch) >= e.options.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case msg := <-e.queue:
+					batch = append(batch, msg)
+					if len(batch) >= e.options.BatchSize {
+						flush(drainCtx)
+					}
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish sends a batch, retrying failures with exponential backoff. The
+// queue keeps filling meanwhile, shedding records once it is full.
+func (e *Exporter) publish(ctx context.Context, batch []Message) {
+	backoff := e.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err := e.sink.Publish(ctx, batch)
+		if err == nil {
+			recordCounter.WithLabelValues(e.name, "published").Add(float64(len(batch)))
+			return
+		}
+		if attempt >= e.options.MaxAttempts {
+			recordCounter.WithLabelValues(e.name, "failed").Add(float64(len(batch)))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			recordCounter.WithLabelValues(e.name, "failed").Add(float64(len(batch)))
+			return
+		}
+	}
+}
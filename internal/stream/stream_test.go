@@ -0,0 +1,220 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type record struct {
+	IP string `json:"ip"`
+}
+
+func TestKafkaSinkBatchesRecords(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/botnet" || r.Header.Get("Content-Type") != kafkaContentType {
+			t.Errorf("request to %s with %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var body struct {
+			Records []struct {
+				Key   string `json:"key"`
+				Value record `json:"value"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		var ips []string
+		for _, rec := range body.Records {
+			if rec.Key != rec.Value.IP {
+				t.Errorf("record keyed %q, want %q", rec.Key, rec.Value.IP)
+			}
+			ips = append(ips, rec.Value.IP)
+		}
+		mu.Lock()
+		batches = append(batches, ips)
+		mu.Unlock()
+		fmt.Fprint(w, `{"offsets":[]}`)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(SinkKafka, server.URL, "botnet", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := NewExporter(sink, "test", Options{BatchSize: 2, FlushInterval: time.Hour})
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		exporter.Send(ip, record{IP: ip})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("batches %v, want two then the rest flushed on shutdown", batches)
+	}
+}
+
+func TestKafkaSinkReportsRecordErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1},{"error_code":50002,"error":"leader not available"}]}`)
+	}))
+	defer server.Close()
+
+	sink, _ := NewSink(SinkKafka, server.URL, "botnet", time.Second)
+	err := sink.Publish(context.Background(), []Message{{Value: []byte(`{}`)}, {Value: []byte(`{}`)}})
+	if err == nil || !strings.Contains(err.Error(), "leader not available") {
+		t.Errorf("Publish() = %v, want the record's error", err)
+	}
+}
+
+// natsServer accepts one connection at a time, confirms PINGs and records
+// the payloads published to it
+type natsServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	subjects []string
+	payloads []string
+}
+
+func newNATSServer(t *testing.T) *natsServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case fields[0] == "PUB" && len(fields) == 3:
+			n, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.subjects = append(s.subjects, fields[1])
+			s.payloads = append(s.payloads, string(payload[:n]))
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestNATSSinkPublishes(t *testing.T) {
+	server := newNATSServer(t)
+	defer server.listener.Close()
+
+	sink, err := NewSink(SinkNATS, "nats://"+server.listener.Addr().String(), "ddos.botnet", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	batch := []Message{{Value: []byte(`{"ip":"192.0.2.1"}`)}, {Value: []byte(`{"ip":"192.0.2.2"}`)}}
+	if err := sink.Publish(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// The PONG confirming the batch follows every PUB in it
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.payloads) != 2 || server.payloads[1] != `{"ip":"192.0.2.2"}` || server.subjects[0] != "ddos.botnet" {
+		t.Errorf("published %v to %v", server.payloads, server.subjects)
+	}
+}
+
+func TestNATSSinkReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sink, _ := NewSink(SinkNATS, "nats://"+addr, "ddos.botnet", time.Second)
+	if err := sink.Publish(context.Background(), []Message{{Value: []byte(`{}`)}}); err == nil {
+		t.Fatal("Publish() with the server down succeeded")
+	}
+
+	server := &natsServer{}
+	if server.listener, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("address taken again: %v", err)
+	}
+	defer server.listener.Close()
+	go func() {
+		conn, err := server.listener.Accept()
+		if err == nil {
+			server.serve(conn)
+		}
+	}()
+	if err := sink.Publish(context.Background(), []Message{{Value: []byte(`{}`)}}); err != nil {
+		t.Errorf("Publish() once the server is up = %v", err)
+	}
+}
+
+func TestExporterShedsWhenQueueIsFull(t *testing.T) {
+	exporter := NewExporter(nil, "test", Options{QueueSize: 2})
+	for i := 0; i < 5; i++ {
+		exporter.Send("", record{IP: strconv.Itoa(i)})
+	}
+	if len(exporter.queue) != 2 {
+		t.Errorf("queued %d records, want 2", len(exporter.queue))
+	}
+}
+
+func TestNewSinkRejectsBadConfig(t *testing.T) {
+	for _, tc := range []struct{ kind, url, topic string }{
+		{SinkKafka, "localhost:8082", "botnet"},
+		{SinkNATS, "http://localhost:4222", "ddos"},
+		{SinkNATS, "nats://localhost", "ddos botnet"},
+		{SinkKafka, "http://localhost:8082", ""},
+		{"amqp", "amqp://localhost", "ddos"},
+	} {
+		if _, err := NewSink(tc.kind, tc.url, tc.topic, 0); err == nil {
+			t.Errorf("NewSink(%q, %q, %q) succeeded", tc.kind, tc.url, tc.topic)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultTenant is used when a request carries no tenant identifier
+const DefaultTenant = "default"
+
+// Resolver determines which tenant a request belongs to
+type Resolver struct {
+	header  string
+	useHost bool
+}
+
+// NewResolver creates a tenant resolver. The header takes precedence; when it
+// is absent and useHost is set, the request hostname identifies the tenant.
+func NewResolver(header string, useHost bool) *Resolver {
+	return &Resolver{
+		header:  header,
+		useHost: useHost,
+	}
+}
+
+// Resolve returns the tenant ID for a request
+func (r *Resolver) Resolve(req *http.Request) string {
+	if r == nil {
+		return DefaultTenant
+	}
+
+	if r.header != "" {
+		if id := strings.TrimSpace(req.Header.Get(r.header)); id != "" {
+			return strings.ToLower(id)
+		}
+	}
+
+	if r.useHost && req.Host != "" {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		return strings.ToLower(host)
+	}
+
+	return DefaultTenant
+}
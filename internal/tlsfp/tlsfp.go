@@ -0,0 +1,498 @@
+// Package tlsfp fingerprints TLS clients by their ClientHello. Bots built on
+// one TLS stack share a fingerprint whatever user agent they claim, so many
+// addresses presenting the same rare fingerprint give a botnet away.
+//
+// A Listener records the ClientHello of each connection as the TLS server
+// reads it, and ConnContext makes the fingerprint available to the
+// connection's requests through FromContext. Both JA3 and JA4 are computed;
+// JA4 sorts ciphers and extensions, so it is stable across browsers that
+// randomize extension order.
+package tlsfp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxHelloSize bounds the bytes buffered while waiting for a ClientHello
+const maxHelloSize = 64 << 10
+
+const (
+	recordTypeHandshake  = 22
+	handshakeClientHello = 1
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var errShortHello = errors.New("truncated ClientHello")
+
+// ClientHello holds the fields of a ClientHello that fingerprints use, in
+// the order the client sent them
+type ClientHello struct {
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          bool
+}
+
+// Fingerprint identifies the TLS stack of a client
+type Fingerprint struct {
+	JA3 string `json:"ja3"`
+	JA4 string `json:"ja4"`
+}
+
+// Fingerprint computes the JA3 and JA4 fingerprints of the hello
+func (h *ClientHello) Fingerprint() Fingerprint {
+	return Fingerprint{JA3: h.JA3(), JA4: h.JA4()}
+}
+
+// ParseClientHello parses a ClientHello handshake message, starting at its
+// handshake header
+func ParseClientHello(msg []byte) (*ClientHello, error) {
+	r := reader(msg)
+	typ, ok := r.uint8()
+	if !ok || typ != handshakeClientHello {
+		return nil, fmt.Errorf("not a ClientHello")
+	}
+	body, ok := r.bytes24()
+	if !ok {
+		return nil, errShortHello
+	}
+
+	r = reader(body)
+	h := &ClientHello{}
+	var ciphers, extensions reader
+	if h.Version, ok = r.uint16(); !ok {
+		return nil, errShortHello
+	}
+	if _, ok = r.take(32); !ok {
+		return nil, errShortHello
+	}
+	if _, ok = r.bytes8(); !ok { // session ID
+		return nil, errShortHello
+	}
+	if ciphers, ok = r.bytes16(); !ok {
+		return nil, errShortHello
+	}
+	for len(ciphers) > 0 {
+		suite, ok := ciphers.uint16()
+		if !ok {
+			return nil, errShortHello
+		}
+		h.CipherSuites = append(h.CipherSuites, suite)
+	}
+	if _, ok = r.bytes8(); !ok { // compression methods
+		return nil, errShortHello
+	}
+	if len(r) == 0 {
+		// Hellos without extensions are valid
+		return h, nil
+	}
+	if extensions, ok = r.bytes16(); !ok {
+		return nil, errShortHello
+	}
+
+	for len(extensions) > 0 {
+		typ, ok := extensions.uint16()
+		if !ok {
+			return nil, errShortHello
+		}
+		data, ok := extensions.bytes16()
+		if !ok {
+			return nil, errShortHello
+		}
+		h.Extensions = append(h.Extensions, typ)
+		if err := h.parseExtension(typ, data); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// parseExtension reads the extensions fingerprints look into
+func (h *ClientHello) parseExtension(typ uint16, data reader) error {
+	var list reader
+	var ok bool
+	switch typ {
+	case extServerName:
+		h.ServerName = true
+		return nil
+	case extSupportedGroups, extSignatureAlgorithms:
+		list, ok = data.bytes16()
+	case extSupportedVersions, extECPointFormats:
+		list, ok = data.bytes8()
+	case extALPN:
+		list, ok = data.bytes16()
+		for ok && len(list) > 0 {
+			var proto reader
+			if proto, ok = list.bytes8(); ok {
+				h.ALPN = append(h.ALPN, string(proto))
+			}
+		}
+		if !ok {
+			return fmt.Errorf("malformed ALPN extension")
+		}
+		return nil
+	default:
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("malformed extension %#04x", typ)
+	}
+
+	if typ == extECPointFormats {
+		h.PointFormats = append(h.PointFormats, list...)
+		return nil
+	}
+	var values []uint16
+	for len(list) > 0 {
+		v, ok := list.uint16()
+		if !ok {
+			return fmt.Errorf("malformed extension %#04x", typ)
+		}
+		values = append(values, v)
+	}
+	switch typ {
+	case extSupportedGroups:
+		h.SupportedGroups = values
+	case extSignatureAlgorithms:
+		h.SignatureAlgorithms = values
+	case extSupportedVersions:
+		h.SupportedVersions = values
+	}
+	return nil
+}
+
+// JA3 returns the MD5 of the JA3 string: version, ciphers, extensions,
+// groups and point formats as decimals, GREASE values left out
+func (h *ClientHello) JA3() string {
+	points := make([]uint16, len(h.PointFormats))
+	for i, p := range h.PointFormats {
+		points[i] = uint16(p)
+	}
+	fields := []string{
+		strconv.Itoa(int(h.Version)),
+		joinDecimal(h.CipherSuites),
+		joinDecimal(h.Extensions),
+		joinDecimal(h.SupportedGroups),
+		joinDecimal(points),
+	}
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint: a readable prefix with the TLS version,
+// SNI, cipher and extension counts and ALPN, then truncated hashes of the
+// sorted ciphers and of the sorted extensions with the signature
+// algorithms
+func (h *ClientHello) JA4() string {
+	ciphers := withoutGrease(h.CipherSuites)
+	extensions := withoutGrease(h.Extensions)
+
+	sni := "i"
+	if h.ServerName {
+		sni = "d"
+	}
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", h.ja4Version(), sni, min99(len(ciphers)), min99(len(extensions)), h.ja4ALPN())
+
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	hashedExtensions := make([]uint16, 0, len(extensions))
+	for _, ext := range extensions {
+		if ext != extServerName && ext != extALPN {
+			hashedExtensions = append(hashedExtensions, ext)
+		}
+	}
+	sort.Slice(hashedExtensions, func(i, j int) bool { return hashedExtensions[i] < hashedExtensions[j] })
+
+	extensionPart := joinHex(hashedExtensions)
+	if algorithms := withoutGrease(h.SignatureAlgorithms); len(algorithms) > 0 {
+		extensionPart += "_" + joinHex(algorithms)
+	}
+	return prefix + "_" + truncatedHash(joinHex(ciphers), len(ciphers)) + "_" + truncatedHash(extensionPart, len(hashedExtensions))
+}
+
+// ja4Version is the highest version offered, GREASE aside
+func (h *ClientHello) ja4Version() string {
+	version := h.Version
+	if versions := withoutGrease(h.SupportedVersions); len(versions) > 0 {
+		version = 0
+		for _, v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+	switch version {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol
+func (h *ClientHello) ja4ALPN() string {
+	if len(h.ALPN) == 0 || h.ALPN[0] == "" {
+		return "00"
+	}
+	proto := h.ALPN[0]
+	first, last := proto[0], proto[len(proto)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		return hex.EncodeToString([]byte{first})[:1] + hex.EncodeToString([]byte{last})[1:]
+	}
+	return string([]byte{first, last})
+}
+
+// Listener wraps a listener so the ClientHello of each connection is
+// recorded as the TLS server reads it
+type Listener struct {
+	net.Listener
+}
+
+// NewListener wraps inner, the plain listener a TLS server accepts from
+func NewListener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner}
+}
+
+// Accept accepts a connection that records its ClientHello
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Conn passes reads through unchanged, recording the ClientHello among them
+type Conn struct {
+	net.Conn
+
+	mu          sync.Mutex
+	pending     []byte // record bytes not yet complete
+	handshake   []byte // handshake bytes from complete records
+	done        bool
+	fingerprint *Fingerprint
+}
+
+// Read reads from the connection, recording the ClientHello
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.observe(p[:n])
+	}
+	return n, err
+}
+
+// Fingerprint returns the fingerprint of the connection's ClientHello, or
+// nil if it was not read or could not be parsed
+func (c *Conn) Fingerprint() *Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fingerprint
+}
+
+// observe collects handshake records until the ClientHello is complete
+func (c *Conn) observe(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	c.pending = append(c.pending, data...)
+	for len(c.pending) >= 5 {
+		if c.pending[0] != recordTypeHandshake {
+			c.finish()
+			return
+		}
+		length := int(c.pending[3])<<8 | int(c.pending[4])
+		if len(c.pending) < 5+length {
+			break
+		}
+		c.handshake = append(c.handshake, c.pending[5:5+length]...)
+		c.pending = c.pending[5+length:]
+
+		if len(c.handshake) >= 4 {
+			size := 4 + (int(c.handshake[1])<<16 | int(c.handshake[2])<<8 | int(c.handshake[3]))
+			if len(c.handshake) >= size {
+				if hello, err := ParseClientHello(c.handshake[:size]); err == nil {
+					fingerprint := hello.Fingerprint()
+					c.fingerprint = &fingerprint
+				}
+				c.finish()
+				return
+			}
+		}
+	}
+	if len(c.pending)+len(c.handshake) > maxHelloSize {
+		c.finish()
+	}
+}
+
+// finish stops recording and drops the buffers; c.mu must be held
+func (c *Conn) finish() {
+	c.done = true
+	c.pending = nil
+	c.handshake = nil
+}
+
+type connKey struct{}
+
+// ConnContext is an http.Server ConnContext making the fingerprint of each
+// connection accepted from a Listener available to its requests
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if fpConn, ok := conn.(*Conn); ok {
+		return context.WithValue(ctx, connKey{}, fpConn)
+	}
+	return ctx
+}
+
+// FromContext returns the TLS fingerprint of the connection a request came
+// in on, or nil for plain HTTP and unparsable hellos
+func FromContext(ctx context.Context) *Fingerprint {
+	conn, ok := ctx.Value(connKey{}).(*Conn)
+	if !ok {
+		return nil
+	}
+	return conn.Fingerprint()
+}
+
+// isGrease reports whether v is a GREASE value (RFC 8701), which clients
+// send at random to keep servers tolerant of unknown values
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGrease(values []uint16) []uint16 {
+	kept := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGrease(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGrease(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// truncatedHash is the first 12 hex digits of the SHA-256 of s, or zeros
+// when there was nothing to hash
+func truncatedHash(s string, count int) string {
+	if count == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func isAlphanumeric(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// reader consumes big-endian fields from a byte slice
+type reader []byte
+
+func (r *reader) take(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	b, ok := r.take(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	b, ok := r.take(2)
+	if !ok {
+		return 0, false
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), true
+}
+
+func (r *reader) bytes8() (reader, bool) {
+	n, ok := r.uint8()
+	if !ok {
+		return nil, false
+	}
+	b, ok := r.take(int(n))
+	return reader(b), ok
+}
+
+func (r *reader) bytes16() (reader, bool) {
+	n, ok := r.uint16()
+	if !ok {
+		return nil, false
+	}
+	b, ok := r.take(int(n))
+	return reader(b), ok
+}
+
+func (r *reader) bytes24() (reader, bool) {
+	b, ok := r.take(3)
+	if !ok {
+		return nil, false
+	}
+	b, ok = r.take(int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+	return reader(b), ok
+}
@@ -0,0 +1,77 @@
+package tlsfp
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// captureHello sends a ClientHello made from config through a Conn and
+// returns the fingerprint it records
+func captureHello(t *testing.T, config *tls.Config) *Fingerprint {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Client(client, config).Handshake()
+
+	conn := &Conn{Conn: server}
+	buf := make([]byte, 1024)
+	for conn.Fingerprint() == nil {
+		if _, err := conn.Read(buf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("read: %v", err)
+		}
+	}
+	return conn.Fingerprint()
+}
+
+func TestConnFingerprintsClientHello(t *testing.T) {
+	config := &tls.Config{ServerName: "example.com", NextProtos: []string{"h2", "http/1.1"}}
+	fp := captureHello(t, config)
+	if fp == nil {
+		t.Fatal("no fingerprint recorded")
+	}
+
+	parts := strings.Split(fp.JA4, "_")
+	if len(parts) != 3 || len(parts[1]) != 12 || len(parts[2]) != 12 {
+		t.Fatalf("malformed JA4 %q", fp.JA4)
+	}
+	if !strings.HasPrefix(parts[0], "t13d") || !strings.HasSuffix(parts[0], "h2") {
+		t.Errorf("JA4 prefix %q, want TLS 1.3 with SNI and h2", parts[0])
+	}
+	if len(fp.JA3) != 32 {
+		t.Errorf("JA3 %q is not an MD5", fp.JA3)
+	}
+
+	// The same stack gives the same JA4, while a different one does not
+	if again := captureHello(t, config); again == nil || again.JA4 != fp.JA4 {
+		t.Errorf("JA4 changed between identical hellos: %v, %v", fp, again)
+	}
+	other := captureHello(t, &tls.Config{ServerName: "example.com", MaxVersion: tls.VersionTLS12})
+	if other == nil || other.JA4 == fp.JA4 || !strings.HasPrefix(other.JA4, "t12d") {
+		t.Errorf("TLS 1.2 hello fingerprinted as %v", other)
+	}
+}
+
+func TestConnIgnoresPlainText(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		client.Close()
+	}()
+
+	conn := &Conn{Conn: server}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if fp := conn.Fingerprint(); fp != nil {
+		t.Errorf("plain HTTP fingerprinted as %v", fp)
+	}
+}
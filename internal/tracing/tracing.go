@@ -0,0 +1,234 @@
+// Package tracing propagates W3C trace context through the protection layer.
+// Requests are sampled head-based at a configured rate, but blocked,
+// challenged and high-risk requests are always sampled and marked with a
+// sampling priority so downstream tracers keep them too.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var tracesSampled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_traces_sampled_total",
+	Help: "Requests sampled for tracing, by reason",
+}, []string{"reason"})
+
+// Headers carrying trace context
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// stateKey is this service's member in tracestate
+const stateKey = "ddos"
+
+// Sampling priorities carried in tracestate as ddos=p:<priority>
+const (
+	// PriorityAuto leaves the sampling decision to the sampled flag
+	PriorityAuto = 0
+	// PriorityKeep asks every tracer to keep the trace regardless of its
+	// own sampling rate
+	PriorityKeep = 2
+)
+
+// Sampling reasons
+const (
+	ReasonParent = "parent"
+	ReasonHead   = "head"
+	ReasonBlock  = "block"
+	ReasonRisk   = "risk"
+)
+
+// Context is the trace context of a request. SpanID identifies the
+// protection layer's span; ParentID is the caller's span, if any.
+type Context struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Sampled  bool
+	Priority int
+	Reason   string
+	state    string
+}
+
+// TraceIDString returns the trace ID in hex
+func (c Context) TraceIDString() string {
+	return hex.EncodeToString(c.TraceID[:])
+}
+
+// SpanIDString returns the span ID in hex
+func (c Context) SpanIDString() string {
+	return hex.EncodeToString(c.SpanID[:])
+}
+
+// ParentIDString returns the parent span ID in hex, or "" for a root span
+func (c Context) ParentIDString() string {
+	if c.ParentID == ([8]byte{}) {
+		return ""
+	}
+	return hex.EncodeToString(c.ParentID[:])
+}
+
+// TraceParent returns the traceparent header value with this context's span
+// as the parent
+func (c Context) TraceParent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceIDString(), c.SpanIDString(), flags)
+}
+
+// TraceState returns the tracestate header value, with this service's
+// member first as the specification requires of the latest writer
+func (c Context) TraceState() string {
+	members := []string{}
+	if c.Priority != PriorityAuto {
+		members = append(members, stateKey+"=p:"+strconv.Itoa(c.Priority))
+	}
+	for _, member := range strings.Split(c.state, ",") {
+		member = strings.TrimSpace(member)
+		if member != "" && !strings.HasPrefix(member, stateKey+"=") {
+			members = append(members, member)
+		}
+	}
+	return strings.Join(members, ",")
+}
+
+// Inject writes the trace context into headers
+func (c Context) Inject(h http.Header) {
+	h.Set(HeaderTraceParent, c.TraceParent())
+	if state := c.TraceState(); state != "" {
+		h.Set(HeaderTraceState, state)
+	} else {
+		h.Del(HeaderTraceState)
+	}
+}
+
+// parseTraceParent parses a version 00 traceparent header
+func parseTraceParent(value string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == ([16]byte{}) {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == ([8]byte{}) {
+		return traceID, parentID, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// parsePriority reads the sampling priority from this service's tracestate member
+func parsePriority(state string) int {
+	for _, member := range strings.Split(state, ",") {
+		member = strings.TrimSpace(member)
+		if !strings.HasPrefix(member, stateKey+"=p:") {
+			continue
+		}
+		if p, err := strconv.Atoi(strings.TrimPrefix(member, stateKey+"=p:")); err == nil {
+			return p
+		}
+	}
+	return PriorityAuto
+}
+
+// Sampler starts traces and decides which requests are sampled
+type Sampler struct {
+	threshold     uint64
+	riskThreshold int
+}
+
+// NewSampler creates a sampler keeping rate (0-1) of ordinary requests and
+// every request whose risk score reaches riskThreshold. A riskThreshold of 0
+// disables risk-based sampling.
+func NewSampler(rate float64, riskThreshold int) *Sampler {
+	s := &Sampler{riskThreshold: riskThreshold}
+	switch {
+	case rate >= 1:
+		s.threshold = math.MaxUint64
+	case rate > 0:
+		s.threshold = uint64(rate * float64(math.MaxUint64))
+	}
+	return s
+}
+
+// Start continues the trace in the request's headers, or starts a new one.
+// A sampled caller keeps the trace sampled; otherwise the head-based rate
+// decides, using the trace ID so every service agrees.
+func (s *Sampler) Start(h http.Header) Context {
+	var c Context
+	if traceID, parentID, sampled, ok := parseTraceParent(h.Get(HeaderTraceParent)); ok {
+		c.TraceID, c.ParentID, c.Sampled = traceID, parentID, sampled
+		c.state = h.Get(HeaderTraceState)
+		c.Priority = parsePriority(c.state)
+		if sampled {
+			c.Reason = ReasonParent
+		}
+	} else {
+		rand.Read(c.TraceID[:])
+	}
+	rand.Read(c.SpanID[:])
+
+	if !c.Sampled && s.threshold > 0 && binary.BigEndian.Uint64(c.TraceID[8:]) <= s.threshold {
+		c.Sampled = true
+		c.Reason = ReasonHead
+	}
+	if c.Sampled {
+		tracesSampled.WithLabelValues(c.Reason).Inc()
+	}
+	return c
+}
+
+// Prioritize forces sampling of a request that was blocked or challenged,
+// or whose risk score reached the sampler's threshold, and marks it with
+// PriorityKeep. It reports whether the trace was kept this way.
+func (s *Sampler) Prioritize(c *Context, blocked bool, riskScore int) bool {
+	reason := ""
+	switch {
+	case blocked:
+		reason = ReasonBlock
+	case s.riskThreshold > 0 && riskScore >= s.riskThreshold:
+		reason = ReasonRisk
+	default:
+		return false
+	}
+
+	if !c.Sampled {
+		tracesSampled.WithLabelValues(reason).Inc()
+	}
+	c.Sampled = true
+	c.Priority = PriorityKeep
+	c.Reason = reason
+	return true
+}
+
+type contextKey struct{}
+
+// WithContext returns a context carrying the trace context
+func WithContext(ctx context.Context, c Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the trace context carried by ctx
+func FromContext(ctx context.Context) (Context, bool) {
+	c, ok := ctx.Value(contextKey{}).(Context)
+	return c, ok
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStartContinuesParentTrace(t *testing.T) {
+	s := NewSampler(0, 0)
+
+	h := http.Header{}
+	h.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set(HeaderTraceState, "vendor=abc")
+
+	c := s.Start(h)
+	if c.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || c.ParentIDString() != "00f067aa0ba902b7" {
+		t.Fatalf("parent trace not continued: %s/%s", c.TraceIDString(), c.ParentIDString())
+	}
+	if !c.Sampled || c.Reason != ReasonParent {
+		t.Errorf("sampled parent should stay sampled, got %v (%s)", c.Sampled, c.Reason)
+	}
+	if c.SpanIDString() == c.ParentIDString() {
+		t.Error("protection span should get its own span ID")
+	}
+
+	out := http.Header{}
+	c.Inject(out)
+	if !strings.HasPrefix(out.Get(HeaderTraceParent), "00-4bf92f3577b34da6a3ce929d0e0e4736-"+c.SpanIDString()) {
+		t.Errorf("traceparent = %q", out.Get(HeaderTraceParent))
+	}
+	if out.Get(HeaderTraceState) != "vendor=abc" {
+		t.Errorf("tracestate = %q, want vendor=abc", out.Get(HeaderTraceState))
+	}
+}
+
+func TestPrioritizeBlockedAndRiskyRequests(t *testing.T) {
+	s := NewSampler(0, 60)
+
+	invalid := http.Header{}
+	invalid.Set(HeaderTraceParent, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	c := s.Start(invalid)
+	if c.Sampled || c.ParentIDString() != "" {
+		t.Fatal("invalid traceparent should start a new unsampled trace")
+	}
+
+	if s.Prioritize(&c, false, 10) || c.Sampled {
+		t.Error("low-risk allowed request should not be sampled at a zero rate")
+	}
+	if !s.Prioritize(&c, false, 75) || c.Reason != ReasonRisk {
+		t.Error("high-risk request should be sampled")
+	}
+
+	c = s.Start(http.Header{})
+	if !s.Prioritize(&c, true, 0) || !c.Sampled || c.Reason != ReasonBlock {
+		t.Fatal("blocked request should always be sampled")
+	}
+
+	h := http.Header{}
+	h.Set(HeaderTraceState, "ddos=p:0,vendor=abc")
+	c.state = h.Get(HeaderTraceState)
+	c.Inject(h)
+	if !strings.HasSuffix(h.Get(HeaderTraceParent), "-01") {
+		t.Errorf("traceparent should carry the sampled flag: %q", h.Get(HeaderTraceParent))
+	}
+	if h.Get(HeaderTraceState) != "ddos=p:2,vendor=abc" {
+		t.Errorf("tracestate = %q, want ddos=p:2,vendor=abc", h.Get(HeaderTraceState))
+	}
+}
+
+func TestHeadSamplingRate(t *testing.T) {
+	all, none := NewSampler(1, 0), NewSampler(0, 0)
+	for i := 0; i < 100; i++ {
+		if !all.Start(http.Header{}).Sampled {
+			t.Fatal("rate 1 should sample every request")
+		}
+		if none.Start(http.Header{}).Sampled {
+			t.Fatal("rate 0 should sample no ordinary request")
+		}
+	}
+}
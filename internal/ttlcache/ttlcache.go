@@ -0,0 +1,102 @@
+// Package ttlcache is a bounded cache of lookup results that all live for
+// the same TTL, as the DNS and probe checkers keep per client IP. Entries
+// are kept in the order they were stored, which is also the order they
+// expire in, so expired entries are dropped from the front without a scan
+// and a full cache evicts its oldest entry.
+package ttlcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type item[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// Cache maps keys to values for a fixed TTL, holding at most max entries.
+// It is safe for concurrent use.
+type Cache[V any] struct {
+	ttl   time.Duration
+	max   int
+	items map[string]*list.Element
+	order *list.List
+	mu    sync.Mutex
+}
+
+// New creates a cache whose entries live for ttl, holding at most max
+func New[V any](ttl time.Duration, max int) *Cache[V] {
+	return &Cache[V]{
+		ttl:   ttl,
+		max:   max,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get returns the value stored for key if it has not expired
+func (c *Cache[V]) Get(key string, now time.Time) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, exists := c.items[key]; exists {
+		if it := el.Value.(*item[V]); now.Before(it.expires) {
+			return it.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores value for key for the cache's TTL. When the cache is full,
+// expired entries are dropped and, if none were, the oldest one.
+func (c *Cache[V]) Set(key string, value V, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := now.Add(c.ttl)
+	if el, exists := c.items[key]; exists {
+		it := el.Value.(*item[V])
+		it.value, it.expires = value, expires
+		c.order.MoveToBack(el)
+		return
+	}
+
+	if c.max > 0 && len(c.items) >= c.max {
+		c.cleanupLocked(now)
+		if len(c.items) >= c.max {
+			c.removeLocked(c.order.Front())
+		}
+	}
+	c.items[key] = c.order.PushBack(&item[V]{key: key, value: value, expires: expires})
+}
+
+// Cleanup drops expired entries
+func (c *Cache[V]) Cleanup(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cleanupLocked(now)
+}
+
+func (c *Cache[V]) cleanupLocked(now time.Time) {
+	for el := c.order.Front(); el != nil && !now.Before(el.Value.(*item[V]).expires); el = c.order.Front() {
+		c.removeLocked(el)
+	}
+}
+
+func (c *Cache[V]) removeLocked(el *list.Element) {
+	delete(c.items, el.Value.(*item[V]).key)
+	c.order.Remove(el)
+}
+
+// Len returns the number of entries, expired or not
+func (c *Cache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
@@ -0,0 +1,45 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := New[string](time.Minute, 3)
+
+	c.Set("a", "1", now)
+	c.Set("b", "2", now.Add(10*time.Second))
+	if v, ok := c.Get("a", now.Add(59*time.Second)); !ok || v != "1" {
+		t.Errorf("Get(a) = %q, %v before expiry", v, ok)
+	}
+	if _, ok := c.Get("a", now.Add(time.Minute)); ok {
+		t.Error("entry served after its TTL")
+	}
+
+	// Storing again renews the entry and its place in line
+	c.Set("a", "1b", now.Add(20*time.Second))
+	c.Cleanup(now.Add(75 * time.Second))
+	if c.Len() != 1 {
+		t.Fatalf("%d entries after cleanup, want the renewed one", c.Len())
+	}
+	if v, ok := c.Get("a", now.Add(75*time.Second)); !ok || v != "1b" {
+		t.Errorf("Get(a) = %q, %v after renewal", v, ok)
+	}
+
+	// A full cache of live entries evicts the oldest
+	later := now.Add(76 * time.Second)
+	c.Set("c", "3", later)
+	c.Set("d", "4", later)
+	c.Set("e", "5", later)
+	if c.Len() != 3 {
+		t.Errorf("cache grew to %d past its bound", c.Len())
+	}
+	if _, ok := c.Get("a", later); ok {
+		t.Error("oldest entry kept over a new one")
+	}
+	if _, ok := c.Get("e", later); !ok {
+		t.Error("new entry not stored in a full cache")
+	}
+}
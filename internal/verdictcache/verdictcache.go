@@ -0,0 +1,225 @@
+// Package verdictcache remembers pipeline verdicts that cannot change until
+// a list changes, such as the ban of a blacklisted IP or the pass of a
+// whitelisted one. Repeat offenders in a flood are then answered from the
+// cache without running any stage. Entries are invalidated by list-change
+// events and never outlive the list entry that produced them.
+package verdictcache
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"ddos-protection/pkg/pipeline"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var lookupCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_verdict_cache_lookups_total",
+	Help: "Verdict cache lookups by result",
+}, []string{"result"})
+
+// Entry is a cached verdict. Target is the list entry the verdict came
+// from, an IP or CIDR range.
+type Entry struct {
+	Stage   string
+	Verdict pipeline.Verdict
+	Target  string
+	Expires time.Time
+}
+
+// Cache holds verdicts by client IP. It is safe for concurrent use.
+//
+// Invalidation never scans the cache: verdicts are indexed by the list
+// entry they came from, and a range change is recorded with the next
+// generation so that verdicts of IPs inside it cached before then are
+// skipped by Get and dropped by Cleanup.
+type Cache struct {
+	maxTTL   time.Duration
+	capacity int
+	entries  map[string]cached
+	byTarget map[string]map[string]struct{}
+	ranges   []rangeChange
+	gen      uint64
+	mu       sync.RWMutex
+}
+
+// cached is an entry with the generation it was cached in
+type cached struct {
+	Entry
+	gen uint64
+}
+
+// rangeChange records a change to a CIDR range in generation gen
+type rangeChange struct {
+	prefix netip.Prefix
+	gen    uint64
+	at     time.Time
+}
+
+// New creates a cache holding up to capacity verdicts for at most maxTTL
+// each, bounding how stale a verdict can get if an invalidation is missed
+func New(maxTTL time.Duration, capacity int) *Cache {
+	return &Cache{
+		maxTTL:   maxTTL,
+		capacity: capacity,
+		entries:  make(map[string]cached),
+		byTarget: make(map[string]map[string]struct{}),
+	}
+}
+
+// Get returns the unexpired verdict cached for ip
+func (c *Cache) Get(ip string, now time.Time) (Entry, bool) {
+	c.mu.RLock()
+	entry, exists := c.entries[ip]
+	valid := exists && now.Before(entry.Expires) && !c.staleLocked(ip, entry)
+	c.mu.RUnlock()
+
+	if !valid {
+		lookupCounter.WithLabelValues("miss").Inc()
+		return Entry{}, false
+	}
+	lookupCounter.WithLabelValues("hit").Inc()
+	return entry.Entry, true
+}
+
+// staleLocked reports whether a range containing ip changed after the
+// entry was cached; callers must hold the lock. Only changes within the
+// maximum TTL are kept, so few are ever checked.
+func (c *Cache) staleLocked(ip string, entry cached) bool {
+	if len(c.ranges) == 0 || c.ranges[len(c.ranges)-1].gen <= entry.gen {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for i := len(c.ranges) - 1; i >= 0 && c.ranges[i].gen > entry.gen; i-- {
+		if c.ranges[i].prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Put caches a verdict for ip until entry.Expires, capped at the cache's
+// maximum TTL. A zero Expires means the list entry never expires.
+func (c *Cache) Put(ip string, entry Entry, now time.Time) {
+	if limit := now.Add(c.maxTTL); entry.Expires.IsZero() || entry.Expires.After(limit) {
+		entry.Expires = limit
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[ip]; exists {
+		c.removeLocked(ip)
+	} else if c.capacity > 0 && len(c.entries) >= c.capacity {
+		c.cleanupLocked(now)
+		if len(c.entries) >= c.capacity {
+			return
+		}
+	}
+	c.entries[ip] = cached{Entry: entry, gen: c.gen}
+	ips, indexed := c.byTarget[entry.Target]
+	if !indexed {
+		ips = make(map[string]struct{})
+		c.byTarget[entry.Target] = ips
+	}
+	ips[ip] = struct{}{}
+}
+
+// removeLocked drops the verdict of ip; callers must hold the lock
+func (c *Cache) removeLocked(ip string) {
+	entry, exists := c.entries[ip]
+	if !exists {
+		return
+	}
+	delete(c.entries, ip)
+	if ips := c.byTarget[entry.Target]; ips != nil {
+		delete(ips, ip)
+		if len(ips) == 0 {
+			delete(c.byTarget, entry.Target)
+		}
+	}
+}
+
+// Invalidate drops verdicts affected by a change to target: the IP itself,
+// every IP inside a CIDR range, and every verdict that came from target
+func (c *Cache) Invalidate(target string) {
+	var prefix netip.Prefix
+	if strings.Contains(target, "/") {
+		if p, err := netip.ParsePrefix(target); err == nil {
+			prefix = p.Masked()
+			target = prefix.String()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !prefix.IsValid() {
+		c.removeLocked(target)
+	}
+	for ip := range c.byTarget[target] {
+		c.removeLocked(ip)
+	}
+	if prefix.IsValid() && len(c.entries) > 0 {
+		now := time.Now()
+		c.pruneRangesLocked(now)
+		c.gen++
+		c.ranges = append(c.ranges, rangeChange{prefix: prefix, gen: c.gen, at: now})
+	}
+}
+
+// pruneRangesLocked forgets range changes older than any verdict could be;
+// callers must hold the lock
+func (c *Cache) pruneRangesLocked(now time.Time) {
+	cutoff := now.Add(-c.maxTTL)
+	i := 0
+	for i < len(c.ranges) && c.ranges[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		c.ranges = append(c.ranges[:0:0], c.ranges[i:]...)
+	}
+}
+
+// Clear drops every cached verdict
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cached)
+	c.byTarget = make(map[string]map[string]struct{})
+	c.ranges = nil
+}
+
+// Len returns the number of cached verdicts, including any a range change
+// made stale that Cleanup has yet to drop
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.entries)
+}
+
+// Cleanup drops expired verdicts and those made stale by range changes
+func (c *Cache) Cleanup(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cleanupLocked(now)
+}
+
+func (c *Cache) cleanupLocked(now time.Time) {
+	for ip, entry := range c.entries {
+		if !now.Before(entry.Expires) || c.staleLocked(ip, entry) {
+			c.removeLocked(ip)
+		}
+	}
+	c.pruneRangesLocked(now)
+}
@@ -0,0 +1,123 @@
+package verdictcache
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ddos-protection/internal/filter"
+	"ddos-protection/pkg/pipeline"
+)
+
+func TestCacheExpiryAndCap(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute, 0)
+
+	c.Put("192.0.2.1", Entry{Verdict: pipeline.Reject(403, "BLOCKED_IP", "Access denied"), Expires: now.Add(10 * time.Second)}, now)
+	c.Put("192.0.2.2", Entry{Verdict: pipeline.Verdict{Decision: pipeline.Allow}}, now)
+
+	if _, ok := c.Get("192.0.2.1", now.Add(5*time.Second)); !ok {
+		t.Error("verdict should be cached until its list entry expires")
+	}
+	if _, ok := c.Get("192.0.2.1", now.Add(11*time.Second)); ok {
+		t.Error("verdict should not outlive its list entry")
+	}
+	if entry, ok := c.Get("192.0.2.2", now); !ok || !entry.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("permanent entry should be capped at the max TTL, got %v", entry.Expires)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	now := time.Now()
+	c := New(time.Hour, 0)
+	deny := pipeline.Reject(403, "BLOCKED_IP", "Access denied")
+
+	c.Put("192.0.2.1", Entry{Verdict: deny, Target: "192.0.2.1"}, now)
+	c.Put("198.51.100.7", Entry{Verdict: deny, Target: "198.51.100.0/24"}, now)
+	c.Put("198.51.100.8", Entry{Verdict: deny, Target: "198.51.100.0/24"}, now)
+	c.Put("203.0.113.9", Entry{Verdict: pipeline.Verdict{Decision: pipeline.Allow}, Target: "203.0.113.9"}, now)
+
+	c.Invalidate("192.0.2.1")
+	if _, ok := c.Get("192.0.2.1", now); ok {
+		t.Error("IP change should invalidate its verdict")
+	}
+
+	c.Invalidate("198.51.100.0/24")
+	if c.Len() != 1 {
+		t.Errorf("range change should invalidate verdicts from it, %d left", c.Len())
+	}
+
+	c.Invalidate("203.0.113.0/24")
+	if _, ok := c.Get("203.0.113.9", now); ok {
+		t.Error("range change should invalidate verdicts of IPs inside it")
+	}
+	c.Cleanup(now)
+	if c.Len() != 0 {
+		t.Errorf("cleanup should drop verdicts a range change made stale, %d left", c.Len())
+	}
+
+	// Verdicts cached after the range change are served
+	c.Put("203.0.113.9", Entry{Verdict: deny, Target: "203.0.113.0/24"}, now)
+	if _, ok := c.Get("203.0.113.9", now); !ok {
+		t.Error("verdict cached after a range change should be served")
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	now := time.Now()
+	c := New(time.Hour, 1)
+	c.Put("192.0.2.1", Entry{}, now)
+	c.Put("192.0.2.2", Entry{}, now)
+	if c.Len() != 1 {
+		t.Errorf("cache grew past its capacity: %d", c.Len())
+	}
+}
+
+// BenchmarkRepeatOffender compares answering a banned client from the cache
+// with running the request filter for it again
+func BenchmarkRepeatOffender(b *testing.B) {
+	req := httptest.NewRequest("GET", "/search?q=shoes&page=2", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	ctx := filter.WithClientKey(context.Background(), "192.0.2.1")
+
+	b.Run("uncached", func(b *testing.B) {
+		rf := filter.NewRequestFilter(1<<20, nil, nil)
+		for i := 0; i < b.N; i++ {
+			rf.FilterRequest(ctx, req)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		rf := filter.NewRequestFilter(1<<20, nil, nil)
+		now := time.Now()
+		c := New(time.Hour, 0)
+		c.Put("192.0.2.1", Entry{Verdict: pipeline.Reject(403, "BLOCKED_IP", "Access denied"), Target: "192.0.2.1"}, now)
+		for i := 0; i < b.N; i++ {
+			if _, ok := c.Get("192.0.2.1", now); !ok {
+				rf.FilterRequest(ctx, req)
+			}
+		}
+	})
+}
+
+// BenchmarkInvalidate measures list changes against a full cache, which
+// must not cost more as the cache grows
+func BenchmarkInvalidate(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			now := time.Now()
+			c := New(time.Hour, 0)
+			for i := 0; i < n; i++ {
+				ip := fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+				c.Put(ip, Entry{Target: ip}, now)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Invalidate(fmt.Sprintf("192.0.2.%d", i&255))
+				c.Invalidate(fmt.Sprintf("198.51.%d.0/24", i&255))
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// Package webhook delivers mitigation events to outbound HTTP endpoints, so
+// SOC tooling and chat channels see bans and unbans as they happen. Payloads
+// are signed with HMAC-SHA256 and failed deliveries are retried with
+// exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var deliveryCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ddos_protection_webhook_deliveries_total",
+	Help: "Webhook deliveries by endpoint and result",
+}, []string{"endpoint", "result"})
+
+// Event types
+const (
+	EventBlacklistAuto   = "blacklist.auto"
+	EventBlacklistManual = "blacklist.manual"
+	EventBlacklistRemove = "blacklist.remove"
+	EventBlacklistExpire = "blacklist.expire"
+	EventWhitelistAdd    = "whitelist.add"
+	EventWhitelistRemove = "whitelist.remove"
+)
+
+// Payload formats
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+)
+
+// Headers set on every delivery
+const (
+	HeaderEvent     = "X-DDoS-Event"
+	HeaderDelivery  = "X-DDoS-Delivery"
+	HeaderTimestamp = "X-DDoS-Timestamp"
+	HeaderSignature = "X-DDoS-Signature"
+)
+
+// Event is a mitigation action. Expires is nil for permanent entries and
+// removals.
+type Event struct {
+	ID      string     `json:"id"`
+	Type    string     `json:"type"`
+	Time    time.Time  `json:"time"`
+	Target  string     `json:"target"`
+	Source  string     `json:"source,omitempty"`
+	Feed    string     `json:"feed,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Actor   string     `json:"actor,omitempty"`
+	Node    string     `json:"node,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Summary describes the event in one line for chat channels
+func (e Event) Summary() string {
+	var b strings.Builder
+	switch e.Type {
+	case EventBlacklistAuto:
+		fmt.Fprintf(&b, "Auto-blacklisted %s", e.Target)
+	case EventBlacklistManual:
+		fmt.Fprintf(&b, "Banned %s", e.Target)
+	case EventBlacklistRemove:
+		fmt.Fprintf(&b, "Unbanned %s", e.Target)
+	case EventBlacklistExpire:
+		fmt.Fprintf(&b, "Ban on %s expired", e.Target)
+	case EventWhitelistAdd:
+		fmt.Fprintf(&b, "Whitelisted %s", e.Target)
+	case EventWhitelistRemove:
+		fmt.Fprintf(&b, "Removed %s from the whitelist", e.Target)
+	default:
+		fmt.Fprintf(&b, "%s %s", e.Type, e.Target)
+	}
+	if e.Source != "" {
+		fmt.Fprintf(&b, " (%s)", e.Source)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ": %s", e.Reason)
+	}
+	if e.Expires != nil {
+		fmt.Fprintf(&b, " until %s", e.Expires.UTC().Format(time.RFC3339))
+	}
+	if e.Actor != "" {
+		fmt.Fprintf(&b, " by %s", e.Actor)
+	}
+	return b.String()
+}
+
+// Endpoint is a webhook receiver. An empty Events list subscribes to every
+// event type; an empty Secret sends deliveries unsigned.
+type Endpoint struct {
+	Name   string
+	URL    string
+	Secret string
+	Format string
+	Events []string
+}
+
+func (e *Endpoint) validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", e.URL)
+	}
+	if e.Format != "" && e.Format != FormatJSON && e.Format != FormatSlack {
+		return fmt.Errorf("unknown webhook format %q", e.Format)
+	}
+	for _, t := range e.Events {
+		switch t {
+		case EventBlacklistAuto, EventBlacklistManual, EventBlacklistRemove, EventBlacklistExpire, EventWhitelistAdd, EventWhitelistRemove:
+		default:
+			return fmt.Errorf("unknown webhook event %q", t)
+		}
+	}
+	return nil
+}
+
+// wants reports whether the endpoint subscribes to an event type
+func (e *Endpoint) wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Options tunes delivery
+type Options struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	QueueSize   int
+	Workers     int
+}
+
+type delivery struct {
+	endpoint *Endpoint
+	event    Event
+}
+
+// Dispatcher queues events and delivers them to subscribed endpoints in the
+// background
+type Dispatcher struct {
+	endpoints []*Endpoint
+	options   Options
+	client    *http.Client
+	queue     chan delivery
+}
+
+// NewDispatcher creates a dispatcher for endpoints. Zero options fall back to
+// a 5 second timeout, 5 attempts starting 1 second apart, a queue of 1000
+// deliveries and 2 workers.
+func NewDispatcher(endpoints []Endpoint, options Options) (*Dispatcher, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 5
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1000
+	}
+	if options.Workers <= 0 {
+		options.Workers = 2
+	}
+
+	d := &Dispatcher{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		queue:   make(chan delivery, options.QueueSize),
+	}
+	for i := range endpoints {
+		endpoint := endpoints[i]
+		if err := endpoint.validate(); err != nil {
+			return nil, err
+		}
+		if endpoint.Name == "" {
+			endpoint.Name = endpoint.URL
+		}
+		d.endpoints = append(d.endpoints, &endpoint)
+	}
+	return d, nil
+}
+
+// Send queues an event for every endpoint subscribed to its type without
+// blocking. Deliveries that do not fit in the queue are dropped.
+func (d *Dispatcher) Send(event Event) {
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, endpoint := range d.endpoints {
+		if !endpoint.wants(event.Type) {
+			continue
+		}
+		select {
+		case d.queue <- delivery{endpoint: endpoint, event: event}:
+		default:
+			deliveryCounter.WithLabelValues(endpoint.Name, "dropped").Inc()
+		}
+	}
+}
+
+// Run delivers queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-d.queue:
+					d.deliver(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver posts an event, retrying network errors, 429s and 5xx responses
+// with exponential backoff. Other responses are final.
+func (d *Dispatcher) deliver(ctx context.Context, job delivery) {
+	body, err := encode(job.endpoint.Format, job.event)
+	if err != nil {
+		deliveryCounter.WithLabelValues(job.endpoint.Name, "failed").Inc()
+		return
+	}
+
+	backoff := d.options.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(ctx, job, body)
+		if err == nil {
+			deliveryCounter.WithLabelValues(job.endpoint.Name, "delivered").Inc()
+			return
+		}
+		if !retry || attempt >= d.options.MaxAttempts {
+			deliveryCounter.WithLabelValues(job.endpoint.Name, "failed").Inc()
+			return
+		}
+
+		deliveryCounter.WithLabelValues(job.endpoint.Name, "retried").Inc()
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying
+func (d *Dispatcher) post(ctx context.Context, job delivery, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, job.event.Type)
+	req.Header.Set(HeaderDelivery, job.event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if job.endpoint.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(job.endpoint.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return false, fmt.Errorf("webhook returned %d", resp.StatusCode)
+}
+
+// encode renders an event in an endpoint's payload format
+func encode(format string, event Event) ([]byte, error) {
+	switch format {
+	case "", FormatJSON:
+		return json.Marshal(event)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": event.Summary()})
+	}
+	return nil, fmt.Errorf("unknown webhook format %q", format)
+}
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, prefixed with "sha256=". Receivers should
+// recompute it and reject stale timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of a delivery
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliverSignedWithRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		received Event
+		verified bool
+	)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		verified = Verify("s3cret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature))
+		json.Unmarshal(body, &received)
+		close(done)
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher([]Endpoint{{URL: server.URL, Secret: "s3cret", Events: []string{EventBlacklistAuto}}},
+		Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Send(Event{Type: EventWhitelistAdd, Target: "192.0.2.1"})
+	d.Send(Event{Type: EventBlacklistAuto, Target: "192.0.2.2", Source: "botnet"})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want a retry after the 503", attempts)
+	}
+	if !verified {
+		t.Error("signature did not verify")
+	}
+	if received.Type != EventBlacklistAuto || received.Target != "192.0.2.2" || received.ID == "" {
+		t.Errorf("received %+v, want the subscribed event only", received)
+	}
+}
+
+func TestNewDispatcherValidatesEndpoints(t *testing.T) {
+	for _, endpoint := range []Endpoint{
+		{URL: "ftp://example.com/hook"},
+		{URL: "https://example.com/hook", Format: "xml"},
+		{URL: "https://example.com/hook", Events: []string{"blacklist.added"}},
+	} {
+		if _, err := NewDispatcher([]Endpoint{endpoint}, Options{}); err == nil {
+			t.Errorf("endpoint %+v should be rejected", endpoint)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	expires := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := Event{Type: EventBlacklistManual, Target: "198.51.100.7", Source: "manual", Reason: "abuse report", Actor: "alice", Expires: &expires}
+	want := "Banned 198.51.100.7 (manual): abuse report until 2024-05-01T12:00:00Z by alice"
+	if got := e.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
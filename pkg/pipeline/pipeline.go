@@ -0,0 +1,385 @@
+// Package pipeline defines the ordered stages a request passes through before
+// it reaches the protected handler. Each stage returns a Verdict; the first
+// stage that does not continue decides the outcome.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ddos_protection_stage_duration_seconds",
+		Help:    "Time spent in each protection pipeline stage",
+		Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	}, []string{"stage"})
+
+	stageVerdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_stage_verdicts_total",
+		Help: "Verdicts returned by each protection pipeline stage",
+	}, []string{"stage", "decision"})
+
+	stageTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddos_protection_stage_timeouts_total",
+		Help: "Evaluations skipped because a stage overran its deadline",
+	}, []string{"stage"})
+)
+
+// Decision is what a stage wants done with the request
+type Decision int
+
+const (
+	// Continue passes the request to the next stage
+	Continue Decision = iota
+	// Allow skips the remaining stages and serves the request
+	Allow
+	// Deny rejects the request with the verdict's status and code
+	Deny
+	// Challenge asks the client to prove it is legitimate
+	Challenge
+	// Respond answers the request at the edge with the verdict's status and
+	// headers, without reaching the handler
+	Respond
+	// Slowdown serves the request after holding it for the verdict's delay
+	Slowdown
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Continue:
+		return "continue"
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	case Challenge:
+		return "challenge"
+	case Respond:
+		return "respond"
+	case Slowdown:
+		return "slowdown"
+	default:
+		return "unknown"
+	}
+}
+
+// Verdict is the result of evaluating a stage
+type Verdict struct {
+	Decision Decision
+	Status   int
+	Code     string
+	Error    string
+	Reason   string
+	Fields   map[string]interface{}
+	Headers  http.Header
+	Delay    time.Duration
+}
+
+// Next returns a verdict that passes the request to the next stage
+func Next() Verdict {
+	return Verdict{Decision: Continue}
+}
+
+// Reject returns a verdict that denies the request
+func Reject(status int, code, message string) Verdict {
+	return Verdict{
+		Decision: Deny,
+		Status:   status,
+		Code:     code,
+		Error:    message,
+	}
+}
+
+// RequestInfo carries the request and what earlier stages learned about it.
+// RiskScore accumulates risk raised by stages that do not decide on their own.
+type RequestInfo struct {
+	Request   *http.Request
+	ClientIP  string
+	Tenant    string
+	Start     time.Time
+	RiskScore int
+	Values    map[string]interface{}
+}
+
+// clone copies the info so a stage can work on it alone. Values is copied;
+// the values in it and the request are shared.
+func (info *RequestInfo) clone() *RequestInfo {
+	copied := *info
+	copied.Values = make(map[string]interface{}, len(info.Values))
+	for k, v := range info.Values {
+		copied.Values[k] = v
+	}
+	return &copied
+}
+
+// NewRequestInfo creates request info for a request
+func NewRequestInfo(req *http.Request, clientIP, tenant string) *RequestInfo {
+	return &RequestInfo{
+		Request:  req,
+		ClientIP: clientIP,
+		Tenant:   tenant,
+		Start:    time.Now(),
+		Values:   make(map[string]interface{}),
+	}
+}
+
+// Stage is a single step of the protection pipeline
+type Stage interface {
+	Name() string
+	Evaluate(ctx context.Context, info *RequestInfo) Verdict
+}
+
+type stageFunc struct {
+	name string
+	fn   func(ctx context.Context, info *RequestInfo) Verdict
+}
+
+func (s *stageFunc) Name() string {
+	return s.name
+}
+
+func (s *stageFunc) Evaluate(ctx context.Context, info *RequestInfo) Verdict {
+	return s.fn(ctx, info)
+}
+
+// NewStage creates a stage from a function
+func NewStage(name string, fn func(ctx context.Context, info *RequestInfo) Verdict) Stage {
+	return &stageFunc{name: name, fn: fn}
+}
+
+// Deadline bounds the time a stage may take. A stage that overruns it is
+// skipped, or denies the request with FailClosed.
+type Deadline struct {
+	Timeout    time.Duration `json:"timeout"`
+	FailClosed bool          `json:"fail_closed"`
+}
+
+// Pipeline is an ordered, mutable list of stages. It is safe to modify
+// while requests are being evaluated.
+type Pipeline struct {
+	stages    []Stage
+	deadlines map[string]Deadline
+	mu        sync.RWMutex
+}
+
+// New creates a pipeline with the given stages in order
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Evaluate runs the stages in order until one decides the outcome. It returns
+// the deciding verdict and stage name, or a Continue verdict and "" when every
+// stage passed the request through.
+func (p *Pipeline) Evaluate(ctx context.Context, info *RequestInfo) (Verdict, string) {
+	p.mu.RLock()
+	stages := p.stages
+	deadlines := p.deadlines
+	p.mu.RUnlock()
+
+	for _, stage := range stages {
+		start := time.Now()
+		var verdict Verdict
+		if deadline, bounded := deadlines[stage.Name()]; bounded {
+			verdict = evaluateWithin(ctx, stage, info, deadline)
+		} else {
+			verdict = stage.Evaluate(ctx, info)
+		}
+		stageDuration.WithLabelValues(stage.Name()).Observe(time.Since(start).Seconds())
+		stageVerdicts.WithLabelValues(stage.Name(), verdict.Decision.String()).Inc()
+
+		if verdict.Decision != Continue {
+			return verdict, stage.Name()
+		}
+	}
+
+	return Next(), ""
+}
+
+// evaluateWithin runs a stage against its deadline. The stage works on a
+// copy of info, taken over only if it finishes in time; one that overruns
+// is left to finish in the background, so contention inside it cannot hold
+// the request longer than the deadline.
+func evaluateWithin(ctx context.Context, stage Stage, info *RequestInfo, deadline Deadline) Verdict {
+	scratch := info.clone()
+	done := make(chan Verdict, 1)
+	go func() {
+		done <- stage.Evaluate(ctx, scratch)
+	}()
+
+	timer := time.NewTimer(deadline.Timeout)
+	defer timer.Stop()
+
+	select {
+	case verdict := <-done:
+		*info = *scratch
+		return verdict
+	case <-timer.C:
+		stageTimeouts.WithLabelValues(stage.Name()).Inc()
+		if !deadline.FailClosed {
+			return Next()
+		}
+		verdict := Reject(http.StatusServiceUnavailable, "STAGE_TIMEOUT", "Request could not be checked in time")
+		verdict.Reason = fmt.Sprintf("stage %s overran its %s deadline", stage.Name(), deadline.Timeout)
+		return verdict
+	}
+}
+
+// SetDeadline bounds the time the named stage may take; a zero timeout
+// removes the bound. The stage need not exist yet.
+func (p *Pipeline) SetDeadline(name string, deadline Deadline) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Copy on write, like the stage list
+	deadlines := make(map[string]Deadline, len(p.deadlines)+1)
+	for stage, d := range p.deadlines {
+		deadlines[stage] = d
+	}
+	if deadline.Timeout > 0 {
+		deadlines[name] = deadline
+	} else {
+		delete(deadlines, name)
+	}
+	p.deadlines = deadlines
+}
+
+// Deadlines returns the stage deadlines by stage name
+func (p *Pipeline) Deadlines() map[string]Deadline {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	deadlines := make(map[string]Deadline, len(p.deadlines))
+	for stage, d := range p.deadlines {
+		deadlines[stage] = d
+	}
+	return deadlines
+}
+
+// Stages returns the stage names in evaluation order
+func (p *Pipeline) Stages() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name()
+	}
+	return names
+}
+
+// Append adds a stage at the end of the pipeline
+func (p *Pipeline) Append(stage Stage) error {
+	return p.modify(func(stages []Stage) ([]Stage, error) {
+		if indexOf(stages, stage.Name()) >= 0 {
+			return nil, fmt.Errorf("stage already exists: %s", stage.Name())
+		}
+		return append(append([]Stage{}, stages...), stage), nil
+	})
+}
+
+// InsertBefore adds a stage immediately before the named stage
+func (p *Pipeline) InsertBefore(name string, stage Stage) error {
+	return p.insert(name, 0, stage)
+}
+
+// InsertAfter adds a stage immediately after the named stage
+func (p *Pipeline) InsertAfter(name string, stage Stage) error {
+	return p.insert(name, 1, stage)
+}
+
+func (p *Pipeline) insert(name string, offset int, stage Stage) error {
+	return p.modify(func(stages []Stage) ([]Stage, error) {
+		if indexOf(stages, stage.Name()) >= 0 {
+			return nil, fmt.Errorf("stage already exists: %s", stage.Name())
+		}
+		i := indexOf(stages, name)
+		if i < 0 {
+			return nil, fmt.Errorf("stage not found: %s", name)
+		}
+
+		i += offset
+		result := append([]Stage{}, stages[:i]...)
+		result = append(result, stage)
+		return append(result, stages[i:]...), nil
+	})
+}
+
+// Replace swaps the named stage for another
+func (p *Pipeline) Replace(name string, stage Stage) error {
+	return p.modify(func(stages []Stage) ([]Stage, error) {
+		i := indexOf(stages, name)
+		if i < 0 {
+			return nil, fmt.Errorf("stage not found: %s", name)
+		}
+
+		result := append([]Stage{}, stages...)
+		result[i] = stage
+		return result, nil
+	})
+}
+
+// Remove deletes the named stage
+func (p *Pipeline) Remove(name string) error {
+	return p.modify(func(stages []Stage) ([]Stage, error) {
+		i := indexOf(stages, name)
+		if i < 0 {
+			return nil, fmt.Errorf("stage not found: %s", name)
+		}
+
+		result := append([]Stage{}, stages[:i]...)
+		return append(result, stages[i+1:]...), nil
+	})
+}
+
+// Reorder rearranges the stages into the given order, which must name every
+// stage exactly once
+func (p *Pipeline) Reorder(names []string) error {
+	return p.modify(func(stages []Stage) ([]Stage, error) {
+		if len(names) != len(stages) {
+			return nil, fmt.Errorf("expected %d stage names, got %d", len(stages), len(names))
+		}
+
+		result := make([]Stage, 0, len(stages))
+		for _, name := range names {
+			i := indexOf(stages, name)
+			if i < 0 {
+				return nil, fmt.Errorf("stage not found: %s", name)
+			}
+			if indexOf(result, name) >= 0 {
+				return nil, fmt.Errorf("stage listed twice: %s", name)
+			}
+			result = append(result, stages[i])
+		}
+		return result, nil
+	})
+}
+
+// modify replaces the stage list copy-on-write so in-flight evaluations keep
+// the list they started with
+func (p *Pipeline) modify(fn func([]Stage) ([]Stage, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stages, err := fn(p.stages)
+	if err != nil {
+		return err
+	}
+	p.stages = stages
+	return nil
+}
+
+func indexOf(stages []Stage, name string) int {
+	for i, stage := range stages {
+		if stage.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
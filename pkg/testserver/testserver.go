@@ -0,0 +1,279 @@
+// Package testserver runs the full protection stack in-process in front of
+// an httptest backend, so projects can write integration tests against
+// blocking, rate limiting and bans without Docker or Redis. Clients made by
+// a Server connect from any IP the test chooses:
+//
+//	func TestCheckoutSurvivesFlood(t *testing.T) {
+//		s := testserver.New(t, testserver.Options{Backend: shop.Handler()})
+//
+//		statuses := s.Client("192.0.2.1").Repeat("/checkout", 100)
+//		if statuses[http.StatusTooManyRequests] == 0 {
+//			t.Fatal("flood was not rate limited")
+//		}
+//		if resp := s.Client("192.0.2.2").Get("/checkout"); resp.Status != http.StatusOK {
+//			t.Fatalf("other clients are affected: %d %s", resp.Status, resp.Code)
+//		}
+//	}
+package testserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddos-protection/internal/blacklist"
+	"ddos-protection/internal/config"
+	"ddos-protection/internal/ddos"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPHeader carries a simulated client's IP from a Client to the
+// server, which makes it the connection's remote address
+const clientIPHeader = "X-Testserver-Client-IP"
+
+// browserUserAgent is sent by clients unless a test sets its own
+const browserUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+
+// Options configures a Server. Zero values use DefaultConfig and a backend
+// answering every request with 200 OK.
+type Options struct {
+	Config  *config.Config
+	Backend http.Handler
+}
+
+// Server is the protection stack listening on URL, proxying requests it
+// lets through to Backend
+type Server struct {
+	URL     string
+	Config  *config.Config
+	Service *ddos.ProtectionService
+	Backend *httptest.Server
+
+	t        testing.TB
+	frontend *httptest.Server
+	hits     int64
+}
+
+// DefaultConfig returns a configuration for tests: no Redis, no files on
+// disk and the protections most tests exercise turned on with the limits
+// from config.yaml
+func DefaultConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Server.Mode = gin.TestMode
+	cfg.Logging.Level = "error"
+	cfg.Events.Capacity = 1000
+
+	p := &cfg.Protection
+	p.RateLimit = config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10, WindowSize: config.Duration(time.Minute)}
+	p.IPBlacklist.Enabled = true
+	p.IPBlacklist.AutoBlacklistThreshold = 100
+	p.IPBlacklist.BlacklistDuration = config.Duration(time.Hour)
+	p.IPWhitelist.Enabled = true
+	p.RequestFilter.Enabled = true
+	p.RequestFilter.MaxRequestSize = 1 << 20
+	p.RequestFilter.BlockedUserAgents = []string{"curl", "wget", "python-requests"}
+	p.Botnet = config.BotnetConfig{DetectionThreshold: 0.7, AutoBlacklistConfidence: 0.9}
+	p.Monitoring = config.MonitoringConfig{Enabled: true, AlertThreshold: 1000, SampleRate: 0.1}
+	p.HealthCheck = config.HealthCheckConfig{Enabled: true, Timeout: config.Duration(5 * time.Second), CheckInterval: config.Duration(30 * time.Second)}
+	return cfg
+}
+
+// New starts a server for the duration of the test. It is closed when the
+// test and its subtests complete.
+func New(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if cfg.Server.Mode != "" {
+		gin.SetMode(cfg.Server.Mode)
+	}
+
+	s := &Server{Config: cfg, t: t}
+
+	backend := opts.Backend
+	if backend == nil {
+		backend = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "OK")
+		})
+	}
+	s.Backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.hits, 1)
+		backend.ServeHTTP(w, r)
+	}))
+
+	service, err := ddos.NewProtectionService(cfg)
+	if err != nil {
+		s.Backend.Close()
+		t.Fatalf("testserver: creating protection service: %v", err)
+	}
+	s.Service = service
+
+	target, _ := url.Parse(s.Backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(service.ProtectionMiddleware())
+	router.NoRoute(gin.WrapH(proxy))
+
+	s.frontend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := r.Header.Get(clientIPHeader); ip != "" {
+			r.Header.Del(clientIPHeader)
+			r.RemoteAddr = net.JoinHostPort(ip, "40000")
+		}
+		router.ServeHTTP(w, r)
+	}))
+	s.URL = s.frontend.URL
+
+	if err := service.Start(context.Background()); err != nil {
+		s.frontend.Close()
+		s.Backend.Close()
+		t.Fatalf("testserver: starting protection service: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the protection service and both servers
+func (s *Server) Close() {
+	s.frontend.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Service.Stop(ctx); err != nil {
+		s.t.Logf("testserver: stopping protection service: %v", err)
+	}
+
+	s.Backend.Close()
+}
+
+// BackendHits returns the number of requests that reached the backend
+func (s *Server) BackendHits() int {
+	return int(atomic.LoadInt64(&s.hits))
+}
+
+// Ban blacklists an IP or CIDR range for duration
+func (s *Server) Ban(target string, duration time.Duration) {
+	s.t.Helper()
+
+	origin := blacklist.Origin{Source: blacklist.SourceManual, Reason: "testserver"}
+	if err := s.Service.BlacklistIP(context.Background(), target, duration, origin); err != nil {
+		s.t.Fatalf("testserver: banning %s: %v", target, err)
+	}
+}
+
+// IsBanned reports whether an IP is blacklisted, on its own or by a range
+func (s *Server) IsBanned(ip string) bool {
+	s.t.Helper()
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		s.t.Fatalf("testserver: invalid IP %s: %v", ip, err)
+	}
+	page := s.Service.ListBlacklist(blacklist.ListQuery{Net: netip.PrefixFrom(addr, addr.BitLen())})
+	return page.Total > 0
+}
+
+// Client sends requests to the server as a browser at ip
+func (s *Server) Client(ip string) *Client {
+	header := make(http.Header)
+	header.Set("User-Agent", browserUserAgent)
+	header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	header.Set("Accept-Language", "en-US,en;q=0.9")
+
+	return &Client{
+		IP:     ip,
+		Header: header,
+		server: s,
+		http: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Client is a simulated client. Header is sent with every request.
+type Client struct {
+	IP     string
+	Header http.Header
+
+	server *Server
+	http   *http.Client
+}
+
+// Response is a response read in full. Code is the error code the
+// protection service gave for a rejection, if any.
+type Response struct {
+	Status int
+	Code   string
+	Header http.Header
+	Body   []byte
+}
+
+// Get requests path
+func (c *Client) Get(path string) Response {
+	return c.Do(http.MethodGet, path, nil)
+}
+
+// Do sends a request with the client's IP and headers
+func (c *Client) Do(method, path string, body io.Reader) Response {
+	t := c.server.t
+	t.Helper()
+
+	req, err := http.NewRequest(method, c.server.URL+path, body)
+	if err != nil {
+		t.Fatalf("testserver: %s %s: %v", method, path, err)
+	}
+	for k, values := range c.Header {
+		req.Header[k] = append([]string(nil), values...)
+	}
+	req.Header.Set(clientIPHeader, c.IP)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		t.Fatalf("testserver: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("testserver: reading %s %s: %v", method, path, err)
+	}
+
+	r := Response{Status: resp.StatusCode, Header: resp.Header, Body: data}
+	if resp.StatusCode >= 400 && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var rejection struct {
+			Code string `json:"code"`
+		}
+		if json.Unmarshal(data, &rejection) == nil {
+			r.Code = rejection.Code
+		}
+	}
+	return r
+}
+
+// Repeat requests path n times and counts the responses by status
+func (c *Client) Repeat(path string, n int) map[int]int {
+	c.server.t.Helper()
+
+	statuses := make(map[int]int)
+	for i := 0; i < n; i++ {
+		statuses[c.Get(path).Status]++
+	}
+	return statuses
+}
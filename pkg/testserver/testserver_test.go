@@ -0,0 +1,43 @@
+package testserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	s := New(t, Options{})
+
+	alice := s.Client("192.0.2.10")
+	if resp := alice.Get("/products"); resp.Status != http.StatusOK || string(resp.Body) != "OK" {
+		t.Fatalf("request not proxied: %d %q", resp.Status, resp.Body)
+	}
+	if s.BackendHits() != 1 {
+		t.Errorf("backend hits = %d, want 1", s.BackendHits())
+	}
+
+	// Bans apply per client IP
+	s.Ban("198.51.100.0/24", time.Hour)
+	if !s.IsBanned("198.51.100.7") {
+		t.Error("IsBanned does not see the range ban")
+	}
+	if resp := s.Client("198.51.100.7").Get("/products"); resp.Status != http.StatusForbidden || resp.Code != "BLOCKED_IP" {
+		t.Errorf("banned client got %d %s", resp.Status, resp.Code)
+	}
+	if resp := alice.Get("/products"); resp.Status != http.StatusOK {
+		t.Errorf("unbanned client got %d", resp.Status)
+	}
+
+	scraper := s.Client("192.0.2.20")
+	scraper.Header.Set("User-Agent", "curl/8.0")
+	if resp := scraper.Get("/"); resp.Status == http.StatusOK {
+		t.Error("blocked user agent reached the backend")
+	}
+
+	// A flood from one client runs into the rate limit
+	statuses := s.Client("192.0.2.30").Repeat("/search", 30)
+	if statuses[http.StatusTooManyRequests] == 0 {
+		t.Errorf("flood was not rate limited: %v", statuses)
+	}
+}